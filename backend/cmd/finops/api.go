@@ -8,7 +8,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/pickeringtech/FinOpsAggregator/internal/allocate"
 	"github.com/pickeringtech/FinOpsAggregator/internal/api"
 	"github.com/pickeringtech/FinOpsAggregator/internal/demo"
 	"github.com/pickeringtech/FinOpsAggregator/internal/store"
@@ -39,6 +38,7 @@ var apiCmd = &cobra.Command{
 			ReadTimeout:  cfg.API.ReadTimeout,
 			WriteTimeout: cfg.API.WriteTimeout,
 			IdleTimeout:  cfg.API.IdleTimeout,
+			BaseCurrency: cfg.Compute.BaseCurrency,
 		}
 
 		// Create and start server
@@ -127,7 +127,7 @@ func seedDemoData() error {
 	startDate := now.AddDate(0, -12, 0)
 	endDate := now.AddDate(0, 12, 0)
 
-	engine := allocate.NewEngine(st)
+	engine := newAllocationEngine(st, false)
 	result, err := engine.AllocateForPeriod(ctx, startDate, endDate, cfg.Compute.ActiveDimensions)
 	if err != nil {
 		return fmt.Errorf("failed to run allocation after seeding demo data: %w", err)
@@ -4,6 +4,10 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,6 +19,7 @@ import (
 	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
 	"github.com/pickeringtech/FinOpsAggregator/internal/ingestion"
 	"github.com/pickeringtech/FinOpsAggregator/internal/logging"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
 	"github.com/pickeringtech/FinOpsAggregator/internal/store"
 	"github.com/pickeringtech/FinOpsAggregator/internal/tui"
 	"github.com/spf13/cobra"
@@ -28,7 +33,12 @@ var (
 )
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	// Interrupting a command cancels its context, so a run in progress is marked
+	// cancelled and can be resumed rather than left running
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -88,7 +98,7 @@ var importCmd = &cobra.Command{
 	Short: "Import data from various sources",
 }
 
-	func runAllocation(ctx context.Context, st *store.Store, from, to string) error {
+	func runAllocation(ctx context.Context, st *store.Store, from, to string, incremental bool) error {
 		startDate, err := time.Parse("2006-01-02", from)
 		if err != nil {
 			return fmt.Errorf("invalid start date format: %w", err)
@@ -101,22 +111,57 @@ var importCmd = &cobra.Command{
 
 		fmt.Printf("Running allocation from %s to %s\n", from, to)
 
-		engine := allocate.NewEngine(st)
+		engine := newAllocationEngine(st, incremental)
 		result, err := engine.AllocateForPeriod(ctx, startDate, endDate, cfg.Compute.ActiveDimensions)
 		if err != nil {
 			return fmt.Errorf("allocation failed: %w", err)
 		}
 
-		fmt.Printf("Allocation completed successfully!\n")
-		fmt.Printf("Run ID: %s\n", result.RunID)
-		fmt.Printf("Processed %d days\n", result.Summary.ProcessedDays)
-		fmt.Printf("Total allocations: %d\n", len(result.Allocations))
-		fmt.Printf("Total contributions: %d\n", len(result.Contributions))
-		fmt.Printf("Processing time: %v\n", result.Summary.ProcessingTime)
-
+		printAllocationResult(result, incremental)
 		return nil
 	}
 
+// newAllocationEngine creates an allocation engine from the compute and jobs settings
+func newAllocationEngine(st *store.Store, incremental bool) *allocate.Engine {
+	precision := cfg.Compute.PrecisionFor(cfg.Compute.BaseCurrency)
+	return allocate.NewEngine(st, &allocate.EngineConfig{
+		Concurrency:              cfg.Jobs.Concurrency,
+		Incremental:              incremental,
+		RemainderPolicy:          allocate.RemainderPolicy(cfg.Compute.RemainderPolicy),
+		DisableZeroUsageFallback: !cfg.Compute.ZeroUsageFallback,
+		RoundingPlaces:           &precision,
+		Timeout:                  cfg.Compute.RunTimeout,
+		HeartbeatInterval:        cfg.Compute.HeartbeatInterval,
+		StaleRunAfter:            cfg.Compute.StaleRunAfter,
+		BaseCurrency:             cfg.Compute.BaseCurrency,
+	})
+}
+
+// printAllocationResult prints the summary of a finished allocation run
+func printAllocationResult(result *models.AllocationOutput, incremental bool) {
+	fmt.Printf("Allocation completed successfully!\n")
+	fmt.Printf("Run ID: %s\n", result.RunID)
+	if result.Summary.ResumedFrom != nil {
+		fmt.Printf("Resumed from %s\n", result.Summary.ResumedFrom.Format("2006-01-02"))
+	}
+	fmt.Printf("Processed %d days\n", result.Summary.ProcessedDays)
+	if incremental {
+		fmt.Printf("Copied forward %d unchanged days\n", result.Summary.CopiedDays)
+	}
+	fmt.Printf("Total allocations: %d\n", result.Summary.AllocationCount)
+	fmt.Printf("Total contributions: %d\n", result.Summary.ContributionCount)
+	fmt.Printf("Total lineage paths: %d\n", result.Summary.LineageCount)
+	for dim, amount := range result.Summary.TotalUnallocated {
+		if amount.IsPositive() {
+			fmt.Printf("Unallocated %s: %s (see unallocated_results_by_dimension)\n", dim, amount.StringFixed(2))
+		}
+	}
+	if result.Summary.InvariantViolations > 0 {
+		fmt.Printf("Invariant violations: %d (see allocation_invariant_reports)\n", result.Summary.InvariantViolations)
+	}
+	fmt.Printf("Processing time: %v\n", result.Summary.ProcessingTime)
+}
+
 
 var graphCmd = &cobra.Command{
 	Use:   "graph",
@@ -129,7 +174,34 @@ var allocateCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		from, _ := cmd.Flags().GetString("from")
 		to, _ := cmd.Flags().GetString("to")
-		return runAllocation(cmd.Context(), st, from, to)
+		incremental, _ := cmd.Flags().GetBool("incremental")
+		return runAllocation(cmd.Context(), st, from, to, incremental)
+	},
+}
+
+var allocateResumeCmd = &cobra.Command{
+	Use:   "resume [run-id]",
+	Short: "Resume a failed or cancelled allocation run",
+	Long: `Resume a failed or cancelled allocation run from the day after its last
+checkpoint. Days that were fully saved are kept; the remaining days of the run's
+window are allocated again using the dimensions the run was started with.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		runID, err := uuid.Parse(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid run ID: %w", err)
+		}
+		incremental, _ := cmd.Flags().GetBool("incremental")
+
+		fmt.Printf("Resuming allocation run %s\n", runID)
+
+		result, err := newAllocationEngine(st, incremental).ResumeRun(cmd.Context(), runID)
+		if err != nil {
+			return fmt.Errorf("resume failed: %w", err)
+		}
+
+		printAllocationResult(result, incremental)
+		return nil
 	},
 }
 
@@ -168,18 +240,36 @@ var demoCmd = &cobra.Command{
 
 var importCostsCmd = &cobra.Command{
 	Use:   "costs [file]",
-	Short: "Import cost data from AWS CUR CSV file",
-	Long: `Import cost data from an AWS Cost and Usage Report (CUR) CSV file.
-
-The CSV file should contain standard AWS CUR columns including:
-  - lineItem/UsageStartDate
-  - lineItem/UnblendedCost
-  - lineItem/ProductCode
+	Short: "Import cost data from an AWS CUR report or manifest",
+	Long: `Import cost data from an AWS Cost and Usage Report (CUR) file.
+
+Legacy CUR and CUR 2.0 / Data Exports reports are accepted as CSV or Parquet,
+optionally gzip compressed or in a zip archive. The report should contain
+standard AWS CUR columns including:
+  - lineItem/UsageStartDate (line_item_usage_start_date)
+  - lineItem/UnblendedCost (line_item_unblended_cost)
+  - lineItem/ProductCode (line_item_product_code)
   - resourceTags/user:Product (optional, for mapping to products)
   - resourceTags/user:Service (optional, for mapping to services)
 
+Given a report's Manifest.json, every file it lists is imported as one batch,
+so the billing period is imported in full or not at all. Files are looked up
+beneath the directories above the manifest.
+
+Line items are mapped to nodes by the Product, Service or CostCenter tag, or
+else the AWS product code. Use --rules (or ingestion.mapping_rules in the
+config) to map them with a YAML file of ordered rules matching tags, columns,
+account IDs and product codes instead. Use --explain to print which rule maps
+each line item, and to which node and dimension, without importing anything.
+
 Use --allocate to automatically run cost allocation after import.
-Use --create-nodes to automatically create missing nodes from AWS product codes.`,
+Use --create-nodes to automatically create missing nodes from AWS product codes.
+Use --cost-basis to choose how Savings Plans and Reserved Instances are reflected:
+  - unblended (default): what was billed each day, including upfront fees
+  - amortised: commitment fees spread over the usage they cover
+  - net_amortised: amortised cost after discounts
+Amortised costs are recorded under dimensions suffixed with the basis
+(e.g. box_usage_amortised), so each basis can be imported side by side.`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		filePath := args[0]
@@ -187,67 +277,59 @@ Use --create-nodes to automatically create missing nodes from AWS product codes.
 		createNodes, _ := cmd.Flags().GetBool("create-nodes")
 		from, _ := cmd.Flags().GetString("from")
 		to, _ := cmd.Flags().GetString("to")
+		costBasisName, _ := cmd.Flags().GetString("cost-basis")
+		rulesPath, _ := cmd.Flags().GetString("rules")
+		explain, _ := cmd.Flags().GetBool("explain")
 
-		// Create progress channel for reporting
-		progressChan := make(chan ingestion.IngestionProgress, 100)
-		defer close(progressChan)
-
-		// Start progress reporter goroutine
-		done := make(chan struct{})
-		go func() {
-			for progress := range progressChan {
-				if progress.Error != nil {
-					fmt.Printf("  ERROR: %v\n", progress.Error)
-				} else if progress.Message != "" {
-					fmt.Printf("  %s\n", progress.Message)
-				}
-			}
-			close(done)
-		}()
-
-		fmt.Printf("Importing AWS CUR costs from %s\n", filePath)
-		fmt.Println("----------------------------------------")
+		costBasis, err := ingestion.ParseCostBasis(costBasisName)
+		if err != nil {
+			return err
+		}
 
-		// Create ingester
-		ingester := ingestion.NewAWSCURIngester(st, &ingestion.AWSCURConfig{
-			BatchSize:          1000,
-			CreateMissingNodes: createNodes,
-			ProgressChan:       progressChan,
-		})
+		if rulesPath == "" {
+			rulesPath = cfg.Ingestion.MappingRules
+		}
+		var rules *ingestion.MappingRuleSet
+		if rulesPath != "" {
+			if rules, err = ingestion.LoadMappingRules(rulesPath); err != nil {
+				return err
+			}
+		}
 
-		// Run ingestion
 		ctx := cmd.Context()
-		result, err := ingester.IngestFile(ctx, filePath)
-		if err != nil {
-			return fmt.Errorf("ingestion failed: %w", err)
+		if explain {
+			ingester := ingestion.NewAWSCURIngester(st, &ingestion.AWSCURConfig{
+				CreateMissingNodes: createNodes,
+				CostBasis:          costBasis,
+				MappingRules:       rules,
+			})
+			return runExplain(ctx, ingester, filePath)
 		}
 
-		// Wait for progress reporter to finish
-		<-done
-
-		fmt.Println("----------------------------------------")
-		fmt.Printf("Ingestion completed!\n")
-		fmt.Printf("  Records processed: %d\n", result.RecordsProcessed)
-		fmt.Printf("  Records inserted:  %d\n", result.RecordsInserted)
-		fmt.Printf("  Records skipped:   %d\n", result.RecordsSkipped)
-		fmt.Printf("  Duration:          %v\n", result.Duration)
+		fmt.Printf("Importing AWS CUR costs from %s\n", filePath)
 
-		if len(result.Errors) > 0 {
-			fmt.Printf("  Errors (%d):\n", len(result.Errors))
-			for i, e := range result.Errors {
-				if i >= 5 {
-					fmt.Printf("    ... and %d more errors\n", len(result.Errors)-5)
-					break
-				}
-				fmt.Printf("    - %s\n", e)
+		err = runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			ingester := ingestion.NewAWSCURIngester(st, &ingestion.AWSCURConfig{
+				BatchSize:          1000,
+				CreateMissingNodes: createNodes,
+				ProgressChan:       progressChan,
+				CostBasis:          costBasis,
+				MappingRules:       rules,
+			})
+			if ingestion.IsCURManifest(filePath) {
+				return ingester.IngestManifestFile(ctx, filePath)
 			}
+			return ingester.IngestFile(ctx, filePath)
+		})
+		if err != nil {
+			return err
 		}
 
 		// Run allocation if requested
 		if allocateAfter {
 			fmt.Println()
 			fmt.Println("Running allocation...")
-			if err := runAllocation(ctx, st, from, to); err != nil {
+			if err := runAllocation(ctx, st, from, to, false); err != nil {
 				return fmt.Errorf("allocation failed: %w", err)
 			}
 		}
@@ -256,26 +338,547 @@ Use --create-nodes to automatically create missing nodes from AWS product codes.
 	},
 }
 
+var importAzureCmd = &cobra.Command{
+	Use:   "azure [file]",
+	Short: "Import cost data from an Azure Cost Management export",
+	Long: `Import cost data from an Azure Cost Management cost details export (CSV).
+
+Both actual and amortized cost exports are supported. Rows are mapped to the
+node named by their Product, Service or CostCenter tag, in that order, or
+else to a node named after their meter category (e.g. azure_virtual_machines).
+Costs are recorded under a dimension named after their meter.
+
+Use --create-nodes to create nodes that do not exist yet.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := args[0]
+		createNodes, _ := cmd.Flags().GetBool("create-nodes")
+
+		fmt.Printf("Importing Azure costs from %s\n", filePath)
+
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			ingester := ingestion.NewAzureCostExportIngester(st, &ingestion.AzureCostExportConfig{
+				BatchSize:          1000,
+				CreateMissingNodes: createNodes,
+				ProgressChan:       progressChan,
+			})
+			return ingester.IngestFile(cmd.Context(), filePath)
+		})
+	},
+}
+
+var importGCPCmd = &cobra.Command{
+	Use:   "gcp [file]",
+	Short: "Import cost data from a GCP billing export",
+	Long: `Import cost data from the GCP Cloud Billing export to BigQuery, saved as
+newline delimited JSON (.json, .jsonl) or CSV (.csv).
+
+Costs are imported net of credits. Rows are mapped to the node named by their
+resource's product, service or cost_center label, then their project's, or
+else to a node named after their service (e.g. gcp_compute_engine). Costs are
+recorded under a dimension named after their SKU.
+
+CSV exports name nested fields with dots or underscores (service.description
+or service_description) and hold labels and credits as JSON.
+
+Use --create-nodes to create nodes that do not exist yet.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := args[0]
+		createNodes, _ := cmd.Flags().GetBool("create-nodes")
+
+		fmt.Printf("Importing GCP costs from %s\n", filePath)
+
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			ingester := ingestion.NewGCPBillingIngester(st, &ingestion.GCPBillingConfig{
+				BatchSize:          1000,
+				CreateMissingNodes: createNodes,
+				ProgressChan:       progressChan,
+			})
+			return ingester.IngestFile(cmd.Context(), filePath)
+		})
+	},
+}
+
+var importFOCUSCmd = &cobra.Command{
+	Use:   "focus [file]",
+	Short: "Import cost data in the FOCUS schema",
+	Long: `Import cost data from a FinOps Open Cost and Usage Specification (FOCUS)
+CSV file, as exported by AWS, Azure, GCP and other providers.
+
+Rows are mapped to the node named by their product, service or cost_center
+tag, or else to a node named after their provider and service (e.g.
+aws_amazon_elastic_compute_cloud). Costs are recorded under a dimension named
+after their service.
+
+Use --cost-basis to choose the cost column:
+  - unblended (default): BilledCost, what was invoiced
+  - amortised or net_amortised: EffectiveCost, with commitment purchases spread
+    over the usage they cover; recorded under dimensions suffixed with the basis
+Use --create-nodes to create nodes that do not exist yet.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := args[0]
+		createNodes, _ := cmd.Flags().GetBool("create-nodes")
+		costBasisName, _ := cmd.Flags().GetString("cost-basis")
+
+		costBasis, err := ingestion.ParseCostBasis(costBasisName)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Importing FOCUS costs from %s\n", filePath)
+
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			ingester := ingestion.NewFOCUSIngester(st, &ingestion.FOCUSConfig{
+				BatchSize:          1000,
+				CreateMissingNodes: createNodes,
+				ProgressChan:       progressChan,
+				CostBasis:          costBasis,
+			})
+			return ingester.IngestFile(cmd.Context(), filePath)
+		})
+	},
+}
+
+var importPrometheusCmd = &cobra.Command{
+	Use:   "prometheus [file]",
+	Short: "Import usage from Prometheus",
+	Long: `Import usage from the result of a Prometheus range query, either saved
+from the /api/v1/query_range API to a file or queried from the server given by
+--url (default ingestion.prometheus_url).
+
+When querying, --query is run from the start of --from to the end of --to at
+--step resolution. Choose a query and step that suit how samples are
+aggregated per day, e.g. increase(http_requests_total[1h]) with a 1h step,
+summed.
+
+Series are mapped to existing nodes by rules matching their labels (--rules,
+default ingestion.prometheus_rules; see docs/prometheus-rules.yaml). Without
+rules, series go to the node named by their service, app or job label, and are
+summed per day under their metric name. Usage keeps the series' labels.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		url, _ := cmd.Flags().GetString("url")
+		query, _ := cmd.Flags().GetString("query")
+		fromStr, _ := cmd.Flags().GetString("from")
+		toStr, _ := cmd.Flags().GetString("to")
+		step, _ := cmd.Flags().GetDuration("step")
+		rulesPath, _ := cmd.Flags().GetString("rules")
+
+		if url == "" {
+			url = cfg.Ingestion.PrometheusURL
+		}
+		if rulesPath == "" {
+			rulesPath = cfg.Ingestion.PrometheusRules
+		}
+		var rules *ingestion.PrometheusRuleSet
+		if rulesPath != "" {
+			var err error
+			if rules, err = ingestion.LoadPrometheusRules(rulesPath); err != nil {
+				return err
+			}
+		}
+
+		ingester := ingestion.NewPrometheusIngester(st, &ingestion.PrometheusConfig{
+			URL:         url,
+			BearerToken: cfg.Ingestion.PrometheusToken,
+			Rules:       rules,
+		})
+
+		if len(args) == 1 {
+			fmt.Printf("Importing Prometheus usage from %s\n", args[0])
+			return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+				return ingester.IngestFile(cmd.Context(), args[0])
+			})
+		}
+
+		if query == "" {
+			return fmt.Errorf("a file or --query is required")
+		}
+		from, err := time.Parse("2006-01-02", fromStr)
+		if err != nil {
+			return fmt.Errorf("invalid from date: %w", err)
+		}
+		to, err := time.Parse("2006-01-02", toStr)
+		if err != nil {
+			return fmt.Errorf("invalid to date: %w", err)
+		}
+
+		fmt.Printf("Importing Prometheus usage from %s for %s to %s\n", url, fromStr, toStr)
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			return ingester.IngestQuery(cmd.Context(), ingestion.PrometheusQuery{
+				Query: query,
+				Start: from,
+				// The last sample is taken within the to date
+				End:  to.AddDate(0, 0, 1).Add(-time.Second),
+				Step: step,
+			})
+		})
+	},
+}
+
+var importDynatraceCmd = &cobra.Command{
+	Use:   "dynatrace [file]",
+	Short: "Import usage from a Dynatrace metrics export",
+	Long: `Import usage from a Dynatrace metrics export (JSON) against the node given
+by --node, as a name or ID.
+
+Metrics are mapped to usage metrics by the default mappings, added to or
+replaced by a YAML file of metric mappings (--metrics, default
+ingestion.dynatrace_metrics; see docs/dynatrace-metrics.yaml) naming each
+metric's usage metric, unit and how its datapoints are aggregated per day.
+Metrics with no mapping are skipped. Usage keeps the datapoints' dimensions as
+labels.
+
+Use --hourly to also record usage per hour, for the peak_coincident strategy,
+and --raw-samples to keep every datapoint at its exported resolution.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := args[0]
+		nodeStr, _ := cmd.Flags().GetString("node")
+		metricsPath, _ := cmd.Flags().GetString("metrics")
+		hourly, _ := cmd.Flags().GetBool("hourly")
+		rawSamples, _ := cmd.Flags().GetBool("raw-samples")
+
+		nodeID, err := uuid.Parse(nodeStr)
+		if err != nil {
+			node, err := st.Nodes.GetByName(cmd.Context(), nodeStr)
+			if err != nil {
+				return fmt.Errorf("invalid node ID or name: %s", nodeStr)
+			}
+			nodeID = node.ID
+		}
+
+		ingester := ingestion.NewDynatraceIngester(st)
+		if metricsPath == "" {
+			metricsPath = cfg.Ingestion.DynatraceMetrics
+		}
+		if metricsPath != "" {
+			mappings, err := ingestion.LoadDynatraceMetricMappings(metricsPath)
+			if err != nil {
+				return err
+			}
+			if err := ingester.SetMetricMappings(mappings); err != nil {
+				return err
+			}
+		}
+		if hourly {
+			ingester.EnableHourlyUsage()
+		}
+		if rawSamples {
+			ingester.EnableRawSamples()
+		}
+
+		fmt.Printf("Importing Dynatrace usage from %s\n", filePath)
+
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			return ingester.IngestFile(cmd.Context(), filePath, nodeID)
+		})
+	},
+}
+
+var importKubernetesCmd = &cobra.Command{
+	Use:   "kubernetes [file]",
+	Short: "Import Kubernetes resource requests and usage",
+	Long: `Import CPU and memory requests and usage per cluster and namespace from an
+OpenCost allocation export (JSON) or a kube-state-metrics style CSV (.csv).
+
+OpenCost exports are responses of /allocation queried with accumulate=false,
+a step such as 1h, aggregate=namespace and includeIdle=true. CSV exports have a
+row per namespace, or pod, and window with the columns window_start, cluster,
+namespace, cpu_request_cores, cpu_usage_cores, memory_request_bytes and
+memory_usage_bytes, and optionally window_end or minutes (an hour by default)
+and label_<name> columns. Rows without a namespace give the cluster's
+cpu_capacity_cores and memory_capacity_bytes, from which idle capacity is
+derived when the export does not report it.
+
+Usage is recorded per day as cpu_request_core_hours, cpu_usage_core_hours,
+memory_request_gb_hours and memory_usage_gb_hours against the namespace's node,
+and unrequested capacity as requests against the cluster's idle node. Node
+names may use {cluster}, {namespace} and {label.<name>} with |lower or
+|normalise.
+
+Use --create-nodes to create cluster, namespace and idle nodes that do not
+exist yet, and --create-edges to add a proportional_on edge from each cluster
+to its namespaces and idle capacity, so the cluster's cost is split by requests.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := args[0]
+		createNodes, _ := cmd.Flags().GetBool("create-nodes")
+		createEdges, _ := cmd.Flags().GetBool("create-edges")
+		edgeMetric, _ := cmd.Flags().GetString("edge-metric")
+		cluster, _ := cmd.Flags().GetString("cluster")
+		labels, _ := cmd.Flags().GetStringSlice("labels")
+		clusterNode, _ := cmd.Flags().GetString("cluster-node")
+		namespaceNode, _ := cmd.Flags().GetString("namespace-node")
+		idleNode, _ := cmd.Flags().GetString("idle-node")
+
+		ingester, err := ingestion.NewKubernetesIngester(st, &ingestion.KubernetesConfig{
+			ClusterNode:        clusterNode,
+			NamespaceNode:      namespaceNode,
+			IdleNode:           idleNode,
+			Cluster:            cluster,
+			Labels:             labels,
+			CreateMissingNodes: createNodes,
+			CreateEdges:        createEdges,
+			EdgeMetric:         edgeMetric,
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Importing Kubernetes usage from %s\n", filePath)
+
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			return ingester.IngestFile(cmd.Context(), filePath)
+		})
+	},
+}
+
+var importUsageCmd = &cobra.Command{
+	Use:   "usage [file]",
+	Short: "Import usage data from CSV",
+	Long: `Import usage metrics from a CSV file with a row per node, date and metric.
+
+Without --mapping, the CSV has the columns date (YYYY-MM-DD), node (the node's
+name), metric, value and unit. A YAML mapping file names other columns, the
+date format, a node ID column instead of names, a fixed metric or unit, label
+columns, the unit each metric must be in and how rows for the same node, date,
+metric and labels are aggregated (sum by default); see
+docs/usage-csv-mapping.yaml.
+
+Each metric must use a single unit: the mapping's, or else that of the
+metric's first row. Rows that cannot be parsed, name an unknown node or use
+another unit are rejected, and written with their line and error to
+--rejected (default <file>.rejected.csv).
+
+Use --create-nodes to create nodes, named in the CSV, that do not exist yet.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filePath := args[0]
+		mappingPath, _ := cmd.Flags().GetString("mapping")
+		createNodes, _ := cmd.Flags().GetBool("create-nodes")
+		rejectedPath, _ := cmd.Flags().GetString("rejected")
+
+		var mapping *ingestion.UsageCSVMapping
+		if mappingPath != "" {
+			var err error
+			if mapping, err = ingestion.LoadUsageCSVMapping(mappingPath); err != nil {
+				return err
+			}
+		}
+		if rejectedPath == "" {
+			rejectedPath = strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".rejected.csv"
+		}
+
+		fmt.Printf("Importing usage from %s\n", filePath)
+
+		return runIngestion(func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error) {
+			ingester, err := ingestion.NewUsageCSVIngester(st, &ingestion.UsageCSVConfig{
+				Mapping:            mapping,
+				BatchSize:          1000,
+				CreateMissingNodes: createNodes,
+				RejectedPath:       rejectedPath,
+				ProgressChan:       progressChan,
+			})
+			if err != nil {
+				return nil, err
+			}
+			return ingester.IngestFile(cmd.Context(), filePath)
+		})
+	},
+}
+
+// runIngestion runs an ingester, printing its progress and a summary of the result
+func runIngestion(ingest func(progressChan chan ingestion.IngestionProgress) (*ingestion.IngestionResult, error)) error {
+	// Create progress channel for reporting
+	progressChan := make(chan ingestion.IngestionProgress, 100)
+
+	// Start progress reporter goroutine
+	done := make(chan struct{})
+	go func() {
+		for progress := range progressChan {
+			if progress.Error != nil {
+				fmt.Printf("  ERROR: %v\n", progress.Error)
+			} else if progress.Message != "" {
+				fmt.Printf("  %s\n", progress.Message)
+			}
+		}
+		close(done)
+	}()
+
+	fmt.Println("----------------------------------------")
+
+	result, err := ingest(progressChan)
+
+	// Wait for progress reporter to finish
+	close(progressChan)
+	<-done
+
+	if err != nil {
+		return fmt.Errorf("ingestion failed: %w", err)
+	}
+
+	fmt.Println("----------------------------------------")
+	fmt.Printf("Ingestion completed!\n")
+	fmt.Printf("  Records processed: %d\n", result.RecordsProcessed)
+	fmt.Printf("  Records inserted:  %d\n", result.RecordsInserted)
+	fmt.Printf("  Records skipped:   %d\n", result.RecordsSkipped)
+	if result.RecordsReplaced > 0 {
+		fmt.Printf("  Records replaced:  %d\n", result.RecordsReplaced)
+	}
+	fmt.Printf("  Duration:          %v\n", result.Duration)
+	if result.AlreadyIngested {
+		fmt.Println("  Already ingested, nothing was written")
+	}
+	if len(result.UnmappedEntities) > 0 {
+		fmt.Printf("  Unmapped (%d):\n", len(result.UnmappedEntities))
+		for i, u := range result.UnmappedEntities {
+			if i >= 5 {
+				fmt.Printf("    ... and %d more\n", len(result.UnmappedEntities)-5)
+				break
+			}
+			fmt.Printf("    - %s\n", u)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		fmt.Printf("  Errors (%d):\n", len(result.Errors))
+		for i, e := range result.Errors {
+			if i >= 5 {
+				fmt.Printf("    ... and %d more errors\n", len(result.Errors)-5)
+				break
+			}
+			fmt.Printf("    - %s\n", e)
+		}
+	}
+	if result.RejectedFile != "" {
+		fmt.Printf("  Rejected rows written to %s\n", result.RejectedFile)
+	}
+
+	return nil
+}
+
+// runExplain prints how each line item of a CUR report or manifest would be
+// mapped to a node, and a count of line items by rule
+func runExplain(ctx context.Context, ingester *ingestion.AWSCURIngester, filePath string) error {
+	fmt.Printf("Explaining mapping of AWS CUR line items in %s (nothing is imported)\n", filePath)
+	fmt.Println("----------------------------------------")
+
+	var rules []string
+	counts := make(map[string]int)
+	err := ingester.ExplainFile(ctx, filePath, func(e ingestion.MappingExplanation) {
+		key := e.Rule
+		if e.Skipped != "" {
+			key = "(skipped)"
+			fmt.Printf("%s:%d  skipped: %s\n", e.File, e.Row, e.Skipped)
+		} else {
+			node := fmt.Sprintf("%s (%s)", e.Node, e.NodeType)
+			if e.NewNode {
+				node += ", new"
+			}
+			fmt.Printf("%s:%d  %s -> %s  %s  %s %s\n", e.File, e.Row, e.Rule, node, e.Dimension, e.Amount, e.Currency)
+		}
+		if _, ok := counts[key]; !ok {
+			rules = append(rules, key)
+		}
+		counts[key]++
+	})
+	if err != nil {
+		return fmt.Errorf("explain failed: %w", err)
+	}
+
+	fmt.Println("----------------------------------------")
+	fmt.Println("Line items by rule:")
+	for _, rule := range rules {
+		fmt.Printf("  %-24s %d\n", rule, counts[rule])
+	}
+	return nil
+}
+
+var importFXCmd = &cobra.Command{
+	Use:   "fx [file]",
+	Short: "Import FX rates from CSV",
+	Long: `Import foreign exchange rates from a CSV file with the columns:
+  - date: the date the rate applies from (YYYY-MM-DD)
+  - from: the currency converted from (e.g. EUR)
+  - to: the currency converted to (e.g. USD)
+  - rate: units of the to currency per unit of the from currency
+  - source (optional): where the rate came from
+
+Costs are converted to the base currency (compute.base_currency) during
+allocation, at the latest rate on or before each cost date. Rerun allocation
+for the affected period after importing rates.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fmt.Printf("Importing FX rates from %s\n", args[0])
+
+		result, err := ingestion.NewFXRateIngester(st).IngestFile(cmd.Context(), args[0])
+		if err != nil {
+			return fmt.Errorf("ingestion failed: %w", err)
+		}
+
+		fmt.Printf("  Rates processed: %d\n", result.RecordsProcessed)
+		fmt.Printf("  Rates imported:  %d\n", result.RecordsInserted)
+		fmt.Printf("  Rates skipped:   %d\n", result.RecordsSkipped)
+		for _, e := range result.Errors {
+			fmt.Printf("    - %s\n", e)
+		}
+
+		return nil
+	},
+}
+
 func init() {
 	// Import costs command flags
 	importCostsCmd.Flags().Bool("allocate", false, "Run cost allocation after import")
 	importCostsCmd.Flags().Bool("create-nodes", false, "Create missing nodes from AWS product codes")
+	importCostsCmd.Flags().String("cost-basis", "unblended", "Cost basis: unblended, amortised or net_amortised")
+	importCostsCmd.Flags().String("rules", "", "YAML file of rules mapping line items to nodes (default ingestion.mapping_rules)")
+	importCostsCmd.Flags().Bool("explain", false, "Print which rule maps each line item without importing")
 	importCostsCmd.Flags().String("from", time.Now().AddDate(0, 0, -30).Format("2006-01-02"), "Start date for allocation (YYYY-MM-DD)")
 	importCostsCmd.Flags().String("to", time.Now().Format("2006-01-02"), "End date for allocation (YYYY-MM-DD)")
 
+	importAzureCmd.Flags().Bool("create-nodes", false, "Create missing nodes from tags and meter categories")
+	importGCPCmd.Flags().Bool("create-nodes", false, "Create missing nodes from labels and services")
+	importFOCUSCmd.Flags().Bool("create-nodes", false, "Create missing nodes from tags and services")
+	importFOCUSCmd.Flags().String("cost-basis", "unblended", "Cost basis: unblended (BilledCost), amortised or net_amortised (EffectiveCost)")
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	importPrometheusCmd.Flags().String("url", "", "Prometheus server URL (default ingestion.prometheus_url)")
+	importPrometheusCmd.Flags().String("query", "", "PromQL range query to import")
+	importPrometheusCmd.Flags().String("from", yesterday, "First date to query (YYYY-MM-DD)")
+	importPrometheusCmd.Flags().String("to", yesterday, "Last date to query (YYYY-MM-DD)")
+	importPrometheusCmd.Flags().Duration("step", time.Hour, "Query resolution")
+	importPrometheusCmd.Flags().String("rules", "", "YAML file of rules mapping series to nodes (default ingestion.prometheus_rules)")
+	importDynatraceCmd.Flags().String("node", "", "Node the usage is recorded against, by name or ID")
+	importDynatraceCmd.Flags().String("metrics", "", "YAML file of metric mappings (default ingestion.dynatrace_metrics)")
+	importDynatraceCmd.Flags().Bool("hourly", false, "Also record usage per hour, for peak-based strategies")
+	importDynatraceCmd.Flags().Bool("raw-samples", false, "Also keep datapoints at their exported resolution")
+	importDynatraceCmd.MarkFlagRequired("node")
+	importKubernetesCmd.Flags().Bool("create-nodes", false, "Create missing cluster, namespace and idle nodes")
+	importKubernetesCmd.Flags().Bool("create-edges", false, "Create proportional_on edges from clusters to their namespaces and idle capacity")
+	importKubernetesCmd.Flags().String("edge-metric", ingestion.KubernetesMetricCPURequest, "Metric created edges split cluster cost on")
+	importKubernetesCmd.Flags().String("cluster", "", "Cluster of rows that name none")
+	importKubernetesCmd.Flags().StringSlice("labels", nil, "Kubernetes labels to keep on usage records")
+	importKubernetesCmd.Flags().String("cluster-node", "", "Cluster node name (default {cluster|normalise})")
+	importKubernetesCmd.Flags().String("namespace-node", "", "Namespace node name (default {cluster|normalise}_{namespace|normalise})")
+	importKubernetesCmd.Flags().String("idle-node", "", "Idle capacity node name (default {cluster|normalise}_idle)")
+	importUsageCmd.Flags().String("mapping", "", "YAML file mapping the CSV's columns to usage records")
+	importUsageCmd.Flags().Bool("create-nodes", false, "Create missing nodes named in the CSV")
+	importUsageCmd.Flags().String("rejected", "", "CSV file rejected rows are written to (default <file>.rejected.csv)")
+
 	// Import subcommands
 	importCmd.AddCommand(importCostsCmd)
-
-	importCmd.AddCommand(&cobra.Command{
-		Use:   "usage [file]",
-		Short: "Import usage data from CSV",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Printf("Importing usage from %s\n", args[0])
-			// TODO: Implement usage import
-			return nil
-		},
-	})
+	importCmd.AddCommand(importAzureCmd)
+	importCmd.AddCommand(importGCPCmd)
+	importCmd.AddCommand(importFOCUSCmd)
+	importCmd.AddCommand(importPrometheusCmd)
+	importCmd.AddCommand(importDynatraceCmd)
+	importCmd.AddCommand(importKubernetesCmd)
+	importCmd.AddCommand(importFXCmd)
+	importCmd.AddCommand(importUsageCmd)
 
 	// Graph subcommands
 	graphCmd.AddCommand(&cobra.Command{
@@ -318,9 +921,53 @@ func init() {
 	// Allocate flags
 	allocateCmd.Flags().String("from", "", "Start date (YYYY-MM-DD)")
 	allocateCmd.Flags().String("to", "", "End date (YYYY-MM-DD)")
+	allocateCmd.Flags().Bool("incremental", false, "Recompute only days whose inputs changed since the last completed run")
 	allocateCmd.MarkFlagRequired("from")
 	allocateCmd.MarkFlagRequired("to")
 
+	// Allocate subcommands
+	allocateResumeCmd.Flags().Bool("incremental", false, "Copy forward days whose inputs are unchanged since the last completed run")
+	allocateCmd.AddCommand(allocateResumeCmd)
+
+	allocateCmd.AddCommand(&cobra.Command{
+		Use:   "cancel [run-id]",
+		Short: "Cancel a pending or running allocation run",
+		Long: `Cancel a pending or running allocation run. The process running it stops at
+its next heartbeat and the run can later be continued with 'allocate resume'.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runID, err := uuid.Parse(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid run ID: %w", err)
+			}
+
+			notes := "cancelled from the command line"
+			if err := st.Runs.Cancel(cmd.Context(), runID, &notes); err != nil {
+				return err
+			}
+
+			fmt.Printf("Cancelled run %s\n", runID)
+			return nil
+		},
+	})
+
+	allocateCmd.AddCommand(&cobra.Command{
+		Use:   "reap",
+		Short: "Mark allocation runs without a recent heartbeat as failed",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ids, err := newAllocationEngine(st, false).ReapStaleRuns(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to reap stale runs: %w", err)
+			}
+
+			fmt.Printf("Marked %d stale runs as failed\n", len(ids))
+			for _, id := range ids {
+				fmt.Printf("  - %s\n", id)
+			}
+			return nil
+		},
+	})
+
 	// Export subcommands
 	chartCmd := &cobra.Command{
 		Use:   "chart",
@@ -506,16 +1153,40 @@ func init() {
 	}
 
 	// Add flags for CSV export
-	csvCmd.Flags().String("type", "products", "Export type: products, nodes, costs_by_type, recommendations")
+	csvCmd.Flags().String("type", "products", "Export type: products, nodes, costs_by_type, recommendations, focus")
 	csvCmd.Flags().String("node-type", "", "Node type filter (for nodes export)")
 	csvCmd.Flags().String("node-id", "", "Node ID filter (for recommendations export)")
 	csvCmd.Flags().String("start-date", "", "Start date (YYYY-MM-DD)")
 	csvCmd.Flags().String("end-date", "", "End date (YYYY-MM-DD)")
-	csvCmd.Flags().String("currency", "USD", "Currency")
+	csvCmd.Flags().String("currency", "", "Currency (defaults to compute.base_currency)")
 	csvCmd.Flags().String("out", "", "Output file (default: stdout)")
 
 	exportCmd.AddCommand(csvCmd)
 
+	focusCmd := &cobra.Command{
+		Use:   "focus",
+		Short: "Export allocated costs in the FOCUS schema",
+		Long: `Export the allocated costs of final cost centres (products with no
+downstream products) as FinOps Open Cost and Usage Specification (FOCUS) CSV.
+
+Each row is the cost allocated to one cost centre for one day and dimension:
+ResourceId and ResourceName identify the cost centre, ServiceName holds the
+dimension, and BilledCost and EffectiveCost the allocated cost. The custom
+columns x_DirectCost and x_IndirectCost split it into direct and allocated cost.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCSVExport(cmd, args)
+		},
+	}
+
+	focusCmd.Flags().String("type", "focus", "Export type")
+	focusCmd.Flags().MarkHidden("type")
+	focusCmd.Flags().String("start-date", "", "Start date (YYYY-MM-DD)")
+	focusCmd.Flags().String("end-date", "", "End date (YYYY-MM-DD)")
+	focusCmd.Flags().String("currency", "", "Currency (defaults to compute.base_currency)")
+	focusCmd.Flags().String("out", "", "Output file (default: stdout)")
+
+	exportCmd.AddCommand(focusCmd)
+
 	// Demo subcommands
 	demoCmd.AddCommand(&cobra.Command{
 		Use:   "seed",
@@ -554,7 +1225,7 @@ func init() {
 			from := now.AddDate(0, -12, 0).Format("2006-01-02")
 			to := now.AddDate(0, 12, 0).Format("2006-01-02")
 			fmt.Printf("Running demo allocation from %s to %s...\n", from, to)
-			return runAllocation(cmd.Context(), st, from, to)
+			return runAllocation(cmd.Context(), st, from, to, false)
 		},
 	})
 
@@ -627,7 +1298,7 @@ func runCSVExport(cmd *cobra.Command, args []string) error {
 	}
 
 	// Create API service
-	service := api.NewService(st)
+	service := api.NewService(st, cfg.Compute.BaseCurrency)
 
 	// Create cost attribution request
 	req := api.CostAttributionRequest{
@@ -664,8 +1335,10 @@ func runCSVExport(cmd *cobra.Command, args []string) error {
 		err = service.ExportRawCostsToCSV(ctx, req, nodeType, writer)
 	case "product_hierarchy":
 		err = service.ExportProductHierarchyToCSV(ctx, req, writer)
+	case "focus":
+		err = service.ExportFOCUS(ctx, req, writer)
 	default:
-		return fmt.Errorf("unsupported export type: %s. Supported types: products, nodes, costs_by_type, recommendations, detailed_costs, raw_costs, product_hierarchy", exportType)
+		return fmt.Errorf("unsupported export type: %s. Supported types: products, nodes, costs_by_type, recommendations, detailed_costs, raw_costs, product_hierarchy, focus", exportType)
 	}
 
 	if err != nil {
@@ -18,6 +18,8 @@ type AllocateRequest struct {
 	EndDate string `json:"end_date"`
 	// Dimensions is an optional list of dimensions to allocate (defaults to config)
 	Dimensions []string `json:"dimensions,omitempty"`
+	// Incremental recomputes only days whose inputs changed since the last completed run
+	Incremental bool `json:"incremental,omitempty"`
 }
 
 // AllocateResponse represents the response from an allocation operation.
@@ -27,6 +29,7 @@ type AllocateResponse struct {
 	ProcessedDays  int    `json:"processed_days,omitempty"`
 	Allocations    int    `json:"allocations,omitempty"`
 	Contributions  int    `json:"contributions,omitempty"`
+	Lineage        int    `json:"lineage,omitempty"`
 	ProcessingTime string `json:"processing_time,omitempty"`
 	Error          string `json:"error,omitempty"`
 }
@@ -62,7 +65,18 @@ func handleAllocate(ctx context.Context, request AllocateRequest) (LambdaRespons
 	}
 
 	// Create allocation engine
-	engine := allocate.NewEngine(st)
+	precision := cfg.Compute.PrecisionFor(cfg.Compute.BaseCurrency)
+	engine := allocate.NewEngine(st, &allocate.EngineConfig{
+		Concurrency:              cfg.Jobs.Concurrency,
+		Incremental:              request.Incremental,
+		RemainderPolicy:          allocate.RemainderPolicy(cfg.Compute.RemainderPolicy),
+		DisableZeroUsageFallback: !cfg.Compute.ZeroUsageFallback,
+		RoundingPlaces:           &precision,
+		Timeout:                  cfg.Compute.RunTimeout,
+		HeartbeatInterval:        cfg.Compute.HeartbeatInterval,
+		StaleRunAfter:            cfg.Compute.StaleRunAfter,
+		BaseCurrency:             cfg.Compute.BaseCurrency,
+	})
 
 	// Run allocation
 	result, err := engine.AllocateForPeriod(ctx, startDate, endDate, dimensions)
@@ -79,8 +93,9 @@ func handleAllocate(ctx context.Context, request AllocateRequest) (LambdaRespons
 	log.Info().
 		Str("run_id", result.RunID.String()).
 		Int("processed_days", result.Summary.ProcessedDays).
-		Int("allocations", len(result.Allocations)).
-		Int("contributions", len(result.Contributions)).
+		Int("allocations", result.Summary.AllocationCount).
+		Int("contributions", result.Summary.ContributionCount).
+		Int("lineage", result.Summary.LineageCount).
 		Dur("processing_time", result.Summary.ProcessingTime).
 		Msg("Allocation completed")
 
@@ -88,8 +103,9 @@ func handleAllocate(ctx context.Context, request AllocateRequest) (LambdaRespons
 		Success:        true,
 		RunID:          result.RunID.String(),
 		ProcessedDays:  result.Summary.ProcessedDays,
-		Allocations:    len(result.Allocations),
-		Contributions:  len(result.Contributions),
+		Allocations:    result.Summary.AllocationCount,
+		Contributions:  result.Summary.ContributionCount,
+		Lineage:        result.Summary.LineageCount,
 		ProcessingTime: result.Summary.ProcessingTime.String(),
 	}
 
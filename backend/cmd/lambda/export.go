@@ -35,7 +35,7 @@ type ExportRequest struct {
 	RunID string `json:"run_id,omitempty"`
 
 	// For CSV exports
-	CSVType  string `json:"csv_type,omitempty"`  // products, nodes, costs_by_type, recommendations
+	CSVType  string `json:"csv_type,omitempty"`  // products, nodes, costs_by_type, recommendations, focus
 	NodeType string `json:"node_type,omitempty"` // Node type filter (for nodes export)
 	Currency string `json:"currency,omitempty"`  // Currency (default: USD)
 
@@ -312,7 +312,7 @@ func exportCSV(ctx context.Context, request ExportRequest, blobStorage *storage.
 	// Set default currency
 	currency := request.Currency
 	if currency == "" {
-		currency = "USD"
+		currency = cfg.Compute.BaseCurrency
 	}
 
 	// Set default CSV type
@@ -331,7 +331,7 @@ func exportCSV(ctx context.Context, request ExportRequest, blobStorage *storage.
 	}
 
 	// Create API service
-	service := api.NewService(st)
+	service := api.NewService(st, cfg.Compute.BaseCurrency)
 
 	// Create cost attribution request
 	req := api.CostAttributionRequest{
@@ -368,8 +368,10 @@ func exportCSV(ctx context.Context, request ExportRequest, blobStorage *storage.
 		err = service.ExportRawCostsToCSV(ctx, req, request.NodeType, &buf)
 	case "product_hierarchy":
 		err = service.ExportProductHierarchyToCSV(ctx, req, &buf)
+	case "focus":
+		err = service.ExportFOCUS(ctx, req, &buf)
 	default:
-		return ExportResponse{}, fmt.Errorf("unsupported CSV type: %s. Supported: products, nodes, costs_by_type, recommendations, detailed_costs, raw_costs, product_hierarchy", csvType)
+		return ExportResponse{}, fmt.Errorf("unsupported CSV type: %s. Supported: products, nodes, costs_by_type, recommendations, detailed_costs, raw_costs, product_hierarchy, focus", csvType)
 	}
 
 	if err != nil {
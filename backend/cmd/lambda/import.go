@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/aws/aws-lambda-go/events"
@@ -27,7 +28,8 @@ func buildS3URL(bucket string) string {
 	return fmt.Sprintf("s3://%s?endpoint=%s&disable_https=true&s3ForcePathStyle=true", bucket, endpoint)
 }
 
-// handleImportAWSCUR handles S3 events for AWS CUR file imports.
+// handleImportAWSCUR handles S3 events for AWS CUR imports. The function is
+// triggered by a report's manifest, and imports every file it lists as one batch.
 func handleImportAWSCUR(ctx context.Context, s3Event events.S3Event) (LambdaResponse, error) {
 	log.Info().
 		Int("records", len(s3Event.Records)).
@@ -38,7 +40,8 @@ func handleImportAWSCUR(ctx context.Context, s3Event events.S3Event) (LambdaResp
 
 	for _, record := range s3Event.Records {
 		bucket := record.S3.Bucket.Name
-		key := record.S3.Object.Key
+		// Data Exports keys hold characters such as = that events URL-encode
+		key := record.S3.Object.URLDecodedKey
 
 		log.Info().
 			Str("bucket", bucket).
@@ -73,7 +76,7 @@ func handleImportAWSCUR(ctx context.Context, s3Event events.S3Event) (LambdaResp
 	return newSuccessResponse(string(body)), nil
 }
 
-// processAWSCURFile processes a single AWS CUR file from S3.
+// processAWSCURFile processes an AWS CUR manifest, or a single report file, from S3.
 func processAWSCURFile(ctx context.Context, bucket, key string) (*ingestion.IngestionResult, error) {
 	// Initialize storage to read from S3
 	storageURL := buildS3URL(bucket)
@@ -83,6 +86,112 @@ func processAWSCURFile(ctx context.Context, bucket, key string) (*ingestion.Inge
 	}
 	defer blobStorage.Close()
 
+	// Create ingester
+	createNodes := getEnvBool("FINOPS_IMPORT_CREATE_NODES")
+	costBasis, err := ingestion.ParseCostBasis(os.Getenv("FINOPS_IMPORT_COST_BASIS"))
+	if err != nil {
+		return nil, err
+	}
+	var rules *ingestion.MappingRuleSet
+	if cfg.Ingestion.MappingRules != "" {
+		if rules, err = ingestion.LoadMappingRules(cfg.Ingestion.MappingRules); err != nil {
+			return nil, err
+		}
+	}
+	ingester := ingestion.NewAWSCURIngester(st, &ingestion.AWSCURConfig{
+		BatchSize:          1000,
+		CreateMissingNodes: createNodes,
+		CostBasis:          costBasis,
+		MappingRules:       rules,
+	})
+
+	// Process the manifest's billing period, or the file
+	sourceName := fmt.Sprintf("s3://%s/%s", bucket, key)
+	var result *ingestion.IngestionResult
+	if ingestion.IsCURManifest(key) {
+		result, err = ingester.IngestManifest(ctx, blobStorage, key)
+	} else {
+		var reader io.ReadCloser
+		reader, err = blobStorage.ReadStream(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file from S3: %w", err)
+		}
+		defer reader.Close()
+		result, err = ingester.IngestReader(ctx, reader, sourceName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ingestion failed: %w", err)
+	}
+
+	log.Info().
+		Str("source", sourceName).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Int("replaced", result.RecordsReplaced).
+		Bool("already_ingested", result.AlreadyIngested).
+		Dur("duration", result.Duration).
+		Msg("AWS CUR file processed")
+
+	return result, nil
+}
+
+// handleImportAzure handles S3 events for Azure Cost Management export imports.
+func handleImportAzure(ctx context.Context, s3Event events.S3Event) (LambdaResponse, error) {
+	log.Info().
+		Int("records", len(s3Event.Records)).
+		Msg("Processing Azure cost export import event")
+
+	var totalProcessed, totalInserted, totalSkipped int
+	var errors []string
+
+	for _, record := range s3Event.Records {
+		bucket := record.S3.Bucket.Name
+		key := record.S3.Object.Key
+
+		log.Info().
+			Str("bucket", bucket).
+			Str("key", key).
+			Msg("Processing S3 object")
+
+		result, err := processAzureFile(ctx, bucket, key)
+		if err != nil {
+			log.Error().Err(err).
+				Str("bucket", bucket).
+				Str("key", key).
+				Msg("Failed to process Azure cost export file")
+			errors = append(errors, fmt.Sprintf("%s/%s: %v", bucket, key, err))
+			continue
+		}
+
+		totalProcessed += result.RecordsProcessed
+		totalInserted += result.RecordsInserted
+		totalSkipped += result.RecordsSkipped
+		errors = append(errors, result.Errors...)
+	}
+
+	response := map[string]interface{}{
+		"message":           "Azure cost export import completed",
+		"records_processed": totalProcessed,
+		"records_inserted":  totalInserted,
+		"records_skipped":   totalSkipped,
+		"errors":            errors,
+	}
+
+	body, _ := json.Marshal(response)
+	return newSuccessResponse(string(body)), nil
+}
+
+// processAzureFile processes a single Azure Cost Management export file from S3.
+func processAzureFile(ctx context.Context, bucket, key string) (*ingestion.IngestionResult, error) {
+	// Initialize storage to read from S3
+	storageURL := buildS3URL(bucket)
+	blobStorage, err := storage.NewBlobStorage(ctx, storageURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer blobStorage.Close()
+
 	// Read the file from S3
 	reader, err := blobStorage.ReadStream(ctx, key)
 	if err != nil {
@@ -91,10 +200,9 @@ func processAWSCURFile(ctx context.Context, bucket, key string) (*ingestion.Inge
 	defer reader.Close()
 
 	// Create ingester
-	createNodes := getEnvBool("FINOPS_IMPORT_CREATE_NODES")
-	ingester := ingestion.NewAWSCURIngester(st, &ingestion.AWSCURConfig{
+	ingester := ingestion.NewAzureCostExportIngester(st, &ingestion.AzureCostExportConfig{
 		BatchSize:          1000,
-		CreateMissingNodes: createNodes,
+		CreateMissingNodes: getEnvBool("FINOPS_IMPORT_CREATE_NODES"),
 	})
 
 	// Process the file
@@ -110,7 +218,190 @@ func processAWSCURFile(ctx context.Context, bucket, key string) (*ingestion.Inge
 		Int("inserted", result.RecordsInserted).
 		Int("skipped", result.RecordsSkipped).
 		Dur("duration", result.Duration).
-		Msg("AWS CUR file processed")
+		Msg("Azure cost export file processed")
+
+	return result, nil
+}
+
+// handleImportGCP handles S3 events for GCP billing export imports.
+func handleImportGCP(ctx context.Context, s3Event events.S3Event) (LambdaResponse, error) {
+	log.Info().
+		Int("records", len(s3Event.Records)).
+		Msg("Processing GCP billing export import event")
+
+	var totalProcessed, totalInserted, totalSkipped int
+	var errors []string
+
+	for _, record := range s3Event.Records {
+		bucket := record.S3.Bucket.Name
+		key := record.S3.Object.Key
+
+		log.Info().
+			Str("bucket", bucket).
+			Str("key", key).
+			Msg("Processing S3 object")
+
+		result, err := processGCPFile(ctx, bucket, key)
+		if err != nil {
+			log.Error().Err(err).
+				Str("bucket", bucket).
+				Str("key", key).
+				Msg("Failed to process GCP billing export file")
+			errors = append(errors, fmt.Sprintf("%s/%s: %v", bucket, key, err))
+			continue
+		}
+
+		totalProcessed += result.RecordsProcessed
+		totalInserted += result.RecordsInserted
+		totalSkipped += result.RecordsSkipped
+		errors = append(errors, result.Errors...)
+	}
+
+	response := map[string]interface{}{
+		"message":           "GCP billing export import completed",
+		"records_processed": totalProcessed,
+		"records_inserted":  totalInserted,
+		"records_skipped":   totalSkipped,
+		"errors":            errors,
+	}
+
+	body, _ := json.Marshal(response)
+	return newSuccessResponse(string(body)), nil
+}
+
+// processGCPFile processes a single GCP billing export file from S3.
+// The format is taken from the key's extension: .csv for CSV, otherwise JSONL.
+func processGCPFile(ctx context.Context, bucket, key string) (*ingestion.IngestionResult, error) {
+	// Initialize storage to read from S3
+	storageURL := buildS3URL(bucket)
+	blobStorage, err := storage.NewBlobStorage(ctx, storageURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer blobStorage.Close()
+
+	// Read the file from S3
+	reader, err := blobStorage.ReadStream(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file from S3: %w", err)
+	}
+	defer reader.Close()
+
+	// Create ingester
+	ingester := ingestion.NewGCPBillingIngester(st, &ingestion.GCPBillingConfig{
+		BatchSize:          1000,
+		CreateMissingNodes: getEnvBool("FINOPS_IMPORT_CREATE_NODES"),
+	})
+
+	// Process the file
+	format := ingestion.GCPFormatFromPath(key)
+	result, err := ingester.IngestReader(ctx, reader, format)
+	if err != nil {
+		return nil, fmt.Errorf("ingestion failed: %w", err)
+	}
+
+	log.Info().
+		Str("bucket", bucket).
+		Str("key", key).
+		Str("format", format).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("GCP billing export file processed")
+
+	return result, nil
+}
+
+// handleImportPrometheus handles S3 events for imports of Prometheus query
+// results saved from the query_range API.
+func handleImportPrometheus(ctx context.Context, s3Event events.S3Event) (LambdaResponse, error) {
+	log.Info().
+		Int("records", len(s3Event.Records)).
+		Msg("Processing Prometheus import event")
+
+	var totalProcessed, totalInserted, totalSkipped int
+	var errors, unmapped []string
+
+	for _, record := range s3Event.Records {
+		bucket := record.S3.Bucket.Name
+		key := record.S3.Object.Key
+
+		log.Info().
+			Str("bucket", bucket).
+			Str("key", key).
+			Msg("Processing S3 object")
+
+		result, err := processPrometheusFile(ctx, bucket, key)
+		if err != nil {
+			log.Error().Err(err).
+				Str("bucket", bucket).
+				Str("key", key).
+				Msg("Failed to process Prometheus file")
+			errors = append(errors, fmt.Sprintf("%s/%s: %v", bucket, key, err))
+			continue
+		}
+
+		totalProcessed += result.RecordsProcessed
+		totalInserted += result.RecordsInserted
+		totalSkipped += result.RecordsSkipped
+		errors = append(errors, result.Errors...)
+		unmapped = append(unmapped, result.UnmappedEntities...)
+	}
+
+	response := map[string]interface{}{
+		"message":           "Prometheus import completed",
+		"records_processed": totalProcessed,
+		"records_inserted":  totalInserted,
+		"records_skipped":   totalSkipped,
+		"unmapped_series":   unmapped,
+		"errors":            errors,
+	}
+
+	body, _ := json.Marshal(response)
+	return newSuccessResponse(string(body)), nil
+}
+
+// processPrometheusFile processes a single Prometheus query result file from S3.
+func processPrometheusFile(ctx context.Context, bucket, key string) (*ingestion.IngestionResult, error) {
+	// Initialize storage to read from S3
+	storageURL := buildS3URL(bucket)
+	blobStorage, err := storage.NewBlobStorage(ctx, storageURL, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize storage: %w", err)
+	}
+	defer blobStorage.Close()
+
+	// Read the file from S3
+	reader, err := blobStorage.ReadStream(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file from S3: %w", err)
+	}
+	defer reader.Close()
+
+	var rules *ingestion.PrometheusRuleSet
+	if cfg.Ingestion.PrometheusRules != "" {
+		if rules, err = ingestion.LoadPrometheusRules(cfg.Ingestion.PrometheusRules); err != nil {
+			return nil, err
+		}
+	}
+
+	// Create ingester and process the file
+	ingester := ingestion.NewPrometheusIngester(st, &ingestion.PrometheusConfig{Rules: rules})
+	result, err := ingester.IngestReader(ctx, reader)
+	if err != nil {
+		return nil, fmt.Errorf("ingestion failed: %w", err)
+	}
+
+	log.Info().
+		Str("bucket", bucket).
+		Str("key", key).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Strs("unmapped_series", result.UnmappedEntities).
+		Dur("duration", result.Duration).
+		Msg("Prometheus file processed")
 
 	return result, nil
 }
@@ -122,7 +413,7 @@ func handleImportDynatrace(ctx context.Context, s3Event events.S3Event) (LambdaR
 		Msg("Processing Dynatrace import event")
 
 	var totalProcessed, totalInserted, totalSkipped int
-	var errors []string
+	var errors, unmapped []string
 
 	for _, record := range s3Event.Records {
 		bucket := record.S3.Bucket.Name
@@ -147,6 +438,7 @@ func handleImportDynatrace(ctx context.Context, s3Event events.S3Event) (LambdaR
 		totalInserted += result.RecordsInserted
 		totalSkipped += result.RecordsSkipped
 		errors = append(errors, result.Errors...)
+		unmapped = append(unmapped, result.UnmappedEntities...)
 	}
 
 	response := map[string]interface{}{
@@ -154,6 +446,7 @@ func handleImportDynatrace(ctx context.Context, s3Event events.S3Event) (LambdaR
 		"records_processed": totalProcessed,
 		"records_inserted":  totalInserted,
 		"records_skipped":   totalSkipped,
+		"unmapped_entities": unmapped,
 		"errors":            errors,
 	}
 
@@ -180,11 +473,41 @@ func processDynatraceFile(ctx context.Context, bucket, key string) (*ingestion.I
 
 	// Create ingester
 	ingester := ingestion.NewDynatraceIngester(st)
+	if cfg.Lambda.DynatraceHourly {
+		ingester.EnableHourlyUsage()
+	}
+	if cfg.Lambda.DynatraceRawSamples {
+		ingester.EnableRawSamples()
+	}
+	if cfg.Ingestion.DynatraceMetrics != "" {
+		mappings, err := ingestion.LoadDynatraceMetricMappings(cfg.Ingestion.DynatraceMetrics)
+		if err != nil {
+			return nil, err
+		}
+		if err := ingester.SetMetricMappings(mappings); err != nil {
+			return nil, err
+		}
+	}
 
 	// For Dynatrace, we need a node ID. Try to get it from the filename or metadata.
 	// The filename convention is: dynatrace/<node-id>.json or dynatrace/<node-name>.json
 	nodeID, err := extractNodeIDFromKey(ctx, key)
-	if err != nil {
+	if cfg.Lambda.DynatraceEntityDimension != "" {
+		// Datapoints are routed by entity; the key's node, if any, takes the rest
+		mapping := ingestion.DynatraceEntityMapping{
+			Dimension:     cfg.Lambda.DynatraceEntityDimension,
+			Nodes:         cfg.Lambda.DynatraceEntityNodes,
+			MatchMetadata: true,
+			MatchNames:    true,
+		}
+		if err := ingester.SetEntityMapping(mapping); err != nil {
+			return nil, fmt.Errorf("invalid Dynatrace entity mapping: %w", err)
+		}
+		if err != nil {
+			log.Debug().Err(err).Str("key", key).Msg("No node for Dynatrace file, routing by entity only")
+			nodeID = uuid.Nil
+		}
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to determine node ID from key %s: %w", key, err)
 	}
 
@@ -200,6 +523,8 @@ func processDynatraceFile(ctx context.Context, bucket, key string) (*ingestion.I
 		Str("node_id", nodeID.String()).
 		Int("processed", result.RecordsProcessed).
 		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Strs("unmapped_entities", result.UnmappedEntities).
 		Dur("duration", result.Duration).
 		Msg("Dynatrace file processed")
 
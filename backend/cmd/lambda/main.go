@@ -26,10 +26,13 @@ import (
 type HandlerType string
 
 const (
-	HandlerImportAWSCUR    HandlerType = "import_awscur"
-	HandlerImportDynatrace HandlerType = "import_dynatrace"
-	HandlerExport          HandlerType = "export"
-	HandlerAllocate        HandlerType = "allocate"
+	HandlerImportAWSCUR     HandlerType = "import_awscur"
+	HandlerImportDynatrace  HandlerType = "import_dynatrace"
+	HandlerImportAzure      HandlerType = "import_azure"
+	HandlerImportGCP        HandlerType = "import_gcp"
+	HandlerImportPrometheus HandlerType = "import_prometheus"
+	HandlerExport           HandlerType = "export"
+	HandlerAllocate         HandlerType = "allocate"
 )
 
 var (
@@ -69,6 +72,12 @@ func main() {
 			handlerType = HandlerImportAWSCUR
 		case "dynatrace":
 			handlerType = HandlerImportDynatrace
+		case "azure":
+			handlerType = HandlerImportAzure
+		case "gcp":
+			handlerType = HandlerImportGCP
+		case "prometheus":
+			handlerType = HandlerImportPrometheus
 		default:
 			log.Fatal().Msg("FINOPS_LAMBDA_HANDLER environment variable not set")
 		}
@@ -91,6 +100,12 @@ func main() {
 			lambda.Start(handleImportAWSCUR)
 		case HandlerImportDynatrace:
 			lambda.Start(handleImportDynatrace)
+		case HandlerImportAzure:
+			lambda.Start(handleImportAzure)
+		case HandlerImportGCP:
+			lambda.Start(handleImportGCP)
+		case HandlerImportPrometheus:
+			lambda.Start(handleImportPrometheus)
 		case HandlerExport:
 			lambda.Start(handleExport)
 		case HandlerAllocate:
@@ -139,6 +154,27 @@ func runLocalMode(handlerType HandlerType) {
 		}
 		response, err = handleImportDynatrace(ctx, event)
 
+	case HandlerImportAzure:
+		var event events.S3Event
+		if err := json.Unmarshal(eventData, &event); err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse S3 event")
+		}
+		response, err = handleImportAzure(ctx, event)
+
+	case HandlerImportGCP:
+		var event events.S3Event
+		if err := json.Unmarshal(eventData, &event); err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse S3 event")
+		}
+		response, err = handleImportGCP(ctx, event)
+
+	case HandlerImportPrometheus:
+		var event events.S3Event
+		if err := json.Unmarshal(eventData, &event); err != nil {
+			log.Fatal().Err(err).Msg("Failed to parse S3 event")
+		}
+		response, err = handleImportPrometheus(ctx, event)
+
 	case HandlerExport:
 		var request ExportRequest
 		if err := json.Unmarshal(eventData, &request); err != nil {
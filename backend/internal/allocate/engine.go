@@ -2,10 +2,13 @@ package allocate
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
 	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
 	"github.com/pickeringtech/FinOpsAggregator/internal/models"
 	"github.com/pickeringtech/FinOpsAggregator/internal/store"
@@ -15,20 +18,127 @@ import (
 
 // Engine performs cost allocation computations
 type Engine struct {
-	store      *store.Store
-	builder    *graph.GraphBuilder
-	strategies *StrategyResolver
+	store             *store.Store
+	builder           *graph.GraphBuilder
+	concurrency       int
+	incremental       bool
+	remainderPolicy   RemainderPolicy
+	zeroUsageFallback bool
+	rounding          bool
+	roundingPlaces    int32
+	timeout           time.Duration
+	heartbeatInterval time.Duration
+	staleRunAfter     time.Duration
+	baseCurrency      string
+}
+
+// EngineConfig configures the allocation engine
+type EngineConfig struct {
+	// Concurrency is the number of days allocated in parallel
+	Concurrency int
+	// Incremental recomputes only days whose input fingerprint changed since the
+	// latest completed run, copying the remaining days forward from that run
+	Incremental bool
+	// RemainderPolicy decides what happens to the part of a parent's cost its
+	// children's shares do not cover (defaults to to_unallocated)
+	RemainderPolicy RemainderPolicy
+	// DisableZeroUsageFallback stops usage-based strategies splitting equally when
+	// no child has usage; the parent's cost is routed to the unallocated node instead
+	DisableZeroUsageFallback bool
+	// RoundingPlaces, when set, rounds every parent's contributions to this many
+	// decimal places, the minor unit of the currency being allocated
+	RoundingPlaces *int32
+	// Timeout fails a run that has not finished within it; zero means no timeout
+	Timeout time.Duration
+	// HeartbeatInterval is how often a running computation records that it is alive
+	// and checks whether it has been cancelled (defaults to 30s)
+	HeartbeatInterval time.Duration
+	// StaleRunAfter is how long a pending or running computation may go without a
+	// heartbeat before the reaper marks it failed (defaults to 10m)
+	StaleRunAfter time.Duration
+	// BaseCurrency is the currency every cost is converted to, at the rate on its
+	// cost date, before it is allocated (defaults to USD)
+	BaseCurrency string
 }
 
 // NewEngine creates a new allocation engine
-func NewEngine(store *store.Store) *Engine {
+func NewEngine(store *store.Store, config *EngineConfig) *Engine {
+	concurrency := 4
+	if config != nil && config.Concurrency > 0 {
+		concurrency = config.Concurrency
+	}
+
+	incremental := false
+	remainderPolicy := RemainderToUnallocated
+	zeroUsageFallback := true
+	if config != nil {
+		incremental = config.Incremental
+		if config.RemainderPolicy != "" {
+			remainderPolicy = config.RemainderPolicy
+		}
+		zeroUsageFallback = !config.DisableZeroUsageFallback
+	}
+
+	rounding := false
+	var roundingPlaces int32
+	if config != nil && config.RoundingPlaces != nil {
+		rounding = true
+		roundingPlaces = *config.RoundingPlaces
+	}
+
+	var timeout time.Duration
+	heartbeatInterval := 30 * time.Second
+	staleRunAfter := 10 * time.Minute
+	if config != nil {
+		timeout = config.Timeout
+		if config.HeartbeatInterval > 0 {
+			heartbeatInterval = config.HeartbeatInterval
+		}
+		if config.StaleRunAfter > 0 {
+			staleRunAfter = config.StaleRunAfter
+		}
+	}
+
+	baseCurrency := "USD"
+	if config != nil && config.BaseCurrency != "" {
+		baseCurrency = fx.NormaliseCurrency(config.BaseCurrency)
+	}
+
 	return &Engine{
-		store:      store,
-		builder:    graph.NewGraphBuilder(store),
-		strategies: NewStrategyResolver(store),
+		store:             store,
+		builder:           graph.NewGraphBuilder(store),
+		concurrency:       concurrency,
+		incremental:       incremental,
+		remainderPolicy:   remainderPolicy,
+		zeroUsageFallback: zeroUsageFallback,
+		rounding:          rounding,
+		roundingPlaces:    roundingPlaces,
+		timeout:           timeout,
+		heartbeatInterval: heartbeatInterval,
+		staleRunAfter:     staleRunAfter,
+		baseCurrency:      baseCurrency,
 	}
 }
 
+// dayResult holds the output of allocating a single day
+type dayResult struct {
+	date          time.Time
+	allocations   []models.AllocationResultByDimension
+	contributions []models.ContributionResultByDimension
+	lineage       []models.LineageResultByDimension
+	unallocated   []models.UnallocatedResultByDimension
+	recoveries    []models.RecoveryResultByDimension
+	reports       []models.AllocationInvariantReport
+	fingerprint   models.AllocationDayFingerprint
+	err           error
+
+	// copyFrom is set when the day's inputs are unchanged and its results
+	// should be copied forward from an earlier run instead of saved
+	copyFrom            *uuid.UUID
+	copiedContributions int
+	copiedLineage       int
+}
+
 // AllocateForPeriod performs cost allocation for a date range
 func (e *Engine) AllocateForPeriod(ctx context.Context, startDate, endDate time.Time, dimensions []string) (*models.AllocationOutput, error) {
 	log.Info().
@@ -37,6 +147,15 @@ func (e *Engine) AllocateForPeriod(ctx context.Context, startDate, endDate time.
 		Strs("dimensions", dimensions).
 		Msg("Starting allocation computation")
 
+	if _, err := ParseRemainderPolicy(string(e.remainderPolicy)); err != nil {
+		return nil, err
+	}
+
+	// Runs abandoned by a dead process would otherwise stay running forever
+	if _, err := e.ReapStaleRuns(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to reap stale computation runs")
+	}
+
 	startTime := time.Now()
 
 	// Create computation run
@@ -45,6 +164,8 @@ func (e *Engine) AllocateForPeriod(ctx context.Context, startDate, endDate time.
 		WindowStart: startDate,
 		WindowEnd:   endDate,
 		Status:      string(models.ComputationStatusRunning),
+		Dimensions:  dimensions,
+		Currency:    e.baseCurrency,
 	}
 
 	// Build graph for the first date to get hash
@@ -54,9 +175,28 @@ func (e *Engine) AllocateForPeriod(ctx context.Context, startDate, endDate time.
 	}
 	run.GraphHash = firstGraph.Hash()
 
-	// Save computation run
-	if err := e.store.Runs.Create(ctx, run); err != nil {
-		return nil, fmt.Errorf("failed to create computation run: %w", err)
+	// Cost the allocation cannot place is routed to a synthetic node of the run's own
+	sink := &models.CostNode{
+		ID:         uuid.New(),
+		Name:       fmt.Sprintf("Unallocated (run %s)", run.ID),
+		Type:       string(models.NodeTypeUnallocated),
+		CostLabels: map[string]interface{}{},
+		Metadata:   map[string]interface{}{"run_id": run.ID.String()},
+	}
+	run.UnallocatedNodeID = &sink.ID
+
+	// The sink and the run are saved together so a failed run cannot orphan its sink
+	err = e.store.WithTx(ctx, func(tx *store.Store) error {
+		if err := tx.Nodes.Create(ctx, sink); err != nil {
+			return fmt.Errorf("failed to create unallocated node: %w", err)
+		}
+		if err := tx.Runs.Create(ctx, run); err != nil {
+			return fmt.Errorf("failed to create computation run: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// Update status to running
@@ -64,153 +204,566 @@ func (e *Engine) AllocateForPeriod(ctx context.Context, startDate, endDate time.
 		log.Error().Err(err).Msg("Failed to update run status to running")
 	}
 
-	var allAllocations []models.AllocationResultByDimension
-	var allContributions []models.ContributionResultByDimension
-	summary := models.AllocationSummary{
-		TotalDirectCost:   make(map[string]decimal.Decimal),
-		TotalIndirectCost: make(map[string]decimal.Decimal),
-		TotalCost:         make(map[string]decimal.Decimal),
+	return e.executeRun(ctx, run, startDate, firstGraph, startTime)
+}
+
+// ResumeRun continues a failed or cancelled run from the day after its last
+// checkpoint, allocating the dimensions the run was started with. Results an
+// interrupted day saved before its checkpoint are discarded and recomputed.
+func (e *Engine) ResumeRun(ctx context.Context, runID uuid.UUID) (*models.AllocationOutput, error) {
+	if _, err := ParseRemainderPolicy(string(e.remainderPolicy)); err != nil {
+		return nil, err
 	}
 
-	// Process each day
-	processedDays := 0
-	log.Debug().
-		Time("start_date", startDate).
-		Time("end_date", endDate).
-		Msg("Starting daily allocation loop")
-	for date := startDate; !date.After(endDate); date = date.AddDate(0, 0, 1) {
-		log.Debug().Time("processing_date", date).Msg("Processing date")
-		dayAllocations, dayContributions, err := e.allocateForDay(ctx, run.ID, date, dimensions)
-		if err != nil {
-			// Update run status to failed
-			notes := fmt.Sprintf("Failed on date %s: %v", date.Format("2006-01-02"), err)
-			if updateErr := e.store.Runs.UpdateStatus(ctx, run.ID, string(models.ComputationStatusFailed), &notes); updateErr != nil {
-				log.Error().Err(updateErr).Msg("Failed to update run status to failed")
-			}
-			return nil, fmt.Errorf("failed to allocate for date %s: %w", date.Format("2006-01-02"), err)
-		}
+	// A run whose process died is still running until it is reaped
+	if _, err := e.ReapStaleRuns(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to reap stale computation runs")
+	}
 
-		allAllocations = append(allAllocations, dayAllocations...)
-		allContributions = append(allContributions, dayContributions...)
-		processedDays++
+	startTime := time.Now()
 
-		// Update summary
-		for _, allocation := range dayAllocations {
-			dim := allocation.Dimension
-			if _, exists := summary.TotalDirectCost[dim]; !exists {
-				summary.TotalDirectCost[dim] = decimal.Zero
-				summary.TotalIndirectCost[dim] = decimal.Zero
-				summary.TotalCost[dim] = decimal.Zero
-			}
-			summary.TotalDirectCost[dim] = summary.TotalDirectCost[dim].Add(allocation.DirectAmount)
-			summary.TotalIndirectCost[dim] = summary.TotalIndirectCost[dim].Add(allocation.IndirectAmount)
-			summary.TotalCost[dim] = summary.TotalCost[dim].Add(allocation.TotalAmount)
-		}
+	run, err := e.store.Runs.GetByID(ctx, runID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Save results in batches
-	if err := e.saveResultsInBatches(ctx, allAllocations, allContributions); err != nil {
-		notes := fmt.Sprintf("Failed to save results: %v", err)
-		if updateErr := e.store.Runs.UpdateStatus(ctx, run.ID, string(models.ComputationStatusFailed), &notes); updateErr != nil {
-			log.Error().Err(updateErr).Msg("Failed to update run status to failed")
-		}
-		return nil, fmt.Errorf("failed to save results: %w", err)
+	switch models.ComputationStatus(run.Status) {
+	case models.ComputationStatusFailed, models.ComputationStatusCancelled:
+	default:
+		return nil, fmt.Errorf("computation run %s is %s; only failed or cancelled runs can be resumed", run.ID, run.Status)
+	}
+	if len(run.Dimensions) == 0 || run.UnallocatedNodeID == nil {
+		return nil, fmt.Errorf("computation run %s predates resumable runs and must be rerun", run.ID)
+	}
+	if run.Currency == "" {
+		run.Currency = e.baseCurrency
 	}
 
-	// Update run status to completed
-	if err := e.store.Runs.UpdateStatus(ctx, run.ID, string(models.ComputationStatusCompleted), nil); err != nil {
-		log.Error().Err(err).Msg("Failed to update run status to completed")
+	resumeFrom := run.WindowStart
+	checkpoint, err := e.store.Runs.GetLastCheckpoint(ctx, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	if checkpoint != nil {
+		resumeFrom = checkpoint.CheckpointDate.AddDate(0, 0, 1)
 	}
 
-	// Complete summary
-	summary.TotalNodes = len(firstGraph.Nodes())
-	summary.TotalEdges = firstGraph.Stats().EdgeCount
-	summary.ProcessedDays = processedDays
-	summary.ProcessingTime = time.Since(startTime)
+	log.Info().
+		Str("run_id", run.ID.String()).
+		Str("previous_status", run.Status).
+		Time("resume_from", resumeFrom).
+		Time("end_date", run.WindowEnd).
+		Strs("dimensions", run.Dimensions).
+		Msg("Resuming allocation computation")
+
+	if err := e.store.Runs.DeleteResultsFrom(ctx, run.ID, resumeFrom); err != nil {
+		return nil, fmt.Errorf("failed to discard results after the last checkpoint: %w", err)
+	}
+	if err := e.store.Runs.MarkResumed(ctx, run.ID); err != nil {
+		return nil, err
+	}
+
+	firstGraph, err := e.builder.BuildForDate(ctx, resumeFrom)
+	if err != nil {
+		e.failRun(ctx, run.ID, err)
+		return nil, fmt.Errorf("failed to build initial graph: %w", err)
+	}
+
+	output, err := e.executeRun(ctx, run, resumeFrom, firstGraph, startTime)
+	if err != nil {
+		return nil, err
+	}
+	output.Summary.ResumedFrom = &resumeFrom
+	return output, nil
+}
 
-	// Calculate and log coverage metrics
+// executeRun allocates every day of the run from startDate to the end of its
+// window and marks it completed, failed or cancelled. While it works it records a
+// heartbeat and stops if the run is cancelled through the repository.
+func (e *Engine) executeRun(ctx context.Context, run *models.ComputationRun, startDate time.Time, firstGraph *graph.Graph, startTime time.Time) (*models.AllocationOutput, error) {
+	endDate := run.WindowEnd
+	dimensions := run.Dimensions
+
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+	ctx, stopHeartbeat := e.startHeartbeat(ctx, run.ID)
+	defer stopHeartbeat()
+
+	// Coverage is measured against the final cost centres of the first day's graph
 	finalCostCentres := firstGraph.GetFinalCostCentres()
 	finalCostCentreSet := make(map[uuid.UUID]bool)
 	for _, id := range finalCostCentres {
 		finalCostCentreSet[id] = true
 	}
 
-	// Calculate totals for logging
-	totalDirectCost := decimal.Zero
-	totalIndirectCost := decimal.Zero
-	finalCostCentreTotal := decimal.Zero
-	for _, alloc := range allAllocations {
-		totalDirectCost = totalDirectCost.Add(alloc.DirectAmount)
-		totalIndirectCost = totalIndirectCost.Add(alloc.IndirectAmount)
-		if finalCostCentreSet[alloc.NodeID] {
-			finalCostCentreTotal = finalCostCentreTotal.Add(alloc.TotalAmount)
+	// In incremental mode, find the fingerprints each day was last computed from
+	var previous map[string]models.AllocationDayFingerprint
+	if e.incremental {
+		var err error
+		previous, err = e.loadPreviousFingerprints(ctx, startDate, endDate)
+		if err != nil {
+			e.failRun(ctx, run.ID, err)
+			return nil, err
 		}
 	}
 
-	// Calculate coverage
-	coveragePercent := 0.0
-	if !totalDirectCost.IsZero() {
-		ratio, _ := finalCostCentreTotal.Div(totalDirectCost).Float64()
-		if ratio > 0 && ratio <= 1 {
-			coveragePercent = ratio * 100
+	// Process each day, persisting its results as soon as it is merged so the
+	// whole period is never held in memory
+	var sinkID uuid.UUID
+	if run.UnallocatedNodeID != nil {
+		sinkID = *run.UnallocatedNodeID
+	}
+	totals := newRunTotals(finalCostCentreSet, sinkID)
+	log.Debug().
+		Time("start_date", startDate).
+		Time("end_date", endDate).
+		Int("concurrency", e.concurrency).
+		Bool("incremental", e.incremental).
+		Msg("Starting daily allocation loop")
+	err := e.allocateDays(ctx, run, startDate, endDate, dimensions, previous, func(day dayResult) error {
+		if err := e.persistDay(ctx, run.ID, &day); err != nil {
+			return fmt.Errorf("failed to save results for date %s: %w", day.date.Format("2006-01-02"), err)
+		}
+		totals.add(day)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) && e.timeout > 0 {
+			err = fmt.Errorf("computation run timed out after %s: %w", e.timeout, err)
 		}
+		e.failRun(ctx, run.ID, err)
+		return nil, err
+	}
+
+	// Update run status to completed
+	if err := e.store.Runs.UpdateStatus(ctx, run.ID, string(models.ComputationStatusCompleted), nil); err != nil {
+		log.Error().Err(err).Msg("Failed to update run status to completed")
 	}
 
+	// Complete summary
+	summary := totals.summary
+	summary.TotalNodes = len(firstGraph.Nodes())
+	summary.TotalEdges = firstGraph.Stats().EdgeCount
+	summary.ProcessingTime = time.Since(startTime)
+
 	log.Info().
 		Str("run_id", run.ID.String()).
-		Int("processed_days", processedDays).
-		Int("allocations", len(allAllocations)).
-		Int("contributions", len(allContributions)).
+		Int("processed_days", summary.ProcessedDays).
+		Int("copied_days", summary.CopiedDays).
+		Int("allocations", summary.AllocationCount).
+		Int("contributions", summary.ContributionCount).
+		Int("lineage", summary.LineageCount).
+		Int("invariant_violations", summary.InvariantViolations).
 		Dur("processing_time", summary.ProcessingTime).
 		Int("total_nodes", summary.TotalNodes).
 		Int("total_edges", summary.TotalEdges).
 		Int("final_cost_centres", len(finalCostCentres)).
-		Str("total_direct_cost", totalDirectCost.StringFixed(2)).
-		Str("total_indirect_cost", totalIndirectCost.StringFixed(2)).
-		Str("final_cost_centre_total", finalCostCentreTotal.StringFixed(2)).
-		Float64("coverage_percent", coveragePercent).
+		Str("total_direct_cost", totals.directCost.StringFixed(2)).
+		Str("total_indirect_cost", totals.indirectCost.StringFixed(2)).
+		Str("final_cost_centre_total", totals.finalCostCentreTotal.StringFixed(2)).
+		Str("unallocated_total", totals.unallocatedTotal.StringFixed(2)).
+		Float64("coverage_percent", totals.coveragePercent()).
 		Msg("Allocation computation completed")
 
 	return &models.AllocationOutput{
-		RunID:         run.ID,
-		Allocations:   allAllocations,
-		Contributions: allContributions,
-		Summary:       summary,
+		RunID:   run.ID,
+		Summary: summary,
 	}, nil
 }
 
-// allocateForDay performs allocation for a single day
-func (e *Engine) allocateForDay(ctx context.Context, runID uuid.UUID, date time.Time, dimensions []string) ([]models.AllocationResultByDimension, []models.ContributionResultByDimension, error) {
+// errRunCancelled is the cancellation cause when a run is cancelled through the
+// repository rather than by its caller
+var errRunCancelled = errors.New("computation run was cancelled")
+
+// startHeartbeat records a heartbeat for the run every heartbeat interval until
+// the returned stop function is called. The returned context is cancelled with
+// errRunCancelled once a heartbeat finds the run has been cancelled.
+func (e *Engine) startHeartbeat(ctx context.Context, runID uuid.UUID) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(e.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			status, err := e.store.Runs.Heartbeat(ctx, runID)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warn().Err(err).Str("run_id", runID.String()).Msg("Failed to record computation run heartbeat")
+				}
+				continue
+			}
+			if status == models.ComputationStatusCancelled {
+				log.Info().Str("run_id", runID.String()).Msg("Computation run cancelled, stopping")
+				cancel(errRunCancelled)
+				return
+			}
+		}
+	}()
+
+	return ctx, func() {
+		cancel(nil)
+		<-done
+	}
+}
+
+// ReapStaleRuns marks pending and running computation runs that have gone without
+// a heartbeat for longer than the stale run threshold as failed
+func (e *Engine) ReapStaleRuns(ctx context.Context) ([]uuid.UUID, error) {
+	ids, err := e.store.Runs.FailStaleRuns(ctx, time.Now().Add(-e.staleRunAfter))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		log.Warn().
+			Str("run_id", id.String()).
+			Dur("stale_after", e.staleRunAfter).
+			Msg("Marked stale computation run as failed")
+	}
+
+	return ids, nil
+}
+
+// runTotals accumulates the run summary one day at a time
+type runTotals struct {
+	summary              models.AllocationSummary
+	finalCostCentres     map[uuid.UUID]bool
+	unallocatedNodeID    uuid.UUID
+	directCost           decimal.Decimal
+	indirectCost         decimal.Decimal
+	finalCostCentreTotal decimal.Decimal
+	unallocatedTotal     decimal.Decimal
+}
+
+func newRunTotals(finalCostCentres map[uuid.UUID]bool, unallocatedNodeID uuid.UUID) *runTotals {
+	return &runTotals{
+		summary: models.AllocationSummary{
+			TotalDirectCost:   make(map[string]decimal.Decimal),
+			TotalIndirectCost: make(map[string]decimal.Decimal),
+			TotalCost:         make(map[string]decimal.Decimal),
+			TotalUnallocated:  make(map[string]decimal.Decimal),
+		},
+		finalCostCentres:  finalCostCentres,
+		unallocatedNodeID: unallocatedNodeID,
+	}
+}
+
+// add folds a day's results into the totals
+func (t *runTotals) add(day dayResult) {
+	t.summary.ProcessedDays++
+	if day.copyFrom != nil {
+		t.summary.CopiedDays++
+	}
+	t.summary.AllocationCount += len(day.allocations)
+	t.summary.ContributionCount += len(day.contributions) + day.copiedContributions
+	t.summary.LineageCount += len(day.lineage) + day.copiedLineage
+	for _, report := range day.reports {
+		if violatesInvariants(report) {
+			t.summary.InvariantViolations++
+		}
+	}
+
+	for _, allocation := range day.allocations {
+		dim := allocation.Dimension
+		if _, exists := t.summary.TotalDirectCost[dim]; !exists {
+			t.summary.TotalDirectCost[dim] = decimal.Zero
+			t.summary.TotalIndirectCost[dim] = decimal.Zero
+			t.summary.TotalCost[dim] = decimal.Zero
+		}
+		t.summary.TotalDirectCost[dim] = t.summary.TotalDirectCost[dim].Add(allocation.DirectAmount)
+		t.summary.TotalIndirectCost[dim] = t.summary.TotalIndirectCost[dim].Add(allocation.IndirectAmount)
+		t.summary.TotalCost[dim] = t.summary.TotalCost[dim].Add(allocation.TotalAmount)
+
+		t.directCost = t.directCost.Add(allocation.DirectAmount)
+		t.indirectCost = t.indirectCost.Add(allocation.IndirectAmount)
+		if t.finalCostCentres[allocation.NodeID] {
+			t.finalCostCentreTotal = t.finalCostCentreTotal.Add(allocation.TotalAmount)
+		}
+		if allocation.NodeID == t.unallocatedNodeID {
+			t.summary.TotalUnallocated[dim] = t.summary.TotalUnallocated[dim].Add(allocation.TotalAmount)
+			t.unallocatedTotal = t.unallocatedTotal.Add(allocation.TotalAmount)
+		}
+	}
+}
+
+// coveragePercent returns the share of direct cost that reached final cost centres
+func (t *runTotals) coveragePercent() float64 {
+	if t.directCost.IsZero() {
+		return 0
+	}
+	ratio, _ := t.finalCostCentreTotal.Div(t.directCost).Float64()
+	if ratio > 0 && ratio <= 1 {
+		return ratio * 100
+	}
+	return 0
+}
+
+// allocateDays allocates every day in the range using a bounded pool of workers
+func (e *Engine) allocateDays(
+	ctx context.Context,
+	run *models.ComputationRun,
+	startDate, endDate time.Time,
+	dimensions []string,
+	previous map[string]models.AllocationDayFingerprint,
+	emit func(dayResult) error,
+) error {
+	var dates []time.Time
+	for date := startDate; !date.After(endDate); date = date.AddDate(0, 0, 1) {
+		dates = append(dates, date)
+	}
+
+	return runDayPool(ctx, dates, e.concurrency, func(ctx context.Context, date time.Time) dayResult {
+		log.Debug().Time("processing_date", date).Msg("Processing date")
+		var prev *models.AllocationDayFingerprint
+		if fp, ok := previous[date.Format("2006-01-02")]; ok {
+			prev = &fp
+		}
+		return e.allocateForDay(ctx, run, date, dimensions, prev)
+	}, emit)
+}
+
+// loadPreviousFingerprints loads the latest completed fingerprint for each day, keyed by date
+func (e *Engine) loadPreviousFingerprints(ctx context.Context, startDate, endDate time.Time) (map[string]models.AllocationDayFingerprint, error) {
+	fingerprints, err := e.store.Runs.GetLatestDayFingerprints(ctx, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load previous day fingerprints: %w", err)
+	}
+
+	previous := make(map[string]models.AllocationDayFingerprint, len(fingerprints))
+	for _, fp := range fingerprints {
+		previous[fp.AllocationDate.Format("2006-01-02")] = fp
+	}
+
+	log.Info().
+		Int("days_with_fingerprints", len(previous)).
+		Msg("Loaded previous day fingerprints for incremental allocation")
+
+	return previous, nil
+}
+
+// failRun marks a run as failed, or as cancelled when its caller cancelled it. A
+// run cancelled through the repository already has its status. The caller's
+// context may already be cancelled, so the status update must not depend on it.
+func (e *Engine) failRun(ctx context.Context, runID uuid.UUID, err error) {
+	if errors.Is(context.Cause(ctx), errRunCancelled) {
+		return
+	}
+
+	status := models.ComputationStatusFailed
+	if errors.Is(err, context.Canceled) {
+		status = models.ComputationStatusCancelled
+	}
+
+	notes := err.Error()
+	if updateErr := e.store.Runs.UpdateStatus(context.WithoutCancel(ctx), runID, string(status), &notes); updateErr != nil {
+		log.Error().Err(updateErr).Msgf("Failed to update run status to %s", status)
+	}
+}
+
+// runDayPool runs allocate for each date on at most concurrency workers. Results
+// are handed to emit strictly in date order, regardless of the order the workers
+// finish in, and workers never run more than a small window ahead of emit so
+// memory stays bounded. The first failure from allocate or emit, or cancellation
+// of ctx, stops all workers and is returned once they have exited.
+func runDayPool(ctx context.Context, dates []time.Time, concurrency int, allocate func(context.Context, time.Time) dayResult, emit func(dayResult) error) error {
+	if len(dates) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(dates) {
+		workers = len(dates)
+	}
+
+	// One buffered slot per day so workers never block on a slow consumer
+	results := make([]chan dayResult, len(dates))
+	for i := range results {
+		results[i] = make(chan dayResult, 1)
+	}
+
+	// Limit how many days may be in flight or awaiting emit at once
+	window := make(chan struct{}, 2*workers)
+
+	// The first failure cancels the remaining work
+	var failOnce sync.Once
+	var failErr error
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failErr = err
+			cancel()
+		})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				date := dates[i]
+				day := allocate(ctx, date)
+				if day.err == nil {
+					day.err = ctx.Err()
+				}
+				if day.err != nil {
+					day.err = fmt.Errorf("failed to allocate for date %s: %w", date.Format("2006-01-02"), day.err)
+					fail(day.err)
+				}
+				results[i] <- day
+			}
+		}()
+	}
+
+	// Feed jobs until every day is queued or the run is cancelled
+	go func() {
+		defer close(jobs)
+		for i := range dates {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Emit results in date order
+	var cancelErr error
+	for i := range dates {
+		var day dayResult
+		select {
+		case day = <-results[i]:
+		case <-ctx.Done():
+			cancelErr = fmt.Errorf("allocation cancelled before %s: %w", dates[i].Format("2006-01-02"), ctx.Err())
+		}
+		if cancelErr != nil || day.err != nil {
+			break
+		}
+		if err := emit(day); err != nil {
+			fail(err)
+			break
+		}
+		<-window
+	}
+
+	cancel()
+	wg.Wait()
+
+	if failErr != nil {
+		return failErr
+	}
+	return cancelErr
+}
+
+// allocateForDay performs allocation for a single day. When previous matches the
+// day's input fingerprint the traversal is skipped and the day is marked to be
+// copied forward from the run that computed it.
+func (e *Engine) allocateForDay(ctx context.Context, run *models.ComputationRun, date time.Time, dimensions []string, previous *models.AllocationDayFingerprint) dayResult {
 	log.Debug().Time("date", date).Msg("Processing allocation for day")
+	result := dayResult{date: date}
+	runID := run.ID
 
 	// Step 1: Build allocation graph
 	g, order, err := e.buildAllocationGraph(ctx, date)
 	if err != nil {
-		return nil, nil, err
+		result.err = err
+		return result
+	}
+
+	// Step 2: Load direct costs, converted to the run's currency
+	costsByNode, err := e.loadDirectCosts(ctx, date, dimensions, run.Currency)
+	if err != nil {
+		result.err = err
+		return result
 	}
 
-	// Step 2: Load direct costs
-	costsByNode, err := e.loadDirectCosts(ctx, date, dimensions)
+	// Step 3: Preload edges, strategy overrides and usage for the day
+	snap, err := e.loadDaySnapshot(ctx, date)
 	if err != nil {
-		return nil, nil, err
+		result.err = err
+		return result
+	}
+	snap.attachNodes(g.Nodes())
+
+	// Step 4: Fingerprint the inputs and skip the day if they are unchanged
+	result.fingerprint = models.AllocationDayFingerprint{
+		RunID:            runID,
+		AllocationDate:   date,
+		GraphHash:        g.Hash(),
+		CostChecksum:     costChecksum(costsByNode),
+		UsageChecksum:    snap.usageChecksum,
+		StrategyChecksum: snap.strategyChecksum,
+	}
+	result.fingerprint.Fingerprint = dayFingerprint(
+		result.fingerprint.GraphHash,
+		result.fingerprint.CostChecksum,
+		result.fingerprint.UsageChecksum,
+		result.fingerprint.StrategyChecksum,
+		dimensions,
+		e.fingerprintSettings(run.Currency),
+	)
+	if previous != nil && previous.Fingerprint == result.fingerprint.Fingerprint {
+		log.Debug().
+			Time("date", date).
+			Str("previous_run_id", previous.RunID.String()).
+			Msg("Day inputs unchanged, copying results forward")
+		result.copyFrom = &previous.RunID
+		return result
 	}
 
-	// Step 3: Initialize indirect cost accumulators
+	// Step 5: Initialize indirect cost accumulators
 	indirectCosts := e.initializeIndirectCosts(g, dimensions)
 
-	// Step 4: Perform allocation traversal
-	allocations, contributions := e.performAllocationTraversal(ctx, runID, date, g, order, dimensions, costsByNode, indirectCosts)
+	// Step 6: Perform allocation traversal
+	result.allocations, result.contributions, result.lineage, err = e.performAllocationTraversal(runID, date, g, order, dimensions, snap, costsByNode, indirectCosts)
+	if err != nil {
+		result.err = err
+		return result
+	}
 
 	log.Debug().
 		Time("date", date).
-		Int("allocations", len(allocations)).
-		Int("contributions", len(contributions)).
+		Int("allocations", len(result.allocations)).
+		Int("contributions", len(result.contributions)).
+		Int("lineage", len(result.lineage)).
 		Msg("Day allocation completed")
 
-	// Step 5: Validate allocation invariants
-	e.validateAllocationInvariants(ctx, g, allocations, contributions, costsByNode, indirectCosts, dimensions, date)
+	// Step 7: Validate allocation invariants and account for any cost created or lost
+	result.reports = e.validateAllocationInvariants(ctx, runID, g, result.allocations, result.contributions, costsByNode, indirectCosts, dimensions, date)
+
+	// Step 8: Route the cost no node could place to the run's unallocated node
+	result.unallocated = e.collectUnallocated(runID, date, g, dimensions, snap, result.contributions, costsByNode, indirectCosts)
+	if run.UnallocatedNodeID != nil {
+		result.allocations = append(result.allocations, unallocatedNodeAllocations(runID, *run.UnallocatedNodeID, date, dimensions, result.unallocated)...)
+	}
 
-	return allocations, contributions, nil
+	// Step 9: Record how far rate-based strategies recovered their parents' cost
+	result.recoveries = snap.recoveryResults(runID, run.UnallocatedNodeID)
+
+	return result
 }
 
 // buildAllocationGraph builds the allocation graph and returns it with topological order
@@ -237,20 +790,29 @@ func (e *Engine) buildAllocationGraph(ctx context.Context, date time.Time) (*gra
 	return g, order, nil
 }
 
-// loadDirectCosts loads direct costs for all nodes on a given date
-func (e *Engine) loadDirectCosts(ctx context.Context, date time.Time, dimensions []string) (map[uuid.UUID]map[string]decimal.Decimal, error) {
+// loadDirectCosts loads direct costs for all nodes on a given date, converted to
+// the currency being allocated at the rate on that date
+func (e *Engine) loadDirectCosts(ctx context.Context, date time.Time, dimensions []string, currency string) (map[uuid.UUID]map[string]decimal.Decimal, error) {
 	directCosts, err := e.store.Costs.GetByDate(ctx, date, dimensions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load direct costs: %w", err)
 	}
 
-	// Organize costs by node and dimension
-	costsByNode := make(map[uuid.UUID]map[string]decimal.Decimal)
+	rates := fx.NewRates(nil)
 	for _, cost := range directCosts {
-		if costsByNode[cost.NodeID] == nil {
-			costsByNode[cost.NodeID] = make(map[string]decimal.Decimal)
+		if fx.NormaliseCurrency(cost.Currency) != currency {
+			fxRates, err := e.store.FX.GetForDateRange(ctx, date, date)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load FX rates: %w", err)
+			}
+			rates = fx.NewRates(fxRates)
+			break
 		}
-		costsByNode[cost.NodeID][cost.Dimension] = cost.Amount
+	}
+
+	costsByNode, err := costsInCurrency(directCosts, currency, rates)
+	if err != nil {
+		return nil, err
 	}
 
 	log.Debug().
@@ -261,6 +823,24 @@ func (e *Engine) loadDirectCosts(ctx context.Context, date time.Time, dimensions
 	return costsByNode, nil
 }
 
+// costsInCurrency organises direct costs by node and dimension, converting each
+// to the currency being allocated at the rate on its cost date
+func costsInCurrency(directCosts []models.NodeCostByDimension, currency string, rates *fx.Rates) (map[uuid.UUID]map[string]decimal.Decimal, error) {
+	costsByNode := make(map[uuid.UUID]map[string]decimal.Decimal)
+	for _, cost := range directCosts {
+		amount, err := rates.Convert(cost.Amount, cost.Currency, currency, cost.CostDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert %s cost of node %s: %w", cost.Dimension, cost.NodeID, err)
+		}
+
+		if costsByNode[cost.NodeID] == nil {
+			costsByNode[cost.NodeID] = make(map[string]decimal.Decimal)
+		}
+		costsByNode[cost.NodeID][cost.Dimension] = amount
+	}
+	return costsByNode, nil
+}
+
 // initializeIndirectCosts initializes indirect cost accumulators for all nodes
 func (e *Engine) initializeIndirectCosts(g *graph.Graph, dimensions []string) map[uuid.UUID]map[string]decimal.Decimal {
 	indirectCosts := make(map[uuid.UUID]map[string]decimal.Decimal)
@@ -273,53 +853,67 @@ func (e *Engine) initializeIndirectCosts(g *graph.Graph, dimensions []string) ma
 	return indirectCosts
 }
 
-// performAllocationTraversal traverses the graph in topological order and allocates costs
+// performAllocationTraversal traverses the graph in topological order and allocates costs,
+// tracing each final cost centre's holistic cost back to the nodes it originated on
 func (e *Engine) performAllocationTraversal(
-	ctx context.Context,
 	runID uuid.UUID,
 	date time.Time,
 	g *graph.Graph,
 	order []uuid.UUID,
 	dimensions []string,
+	snap *DaySnapshot,
 	costsByNode map[uuid.UUID]map[string]decimal.Decimal,
 	indirectCosts map[uuid.UUID]map[string]decimal.Decimal,
-) ([]models.AllocationResultByDimension, []models.ContributionResultByDimension) {
+) ([]models.AllocationResultByDimension, []models.ContributionResultByDimension, []models.LineageResultByDimension, error) {
 	var allocations []models.AllocationResultByDimension
 	var contributions []models.ContributionResultByDimension
+	lineage := newLineageTracker(runID, date, g.GetFinalCostCentres())
 
 	log.Debug().
 		Int("total_nodes_to_process", len(order)).
 		Msg("Starting allocation traversal")
 
 	for _, nodeID := range order {
+		// The node's own direct cost starts a lineage flow of its own
+		for _, dim := range dimensions {
+			if costsByNode[nodeID] != nil {
+				lineage.seed(nodeID, dim, costsByNode[nodeID][dim])
+			}
+		}
+
 		// Process outgoing edges (allocate to children)
-		nodeContributions := e.allocateFromNode(ctx, runID, date, g, nodeID, dimensions, costsByNode, indirectCosts)
+		nodeContributions, err := e.allocateFromNode(runID, date, g, nodeID, dimensions, snap, costsByNode, indirectCosts, lineage)
+		if err != nil {
+			return nil, nil, nil, err
+		}
 		contributions = append(contributions, nodeContributions...)
+		lineage.release(nodeID)
 
 		// Record allocation result for this node
 		nodeAllocations := e.recordNodeAllocations(runID, date, nodeID, dimensions, costsByNode, indirectCosts)
 		allocations = append(allocations, nodeAllocations...)
 	}
 
-	return allocations, contributions
+	return allocations, contributions, lineage.results, nil
 }
 
 // allocateFromNode allocates costs from a parent node to its children
 func (e *Engine) allocateFromNode(
-	ctx context.Context,
 	runID uuid.UUID,
 	date time.Time,
 	g *graph.Graph,
 	nodeID uuid.UUID,
 	dimensions []string,
+	snap *DaySnapshot,
 	costsByNode map[uuid.UUID]map[string]decimal.Decimal,
 	indirectCosts map[uuid.UUID]map[string]decimal.Decimal,
-) []models.ContributionResultByDimension {
+	lineage *lineageTracker,
+) ([]models.ContributionResultByDimension, error) {
 	var contributions []models.ContributionResultByDimension
 
 	edges := g.Edges(nodeID)
 	if len(edges) == 0 {
-		return contributions // No children to allocate to
+		return contributions, nil // No children to allocate to
 	}
 
 	log.Debug().
@@ -327,21 +921,34 @@ func (e *Engine) allocateFromNode(
 		Int("edge_count", len(edges)).
 		Msg("Processing node for allocation")
 
+	// Contributions to all of the parent's children are worked out, and rounded,
+	// together for each dimension
+	byDim := make(map[string]map[uuid.UUID]childContribution, len(dimensions))
+	for _, dim := range dimensions {
+		contribs, err := e.calculateContributions(nodeID, dim, date, snap, costsByNode, indirectCosts)
+		if err != nil {
+			return nil, err
+		}
+		byDim[dim] = contribs
+	}
+
 	for _, edge := range edges {
 		childID := edge.ChildID
 
 		for _, dim := range dimensions {
-			contribution := e.calculateContribution(ctx, nodeID, childID, edge, dim, date, costsByNode, indirectCosts)
-			if contribution == nil {
+			child, ok := byDim[dim][childID]
+			if !ok {
 				continue
 			}
+			contribution := child.contribution
 
-			// Add to child's indirect costs
+			// Add to child's indirect costs and carry the parent's lineage with it
 			indirectCosts[childID][dim] = indirectCosts[childID][dim].Add(contribution.ContributedAmount)
+			lineage.propagate(nodeID, childID, dim, child.share)
 
 			// Record contribution
 			contribution.RunID = runID
-			contributions = append(contributions, *contribution)
+			contributions = append(contributions, contribution)
 
 			log.Debug().
 				Str("parent_id", nodeID.String()).
@@ -352,19 +959,28 @@ func (e *Engine) allocateFromNode(
 		}
 	}
 
-	return contributions
+	return contributions, nil
 }
 
-// calculateContribution calculates the contribution from parent to child for a dimension
-func (e *Engine) calculateContribution(
-	ctx context.Context,
-	parentID, childID uuid.UUID,
-	edge models.DependencyEdge,
+// childContribution is a parent's contribution to one child and the share of the
+// parent's holistic cost it represents after rounding
+type childContribution struct {
+	contribution models.ContributionResultByDimension
+	share        decimal.Decimal
+}
+
+// calculateContributions calculates the parent's contribution to each of its children
+// for a dimension. When rounding is enabled the contributions are rounded to the
+// currency's minor unit together, so they sum to the parent's cost exactly, and each
+// records the adjustment rounding made to it.
+func (e *Engine) calculateContributions(
+	parentID uuid.UUID,
 	dim string,
 	date time.Time,
+	snap *DaySnapshot,
 	costsByNode map[uuid.UUID]map[string]decimal.Decimal,
 	indirectCosts map[uuid.UUID]map[string]decimal.Decimal,
-) *models.ContributionResultByDimension {
+) (map[uuid.UUID]childContribution, error) {
 	// Calculate parent's holistic cost for this dimension
 	parentDirectDim := decimal.Zero
 	if costsByNode[parentID] != nil {
@@ -374,50 +990,55 @@ func (e *Engine) calculateContribution(
 	parentTotalDim := parentDirectDim.Add(parentIndirectDim)
 
 	if parentTotalDim.IsZero() {
-		return nil // No cost to allocate
+		return nil, nil // No cost to allocate
 	}
 
-	// Resolve and apply allocation strategy
-	strategy, err := e.strategies.ResolveStrategy(ctx, edge, dim, date)
+	// Shares are computed and normalised across all of the parent's children once
+	snap.setParentCost(parentID, dim, parentTotalDim)
+	shares, _, err := snap.SiblingShares(parentID, dim)
 	if err != nil {
-		log.Error().
-			Err(err).
-			Str("edge_id", edge.ID.String()).
-			Str("dimension", dim).
-			Msg("Failed to resolve strategy, using equal allocation")
-		strategy = &Strategy{
-			Type:       models.StrategyEqual,
-			Parameters: make(map[string]interface{}),
-		}
+		return nil, fmt.Errorf("failed to calculate shares: %w", err)
 	}
 
-	// Calculate allocation share
-	share, err := strategy.CalculateShare(ctx, e.store, parentID, childID, dim, date)
-	if err != nil {
-		log.Error().
-			Err(err).
-			Str("strategy", string(strategy.Type)).
-			Str("parent_id", parentID.String()).
-			Str("child_id", childID.String()).
-			Str("dimension", dim).
-			Msg("Failed to calculate share, skipping")
-		return nil
+	exact := make(map[uuid.UUID]decimal.Decimal, len(shares))
+	for childID, share := range shares {
+		if amount := parentTotalDim.Mul(share); !amount.IsZero() {
+			exact[childID] = amount
+		}
 	}
 
-	// Calculate contribution amount
-	contribution := parentTotalDim.Mul(share)
-	if contribution.IsZero() {
-		return nil
+	amounts := exact
+	if e.rounding {
+		amounts = roundContributions(exact, e.roundingPlaces)
 	}
 
-	return &models.ContributionResultByDimension{
-		ParentID:          parentID,
-		ChildID:           childID,
-		ContributionDate:  date,
-		Dimension:         dim,
-		ContributedAmount: contribution,
-		Path:              []uuid.UUID{parentID, childID},
+	contributions := make(map[uuid.UUID]childContribution, len(amounts))
+	for childID, amount := range amounts {
+		if amount.IsZero() {
+			continue
+		}
+
+		// Lineage follows the rounded amount, so the child's flows still sum to its cost
+		share := shares[childID]
+		if e.rounding {
+			share = amount.Div(parentTotalDim)
+		}
+
+		contributions[childID] = childContribution{
+			contribution: models.ContributionResultByDimension{
+				ParentID:           parentID,
+				ChildID:            childID,
+				ContributionDate:   date,
+				Dimension:          dim,
+				ContributedAmount:  amount,
+				RoundingAdjustment: amount.Sub(exact[childID]),
+				Path:               []uuid.UUID{parentID, childID},
+			},
+			share: share,
+		}
 	}
+
+	return contributions, nil
 }
 
 // recordNodeAllocations records the allocation results for a node
@@ -453,46 +1074,201 @@ func (e *Engine) recordNodeAllocations(
 	return allocations
 }
 
-// saveResultsInBatches saves allocation and contribution results in batches
-func (e *Engine) saveResultsInBatches(ctx context.Context, allocations []models.AllocationResultByDimension, contributions []models.ContributionResultByDimension) error {
-	const batchSize = 1000
+// collectUnallocated works out the cost each node retained without being a final
+// cost centre, which is cost the allocation could not place, and the reason for it.
+// Childless nodes retain their whole holistic cost. Parents retain the remainder
+// their child shares left, attributed to the cause SiblingShares recorded; anything
+// retained beyond that remainder is rounding residue.
+func (e *Engine) collectUnallocated(
+	runID uuid.UUID,
+	date time.Time,
+	g *graph.Graph,
+	dimensions []string,
+	snap *DaySnapshot,
+	contributions []models.ContributionResultByDimension,
+	costsByNode map[uuid.UUID]map[string]decimal.Decimal,
+	indirectCosts map[uuid.UUID]map[string]decimal.Decimal,
+) []models.UnallocatedResultByDimension {
+	allocatedOut := make(map[uuid.UUID]map[string]decimal.Decimal)
+	for _, contrib := range contributions {
+		if allocatedOut[contrib.ParentID] == nil {
+			allocatedOut[contrib.ParentID] = make(map[string]decimal.Decimal)
+		}
+		allocatedOut[contrib.ParentID][contrib.Dimension] = allocatedOut[contrib.ParentID][contrib.Dimension].Add(contrib.ContributedAmount)
+	}
+
+	finalCostCentreSet := make(map[uuid.UUID]bool)
+	for _, id := range g.GetFinalCostCentres() {
+		finalCostCentreSet[id] = true
+	}
 
-	// Save allocations in batches
-	for i := 0; i < len(allocations); i += batchSize {
-		end := i + batchSize
-		if end > len(allocations) {
-			end = len(allocations)
+	var results []models.UnallocatedResultByDimension
+	record := func(nodeID uuid.UUID, dim string, reason models.UnallocatedReason, amount decimal.Decimal) {
+		if !amount.IsPositive() {
+			return
 		}
-		
-		batch := allocations[i:end]
-		if err := e.store.Runs.SaveAllocationResults(ctx, batch); err != nil {
-			return fmt.Errorf("failed to save allocation batch %d-%d: %w", i, end, err)
+		results = append(results, models.UnallocatedResultByDimension{
+			RunID:          runID,
+			NodeID:         nodeID,
+			AllocationDate: date,
+			Dimension:      dim,
+			Reason:         reason,
+			Amount:         amount,
+		})
+	}
+
+	for nodeID := range g.Nodes() {
+		if finalCostCentreSet[nodeID] {
+			continue
 		}
+		hasChildren := len(g.Edges(nodeID)) > 0
+
+		for _, dim := range dimensions {
+			holistic := decimal.Zero
+			if costsByNode[nodeID] != nil {
+				holistic = costsByNode[nodeID][dim]
+			}
+			if indirectCosts[nodeID] != nil {
+				holistic = holistic.Add(indirectCosts[nodeID][dim])
+			}
+			retained := holistic.Sub(allocatedOut[nodeID][dim])
+			if !retained.IsPositive() {
+				continue
+			}
+
+			if !hasChildren {
+				record(nodeID, dim, models.UnallocatedReasonNoChildren, retained)
+				continue
+			}
+
+			unplaced := decimal.Zero
+			reason := snap.RemainderReason(nodeID, dim)
+			if reason != "" {
+				_, remainder, _ := snap.SiblingShares(nodeID, dim)
+				unplaced = decimal.Min(holistic.Mul(remainder), retained)
+				record(nodeID, dim, reason, unplaced)
+			}
+			record(nodeID, dim, models.UnallocatedReasonRoundingResidue, retained.Sub(unplaced))
+		}
+	}
+
+	return results
+}
+
+// unallocatedNodeAllocations records the unallocated node's holistic cost for a day,
+// which is everything routed to it, as indirect cost
+func unallocatedNodeAllocations(runID, nodeID uuid.UUID, date time.Time, dimensions []string, unallocated []models.UnallocatedResultByDimension) []models.AllocationResultByDimension {
+	totals := make(map[string]decimal.Decimal, len(dimensions))
+	for _, result := range unallocated {
+		totals[result.Dimension] = totals[result.Dimension].Add(result.Amount)
+	}
+
+	allocations := make([]models.AllocationResultByDimension, 0, len(dimensions))
+	for _, dim := range dimensions {
+		allocations = append(allocations, models.AllocationResultByDimension{
+			RunID:          runID,
+			NodeID:         nodeID,
+			AllocationDate: date,
+			Dimension:      dim,
+			DirectAmount:   decimal.Zero,
+			IndirectAmount: totals[dim],
+			TotalAmount:    totals[dim],
+		})
 	}
 
-	// Save contributions in batches
-	for i := 0; i < len(contributions); i += batchSize {
-		end := i + batchSize
-		if end > len(contributions) {
-			end = len(contributions)
+	return allocations
+}
+
+// persistDay saves a day's results, or copies them forward from an earlier run,
+// and records the day's input fingerprint and checkpoint
+func (e *Engine) persistDay(ctx context.Context, runID uuid.UUID, day *dayResult) error {
+	if day.copyFrom != nil {
+		copied, err := e.store.Runs.CopyDayResults(ctx, *day.copyFrom, runID, day.date)
+		if err != nil {
+			return err
+		}
+
+		// Read the copied allocations back so the run summary stays complete
+		day.allocations, err = e.store.Runs.GetAllocationResults(ctx, runID, store.AllocationResultFilters{
+			StartDate: day.date,
+			EndDate:   day.date,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read copied allocations: %w", err)
+		}
+		day.copiedContributions = int(copied.Contributions)
+		day.copiedLineage = int(copied.Lineage)
+
+		day.reports, err = e.store.Runs.GetInvariantReports(ctx, runID, day.date, day.date)
+		if err != nil {
+			return fmt.Errorf("failed to read copied invariant reports: %w", err)
+		}
+		day.fingerprint.CopiedFromRunID = day.copyFrom
+	} else {
+		if err := e.store.Runs.SaveAllocationResults(ctx, day.allocations); err != nil {
+			return fmt.Errorf("failed to save allocations: %w", err)
+		}
+
+		if err := e.store.Runs.SaveContributionResults(ctx, day.contributions); err != nil {
+			return fmt.Errorf("failed to save contributions: %w", err)
 		}
 
-		batch := contributions[i:end]
-		if err := e.store.Runs.SaveContributionResults(ctx, batch); err != nil {
-			return fmt.Errorf("failed to save contribution batch %d-%d: %w", i, end, err)
+		if err := e.store.Runs.SaveLineageResults(ctx, day.lineage); err != nil {
+			return fmt.Errorf("failed to save lineage: %w", err)
+		}
+
+		if err := e.store.Runs.SaveUnallocatedResults(ctx, day.unallocated); err != nil {
+			return fmt.Errorf("failed to save unallocated results: %w", err)
+		}
+
+		if err := e.store.Runs.SaveRecoveryResults(ctx, day.recoveries); err != nil {
+			return fmt.Errorf("failed to save recovery results: %w", err)
+		}
+
+		if err := e.store.Runs.SaveInvariantReports(ctx, day.reports); err != nil {
+			return fmt.Errorf("failed to save invariant reports: %w", err)
 		}
 	}
 
+	if err := e.store.Runs.SaveDayFingerprint(ctx, &day.fingerprint); err != nil {
+		return fmt.Errorf("failed to save fingerprint: %w", err)
+	}
+
+	// The checkpoint comes last, so a resumed run never skips a partly saved day
+	if err := e.store.Runs.SaveCheckpoint(ctx, runID, day.date); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+
 	return nil
 }
 
-// validateAllocationInvariants checks allocation invariants and logs warnings if violated
+// invariantTolerance is the smallest amount of created or lost cost reported as a violation
+var invariantTolerance = decimal.New(1, -6)
+
+// violatesInvariants reports whether a day's dimension created or lost cost
+func violatesInvariants(report models.AllocationInvariantReport) bool {
+	return report.CreatedCost.GreaterThan(invariantTolerance) || report.LostCost.Abs().GreaterThan(invariantTolerance)
+}
+
+// validateAllocationInvariants checks allocation invariants, logs warnings if they are
+// violated and returns a report per dimension accounting for where the day's direct
+// cost ended up. Every node retains its holistic cost less what it allocated out:
+//   - final cost centres retain allocated cost
+//   - other nodes with children retain the remainder their child shares left unallocated
+//   - other nodes without children retain stranded cost
+//   - parents whose contributions were rounded retain the rounding residue, the
+//     exact contributions less the rounded ones, which is negative for credits
+//   - a negative retained amount is cost created by allocating more than the node held
+//
+// Whatever direct cost those do not account for, such as cost on nodes missing from
+// the day's graph, is reported as lost.
 // Invariants checked:
-// 1. Share validity: For each parent, sum of shares to children <= 1
-// 2. Conservation: Total cost allocated from a node <= node's holistic cost
+// 1. Conservation: Total cost allocated from a node <= node's holistic cost
+// 2. Accounting: Direct cost = final cost centre + unallocated + stranded + rounding residue - created
 // 3. No amplification: Sum of final cost centre costs <= Raw Infrastructure Cost
 func (e *Engine) validateAllocationInvariants(
 	ctx context.Context,
+	runID uuid.UUID,
 	g *graph.Graph,
 	allocations []models.AllocationResultByDimension,
 	contributions []models.ContributionResultByDimension,
@@ -500,67 +1276,120 @@ func (e *Engine) validateAllocationInvariants(
 	indirectCosts map[uuid.UUID]map[string]decimal.Decimal,
 	dimensions []string,
 	date time.Time,
-) {
+) []models.AllocationInvariantReport {
 	// Tolerance for floating point comparisons (0.01% of total)
 	tolerance := decimal.NewFromFloat(0.0001)
 
-	// Build allocation map by node and dimension
-	allocationMap := make(map[uuid.UUID]map[string]decimal.Decimal)
-	for _, alloc := range allocations {
-		if allocationMap[alloc.NodeID] == nil {
-			allocationMap[alloc.NodeID] = make(map[string]decimal.Decimal)
-		}
-		allocationMap[alloc.NodeID][alloc.Dimension] = alloc.TotalAmount
-	}
-
-	// Build contribution map: parent -> dimension -> total contributed
+	// Build contribution and rounding residue maps: parent -> dimension -> total
 	contributionsByParent := make(map[uuid.UUID]map[string]decimal.Decimal)
+	residueByParent := make(map[uuid.UUID]map[string]decimal.Decimal)
 	for _, contrib := range contributions {
 		if contributionsByParent[contrib.ParentID] == nil {
 			contributionsByParent[contrib.ParentID] = make(map[string]decimal.Decimal)
+			residueByParent[contrib.ParentID] = make(map[string]decimal.Decimal)
 		}
 		contributionsByParent[contrib.ParentID][contrib.Dimension] = contributionsByParent[contrib.ParentID][contrib.Dimension].Add(contrib.ContributedAmount)
+		residueByParent[contrib.ParentID][contrib.Dimension] = residueByParent[contrib.ParentID][contrib.Dimension].Sub(contrib.RoundingAdjustment)
 	}
 
-	// Invariant 1: Check share validity (sum of shares <= 1)
-	// This is implicitly checked by ensuring total contributed <= parent's holistic cost
-	for parentID, dimContribs := range contributionsByParent {
-		for dim, totalContributed := range dimContribs {
-			parentHolistic := decimal.Zero
-			if costsByNode[parentID] != nil {
-				parentHolistic = costsByNode[parentID][dim]
-			}
-			if indirectCosts[parentID] != nil {
-				parentHolistic = parentHolistic.Add(indirectCosts[parentID][dim])
+	// Get final cost centres
+	finalCostCentres := g.GetFinalCostCentres()
+	finalCostCentreSet := make(map[uuid.UUID]bool)
+	for _, id := range finalCostCentres {
+		finalCostCentreSet[id] = true
+	}
+
+	reports := make(map[string]*models.AllocationInvariantReport, len(dimensions))
+	for _, dim := range dimensions {
+		reports[dim] = &models.AllocationInvariantReport{
+			RunID:               runID,
+			ReportDate:          date,
+			Dimension:           dim,
+			DirectCost:          decimal.Zero,
+			FinalCostCentreCost: decimal.Zero,
+			UnallocatedCost:     decimal.Zero,
+			RoundingResidue:     decimal.Zero,
+			StrandedCost:        decimal.Zero,
+			CreatedCost:         decimal.Zero,
+			LostCost:            decimal.Zero,
+		}
+	}
+
+	for _, dimCosts := range costsByNode {
+		for dim, cost := range dimCosts {
+			if report, ok := reports[dim]; ok {
+				report.DirectCost = report.DirectCost.Add(cost)
 			}
+		}
+	}
 
-			if !parentHolistic.IsZero() {
-				shareSum := totalContributed.Div(parentHolistic)
-				if shareSum.GreaterThan(decimal.NewFromInt(1).Add(tolerance)) {
-					log.Warn().
-						Str("parent_id", parentID.String()).
-						Str("dimension", dim).
-						Str("share_sum", shareSum.StringFixed(4)).
-						Str("total_contributed", totalContributed.StringFixed(2)).
-						Str("parent_holistic", parentHolistic.StringFixed(2)).
-						Time("date", date).
-						Msg("INVARIANT VIOLATION: Sum of allocation shares exceeds 1")
-				}
+	// Invariant 1: Conservation, and classification of what each node retains
+	for nodeID := range g.Nodes() {
+		hasChildren := len(g.Edges(nodeID)) > 0
+
+		for _, dim := range dimensions {
+			report := reports[dim]
+
+			holistic := decimal.Zero
+			if costsByNode[nodeID] != nil {
+				holistic = costsByNode[nodeID][dim]
+			}
+			if indirectCosts[nodeID] != nil {
+				holistic = holistic.Add(indirectCosts[nodeID][dim])
+			}
+			allocatedOut := contributionsByParent[nodeID][dim]
+			residue := residueByParent[nodeID][dim]
+			report.RoundingResidue = report.RoundingResidue.Add(residue)
+			retained := holistic.Sub(allocatedOut).Sub(residue)
+
+			switch {
+			case retained.IsNegative():
+				report.CreatedCost = report.CreatedCost.Add(retained.Neg())
+				log.Warn().
+					Str("parent_id", nodeID.String()).
+					Str("dimension", dim).
+					Str("total_contributed", allocatedOut.StringFixed(2)).
+					Str("parent_holistic", holistic.StringFixed(2)).
+					Time("date", date).
+					Msg("INVARIANT VIOLATION: Allocated more than the parent's holistic cost")
+			case finalCostCentreSet[nodeID]:
+				report.FinalCostCentreCost = report.FinalCostCentreCost.Add(retained)
+			case hasChildren:
+				report.UnallocatedCost = report.UnallocatedCost.Add(retained)
+			default:
+				report.StrandedCost = report.StrandedCost.Add(retained)
 			}
 		}
 	}
 
-	// Invariant 2: Calculate raw infrastructure cost and final cost centre totals
-	rawInfraCost := make(map[string]decimal.Decimal)
-	finalCostCentreTotal := make(map[string]decimal.Decimal)
+	// Invariant 2: Accounting - whatever is not accounted for was lost
+	result := make([]models.AllocationInvariantReport, 0, len(dimensions))
+	for _, dim := range dimensions {
+		report := reports[dim]
+		report.LostCost = report.DirectCost.
+			Add(report.CreatedCost).
+			Sub(report.FinalCostCentreCost).
+			Sub(report.UnallocatedCost).
+			Sub(report.StrandedCost).
+			Sub(report.RoundingResidue)
+
+		if violatesInvariants(*report) {
+			log.Warn().
+				Str("dimension", dim).
+				Str("direct_cost", report.DirectCost.String()).
+				Str("created_cost", report.CreatedCost.String()).
+				Str("lost_cost", report.LostCost.String()).
+				Time("date", date).
+				Msg("INVARIANT VIOLATION: Allocation created or lost cost")
+		}
 
-	// Get final cost centres
-	finalCostCentres := g.GetFinalCostCentres()
-	finalCostCentreSet := make(map[uuid.UUID]bool)
-	for _, id := range finalCostCentres {
-		finalCostCentreSet[id] = true
+		result = append(result, *report)
 	}
 
+	// Invariant 3: Calculate raw infrastructure cost and final cost centre totals
+	rawInfraCost := make(map[string]decimal.Decimal)
+	finalCostCentreTotal := make(map[string]decimal.Decimal)
+
 	// Calculate raw infrastructure cost (direct costs on infra-like nodes)
 	for nodeID, dimCosts := range costsByNode {
 		node, exists := g.Nodes()[nodeID]
@@ -585,7 +1414,7 @@ func (e *Engine) validateAllocationInvariants(
 		}
 	}
 
-	// Invariant 3: No amplification - final cost centre sum <= raw infra cost
+	// No amplification - final cost centre sum <= raw infra cost
 	for dim, rawCost := range rawInfraCost {
 		finalTotal := finalCostCentreTotal[dim]
 		if finalTotal.GreaterThan(rawCost.Add(rawCost.Mul(tolerance))) {
@@ -606,4 +1435,6 @@ func (e *Engine) validateAllocationInvariants(
 		Interface("final_cost_centre_total", finalCostCentreTotal).
 		Time("date", date).
 		Msg("Allocation invariant checks completed")
+
+	return result
 }
@@ -1,11 +1,19 @@
 package allocate
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
 	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // TestAllocationEngineWithCorrectedEdges verifies that the allocation engine
@@ -109,4 +117,194 @@ func TestAllocationEngineWithCorrectedEdges(t *testing.T) {
 		// 3. Allocate platform service's costs to product (child)
 		// This is the correct behavior we want
 	})
-}
\ No newline at end of file
+}
+func poolDates(n int) []time.Time {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	dates := make([]time.Time, n)
+	for i := range dates {
+		dates[i] = start.AddDate(0, 0, i)
+	}
+	return dates
+}
+
+// TestRunDayPool verifies the per-day worker pool merges results deterministically
+// and stops cleanly on failure or cancellation
+func TestRunDayPool(t *testing.T) {
+	t.Run("emits days in date order whatever order they finish in", func(t *testing.T) {
+		dates := poolDates(30)
+		var inFlight, maxInFlight int32
+
+		var emitted []time.Time
+		err := runDayPool(context.Background(), dates, 4, func(ctx context.Context, date time.Time) dayResult {
+			n := atomic.AddInt32(&inFlight, 1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(time.Duration(rand.Intn(3)) * time.Millisecond)
+			atomic.AddInt32(&inFlight, -1)
+
+			return dayResult{
+				date:        date,
+				allocations: []models.AllocationResultByDimension{{AllocationDate: date, TotalAmount: decimal.NewFromInt(1)}},
+			}
+		}, func(day dayResult) error {
+			emitted = append(emitted, day.date)
+			return nil
+		})
+
+		require.NoError(t, err)
+		assert.Equal(t, dates, emitted)
+		assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(4), "Pool should never exceed its concurrency")
+	})
+
+	t.Run("first failure stops the pool and is returned", func(t *testing.T) {
+		dates := poolDates(50)
+		failure := errors.New("graph has a cycle")
+		var calls int32
+
+		var emitted []time.Time
+		err := runDayPool(context.Background(), dates, 2, func(ctx context.Context, date time.Time) dayResult {
+			atomic.AddInt32(&calls, 1)
+			if date.Equal(dates[5]) {
+				return dayResult{date: date, err: failure}
+			}
+			time.Sleep(time.Millisecond)
+			return dayResult{date: date}
+		}, func(day dayResult) error {
+			emitted = append(emitted, day.date)
+			return nil
+		})
+
+		require.Error(t, err)
+		assert.ErrorIs(t, err, failure)
+		assert.Contains(t, err.Error(), "2024-01-06")
+		require.LessOrEqual(t, len(emitted), 5, "Days after the failure should never be emitted")
+		assert.Equal(t, dates[:len(emitted)], emitted, "Emitted days should be an in-order prefix")
+		assert.Less(t, int(atomic.LoadInt32(&calls)), len(dates), "Remaining days should not be started")
+	})
+
+	t.Run("cancelling the context stops every worker", func(t *testing.T) {
+		dates := poolDates(50)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var calls int32
+
+		err := runDayPool(ctx, dates, 3, func(ctx context.Context, date time.Time) dayResult {
+			if atomic.AddInt32(&calls, 1) == 3 {
+				cancel()
+			}
+			<-ctx.Done()
+			return dayResult{date: date, err: ctx.Err()}
+		}, func(day dayResult) error { return nil })
+
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.LessOrEqual(t, int(atomic.LoadInt32(&calls)), 3+3, "Workers should stop picking up days after cancellation")
+	})
+
+	t.Run("a timeout leaves an in-order prefix of days to resume after", func(t *testing.T) {
+		dates := poolDates(50)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		var emitted []time.Time
+		err := runDayPool(ctx, dates, 3, func(ctx context.Context, date time.Time) dayResult {
+			time.Sleep(2 * time.Millisecond)
+			return dayResult{date: date}
+		}, func(day dayResult) error {
+			emitted = append(emitted, day.date)
+			return nil
+		})
+
+		require.Error(t, err)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Less(t, len(emitted), len(dates))
+		assert.Equal(t, dates[:len(emitted)], emitted, "Checkpoints are only valid if every day before the last one was emitted")
+	})
+
+	t.Run("failure to persist a day stops the pool", func(t *testing.T) {
+		dates := poolDates(50)
+		saveErr := errors.New("connection reset")
+		var calls int32
+
+		var emitted int
+		err := runDayPool(context.Background(), dates, 2, func(ctx context.Context, date time.Time) dayResult {
+			atomic.AddInt32(&calls, 1)
+			return dayResult{date: date}
+		}, func(day dayResult) error {
+			if day.date.Equal(dates[3]) {
+				return saveErr
+			}
+			emitted++
+			return nil
+		})
+
+		assert.ErrorIs(t, err, saveErr)
+		assert.Equal(t, 3, emitted)
+		assert.LessOrEqual(t, int(atomic.LoadInt32(&calls)), 4+2*2, "Workers should not run far ahead of persistence")
+	})
+
+	t.Run("empty range", func(t *testing.T) {
+		err := runDayPool(context.Background(), nil, 4, func(ctx context.Context, date time.Time) dayResult {
+			t.Fatal("allocate should not be called")
+			return dayResult{}
+		}, func(day dayResult) error { return nil })
+		assert.NoError(t, err)
+	})
+}
+
+// TestRunTotals verifies the run summary is built incrementally from each day
+func TestRunTotals(t *testing.T) {
+	resource := uuid.New()
+	product := uuid.New()
+	totals := newRunTotals(map[uuid.UUID]bool{product: true}, uuid.New())
+
+	for day := 0; day < 3; day++ {
+		date := time.Date(2024, 1, 1+day, 0, 0, 0, 0, time.UTC)
+		totals.add(dayResult{
+			date: date,
+			allocations: []models.AllocationResultByDimension{
+				{NodeID: resource, AllocationDate: date, Dimension: "cost", DirectAmount: decimal.NewFromInt(100), TotalAmount: decimal.NewFromInt(100)},
+				{NodeID: product, AllocationDate: date, Dimension: "cost", IndirectAmount: decimal.NewFromInt(80), TotalAmount: decimal.NewFromInt(80)},
+			},
+			contributions: []models.ContributionResultByDimension{
+				{ParentID: resource, ChildID: product, ContributionDate: date, Dimension: "cost", ContributedAmount: decimal.NewFromInt(80)},
+			},
+		})
+	}
+
+	assert.Equal(t, 3, totals.summary.ProcessedDays)
+	assert.Equal(t, 6, totals.summary.AllocationCount)
+	assert.Equal(t, 3, totals.summary.ContributionCount)
+	assert.Equal(t, "300", totals.summary.TotalDirectCost["cost"].String())
+	assert.Equal(t, "240", totals.summary.TotalIndirectCost["cost"].String())
+	assert.Equal(t, "540", totals.summary.TotalCost["cost"].String())
+	assert.InDelta(t, 80.0, totals.coveragePercent(), 0.0001)
+}
+
+// TestCostsInCurrency checks direct costs are converted to the run's currency at
+// the rate on their cost date
+func TestCostsInCurrency(t *testing.T) {
+	node := uuid.New()
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	rates := fx.NewRates([]models.FXRate{
+		{RateDate: day.AddDate(0, 0, -1), FromCurrency: "EUR", ToCurrency: "USD", Rate: decimal.RequireFromString("1.10")},
+	})
+	cost := func(dimension, amount, currency string) models.NodeCostByDimension {
+		return models.NodeCostByDimension{NodeID: node, CostDate: day, Dimension: dimension, Amount: decimal.RequireFromString(amount), Currency: currency}
+	}
+
+	costs, err := costsInCurrency([]models.NodeCostByDimension{
+		cost("compute", "100", "EUR"),
+		cost("storage", "25", "USD"),
+	}, "USD", rates)
+	require.NoError(t, err)
+	assert.Equal(t, "110", costs[node]["compute"].String())
+	assert.Equal(t, "25", costs[node]["storage"].String())
+
+	_, err = costsInCurrency([]models.NodeCostByDimension{cost("compute", "100", "GBP")}, "USD", rates)
+	assert.ErrorContains(t, err, "no FX rate from GBP to USD")
+}
@@ -0,0 +1,123 @@
+package allocate
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// fingerprintVersion is mixed into every day fingerprint. Bump it whenever the
+// allocation semantics change so incremental runs do not copy forward results
+// computed by older logic.
+const fingerprintVersion = "5"
+
+// dayFingerprint combines the checksums of everything a day's allocation depends on.
+// settings describes the engine configuration that changes allocation results.
+func dayFingerprint(graphHash, costChecksum, usageChecksum, strategyChecksum string, dimensions []string, settings string) string {
+	dims := append([]string(nil), dimensions...)
+	sort.Strings(dims)
+
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "version:%s\n", fingerprintVersion)
+	fmt.Fprintf(hasher, "dimensions:%s\n", strings.Join(dims, ","))
+	fmt.Fprintf(hasher, "settings:%s\n", settings)
+	fmt.Fprintf(hasher, "graph:%s\n", graphHash)
+	fmt.Fprintf(hasher, "costs:%s\n", costChecksum)
+	fmt.Fprintf(hasher, "usage:%s\n", usageChecksum)
+	fmt.Fprintf(hasher, "strategies:%s\n", strategyChecksum)
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
+
+// fingerprintSettings describes the engine settings and the run's currency that
+// change allocation results, so a day computed under different settings is never
+// copied forward
+func (e *Engine) fingerprintSettings(currency string) string {
+	rounding := "none"
+	if e.rounding {
+		rounding = fmt.Sprintf("%d", e.roundingPlaces)
+	}
+	return fmt.Sprintf("remainder_policy=%s;zero_usage_fallback=%t;rounding=%s;currency=%s", e.remainderPolicy, e.zeroUsageFallback, rounding, currency)
+}
+
+// costChecksum hashes direct costs by node and dimension in a stable order
+func costChecksum(costsByNode map[uuid.UUID]map[string]decimal.Decimal) string {
+	lines := make([]string, 0, len(costsByNode))
+	for nodeID, dims := range costsByNode {
+		for dim, amount := range dims {
+			lines = append(lines, fmt.Sprintf("%s:%s:%s", nodeID, dim, amount.String()))
+		}
+	}
+	sort.Strings(lines)
+	return checksumLines(lines)
+}
+
+// usageChecksum hashes the usage records a day's strategies read, including labels
+func usageChecksum(usage, labelled []models.NodeUsageByDimension) string {
+	lines := make([]string, 0, len(usage)+len(labelled))
+	for _, u := range usage {
+		lines = append(lines, fmt.Sprintf("u:%s:%s:%s:%s", u.NodeID, u.UsageDate.Format("2006-01-02"), u.Metric, u.Value.String()))
+	}
+	for _, u := range labelled {
+		labels, _ := json.Marshal(u.Labels)
+		lines = append(lines, fmt.Sprintf("l:%s:%s:%s:%s:%s", u.NodeID, u.UsageDate.Format("2006-01-02"), u.Metric, u.Value.String(), labels))
+	}
+	sort.Strings(lines)
+	return checksumLines(lines)
+}
+
+// hourlyUsageChecksum hashes the hourly usage records a day's strategies read
+func hourlyUsageChecksum(hourly []models.NodeUsageHourly) string {
+	lines := make([]string, 0, len(hourly))
+	for _, u := range hourly {
+		lines = append(lines, fmt.Sprintf("h:%s:%s:%s:%s", u.NodeID, u.UsageHour.UTC().Format(time.RFC3339), u.Metric, u.Value.String()))
+	}
+	sort.Strings(lines)
+	return checksumLines(lines)
+}
+
+// strategyChecksum hashes every edge's default strategy and its overrides
+func strategyChecksum(edges []models.DependencyEdge, strategies map[uuid.UUID][]models.EdgeStrategy) string {
+	lines := make([]string, 0, len(edges))
+	for _, edge := range edges {
+		params, _ := json.Marshal(edge.DefaultParameters)
+		lines = append(lines, fmt.Sprintf("e:%s:%s:%s:%s:%s", edge.ID, edge.ParentID, edge.ChildID, edge.DefaultStrategy, params))
+
+		for _, override := range strategies[edge.ID] {
+			dim := "*"
+			if override.Dimension != nil {
+				dim = *override.Dimension
+			}
+			params, _ := json.Marshal(override.Parameters)
+			lines = append(lines, fmt.Sprintf("s:%s:%s:%s:%s", edge.ID, dim, override.Strategy, params))
+		}
+	}
+	sort.Strings(lines)
+	return checksumLines(lines)
+}
+
+// nodeLabelChecksum hashes every node's cost labels
+func nodeLabelChecksum(nodes map[uuid.UUID]*models.CostNode) string {
+	lines := make([]string, 0, len(nodes))
+	for id, node := range nodes {
+		labels, _ := json.Marshal(node.CostLabels)
+		lines = append(lines, fmt.Sprintf("n:%s:%s", id, labels))
+	}
+	sort.Strings(lines)
+	return checksumLines(lines)
+}
+
+func checksumLines(lines []string) string {
+	hasher := sha256.New()
+	for _, line := range lines {
+		hasher.Write([]byte(line))
+		hasher.Write([]byte{'\n'})
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil))
+}
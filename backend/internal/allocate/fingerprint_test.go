@@ -0,0 +1,108 @@
+package allocate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDayFingerprint verifies that a day's fingerprint is stable for identical inputs
+// and changes whenever anything the allocation reads changes
+func TestDayFingerprint(t *testing.T) {
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	nodeA := uuid.New()
+	nodeB := uuid.New()
+
+	t.Run("cost checksum ignores map order and trailing zeros", func(t *testing.T) {
+		first := map[uuid.UUID]map[string]decimal.Decimal{
+			nodeA: {"cost": decimal.RequireFromString("100.000000000"), "egress_gb": decimal.NewFromInt(5)},
+			nodeB: {"cost": decimal.NewFromInt(50)},
+		}
+		second := map[uuid.UUID]map[string]decimal.Decimal{
+			nodeB: {"cost": decimal.NewFromInt(50)},
+			nodeA: {"egress_gb": decimal.NewFromInt(5), "cost": decimal.NewFromInt(100)},
+		}
+		assert.Equal(t, costChecksum(first), costChecksum(second))
+
+		second[nodeB]["cost"] = decimal.NewFromFloat(50.01)
+		assert.NotEqual(t, costChecksum(first), costChecksum(second), "A restated cost should change the checksum")
+	})
+
+	t.Run("usage checksum covers values and labels", func(t *testing.T) {
+		usage := []models.NodeUsageByDimension{
+			{NodeID: nodeA, UsageDate: date, Metric: "requests", Value: decimal.NewFromInt(10)},
+			{NodeID: nodeB, UsageDate: date, Metric: "requests", Value: decimal.NewFromInt(20)},
+		}
+		reordered := []models.NodeUsageByDimension{usage[1], usage[0]}
+		assert.Equal(t, usageChecksum(usage, nil), usageChecksum(reordered, nil))
+
+		labelled := []models.NodeUsageByDimension{
+			{NodeID: nodeA, UsageDate: date, Metric: "requests", Value: decimal.NewFromInt(10), Labels: map[string]string{"customer_id": "acme"}},
+		}
+		relabelled := []models.NodeUsageByDimension{
+			{NodeID: nodeA, UsageDate: date, Metric: "requests", Value: decimal.NewFromInt(10), Labels: map[string]string{"customer_id": "globex"}},
+		}
+		assert.NotEqual(t, usageChecksum(usage, labelled), usageChecksum(usage, relabelled))
+	})
+
+	t.Run("strategy checksum covers defaults and overrides", func(t *testing.T) {
+		edge := models.DependencyEdge{
+			ID:                uuid.New(),
+			ParentID:          nodeA,
+			ChildID:           nodeB,
+			DefaultStrategy:   string(models.StrategyProportionalOn),
+			DefaultParameters: map[string]interface{}{"metric": "requests"},
+		}
+		base := strategyChecksum([]models.DependencyEdge{edge}, nil)
+
+		changedParams := edge
+		changedParams.DefaultParameters = map[string]interface{}{"metric": "cpu_hours"}
+		assert.NotEqual(t, base, strategyChecksum([]models.DependencyEdge{changedParams}, nil))
+
+		dim := "egress_gb"
+		overridden := strategyChecksum([]models.DependencyEdge{edge}, map[uuid.UUID][]models.EdgeStrategy{
+			edge.ID: {{EdgeID: edge.ID, Dimension: &dim, Strategy: string(models.StrategyEqual)}},
+		})
+		assert.NotEqual(t, base, overridden)
+	})
+
+	t.Run("fingerprint depends on every component, the dimension set and settings", func(t *testing.T) {
+		base := dayFingerprint("graph", "costs", "usage", "strategies", []string{"cost", "egress_gb"}, "")
+
+		assert.Equal(t, base, dayFingerprint("graph", "costs", "usage", "strategies", []string{"egress_gb", "cost"}, ""),
+			"Dimension order should not matter")
+		assert.NotEqual(t, base, dayFingerprint("graph2", "costs", "usage", "strategies", []string{"cost", "egress_gb"}, ""))
+		assert.NotEqual(t, base, dayFingerprint("graph", "costs2", "usage", "strategies", []string{"cost", "egress_gb"}, ""))
+		assert.NotEqual(t, base, dayFingerprint("graph", "costs", "usage2", "strategies", []string{"cost", "egress_gb"}, ""))
+		assert.NotEqual(t, base, dayFingerprint("graph", "costs", "usage", "strategies2", []string{"cost", "egress_gb"}, ""))
+		assert.NotEqual(t, base, dayFingerprint("graph", "costs", "usage", "strategies", []string{"cost"}, ""))
+		assert.NotEqual(t, base, dayFingerprint("graph", "costs", "usage", "strategies", []string{"cost", "egress_gb"}, "remainder_policy=renormalise"))
+	})
+}
+
+// TestRunTotalsCopiedDays verifies copied-forward days count towards the run summary
+func TestRunTotalsCopiedDays(t *testing.T) {
+	previousRun := uuid.New()
+	node := uuid.New()
+	totals := newRunTotals(nil, uuid.Nil)
+
+	totals.add(dayResult{
+		date:                time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		allocations:         []models.AllocationResultByDimension{{NodeID: node, Dimension: "cost", DirectAmount: decimal.NewFromInt(10), TotalAmount: decimal.NewFromInt(10)}},
+		copyFrom:            &previousRun,
+		copiedContributions: 4,
+	})
+	totals.add(dayResult{
+		date:        time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+		allocations: []models.AllocationResultByDimension{{NodeID: node, Dimension: "cost", DirectAmount: decimal.NewFromInt(12), TotalAmount: decimal.NewFromInt(12)}},
+	})
+
+	assert.Equal(t, 2, totals.summary.ProcessedDays)
+	assert.Equal(t, 1, totals.summary.CopiedDays)
+	assert.Equal(t, 4, totals.summary.ContributionCount)
+	assert.Equal(t, "22", totals.summary.TotalDirectCost["cost"].String())
+}
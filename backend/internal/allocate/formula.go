@@ -0,0 +1,179 @@
+package allocate
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// calculateFormulaShares splits a parent's cost by a per-child weight computed
+// from a formula over the child's usage, cost labels and constants.
+//
+// Parameters:
+//   - expression: the formula giving each child's weight (required)
+//   - constants: named numbers the formula may refer to
+//   - fixed_percent: portion of the cost split equally before the formula applies
+//   - min_percent: minimum share of every child, funded by the children above it
+func (s *Strategy) calculateFormulaShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+	expr, err := models.ParseFormulaParameters(s.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("formula strategy: %w", err)
+	}
+
+	fixedPercent, err := s.percentParameter("fixed_percent")
+	if err != nil {
+		return nil, err
+	}
+	minPercent, err := s.percentParameter("min_percent")
+	if err != nil {
+		return nil, err
+	}
+
+	edges := snap.ChildEdges(parentID)
+	if len(edges) == 0 {
+		return map[uuid.UUID]decimal.Decimal{}, nil
+	}
+
+	// Evaluate the formula for every child
+	var totalWeight decimal.Decimal
+	weights := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	for _, edge := range edges {
+		weight, err := expr.Evaluate(formulaEnv{snap: snap, nodeID: edge.ChildID})
+		if err != nil {
+			return nil, fmt.Errorf("child %s: %w", edge.ChildID, err)
+		}
+		if weight.IsNegative() {
+			return nil, fmt.Errorf("formula gave child %s a negative weight %s", edge.ChildID, weight)
+		}
+		weights[edge.ChildID] = weight
+		totalWeight = totalWeight.Add(weight)
+	}
+
+	variable := zeroUsageShares(snap, edges)
+	if !totalWeight.IsZero() {
+		variable = make(map[uuid.UUID]decimal.Decimal, len(weights))
+		for childID, weight := range weights {
+			variable[childID] = weight.Div(totalWeight)
+		}
+	}
+
+	// Blend the equal portion with the formula's portion
+	fixedShare := fixedPercent.Div(decimal.NewFromInt(int64(len(edges))))
+	variablePercent := decimal.NewFromInt(1).Sub(fixedPercent)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	for _, edge := range edges {
+		shares[edge.ChildID] = fixedShare.Add(variablePercent.Mul(variable[edge.ChildID]))
+	}
+
+	if minPercent.IsPositive() {
+		shares = floorShares(shares, minPercent)
+	}
+	return shares, nil
+}
+
+// formulaMetrics returns the usage metrics the formula strategy's expression reads
+func (s *Strategy) formulaMetrics() []string {
+	expr, err := models.ParseFormulaParameters(s.Parameters)
+	if err != nil {
+		return nil
+	}
+	return expr.Metrics()
+}
+
+// percentParameter reads an optional percentage parameter as a fraction of 1,
+// accepting either a percentage (25) or a fraction (0.25)
+func (s *Strategy) percentParameter(name string) (decimal.Decimal, error) {
+	raw, ok := s.Parameters[name]
+	if !ok || raw == nil {
+		return decimal.Zero, nil
+	}
+
+	var percent decimal.Decimal
+	switch v := raw.(type) {
+	case float64:
+		percent = decimal.NewFromFloat(v)
+	case string:
+		var err error
+		percent, err = decimal.NewFromString(v)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("invalid %s value: %v", name, v)
+		}
+	default:
+		return decimal.Zero, fmt.Errorf("%s parameter must be float64 or string, got %T", name, v)
+	}
+
+	if percent.GreaterThan(decimal.NewFromInt(1)) {
+		percent = percent.Div(decimal.NewFromInt(100))
+	}
+	return percent, nil
+}
+
+// floorShares raises every share below the floor to it, funding the increase from
+// the children above it in proportion to their shares, and repeats until no new
+// child falls below the floor. The shares keep their total; when that total cannot
+// give every child the floor it is split equally instead.
+func floorShares(shares map[uuid.UUID]decimal.Decimal, floor decimal.Decimal) map[uuid.UUID]decimal.Decimal {
+	total := decimal.Zero
+	for _, share := range shares {
+		total = total.Add(share)
+	}
+
+	count := decimal.NewFromInt(int64(len(shares)))
+	if floor.Mul(count).GreaterThanOrEqual(total) {
+		result := make(map[uuid.UUID]decimal.Decimal, len(shares))
+		for childID := range shares {
+			result[childID] = total.Div(count)
+		}
+		return result
+	}
+
+	floored := make(map[uuid.UUID]bool, len(shares))
+	result := make(map[uuid.UUID]decimal.Decimal, len(shares))
+	for {
+		free := total.Sub(floor.Mul(decimal.NewFromInt(int64(len(floored)))))
+		unflooredTotal := decimal.Zero
+		for childID, share := range shares {
+			if !floored[childID] {
+				unflooredTotal = unflooredTotal.Add(share)
+			}
+		}
+
+		newlyFloored := false
+		for childID, share := range shares {
+			if floored[childID] {
+				result[childID] = floor
+				continue
+			}
+
+			scaled := decimal.Zero
+			if !unflooredTotal.IsZero() {
+				scaled = free.Mul(share).Div(unflooredTotal)
+			}
+			if scaled.LessThan(floor) {
+				floored[childID] = true
+				newlyFloored = true
+			}
+			result[childID] = scaled
+		}
+
+		if !newlyFloored {
+			return result
+		}
+	}
+}
+
+// formulaEnv evaluates a formula against one node of a day snapshot
+type formulaEnv struct {
+	snap   *DaySnapshot
+	nodeID uuid.UUID
+}
+
+func (e formulaEnv) Usage(metric string) decimal.Decimal {
+	return e.snap.UsageOn(e.nodeID, metric)
+}
+
+func (e formulaEnv) Label(key string) (string, bool) {
+	return e.snap.NodeLabel(e.nodeID, key)
+}
@@ -0,0 +1,142 @@
+package allocate
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestFormulaShares checks the formula strategy against hand-computed share vectors
+func TestFormulaShares(t *testing.T) {
+	parent := uuid.New()
+	childA, childB, childC := uuid.New(), uuid.New(), uuid.New()
+	children := []uuid.UUID{childA, childB, childC}
+
+	usage := []models.NodeUsageByDimension{
+		usageRecord(childA, snapshotDate, "requests", 80),
+		usageRecord(childA, snapshotDate, "cpu_hours", 20),
+		usageRecord(childB, snapshotDate, "requests", 300),
+		usageRecord(childC, snapshotDate, "requests", 500),
+		usageRecord(childC, snapshotDate, "cpu_hours", 100),
+	}
+	nodes := map[uuid.UUID]*models.CostNode{
+		childA: {ID: childA, CostLabels: map[string]interface{}{"tier": "gold", "weight": 3}},
+		childB: {ID: childB, CostLabels: map[string]interface{}{"tier": "silver"}},
+		childC: {ID: childC, CostLabels: map[string]interface{}{}},
+	}
+
+	shares := func(t *testing.T, params map[string]interface{}) map[uuid.UUID]decimal.Decimal {
+		t.Helper()
+		var edges []models.DependencyEdge
+		for _, child := range children {
+			edges = append(edges, strategyEdge(parent, child, models.StrategyFormula, params))
+		}
+		snap := newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+		snap.attachNodes(nodes)
+
+		result, err := snap.Shares(snap.ResolveStrategy(edges[0], "cost"), parent, "cost")
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("weights by the expression", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"expression": "requests + cpu_hours"})
+		assertShare(t, "0.1000", result[childA])
+		assertShare(t, "0.3000", result[childB])
+		assertShare(t, "0.6000", result[childC])
+	})
+
+	t.Run("constants and labels", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{
+			"expression": "if(label('tier') == 'gold', boost, 1) * label_number('weight', 1)",
+			"constants":  map[string]interface{}{"boost": 2.0},
+		})
+		assertShare(t, "0.7500", result[childA])
+		assertShare(t, "0.1250", result[childB])
+		assertShare(t, "0.1250", result[childC])
+	})
+
+	t.Run("equal portion blended with the formula", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"expression": "requests + cpu_hours", "fixed_percent": 40.0})
+		assertShare(t, "0.1933", result[childA])
+		assertShare(t, "0.3133", result[childB])
+		assertShare(t, "0.4933", result[childC])
+	})
+
+	t.Run("minimum share funded by the larger children", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"expression": "requests + cpu_hours", "min_percent": 25.0})
+		assertShare(t, "0.2500", result[childA])
+		assertShare(t, "0.2500", result[childB])
+		assertShare(t, "0.5000", result[childC])
+	})
+
+	t.Run("no weight falls back to equal shares", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"expression": "unused_metric * 2"})
+		for _, child := range children {
+			assertShare(t, "0.3333", result[child])
+		}
+	})
+
+	t.Run("negative weights are rejected", func(t *testing.T) {
+		edge := strategyEdge(parent, childA, models.StrategyFormula, map[string]interface{}{"expression": "requests - 1000"})
+		snap := newDaySnapshot(snapshotDate, []models.DependencyEdge{edge}, nil, usage, nil)
+		_, err := snap.Shares(snap.ResolveStrategy(edge, "cost"), parent, "cost")
+		assert.ErrorContains(t, err, "negative weight")
+	})
+
+	t.Run("invalid formulas are rejected before running", func(t *testing.T) {
+		edge := strategyEdge(parent, childA, models.StrategyFormula, map[string]interface{}{"expression": "requests +"})
+		snap := newDaySnapshot(snapshotDate, []models.DependencyEdge{edge}, nil, usage, nil)
+		_, err := snap.Shares(snap.ResolveStrategy(edge, "cost"), parent, "cost")
+		assert.ErrorContains(t, err, "invalid formula")
+	})
+}
+
+// TestFormulaRequirements checks the snapshot loads what a formula reads
+func TestFormulaRequirements(t *testing.T) {
+	parent, child := uuid.New(), uuid.New()
+	edges := []models.DependencyEdge{
+		strategyEdge(parent, child, models.StrategyFormula, map[string]interface{}{"expression": "requests * 0.7 + usage('cpu_hours') * 0.3"}),
+	}
+
+	reqs := collectUsageRequirements(edges, nil)
+	assert.ElementsMatch(t, []string{"cpu_hours", "requests"}, reqs.metrics)
+	assert.True(t, reqs.needsNodeLabels)
+
+	t.Run("label changes change the strategy checksum", func(t *testing.T) {
+		checksum := func(tier string) string {
+			snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+			snap.needsNodeLabels = reqs.needsNodeLabels
+			snap.attachNodes(map[uuid.UUID]*models.CostNode{child: {ID: child, CostLabels: map[string]interface{}{"tier": tier}}})
+			return snap.strategyChecksum
+		}
+		assert.Equal(t, checksum("gold"), checksum("gold"))
+		assert.NotEqual(t, checksum("gold"), checksum("silver"))
+	})
+}
+
+func TestFloorShares(t *testing.T) {
+	childA, childB := uuid.New(), uuid.New()
+
+	t.Run("an unreachable floor splits equally", func(t *testing.T) {
+		result := floorShares(map[uuid.UUID]decimal.Decimal{
+			childA: decimal.RequireFromString("0.9"),
+			childB: decimal.RequireFromString("0.1"),
+		}, decimal.RequireFromString("0.6"))
+		assertShare(t, "0.5000", result[childA])
+		assertShare(t, "0.5000", result[childB])
+	})
+
+	t.Run("the total is preserved", func(t *testing.T) {
+		result := floorShares(map[uuid.UUID]decimal.Decimal{
+			childA: decimal.RequireFromString("0.95"),
+			childB: decimal.RequireFromString("0.05"),
+		}, decimal.RequireFromString("0.1"))
+		assertShare(t, "0.9000", result[childA])
+		assertShare(t, "0.1000", result[childB])
+	})
+}
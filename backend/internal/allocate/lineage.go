@@ -0,0 +1,95 @@
+package allocate
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// lineageFlow is the part of a node's holistic cost that originated on a single
+// node and reached it along a single path
+type lineageFlow struct {
+	origin uuid.UUID
+	path   []uuid.UUID
+	amount decimal.Decimal
+}
+
+// lineageTracker propagates per-origin cost vectors through the graph alongside
+// the allocation traversal. Every node's holistic cost is held as a set of flows
+// keyed by where the cost was first incurred and the path it took; when a node
+// allocates to a child each flow is scaled by the same share as the contribution,
+// so the flows arriving at a node always sum to its holistic cost.
+type lineageTracker struct {
+	runID            uuid.UUID
+	date             time.Time
+	finalCostCentres map[uuid.UUID]bool
+	flows            map[uuid.UUID]map[string][]lineageFlow
+	results          []models.LineageResultByDimension
+}
+
+func newLineageTracker(runID uuid.UUID, date time.Time, finalCostCentres []uuid.UUID) *lineageTracker {
+	finalSet := make(map[uuid.UUID]bool, len(finalCostCentres))
+	for _, id := range finalCostCentres {
+		finalSet[id] = true
+	}
+
+	return &lineageTracker{
+		runID:            runID,
+		date:             date,
+		finalCostCentres: finalSet,
+		flows:            make(map[uuid.UUID]map[string][]lineageFlow),
+	}
+}
+
+// seed records a node's direct cost as a flow originating on the node itself
+func (t *lineageTracker) seed(nodeID uuid.UUID, dim string, direct decimal.Decimal) {
+	if direct.IsZero() {
+		return
+	}
+	t.append(nodeID, dim, lineageFlow{origin: nodeID, path: []uuid.UUID{nodeID}, amount: direct})
+}
+
+// propagate passes a share of every flow held by the parent on to the child
+func (t *lineageTracker) propagate(parentID, childID uuid.UUID, dim string, share decimal.Decimal) {
+	for _, flow := range t.flows[parentID][dim] {
+		amount := flow.amount.Mul(share)
+		if amount.IsZero() {
+			continue
+		}
+
+		path := make([]uuid.UUID, len(flow.path), len(flow.path)+1)
+		copy(path, flow.path)
+		t.append(childID, dim, lineageFlow{origin: flow.origin, path: append(path, childID), amount: amount})
+	}
+}
+
+// release is called once a node has allocated to its children. Flows that ended
+// on a final cost centre become lineage results; the node's flows are then
+// dropped since nothing later in the topological order reads them.
+func (t *lineageTracker) release(nodeID uuid.UUID) {
+	if t.finalCostCentres[nodeID] {
+		for dim, flows := range t.flows[nodeID] {
+			for _, flow := range flows {
+				t.results = append(t.results, models.LineageResultByDimension{
+					RunID:        t.runID,
+					OriginID:     flow.origin,
+					CostCentreID: nodeID,
+					LineageDate:  t.date,
+					Dimension:    dim,
+					Amount:       flow.amount,
+					Path:         flow.path,
+				})
+			}
+		}
+	}
+	delete(t.flows, nodeID)
+}
+
+func (t *lineageTracker) append(nodeID uuid.UUID, dim string, flow lineageFlow) {
+	if t.flows[nodeID] == nil {
+		t.flows[nodeID] = make(map[string][]lineageFlow)
+	}
+	t.flows[nodeID][dim] = append(t.flows[nodeID][dim], flow)
+}
@@ -0,0 +1,87 @@
+package allocate
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLineageTraversal checks that lineage follows cost across multiple hops.
+//
+//	db ($1000, proportional on requests) → platform (30%), app (70%)
+//	platform ($100, equal) → app, web
+func TestLineageTraversal(t *testing.T) {
+	db := models.CostNode{ID: uuid.New(), Name: "db", Type: string(models.NodeTypeShared)}
+	platform := models.CostNode{ID: uuid.New(), Name: "platform", Type: string(models.NodeTypePlatform)}
+	app := models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	nodes := []models.CostNode{db, platform, app, web}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(db.ID, platform.ID, models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}),
+		strategyEdge(db.ID, app.ID, models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}),
+		strategyEdge(platform.ID, app.ID, models.StrategyEqual, nil),
+		strategyEdge(platform.ID, web.ID, models.StrategyEqual, nil),
+	}
+	usage := []models.NodeUsageByDimension{
+		usageRecord(platform.ID, snapshotDate, "requests", 30),
+		usageRecord(app.ID, snapshotDate, "requests", 70),
+	}
+
+	g := graph.NewGraph(snapshotDate, nodes, edges)
+	order, err := g.TopologicalSort()
+	require.NoError(t, err)
+
+	dimensions := []string{"cost"}
+	costsByNode := map[uuid.UUID]map[string]decimal.Decimal{
+		db.ID:       {"cost": decimal.NewFromInt(1000)},
+		platform.ID: {"cost": decimal.NewFromInt(100)},
+	}
+
+	e := &Engine{}
+	snap := newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+	indirectCosts := e.initializeIndirectCosts(g, dimensions)
+	allocations, _, lineage, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+	require.NoError(t, err)
+
+	type flowKey struct {
+		costCentre uuid.UUID
+		path       string
+	}
+	pathKey := func(path ...uuid.UUID) string {
+		var key string
+		for _, id := range path {
+			key += id.String() + ">"
+		}
+		return key
+	}
+
+	flows := make(map[flowKey]string)
+	byCostCentre := make(map[uuid.UUID]decimal.Decimal)
+	for _, l := range lineage {
+		assert.Equal(t, l.Path[0], l.OriginID, "path starts at the origin")
+		assert.Equal(t, l.Path[len(l.Path)-1], l.CostCentreID, "path ends at the cost centre")
+		flows[flowKey{l.CostCentreID, pathKey(l.Path...)}] = l.Amount.StringFixed(2)
+		byCostCentre[l.CostCentreID] = byCostCentre[l.CostCentreID].Add(l.Amount)
+	}
+
+	assert.Len(t, lineage, 5)
+	assert.Equal(t, "700.00", flows[flowKey{app.ID, pathKey(db.ID, app.ID)}])
+	assert.Equal(t, "150.00", flows[flowKey{app.ID, pathKey(db.ID, platform.ID, app.ID)}], "db cost reaches app through platform")
+	assert.Equal(t, "50.00", flows[flowKey{app.ID, pathKey(platform.ID, app.ID)}])
+	assert.Equal(t, "150.00", flows[flowKey{web.ID, pathKey(db.ID, platform.ID, web.ID)}])
+	assert.Equal(t, "50.00", flows[flowKey{web.ID, pathKey(platform.ID, web.ID)}])
+
+	// Lineage for each cost centre reconciles to its holistic cost
+	for _, alloc := range allocations {
+		if alloc.NodeID == app.ID || alloc.NodeID == web.ID {
+			assert.True(t, alloc.TotalAmount.Equal(byCostCentre[alloc.NodeID]),
+				"lineage for %s should sum to %s, got %s", alloc.NodeID, alloc.TotalAmount, byCostCentre[alloc.NodeID])
+		}
+	}
+}
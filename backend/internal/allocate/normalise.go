@@ -0,0 +1,124 @@
+package allocate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// RemainderPolicy controls what happens to the part of a parent's cost that its
+// children's shares do not cover
+type RemainderPolicy string
+
+const (
+	// RemainderToUnallocated leaves the remainder on the parent as unallocated cost
+	RemainderToUnallocated RemainderPolicy = "to_unallocated"
+	// RemainderRenormalise scales the children's shares up so they sum to 1
+	RemainderRenormalise RemainderPolicy = "renormalise"
+	// RemainderFail fails the allocation when shares do not sum to 1
+	RemainderFail RemainderPolicy = "fail"
+)
+
+// ParseRemainderPolicy parses a remainder policy, defaulting to to_unallocated when empty
+func ParseRemainderPolicy(s string) (RemainderPolicy, error) {
+	switch RemainderPolicy(s) {
+	case "":
+		return RemainderToUnallocated, nil
+	case RemainderToUnallocated, RemainderRenormalise, RemainderFail:
+		return RemainderPolicy(s), nil
+	default:
+		return "", fmt.Errorf("invalid remainder policy %q: must be one of %s, %s, %s",
+			s, RemainderToUnallocated, RemainderRenormalise, RemainderFail)
+	}
+}
+
+// shareDust is the largest deviation from 1 treated as division rounding rather
+// than a real remainder. Dust is absorbed by the largest share so the vector sums
+// to exactly 1 and no cost is created or lost to rounding.
+var shareDust = decimal.New(1, -12)
+
+// normaliseShares turns the raw shares of a parent's children into a vector that
+// never allocates more than the parent's cost. Shares over 1 are always scaled
+// back unless the policy is fail; shares under 1 are handled by the policy. It
+// returns the normalised shares and the share of the parent's cost left unallocated.
+func normaliseShares(raw map[uuid.UUID]decimal.Decimal, policy RemainderPolicy) (map[uuid.UUID]decimal.Decimal, decimal.Decimal, error) {
+	one := decimal.NewFromInt(1)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(raw))
+	if len(raw) == 0 {
+		return shares, decimal.Zero, nil
+	}
+
+	// Negative shares would pull cost back up the graph
+	sum := decimal.Zero
+	for childID, share := range raw {
+		if share.IsNegative() {
+			share = decimal.Zero
+		}
+		shares[childID] = share
+		sum = sum.Add(share)
+	}
+
+	if sum.IsZero() {
+		if policy == RemainderFail {
+			return nil, decimal.Zero, fmt.Errorf("no child has a share of the parent's cost")
+		}
+		// Nothing to renormalise against, so the whole cost stays unallocated
+		return shares, one, nil
+	}
+
+	remainder := one.Sub(sum)
+	switch {
+	case remainder.Abs().LessThanOrEqual(shareDust):
+		absorbDust(shares, remainder)
+		return shares, decimal.Zero, nil
+
+	case remainder.IsNegative():
+		if policy == RemainderFail {
+			return nil, decimal.Zero, fmt.Errorf("child shares sum to %s, exceeding 1", sum.String())
+		}
+		return scaleShares(shares, sum), decimal.Zero, nil
+
+	default:
+		switch policy {
+		case RemainderRenormalise:
+			return scaleShares(shares, sum), decimal.Zero, nil
+		case RemainderFail:
+			return nil, decimal.Zero, fmt.Errorf("child shares sum to %s, leaving %s unallocated", sum.String(), remainder.String())
+		default:
+			return shares, remainder, nil
+		}
+	}
+}
+
+// scaleShares divides every share by their sum and absorbs the division dust
+func scaleShares(shares map[uuid.UUID]decimal.Decimal, sum decimal.Decimal) map[uuid.UUID]decimal.Decimal {
+	total := decimal.Zero
+	for childID, share := range shares {
+		shares[childID] = share.Div(sum)
+		total = total.Add(shares[childID])
+	}
+	absorbDust(shares, decimal.NewFromInt(1).Sub(total))
+	return shares
+}
+
+// absorbDust adds a rounding difference to the largest share, breaking ties by child ID
+func absorbDust(shares map[uuid.UUID]decimal.Decimal, dust decimal.Decimal) {
+	if dust.IsZero() || len(shares) == 0 {
+		return
+	}
+
+	ids := make([]uuid.UUID, 0, len(shares))
+	for childID := range shares {
+		ids = append(ids, childID)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if cmp := shares[ids[i]].Cmp(shares[ids[j]]); cmp != 0 {
+			return cmp > 0
+		}
+		return ids[i].String() < ids[j].String()
+	})
+
+	shares[ids[0]] = shares[ids[0]].Add(dust)
+}
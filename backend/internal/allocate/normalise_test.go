@@ -0,0 +1,196 @@
+package allocate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sumShares(shares map[uuid.UUID]decimal.Decimal) decimal.Decimal {
+	total := decimal.Zero
+	for _, share := range shares {
+		total = total.Add(share)
+	}
+	return total
+}
+
+// TestNormaliseShares checks each remainder policy against under and over allocation
+func TestNormaliseShares(t *testing.T) {
+	childA := uuid.New()
+	childB := uuid.New()
+	one := decimal.NewFromInt(1)
+
+	under := func() map[uuid.UUID]decimal.Decimal {
+		return map[uuid.UUID]decimal.Decimal{childA: decimal.NewFromFloat(0.25), childB: decimal.NewFromFloat(0.5)}
+	}
+	over := func() map[uuid.UUID]decimal.Decimal {
+		return map[uuid.UUID]decimal.Decimal{childA: decimal.NewFromFloat(0.6), childB: decimal.NewFromFloat(0.6)}
+	}
+
+	t.Run("to_unallocated keeps shares and reports the remainder", func(t *testing.T) {
+		shares, remainder, err := normaliseShares(under(), RemainderToUnallocated)
+		require.NoError(t, err)
+		assertShare(t, "0.2500", shares[childA])
+		assertShare(t, "0.5000", shares[childB])
+		assertShare(t, "0.2500", remainder)
+	})
+
+	t.Run("renormalise scales shares to sum to exactly 1", func(t *testing.T) {
+		shares, remainder, err := normaliseShares(under(), RemainderRenormalise)
+		require.NoError(t, err)
+		assertShare(t, "0.3333", shares[childA])
+		assertShare(t, "0.6667", shares[childB])
+		assert.True(t, remainder.IsZero())
+		assert.True(t, sumShares(shares).Equal(one), "shares sum to %s", sumShares(shares))
+	})
+
+	t.Run("fail rejects a remainder", func(t *testing.T) {
+		_, _, err := normaliseShares(under(), RemainderFail)
+		assert.Error(t, err)
+	})
+
+	t.Run("over allocation is scaled back under every non-failing policy", func(t *testing.T) {
+		for _, policy := range []RemainderPolicy{RemainderToUnallocated, RemainderRenormalise} {
+			shares, remainder, err := normaliseShares(over(), policy)
+			require.NoError(t, err)
+			assertShare(t, "0.5000", shares[childA])
+			assertShare(t, "0.5000", shares[childB])
+			assert.True(t, remainder.IsZero())
+		}
+
+		_, _, err := normaliseShares(over(), RemainderFail)
+		assert.Error(t, err)
+	})
+
+	t.Run("division dust is absorbed by the largest share", func(t *testing.T) {
+		third := one.Div(decimal.NewFromInt(3))
+		childC := uuid.New()
+		shares, remainder, err := normaliseShares(map[uuid.UUID]decimal.Decimal{childA: third, childB: third, childC: third}, RemainderFail)
+		require.NoError(t, err)
+		assert.True(t, remainder.IsZero())
+		assert.True(t, sumShares(shares).Equal(one), "shares sum to %s", sumShares(shares))
+	})
+
+	t.Run("negative shares are clamped to zero", func(t *testing.T) {
+		shares, _, err := normaliseShares(map[uuid.UUID]decimal.Decimal{childA: decimal.NewFromFloat(-0.5), childB: one}, RemainderFail)
+		require.NoError(t, err)
+		assert.True(t, shares[childA].IsZero())
+		assert.True(t, shares[childB].Equal(one))
+	})
+
+	t.Run("all zero shares leave the whole cost unallocated", func(t *testing.T) {
+		zero := map[uuid.UUID]decimal.Decimal{childA: decimal.Zero, childB: decimal.Zero}
+		_, remainder, err := normaliseShares(zero, RemainderRenormalise)
+		require.NoError(t, err)
+		assert.True(t, remainder.Equal(one))
+
+		_, _, err = normaliseShares(zero, RemainderFail)
+		assert.Error(t, err)
+	})
+}
+
+// TestParseRemainderPolicy checks the accepted policy names
+func TestParseRemainderPolicy(t *testing.T) {
+	policy, err := ParseRemainderPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, RemainderToUnallocated, policy)
+
+	policy, err = ParseRemainderPolicy("renormalise")
+	require.NoError(t, err)
+	assert.Equal(t, RemainderRenormalise, policy)
+
+	_, err = ParseRemainderPolicy("renormalize")
+	assert.Error(t, err)
+}
+
+// TestSiblingSharesMixedStrategies checks that siblings on different strategies are
+// normalised together rather than each taking its own strategy's share
+func TestSiblingSharesMixedStrategies(t *testing.T) {
+	parent := uuid.New()
+	childA := uuid.New()
+	childB := uuid.New()
+
+	edges := []models.DependencyEdge{
+		strategyEdge(parent, childA, models.StrategyFixedPercent, map[string]interface{}{"percent": 80.0}),
+		strategyEdge(parent, childB, models.StrategyEqual, nil),
+	}
+
+	// 0.8 + 0.5 over allocates, so both are scaled back
+	snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+	shares, remainder, err := snap.SiblingShares(parent, "cost")
+	require.NoError(t, err)
+	assertShare(t, "0.6154", shares[childA])
+	assertShare(t, "0.3846", shares[childB])
+	assert.True(t, remainder.IsZero())
+	assert.True(t, sumShares(shares).Equal(decimal.NewFromInt(1)))
+
+	snap = newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+	snap.remainderPolicy = RemainderFail
+	_, _, err = snap.SiblingShares(parent, "cost")
+	assert.Error(t, err)
+}
+
+// TestInvariantReport checks that the day's report accounts for every unit of direct cost.
+//
+//	shared ($1000, fixed 25% each) → app, web
+//	orphan ($50) has no edges and is not a final cost centre
+//	$10 is recorded on a node missing from the graph
+func TestInvariantReport(t *testing.T) {
+	shared := models.CostNode{ID: uuid.New(), Name: "shared", Type: string(models.NodeTypeShared)}
+	orphan := models.CostNode{ID: uuid.New(), Name: "orphan", Type: string(models.NodeTypeResource)}
+	app := models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	nodes := []models.CostNode{shared, orphan, app, web}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(shared.ID, app.ID, models.StrategyFixedPercent, map[string]interface{}{"percent": 25.0}),
+		strategyEdge(shared.ID, web.ID, models.StrategyFixedPercent, map[string]interface{}{"percent": 25.0}),
+	}
+
+	g := graph.NewGraph(snapshotDate, nodes, edges)
+	order, err := g.TopologicalSort()
+	require.NoError(t, err)
+
+	dimensions := []string{"cost"}
+	costsByNode := map[uuid.UUID]map[string]decimal.Decimal{
+		shared.ID:  {"cost": decimal.NewFromInt(1000)},
+		orphan.ID:  {"cost": decimal.NewFromInt(50)},
+		uuid.New(): {"cost": decimal.NewFromInt(10)},
+	}
+
+	run := func(policy RemainderPolicy) models.AllocationInvariantReport {
+		e := &Engine{remainderPolicy: policy}
+		snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+		snap.remainderPolicy = policy
+		indirectCosts := e.initializeIndirectCosts(g, dimensions)
+		allocations, contributions, _, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+		require.NoError(t, err)
+
+		reports := e.validateAllocationInvariants(context.Background(), uuid.New(), g, allocations, contributions, costsByNode, indirectCosts, dimensions, snapshotDate)
+		require.Len(t, reports, 1)
+		return reports[0]
+	}
+
+	t.Run("to_unallocated", func(t *testing.T) {
+		report := run(RemainderToUnallocated)
+		assert.Equal(t, "1060.00", report.DirectCost.StringFixed(2))
+		assert.Equal(t, "500.00", report.FinalCostCentreCost.StringFixed(2))
+		assert.Equal(t, "500.00", report.UnallocatedCost.StringFixed(2))
+		assert.Equal(t, "50.00", report.StrandedCost.StringFixed(2))
+		assert.True(t, report.CreatedCost.IsZero())
+		assert.Equal(t, "10.00", report.LostCost.StringFixed(2), "cost on a node outside the graph is lost")
+		assert.True(t, violatesInvariants(report))
+	})
+
+	t.Run("renormalise", func(t *testing.T) {
+		report := run(RemainderRenormalise)
+		assert.Equal(t, "1000.00", report.FinalCostCentreCost.StringFixed(2))
+		assert.True(t, report.UnallocatedCost.IsZero())
+	})
+}
@@ -0,0 +1,103 @@
+package allocate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// calculatePeakCoincidentShares splits a parent's cost by each child's hourly usage
+// of a metric during the parent's busiest hours of the day, so capacity-driven
+// costs follow each consumer's contribution to peak load rather than its volume.
+// The parent's load in an hour is its own usage of the metric when it has any
+// hourly usage recorded, otherwise its children's combined usage.
+//
+// Parameters:
+//   - metric: the hourly usage metric (required)
+//   - peak_hours: how many of the parent's busiest hours are compared (default 1)
+func (s *Strategy) calculatePeakCoincidentShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+	metric, ok := s.Parameters["metric"].(string)
+	if !ok {
+		return nil, fmt.Errorf("peak_coincident strategy requires 'metric' parameter")
+	}
+
+	edges := snap.ChildEdges(parentID)
+	if len(edges) == 0 {
+		return map[uuid.UUID]decimal.Decimal{}, nil
+	}
+
+	childUsage := make(map[uuid.UUID][24]decimal.Decimal, len(edges))
+	var combined [24]decimal.Decimal
+	for _, edge := range edges {
+		hours, _ := snap.HourlyUsageOn(edge.ChildID, metric)
+		childUsage[edge.ChildID] = hours
+		for hour, value := range hours {
+			combined[hour] = combined[hour].Add(value)
+		}
+	}
+
+	load, ok := snap.HourlyUsageOn(parentID, metric)
+	if !ok {
+		load = combined
+	}
+
+	// Sum each child's usage over the peak hours
+	peaks := busiestHours(load, s.peakHours())
+	var totalUsage decimal.Decimal
+	usage := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	for _, edge := range edges {
+		var sum decimal.Decimal
+		for _, hour := range peaks {
+			sum = sum.Add(childUsage[edge.ChildID][hour])
+		}
+		usage[edge.ChildID] = sum
+		totalUsage = totalUsage.Add(sum)
+	}
+
+	if totalUsage.IsZero() {
+		return zeroUsageShares(snap, edges), nil
+	}
+
+	shares := make(map[uuid.UUID]decimal.Decimal, len(usage))
+	for childID, value := range usage {
+		shares[childID] = value.Div(totalUsage)
+	}
+	return shares, nil
+}
+
+// busiestHours returns up to n hours with the highest positive load, the earlier hour
+// first when two are equal
+func busiestHours(load [24]decimal.Decimal, n int) []int {
+	hours := make([]int, 0, len(load))
+	for hour, value := range load {
+		if value.IsPositive() {
+			hours = append(hours, hour)
+		}
+	}
+
+	sort.SliceStable(hours, func(i, j int) bool {
+		return load[hours[i]].GreaterThan(load[hours[j]])
+	})
+
+	if len(hours) > n {
+		hours = hours[:n]
+	}
+	return hours
+}
+
+// peakHours returns the peak_hours parameter, defaulting to 1
+func (s *Strategy) peakHours() int {
+	peakHours := 1
+	switch v := s.Parameters["peak_hours"].(type) {
+	case float64:
+		peakHours = int(v)
+	case int:
+		peakHours = v
+	}
+	if peakHours < 1 {
+		peakHours = 1
+	}
+	return peakHours
+}
@@ -0,0 +1,118 @@
+package allocate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hourlyRecord(nodeID uuid.UUID, hour int, metric string, value float64) models.NodeUsageHourly {
+	return models.NodeUsageHourly{
+		NodeID:    nodeID,
+		UsageHour: snapshotDate.Add(time.Duration(hour) * time.Hour),
+		Metric:    metric,
+		Value:     decimal.NewFromFloat(value),
+		Unit:      "count",
+	}
+}
+
+// TestPeakCoincidentShares checks the peak_coincident strategy against a database
+// cluster shared by a steady consumer and a bursty one
+//
+//	steady: 10 connections every hour (240 a day)
+//	bursty: 50 at 14:00 and 40 at 15:00 (90 a day)
+//	combined load peaks at 14:00 (60), then 15:00 (50)
+func TestPeakCoincidentShares(t *testing.T) {
+	cluster := uuid.New()
+	steady, bursty := uuid.New(), uuid.New()
+
+	var hourly []models.NodeUsageHourly
+	for hour := 0; hour < 24; hour++ {
+		hourly = append(hourly, hourlyRecord(steady, hour, "connections", 10))
+	}
+	hourly = append(hourly,
+		hourlyRecord(bursty, 14, "connections", 50),
+		hourlyRecord(bursty, 15, "connections", 40),
+	)
+
+	shares := func(t *testing.T, params map[string]interface{}, hourly []models.NodeUsageHourly) map[uuid.UUID]decimal.Decimal {
+		t.Helper()
+		edges := []models.DependencyEdge{
+			strategyEdge(cluster, steady, models.StrategyPeakCoincident, params),
+			strategyEdge(cluster, bursty, models.StrategyPeakCoincident, params),
+		}
+		snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+		snap.attachHourlyUsage(hourly)
+
+		result, err := snap.Shares(snap.ResolveStrategy(edges[0], "cost"), cluster, "cost")
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("the single busiest hour", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"metric": "connections"}, hourly)
+		assertShare(t, "0.1667", result[steady])
+		assertShare(t, "0.8333", result[bursty])
+	})
+
+	t.Run("the busiest hours", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"metric": "connections", "peak_hours": 2.0}, hourly)
+		assertShare(t, "0.1818", result[steady])
+		assertShare(t, "0.8182", result[bursty])
+	})
+
+	t.Run("every hour with load matches a daily split", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"metric": "connections", "peak_hours": 24.0}, hourly)
+		assertShare(t, "0.7273", result[steady])
+		assertShare(t, "0.2727", result[bursty])
+	})
+
+	t.Run("the parent's own usage decides the peaks", func(t *testing.T) {
+		withParent := append([]models.NodeUsageHourly{hourlyRecord(cluster, 3, "connections", 100)}, hourly...)
+		result := shares(t, map[string]interface{}{"metric": "connections"}, withParent)
+		assertShare(t, "1.0000", result[steady])
+		assertShare(t, "0.0000", result[bursty])
+	})
+
+	t.Run("no usage at the peaks falls back to equal shares", func(t *testing.T) {
+		result := shares(t, map[string]interface{}{"metric": "connections"}, nil)
+		assertShare(t, "0.5000", result[steady])
+		assertShare(t, "0.5000", result[bursty])
+	})
+}
+
+func TestBusiestHours(t *testing.T) {
+	var load [24]decimal.Decimal
+	load[2] = decimal.NewFromInt(5)
+	load[9] = decimal.NewFromInt(7)
+	load[17] = decimal.NewFromInt(5)
+
+	assert.Equal(t, []int{9}, busiestHours(load, 1))
+	assert.Equal(t, []int{9, 2, 17}, busiestHours(load, 3), "ties go to the earlier hour")
+	assert.Equal(t, []int{9, 2, 17}, busiestHours(load, 24), "hours without load are never peaks")
+}
+
+// TestPeakCoincidentRequirements checks the snapshot loads hourly usage for the
+// strategy and that it changes the usage checksum
+func TestPeakCoincidentRequirements(t *testing.T) {
+	parent, child := uuid.New(), uuid.New()
+	edges := []models.DependencyEdge{
+		strategyEdge(parent, child, models.StrategyPeakCoincident, map[string]interface{}{"metric": "connections"}),
+	}
+
+	reqs := collectUsageRequirements(edges, nil)
+	assert.Equal(t, []string{"connections"}, reqs.hourlyMetrics)
+
+	checksum := func(value float64) string {
+		snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+		snap.attachHourlyUsage([]models.NodeUsageHourly{hourlyRecord(child, 14, "connections", value)})
+		return snap.usageChecksum
+	}
+	assert.Equal(t, checksum(10), checksum(10))
+	assert.NotEqual(t, checksum(10), checksum(20))
+}
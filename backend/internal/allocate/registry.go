@@ -0,0 +1,59 @@
+package allocate
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
+	"github.com/shopspring/decimal"
+)
+
+// builtinFunc is a built-in strategy, which reads the engine's own day snapshot
+type builtinFunc func(s *Strategy, snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error)
+
+// strategyFunc adapts a built-in strategy to the registry's function type
+func (fn builtinFunc) strategyFunc(name models.AllocationStrategy) strategy.Func {
+	return func(params map[string]interface{}, snap strategy.Snapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+		day, ok := snap.(*DaySnapshot)
+		if !ok {
+			return nil, fmt.Errorf("built-in strategy %s needs the engine's day snapshot, got %T", name, snap)
+		}
+		return fn(&Strategy{Type: name, Parameters: params}, day, parentID, dimension)
+	}
+}
+
+func init() {
+	builtin := func(name models.AllocationStrategy, fn builtinFunc) strategy.Definition {
+		return strategy.Definition{Spec: strategy.Spec{Name: name}, Func: fn.strategyFunc(name)}
+	}
+
+	weightedAverage := builtin(models.StrategyWeightedAverage, (*Strategy).calculateWeightedAverageShares)
+	weightedAverage.LookbackDays = func(params map[string]interface{}) int { return (&Strategy{Parameters: params}).windowDays() }
+	segmentFiltered := builtin(models.StrategySegmentFilteredProp, (*Strategy).calculateSegmentFilteredProportionalShares)
+	segmentFiltered.LabelledUsage = true
+	formula := builtin(models.StrategyFormula, (*Strategy).calculateFormulaShares)
+	formula.Metrics = func(params map[string]interface{}) []string { return (&Strategy{Parameters: params}).formulaMetrics() }
+	formula.NodeLabels = true
+	peakCoincident := builtin(models.StrategyPeakCoincident, (*Strategy).calculatePeakCoincidentShares)
+	peakCoincident.HourlyUsage = true
+
+	for _, def := range []strategy.Definition{
+		builtin(models.StrategyEqual, (*Strategy).calculateEqualShares),
+		builtin(models.StrategyProportionalOn, (*Strategy).calculateProportionalShares),
+		builtin(models.StrategyFixedPercent, (*Strategy).calculateFixedPercentShares),
+		builtin(models.StrategyCappedProp, (*Strategy).calculateCappedProportionalShares),
+		builtin(models.StrategyResidualToMax, (*Strategy).calculateResidualToMaxShares),
+		weightedAverage,
+		builtin(models.StrategyHybridFixedProp, (*Strategy).calculateHybridFixedProportionalShares),
+		builtin(models.StrategyMinFloorProportional, (*Strategy).calculateMinFloorProportionalShares),
+		segmentFiltered,
+		formula,
+		builtin(models.StrategyTieredRate, (*Strategy).calculateTieredRateShares),
+		peakCoincident,
+	} {
+		if err := strategy.Implement(def); err != nil {
+			panic(fmt.Sprintf("built-in strategy: %v", err))
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package allocate
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStrategyRegistry checks built-in and custom strategies are dispatched through the registry
+func TestStrategyRegistry(t *testing.T) {
+	t.Run("every registered spec has an implementation", func(t *testing.T) {
+		for _, spec := range models.StrategySpecs() {
+			if spec.Name == "test_registered_strategy" {
+				continue // Registered by the models tests only
+			}
+			def, ok := strategy.Lookup(spec.Name)
+			require.True(t, ok, "strategy %s has no implementation", spec.Name)
+			assert.NotNil(t, def.Func)
+		}
+	})
+
+	t.Run("a custom strategy is validated and run", func(t *testing.T) {
+		const name models.AllocationStrategy = "test_first_child"
+		require.False(t, models.IsValidStrategy(string(name)))
+
+		// Gives the whole parent to the child named by the "child" parameter, if
+		// it is one of the parent's children
+		err := strategy.Register(strategy.Definition{
+			Spec: strategy.Spec{
+				Name: name,
+				Parameters: map[string]strategy.ParamSpec{
+					"child": {Type: strategy.ParamTypeString, Required: true},
+				},
+			},
+			Func: func(params map[string]interface{}, snap strategy.Snapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+				child := uuid.MustParse(params["child"].(string))
+				for _, edge := range snap.ChildEdges(parentID) {
+					if edge.ChildID == child {
+						return map[uuid.UUID]decimal.Decimal{child: decimal.NewFromInt(1)}, nil
+					}
+				}
+				return nil, nil
+			},
+		})
+		require.NoError(t, err)
+		assert.True(t, models.IsValidStrategy(string(name)))
+		equal := builtinFunc((*Strategy).calculateEqualShares).strategyFunc(name)
+		assert.Error(t, strategy.Register(strategy.Definition{Spec: strategy.Spec{Name: name}, Func: equal}), "names must be unique")
+
+		parent, childA, childB := uuid.New(), uuid.New(), uuid.New()
+		params := map[string]interface{}{"child": childB.String()}
+		snap := newDaySnapshot(snapshotDate, []models.DependencyEdge{
+			strategyEdge(parent, childA, name, params),
+			strategyEdge(parent, childB, name, params),
+		}, nil, nil, nil)
+
+		shares, remainder, err := snap.SiblingShares(parent, "cost")
+		require.NoError(t, err)
+		assert.True(t, shares[childB].Equal(decimal.NewFromInt(1)))
+		assert.True(t, shares[childA].IsZero())
+		assert.True(t, remainder.IsZero())
+
+		_, err = (&Strategy{Type: name}).CalculateShares(snap, parent, "cost")
+		require.Error(t, err, "parameters are validated before the strategy runs")
+		assert.Contains(t, err.Error(), "'child' is required")
+	})
+
+	t.Run("unknown strategies are rejected", func(t *testing.T) {
+		snap := newDaySnapshot(snapshotDate, nil, nil, nil, nil)
+		_, err := (&Strategy{Type: "no_such_strategy"}).CalculateShares(snap, uuid.New(), "cost")
+		assert.ErrorContains(t, err, "unknown strategy type")
+	})
+
+	t.Run("functions are required", func(t *testing.T) {
+		assert.Error(t, strategy.Register(strategy.Definition{Spec: strategy.Spec{Name: "test_no_func"}}))
+		assert.False(t, models.IsValidStrategy("test_no_func"))
+	})
+
+	t.Run("built-in strategies need the engine's snapshot", func(t *testing.T) {
+		def, ok := strategy.Lookup(models.StrategyEqual)
+		require.True(t, ok)
+		_, err := def.Func(nil, nil, uuid.New(), "cost")
+		assert.ErrorContains(t, err, "needs the engine's day snapshot")
+
+		assert.Error(t, strategy.Implement(strategy.Definition{Spec: strategy.Spec{Name: "test_no_spec"}, Func: def.Func}), "only registered specs can be implemented")
+	})
+}
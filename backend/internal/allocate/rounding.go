@@ -0,0 +1,59 @@
+package allocate
+
+import (
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// roundContributions rounds a parent's exact contributions to its children to the
+// given number of decimal places using the largest remainder method. Every amount
+// is truncated to the minor unit, then the units still needed to reach the total of
+// the exact amounts, itself truncated to the minor unit, go one at a time to the
+// children with the largest truncated remainders, ties broken by child ID. The
+// rounded amounts therefore sum to exactly that total, no child moves by more than
+// one minor unit, and no cost is created: anything below one minor unit stays on
+// the parent as rounding residue, which the invariant report accounts for.
+func roundContributions(exact map[uuid.UUID]decimal.Decimal, places int32) map[uuid.UUID]decimal.Decimal {
+	rounded := make(map[uuid.UUID]decimal.Decimal, len(exact))
+	if len(exact) == 0 {
+		return rounded
+	}
+
+	total := decimal.Zero
+	truncatedTotal := decimal.Zero
+	ids := make([]uuid.UUID, 0, len(exact))
+	for childID, amount := range exact {
+		total = total.Add(amount)
+		rounded[childID] = amount.Truncate(places)
+		truncatedTotal = truncatedTotal.Add(rounded[childID])
+		ids = append(ids, childID)
+	}
+
+	// Credits truncate towards zero too, so a negative total takes units away
+	unit := decimal.New(1, -places)
+	units := total.Truncate(places).Sub(truncatedTotal).Div(unit).IntPart()
+	if units < 0 {
+		unit = unit.Neg()
+		units = -units
+	}
+
+	// Largest remainder in the direction of the units first, so the children
+	// closest to the next unit get it
+	sort.Slice(ids, func(i, j int) bool {
+		ri := exact[ids[i]].Sub(rounded[ids[i]]).Div(unit)
+		rj := exact[ids[j]].Sub(rounded[ids[j]]).Div(unit)
+		if cmp := ri.Cmp(rj); cmp != 0 {
+			return cmp > 0
+		}
+		return ids[i].String() < ids[j].String()
+	})
+
+	for i := int64(0); i < units; i++ {
+		childID := ids[i%int64(len(ids))]
+		rounded[childID] = rounded[childID].Add(unit)
+	}
+
+	return rounded
+}
@@ -0,0 +1,192 @@
+package allocate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sumAmounts(amounts map[uuid.UUID]decimal.Decimal) decimal.Decimal {
+	total := decimal.Zero
+	for _, amount := range amounts {
+		total = total.Add(amount)
+	}
+	return total
+}
+
+// TestRoundContributions checks the largest remainder method against awkward splits
+func TestRoundContributions(t *testing.T) {
+	third := decimal.NewFromInt(100).Div(decimal.NewFromInt(3))
+
+	t.Run("thirds of 100 sum back to 100.00", func(t *testing.T) {
+		childA, childB, childC := uuid.New(), uuid.New(), uuid.New()
+		exact := map[uuid.UUID]decimal.Decimal{childA: third, childB: third, childC: third.Add(decimal.NewFromInt(100).Sub(third.Mul(decimal.NewFromInt(3))))}
+
+		rounded := roundContributions(exact, 2)
+		assert.Equal(t, "100.00", sumAmounts(rounded).StringFixed(2))
+		assert.True(t, sumAmounts(rounded).Equal(decimal.NewFromInt(100)))
+
+		var amounts []string
+		for _, amount := range rounded {
+			amounts = append(amounts, amount.StringFixed(2))
+		}
+		assert.ElementsMatch(t, []string{"33.34", "33.33", "33.33"}, amounts)
+	})
+
+	t.Run("the largest remainder gets the unit", func(t *testing.T) {
+		childA, childB := uuid.New(), uuid.New()
+		rounded := roundContributions(map[uuid.UUID]decimal.Decimal{
+			childA: decimal.RequireFromString("0.334"),
+			childB: decimal.RequireFromString("0.666"),
+		}, 2)
+		assert.Equal(t, "0.33", rounded[childA].StringFixed(2))
+		assert.Equal(t, "0.67", rounded[childB].StringFixed(2))
+	})
+
+	t.Run("ties are broken by child ID", func(t *testing.T) {
+		childA := uuid.MustParse("00000000-0000-0000-0000-00000000000a")
+		childB := uuid.MustParse("00000000-0000-0000-0000-00000000000b")
+		for i := 0; i < 10; i++ {
+			rounded := roundContributions(map[uuid.UUID]decimal.Decimal{
+				childB: decimal.RequireFromString("0.005"),
+				childA: decimal.RequireFromString("0.005"),
+			}, 2)
+			assert.Equal(t, "0.01", rounded[childA].StringFixed(2))
+			assert.Equal(t, "0.00", rounded[childB].StringFixed(2))
+		}
+	})
+
+	t.Run("zero places rounds to whole units", func(t *testing.T) {
+		childA, childB, childC := uuid.New(), uuid.New(), uuid.New()
+		rounded := roundContributions(map[uuid.UUID]decimal.Decimal{childA: third, childB: third, childC: third.Add(decimal.RequireFromString("0.0000000000000001"))}, 0)
+		assert.True(t, sumAmounts(rounded).Equal(decimal.NewFromInt(100)), "rounded to %s", sumAmounts(rounded))
+	})
+
+	t.Run("credits sum back to the negative total", func(t *testing.T) {
+		childA, childB, childC := uuid.New(), uuid.New(), uuid.New()
+		exact := map[uuid.UUID]decimal.Decimal{childA: third.Neg(), childB: third.Neg(), childC: third.Neg().Sub(decimal.RequireFromString("0.0000000000000001"))}
+		rounded := roundContributions(exact, 2)
+		assert.True(t, sumAmounts(rounded).Equal(decimal.NewFromInt(-100)), "rounded to %s", sumAmounts(rounded))
+	})
+
+	t.Run("a sub-unit total is never rounded up", func(t *testing.T) {
+		childA, childB := uuid.New(), uuid.New()
+		rounded := roundContributions(map[uuid.UUID]decimal.Decimal{
+			childA: decimal.RequireFromString("50.0025"),
+			childB: decimal.RequireFromString("50.0025"),
+		}, 2)
+		assert.True(t, sumAmounts(rounded).Equal(decimal.NewFromInt(100)), "rounded to %s", sumAmounts(rounded))
+	})
+}
+
+// TestRoundedTraversal checks a traversal with rounding enabled lands penny-exact
+//
+//	shared ($100, equal) → app, web, api
+//	odd ($100.005, equal) → app, web
+func TestRoundedTraversal(t *testing.T) {
+	shared := models.CostNode{ID: uuid.New(), Name: "shared", Type: string(models.NodeTypeShared)}
+	odd := models.CostNode{ID: uuid.New(), Name: "odd", Type: string(models.NodeTypeShared)}
+	app := models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	api := models.CostNode{ID: uuid.New(), Name: "api", Type: string(models.NodeTypeProduct)}
+	nodes := []models.CostNode{shared, odd, app, web, api}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(shared.ID, app.ID, models.StrategyEqual, nil),
+		strategyEdge(shared.ID, web.ID, models.StrategyEqual, nil),
+		strategyEdge(shared.ID, api.ID, models.StrategyEqual, nil),
+		strategyEdge(odd.ID, app.ID, models.StrategyEqual, nil),
+		strategyEdge(odd.ID, web.ID, models.StrategyEqual, nil),
+	}
+
+	g := graph.NewGraph(snapshotDate, nodes, edges)
+	order, err := g.TopologicalSort()
+	require.NoError(t, err)
+
+	dimensions := []string{"cost"}
+	costsByNode := map[uuid.UUID]map[string]decimal.Decimal{
+		shared.ID: {"cost": decimal.NewFromInt(100)},
+		odd.ID:    {"cost": decimal.RequireFromString("100.005")},
+	}
+
+	e := &Engine{remainderPolicy: RemainderToUnallocated, zeroUsageFallback: true, rounding: true, roundingPlaces: 2}
+	snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+	indirectCosts := e.initializeIndirectCosts(g, dimensions)
+	_, contributions, _, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+	require.NoError(t, err)
+
+	byParent := make(map[uuid.UUID]decimal.Decimal)
+	adjustments := make(map[uuid.UUID]decimal.Decimal)
+	for _, contribution := range contributions {
+		assert.True(t, contribution.ContributedAmount.Equal(contribution.ContributedAmount.Round(2)), "%s is not in minor units", contribution.ContributedAmount)
+		byParent[contribution.ParentID] = byParent[contribution.ParentID].Add(contribution.ContributedAmount)
+		adjustments[contribution.ParentID] = adjustments[contribution.ParentID].Add(contribution.RoundingAdjustment.Abs())
+	}
+
+	assert.True(t, byParent[shared.ID].Equal(decimal.NewFromInt(100)), "children sum to the parent exactly, got %s", byParent[shared.ID])
+	assert.True(t, byParent[odd.ID].Equal(decimal.NewFromInt(100)), "the sub-cent part is not placed, got %s", byParent[odd.ID])
+	assert.False(t, adjustments[shared.ID].IsZero(), "rounding adjustments are recorded")
+
+	total := indirectCosts[app.ID]["cost"].Add(indirectCosts[web.ID]["cost"]).Add(indirectCosts[api.ID]["cost"])
+	assert.True(t, total.Equal(decimal.NewFromInt(200)), "final cost centres hold %s", total)
+
+	residue := e.collectUnallocated(uuid.New(), snapshotDate, g, dimensions, snap, contributions, costsByNode, indirectCosts)
+	require.Len(t, residue, 1)
+	assert.Equal(t, odd.ID, residue[0].NodeID)
+	assert.Equal(t, models.UnallocatedReasonRoundingResidue, residue[0].Reason)
+	assert.Equal(t, "0.005", residue[0].Amount.String())
+}
+
+// TestRoundingResidueReport checks the invariant report accounts for each parent's
+// rounding residue on its own, including the negative residue a credit leaves
+//
+//	odd ($100.005, equal) → app, web
+//	credit (-$20.003, equal) → app, web
+func TestRoundingResidueReport(t *testing.T) {
+	odd := models.CostNode{ID: uuid.New(), Name: "odd", Type: string(models.NodeTypeShared)}
+	credit := models.CostNode{ID: uuid.New(), Name: "credit", Type: string(models.NodeTypeShared)}
+	app := models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	nodes := []models.CostNode{odd, credit, app, web}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(odd.ID, app.ID, models.StrategyEqual, nil),
+		strategyEdge(odd.ID, web.ID, models.StrategyEqual, nil),
+		strategyEdge(credit.ID, app.ID, models.StrategyEqual, nil),
+		strategyEdge(credit.ID, web.ID, models.StrategyEqual, nil),
+	}
+
+	g := graph.NewGraph(snapshotDate, nodes, edges)
+	order, err := g.TopologicalSort()
+	require.NoError(t, err)
+
+	dimensions := []string{"cost"}
+	costsByNode := map[uuid.UUID]map[string]decimal.Decimal{
+		odd.ID:    {"cost": decimal.RequireFromString("100.005")},
+		credit.ID: {"cost": decimal.RequireFromString("-20.003")},
+	}
+
+	e := &Engine{remainderPolicy: RemainderToUnallocated, zeroUsageFallback: true, rounding: true, roundingPlaces: 2}
+	snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+	indirectCosts := e.initializeIndirectCosts(g, dimensions)
+	allocations, contributions, _, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+	require.NoError(t, err)
+
+	reports := e.validateAllocationInvariants(context.Background(), uuid.New(), g, allocations, contributions, costsByNode, indirectCosts, dimensions, snapshotDate)
+	require.Len(t, reports, 1)
+	report := reports[0]
+
+	assert.Equal(t, "80.002", report.DirectCost.String())
+	assert.Equal(t, "80", report.FinalCostCentreCost.String())
+	assert.Equal(t, "0.002", report.RoundingResidue.String(), "0.005 left on odd and -0.003 on credit")
+	assert.True(t, report.UnallocatedCost.IsZero(), "residue is not reported as unallocated")
+	assert.True(t, report.CreatedCost.IsZero(), "a credit's residue is not created cost")
+	assert.True(t, report.LostCost.IsZero())
+	assert.False(t, violatesInvariants(report))
+}
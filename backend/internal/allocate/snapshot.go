@@ -0,0 +1,527 @@
+package allocate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// DaySnapshot holds everything the allocation strategies need for a single day,
+// loaded up front so the traversal never goes back to the database. Share vectors
+// are memoised per (parent, dimension, strategy) so each is computed once, and the
+// normalised vector across all of a parent's children per (parent, dimension).
+type DaySnapshot struct {
+	date          time.Time
+	edgesByParent map[uuid.UUID][]models.DependencyEdge
+	childEdges    map[uuid.UUID][]strategy.Edge
+	parentEdges   map[uuid.UUID][]strategy.Edge
+	strategies    map[uuid.UUID][]models.EdgeStrategy
+	usage         map[uuid.UUID]map[string][]models.NodeUsageByDimension
+	labelledUsage map[uuid.UUID]map[string][]models.NodeUsageByDimension
+	hourlyUsage   map[uuid.UUID]map[string][]models.NodeUsageHourly
+	nodeLabels    map[uuid.UUID]map[string]interface{}
+	shares        map[shareKey]shareResult
+	siblings      map[siblingKey]siblingResult
+
+	// remainderPolicy decides what happens when a parent's child shares do not sum to 1
+	remainderPolicy RemainderPolicy
+	// zeroUsageFallback lets usage-based strategies split equally when no child has
+	// usage; when disabled, noUsage records the parents left with nothing to split on
+	zeroUsageFallback bool
+	noUsage           map[uuid.UUID]bool
+
+	// parentCosts holds each parent's holistic cost, set by the engine before its
+	// shares are calculated, for strategies that price usage against it
+	parentCosts map[siblingKey]decimal.Decimal
+	// recoveries records how far rate-based strategies' charges covered their parent's cost
+	recoveries map[siblingKey]recovery
+
+	usageChecksum    string
+	strategyChecksum string
+	// needsNodeLabels is set when a strategy in use reads node labels, which are
+	// then part of the strategy checksum
+	needsNodeLabels bool
+}
+
+// shareKey identifies a memoised share vector
+type shareKey struct {
+	parentID  uuid.UUID
+	dimension string
+	strategy  string
+}
+
+// shareResult is a memoised share vector or the error that prevented computing it
+type shareResult struct {
+	shares map[uuid.UUID]decimal.Decimal
+	err    error
+}
+
+// siblingKey identifies a memoised normalised share vector
+type siblingKey struct {
+	parentID  uuid.UUID
+	dimension string
+}
+
+// siblingResult is a parent's normalised share vector, the share it leaves
+// unallocated and why
+type siblingResult struct {
+	shares    map[uuid.UUID]decimal.Decimal
+	remainder decimal.Decimal
+	reason    models.UnallocatedReason
+	err       error
+}
+
+// recovery compares what a rate-based strategy charged a parent's children with
+// the parent's cost. A nil sink is the run's unallocated node.
+type recovery struct {
+	strategy models.AllocationStrategy
+	sink     *uuid.UUID
+	cost     decimal.Decimal
+	charged  decimal.Decimal
+}
+
+// usageRequirements describes which usage data the day's strategies will read
+type usageRequirements struct {
+	metrics         []string
+	hourlyMetrics   []string
+	windowDays      int
+	needsLabelled   bool
+	needsNodeLabels bool
+}
+
+// loadDaySnapshot bulk loads the edges, strategy overrides and usage for a date
+func (e *Engine) loadDaySnapshot(ctx context.Context, date time.Time) (*DaySnapshot, error) {
+	edges, err := e.store.Edges.GetActiveEdgesForDate(ctx, date)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load edges: %w", err)
+	}
+
+	edgeIDs := make([]uuid.UUID, 0, len(edges))
+	for _, edge := range edges {
+		edgeIDs = append(edgeIDs, edge.ID)
+	}
+
+	strategies, err := e.store.Edges.GetStrategiesForEdges(ctx, edgeIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load edge strategies: %w", err)
+	}
+
+	reqs := collectUsageRequirements(edges, strategies)
+
+	var usage []models.NodeUsageByDimension
+	if len(reqs.metrics) > 0 {
+		startDate := date.AddDate(0, 0, -(reqs.windowDays - 1))
+		usage, err = e.store.Usage.GetByDateRange(ctx, startDate, date, reqs.metrics)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load usage: %w", err)
+		}
+	}
+
+	var labelled []models.NodeUsageByDimension
+	if reqs.needsLabelled {
+		labelled, err = e.store.Usage.QueryWithOptions(ctx, models.UsageQueryOptions{
+			StartDate: date,
+			EndDate:   date,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to load labelled usage: %w", err)
+		}
+	}
+
+	var hourly []models.NodeUsageHourly
+	if len(reqs.hourlyMetrics) > 0 {
+		hourly, err = e.store.Usage.GetHourlyByDate(ctx, date, reqs.hourlyMetrics)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load hourly usage: %w", err)
+		}
+	}
+
+	snap := newDaySnapshot(date, edges, strategies, usage, labelled)
+	snap.attachHourlyUsage(hourly)
+	snap.remainderPolicy = e.remainderPolicy
+	snap.zeroUsageFallback = e.zeroUsageFallback
+	snap.needsNodeLabels = reqs.needsNodeLabels
+
+	log.Debug().
+		Time("date", date).
+		Int("edges", len(edges)).
+		Int("strategy_overrides", len(strategies)).
+		Int("usage_records", len(usage)).
+		Int("labelled_usage_records", len(labelled)).
+		Int("hourly_usage_records", len(hourly)).
+		Msg("Day snapshot loaded")
+
+	return snap, nil
+}
+
+// newDaySnapshot indexes already-loaded data into a snapshot
+func newDaySnapshot(
+	date time.Time,
+	edges []models.DependencyEdge,
+	strategies map[uuid.UUID][]models.EdgeStrategy,
+	usage []models.NodeUsageByDimension,
+	labelled []models.NodeUsageByDimension,
+) *DaySnapshot {
+	snap := &DaySnapshot{
+		date:          date,
+		edgesByParent: make(map[uuid.UUID][]models.DependencyEdge),
+		childEdges:    make(map[uuid.UUID][]strategy.Edge),
+		parentEdges:   make(map[uuid.UUID][]strategy.Edge),
+		strategies:    strategies,
+		usage:         indexUsage(usage),
+		labelledUsage: indexUsage(labelled),
+		shares:        make(map[shareKey]shareResult),
+		siblings:      make(map[siblingKey]siblingResult),
+
+		remainderPolicy:   RemainderToUnallocated,
+		zeroUsageFallback: true,
+		noUsage:           make(map[uuid.UUID]bool),
+		parentCosts:       make(map[siblingKey]decimal.Decimal),
+		recoveries:        make(map[siblingKey]recovery),
+
+		usageChecksum:    usageChecksum(usage, labelled),
+		strategyChecksum: strategyChecksum(edges, strategies),
+	}
+	if snap.strategies == nil {
+		snap.strategies = make(map[uuid.UUID][]models.EdgeStrategy)
+	}
+
+	for _, edge := range edges {
+		snap.edgesByParent[edge.ParentID] = append(snap.edgesByParent[edge.ParentID], edge)
+		view := strategy.Edge{ID: edge.ID, ParentID: edge.ParentID, ChildID: edge.ChildID}
+		snap.childEdges[edge.ParentID] = append(snap.childEdges[edge.ParentID], view)
+		snap.parentEdges[edge.ChildID] = append(snap.parentEdges[edge.ChildID], view)
+	}
+
+	// Strategies that break ties by edge order see the same order however the
+	// edges were loaded
+	for _, edges := range snap.childEdges {
+		sort.Slice(edges, func(i, j int) bool { return edges[i].ChildID.String() < edges[j].ChildID.String() })
+	}
+	for _, edges := range snap.parentEdges {
+		sort.Slice(edges, func(i, j int) bool { return edges[i].ParentID.String() < edges[j].ParentID.String() })
+	}
+
+	return snap
+}
+
+// collectUsageRequirements scans every strategy in use for the metrics, look-back
+// window, hourly usage and label data it depends on
+func collectUsageRequirements(edges []models.DependencyEdge, strategies map[uuid.UUID][]models.EdgeStrategy) usageRequirements {
+	reqs := usageRequirements{windowDays: 1}
+	seen := make(map[string]bool)
+	seenHourly := make(map[string]bool)
+
+	addMetric := func(metric string) {
+		if !seen[metric] {
+			seen[metric] = true
+			reqs.metrics = append(reqs.metrics, metric)
+		}
+	}
+
+	add := func(s Strategy) {
+		if metric, ok := s.Parameters["metric"].(string); ok {
+			addMetric(metric)
+		}
+		def, ok := strategy.Lookup(s.Type)
+		if !ok {
+			return
+		}
+		if def.Metrics != nil {
+			for _, metric := range def.Metrics(s.Parameters) {
+				addMetric(metric)
+			}
+		}
+		if def.LookbackDays != nil {
+			if window := def.LookbackDays(s.Parameters); window > reqs.windowDays {
+				reqs.windowDays = window
+			}
+		}
+		if metric, ok := s.Parameters["metric"].(string); ok && def.HourlyUsage && !seenHourly[metric] {
+			seenHourly[metric] = true
+			reqs.hourlyMetrics = append(reqs.hourlyMetrics, metric)
+		}
+		if def.LabelledUsage {
+			reqs.needsLabelled = true
+		}
+		if def.NodeLabels {
+			reqs.needsNodeLabels = true
+		}
+	}
+
+	for _, edge := range edges {
+		add(Strategy{Type: models.AllocationStrategy(edge.DefaultStrategy), Parameters: edge.DefaultParameters})
+		for _, override := range strategies[edge.ID] {
+			add(Strategy{Type: models.AllocationStrategy(override.Strategy), Parameters: override.Parameters})
+		}
+	}
+
+	return reqs
+}
+
+// indexUsage groups usage records by node and metric, preserving their order
+func indexUsage(usage []models.NodeUsageByDimension) map[uuid.UUID]map[string][]models.NodeUsageByDimension {
+	index := make(map[uuid.UUID]map[string][]models.NodeUsageByDimension)
+	for _, u := range usage {
+		if index[u.NodeID] == nil {
+			index[u.NodeID] = make(map[string][]models.NodeUsageByDimension)
+		}
+		index[u.NodeID][u.Metric] = append(index[u.NodeID][u.Metric], u)
+	}
+	return index
+}
+
+// attachHourlyUsage indexes the day's hourly usage by node and metric and folds it
+// into the usage checksum, so a change to it recomputes the day
+func (s *DaySnapshot) attachHourlyUsage(hourly []models.NodeUsageHourly) {
+	s.hourlyUsage = make(map[uuid.UUID]map[string][]models.NodeUsageHourly)
+	for _, u := range hourly {
+		if s.hourlyUsage[u.NodeID] == nil {
+			s.hourlyUsage[u.NodeID] = make(map[string][]models.NodeUsageHourly)
+		}
+		s.hourlyUsage[u.NodeID][u.Metric] = append(s.hourlyUsage[u.NodeID][u.Metric], u)
+	}
+	if len(hourly) > 0 {
+		s.usageChecksum = checksumLines([]string{s.usageChecksum, hourlyUsageChecksum(hourly)})
+	}
+}
+
+// attachNodes records the cost labels of the day's nodes. When a strategy in use
+// reads them they are folded into the strategy checksum, so a label change
+// recomputes the day.
+func (s *DaySnapshot) attachNodes(nodes map[uuid.UUID]*models.CostNode) {
+	s.nodeLabels = make(map[uuid.UUID]map[string]interface{}, len(nodes))
+	for id, node := range nodes {
+		s.nodeLabels[id] = node.CostLabels
+	}
+	if s.needsNodeLabels {
+		s.strategyChecksum = checksumLines([]string{s.strategyChecksum, nodeLabelChecksum(nodes)})
+	}
+}
+
+// Date returns the date the snapshot was taken for
+func (s *DaySnapshot) Date() time.Time {
+	return s.date
+}
+
+// ChildEdges returns the edges from a parent to its children, ordered by child ID
+func (s *DaySnapshot) ChildEdges(parentID uuid.UUID) []strategy.Edge {
+	return s.childEdges[parentID]
+}
+
+// ParentEdges returns the edges into a child from its parents, ordered by parent ID
+func (s *DaySnapshot) ParentEdges(childID uuid.UUID) []strategy.Edge {
+	return s.parentEdges[childID]
+}
+
+// UsageOn returns a node's usage of a metric on the snapshot date
+func (s *DaySnapshot) UsageOn(nodeID uuid.UUID, metric string) decimal.Decimal {
+	for _, u := range s.usage[nodeID][metric] {
+		if u.UsageDate.Equal(s.date) {
+			return u.Value
+		}
+	}
+	return decimal.Zero
+}
+
+// UsageBetween returns a node's usage records for a metric within an inclusive date range
+func (s *DaySnapshot) UsageBetween(nodeID uuid.UUID, metric string, startDate, endDate time.Time) []strategy.Usage {
+	var records []strategy.Usage
+	for _, u := range s.usage[nodeID][metric] {
+		if !u.UsageDate.Before(startDate) && !u.UsageDate.After(endDate) {
+			records = append(records, usageView(u))
+		}
+	}
+	return records
+}
+
+// LabelledUsageOn returns a node's labelled usage records for a metric on the snapshot date
+func (s *DaySnapshot) LabelledUsageOn(nodeID uuid.UUID, metric string) []strategy.Usage {
+	records := make([]strategy.Usage, 0, len(s.labelledUsage[nodeID][metric]))
+	for _, u := range s.labelledUsage[nodeID][metric] {
+		records = append(records, usageView(u))
+	}
+	return records
+}
+
+// usageView is a usage record as strategies see it
+func usageView(u models.NodeUsageByDimension) strategy.Usage {
+	return strategy.Usage{NodeID: u.NodeID, Date: u.UsageDate, Metric: u.Metric, Value: u.Value, Unit: u.Unit, Labels: u.Labels}
+}
+
+// HourlyUsageOn returns a node's usage of a metric in each hour (UTC) of the
+// snapshot date, and whether it has any hourly usage of the metric at all
+func (s *DaySnapshot) HourlyUsageOn(nodeID uuid.UUID, metric string) ([24]decimal.Decimal, bool) {
+	var hours [24]decimal.Decimal
+	records := s.hourlyUsage[nodeID][metric]
+	for _, u := range records {
+		hour := int(u.UsageHour.Sub(s.date) / time.Hour)
+		if hour >= 0 && hour < len(hours) {
+			hours[hour] = hours[hour].Add(u.Value)
+		}
+	}
+	return hours, len(records) > 0
+}
+
+// NodeLabel returns the value of one of a node's cost labels as a string
+func (s *DaySnapshot) NodeLabel(nodeID uuid.UUID, key string) (string, bool) {
+	value, ok := s.nodeLabels[nodeID][key]
+	if !ok || value == nil {
+		return "", false
+	}
+	if str, ok := value.(string); ok {
+		return str, true
+	}
+	return fmt.Sprint(value), true
+}
+
+// ParentCost returns a parent's holistic cost for a dimension, as set by the engine
+// before its shares are calculated, or zero if it has not been set
+func (s *DaySnapshot) ParentCost(parentID uuid.UUID, dimension string) decimal.Decimal {
+	return s.parentCosts[siblingKey{parentID: parentID, dimension: dimension}]
+}
+
+// setParentCost records a parent's holistic cost for a dimension
+func (s *DaySnapshot) setParentCost(parentID uuid.UUID, dimension string, cost decimal.Decimal) {
+	s.parentCosts[siblingKey{parentID: parentID, dimension: dimension}] = cost
+}
+
+// recordRecovery records how far a strategy's charges covered a parent's cost
+func (s *DaySnapshot) recordRecovery(parentID uuid.UUID, dimension string, r recovery) {
+	s.recoveries[siblingKey{parentID: parentID, dimension: dimension}] = r
+}
+
+// recoveryResults returns the day's recoveries as results, with under- and
+// over-recovery split out. unallocatedNodeID stands in for a nil sink.
+func (s *DaySnapshot) recoveryResults(runID uuid.UUID, unallocatedNodeID *uuid.UUID) []models.RecoveryResultByDimension {
+	results := make([]models.RecoveryResultByDimension, 0, len(s.recoveries))
+	for key, r := range s.recoveries {
+		sink := r.sink
+		if sink == nil {
+			sink = unallocatedNodeID
+		}
+
+		result := models.RecoveryResultByDimension{
+			RunID:          runID,
+			ParentID:       key.parentID,
+			AllocationDate: s.date,
+			Dimension:      key.dimension,
+			Strategy:       string(r.strategy),
+			SinkNodeID:     sink,
+			CostAmount:     r.cost,
+			ChargedAmount:  r.charged,
+			UnderRecovery:  decimal.Zero,
+			OverRecovery:   decimal.Zero,
+		}
+		if difference := r.cost.Sub(r.charged); difference.IsPositive() {
+			result.UnderRecovery = difference
+		} else {
+			result.OverRecovery = difference.Neg()
+		}
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].ParentID != results[j].ParentID {
+			return results[i].ParentID.String() < results[j].ParentID.String()
+		}
+		return results[i].Dimension < results[j].Dimension
+	})
+	return results
+}
+
+// ResolveStrategy resolves the allocation strategy for an edge and dimension
+func (s *DaySnapshot) ResolveStrategy(edge models.DependencyEdge, dimension string) *Strategy {
+	return resolveStrategy(edge, dimension, s.strategies[edge.ID])
+}
+
+// Shares returns the share vector for a parent under a strategy, computing it on first use
+func (s *DaySnapshot) Shares(strategy *Strategy, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+	key := shareKey{parentID: parentID, dimension: dimension, strategy: strategy.key()}
+	if cached, ok := s.shares[key]; ok {
+		return cached.shares, cached.err
+	}
+
+	shares, err := strategy.CalculateShares(s, parentID, dimension)
+	s.shares[key] = shareResult{shares: shares, err: err}
+	return shares, err
+}
+
+// SiblingShares returns the normalised share vector across all of a parent's children
+// for a dimension, and the share of the parent's cost left unallocated. Each child's
+// raw share comes from the strategy resolved for its own edge, so siblings on
+// different strategies are normalised together.
+func (s *DaySnapshot) SiblingShares(parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, decimal.Decimal, error) {
+	key := siblingKey{parentID: parentID, dimension: dimension}
+	if cached, ok := s.siblings[key]; ok {
+		return cached.shares, cached.remainder, cached.err
+	}
+
+	edges := s.edgesByParent[parentID]
+	raw := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	strategyFailed := false
+	for _, edge := range edges {
+		strategy := s.ResolveStrategy(edge, dimension)
+		shares, err := s.Shares(strategy, parentID, dimension)
+		if err != nil {
+			if s.remainderPolicy == RemainderFail {
+				err = fmt.Errorf("failed to calculate %s share for child %s: %w", strategy.Type, edge.ChildID, err)
+				s.siblings[key] = siblingResult{err: err}
+				return nil, decimal.Zero, err
+			}
+			log.Error().
+				Err(err).
+				Str("strategy", string(strategy.Type)).
+				Str("parent_id", parentID.String()).
+				Str("child_id", edge.ChildID.String()).
+				Str("dimension", dimension).
+				Msg("Failed to calculate share, child receives nothing")
+			raw[edge.ChildID] = decimal.Zero
+			strategyFailed = true
+			continue
+		}
+		raw[edge.ChildID] = shares[edge.ChildID]
+	}
+
+	shares, remainder, err := normaliseShares(raw, s.remainderPolicy)
+	if err != nil {
+		err = fmt.Errorf("parent %s dimension %s: %w", parentID, dimension, err)
+	}
+
+	// Attribute the remainder to the most specific cause
+	var reason models.UnallocatedReason
+	switch {
+	case remainder.IsZero():
+	case strategyFailed:
+		reason = models.UnallocatedReasonStrategyError
+	case s.underRecovered(key):
+		reason = models.UnallocatedReasonUnderRecovery
+	case s.noUsage[parentID]:
+		reason = models.UnallocatedReasonZeroUsageFallbackDisabled
+	default:
+		reason = models.UnallocatedReasonShareRemainder
+	}
+
+	s.siblings[key] = siblingResult{shares: shares, remainder: remainder, reason: reason, err: err}
+	return shares, remainder, err
+}
+
+// underRecovered reports whether a rate-based strategy without a sink node left
+// part of the parent's cost unrecovered
+func (s *DaySnapshot) underRecovered(key siblingKey) bool {
+	r, ok := s.recoveries[key]
+	return ok && r.sink == nil && r.charged.LessThan(r.cost)
+}
+
+// RemainderReason returns why SiblingShares left part of a parent's cost unallocated
+// for a dimension, or an empty reason if it left nothing or has not been called
+func (s *DaySnapshot) RemainderReason(parentID uuid.UUID, dimension string) models.UnallocatedReason {
+	return s.siblings[siblingKey{parentID: parentID, dimension: dimension}].reason
+}
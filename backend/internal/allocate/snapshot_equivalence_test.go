@@ -0,0 +1,465 @@
+package allocate
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// storeBackedShare computes one edge's share the way allocation did before day
+// snapshots: resolving the edge's strategy and reading whatever it needs with a
+// query per sibling, parent or hour series. The arithmetic helpers are shared
+// with the built-ins; only where the data comes from differs.
+func storeBackedShare(s *countingStore, edge models.DependencyEdge, dimension string, date time.Time) (decimal.Decimal, error) {
+	strategy := resolveStrategy(edge, dimension, s.GetStrategiesForEdge(edge.ID))
+	metric, _ := strategy.Parameters["metric"].(string)
+	siblings := s.GetByParentID(edge.ParentID)
+	equal := decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(siblings))))
+
+	switch strategy.Type {
+	case models.StrategyEqual:
+		return equal, nil
+
+	case models.StrategyProportionalOn:
+		return storeProportional(s, edge.ParentID, metric, date)[edge.ChildID], nil
+
+	case models.StrategyFixedPercent:
+		return strategy.percentParameter("percent")
+
+	case models.StrategyCappedProp:
+		cap, err := strategy.percentParameter("cap")
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return capShares(storeProportional(s, edge.ParentID, metric, date), cap)[edge.ChildID], nil
+
+	case models.StrategyResidualToMax:
+		parents := s.GetByChildID(edge.ChildID)
+		var maxUsage decimal.Decimal
+		var maxParentID uuid.UUID
+		for _, parent := range parents {
+			if usage := storeUsageOn(s, parent.ParentID, metric, date); usage.GreaterThan(maxUsage) {
+				maxUsage = usage
+				maxParentID = parent.ParentID
+			}
+		}
+		if edge.ParentID != maxParentID {
+			return storeProportional(s, edge.ParentID, metric, date)[edge.ChildID], nil
+		}
+		residual := decimal.NewFromInt(1)
+		for _, parent := range parents {
+			if parent.ParentID != maxParentID {
+				residual = residual.Sub(storeProportional(s, parent.ParentID, metric, date)[edge.ChildID])
+			}
+		}
+		if residual.IsNegative() {
+			return decimal.Zero, nil
+		}
+		return residual, nil
+
+	case models.StrategyWeightedAverage:
+		startDate := date.AddDate(0, 0, -(strategy.windowDays() - 1))
+		var total, child decimal.Decimal
+		for _, sibling := range siblings {
+			usage := s.GetByNodeAndDateRange(sibling.ChildID, startDate, date, metric)
+			var sum decimal.Decimal
+			for _, u := range usage {
+				sum = sum.Add(u.Value)
+			}
+			var avg decimal.Decimal
+			if len(usage) > 0 {
+				avg = sum.Div(decimal.NewFromInt(int64(len(usage))))
+			}
+			total = total.Add(avg)
+			if sibling.ChildID == edge.ChildID {
+				child = avg
+			}
+		}
+		if total.IsZero() {
+			return equal, nil
+		}
+		return child.Div(total), nil
+
+	case models.StrategyHybridFixedProp:
+		fixed, err := strategy.percentParameter("fixed_percent")
+		if err != nil {
+			return decimal.Zero, err
+		}
+		proportional := storeProportional(s, edge.ParentID, metric, date)[edge.ChildID]
+		return fixed.Mul(equal).Add(decimal.NewFromInt(1).Sub(fixed).Mul(proportional)), nil
+
+	case models.StrategyMinFloorProportional:
+		floor, err := strategy.percentParameter("min_floor_percent")
+		if err != nil {
+			return decimal.Zero, err
+		}
+		totalFloor := floor.Mul(decimal.NewFromInt(int64(len(siblings))))
+		if totalFloor.GreaterThanOrEqual(decimal.NewFromInt(1)) {
+			return equal, nil
+		}
+		proportional := storeProportional(s, edge.ParentID, metric, date)[edge.ChildID]
+		return floor.Add(decimal.NewFromInt(1).Sub(totalFloor).Mul(proportional)), nil
+
+	case models.StrategySegmentFilteredProp:
+		filter, err := strategy.parseSegmentFilter()
+		if err != nil {
+			return decimal.Zero, err
+		}
+		var total, child decimal.Decimal
+		for _, sibling := range siblings {
+			var usage decimal.Decimal
+			for _, u := range s.GetLabelledByNodeAndDate(sibling.ChildID, date, metric) {
+				if filter == nil || matchesLabelFilter(u.Labels, *filter) {
+					usage = usage.Add(u.Value)
+				}
+			}
+			total = total.Add(usage)
+			if sibling.ChildID == edge.ChildID {
+				child = usage
+			}
+		}
+		if total.IsZero() {
+			return equal, nil
+		}
+		return child.Div(total), nil
+
+	case models.StrategyFormula:
+		return storeFormulaShare(s, strategy, edge, siblings, date)
+
+	case models.StrategyTieredRate:
+		return storeTieredRateShare(s, strategy, edge, siblings, metric, dimension, date)
+
+	case models.StrategyPeakCoincident:
+		var combined [24]decimal.Decimal
+		hoursByChild := make(map[uuid.UUID][24]decimal.Decimal, len(siblings))
+		for _, sibling := range siblings {
+			hours, _ := storeHourlyUsage(s, sibling.ChildID, metric, date)
+			hoursByChild[sibling.ChildID] = hours
+			for hour, value := range hours {
+				combined[hour] = combined[hour].Add(value)
+			}
+		}
+		load, ok := storeHourlyUsage(s, edge.ParentID, metric, date)
+		if !ok {
+			load = combined
+		}
+		peaks := busiestHours(load, strategy.peakHours())
+		var total, child decimal.Decimal
+		for _, sibling := range siblings {
+			for _, hour := range peaks {
+				total = total.Add(hoursByChild[sibling.ChildID][hour])
+				if sibling.ChildID == edge.ChildID {
+					child = child.Add(hoursByChild[sibling.ChildID][hour])
+				}
+			}
+		}
+		if total.IsZero() {
+			return equal, nil
+		}
+		return child.Div(total), nil
+	}
+
+	return decimal.Zero, fmt.Errorf("no store-backed share for strategy %s", strategy.Type)
+}
+
+// storeUsageOn reads a node's usage of a metric on a date
+func storeUsageOn(s *countingStore, nodeID uuid.UUID, metric string, date time.Time) decimal.Decimal {
+	var total decimal.Decimal
+	for _, u := range s.GetByNodeAndDateRange(nodeID, date, date, metric) {
+		total = total.Add(u.Value)
+	}
+	return total
+}
+
+// storeProportional splits by each of a parent's children's usage on a date,
+// falling back to equal shares when none has usage
+func storeProportional(s *countingStore, parentID uuid.UUID, metric string, date time.Time) map[uuid.UUID]decimal.Decimal {
+	siblings := s.GetByParentID(parentID)
+	usage := make(map[uuid.UUID]decimal.Decimal, len(siblings))
+	var total decimal.Decimal
+	for _, sibling := range siblings {
+		usage[sibling.ChildID] = storeUsageOn(s, sibling.ChildID, metric, date)
+		total = total.Add(usage[sibling.ChildID])
+	}
+
+	shares := make(map[uuid.UUID]decimal.Decimal, len(siblings))
+	for childID, value := range usage {
+		if total.IsZero() {
+			shares[childID] = decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(siblings))))
+			continue
+		}
+		shares[childID] = value.Div(total)
+	}
+	return shares
+}
+
+// storeHourlyUsage reads a node's usage of a metric in each hour of a date
+func storeHourlyUsage(s *countingStore, nodeID uuid.UUID, metric string, date time.Time) ([24]decimal.Decimal, bool) {
+	var hours [24]decimal.Decimal
+	usage := s.GetHourlyByNodeAndDate(nodeID, date, metric)
+	for _, u := range usage {
+		hour := int(u.UsageHour.Sub(date) / time.Hour)
+		hours[hour] = hours[hour].Add(u.Value)
+	}
+	return hours, len(usage) > 0
+}
+
+func storeFormulaShare(s *countingStore, strategy *Strategy, edge models.DependencyEdge, siblings []models.DependencyEdge, date time.Time) (decimal.Decimal, error) {
+	expr, err := models.ParseFormulaParameters(strategy.Parameters)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	fixed, err := strategy.percentParameter("fixed_percent")
+	if err != nil {
+		return decimal.Zero, err
+	}
+	minPercent, err := strategy.percentParameter("min_percent")
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	var total decimal.Decimal
+	weights := make(map[uuid.UUID]decimal.Decimal, len(siblings))
+	for _, sibling := range siblings {
+		weight, err := expr.Evaluate(storeFormulaEnv{store: s, nodeID: sibling.ChildID, date: date})
+		if err != nil {
+			return decimal.Zero, err
+		}
+		weights[sibling.ChildID] = weight
+		total = total.Add(weight)
+	}
+
+	count := decimal.NewFromInt(int64(len(siblings)))
+	shares := make(map[uuid.UUID]decimal.Decimal, len(siblings))
+	for childID, weight := range weights {
+		variable := decimal.NewFromInt(1).Div(count)
+		if !total.IsZero() {
+			variable = weight.Div(total)
+		}
+		shares[childID] = fixed.Div(count).Add(decimal.NewFromInt(1).Sub(fixed).Mul(variable))
+	}
+	if minPercent.IsPositive() {
+		shares = floorShares(shares, minPercent)
+	}
+	return shares[edge.ChildID], nil
+}
+
+// storeFormulaEnv answers a formula's usage and label lookups with store queries
+type storeFormulaEnv struct {
+	store  *countingStore
+	nodeID uuid.UUID
+	date   time.Time
+}
+
+func (e storeFormulaEnv) Usage(metric string) decimal.Decimal {
+	return storeUsageOn(e.store, e.nodeID, metric, e.date)
+}
+
+func (e storeFormulaEnv) Label(key string) (string, bool) {
+	node := e.store.GetNode(e.nodeID)
+	if node == nil || node.CostLabels[key] == nil {
+		return "", false
+	}
+	return fmt.Sprint(node.CostLabels[key]), true
+}
+
+func storeTieredRateShare(s *countingStore, strategy *Strategy, edge models.DependencyEdge, siblings []models.DependencyEdge, metric, dimension string, date time.Time) (decimal.Decimal, error) {
+	tiers, err := models.ParseRateTiers(strategy.Parameters)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	var sink *uuid.UUID
+	if raw, ok := strategy.Parameters["sink_node"].(string); ok {
+		id := uuid.MustParse(raw)
+		sink = &id
+	}
+
+	var charged, charge decimal.Decimal
+	for _, sibling := range siblings {
+		if sink != nil && sibling.ChildID == *sink {
+			continue
+		}
+		value := priceTiers(tiers, storeUsageOn(s, sibling.ChildID, metric, date))
+		charged = charged.Add(value)
+		if sibling.ChildID == edge.ChildID {
+			charge = value
+		}
+	}
+
+	cost := s.GetParentCost(edge.ParentID, dimension)
+	switch {
+	case !cost.IsPositive() || charged.GreaterThan(cost):
+		if charged.IsZero() {
+			return decimal.Zero, nil
+		}
+		return charge.Div(charged), nil
+	case sink != nil && edge.ChildID == *sink:
+		return cost.Sub(charged).Div(cost), nil
+	default:
+		return charge.Div(cost), nil
+	}
+}
+
+// snapshotFromStore loads a day snapshot from the store the way loadDaySnapshot
+// does, reading only what collectUsageRequirements asks for
+func snapshotFromStore(s *countingStore, edges []models.DependencyEdge, date time.Time) *DaySnapshot {
+	reqs := collectUsageRequirements(edges, s.overrides)
+
+	var usage, labelled []models.NodeUsageByDimension
+	startDate := date.AddDate(0, 0, -(reqs.windowDays - 1))
+	for nodeID := range s.usageByNode {
+		for _, metric := range reqs.metrics {
+			usage = append(usage, s.dailyTotals(nodeID, startDate, date, metric)...)
+		}
+		if reqs.needsLabelled {
+			for _, u := range s.usageByNode[nodeID] {
+				if u.UsageDate.Equal(date) {
+					labelled = append(labelled, u)
+				}
+			}
+		}
+	}
+
+	var hourly []models.NodeUsageHourly
+	for _, records := range s.hourlyByNode {
+		for _, u := range records {
+			if containsString(reqs.hourlyMetrics, u.Metric) {
+				hourly = append(hourly, u)
+			}
+		}
+	}
+
+	snap := newDaySnapshot(date, edges, s.overrides, usage, labelled)
+	snap.attachHourlyUsage(hourly)
+	snap.needsNodeLabels = reqs.needsNodeLabels
+	snap.attachNodes(s.nodes)
+	for key, cost := range s.parentCosts {
+		snap.setParentCost(key.parentID, key.dimension, cost)
+	}
+	return snap
+}
+
+// TestSnapshotMatchesStoreBackedShares checks that every built-in strategy gives
+// the same shares from a day snapshot as from per-edge store queries, on a
+// fixture where one child has two parents and a dimension override
+func TestSnapshotMatchesStoreBackedShares(t *testing.T) {
+	parentA, parentB := uuid.New(), uuid.New()
+	shared, web, batch, cache := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	previous := snapshotDate.AddDate(0, 0, -1)
+	hour := func(h int) time.Time { return snapshotDate.Add(time.Duration(h) * time.Hour) }
+	labelled := func(nodeID uuid.UUID, value float64, customer string) models.NodeUsageByDimension {
+		u := usageRecord(nodeID, snapshotDate, "requests", value)
+		u.Labels = map[string]string{"customer": customer}
+		return u
+	}
+
+	usage := []models.NodeUsageByDimension{
+		labelled(shared, 120, "acme"),
+		labelled(shared, 80, "globex"),
+		labelled(web, 300, "acme"),
+		labelled(batch, 50, "globex"),
+		usageRecord(batch, snapshotDate, "requests", 150),
+		usageRecord(cache, snapshotDate, "requests", 40),
+		usageRecord(shared, snapshotDate, "cpu_hours", 10),
+		usageRecord(web, snapshotDate, "cpu_hours", 30),
+		usageRecord(web, snapshotDate, "egress", 7),
+		usageRecord(batch, snapshotDate, "egress", 3),
+		usageRecord(parentA, snapshotDate, "requests", 900),
+		usageRecord(parentB, snapshotDate, "requests", 100),
+		// Earlier days for the weighted average window
+		usageRecord(shared, previous, "requests", 400),
+		usageRecord(cache, previous, "requests", 10),
+		// Outside a 2-day window
+		usageRecord(web, snapshotDate.AddDate(0, 0, -2), "requests", 5000),
+	}
+	hourly := []models.NodeUsageHourly{
+		{NodeID: shared, UsageHour: hour(9), Metric: "requests", Value: decimal.NewFromInt(60)},
+		{NodeID: shared, UsageHour: hour(14), Metric: "requests", Value: decimal.NewFromInt(10)},
+		{NodeID: web, UsageHour: hour(9), Metric: "requests", Value: decimal.NewFromInt(20)},
+		{NodeID: web, UsageHour: hour(14), Metric: "requests", Value: decimal.NewFromInt(90)},
+		{NodeID: batch, UsageHour: hour(2), Metric: "requests", Value: decimal.NewFromInt(200)},
+		{NodeID: cache, UsageHour: hour(14), Metric: "requests", Value: decimal.NewFromInt(30)},
+		// parentB's own load peaks at 02:00
+		{NodeID: parentB, UsageHour: hour(2), Metric: "requests", Value: decimal.NewFromInt(500)},
+		{NodeID: parentB, UsageHour: hour(14), Metric: "requests", Value: decimal.NewFromInt(100)},
+	}
+	nodes := map[uuid.UUID]*models.CostNode{
+		shared: {ID: shared, CostLabels: map[string]interface{}{"tier": "gold"}},
+		web:    {ID: web, CostLabels: map[string]interface{}{"tier": "silver"}},
+		batch:  {ID: batch, CostLabels: map[string]interface{}{}},
+		cache:  {ID: cache, CostLabels: map[string]interface{}{"tier": "gold"}},
+	}
+
+	tiers := []interface{}{
+		map[string]interface{}{"up_to": 100.0, "rate": 1.0},
+		map[string]interface{}{"rate": 0.5},
+	}
+	cases := []struct {
+		strategy models.AllocationStrategy
+		params   map[string]interface{}
+	}{
+		{models.StrategyEqual, nil},
+		{models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}},
+		{models.StrategyFixedPercent, map[string]interface{}{"percent": 30.0}},
+		{models.StrategyCappedProp, map[string]interface{}{"metric": "requests", "cap": 45.0}},
+		{models.StrategyResidualToMax, map[string]interface{}{"metric": "requests"}},
+		{models.StrategyWeightedAverage, map[string]interface{}{"metric": "requests", "window_days": 2.0}},
+		{models.StrategyHybridFixedProp, map[string]interface{}{"metric": "requests", "fixed_percent": 40.0}},
+		{models.StrategyMinFloorProportional, map[string]interface{}{"metric": "requests", "min_floor_percent": 10.0}},
+		{models.StrategySegmentFilteredProp, map[string]interface{}{
+			"metric":         "requests",
+			"segment_filter": map[string]interface{}{"label": "customer", "values": []interface{}{"acme"}},
+		}},
+		{models.StrategyFormula, map[string]interface{}{
+			"expression":  "if(label('tier') == 'gold', 2, 1) * (requests + cpu_hours)",
+			"min_percent": 15.0,
+		}},
+		{models.StrategyTieredRate, map[string]interface{}{"metric": "requests", "tiers": tiers, "sink_node": shared.String()}},
+		{models.StrategyPeakCoincident, map[string]interface{}{"metric": "requests", "peak_hours": 1.0}},
+	}
+
+	for _, tc := range cases {
+		t.Run(string(tc.strategy), func(t *testing.T) {
+			edges := []models.DependencyEdge{
+				strategyEdge(parentA, shared, tc.strategy, tc.params),
+				strategyEdge(parentA, web, tc.strategy, tc.params),
+				strategyEdge(parentA, batch, tc.strategy, tc.params),
+				strategyEdge(parentB, shared, tc.strategy, tc.params),
+				strategyEdge(parentB, cache, tc.strategy, tc.params),
+			}
+			s := newCountingStore(edges, usage)
+			for _, u := range hourly {
+				s.hourlyByNode[u.NodeID] = append(s.hourlyByNode[u.NodeID], u)
+			}
+			s.nodes = nodes
+			s.parentCosts[siblingKey{parentID: parentA, dimension: "cost"}] = decimal.NewFromInt(1000)
+			s.parentCosts[siblingKey{parentID: parentB, dimension: "cost"}] = decimal.NewFromInt(50)
+			egress := "egress_gb"
+			s.overrides[edges[1].ID] = []models.EdgeStrategy{{
+				EdgeID:     edges[1].ID,
+				Dimension:  &egress,
+				Strategy:   string(models.StrategyProportionalOn),
+				Parameters: map[string]interface{}{"metric": "egress"},
+			}}
+
+			snap := snapshotFromStore(s, edges, snapshotDate)
+			for _, edge := range edges {
+				for _, dim := range []string{"cost", egress} {
+					want, err := storeBackedShare(s, edge, dim, snapshotDate)
+					require.NoError(t, err)
+
+					shares, err := snap.Shares(snap.ResolveStrategy(edge, dim), edge.ParentID, dim)
+					require.NoError(t, err)
+					assert.Equal(t, want.StringFixed(12), shares[edge.ChildID].StringFixed(12),
+						"%s -> %s (%s)", edge.ParentID, edge.ChildID, dim)
+				}
+			}
+		})
+	}
+}
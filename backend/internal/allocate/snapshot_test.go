@@ -0,0 +1,483 @@
+package allocate
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var snapshotDate = time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+
+func usageRecord(nodeID uuid.UUID, date time.Time, metric string, value float64) models.NodeUsageByDimension {
+	return models.NodeUsageByDimension{NodeID: nodeID, UsageDate: date, Metric: metric, Value: decimal.NewFromFloat(value)}
+}
+
+func strategyEdge(parentID, childID uuid.UUID, strategy models.AllocationStrategy, params map[string]interface{}) models.DependencyEdge {
+	return models.DependencyEdge{
+		ID:                uuid.New(),
+		ParentID:          parentID,
+		ChildID:           childID,
+		DefaultStrategy:   string(strategy),
+		DefaultParameters: params,
+		ActiveFrom:        snapshotDate.AddDate(0, 0, -30),
+	}
+}
+
+func assertShare(t *testing.T, expected string, actual decimal.Decimal, msgAndArgs ...interface{}) {
+	t.Helper()
+	assert.Equal(t, expected, actual.StringFixed(4), msgAndArgs...)
+}
+
+// TestSnapshotShareVectors checks each strategy's share vector against hand-computed values
+func TestSnapshotShareVectors(t *testing.T) {
+	parent := uuid.New()
+	childA := uuid.New()
+	childB := uuid.New()
+	childC := uuid.New()
+	children := []uuid.UUID{childA, childB, childC}
+
+	usage := []models.NodeUsageByDimension{
+		usageRecord(childA, snapshotDate, "requests", 100),
+		usageRecord(childB, snapshotDate, "requests", 300),
+		usageRecord(childC, snapshotDate, "requests", 600),
+		// Earlier days for the weighted average window
+		usageRecord(childA, snapshotDate.AddDate(0, 0, -1), "requests", 500),
+		usageRecord(childC, snapshotDate.AddDate(0, 0, -1), "requests", 0),
+		// Outside a 2-day window
+		usageRecord(childB, snapshotDate.AddDate(0, 0, -2), "requests", 10000),
+	}
+
+	build := func(strategy models.AllocationStrategy, params map[string]interface{}) *DaySnapshot {
+		var edges []models.DependencyEdge
+		for _, child := range children {
+			edges = append(edges, strategyEdge(parent, child, strategy, params))
+		}
+		return newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+	}
+
+	shares := func(t *testing.T, snap *DaySnapshot) map[uuid.UUID]decimal.Decimal {
+		edge := snap.edgesByParent[parent][0]
+		result, err := snap.Shares(snap.ResolveStrategy(edge, "cost"), parent, "cost")
+		require.NoError(t, err)
+		return result
+	}
+
+	t.Run("equal", func(t *testing.T) {
+		result := shares(t, build(models.StrategyEqual, nil))
+		for _, child := range children {
+			assertShare(t, "0.3333", result[child])
+		}
+	})
+
+	t.Run("proportional_on", func(t *testing.T) {
+		result := shares(t, build(models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}))
+		assertShare(t, "0.1000", result[childA])
+		assertShare(t, "0.3000", result[childB])
+		assertShare(t, "0.6000", result[childC])
+	})
+
+	t.Run("proportional_on falls back to equal without usage", func(t *testing.T) {
+		result := shares(t, build(models.StrategyProportionalOn, map[string]interface{}{"metric": "missing"}))
+		for _, child := range children {
+			assertShare(t, "0.3333", result[child])
+		}
+	})
+
+	t.Run("proportional_on requires metric", func(t *testing.T) {
+		snap := build(models.StrategyProportionalOn, nil)
+		_, err := snap.Shares(snap.ResolveStrategy(snap.edgesByParent[parent][0], "cost"), parent, "cost")
+		assert.Error(t, err)
+	})
+
+	t.Run("fixed_percent", func(t *testing.T) {
+		result := shares(t, build(models.StrategyFixedPercent, map[string]interface{}{"percent": 25.0}))
+		for _, child := range children {
+			assertShare(t, "0.2500", result[child])
+		}
+	})
+
+	t.Run("capped_proportional", func(t *testing.T) {
+		// C is capped at 0.5 and its 0.1 excess is redistributed 1:3 to A and B
+		result := shares(t, build(models.StrategyCappedProp, map[string]interface{}{"metric": "requests", "cap": "0.5"}))
+		assertShare(t, "0.1250", result[childA])
+		assertShare(t, "0.3750", result[childB])
+		assertShare(t, "0.5000", result[childC])
+	})
+
+	t.Run("capped_proportional redistributes until no child exceeds the cap", func(t *testing.T) {
+		// At 0.35 C is capped, then B's redistributed share (0.4875) is capped too
+		result := shares(t, build(models.StrategyCappedProp, map[string]interface{}{"metric": "requests", "cap": 35.0}))
+		assertShare(t, "0.3000", result[childA])
+		assertShare(t, "0.3500", result[childB])
+		assertShare(t, "0.3500", result[childC])
+	})
+
+	t.Run("weighted_average", func(t *testing.T) {
+		// 2-day window: A avg (100+500)/2=300, B avg 300, C avg (600+0)/2=300
+		result := shares(t, build(models.StrategyWeightedAverage, map[string]interface{}{"metric": "requests", "window_days": 2.0}))
+		for _, child := range children {
+			assertShare(t, "0.3333", result[child])
+		}
+	})
+
+	t.Run("hybrid_fixed_proportional", func(t *testing.T) {
+		// 40% equal (0.1333 each) + 60% proportional
+		result := shares(t, build(models.StrategyHybridFixedProp, map[string]interface{}{"metric": "requests", "fixed_percent": 40.0}))
+		assertShare(t, "0.1933", result[childA])
+		assertShare(t, "0.3133", result[childB])
+		assertShare(t, "0.4933", result[childC])
+	})
+
+	t.Run("min_floor_proportional", func(t *testing.T) {
+		// 10% floor each, remaining 70% proportional
+		result := shares(t, build(models.StrategyMinFloorProportional, map[string]interface{}{"metric": "requests", "min_floor_percent": 10.0}))
+		assertShare(t, "0.1700", result[childA])
+		assertShare(t, "0.3100", result[childB])
+		assertShare(t, "0.5200", result[childC])
+	})
+}
+
+// TestSnapshotResidualToMax checks that the parent with most usage absorbs the residual
+func TestSnapshotResidualToMax(t *testing.T) {
+	big := uuid.New()
+	small := uuid.New()
+	child := uuid.New()
+	other := uuid.New()
+	params := map[string]interface{}{"metric": "requests"}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(big, child, models.StrategyResidualToMax, params),
+		strategyEdge(small, child, models.StrategyResidualToMax, params),
+		strategyEdge(small, other, models.StrategyResidualToMax, params),
+	}
+	usage := []models.NodeUsageByDimension{
+		usageRecord(big, snapshotDate, "requests", 900),
+		usageRecord(small, snapshotDate, "requests", 100),
+		usageRecord(child, snapshotDate, "requests", 250),
+		usageRecord(other, snapshotDate, "requests", 750),
+	}
+	snap := newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+	strategy := snap.ResolveStrategy(edges[0], "cost")
+
+	// small is not the max parent of child, so it uses its proportional share: 250/1000.
+	// It is the only (and so max) parent of other, which it absorbs entirely.
+	smallShares, err := snap.Shares(strategy, small, "cost")
+	require.NoError(t, err)
+	assertShare(t, "0.2500", smallShares[child])
+	assertShare(t, "1.0000", smallShares[other])
+
+	// big is the max parent and takes what small leaves: 1 - 0.25
+	bigShares, err := snap.Shares(strategy, big, "cost")
+	require.NoError(t, err)
+	assertShare(t, "0.7500", bigShares[child])
+}
+
+// TestSnapshotSegmentFilteredProportional checks label filters match the SQL operators
+func TestSnapshotSegmentFilteredProportional(t *testing.T) {
+	parent := uuid.New()
+	childA := uuid.New()
+	childB := uuid.New()
+
+	labelled := func(nodeID uuid.UUID, value float64, labels map[string]string) models.NodeUsageByDimension {
+		u := usageRecord(nodeID, snapshotDate, "requests", value)
+		u.Labels = labels
+		return u
+	}
+	records := []models.NodeUsageByDimension{
+		labelled(childA, 100, map[string]string{"customer_id": "acme"}),
+		labelled(childA, 50, map[string]string{"customer_id": "globex"}),
+		labelled(childB, 300, map[string]string{"customer_id": "acme"}),
+		labelled(childB, 25, nil),
+	}
+
+	cases := []struct {
+		name     string
+		filter   map[string]interface{}
+		expected [2]string
+	}{
+		{"eq", map[string]interface{}{"label": "customer_id", "value": "acme"}, [2]string{"0.2500", "0.7500"}},
+		{"in", map[string]interface{}{"label": "customer_id", "values": []interface{}{"acme", "globex"}}, [2]string{"0.3333", "0.6667"}},
+		{"neq", map[string]interface{}{"label": "customer_id", "operator": "neq", "values": []interface{}{"acme"}}, [2]string{"0.6667", "0.3333"}},
+		{"not_exists", map[string]interface{}{"label": "customer_id", "operator": "not_exists"}, [2]string{"0.0000", "1.0000"}},
+		{"no matches falls back to equal", map[string]interface{}{"label": "customer_id", "value": "initech"}, [2]string{"0.5000", "0.5000"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := map[string]interface{}{"metric": "requests", "segment_filter": tc.filter}
+			edges := []models.DependencyEdge{
+				strategyEdge(parent, childA, models.StrategySegmentFilteredProp, params),
+				strategyEdge(parent, childB, models.StrategySegmentFilteredProp, params),
+			}
+			snap := newDaySnapshot(snapshotDate, edges, nil, nil, records)
+
+			shares, err := snap.Shares(snap.ResolveStrategy(edges[0], "cost"), parent, "cost")
+			require.NoError(t, err)
+			assertShare(t, tc.expected[0], shares[childA])
+			assertShare(t, tc.expected[1], shares[childB])
+		})
+	}
+}
+
+// TestSnapshotResolveStrategy checks override precedence
+func TestSnapshotResolveStrategy(t *testing.T) {
+	edge := strategyEdge(uuid.New(), uuid.New(), models.StrategyEqual, nil)
+	dimension := "egress_gb"
+
+	snap := newDaySnapshot(snapshotDate, []models.DependencyEdge{edge}, map[uuid.UUID][]models.EdgeStrategy{
+		edge.ID: {
+			{EdgeID: edge.ID, Dimension: nil, Strategy: string(models.StrategyFixedPercent)},
+			{EdgeID: edge.ID, Dimension: &dimension, Strategy: string(models.StrategyProportionalOn)},
+		},
+	}, nil, nil)
+
+	assert.Equal(t, models.StrategyProportionalOn, snap.ResolveStrategy(edge, dimension).Type, "dimension override wins")
+	assert.Equal(t, models.StrategyFixedPercent, snap.ResolveStrategy(edge, "cost").Type, "default override applies to other dimensions")
+
+	plain := newDaySnapshot(snapshotDate, []models.DependencyEdge{edge}, nil, nil, nil)
+	assert.Equal(t, models.StrategyEqual, plain.ResolveStrategy(edge, "cost").Type, "edge default when no overrides")
+}
+
+// TestSnapshotEdgeOrder checks edges are ordered by ID whatever order they were loaded in
+func TestSnapshotEdgeOrder(t *testing.T) {
+	parent := uuid.New()
+	child := uuid.New()
+	var edges []models.DependencyEdge
+	for i := 0; i < 8; i++ {
+		edges = append(edges, strategyEdge(parent, uuid.New(), models.StrategyEqual, nil))
+		edges = append(edges, strategyEdge(uuid.New(), child, models.StrategyEqual, nil))
+	}
+	snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+
+	children := snap.ChildEdges(parent)
+	require.Len(t, children, 8)
+	assert.True(t, sort.SliceIsSorted(children, func(i, j int) bool { return children[i].ChildID.String() < children[j].ChildID.String() }))
+	parents := snap.ParentEdges(child)
+	require.Len(t, parents, 8)
+	assert.True(t, sort.SliceIsSorted(parents, func(i, j int) bool { return parents[i].ParentID.String() < parents[j].ParentID.String() }))
+}
+
+// TestSnapshotTraversal runs the in-memory traversal over a small DAG and checks the amounts.
+//
+//	db ($1000, proportional on requests) → platform (30%), app (70%)
+//	platform (equal) → app, web
+func TestSnapshotTraversal(t *testing.T) {
+	db := models.CostNode{ID: uuid.New(), Name: "db", Type: string(models.NodeTypeShared)}
+	platform := models.CostNode{ID: uuid.New(), Name: "platform", Type: string(models.NodeTypePlatform)}
+	app := models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	nodes := []models.CostNode{db, platform, app, web}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(db.ID, platform.ID, models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}),
+		strategyEdge(db.ID, app.ID, models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}),
+		strategyEdge(platform.ID, app.ID, models.StrategyEqual, nil),
+		strategyEdge(platform.ID, web.ID, models.StrategyEqual, nil),
+	}
+	usage := []models.NodeUsageByDimension{
+		usageRecord(platform.ID, snapshotDate, "requests", 30),
+		usageRecord(app.ID, snapshotDate, "requests", 70),
+	}
+
+	g := graph.NewGraph(snapshotDate, nodes, edges)
+	order, err := g.TopologicalSort()
+	require.NoError(t, err)
+
+	dimensions := []string{"cost"}
+	costsByNode := map[uuid.UUID]map[string]decimal.Decimal{
+		db.ID:       {"cost": decimal.NewFromInt(1000)},
+		platform.ID: {"cost": decimal.NewFromInt(100)},
+	}
+
+	e := &Engine{}
+	snap := newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+	indirectCosts := e.initializeIndirectCosts(g, dimensions)
+	allocations, contributions, _, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+	require.NoError(t, err)
+
+	totals := make(map[uuid.UUID]string)
+	for _, alloc := range allocations {
+		totals[alloc.NodeID] = alloc.TotalAmount.StringFixed(2)
+	}
+
+	// platform: 100 direct + 300 from db = 400, split 200/200
+	assert.Equal(t, "1000.00", totals[db.ID])
+	assert.Equal(t, "400.00", totals[platform.ID])
+	assert.Equal(t, "900.00", totals[app.ID], "app gets 700 from db and 200 from platform")
+	assert.Equal(t, "200.00", totals[web.ID])
+	assert.Len(t, contributions, 4)
+}
+
+// countingStore serves the queries allocation makes from memory and counts
+// each one, standing in for a database round trip
+type countingStore struct {
+	edgesByParent map[uuid.UUID][]models.DependencyEdge
+	edgesByChild  map[uuid.UUID][]models.DependencyEdge
+	overrides     map[uuid.UUID][]models.EdgeStrategy
+	usageByNode   map[uuid.UUID][]models.NodeUsageByDimension
+	hourlyByNode  map[uuid.UUID][]models.NodeUsageHourly
+	nodes         map[uuid.UUID]*models.CostNode
+	parentCosts   map[siblingKey]decimal.Decimal
+	queries       int
+}
+
+func newCountingStore(edges []models.DependencyEdge, usage []models.NodeUsageByDimension) *countingStore {
+	s := &countingStore{
+		edgesByParent: make(map[uuid.UUID][]models.DependencyEdge),
+		edgesByChild:  make(map[uuid.UUID][]models.DependencyEdge),
+		overrides:     make(map[uuid.UUID][]models.EdgeStrategy),
+		usageByNode:   make(map[uuid.UUID][]models.NodeUsageByDimension),
+		hourlyByNode:  make(map[uuid.UUID][]models.NodeUsageHourly),
+		nodes:         make(map[uuid.UUID]*models.CostNode),
+		parentCosts:   make(map[siblingKey]decimal.Decimal),
+	}
+	for _, edge := range edges {
+		s.edgesByParent[edge.ParentID] = append(s.edgesByParent[edge.ParentID], edge)
+		s.edgesByChild[edge.ChildID] = append(s.edgesByChild[edge.ChildID], edge)
+	}
+	for _, u := range usage {
+		s.usageByNode[u.NodeID] = append(s.usageByNode[u.NodeID], u)
+	}
+	return s
+}
+
+func (s *countingStore) GetStrategiesForEdge(edgeID uuid.UUID) []models.EdgeStrategy {
+	s.queries++
+	return s.overrides[edgeID]
+}
+
+func (s *countingStore) GetByParentID(parentID uuid.UUID) []models.DependencyEdge {
+	s.queries++
+	return s.edgesByParent[parentID]
+}
+
+func (s *countingStore) GetByChildID(childID uuid.UUID) []models.DependencyEdge {
+	s.queries++
+	return s.edgesByChild[childID]
+}
+
+// GetByNodeAndDateRange returns a node's daily usage totals of a metric, with
+// its label sets combined as the usage repository does
+func (s *countingStore) GetByNodeAndDateRange(nodeID uuid.UUID, start, end time.Time, metric string) []models.NodeUsageByDimension {
+	s.queries++
+	return s.dailyTotals(nodeID, start, end, metric)
+}
+
+func (s *countingStore) dailyTotals(nodeID uuid.UUID, start, end time.Time, metric string) []models.NodeUsageByDimension {
+	var usage []models.NodeUsageByDimension
+	byDate := make(map[time.Time]int)
+	for _, u := range s.usageByNode[nodeID] {
+		if u.Metric != metric || u.UsageDate.Before(start) || u.UsageDate.After(end) {
+			continue
+		}
+		if i, ok := byDate[u.UsageDate]; ok {
+			usage[i].Value = usage[i].Value.Add(u.Value)
+			continue
+		}
+		byDate[u.UsageDate] = len(usage)
+		usage = append(usage, models.NodeUsageByDimension{NodeID: nodeID, UsageDate: u.UsageDate, Metric: metric, Value: u.Value})
+	}
+	return usage
+}
+
+// GetLabelledByNodeAndDate returns a node's usage rows of a metric on a date,
+// one per label set
+func (s *countingStore) GetLabelledByNodeAndDate(nodeID uuid.UUID, date time.Time, metric string) []models.NodeUsageByDimension {
+	s.queries++
+	var usage []models.NodeUsageByDimension
+	for _, u := range s.usageByNode[nodeID] {
+		if u.Metric == metric && u.UsageDate.Equal(date) {
+			usage = append(usage, u)
+		}
+	}
+	return usage
+}
+
+func (s *countingStore) GetHourlyByNodeAndDate(nodeID uuid.UUID, date time.Time, metric string) []models.NodeUsageHourly {
+	s.queries++
+	var usage []models.NodeUsageHourly
+	for _, u := range s.hourlyByNode[nodeID] {
+		if u.Metric == metric && !u.UsageHour.Before(date) && u.UsageHour.Before(date.AddDate(0, 0, 1)) {
+			usage = append(usage, u)
+		}
+	}
+	return usage
+}
+
+func (s *countingStore) GetNode(nodeID uuid.UUID) *models.CostNode {
+	s.queries++
+	return s.nodes[nodeID]
+}
+
+func (s *countingStore) GetParentCost(parentID uuid.UUID, dimension string) decimal.Decimal {
+	s.queries++
+	return s.parentCosts[siblingKey{parentID: parentID, dimension: dimension}]
+}
+
+// BenchmarkShareCalculation compares the store-backed per-edge share calculation
+// against loading a day snapshot once and reusing its per-parent share vectors.
+// Queries per operation are reported alongside the time, as each one is a
+// database round trip outside the benchmark.
+func BenchmarkShareCalculation(b *testing.B) {
+	const parents, childrenPerParent = 20, 50
+	var edges []models.DependencyEdge
+	var usage []models.NodeUsageByDimension
+	params := map[string]interface{}{"metric": "requests", "window_days": 7.0}
+
+	for p := 0; p < parents; p++ {
+		parentID := uuid.New()
+		for c := 0; c < childrenPerParent; c++ {
+			childID := uuid.New()
+			edges = append(edges, strategyEdge(parentID, childID, models.StrategyWeightedAverage, params))
+			for d := 0; d < 7; d++ {
+				usage = append(usage, usageRecord(childID, snapshotDate.AddDate(0, 0, -d), "requests", float64(c+d+1)))
+			}
+		}
+	}
+	dimensions := []string{"cost", "egress_gb", "storage_gb"}
+
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+	defer zerolog.SetGlobalLevel(level)
+
+	b.Run("store-per-edge", func(b *testing.B) {
+		s := newCountingStore(edges, usage)
+		for i := 0; i < b.N; i++ {
+			for _, edge := range edges {
+				for _, dim := range dimensions {
+					if _, err := storeBackedShare(s, edge, dim, snapshotDate); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		}
+		b.ReportMetric(float64(s.queries)/float64(b.N), "queries/op")
+	})
+
+	b.Run("snapshot", func(b *testing.B) {
+		queries := 0
+		for i := 0; i < b.N; i++ {
+			// Edges, strategy overrides and usage are each loaded in one query
+			queries += 3
+			snap := newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+			for _, edge := range edges {
+				for _, dim := range dimensions {
+					shares, err := snap.Shares(snap.ResolveStrategy(edge, dim), edge.ParentID, dim)
+					if err != nil {
+						b.Fatal(err)
+					}
+					_ = shares[edge.ChildID]
+				}
+			}
+		}
+		b.ReportMetric(float64(queries)/float64(b.N), "queries/op")
+	})
+}
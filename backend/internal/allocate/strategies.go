@@ -1,13 +1,12 @@
 package allocate
 
 import (
-	"context"
+	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/google/uuid"
 	"github.com/pickeringtech/FinOpsAggregator/internal/models"
-	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
 	"github.com/rs/zerolog/log"
 	"github.com/shopspring/decimal"
 )
@@ -18,42 +17,26 @@ type Strategy struct {
 	Parameters map[string]interface{}     `json:"parameters"`
 }
 
-// StrategyResolver resolves allocation strategies for edges and dimensions
-type StrategyResolver struct {
-	store *store.Store
-}
-
-// NewStrategyResolver creates a new strategy resolver
-func NewStrategyResolver(store *store.Store) *StrategyResolver {
-	return &StrategyResolver{
-		store: store,
-	}
-}
-
-// ResolveStrategy resolves the allocation strategy for an edge and dimension
-func (sr *StrategyResolver) ResolveStrategy(ctx context.Context, edge models.DependencyEdge, dimension string, date time.Time) (*Strategy, error) {
-	// First, check for dimension-specific strategy override
-	strategies, err := sr.store.Edges.GetStrategiesForEdge(ctx, edge.ID)
-	if err != nil {
-		log.Error().Err(err).Str("edge_id", edge.ID.String()).Msg("Failed to get edge strategies")
-	} else {
-		// Look for dimension-specific strategy
-		for _, strategy := range strategies {
-			if strategy.Dimension != nil && *strategy.Dimension == dimension {
-				return &Strategy{
-					Type:       models.AllocationStrategy(strategy.Strategy),
-					Parameters: strategy.Parameters,
-				}, nil
+// resolveStrategy picks the strategy for an edge and dimension from its overrides.
+// A dimension-specific override wins over a default override (dimension is null),
+// which in turn wins over the edge's default strategy.
+func resolveStrategy(edge models.DependencyEdge, dimension string, overrides []models.EdgeStrategy) *Strategy {
+	// Look for dimension-specific strategy
+	for _, strategy := range overrides {
+		if strategy.Dimension != nil && *strategy.Dimension == dimension {
+			return &Strategy{
+				Type:       models.AllocationStrategy(strategy.Strategy),
+				Parameters: strategy.Parameters,
 			}
 		}
-		
-		// Look for default strategy override (dimension is null)
-		for _, strategy := range strategies {
-			if strategy.Dimension == nil {
-				return &Strategy{
-					Type:       models.AllocationStrategy(strategy.Strategy),
-					Parameters: strategy.Parameters,
-				}, nil
+	}
+
+	// Look for default strategy override (dimension is null)
+	for _, strategy := range overrides {
+		if strategy.Dimension == nil {
+			return &Strategy{
+				Type:       models.AllocationStrategy(strategy.Strategy),
+				Parameters: strategy.Parameters,
 			}
 		}
 	}
@@ -62,35 +45,31 @@ func (sr *StrategyResolver) ResolveStrategy(ctx context.Context, edge models.Dep
 	return &Strategy{
 		Type:       models.AllocationStrategy(edge.DefaultStrategy),
 		Parameters: edge.DefaultParameters,
-	}, nil
+	}
 }
 
-// CalculateShare calculates the allocation share for a parent-child relationship
-func (s *Strategy) CalculateShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
-	var share decimal.Decimal
-	var err error
-
-	switch s.Type {
-	case models.StrategyEqual:
-		share, err = s.calculateEqualShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyProportionalOn:
-		share, err = s.calculateProportionalShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyFixedPercent:
-		share, err = s.calculateFixedPercentShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyCappedProp:
-		share, err = s.calculateCappedProportionalShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyResidualToMax:
-		share, err = s.calculateResidualToMaxShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyWeightedAverage:
-		share, err = s.calculateWeightedAverageShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyHybridFixedProp:
-		share, err = s.calculateHybridFixedProportionalShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategyMinFloorProportional:
-		share, err = s.calculateMinFloorProportionalShare(ctx, store, parentID, childID, dimension, date)
-	case models.StrategySegmentFilteredProp:
-		share, err = s.calculateSegmentFilteredProportionalShare(ctx, store, parentID, childID, dimension, date)
-	default:
-		return decimal.Zero, fmt.Errorf("unknown strategy type: %s", s.Type)
+// key returns a stable identifier for the strategy type and its parameters
+func (s *Strategy) key() string {
+	params, err := json.Marshal(s.Parameters)
+	if err != nil {
+		params = []byte(fmt.Sprintf("%v", s.Parameters))
+	}
+	return string(s.Type) + ":" + string(params)
+}
+
+// CalculateShares calculates the allocation share of every child of a parent in one pass.
+// The returned map is keyed by child ID; children absent from the map receive nothing.
+func (s *Strategy) CalculateShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+	def, ok := strategy.Lookup(s.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy type: %s", s.Type)
+	}
+
+	// Parameters are checked against the strategy's spec before it runs
+	err := def.Spec.ValidateParameters(s.Parameters)
+	var shares map[uuid.UUID]decimal.Decimal
+	if err == nil {
+		shares, err = def.Func(s.Parameters, snap, parentID, dimension)
 	}
 
 	if err != nil {
@@ -98,98 +77,78 @@ func (s *Strategy) CalculateShare(ctx context.Context, store *store.Store, paren
 			Err(err).
 			Str("strategy", string(s.Type)).
 			Str("parent_id", parentID.String()).
-			Str("child_id", childID.String()).
 			Str("dimension", dimension).
-			Time("date", date).
+			Time("date", snap.Date()).
 			Msg("Strategy calculation failed")
-		return decimal.Zero, err
+		return nil, err
 	}
 
 	log.Trace().
 		Str("strategy", string(s.Type)).
 		Str("parent_id", parentID.String()).
-		Str("child_id", childID.String()).
 		Str("dimension", dimension).
-		Str("share", share.StringFixed(6)).
-		Time("date", date).
-		Msg("Strategy share calculated")
+		Int("children", len(shares)).
+		Time("date", snap.Date()).
+		Msg("Strategy shares calculated")
 
-	return share, nil
+	return shares, nil
 }
 
-// calculateEqualShare calculates equal allocation among all children
-func (s *Strategy) calculateEqualShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// calculateEqualShares calculates equal allocation among all children
+func (s *Strategy) calculateEqualShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get all children of the parent for this date (for top-down allocation)
-	edges, err := store.Edges.GetByParentID(ctx, parentID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get child edges: %w", err)
-	}
-
-	if len(edges) == 0 {
-		return decimal.Zero, nil
-	}
+	edges := snap.ChildEdges(parentID)
 
 	// Equal share among all children
-	return decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(edges)))), nil
+	return equalShares(edges), nil
 }
 
-// calculateProportionalShare calculates proportional allocation based on usage metric
-func (s *Strategy) calculateProportionalShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// calculateProportionalShares calculates proportional allocation based on usage metric
+func (s *Strategy) calculateProportionalShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the metric to use for proportional allocation
 	metric, ok := s.Parameters["metric"].(string)
 	if !ok {
-		return decimal.Zero, fmt.Errorf("proportional_on strategy requires 'metric' parameter")
+		return nil, fmt.Errorf("proportional_on strategy requires 'metric' parameter")
 	}
 
-	// Get all children of the parent (for top-down allocation)
-	edges, err := store.Edges.GetByParentID(ctx, parentID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get child edges: %w", err)
-	}
+	return proportionalShares(snap, snap.ChildEdges(parentID), metric), nil
+}
 
+// proportionalShares splits by each child's usage of metric on the snapshot date,
+// falling back to equal shares when no child has usage
+func proportionalShares(snap *DaySnapshot, edges []strategy.Edge, metric string) map[uuid.UUID]decimal.Decimal {
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
 	if len(edges) == 0 {
-		return decimal.Zero, nil
+		return shares
 	}
 
 	// Get usage values for all children
 	var totalUsage decimal.Decimal
-	var childUsage decimal.Decimal
+	usageByChild := make(map[uuid.UUID]decimal.Decimal, len(edges))
 
 	for _, edge := range edges {
-		usage, err := store.Usage.GetByNodeAndDateRange(ctx, edge.ChildID, date, date, []string{metric})
-		if err != nil {
-			log.Error().Err(err).Str("node_id", edge.ChildID.String()).Str("metric", metric).Msg("Failed to get usage for proportional allocation")
-			continue
-		}
-
-		var nodeUsage decimal.Decimal
-		for _, u := range usage {
-			if u.Metric == metric {
-				nodeUsage = u.Value
-				break
-			}
-		}
-
+		nodeUsage := snap.UsageOn(edge.ChildID, metric)
+		usageByChild[edge.ChildID] = nodeUsage
 		totalUsage = totalUsage.Add(nodeUsage)
-		if edge.ChildID == childID {
-			childUsage = nodeUsage
-		}
 	}
 
 	if totalUsage.IsZero() {
 		// Fall back to equal allocation if no usage data
-		return decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(edges)))), nil
+		return zeroUsageShares(snap, edges)
 	}
 
-	return childUsage.Div(totalUsage), nil
+	for childID, usage := range usageByChild {
+		shares[childID] = usage.Div(totalUsage)
+	}
+	return shares
 }
 
-// calculateFixedPercentShare calculates fixed percentage allocation
-func (s *Strategy) calculateFixedPercentShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// calculateFixedPercentShares calculates fixed percentage allocation
+func (s *Strategy) calculateFixedPercentShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the fixed percentage
 	percentInterface, ok := s.Parameters["percent"]
 	if !ok {
-		return decimal.Zero, fmt.Errorf("fixed_percent strategy requires 'percent' parameter")
+		return nil, fmt.Errorf("fixed_percent strategy requires 'percent' parameter")
 	}
 
 	var percent decimal.Decimal
@@ -200,10 +159,10 @@ func (s *Strategy) calculateFixedPercentShare(ctx context.Context, store *store.
 		var err error
 		percent, err = decimal.NewFromString(v)
 		if err != nil {
-			return decimal.Zero, fmt.Errorf("invalid percent value: %v", v)
+			return nil, fmt.Errorf("invalid percent value: %v", v)
 		}
 	default:
-		return decimal.Zero, fmt.Errorf("percent parameter must be float64 or string, got %T", v)
+		return nil, fmt.Errorf("percent parameter must be float64 or string, got %T", v)
 	}
 
 	// Convert percentage to decimal (e.g., 25% -> 0.25)
@@ -211,21 +170,26 @@ func (s *Strategy) calculateFixedPercentShare(ctx context.Context, store *store.
 		percent = percent.Div(decimal.NewFromInt(100))
 	}
 
-	return percent, nil
+	edges := snap.ChildEdges(parentID)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	for _, edge := range edges {
+		shares[edge.ChildID] = percent
+	}
+	return shares, nil
 }
 
-// calculateCappedProportionalShare calculates proportional allocation with a cap
-func (s *Strategy) calculateCappedProportionalShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
-	// First calculate proportional share
-	proportionalShare, err := s.calculateProportionalShare(ctx, store, parentID, childID, dimension, date)
+// calculateCappedProportionalShares calculates proportional allocation with a cap
+func (s *Strategy) calculateCappedProportionalShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+	// First calculate proportional shares
+	shares, err := s.calculateProportionalShares(snap, parentID, dimension)
 	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to calculate proportional share: %w", err)
+		return nil, fmt.Errorf("failed to calculate proportional share: %w", err)
 	}
 
 	// Get the cap
 	capInterface, ok := s.Parameters["cap"]
 	if !ok {
-		return proportionalShare, nil // No cap, return proportional share
+		return shares, nil // No cap, return proportional shares
 	}
 
 	var cap decimal.Decimal
@@ -236,10 +200,10 @@ func (s *Strategy) calculateCappedProportionalShare(ctx context.Context, store *
 		var err error
 		cap, err = decimal.NewFromString(v)
 		if err != nil {
-			return decimal.Zero, fmt.Errorf("invalid cap value: %v", v)
+			return nil, fmt.Errorf("invalid cap value: %v", v)
 		}
 	default:
-		return decimal.Zero, fmt.Errorf("cap parameter must be float64 or string, got %T", v)
+		return nil, fmt.Errorf("cap parameter must be float64 or string, got %T", v)
 	}
 
 	// Convert percentage to decimal if needed
@@ -247,135 +211,143 @@ func (s *Strategy) calculateCappedProportionalShare(ctx context.Context, store *
 		cap = cap.Div(decimal.NewFromInt(100))
 	}
 
-	// Return the minimum of proportional share and cap
-	if proportionalShare.LessThan(cap) {
-		return proportionalShare, nil
+	return capShares(shares, cap), nil
+}
+
+// capShares limits every share to the cap and redistributes the excess over the
+// cap proportionally among the children still under it, repeating until no new
+// child reaches the cap. Whatever cannot be placed under the cap is left as a
+// remainder for the normalisation stage.
+func capShares(weights map[uuid.UUID]decimal.Decimal, cap decimal.Decimal) map[uuid.UUID]decimal.Decimal {
+	capped := make(map[uuid.UUID]bool, len(weights))
+	shares := make(map[uuid.UUID]decimal.Decimal, len(weights))
+
+	for {
+		free := decimal.NewFromInt(1).Sub(cap.Mul(decimal.NewFromInt(int64(len(capped)))))
+		uncappedWeight := decimal.Zero
+		for childID, weight := range weights {
+			if !capped[childID] {
+				uncappedWeight = uncappedWeight.Add(weight)
+			}
+		}
+
+		newlyCapped := false
+		for childID, weight := range weights {
+			if capped[childID] {
+				shares[childID] = cap
+				continue
+			}
+
+			share := decimal.Zero
+			if !uncappedWeight.IsZero() {
+				share = free.Mul(weight).Div(uncappedWeight)
+			}
+			if !share.LessThan(cap) {
+				capped[childID] = true
+				newlyCapped = true
+			}
+			shares[childID] = share
+		}
+
+		if !newlyCapped {
+			return shares
+		}
 	}
-	return cap, nil
 }
 
-// calculateResidualToMaxShare calculates allocation for the node with maximum usage
-func (s *Strategy) calculateResidualToMaxShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// calculateResidualToMaxShares calculates allocation where the parent with maximum usage
+// absorbs whatever the child's other parents do not cover
+func (s *Strategy) calculateResidualToMaxShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the metric to use for finding max usage
 	metric, ok := s.Parameters["metric"].(string)
 	if !ok {
-		return decimal.Zero, fmt.Errorf("residual_to_max strategy requires 'metric' parameter")
+		return nil, fmt.Errorf("residual_to_max strategy requires 'metric' parameter")
 	}
 
-	// Get all parents of the child
-	edges, err := store.Edges.GetByChildID(ctx, childID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get parent edges: %w", err)
-	}
+	childEdges := snap.ChildEdges(parentID)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(childEdges))
 
-	if len(edges) == 0 {
-		return decimal.Zero, nil
-	}
+	for _, childEdge := range childEdges {
+		childID := childEdge.ChildID
 
-	// Find the parent with maximum usage
-	var maxUsage decimal.Decimal
-	var maxUsageParentID uuid.UUID
-	
-	for _, edge := range edges {
-		usage, err := store.Usage.GetByNodeAndDateRange(ctx, edge.ParentID, date, date, []string{metric})
-		if err != nil {
-			log.Error().Err(err).Str("node_id", edge.ParentID.String()).Str("metric", metric).Msg("Failed to get usage for residual_to_max allocation")
+		// Get all parents of the child
+		edges := snap.ParentEdges(childID)
+		if len(edges) == 0 {
+			shares[childID] = decimal.Zero
 			continue
 		}
-		
-		var nodeUsage decimal.Decimal
-		for _, u := range usage {
-			if u.Metric == metric {
-				nodeUsage = u.Value
-				break
+
+		// Find the parent with maximum usage
+		var maxUsage decimal.Decimal
+		var maxUsageParentID uuid.UUID
+
+		for _, edge := range edges {
+			nodeUsage := snap.UsageOn(edge.ParentID, metric)
+			if nodeUsage.GreaterThan(maxUsage) {
+				maxUsage = nodeUsage
+				maxUsageParentID = edge.ParentID
 			}
 		}
-		
-		if nodeUsage.GreaterThan(maxUsage) {
-			maxUsage = nodeUsage
-			maxUsageParentID = edge.ParentID
-		}
-	}
 
-	// Calculate shares for other parents first (using proportional)
-	if parentID != maxUsageParentID {
 		// Use proportional allocation for non-max parents
-		return s.calculateProportionalShare(ctx, store, parentID, childID, dimension, date)
-	}
+		if parentID != maxUsageParentID {
+			shares[childID] = proportionalShares(snap, childEdges, metric)[childID]
+			continue
+		}
 
-	// For the max usage parent, calculate residual
-	var totalOtherShares decimal.Decimal
-	for _, edge := range edges {
-		if edge.ParentID != maxUsageParentID {
-			share, err := s.calculateProportionalShare(ctx, store, edge.ParentID, childID, dimension, date)
-			if err != nil {
-				log.Error().Err(err).Str("parent_id", edge.ParentID.String()).Msg("Failed to calculate proportional share for residual calculation")
-				continue
+		// For the max usage parent, calculate residual after the other parents' shares
+		var totalOtherShares decimal.Decimal
+		for _, edge := range edges {
+			if edge.ParentID != maxUsageParentID {
+				share := proportionalShares(snap, snap.ChildEdges(edge.ParentID), metric)[childID]
+				totalOtherShares = totalOtherShares.Add(share)
 			}
-			totalOtherShares = totalOtherShares.Add(share)
 		}
-	}
 
-	// Residual share is what's left after other allocations
-	residualShare := decimal.NewFromInt(1).Sub(totalOtherShares)
-	if residualShare.LessThan(decimal.Zero) {
-		residualShare = decimal.Zero
+		// Residual share is what's left after other allocations
+		residualShare := decimal.NewFromInt(1).Sub(totalOtherShares)
+		if residualShare.LessThan(decimal.Zero) {
+			residualShare = decimal.Zero
+		}
+		shares[childID] = residualShare
 	}
 
-	return residualShare, nil
+	return shares, nil
 }
 
-// calculateWeightedAverageShare calculates allocation based on weighted average usage over a look-back window
-func (s *Strategy) calculateWeightedAverageShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// calculateWeightedAverageShares calculates allocation based on weighted average usage over a look-back window
+func (s *Strategy) calculateWeightedAverageShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the metric to use for proportional allocation
 	metric, ok := s.Parameters["metric"].(string)
 	if !ok {
-		return decimal.Zero, fmt.Errorf("weighted_average strategy requires 'metric' parameter")
+		return nil, fmt.Errorf("weighted_average strategy requires 'metric' parameter")
 	}
 
 	// Get the look-back window (default 7 days)
-	windowDays := 7
-	if windowInterface, ok := s.Parameters["window_days"]; ok {
-		switch v := windowInterface.(type) {
-		case float64:
-			windowDays = int(v)
-		case int:
-			windowDays = v
-		}
-	}
+	windowDays := s.windowDays()
 
 	// Get all children of the parent
-	edges, err := store.Edges.GetByParentID(ctx, parentID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get child edges: %w", err)
-	}
-
+	edges := snap.ChildEdges(parentID)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
 	if len(edges) == 0 {
-		return decimal.Zero, nil
+		return shares, nil
 	}
 
 	// Calculate start date for look-back window
+	date := snap.Date()
 	startDate := date.AddDate(0, 0, -(windowDays - 1))
 
 	// Get average usage values for all children over the window
 	var totalAvgUsage decimal.Decimal
-	var childAvgUsage decimal.Decimal
+	avgByChild := make(map[uuid.UUID]decimal.Decimal, len(edges))
 
 	for _, edge := range edges {
-		usage, err := store.Usage.GetByNodeAndDateRange(ctx, edge.ChildID, startDate, date, []string{metric})
-		if err != nil {
-			log.Error().Err(err).Str("node_id", edge.ChildID.String()).Str("metric", metric).Msg("Failed to get usage for weighted_average allocation")
-			continue
-		}
-
 		// Calculate average usage over the window
 		var sumUsage decimal.Decimal
 		var count int
-		for _, u := range usage {
-			if u.Metric == metric {
-				sumUsage = sumUsage.Add(u.Value)
-				count++
-			}
+		for _, u := range snap.UsageBetween(edge.ChildID, metric, startDate, date) {
+			sumUsage = sumUsage.Add(u.Value)
+			count++
 		}
 
 		var avgUsage decimal.Decimal
@@ -383,26 +355,41 @@ func (s *Strategy) calculateWeightedAverageShare(ctx context.Context, store *sto
 			avgUsage = sumUsage.Div(decimal.NewFromInt(int64(count)))
 		}
 
+		avgByChild[edge.ChildID] = avgUsage
 		totalAvgUsage = totalAvgUsage.Add(avgUsage)
-		if edge.ChildID == childID {
-			childAvgUsage = avgUsage
-		}
 	}
 
 	if totalAvgUsage.IsZero() {
 		// Fall back to equal allocation if no usage data
-		return decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(edges)))), nil
+		return zeroUsageShares(snap, edges), nil
 	}
 
-	return childAvgUsage.Div(totalAvgUsage), nil
+	for childID, avg := range avgByChild {
+		shares[childID] = avg.Div(totalAvgUsage)
+	}
+	return shares, nil
 }
 
-// calculateHybridFixedProportionalShare calculates allocation with a fixed baseline plus proportional variable
-func (s *Strategy) calculateHybridFixedProportionalShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// windowDays returns the weighted_average look-back window in days (default 7)
+func (s *Strategy) windowDays() int {
+	windowDays := 7
+	if windowInterface, ok := s.Parameters["window_days"]; ok {
+		switch v := windowInterface.(type) {
+		case float64:
+			windowDays = int(v)
+		case int:
+			windowDays = v
+		}
+	}
+	return windowDays
+}
+
+// calculateHybridFixedProportionalShares calculates allocation with a fixed baseline plus proportional variable
+func (s *Strategy) calculateHybridFixedProportionalShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the fixed percentage (portion allocated equally)
 	fixedPercentInterface, ok := s.Parameters["fixed_percent"]
 	if !ok {
-		return decimal.Zero, fmt.Errorf("hybrid_fixed_proportional strategy requires 'fixed_percent' parameter")
+		return nil, fmt.Errorf("hybrid_fixed_proportional strategy requires 'fixed_percent' parameter")
 	}
 
 	var fixedPercent decimal.Decimal
@@ -413,10 +400,10 @@ func (s *Strategy) calculateHybridFixedProportionalShare(ctx context.Context, st
 		var err error
 		fixedPercent, err = decimal.NewFromString(v)
 		if err != nil {
-			return decimal.Zero, fmt.Errorf("invalid fixed_percent value: %v", v)
+			return nil, fmt.Errorf("invalid fixed_percent value: %v", v)
 		}
 	default:
-		return decimal.Zero, fmt.Errorf("fixed_percent parameter must be float64 or string, got %T", v)
+		return nil, fmt.Errorf("fixed_percent parameter must be float64 or string, got %T", v)
 	}
 
 	// Convert percentage to decimal if needed (e.g., 40 -> 0.40)
@@ -425,13 +412,10 @@ func (s *Strategy) calculateHybridFixedProportionalShare(ctx context.Context, st
 	}
 
 	// Get all children of the parent
-	edges, err := store.Edges.GetByParentID(ctx, parentID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get child edges: %w", err)
-	}
-
+	edges := snap.ChildEdges(parentID)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
 	if len(edges) == 0 {
-		return decimal.Zero, nil
+		return shares, nil
 	}
 
 	numChildren := decimal.NewFromInt(int64(len(edges)))
@@ -442,24 +426,25 @@ func (s *Strategy) calculateHybridFixedProportionalShare(ctx context.Context, st
 	// Variable portion: split proportionally
 	variablePercent := decimal.NewFromInt(1).Sub(fixedPercent)
 
-	// Calculate proportional share for the variable portion
-	proportionalShare, err := s.calculateProportionalShare(ctx, store, parentID, childID, dimension, date)
+	// Calculate proportional shares for the variable portion
+	proportional, err := s.calculateProportionalShares(snap, parentID, dimension)
 	if err != nil {
 		// Fall back to equal for variable portion if proportional fails
-		proportionalShare = decimal.NewFromInt(1).Div(numChildren)
+		proportional = equalShares(edges)
 	}
 
-	variableShare := variablePercent.Mul(proportionalShare)
-
-	return fixedShare.Add(variableShare), nil
+	for _, edge := range edges {
+		shares[edge.ChildID] = fixedShare.Add(variablePercent.Mul(proportional[edge.ChildID]))
+	}
+	return shares, nil
 }
 
-// calculateMinFloorProportionalShare calculates allocation with a minimum floor per child
-func (s *Strategy) calculateMinFloorProportionalShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+// calculateMinFloorProportionalShares calculates allocation with a minimum floor per child
+func (s *Strategy) calculateMinFloorProportionalShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the minimum floor percentage per child
 	minFloorInterface, ok := s.Parameters["min_floor_percent"]
 	if !ok {
-		return decimal.Zero, fmt.Errorf("min_floor_proportional strategy requires 'min_floor_percent' parameter")
+		return nil, fmt.Errorf("min_floor_proportional strategy requires 'min_floor_percent' parameter")
 	}
 
 	var minFloorPercent decimal.Decimal
@@ -470,10 +455,10 @@ func (s *Strategy) calculateMinFloorProportionalShare(ctx context.Context, store
 		var err error
 		minFloorPercent, err = decimal.NewFromString(v)
 		if err != nil {
-			return decimal.Zero, fmt.Errorf("invalid min_floor_percent value: %v", v)
+			return nil, fmt.Errorf("invalid min_floor_percent value: %v", v)
 		}
 	default:
-		return decimal.Zero, fmt.Errorf("min_floor_percent parameter must be float64 or string, got %T", v)
+		return nil, fmt.Errorf("min_floor_percent parameter must be float64 or string, got %T", v)
 	}
 
 	// Convert percentage to decimal if needed (e.g., 10 -> 0.10)
@@ -482,13 +467,9 @@ func (s *Strategy) calculateMinFloorProportionalShare(ctx context.Context, store
 	}
 
 	// Get all children of the parent
-	edges, err := store.Edges.GetByParentID(ctx, parentID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get child edges: %w", err)
-	}
-
+	edges := snap.ChildEdges(parentID)
 	if len(edges) == 0 {
-		return decimal.Zero, nil
+		return map[uuid.UUID]decimal.Decimal{}, nil
 	}
 
 	numChildren := decimal.NewFromInt(int64(len(edges)))
@@ -497,24 +478,28 @@ func (s *Strategy) calculateMinFloorProportionalShare(ctx context.Context, store
 	totalFloor := minFloorPercent.Mul(numChildren)
 	if totalFloor.GreaterThanOrEqual(decimal.NewFromInt(1)) {
 		// Fall back to equal allocation
-		return decimal.NewFromInt(1).Div(numChildren), nil
+		return equalShares(edges), nil
 	}
 
 	// Calculate remainder after floor allocations
 	remainder := decimal.NewFromInt(1).Sub(totalFloor)
 
-	// Calculate proportional share for the remainder
-	proportionalShare, err := s.calculateProportionalShare(ctx, store, parentID, childID, dimension, date)
+	// Calculate proportional shares for the remainder
+	proportional, err := s.calculateProportionalShares(snap, parentID, dimension)
 	if err != nil {
 		// Fall back to equal for remainder if proportional fails
-		proportionalShare = decimal.NewFromInt(1).Div(numChildren)
+		proportional = equalShares(edges)
 	}
 
 	// Total share = floor + (remainder * proportional share)
-	return minFloorPercent.Add(remainder.Mul(proportionalShare)), nil
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	for _, edge := range edges {
+		shares[edge.ChildID] = minFloorPercent.Add(remainder.Mul(proportional[edge.ChildID]))
+	}
+	return shares, nil
 }
 
-// calculateSegmentFilteredProportionalShare calculates proportional allocation based on usage metrics
+// calculateSegmentFilteredProportionalShares calculates proportional allocation based on usage metrics
 // filtered by specific label values (e.g., customer_id, environment, plan_tier).
 // This enables segment-based cost allocation using Dynatrace or other labelled metrics.
 //
@@ -523,66 +508,41 @@ func (s *Strategy) calculateMinFloorProportionalShare(ctx context.Context, store
 //   - segment_filter.label: The label key to filter on (e.g., "customer_id")
 //   - segment_filter.values: Array of label values to include (OR semantics)
 //   - segment_filter.operator: Filter operator ("eq", "in", "exists", etc.)
-func (s *Strategy) calculateSegmentFilteredProportionalShare(ctx context.Context, store *store.Store, parentID, childID uuid.UUID, dimension string, date time.Time) (decimal.Decimal, error) {
+func (s *Strategy) calculateSegmentFilteredProportionalShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
 	// Get the metric to use for proportional allocation
 	metric, ok := s.Parameters["metric"].(string)
 	if !ok {
-		return decimal.Zero, fmt.Errorf("segment_filtered_proportional strategy requires 'metric' parameter")
+		return nil, fmt.Errorf("segment_filtered_proportional strategy requires 'metric' parameter")
 	}
 
 	// Parse segment filter from parameters
 	segmentFilter, err := s.parseSegmentFilter()
 	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to parse segment filter: %w", err)
+		return nil, fmt.Errorf("failed to parse segment filter: %w", err)
 	}
 
 	// Get all children of the parent (for top-down allocation)
-	edges, err := store.Edges.GetByParentID(ctx, parentID, &date)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get child edges: %w", err)
-	}
-
+	edges := snap.ChildEdges(parentID)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
 	if len(edges) == 0 {
-		return decimal.Zero, nil
-	}
-
-	// Build usage query options with label filters
-	opts := models.UsageQueryOptions{
-		Metrics:   []string{metric},
-		StartDate: date,
-		EndDate:   date,
-	}
-
-	// Add label filter if specified
-	if segmentFilter != nil {
-		opts.LabelFilters = []models.UsageLabelFilter{*segmentFilter}
+		return shares, nil
 	}
 
 	// Get usage values for all children with label filtering
 	var totalUsage decimal.Decimal
-	var childUsage decimal.Decimal
+	usageByChild := make(map[uuid.UUID]decimal.Decimal, len(edges))
 
 	for _, edge := range edges {
-		opts.NodeIDs = []uuid.UUID{edge.ChildID}
-
-		usage, err := store.Usage.QueryWithOptions(ctx, opts)
-		if err != nil {
-			log.Error().Err(err).Str("node_id", edge.ChildID.String()).Str("metric", metric).Msg("Failed to get filtered usage for segment allocation")
-			continue
-		}
-
 		// Sum all matching usage values (may have multiple records with different label values)
 		var nodeUsage decimal.Decimal
-		for _, u := range usage {
-			if u.Metric == metric {
+		for _, u := range snap.LabelledUsageOn(edge.ChildID, metric) {
+			if segmentFilter == nil || matchesLabelFilter(u.Labels, *segmentFilter) {
 				nodeUsage = nodeUsage.Add(u.Value)
 			}
 		}
 
+		usageByChild[edge.ChildID] = nodeUsage
 		totalUsage = totalUsage.Add(nodeUsage)
-		if edge.ChildID == childID {
-			childUsage = nodeUsage
-		}
 	}
 
 	if totalUsage.IsZero() {
@@ -590,11 +550,15 @@ func (s *Strategy) calculateSegmentFilteredProportionalShare(ctx context.Context
 		log.Debug().
 			Str("parent_id", parentID.String()).
 			Str("metric", metric).
-			Msg("No filtered usage data found, falling back to equal allocation")
-		return decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(edges)))), nil
+			Bool("equal_fallback", snap.zeroUsageFallback).
+			Msg("No filtered usage data found")
+		return zeroUsageShares(snap, edges), nil
 	}
 
-	return childUsage.Div(totalUsage), nil
+	for childID, usage := range usageByChild {
+		shares[childID] = usage.Div(totalUsage)
+	}
+	return shares, nil
 }
 
 // parseSegmentFilter parses the segment_filter parameter from strategy parameters
@@ -642,3 +606,69 @@ func (s *Strategy) parseSegmentFilter() (*models.UsageLabelFilter, error) {
 
 	return filter, nil
 }
+
+// matchesLabelFilter evaluates a label filter in memory with the same semantics
+// as the JSONB filters applied by UsageRepository.QueryWithOptions
+func matchesLabelFilter(labels map[string]string, filter models.UsageLabelFilter) bool {
+	value, exists := labels[filter.Key]
+
+	switch filter.Operator {
+	case "eq":
+		if len(filter.Values) > 0 {
+			return exists && value == filter.Values[0]
+		}
+	case "neq":
+		if len(filter.Values) > 0 {
+			return !exists || value != filter.Values[0]
+		}
+	case "in":
+		if len(filter.Values) > 0 {
+			return exists && containsString(filter.Values, value)
+		}
+	case "not_in":
+		if len(filter.Values) > 0 {
+			return !exists || !containsString(filter.Values, value)
+		}
+	case "exists":
+		return exists
+	case "not_exists":
+		return !exists
+	}
+
+	return true
+}
+
+// equalShares splits evenly across the given child edges
+func equalShares(edges []strategy.Edge) map[uuid.UUID]decimal.Decimal {
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	if len(edges) == 0 {
+		return shares
+	}
+
+	share := decimal.NewFromInt(1).Div(decimal.NewFromInt(int64(len(edges))))
+	for _, edge := range edges {
+		shares[edge.ChildID] = share
+	}
+	return shares
+}
+
+// zeroUsageShares is what a usage-based strategy returns when no child has usage:
+// equal shares, or no shares at all when the fallback is disabled, leaving the
+// parent's cost to be routed to the unallocated node
+func zeroUsageShares(snap *DaySnapshot, edges []strategy.Edge) map[uuid.UUID]decimal.Decimal {
+	if snap.zeroUsageFallback || len(edges) == 0 {
+		return equalShares(edges)
+	}
+
+	snap.noUsage[edges[0].ParentID] = true
+	return make(map[uuid.UUID]decimal.Decimal)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
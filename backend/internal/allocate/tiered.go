@@ -0,0 +1,124 @@
+package allocate
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
+	"github.com/shopspring/decimal"
+)
+
+// calculateTieredRateShares charges each child for its usage of a metric against a
+// tier table and allocates the parent's cost in proportion to the charges. The
+// difference between the charges and the parent's cost goes to a sink:
+//   - under-recovery, cost the charges do not cover, is allocated to the sink node,
+//     or left as a remainder for the run's unallocated node when there is none
+//   - over-recovery cannot be allocated without creating cost, so the charges are
+//     scaled back to the parent's cost and the surplus is booked against the sink
+//
+// Parameters:
+//   - metric: the usage metric the tiers price (required)
+//   - tiers: ascending tiers of {"up_to": usage, "rate": price per unit}, the last unbounded (required)
+//   - sink_node: the child that absorbs under-recovery; it is not charged itself.
+//     Its edge must use the same strategy, as each child's share comes from its own edge.
+func (s *Strategy) calculateTieredRateShares(snap *DaySnapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error) {
+	metric, ok := s.Parameters["metric"].(string)
+	if !ok {
+		return nil, fmt.Errorf("tiered_rate strategy requires 'metric' parameter")
+	}
+	tiers, err := models.ParseRateTiers(s.Parameters)
+	if err != nil {
+		return nil, fmt.Errorf("tiered_rate strategy: %w", err)
+	}
+
+	edges := snap.ChildEdges(parentID)
+	shares := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	if len(edges) == 0 {
+		return shares, nil
+	}
+
+	var sink *uuid.UUID
+	if raw, ok := s.Parameters["sink_node"].(string); ok {
+		id, err := uuid.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sink_node %q: %w", raw, err)
+		}
+		if !hasChild(edges, id) {
+			return nil, fmt.Errorf("sink node %s is not a child of parent %s", id, parentID)
+		}
+		sink = &id
+	}
+
+	// Price every child's usage, except the sink's
+	charged := decimal.Zero
+	charges := make(map[uuid.UUID]decimal.Decimal, len(edges))
+	for _, edge := range edges {
+		if sink != nil && edge.ChildID == *sink {
+			continue
+		}
+		charge := priceTiers(tiers, snap.UsageOn(edge.ChildID, metric))
+		charges[edge.ChildID] = charge
+		charged = charged.Add(charge)
+	}
+
+	cost := snap.ParentCost(parentID, dimension)
+	if cost.IsPositive() {
+		snap.recordRecovery(parentID, dimension, recovery{strategy: s.Type, sink: sink, cost: cost, charged: charged})
+	}
+
+	switch {
+	case !cost.IsPositive() || charged.GreaterThan(cost):
+		// Over-recovered, or no cost to recover: the children split the cost in
+		// proportion to their charges
+		if charged.IsZero() {
+			return shares, nil
+		}
+		for childID, charge := range charges {
+			shares[childID] = charge.Div(charged)
+		}
+	default:
+		for childID, charge := range charges {
+			shares[childID] = charge.Div(cost)
+		}
+		if sink != nil {
+			shares[*sink] = cost.Sub(charged).Div(cost)
+		}
+	}
+
+	return shares, nil
+}
+
+// priceTiers charges usage against a tier table, each unit at the rate of the tier
+// it falls in
+func priceTiers(tiers []models.RateTier, usage decimal.Decimal) decimal.Decimal {
+	charge := decimal.Zero
+	lower := decimal.Zero
+	for _, tier := range tiers {
+		if !usage.GreaterThan(lower) {
+			break
+		}
+
+		upper := usage
+		if tier.UpTo != nil && tier.UpTo.LessThan(usage) {
+			upper = *tier.UpTo
+		}
+		charge = charge.Add(upper.Sub(lower).Mul(tier.Rate))
+
+		if tier.UpTo == nil {
+			break
+		}
+		lower = *tier.UpTo
+	}
+	return charge
+}
+
+// hasChild reports whether any of the edges leads to the child
+func hasChild(edges []strategy.Edge, childID uuid.UUID) bool {
+	for _, edge := range edges {
+		if edge.ChildID == childID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package allocate
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kafkaTiers is the Kafka rate card: the first 1 TB free, the next 10 TB at $50/TB
+// and beyond that $20/TB
+var kafkaTiers = []interface{}{
+	map[string]interface{}{"up_to": 1.0, "rate": 0.0},
+	map[string]interface{}{"up_to": 11.0, "rate": 50.0},
+	map[string]interface{}{"rate": 20.0},
+}
+
+func TestPriceTiers(t *testing.T) {
+	tiers, err := models.ParseRateTiers(map[string]interface{}{"tiers": kafkaTiers})
+	require.NoError(t, err)
+
+	tests := []struct {
+		usage string
+		want  string
+	}{
+		{"0", "0"},
+		{"0.5", "0"},    // Inside the free tier
+		{"1", "0"},      // At the free tier's bound
+		{"5", "200"},    // 4 TB at $50
+		{"11", "500"},   // The whole $50 tier
+		{"20", "680"},   // 10 TB at $50 and 9 TB at $20
+		{"11.5", "510"}, // Half a TB into the last tier
+	}
+	for _, tt := range tests {
+		got := priceTiers(tiers, decimal.RequireFromString(tt.usage))
+		assert.True(t, got.Equal(decimal.RequireFromString(tt.want)), "%s TB priced at %s, want %s", tt.usage, got, tt.want)
+	}
+}
+
+// TestTieredRateAllocation tests the Kafka chargeback through a traversal
+//
+//	kafka → [batch (0.5 TB), search (5 TB), analytics (20 TB), platform (sink)]
+//	charges: batch $0, search $200, analytics $680 = $880
+func TestTieredRateAllocation(t *testing.T) {
+	kafka := models.CostNode{ID: uuid.New(), Name: "kafka", Type: string(models.NodeTypeShared)}
+	batch := models.CostNode{ID: uuid.New(), Name: "batch", Type: string(models.NodeTypeProduct)}
+	search := models.CostNode{ID: uuid.New(), Name: "search", Type: string(models.NodeTypeProduct)}
+	analytics := models.CostNode{ID: uuid.New(), Name: "analytics", Type: string(models.NodeTypeProduct)}
+	platform := models.CostNode{ID: uuid.New(), Name: "platform", Type: string(models.NodeTypeProduct)}
+	unallocatedNode := uuid.New()
+
+	usage := []models.NodeUsageByDimension{
+		usageRecord(batch.ID, snapshotDate, "tb_ingested", 0.5),
+		usageRecord(search.ID, snapshotDate, "tb_ingested", 5),
+		usageRecord(analytics.ID, snapshotDate, "tb_ingested", 20),
+	}
+
+	type outcome struct {
+		received    map[uuid.UUID]decimal.Decimal
+		unallocated []models.UnallocatedResultByDimension
+		recoveries  []models.RecoveryResultByDimension
+	}
+
+	run := func(t *testing.T, cost decimal.Decimal, withSink bool) outcome {
+		t.Helper()
+		params := map[string]interface{}{"metric": "tb_ingested", "tiers": kafkaTiers}
+		nodes := []models.CostNode{kafka, batch, search, analytics}
+		children := []uuid.UUID{batch.ID, search.ID, analytics.ID}
+		if withSink {
+			params["sink_node"] = platform.ID.String()
+			nodes = append(nodes, platform)
+			children = append(children, platform.ID)
+		}
+
+		var edges []models.DependencyEdge
+		for _, child := range children {
+			edges = append(edges, strategyEdge(kafka.ID, child, models.StrategyTieredRate, params))
+		}
+
+		g := graph.NewGraph(snapshotDate, nodes, edges)
+		order, err := g.TopologicalSort()
+		require.NoError(t, err)
+
+		dimensions := []string{"cost"}
+		costsByNode := map[uuid.UUID]map[string]decimal.Decimal{kafka.ID: {"cost": cost}}
+
+		e := &Engine{remainderPolicy: RemainderToUnallocated, zeroUsageFallback: true}
+		snap := newDaySnapshot(snapshotDate, edges, nil, usage, nil)
+		indirectCosts := e.initializeIndirectCosts(g, dimensions)
+		_, contributions, _, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+		require.NoError(t, err)
+
+		result := outcome{received: make(map[uuid.UUID]decimal.Decimal)}
+		for _, contribution := range contributions {
+			result.received[contribution.ChildID] = result.received[contribution.ChildID].Add(contribution.ContributedAmount)
+		}
+		result.unallocated = e.collectUnallocated(uuid.New(), snapshotDate, g, dimensions, snap, contributions, costsByNode, indirectCosts)
+		result.recoveries = snap.recoveryResults(uuid.New(), &unallocatedNode)
+		return result
+	}
+
+	assertAmount := func(t *testing.T, want string, got decimal.Decimal, msg string) {
+		t.Helper()
+		assert.Equal(t, want, got.StringFixed(2), msg)
+	}
+
+	t.Run("under-recovery goes to the unallocated node", func(t *testing.T) {
+		result := run(t, decimal.NewFromInt(1000), false)
+
+		assertAmount(t, "0.00", result.received[batch.ID], "batch stays inside the free tier")
+		assertAmount(t, "200.00", result.received[search.ID], "search pays its tier charges")
+		assertAmount(t, "680.00", result.received[analytics.ID], "analytics pays its tier charges")
+
+		require.Len(t, result.unallocated, 1)
+		assert.Equal(t, models.UnallocatedReasonUnderRecovery, result.unallocated[0].Reason)
+		assertAmount(t, "120.00", result.unallocated[0].Amount, "the unrecovered cost is unallocated")
+
+		require.Len(t, result.recoveries, 1)
+		recovery := result.recoveries[0]
+		assert.Equal(t, kafka.ID, recovery.ParentID)
+		assert.Equal(t, unallocatedNode, *recovery.SinkNodeID)
+		assertAmount(t, "880.00", recovery.ChargedAmount, "charged")
+		assertAmount(t, "120.00", recovery.UnderRecovery, "under-recovered")
+		assert.True(t, recovery.OverRecovery.IsZero())
+	})
+
+	t.Run("under-recovery goes to the sink node", func(t *testing.T) {
+		result := run(t, decimal.NewFromInt(1000), true)
+
+		assertAmount(t, "200.00", result.received[search.ID], "search pays its tier charges")
+		assertAmount(t, "680.00", result.received[analytics.ID], "analytics pays its tier charges")
+		assertAmount(t, "120.00", result.received[platform.ID], "the sink absorbs the unrecovered cost")
+		assert.Empty(t, result.unallocated)
+
+		require.Len(t, result.recoveries, 1)
+		assert.Equal(t, platform.ID, *result.recoveries[0].SinkNodeID)
+		assertAmount(t, "120.00", result.recoveries[0].UnderRecovery, "under-recovered")
+	})
+
+	t.Run("over-recovery is scaled back and booked against the sink", func(t *testing.T) {
+		result := run(t, decimal.NewFromInt(800), true)
+
+		assertAmount(t, "181.82", result.received[search.ID], "search's charge is scaled back")
+		assertAmount(t, "618.18", result.received[analytics.ID], "analytics' charge is scaled back")
+		assert.True(t, result.received[platform.ID].IsZero(), "the sink receives no cost")
+		total := result.received[search.ID].Add(result.received[analytics.ID])
+		assert.True(t, total.Sub(decimal.NewFromInt(800)).Abs().LessThan(decimal.New(1, -9)), "no cost is created, got %s", total)
+		assert.Empty(t, result.unallocated)
+
+		require.Len(t, result.recoveries, 1)
+		assert.Equal(t, platform.ID, *result.recoveries[0].SinkNodeID)
+		assertAmount(t, "80.00", result.recoveries[0].OverRecovery, "over-recovered")
+		assert.True(t, result.recoveries[0].UnderRecovery.IsZero())
+	})
+
+	t.Run("a sink node must be a child", func(t *testing.T) {
+		params := map[string]interface{}{"metric": "tb_ingested", "tiers": kafkaTiers, "sink_node": uuid.New().String()}
+		edge := strategyEdge(kafka.ID, search.ID, models.StrategyTieredRate, params)
+		snap := newDaySnapshot(snapshotDate, []models.DependencyEdge{edge}, nil, usage, nil)
+		_, err := snap.Shares(snap.ResolveStrategy(edge, "cost"), kafka.ID, "cost")
+		assert.ErrorContains(t, err, "is not a child")
+	})
+}
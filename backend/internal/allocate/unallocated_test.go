@@ -0,0 +1,118 @@
+package allocate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCollectUnallocated checks every reason cost is routed to the unallocated node.
+//
+//	shared ($1000, fixed 25% each) → app, web         $500 share remainder
+//	platform ($200, proportional on requests) → app    no usage, fallback disabled
+//	broken ($100, invalid fixed_percent + equal) → app, web   $50 strategy error
+//	orphan ($50) has no edges and is not a final cost centre
+func TestCollectUnallocated(t *testing.T) {
+	shared := models.CostNode{ID: uuid.New(), Name: "shared", Type: string(models.NodeTypeShared)}
+	platform := models.CostNode{ID: uuid.New(), Name: "platform", Type: string(models.NodeTypePlatform)}
+	broken := models.CostNode{ID: uuid.New(), Name: "broken", Type: string(models.NodeTypeShared)}
+	orphan := models.CostNode{ID: uuid.New(), Name: "orphan", Type: string(models.NodeTypeResource)}
+	app := models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	nodes := []models.CostNode{shared, platform, broken, orphan, app, web}
+
+	edges := []models.DependencyEdge{
+		strategyEdge(shared.ID, app.ID, models.StrategyFixedPercent, map[string]interface{}{"percent": 25.0}),
+		strategyEdge(shared.ID, web.ID, models.StrategyFixedPercent, map[string]interface{}{"percent": 25.0}),
+		strategyEdge(platform.ID, app.ID, models.StrategyProportionalOn, map[string]interface{}{"metric": "requests"}),
+		strategyEdge(broken.ID, app.ID, models.StrategyFixedPercent, nil),
+		strategyEdge(broken.ID, web.ID, models.StrategyEqual, nil),
+	}
+
+	g := graph.NewGraph(snapshotDate, nodes, edges)
+	order, err := g.TopologicalSort()
+	require.NoError(t, err)
+
+	dimensions := []string{"cost"}
+	costsByNode := map[uuid.UUID]map[string]decimal.Decimal{
+		shared.ID:   {"cost": decimal.NewFromInt(1000)},
+		platform.ID: {"cost": decimal.NewFromInt(200)},
+		broken.ID:   {"cost": decimal.NewFromInt(100)},
+		orphan.ID:   {"cost": decimal.NewFromInt(50)},
+	}
+
+	run := func(zeroUsageFallback bool, adjust func([]models.ContributionResultByDimension)) map[uuid.UUID]map[models.UnallocatedReason]string {
+		e := &Engine{remainderPolicy: RemainderToUnallocated, zeroUsageFallback: zeroUsageFallback}
+		snap := newDaySnapshot(snapshotDate, edges, nil, nil, nil)
+		snap.zeroUsageFallback = zeroUsageFallback
+		indirectCosts := e.initializeIndirectCosts(g, dimensions)
+		_, contributions, _, err := e.performAllocationTraversal(uuid.New(), snapshotDate, g, order, dimensions, snap, costsByNode, indirectCosts)
+		require.NoError(t, err)
+		if adjust != nil {
+			adjust(contributions)
+		}
+
+		byNode := make(map[uuid.UUID]map[models.UnallocatedReason]string)
+		for _, result := range e.collectUnallocated(uuid.New(), snapshotDate, g, dimensions, snap, contributions, costsByNode, indirectCosts) {
+			if byNode[result.NodeID] == nil {
+				byNode[result.NodeID] = make(map[models.UnallocatedReason]string)
+			}
+			byNode[result.NodeID][result.Reason] = result.Amount.StringFixed(2)
+		}
+		return byNode
+	}
+
+	t.Run("each cause is attributed to its own reason", func(t *testing.T) {
+		byNode := run(false, nil)
+		assert.Equal(t, map[models.UnallocatedReason]string{models.UnallocatedReasonShareRemainder: "500.00"}, byNode[shared.ID])
+		assert.Equal(t, map[models.UnallocatedReason]string{models.UnallocatedReasonZeroUsageFallbackDisabled: "200.00"}, byNode[platform.ID])
+		assert.Equal(t, map[models.UnallocatedReason]string{models.UnallocatedReasonStrategyError: "50.00"}, byNode[broken.ID])
+		assert.Equal(t, map[models.UnallocatedReason]string{models.UnallocatedReasonNoChildren: "50.00"}, byNode[orphan.ID])
+		assert.Nil(t, byNode[app.ID], "Final cost centres keep their cost")
+		assert.Nil(t, byNode[web.ID], "Final cost centres keep their cost")
+	})
+
+	t.Run("the zero usage fallback places the cost equally", func(t *testing.T) {
+		byNode := run(true, nil)
+		assert.Nil(t, byNode[platform.ID])
+	})
+
+	t.Run("cost retained beyond the remainder is rounding residue", func(t *testing.T) {
+		byNode := run(false, func(contributions []models.ContributionResultByDimension) {
+			for i := range contributions {
+				if contributions[i].ParentID == shared.ID && contributions[i].ChildID == app.ID {
+					contributions[i].ContributedAmount = contributions[i].ContributedAmount.Sub(decimal.RequireFromString("0.01"))
+				}
+			}
+		})
+		assert.Equal(t, "500.00", byNode[shared.ID][models.UnallocatedReasonShareRemainder])
+		assert.Equal(t, "0.01", byNode[shared.ID][models.UnallocatedReasonRoundingResidue])
+	})
+}
+
+// TestUnallocatedNodeAllocations checks the unallocated node holds everything routed to it
+func TestUnallocatedNodeAllocations(t *testing.T) {
+	runID := uuid.New()
+	sink := uuid.New()
+	date := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	allocations := unallocatedNodeAllocations(runID, sink, date, []string{"cost", "egress_gb"}, []models.UnallocatedResultByDimension{
+		{NodeID: uuid.New(), Dimension: "cost", Reason: models.UnallocatedReasonNoChildren, Amount: decimal.NewFromInt(50)},
+		{NodeID: uuid.New(), Dimension: "cost", Reason: models.UnallocatedReasonShareRemainder, Amount: decimal.NewFromInt(25)},
+	})
+	require.Len(t, allocations, 2)
+	assert.Equal(t, sink, allocations[0].NodeID)
+	assert.Equal(t, "75", allocations[0].TotalAmount.String())
+	assert.True(t, allocations[0].DirectAmount.IsZero())
+	assert.True(t, allocations[1].TotalAmount.IsZero(), "Every dimension gets a row")
+
+	totals := newRunTotals(nil, sink)
+	totals.add(dayResult{date: date, allocations: allocations})
+	assert.Equal(t, "75", totals.summary.TotalUnallocated["cost"].String())
+}
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// requestRates holds the FX rates covering a request's period. They are loaded
+// on first use and shared by every copy of the request.
+type requestRates struct {
+	once  sync.Once
+	rates *fx.Rates
+	err   error
+}
+
+// currency returns the currency results are presented in: the requested
+// currency, or the base currency allocations are computed in
+func (s *Service) currency(requested string) string {
+	if requested = fx.NormaliseCurrency(requested); requested != "" {
+		return requested
+	}
+	return s.baseCurrency
+}
+
+// rates returns the FX rates covering a request's period
+func (s *Service) rates(ctx context.Context, req CostAttributionRequest) (*fx.Rates, error) {
+	load := func() (*fx.Rates, error) {
+		rates, err := s.store.FX.GetForDateRange(ctx, req.StartDate, req.EndDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load FX rates: %w", err)
+		}
+		return fx.NewRates(rates), nil
+	}
+
+	if req.rates == nil {
+		return load()
+	}
+	req.rates.once.Do(func() {
+		req.rates.rates, req.rates.err = load()
+	})
+	return req.rates.rates, req.rates.err
+}
+
+// convert converts an amount into the request's currency at the rate on its date
+func (s *Service) convert(ctx context.Context, req CostAttributionRequest, amount decimal.Decimal, from string, date time.Time) (decimal.Decimal, error) {
+	to := s.currency(req.Currency)
+	if fx.NormaliseCurrency(from) == to {
+		return amount, nil
+	}
+
+	rates, err := s.rates(ctx, req)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return rates.Convert(amount, from, to, date)
+}
+
+// convertCosts converts costs into the request's currency
+func (s *Service) convertCosts(ctx context.Context, req CostAttributionRequest, costs []models.NodeCostByDimension) ([]models.NodeCostByDimension, error) {
+	converted := make([]models.NodeCostByDimension, len(costs))
+	for i, cost := range costs {
+		amount, err := s.convert(ctx, req, cost.Amount, cost.Currency, cost.CostDate)
+		if err != nil {
+			return nil, err
+		}
+		cost.Amount = amount
+		cost.Currency = s.currency(req.Currency)
+		converted[i] = cost
+	}
+	return converted, nil
+}
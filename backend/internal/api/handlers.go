@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
 	"github.com/rs/zerolog/log"
 )
 
@@ -277,6 +279,47 @@ func (h *Handler) GetNodeMetricsTimeSeries(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// GetNodeLineage handles requests for the end-to-end cost lineage through a node
+func (h *Handler) GetNodeLineage(c *gin.Context) {
+	nodeIDStr := c.Param("nodeId")
+	nodeID, err := uuid.Parse(nodeIDStr)
+	if err != nil {
+		h.handleError(c, http.StatusBadRequest, "invalid_node_id", "Invalid node ID format")
+		return
+	}
+
+	req, err := h.parseCostAttributionRequest(c)
+	if err != nil {
+		h.handleError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	// Default to the latest completed run when no run is given
+	var runID *uuid.UUID
+	if runIDStr := c.Query("run_id"); runIDStr != "" {
+		parsed, err := uuid.Parse(runIDStr)
+		if err != nil {
+			h.handleError(c, http.StatusBadRequest, "invalid_run_id", "Invalid run ID format")
+			return
+		}
+		runID = &parsed
+	}
+
+	response, err := h.service.GetNodeLineage(c.Request.Context(), nodeID, runID, *req)
+	var notFound *NotFoundError
+	if errors.As(err, &notFound) {
+		h.handleError(c, http.StatusNotFound, "not_found", notFound.Error())
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("node_id", nodeIDStr).Msg("Failed to get node lineage")
+		h.handleError(c, http.StatusInternalServerError, "internal_error", "Failed to retrieve node lineage")
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
 // parseCostAttributionRequest parses common request parameters
 func (h *Handler) parseCostAttributionRequest(c *gin.Context) (*CostAttributionRequest, error) {
 	req := &CostAttributionRequest{}
@@ -323,11 +366,12 @@ func (h *Handler) parseCostAttributionRequest(c *gin.Context) (*CostAttributionR
 		req.IncludeTrend = includeTrend
 	}
 
-	// Parse currency
-	req.Currency = c.Query("currency")
-	if req.Currency == "" {
-		req.Currency = "USD"
+	// Parse currency, defaulting to the base currency
+	req.Currency = h.service.currency(c.Query("currency"))
+	if err := fx.ValidateCurrency(req.Currency); err != nil {
+		return nil, err
 	}
+	req.rates = &requestRates{}
 
 	return req, nil
 }
@@ -550,3 +594,31 @@ func (h *Handler) ExportCSV(c *gin.Context) {
 	// Write CSV data
 	c.Data(http.StatusOK, "text/csv", buf.Bytes())
 }
+
+// ExportFOCUS handles FOCUS export requests for the allocated costs of final
+// cost centres
+func (h *Handler) ExportFOCUS(c *gin.Context) {
+	req, err := h.parseCostAttributionRequest(c)
+	if err != nil {
+		h.handleError(c, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := h.service.ExportFOCUS(c.Request.Context(), *req, &buf); err != nil {
+		log.Error().Err(err).Msg("Failed to export FOCUS")
+		h.handleError(c, http.StatusInternalServerError, "internal_error", "Failed to generate FOCUS export")
+		return
+	}
+
+	filename := fmt.Sprintf("focus_%s_to_%s.csv",
+		req.StartDate.Format("2006-01-02"),
+		req.EndDate.Format("2006-01-02"))
+
+	// Set headers for CSV download
+	c.Header("Content-Type", "text/csv")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Length", strconv.Itoa(buf.Len()))
+
+	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+}
@@ -77,6 +77,37 @@ type NodeMetricsTimeSeriesResponse struct {
 	Metrics    []string               `json:"metrics"`    // Available usage metrics
 }
 
+// NodeLineageResponse represents the end-to-end cost flows that pass through a node
+type NodeLineageResponse struct {
+	NodeID    uuid.UUID     `json:"node_id"`
+	NodeName  string        `json:"node_name"`
+	NodeType  string        `json:"node_type"`
+	RunID     uuid.UUID     `json:"run_id"`
+	Period    string        `json:"period"`
+	StartDate time.Time     `json:"start_date"`
+	EndDate   time.Time     `json:"end_date"`
+	Currency  string        `json:"currency"`
+	Flows     []LineageFlow `json:"flows"`
+}
+
+// LineageFlow represents cost incurred on an origin node that reached a final cost
+// centre along a single allocation path, summed over the requested period
+type LineageFlow struct {
+	Origin     LineageNode     `json:"origin"`
+	CostCentre LineageNode     `json:"cost_centre"`
+	Path       []LineageNode   `json:"path"`
+	Hops       int             `json:"hops"`
+	Dimension  string          `json:"dimension"`
+	Amount     decimal.Decimal `json:"amount"`
+}
+
+// LineageNode identifies a node on a lineage path
+type LineageNode struct {
+	ID   uuid.UUID `json:"id"`
+	Name string    `json:"name"`
+	Type string    `json:"type"`
+}
+
 // DailyCostDataPoint represents cost data for a single day with dimension breakdown
 type DailyCostDataPoint struct {
 	Date       time.Time                  `json:"date"`
@@ -252,6 +283,9 @@ type CostAttributionRequest struct {
 	Dimensions   []string  `json:"dimensions,omitempty" form:"dimensions"`
 	IncludeTrend bool      `json:"include_trend,omitempty" form:"include_trend"`
 	Currency     string    `json:"currency,omitempty" form:"currency"`
+
+	// rates caches the FX rates the request's amounts are converted with
+	rates *requestRates
 }
 
 // ErrorResponse represents an API error response
@@ -362,6 +396,7 @@ type AllocationReconciliationResponse struct {
 	RawInfrastructureCost     decimal.Decimal            `json:"raw_infrastructure_cost"`
 	AllocatedProductCost      decimal.Decimal            `json:"allocated_product_cost"`
 	UnallocatedCost           decimal.Decimal            `json:"unallocated_cost"`
+	UnallocatedByReason       map[string]decimal.Decimal `json:"unallocated_by_reason"`
 	CoveragePercent           float64                    `json:"coverage_percent"`
 	ConservationDelta         decimal.Decimal            `json:"conservation_delta"`
 	ConservationValid         bool                       `json:"conservation_valid"`
@@ -423,9 +458,12 @@ type DashboardSummaryResponse struct {
 	// AllocationCoveragePercent shows what % of raw infra cost is allocated to products
 	AllocationCoveragePercent float64 `json:"allocation_coverage_percent"`
 
-	// UnallocatedCost is the gap between raw infra and allocated product costs
+	// UnallocatedCost is the cost the allocation routed to the run's unallocated node
 	UnallocatedCost decimal.Decimal `json:"unallocated_cost"`
 
+	// UnallocatedByReason breaks UnallocatedCost down by why it could not be placed
+	UnallocatedByReason map[string]decimal.Decimal `json:"unallocated_by_reason"`
+
 	// CostsByType provides breakdown by node type (for pie charts, etc.)
 	CostsByType []TypeAggregation `json:"costs_by_type"`
 
@@ -34,6 +34,7 @@ func SetupRouter(handler *Handler) *gin.Engine {
 		{
 			nodes.GET("/:nodeId", handler.GetIndividualNode)
 			nodes.GET("/:nodeId/metrics/timeseries", handler.GetNodeMetricsTimeSeries)
+			nodes.GET("/:nodeId/lineage", handler.GetNodeLineage)
 			nodes.GET("", handler.ListNodes) // New: flat list of all nodes
 		}
 
@@ -75,6 +76,7 @@ func SetupRouter(handler *Handler) *gin.Engine {
 		export := v1.Group("/export")
 		{
 			export.GET("/csv", handler.ExportCSV)
+			export.GET("/focus", handler.ExportFOCUS)
 		}
 	}
 
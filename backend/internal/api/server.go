@@ -18,6 +18,9 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration `mapstructure:"read_timeout"`
 	WriteTimeout time.Duration `mapstructure:"write_timeout"`
 	IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
+	// BaseCurrency is the currency results are presented in when a request
+	// does not ask for another
+	BaseCurrency string `mapstructure:"base_currency"`
 }
 
 // DefaultServerConfig returns default server configuration
@@ -28,6 +31,7 @@ func DefaultServerConfig() ServerConfig {
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
+		BaseCurrency: "USD",
 	}
 }
 
@@ -42,7 +46,7 @@ type Server struct {
 
 // NewServer creates a new API server
 func NewServer(config ServerConfig, store *store.Store) *Server {
-	service := NewService(store)
+	service := NewService(store, config.BaseCurrency)
 	handler := NewHandler(service)
 	router := SetupRouter(handler)
 
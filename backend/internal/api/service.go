@@ -4,14 +4,19 @@ import (
 	"context"
 	"encoding/csv"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/pickeringtech/FinOpsAggregator/internal/analysis"
 	"github.com/pickeringtech/FinOpsAggregator/internal/analyzer"
+	"github.com/pickeringtech/FinOpsAggregator/internal/focus"
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
 	"github.com/pickeringtech/FinOpsAggregator/internal/graph"
 	"github.com/pickeringtech/FinOpsAggregator/internal/models"
 	"github.com/pickeringtech/FinOpsAggregator/internal/store"
@@ -25,15 +30,31 @@ type Service struct {
 	analyzer               *analysis.FinOpsAnalyzer
 	recommendationAnalyzer *analyzer.RecommendationAnalyzer
 	graphBuilder           *graph.GraphBuilder
+	baseCurrency           string
 }
 
-// NewService creates a new API service
-func NewService(store *store.Store) *Service {
+// NotFoundError reports that a node or computation run a request names does not
+// exist
+type NotFoundError struct {
+	Message string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Message
+}
+
+// NewService creates a new API service presenting results in the base currency
+// unless a request asks for another
+func NewService(store *store.Store, baseCurrency string) *Service {
+	if baseCurrency = fx.NormaliseCurrency(baseCurrency); baseCurrency == "" {
+		baseCurrency = "USD"
+	}
 	return &Service{
 		store:                  store,
 		analyzer:               analysis.NewFinOpsAnalyzer(store),
 		recommendationAnalyzer: analyzer.NewRecommendationAnalyzer(store),
 		graphBuilder:           graph.NewGraphBuilder(store),
+		baseCurrency:           baseCurrency,
 	}
 }
 
@@ -69,26 +90,22 @@ func (s *Service) GetProductHierarchy(ctx context.Context, req CostAttributionRe
 	}
 
 	// Get total infrastructure costs (platform + shared raw infrastructure only)
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
-	}
+	currency := s.currency(req.Currency)
 
 	rawTotalCosts, err := s.store.Costs.GetTotalInfrastructureCostByDateRange(ctx, req.StartDate, req.EndDate, currency)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get total infrastructure costs: %w", err)
 	}
 
-	// Calculate unallocated costs as the gap between raw infra spend and allocated product totals
-	// Unallocated = Raw Infrastructure Cost - Allocated Product Cost
-	unallocatedCost := rawTotalCosts.Sub(totalAllocatedCost)
-	if unallocatedCost.LessThan(decimal.Zero) {
-		// Clamp to zero - negative unallocated indicates data inconsistency
-		unallocatedCost = decimal.Zero
+	// Unallocated costs are whatever the allocation engine routed to the run's unallocated node
+	unallocated, err := s.getUnallocatedCosts(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unallocated costs: %w", err)
 	}
+	unallocatedCost := unallocated.total
 
-	// Get platform and shared nodes for unallocated breakdown
-	unallocatedNode, err := s.buildUnallocatedNode(ctx, req, unallocatedCost)
+	// Break the unallocated node down by the nodes the cost could not be placed from
+	unallocatedNode, err := s.buildUnallocatedNode(ctx, req, unallocated)
 	if err != nil {
 		return nil, fmt.Errorf("failed to build unallocated node: %w", err)
 	}
@@ -263,7 +280,7 @@ func (s *Service) GetPlatformServices(ctx context.Context, req CostAttributionRe
 
 // ListProducts retrieves a flat list of products with their costs
 func (s *Service) ListProducts(ctx context.Context, req CostAttributionRequest, limit, offset int) (*NodeListResponse, error) {
-	nodes, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, req.Currency, "product", limit, offset)
+	nodes, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), "product", limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list products: %w", err)
 	}
@@ -290,7 +307,7 @@ func (s *Service) ListProducts(ctx context.Context, req CostAttributionRequest,
 
 // ListNodes retrieves a flat list of all nodes with their costs
 func (s *Service) ListNodes(ctx context.Context, req CostAttributionRequest, nodeType string, limit, offset int) (*NodeListResponse, error) {
-	nodes, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, req.Currency, nodeType, limit, offset)
+	nodes, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), nodeType, limit, offset)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list nodes: %w", err)
 	}
@@ -317,7 +334,7 @@ func (s *Service) ListNodes(ctx context.Context, req CostAttributionRequest, nod
 
 // GetCostsByType retrieves costs aggregated by node type
 func (s *Service) GetCostsByType(ctx context.Context, req CostAttributionRequest) (*CostsByTypeResponse, error) {
-	costsByType, err := s.store.Costs.GetCostsByType(ctx, req.StartDate, req.EndDate, req.Currency)
+	costsByType, err := s.store.Costs.GetCostsByType(ctx, req.StartDate, req.EndDate, s.currency(req.Currency))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get costs by type: %w", err)
 	}
@@ -340,13 +357,13 @@ func (s *Service) GetCostsByType(ctx context.Context, req CostAttributionRequest
 	return &CostsByTypeResponse{
 		Aggregations: aggregations,
 		TotalCost:    totalCost,
-		Currency:     req.Currency,
+		Currency:     s.currency(req.Currency),
 	}, nil
 }
 
 // GetCostsByDimension retrieves costs aggregated by a custom dimension
 func (s *Service) GetCostsByDimension(ctx context.Context, req CostAttributionRequest, dimensionKey string) (*CostsByDimensionResponse, error) {
-	costsByDim, err := s.store.Costs.GetCostsByDimension(ctx, req.StartDate, req.EndDate, req.Currency, dimensionKey)
+	costsByDim, err := s.store.Costs.GetCostsByDimension(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), dimensionKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get costs by dimension: %w", err)
 	}
@@ -367,7 +384,7 @@ func (s *Service) GetCostsByDimension(ctx context.Context, req CostAttributionRe
 		DimensionKey: dimensionKey,
 		Aggregations: aggregations,
 		TotalCost:    totalCost,
-		Currency:     req.Currency,
+		Currency:     s.currency(req.Currency),
 	}, nil
 }
 
@@ -435,7 +452,7 @@ func (s *Service) buildProductNode(ctx context.Context, node models.CostNode, re
 // getNodeDirectCosts retrieves direct costs for a node from allocation results
 func (s *Service) getNodeDirectCosts(ctx context.Context, nodeID uuid.UUID, req CostAttributionRequest) (*CostBreakdown, error) {
 	// Use allocated costs from computation results instead of raw input costs
-	allocatedCosts, err := s.store.Costs.GetAllocatedCostsByNodeAndDateRange(ctx, nodeID, req.StartDate, req.EndDate)
+	allocatedCosts, err := s.store.Costs.GetAllocatedCostsByNodeAndDateRange(ctx, nodeID, req.StartDate, req.EndDate, s.currency(req.Currency))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get node costs: %w", err)
 	}
@@ -443,19 +460,21 @@ func (s *Service) getNodeDirectCosts(ctx context.Context, nodeID uuid.UUID, req
 	return s.buildCostBreakdownFromAllocations(allocatedCosts, req), nil
 }
 
-// buildCostBreakdown builds a cost breakdown from cost data
-func (s *Service) buildCostBreakdown(costs []models.NodeCostByDimension, req CostAttributionRequest) *CostBreakdown {
+// buildCostBreakdown builds a cost breakdown from cost data, converted to the
+// request's currency
+func (s *Service) buildCostBreakdown(ctx context.Context, costs []models.NodeCostByDimension, req CostAttributionRequest) (*CostBreakdown, error) {
+	costs, err := s.convertCosts(ctx, req, costs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert costs: %w", err)
+	}
+
 	breakdown := &CostBreakdown{
 		Total:      decimal.Zero,
-		Currency:   req.Currency,
+		Currency:   s.currency(req.Currency),
 		Dimensions: make(map[string]decimal.Decimal),
 		Trend:      []DailyCostPoint{},
 	}
 
-	if breakdown.Currency == "" {
-		breakdown.Currency = "USD"
-	}
-
 	// Aggregate by dimension
 	for _, cost := range costs {
 		breakdown.Total = breakdown.Total.Add(cost.Amount)
@@ -471,7 +490,7 @@ func (s *Service) buildCostBreakdown(costs []models.NodeCostByDimension, req Cos
 		breakdown.Trend = s.buildCostTrend(costs, req.StartDate, req.EndDate)
 	}
 
-	return breakdown
+	return breakdown, nil
 }
 
 // buildCostBreakdownFromAllocations builds a cost breakdown from allocation results
@@ -479,15 +498,11 @@ func (s *Service) buildCostBreakdown(costs []models.NodeCostByDimension, req Cos
 func (s *Service) buildCostBreakdownFromAllocations(allocations []models.AllocationResultByDimension, req CostAttributionRequest) *CostBreakdown {
 	breakdown := &CostBreakdown{
 		Total:      decimal.Zero,
-		Currency:   req.Currency,
+		Currency:   s.currency(req.Currency),
 		Dimensions: make(map[string]decimal.Decimal),
 		Trend:      []DailyCostPoint{},
 	}
 
-	if breakdown.Currency == "" {
-		breakdown.Currency = "USD"
-	}
-
 	// Aggregate by dimension using DirectAmount (node's own costs)
 	for _, alloc := range allocations {
 		breakdown.Total = breakdown.Total.Add(alloc.DirectAmount)
@@ -583,11 +598,11 @@ func (s *Service) getNodeAllocatedCosts(ctx context.Context, nodeID uuid.UUID, r
 			CostDate:  allocation.AllocationDate,
 			Dimension: allocation.Dimension,
 			Amount:    allocation.IndirectAmount,
-			Currency:  "USD", // TODO: Get from config
+			Currency:  s.baseCurrency,
 		})
 	}
 
-	return s.buildCostBreakdown(costs, req), nil
+	return s.buildCostBreakdown(ctx, costs, req)
 }
 
 // getNodeSharedServiceCosts retrieves costs allocated from shared services specifically
@@ -613,12 +628,12 @@ func (s *Service) getNodeSharedServiceCosts(ctx context.Context, nodeID uuid.UUI
 				CostDate:  contribution.ContributionDate,
 				Dimension: contribution.Dimension,
 				Amount:    contribution.ContributedAmount,
-				Currency:  "USD", // TODO: Get from config
+				Currency:  s.baseCurrency,
 			})
 		}
 	}
 
-	return s.buildCostBreakdown(sharedCosts, req), nil
+	return s.buildCostBreakdown(ctx, sharedCosts, req)
 }
 
 // combineCostBreakdowns combines two cost breakdowns
@@ -732,6 +747,11 @@ func (s *Service) getNodeAllocations(ctx context.Context, nodeID uuid.UUID, req
 	}
 
 	for _, contribution := range parentContributions {
+		amount, err := s.convert(ctx, req, contribution.ContributedAmount, s.baseCurrency, contribution.ContributionDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert contribution: %w", err)
+		}
+
 		// Get the child node details
 		childNode, err := s.store.Nodes.GetByID(ctx, contribution.ChildID)
 		if err != nil {
@@ -749,8 +769,8 @@ func (s *Service) getNodeAllocations(ctx context.Context, nodeID uuid.UUID, req
 				Name: "", // Will be filled by caller
 				Type: "", // Will be filled by caller
 			},
-			Amount:         contribution.ContributedAmount,
-			Currency:       "USD", // TODO: Get from config
+			Amount:         amount,
+			Currency:       s.currency(req.Currency),
 			Dimension:      contribution.Dimension,
 			Strategy:       "", // TODO: Get from edge strategy
 			AllocationDate: contribution.ContributionDate,
@@ -764,6 +784,11 @@ func (s *Service) getNodeAllocations(ctx context.Context, nodeID uuid.UUID, req
 	}
 
 	for _, contribution := range childContributions {
+		amount, err := s.convert(ctx, req, contribution.ContributedAmount, s.baseCurrency, contribution.ContributionDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert contribution: %w", err)
+		}
+
 		// Get the parent node details
 		parentNode, err := s.store.Nodes.GetByID(ctx, contribution.ParentID)
 		if err != nil {
@@ -781,8 +806,8 @@ func (s *Service) getNodeAllocations(ctx context.Context, nodeID uuid.UUID, req
 				Name: parentNode.Name,
 				Type: parentNode.Type,
 			},
-			Amount:         contribution.ContributedAmount,
-			Currency:       "USD", // TODO: Get from config
+			Amount:         amount,
+			Currency:       s.currency(req.Currency),
 			Dimension:      contribution.Dimension,
 			Strategy:       "", // TODO: Get from edge strategy
 			AllocationDate: contribution.ContributionDate,
@@ -836,11 +861,14 @@ func (s *Service) buildChildNodeWithContributions(ctx context.Context, node mode
 			CostDate:  contribution.ContributionDate,
 			Dimension: contribution.Dimension,
 			Amount:    contribution.ContributedAmount,
-			Currency:  "USD", // TODO: Get from config
+			Currency:  s.baseCurrency,
 		})
 	}
 
-	contributedCosts := s.buildCostBreakdown(costs, req)
+	contributedCosts, err := s.buildCostBreakdown(ctx, costs, req)
+	if err != nil {
+		return nil, err
+	}
 
 	// For children, we show the contributed amount as both direct and holistic
 	// since we're showing what this child contributed to the parent
@@ -850,7 +878,7 @@ func (s *Service) buildChildNodeWithContributions(ctx context.Context, node mode
 		Type:               node.Type,
 		DirectCosts:        *contributedCosts,
 		HolisticCosts:      *contributedCosts,
-		SharedServiceCosts: CostBreakdown{Total: decimal.Zero, Currency: req.Currency, Dimensions: make(map[string]decimal.Decimal)},
+		SharedServiceCosts: CostBreakdown{Total: decimal.Zero, Currency: s.currency(req.Currency), Dimensions: make(map[string]decimal.Decimal)},
 		Children:           []ProductNode{}, // Don't recurse further for children
 		Metadata:           node.Metadata,
 	}, nil
@@ -917,8 +945,13 @@ func (s *Service) getPlatformAllocationTargets(ctx context.Context, nodeID uuid.
 	totalAmount := decimal.Zero
 
 	for _, contribution := range contributions {
+		amount, err := s.convert(ctx, req, contribution.ContributedAmount, s.baseCurrency, contribution.ContributionDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert contribution: %w", err)
+		}
+
 		if target, exists := targetMap[contribution.ParentID]; exists {
-			target.Amount = target.Amount.Add(contribution.ContributedAmount)
+			target.Amount = target.Amount.Add(amount)
 		} else {
 			// Get parent node details
 			parentNode, err := s.store.Nodes.GetByID(ctx, contribution.ParentID)
@@ -930,11 +963,11 @@ func (s *Service) getPlatformAllocationTargets(ctx context.Context, nodeID uuid.
 				NodeID:   contribution.ParentID,
 				NodeName: parentNode.Name,
 				NodeType: parentNode.Type,
-				Amount:   contribution.ContributedAmount,
-				Currency: "USD", // TODO: Get from config
+				Amount:   amount,
+				Currency: s.currency(req.Currency),
 			}
 		}
-		totalAmount = totalAmount.Add(contribution.ContributedAmount)
+		totalAmount = totalAmount.Add(amount)
 	}
 
 	// Calculate percentages and convert to slice
@@ -962,8 +995,13 @@ func (s *Service) getSharedServiceWeightedTargets(ctx context.Context, nodeID uu
 	totalAmount := decimal.Zero
 
 	for _, contribution := range contributions {
+		amount, err := s.convert(ctx, req, contribution.ContributedAmount, s.baseCurrency, contribution.ContributionDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert contribution: %w", err)
+		}
+
 		if target, exists := targetMap[contribution.ParentID]; exists {
-			target.Amount = target.Amount.Add(contribution.ContributedAmount)
+			target.Amount = target.Amount.Add(amount)
 		} else {
 			// Get parent node details
 			parentNode, err := s.store.Nodes.GetByID(ctx, contribution.ParentID)
@@ -979,11 +1017,11 @@ func (s *Service) getSharedServiceWeightedTargets(ctx context.Context, nodeID uu
 				NodeName: parentNode.Name,
 				NodeType: parentNode.Type,
 				Weight:   weight,
-				Amount:   contribution.ContributedAmount,
-				Currency: "USD", // TODO: Get from config
+				Amount:   amount,
+				Currency: s.currency(req.Currency),
 			}
 		}
-		totalAmount = totalAmount.Add(contribution.ContributedAmount)
+		totalAmount = totalAmount.Add(amount)
 	}
 
 	// Calculate percentages and convert to slice
@@ -1017,6 +1055,11 @@ func (s *Service) getWeightedAllocations(ctx context.Context, req CostAttributio
 		}
 
 		for _, contribution := range contributions {
+			amount, err := s.convert(ctx, req, contribution.ContributedAmount, s.baseCurrency, contribution.ContributionDate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert contribution: %w", err)
+			}
+
 			// Get target node details
 			targetNode, err := s.store.Nodes.GetByID(ctx, contribution.ParentID)
 			if err != nil {
@@ -1033,8 +1076,8 @@ func (s *Service) getWeightedAllocations(ctx context.Context, req CostAttributio
 				TargetNodeID:      targetNode.ID,
 				TargetNodeName:    targetNode.Name,
 				Weight:            weight,
-				Amount:            contribution.ContributedAmount,
-				Currency:          "USD", // TODO: Get from config
+				Amount:            amount,
+				Currency:          s.currency(req.Currency),
 				Dimension:         contribution.Dimension,
 				Strategy:          strategy,
 			})
@@ -1070,6 +1113,10 @@ func (s *Service) calculatePlatformSummary(ctx context.Context, req CostAttribut
 		if err != nil {
 			continue // Skip if we can't get costs
 		}
+		costs, err = s.convertCosts(ctx, req, costs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert costs: %w", err)
+		}
 		for _, cost := range costs {
 			totalCost = totalCost.Add(cost.Amount)
 		}
@@ -1082,16 +1129,17 @@ func (s *Service) calculatePlatformSummary(ctx context.Context, req CostAttribut
 		if err != nil {
 			continue // Skip if we can't get costs
 		}
+		costs, err = s.convertCosts(ctx, req, costs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert costs: %w", err)
+		}
 		for _, cost := range costs {
 			totalCost = totalCost.Add(cost.Amount)
 		}
 		nodeCount++
 	}
 
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
-	}
+	currency := s.currency(req.Currency)
 
 	return &CostSummary{
 		TotalCost:         totalCost,
@@ -1166,10 +1214,7 @@ func (s *Service) GetRecommendations(ctx context.Context, req CostAttributionReq
 		}
 	}
 
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
-	}
+	currency := s.currency(req.Currency)
 
 	return &RecommendationsResponse{
 		Recommendations:     apiRecommendations,
@@ -1183,126 +1228,157 @@ func (s *Service) GetRecommendations(ctx context.Context, req CostAttributionReq
 
 
 
-// buildUnallocatedNode creates a synthetic node representing unallocated platform/shared costs
-func (s *Service) buildUnallocatedNode(ctx context.Context, req CostAttributionRequest, unallocatedCost decimal.Decimal) (*ProductNode, error) {
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
+// unallocatedCosts is the cost a run's allocation could not place, read from the
+// run's unallocated node and the reasons recorded against it
+type unallocatedCosts struct {
+	runID       uuid.UUID
+	nodeID      uuid.UUID
+	total       decimal.Decimal
+	byDimension map[string]decimal.Decimal
+	byReason    map[string]decimal.Decimal
+	// bySource is keyed by the node the cost could not be placed from, then reason
+	bySource map[uuid.UUID]map[string]decimal.Decimal
+}
+
+// getUnallocatedCosts reads the cost the latest completed run routed to its
+// unallocated node over the request period. Runs computed before the engine had
+// an unallocated node report nothing.
+func (s *Service) getUnallocatedCosts(ctx context.Context, req CostAttributionRequest) (*unallocatedCosts, error) {
+	costs := &unallocatedCosts{
+		total:       decimal.Zero,
+		byDimension: make(map[string]decimal.Decimal),
+		byReason:    make(map[string]decimal.Decimal),
+		bySource:    make(map[uuid.UUID]map[string]decimal.Decimal),
 	}
 
-	// Get platform and shared nodes to show as children
-	platformNodes, err := s.store.Nodes.List(ctx, store.NodeFilters{
-		IsPlatform: &[]bool{true}[0],
-	})
+	run, err := s.latestCompletedRun(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get platform nodes: %w", err)
+		return nil, err
 	}
+	if run == nil || run.UnallocatedNodeID == nil {
+		log.Warn().Msg("No completed computation run with an unallocated node, reporting no unallocated cost")
+		return costs, nil
+	}
+	costs.runID = run.ID
+	costs.nodeID = *run.UnallocatedNodeID
 
-	sharedNodes, err := s.store.Nodes.List(ctx, store.NodeFilters{
-		Type: string(models.NodeTypeShared),
+	allocations, err := s.store.Runs.GetAllocationResults(ctx, run.ID, store.AllocationResultFilters{
+		NodeID:     *run.UnallocatedNodeID,
+		StartDate:  req.StartDate,
+		EndDate:    req.EndDate,
+		Dimensions: req.Dimensions,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get shared nodes: %w", err)
+		return nil, fmt.Errorf("failed to get unallocated node allocations: %w", err)
 	}
-
-	// Build children showing unallocated portions
-	children := make([]ProductNode, 0)
-
-	// Add platform nodes
-	for _, node := range platformNodes {
-		directCosts, err := s.getNodeDirectCosts(ctx, node.ID, req)
+	for _, alloc := range allocations {
+		amount, err := s.convert(ctx, req, alloc.TotalAmount, run.Currency, alloc.AllocationDate)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("failed to convert unallocated cost: %w", err)
 		}
+		costs.total = costs.total.Add(amount)
+		costs.byDimension[alloc.Dimension] = costs.byDimension[alloc.Dimension].Add(amount)
+	}
 
-		// Get how much was allocated to products
-		allocatedToProducts, err := s.getNodeAllocatedToProducts(ctx, node.ID, req)
+	results, err := s.store.Runs.GetUnallocatedResults(ctx, run.ID, store.UnallocatedResultFilters{
+		StartDate:  req.StartDate,
+		EndDate:    req.EndDate,
+		Dimensions: req.Dimensions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unallocated results: %w", err)
+	}
+	for _, result := range results {
+		amount, err := s.convert(ctx, req, result.Amount, run.Currency, result.AllocationDate)
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("failed to convert unallocated cost: %w", err)
 		}
-
-		// Calculate unallocated portion
-		unallocatedPortion := directCosts.Total.Sub(allocatedToProducts)
-
-		if unallocatedPortion.GreaterThan(decimal.Zero) {
-			children = append(children, ProductNode{
-				ID:   node.ID,
-				Name: node.Name,
-				Type: node.Type,
-				DirectCosts: CostBreakdown{
-					Total:      unallocatedPortion,
-					Currency:   currency,
-					Dimensions: map[string]decimal.Decimal{},
-				},
-				HolisticCosts: CostBreakdown{
-					Total:      unallocatedPortion,
-					Currency:   currency,
-					Dimensions: map[string]decimal.Decimal{},
-				},
-				SharedServiceCosts: CostBreakdown{
-					Total:      decimal.Zero,
-					Currency:   currency,
-					Dimensions: map[string]decimal.Decimal{},
-				},
-				Metadata: node.Metadata,
-			})
+		reason := string(result.Reason)
+		costs.byReason[reason] = costs.byReason[reason].Add(amount)
+		if costs.bySource[result.NodeID] == nil {
+			costs.bySource[result.NodeID] = make(map[string]decimal.Decimal)
 		}
+		costs.bySource[result.NodeID][reason] = costs.bySource[result.NodeID][reason].Add(amount)
 	}
 
-	// Add shared nodes
-	for _, node := range sharedNodes {
-		directCosts, err := s.getNodeDirectCosts(ctx, node.ID, req)
-		if err != nil {
-			continue
-		}
+	return costs, nil
+}
+
+// latestCompletedRun returns the most recent completed computation run, or nil if there is none
+func (s *Service) latestCompletedRun(ctx context.Context) (*models.ComputationRun, error) {
+	runs, err := s.store.Runs.List(ctx, store.RunFilters{Status: string(models.ComputationStatusCompleted), Limit: 1})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find latest run: %w", err)
+	}
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	return &runs[0], nil
+}
+
+// buildUnallocatedNode represents the run's unallocated node in the product hierarchy,
+// with a child for every node whose cost was routed to it
+func (s *Service) buildUnallocatedNode(ctx context.Context, req CostAttributionRequest, unallocated *unallocatedCosts) (*ProductNode, error) {
+	currency := s.currency(req.Currency)
 
-		// Get how much was allocated to products
-		allocatedToProducts, err := s.getNodeAllocatedToProducts(ctx, node.ID, req)
+	children := make([]ProductNode, 0, len(unallocated.bySource))
+	for sourceID, reasons := range unallocated.bySource {
+		node, err := s.store.Nodes.GetByID(ctx, sourceID)
 		if err != nil {
 			continue
 		}
 
-		// Calculate unallocated portion
-		unallocatedPortion := directCosts.Total.Sub(allocatedToProducts)
-
-		if unallocatedPortion.GreaterThan(decimal.Zero) {
-			children = append(children, ProductNode{
-				ID:   node.ID,
-				Name: node.Name,
-				Type: node.Type,
-				DirectCosts: CostBreakdown{
-					Total:      unallocatedPortion,
-					Currency:   currency,
-					Dimensions: map[string]decimal.Decimal{},
-				},
-				HolisticCosts: CostBreakdown{
-					Total:      unallocatedPortion,
-					Currency:   currency,
-					Dimensions: map[string]decimal.Decimal{},
-				},
-				SharedServiceCosts: CostBreakdown{
-					Total:      decimal.Zero,
-					Currency:   currency,
-					Dimensions: map[string]decimal.Decimal{},
-				},
-				Metadata: node.Metadata,
-			})
+		total := decimal.Zero
+		for _, amount := range reasons {
+			total = total.Add(amount)
 		}
+
+		children = append(children, ProductNode{
+			ID:   node.ID,
+			Name: node.Name,
+			Type: node.Type,
+			DirectCosts: CostBreakdown{
+				Total:      total,
+				Currency:   currency,
+				Dimensions: map[string]decimal.Decimal{},
+			},
+			HolisticCosts: CostBreakdown{
+				Total:      total,
+				Currency:   currency,
+				Dimensions: map[string]decimal.Decimal{},
+			},
+			SharedServiceCosts: CostBreakdown{
+				Total:      decimal.Zero,
+				Currency:   currency,
+				Dimensions: map[string]decimal.Decimal{},
+			},
+			Metadata: map[string]interface{}{
+				"unallocated_reasons": reasons,
+			},
+		})
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].HolisticCosts.Total.GreaterThan(children[j].HolisticCosts.Total)
+	})
+
+	id := unallocated.nodeID
+	if id == uuid.Nil {
+		id = uuid.New()
 	}
 
 	return &ProductNode{
-		ID:   uuid.New(),
-		Name: "Unallocated Platform & Shared Costs",
-		Type: "unallocated",
+		ID:   id,
+		Name: "Unallocated Costs",
+		Type: string(models.NodeTypeUnallocated),
 		DirectCosts: CostBreakdown{
-			Total:      unallocatedCost,
+			Total:      decimal.Zero,
 			Currency:   currency,
 			Dimensions: map[string]decimal.Decimal{},
 		},
 		HolisticCosts: CostBreakdown{
-			Total:      unallocatedCost,
+			Total:      unallocated.total,
 			Currency:   currency,
-			Dimensions: map[string]decimal.Decimal{},
+			Dimensions: unallocated.byDimension,
 		},
 		SharedServiceCosts: CostBreakdown{
 			Total:      decimal.Zero,
@@ -1311,34 +1387,12 @@ func (s *Service) buildUnallocatedNode(ctx context.Context, req CostAttributionR
 		},
 		Children: children,
 		Metadata: map[string]interface{}{
-			"description": "Platform and shared service costs that are not allocated to any product",
+			"description":         "Costs the allocation could not place on any child, by the node they were left on",
+			"unallocated_reasons": unallocated.byReason,
 		},
 	}, nil
 }
 
-// getNodeAllocatedToProducts calculates how much of a node's cost was allocated to products
-func (s *Service) getNodeAllocatedToProducts(ctx context.Context, nodeID uuid.UUID, req CostAttributionRequest) (decimal.Decimal, error) {
-	// Get all contributions where this node is the child (contributing to products)
-	contributions, err := s.store.Runs.GetContributionsByChildAndDateRange(ctx, nodeID, req.StartDate, req.EndDate, req.Dimensions)
-	if err != nil {
-		return decimal.Zero, fmt.Errorf("failed to get contributions: %w", err)
-	}
-
-	total := decimal.Zero
-	for _, contrib := range contributions {
-		// Only count contributions to product nodes
-		parentNode, err := s.store.Nodes.GetByID(ctx, contrib.ParentID)
-		if err != nil {
-			continue
-		}
-		if parentNode.Type == string(models.NodeTypeProduct) {
-			total = total.Add(contrib.ContributedAmount)
-		}
-	}
-
-	return total, nil
-}
-
 // GetAllocationReconciliation provides debug information for allocation reconciliation
 // This endpoint helps diagnose allocation issues by showing:
 // - Raw infrastructure cost vs allocated product cost
@@ -1353,7 +1407,7 @@ func (s *Service) GetAllocationReconciliation(ctx context.Context, req CostAttri
 	}
 
 	// Get raw infrastructure cost
-	rawInfraCost, err := s.store.Costs.GetTotalInfrastructureCostByDateRange(ctx, req.StartDate, req.EndDate, req.Currency)
+	rawInfraCost, err := s.store.Costs.GetTotalInfrastructureCostByDateRange(ctx, req.StartDate, req.EndDate, s.currency(req.Currency))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get raw infrastructure cost: %w", err)
 	}
@@ -1383,8 +1437,16 @@ func (s *Service) GetAllocationReconciliation(ctx context.Context, req CostAttri
 
 		var directCost, indirectCost decimal.Decimal
 		for _, alloc := range allocations {
-			directCost = directCost.Add(alloc.DirectAmount)
-			indirectCost = indirectCost.Add(alloc.IndirectAmount)
+			direct, err := s.convert(ctx, req, alloc.DirectAmount, s.baseCurrency, alloc.AllocationDate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert allocation: %w", err)
+			}
+			indirect, err := s.convert(ctx, req, alloc.IndirectAmount, s.baseCurrency, alloc.AllocationDate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert allocation: %w", err)
+			}
+			directCost = directCost.Add(direct)
+			indirectCost = indirectCost.Add(indirect)
 		}
 		holisticCost := directCost.Add(indirectCost)
 		allocatedProductCost = allocatedProductCost.Add(holisticCost)
@@ -1412,6 +1474,10 @@ func (s *Service) GetAllocationReconciliation(ctx context.Context, req CostAttri
 		if err != nil {
 			continue
 		}
+		costs, err = s.convertCosts(ctx, req, costs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert costs: %w", err)
+		}
 
 		var directCost decimal.Decimal
 		for _, cost := range costs {
@@ -1427,11 +1493,12 @@ func (s *Service) GetAllocationReconciliation(ctx context.Context, req CostAttri
 		})
 	}
 
-	// Calculate unallocated cost
-	unallocatedCost := rawInfraCost.Sub(allocatedProductCost)
-	if unallocatedCost.LessThan(decimal.Zero) {
-		unallocatedCost = decimal.Zero
+	// Unallocated cost is what the engine routed to the run's unallocated node
+	unallocated, err := s.getUnallocatedCosts(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unallocated costs: %w", err)
 	}
+	unallocatedCost := unallocated.total
 
 	// Calculate coverage
 	coveragePercent := 0.0
@@ -1488,10 +1555,11 @@ func (s *Service) GetAllocationReconciliation(ctx context.Context, req CostAttri
 		Period:                fmt.Sprintf("%s to %s", req.StartDate.Format("2006-01-02"), req.EndDate.Format("2006-01-02")),
 		StartDate:            req.StartDate,
 		EndDate:              req.EndDate,
-		Currency:             req.Currency,
+		Currency:             s.currency(req.Currency),
 		RawInfrastructureCost: rawInfraCost,
 		AllocatedProductCost:  allocatedProductCost,
 		UnallocatedCost:       unallocatedCost,
+		UnallocatedByReason:   unallocated.byReason,
 		CoveragePercent:       coveragePercent,
 		ConservationDelta:     conservationDelta,
 		ConservationValid:     conservationValid,
@@ -1618,10 +1686,7 @@ func (s *Service) performProductTreeSanityChecks(
 // GetDashboardSummary returns the correct totals for the dashboard
 // This uses final cost centres to calculate the true "Total Product Cost" without double-counting
 func (s *Service) GetDashboardSummary(ctx context.Context, req CostAttributionRequest) (*DashboardSummaryResponse, error) {
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
-	}
+	currency := s.currency(req.Currency)
 
 	// Build graph to identify final cost centres
 	g, err := s.graphBuilder.BuildForDate(ctx, req.EndDate)
@@ -1656,11 +1721,14 @@ func (s *Service) GetDashboardSummary(ctx context.Context, req CostAttributionRe
 		return nil, fmt.Errorf("failed to get raw infrastructure cost: %w", err)
 	}
 
-	// Calculate unallocated cost and coverage
-	unallocatedCost := rawInfraCost.Sub(totalProductCost)
-	if unallocatedCost.LessThan(decimal.Zero) {
-		unallocatedCost = decimal.Zero
+	// Unallocated cost is what the engine routed to the run's unallocated node
+	unallocated, err := s.getUnallocatedCosts(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unallocated costs: %w", err)
 	}
+	unallocatedCost := unallocated.total
+
+	// Calculate coverage
 
 	var coveragePercent float64
 	if !rawInfraCost.IsZero() {
@@ -1705,6 +1773,7 @@ func (s *Service) GetDashboardSummary(ctx context.Context, req CostAttributionRe
 		RawInfrastructureCost:     rawInfraCost,
 		AllocationCoveragePercent: coveragePercent,
 		UnallocatedCost:           unallocatedCost,
+		UnallocatedByReason:       unallocated.byReason,
 		CostsByType:               typeAggregations,
 		ProductCount:              productCount,
 		FinalCostCentreCount:      len(finalCostCentreIDs),
@@ -1721,10 +1790,7 @@ func (s *Service) GetDashboardSummary(ctx context.Context, req CostAttributionRe
 // GetInfrastructureHierarchy retrieves the infrastructure hierarchy with cost and allocation data
 // This shows platform, shared, and resource nodes with their direct costs and how much has been allocated to products
 func (s *Service) GetInfrastructureHierarchy(ctx context.Context, req CostAttributionRequest) (*InfrastructureHierarchyResponse, error) {
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
-	}
+	currency := s.currency(req.Currency)
 
 	// Get all infrastructure-type nodes
 	platformNodes, err := s.store.Nodes.List(ctx, store.NodeFilters{IsPlatform: &[]bool{true}[0]})
@@ -1830,10 +1896,13 @@ func (s *Service) buildInfrastructureNode(ctx context.Context, node models.CostN
 	if err != nil {
 		return nil, fmt.Errorf("failed to get direct costs: %w", err)
 	}
-	directBreakdown := s.buildCostBreakdown(directCosts, req)
+	directBreakdown, err := s.buildCostBreakdown(ctx, directCosts, req)
+	if err != nil {
+		return nil, err
+	}
 
 	// Get allocations FROM this node TO products
-	allocations, err := s.store.Costs.GetAllocationsFromNode(ctx, node.ID, req.StartDate, req.EndDate)
+	allocations, err := s.store.Costs.GetAllocationsFromNode(ctx, node.ID, req.StartDate, req.EndDate, s.currency(req.Currency))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get allocations: %w", err)
 	}
@@ -1864,7 +1933,7 @@ func (s *Service) buildInfrastructureNode(ctx context.Context, node models.CostN
 				Name:     targetNode.Name,
 				Type:     targetNode.Type,
 				Amount:   alloc.Amount,
-				Currency: req.Currency,
+				Currency: s.currency(req.Currency),
 				Strategy: alloc.Strategy,
 			}
 		}
@@ -1898,7 +1967,7 @@ func (s *Service) buildInfrastructureNode(ctx context.Context, node models.CostN
 		DirectCosts: *directBreakdown,
 		AllocatedCosts: CostBreakdown{
 			Total:      allocatedTotal,
-			Currency:   req.Currency,
+			Currency:   s.currency(req.Currency),
 			Dimensions: allocatedDimensions,
 		},
 		UnallocatedCost: unallocatedCost,
@@ -1910,10 +1979,7 @@ func (s *Service) buildInfrastructureNode(ctx context.Context, node models.CostN
 
 // GetNodeMetricsTimeSeries retrieves cost and usage metrics over time for a specific node
 func (s *Service) GetNodeMetricsTimeSeries(ctx context.Context, nodeID uuid.UUID, req CostAttributionRequest) (*NodeMetricsTimeSeriesResponse, error) {
-	currency := req.Currency
-	if currency == "" {
-		currency = "USD"
-	}
+	currency := s.currency(req.Currency)
 
 	// Get node info
 	node, err := s.store.Nodes.GetByID(ctx, nodeID)
@@ -1926,6 +1992,10 @@ func (s *Service) GetNodeMetricsTimeSeries(ctx context.Context, nodeID uuid.UUID
 	if err != nil {
 		return nil, fmt.Errorf("failed to get costs: %w", err)
 	}
+	costs, err = s.convertCosts(ctx, req, costs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert costs: %w", err)
+	}
 
 	// Get daily usage (nil metrics means all metrics)
 	usage, err := s.store.Usage.GetByNodeAndDateRange(ctx, nodeID, req.StartDate, req.EndDate, nil)
@@ -2005,12 +2075,140 @@ func (s *Service) GetNodeMetricsTimeSeries(ctx context.Context, nodeID uuid.UUID
 	}, nil
 }
 
+// GetNodeLineage retrieves the end-to-end cost flows that originate on, end on, or pass
+// through a node, summed per path and dimension over the requested period
+func (s *Service) GetNodeLineage(ctx context.Context, nodeID uuid.UUID, runID *uuid.UUID, req CostAttributionRequest) (*NodeLineageResponse, error) {
+	currency := s.currency(req.Currency)
+
+	node, err := s.store.Nodes.GetByID(ctx, nodeID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, &NotFoundError{Message: fmt.Sprintf("node %s not found", nodeID)}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get node: %w", err)
+	}
+
+	var run *models.ComputationRun
+	if runID == nil {
+		run, err = s.latestCompletedRun(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if run == nil {
+			return nil, &NotFoundError{Message: "no completed computation runs found"}
+		}
+		runID = &run.ID
+	} else {
+		run, err = s.store.Runs.GetByID(ctx, *runID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, &NotFoundError{Message: fmt.Sprintf("computation run %s not found", *runID)}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get computation run: %w", err)
+		}
+	}
+
+	lineage, err := s.store.Runs.GetLineage(ctx, *runID, nodeID, store.LineageFilters{
+		StartDate:  req.StartDate,
+		EndDate:    req.EndDate,
+		Dimensions: req.Dimensions,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lineage: %w", err)
+	}
+
+	// Resolve every node on the paths in one query
+	names := map[uuid.UUID]LineageNode{
+		node.ID: {ID: node.ID, Name: node.Name, Type: node.Type},
+	}
+	var ids []uuid.UUID
+	add := func(id uuid.UUID) {
+		if _, ok := names[id]; !ok {
+			names[id] = LineageNode{ID: id}
+			ids = append(ids, id)
+		}
+	}
+	for _, l := range lineage {
+		add(l.OriginID)
+		add(l.CostCentreID)
+		for _, id := range l.Path {
+			add(id)
+		}
+	}
+	if len(ids) > 0 {
+		pathNodes, err := s.store.Nodes.List(ctx, store.NodeFilters{IDs: ids, IncludeArchived: true, IncludeUnallocated: true})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get lineage nodes: %w", err)
+		}
+		for _, n := range pathNodes {
+			names[n.ID] = LineageNode{ID: n.ID, Name: n.Name, Type: n.Type}
+		}
+	}
+	lookup := func(id uuid.UUID) LineageNode {
+		return names[id]
+	}
+
+	// Sum each path and dimension across the period
+	flowsByKey := make(map[string]*LineageFlow)
+	var keys []string
+	for _, l := range lineage {
+		pathIDs := make([]string, len(l.Path))
+		for i, id := range l.Path {
+			pathIDs[i] = id.String()
+		}
+		key := l.Dimension + ":" + strings.Join(pathIDs, ">")
+
+		flow, exists := flowsByKey[key]
+		if !exists {
+			path := make([]LineageNode, len(l.Path))
+			for i, id := range l.Path {
+				path[i] = lookup(id)
+			}
+			flow = &LineageFlow{
+				Origin:     lookup(l.OriginID),
+				CostCentre: lookup(l.CostCentreID),
+				Path:       path,
+				Hops:       len(l.Path) - 1,
+				Dimension:  l.Dimension,
+				Amount:     decimal.Zero,
+			}
+			flowsByKey[key] = flow
+			keys = append(keys, key)
+		}
+		amount, err := s.convert(ctx, req, l.Amount, run.Currency, l.LineageDate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert lineage: %w", err)
+		}
+		flow.Amount = flow.Amount.Add(amount)
+	}
+
+	flows := make([]LineageFlow, 0, len(keys))
+	for _, key := range keys {
+		flows = append(flows, *flowsByKey[key])
+	}
+	sort.SliceStable(flows, func(i, j int) bool {
+		return flows[i].Amount.GreaterThan(flows[j].Amount)
+	})
+
+	return &NodeLineageResponse{
+		NodeID:    node.ID,
+		NodeName:  node.Name,
+		NodeType:  node.Type,
+		RunID:     *runID,
+		Period:    fmt.Sprintf("%s to %s", req.StartDate.Format("2006-01-02"), req.EndDate.Format("2006-01-02")),
+		StartDate: req.StartDate,
+		EndDate:   req.EndDate,
+		Currency:  currency,
+		Flows:     flows,
+	}, nil
+}
+
 // CSV Export Methods
 
 // ExportProductsToCSV exports products with costs to CSV format
 func (s *Service) ExportProductsToCSV(ctx context.Context, req CostAttributionRequest, writer io.Writer) error {
 	// Get products data
-	products, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, req.Currency, "product", 0, 0)
+	products, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), "product", 0, 0)
 	if err != nil {
 		return fmt.Errorf("failed to list products: %w", err)
 	}
@@ -2046,7 +2244,7 @@ func (s *Service) ExportProductsToCSV(ctx context.Context, req CostAttributionRe
 // ExportNodesToCSV exports all nodes with costs to CSV format
 func (s *Service) ExportNodesToCSV(ctx context.Context, req CostAttributionRequest, nodeType string, writer io.Writer) error {
 	// Get nodes data
-	nodes, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, req.Currency, nodeType, 0, 0)
+	nodes, err := s.store.Costs.ListNodesWithCosts(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), nodeType, 0, 0)
 	if err != nil {
 		return fmt.Errorf("failed to list nodes: %w", err)
 	}
@@ -2082,7 +2280,7 @@ func (s *Service) ExportNodesToCSV(ctx context.Context, req CostAttributionReque
 // ExportCostsByTypeToCSV exports costs aggregated by type to CSV format
 func (s *Service) ExportCostsByTypeToCSV(ctx context.Context, req CostAttributionRequest, writer io.Writer) error {
 	// Get costs by type data
-	costsByType, err := s.store.Costs.GetCostsByType(ctx, req.StartDate, req.EndDate, req.Currency)
+	costsByType, err := s.store.Costs.GetCostsByType(ctx, req.StartDate, req.EndDate, s.currency(req.Currency))
 	if err != nil {
 		return fmt.Errorf("failed to get costs by type: %w", err)
 	}
@@ -2105,7 +2303,7 @@ func (s *Service) ExportCostsByTypeToCSV(ctx context.Context, req CostAttributio
 			ct.TotalCost.StringFixed(2),
 			strconv.Itoa(ct.NodeCount),
 			strconv.FormatFloat(ct.PercentOfTotal, 'f', 2, 64),
-			req.Currency,
+			s.currency(req.Currency),
 			req.StartDate.Format("2006-01-02"),
 			req.EndDate.Format("2006-01-02"),
 		}
@@ -2183,7 +2381,7 @@ func (s *Service) ExportRecommendationsToCSV(ctx context.Context, req CostAttrib
 // ExportDetailedCostsToCSV exports detailed cost records (not aggregated) to CSV format
 func (s *Service) ExportDetailedCostsToCSV(ctx context.Context, req CostAttributionRequest, nodeType string, writer io.Writer) error {
 	// Get detailed cost data from allocation results
-	costs, err := s.store.Costs.GetDetailedCostRecords(ctx, req.StartDate, req.EndDate, req.Currency, nodeType)
+	costs, err := s.store.Costs.GetDetailedCostRecords(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), nodeType)
 	if err != nil {
 		return fmt.Errorf("failed to get detailed cost records: %w", err)
 	}
@@ -2224,7 +2422,7 @@ func (s *Service) ExportDetailedCostsToCSV(ctx context.Context, req CostAttribut
 // ExportRawCostsToCSV exports raw ingested cost data (pre-allocation) to CSV format
 func (s *Service) ExportRawCostsToCSV(ctx context.Context, req CostAttributionRequest, nodeType string, writer io.Writer) error {
 	// Get raw cost data from node_costs_by_dimension
-	costs, err := s.store.Costs.GetRawCostRecords(ctx, req.StartDate, req.EndDate, req.Currency, nodeType)
+	costs, err := s.store.Costs.GetRawCostRecords(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), nodeType)
 	if err != nil {
 		return fmt.Errorf("failed to get raw cost records: %w", err)
 	}
@@ -2271,7 +2469,7 @@ func (s *Service) ExportRawCostsToCSV(ctx context.Context, req CostAttributionRe
 // ExportProductHierarchyToCSV exports product hierarchy with downstream relationships to CSV
 func (s *Service) ExportProductHierarchyToCSV(ctx context.Context, req CostAttributionRequest, writer io.Writer) error {
 	// Get product hierarchy records
-	records, err := s.store.Costs.GetProductHierarchyRecords(ctx, req.StartDate, req.EndDate, req.Currency)
+	records, err := s.store.Costs.GetProductHierarchyRecords(ctx, req.StartDate, req.EndDate, s.currency(req.Currency))
 	if err != nil {
 		return fmt.Errorf("failed to get product hierarchy records: %w", err)
 	}
@@ -2373,3 +2571,75 @@ func (s *Service) ExportProductHierarchyToCSV(ctx context.Context, req CostAttri
 
 	return nil
 }
+
+// ExportFOCUS exports the allocated costs of final cost centres in the FinOps
+// Foundation's FOCUS schema, one row per cost centre, day and dimension. Each
+// row is a usage charge whose resource is the cost centre and whose service is
+// the dimension. Allocations carry no pricing detail, so every cost column holds
+// the allocated cost; its direct and indirect parts are in custom columns.
+func (s *Service) ExportFOCUS(ctx context.Context, req CostAttributionRequest, writer io.Writer) error {
+	// Build graph to identify final cost centres
+	g, err := s.graphBuilder.BuildForDate(ctx, req.EndDate)
+	if err != nil {
+		return fmt.Errorf("failed to build graph for final cost centre detection: %w", err)
+	}
+	finalCostCentreSet := make(map[uuid.UUID]bool)
+	for _, id := range g.GetFinalCostCentres() {
+		finalCostCentreSet[id] = true
+	}
+
+	records, err := s.store.Costs.GetDetailedCostRecords(ctx, req.StartDate, req.EndDate, s.currency(req.Currency), "product")
+	if err != nil {
+		return fmt.Errorf("failed to get detailed cost records: %w", err)
+	}
+
+	focusWriter, err := focus.NewWriter(writer, "x_Dimension", "x_DirectCost", "x_IndirectCost")
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if !finalCostCentreSet[record.NodeID] || record.TotalCost.IsZero() {
+			continue
+		}
+
+		var tags map[string]string
+		if node, ok := g.Nodes()[record.NodeID]; ok && len(node.CostLabels) > 0 {
+			tags = make(map[string]string, len(node.CostLabels))
+			for key, value := range node.CostLabels {
+				tags[key] = fmt.Sprint(value)
+			}
+		}
+
+		periodStart, periodEnd := focus.BillingPeriod(record.Date)
+		row := focus.Row{
+			BillingPeriodStart: periodStart,
+			BillingPeriodEnd:   periodEnd,
+			ChargePeriodStart:  record.Date,
+			ChargePeriodEnd:    record.Date.AddDate(0, 0, 1),
+			BilledCost:         record.TotalCost,
+			EffectiveCost:      record.TotalCost,
+			ListCost:           record.TotalCost,
+			ContractedCost:     record.TotalCost,
+			BillingCurrency:    record.Currency,
+			ChargeCategory:     focus.ChargeCategoryUsage,
+			ChargeDescription:  fmt.Sprintf("%s cost allocated to %s", record.Dimension, record.NodeName),
+			ProviderName:       "FinOpsAggregator",
+			ServiceName:        record.Dimension,
+			ResourceID:         record.NodeID.String(),
+			ResourceName:       record.NodeName,
+			ResourceType:       record.NodeType,
+			Tags:               tags,
+			Custom: map[string]string{
+				"x_Dimension":    record.Dimension,
+				"x_DirectCost":   record.DirectCost.String(),
+				"x_IndirectCost": record.IndirectCost.String(),
+			},
+		}
+		if err := focusWriter.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return focusWriter.Flush()
+}
@@ -10,14 +10,15 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Postgres PostgresConfig `mapstructure:"postgres"`
-	Compute  ComputeConfig  `mapstructure:"compute"`
-	Charts   ChartsConfig   `mapstructure:"charts"`
-	Storage  StorageConfig  `mapstructure:"storage"`
-	Jobs     JobsConfig     `mapstructure:"jobs"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
-	API      APIConfig      `mapstructure:"api"`
-	Lambda   LambdaConfig   `mapstructure:"lambda"`
+	Postgres  PostgresConfig  `mapstructure:"postgres"`
+	Compute   ComputeConfig   `mapstructure:"compute"`
+	Charts    ChartsConfig    `mapstructure:"charts"`
+	Storage   StorageConfig   `mapstructure:"storage"`
+	Jobs      JobsConfig      `mapstructure:"jobs"`
+	Logging   LoggingConfig   `mapstructure:"logging"`
+	API       APIConfig       `mapstructure:"api"`
+	Lambda    LambdaConfig    `mapstructure:"lambda"`
+	Ingestion IngestionConfig `mapstructure:"ingestion"`
 }
 
 // PostgresConfig holds database configuration
@@ -29,6 +30,36 @@ type PostgresConfig struct {
 type ComputeConfig struct {
 	BaseCurrency     string   `mapstructure:"base_currency"`
 	ActiveDimensions []string `mapstructure:"active_dimensions"`
+	// RemainderPolicy decides what happens when a parent's child shares sum to less
+	// than 1 (to_unallocated, renormalise or fail)
+	RemainderPolicy string `mapstructure:"remainder_policy"`
+	// ZeroUsageFallback lets usage-based strategies split equally when no child has
+	// usage; when false the cost is routed to the run's unallocated node instead
+	ZeroUsageFallback bool `mapstructure:"zero_usage_fallback"`
+	// CurrencyPrecision maps a currency code to its minor unit in decimal places;
+	// each parent's contributions are rounded to it using the largest remainder method
+	CurrencyPrecision map[string]int32 `mapstructure:"currency_precision"`
+	// RunTimeout fails a computation run that takes longer; zero means no timeout
+	RunTimeout time.Duration `mapstructure:"run_timeout"`
+	// HeartbeatInterval is how often a running computation records a heartbeat
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	// StaleRunAfter is how long a computation run may go without a heartbeat before
+	// it is reaped and marked failed
+	StaleRunAfter time.Duration `mapstructure:"stale_run_after"`
+}
+
+// defaultCurrencyPrecision is used for currencies missing from CurrencyPrecision
+const defaultCurrencyPrecision int32 = 2
+
+// PrecisionFor returns the number of decimal places amounts in the given currency
+// are rounded to. Viper lower-cases map keys, so the lookup ignores case.
+func (c ComputeConfig) PrecisionFor(currency string) int32 {
+	for code, places := range c.CurrencyPrecision {
+		if strings.EqualFold(code, currency) {
+			return places
+		}
+	}
+	return defaultCurrencyPrecision
 }
 
 // ChartsConfig holds chart generation settings
@@ -48,6 +79,23 @@ type JobsConfig struct {
 	Queues      map[string]int `mapstructure:"queues"`
 }
 
+// IngestionConfig holds cost import settings
+type IngestionConfig struct {
+	// MappingRules is the path of a YAML file of rules mapping AWS CUR line items
+	// to nodes; when empty the Product, Service and CostCenter tags are used
+	MappingRules string `mapstructure:"mapping_rules"`
+	// PrometheusURL is the Prometheus server usage is queried from
+	PrometheusURL string `mapstructure:"prometheus_url"`
+	// PrometheusToken, when set, is sent as a bearer token with Prometheus queries
+	PrometheusToken string `mapstructure:"prometheus_token"`
+	// PrometheusRules is the path of a YAML file of rules mapping Prometheus
+	// series to nodes; when empty the service, app and job labels are used
+	PrometheusRules string `mapstructure:"prometheus_rules"`
+	// DynatraceMetrics is the path of a YAML file of Dynatrace metric mappings,
+	// with their units and aggregations, added to the defaults
+	DynatraceMetrics string `mapstructure:"dynatrace_metrics"`
+}
+
 // LoggingConfig holds logging settings
 type LoggingConfig struct {
 	Level string `mapstructure:"level"`
@@ -64,7 +112,7 @@ type APIConfig struct {
 
 // LambdaConfig holds AWS Lambda-specific settings
 type LambdaConfig struct {
-	// Handler specifies which Lambda handler to use (import_awscur, import_dynatrace, export, allocate)
+	// Handler specifies which Lambda handler to use (import_awscur, import_dynatrace, import_azure, import_gcp, import_prometheus, export, allocate)
 	Handler string `mapstructure:"handler"`
 	// ImportBucket is the S3 bucket for data imports
 	ImportBucket string `mapstructure:"import_bucket"`
@@ -72,6 +120,15 @@ type LambdaConfig struct {
 	ExportBucket string `mapstructure:"export_bucket"`
 	// CreateMissingNodes controls whether to auto-create nodes during import
 	CreateMissingNodes bool `mapstructure:"create_missing_nodes"`
+	// DynatraceHourly also records Dynatrace datapoints per hour, for peak-based strategies
+	DynatraceHourly bool `mapstructure:"dynatrace_hourly"`
+	// DynatraceRawSamples also keeps Dynatrace datapoints at their exported resolution
+	DynatraceRawSamples bool `mapstructure:"dynatrace_raw_samples"`
+	// DynatraceEntityDimension routes Dynatrace datapoints to nodes by the entity
+	// in this dimension, e.g. dt.entity.service, rather than one node per file
+	DynatraceEntityDimension string `mapstructure:"dynatrace_entity_dimension"`
+	// DynatraceEntityNodes maps Dynatrace entity IDs or names to node names or IDs
+	DynatraceEntityNodes map[string]string `mapstructure:"dynatrace_entity_nodes"`
 }
 
 // Load loads configuration from file and environment variables
@@ -118,6 +175,17 @@ func setDefaults(v *viper.Viper) {
 
 	// Compute defaults
 	v.SetDefault("compute.base_currency", "USD")
+	v.SetDefault("compute.remainder_policy", "to_unallocated")
+	v.SetDefault("compute.zero_usage_fallback", true)
+	v.SetDefault("compute.currency_precision", map[string]int32{
+		"usd": 2,
+		"eur": 2,
+		"gbp": 2,
+		"jpy": 0,
+	})
+	v.SetDefault("compute.run_timeout", 0)
+	v.SetDefault("compute.heartbeat_interval", 30*time.Second)
+	v.SetDefault("compute.stale_run_after", 10*time.Minute)
 	v.SetDefault("compute.active_dimensions", []string{
 		"instance_hours",
 		"storage_gb_month",
@@ -147,6 +215,13 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("jobs.queues.default", 1)
 	v.SetDefault("jobs.queues.exports", 1)
 
+	// Ingestion defaults
+	v.SetDefault("ingestion.mapping_rules", "")
+	v.SetDefault("ingestion.prometheus_url", "")
+	v.SetDefault("ingestion.prometheus_token", "")
+	v.SetDefault("ingestion.prometheus_rules", "")
+	v.SetDefault("ingestion.dynatrace_metrics", "")
+
 	// Logging defaults
 	v.SetDefault("logging.level", "info")
 
@@ -162,4 +237,7 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("lambda.import_bucket", "")
 	v.SetDefault("lambda.export_bucket", "")
 	v.SetDefault("lambda.create_missing_nodes", false)
+	v.SetDefault("lambda.dynatrace_hourly", false)
+	v.SetDefault("lambda.dynatrace_raw_samples", false)
+	v.SetDefault("lambda.dynatrace_entity_dimension", "")
 }
@@ -0,0 +1,252 @@
+// Package focus reads and writes cost data in the FinOps Foundation's FOCUS
+// (FinOps Open Cost and Usage Specification) schema.
+package focus
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// FOCUS column names
+const (
+	ColBilledCost         = "BilledCost"
+	ColEffectiveCost      = "EffectiveCost"
+	ColListCost           = "ListCost"
+	ColContractedCost     = "ContractedCost"
+	ColBillingCurrency    = "BillingCurrency"
+	ColBillingPeriodStart = "BillingPeriodStart"
+	ColBillingPeriodEnd   = "BillingPeriodEnd"
+	ColChargePeriodStart  = "ChargePeriodStart"
+	ColChargePeriodEnd    = "ChargePeriodEnd"
+	ColChargeCategory     = "ChargeCategory"
+	ColChargeDescription  = "ChargeDescription"
+	ColProviderName       = "ProviderName"
+	ColServiceName        = "ServiceName"
+	ColServiceCategory    = "ServiceCategory"
+	ColResourceID         = "ResourceId"
+	ColResourceName       = "ResourceName"
+	ColResourceType       = "ResourceType"
+	ColRegionID           = "RegionId"
+	ColSubAccountID       = "SubAccountId"
+	ColSubAccountName     = "SubAccountName"
+	ColTags               = "Tags"
+)
+
+// Charge categories
+const (
+	ChargeCategoryUsage      = "Usage"
+	ChargeCategoryPurchase   = "Purchase"
+	ChargeCategoryTax        = "Tax"
+	ChargeCategoryCredit     = "Credit"
+	ChargeCategoryAdjustment = "Adjustment"
+)
+
+// TimeFormat is the format of FOCUS date/time values
+const TimeFormat = "2006-01-02T15:04:05Z"
+
+// Columns are the columns a Writer writes, in order, before any custom columns
+var Columns = []string{
+	ColBillingPeriodStart,
+	ColBillingPeriodEnd,
+	ColChargePeriodStart,
+	ColChargePeriodEnd,
+	ColBilledCost,
+	ColEffectiveCost,
+	ColListCost,
+	ColContractedCost,
+	ColBillingCurrency,
+	ColChargeCategory,
+	ColChargeDescription,
+	ColProviderName,
+	ColServiceName,
+	ColServiceCategory,
+	ColResourceID,
+	ColResourceName,
+	ColResourceType,
+	ColSubAccountID,
+	ColSubAccountName,
+	ColTags,
+}
+
+// Row is a FOCUS cost row
+type Row struct {
+	BillingPeriodStart time.Time
+	BillingPeriodEnd   time.Time
+	ChargePeriodStart  time.Time
+	ChargePeriodEnd    time.Time
+	BilledCost         decimal.Decimal
+	EffectiveCost      decimal.Decimal
+	ListCost           decimal.Decimal
+	ContractedCost     decimal.Decimal
+	BillingCurrency    string
+	ChargeCategory     string
+	ChargeDescription  string
+	ProviderName       string
+	ServiceName        string
+	ServiceCategory    string
+	ResourceID         string
+	ResourceName       string
+	ResourceType       string
+	SubAccountID       string
+	SubAccountName     string
+	Tags               map[string]string
+	// Custom holds the values of custom columns, keyed by column name
+	Custom map[string]string
+}
+
+// BillingPeriod returns the calendar month containing a date, as FOCUS billing
+// periods are: inclusive start and exclusive end
+func BillingPeriod(date time.Time) (time.Time, time.Time) {
+	start := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 1, 0)
+}
+
+// Writer writes FOCUS rows as CSV
+type Writer struct {
+	csv    *csv.Writer
+	custom []string
+	header bool
+}
+
+// NewWriter creates a writer of FOCUS rows with the given custom columns. Custom
+// column names must start with the x_ prefix FOCUS reserves for them.
+func NewWriter(w io.Writer, customColumns ...string) (*Writer, error) {
+	for _, col := range customColumns {
+		if !strings.HasPrefix(col, "x_") {
+			return nil, fmt.Errorf("custom column %s must start with x_", col)
+		}
+	}
+	return &Writer{csv: csv.NewWriter(w), custom: customColumns}, nil
+}
+
+// Write writes a row, preceded by the header if it is the first
+func (w *Writer) Write(row Row) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	tags, err := FormatTags(row.Tags)
+	if err != nil {
+		return err
+	}
+
+	record := []string{
+		formatTime(row.BillingPeriodStart),
+		formatTime(row.BillingPeriodEnd),
+		formatTime(row.ChargePeriodStart),
+		formatTime(row.ChargePeriodEnd),
+		row.BilledCost.String(),
+		row.EffectiveCost.String(),
+		row.ListCost.String(),
+		row.ContractedCost.String(),
+		row.BillingCurrency,
+		row.ChargeCategory,
+		row.ChargeDescription,
+		row.ProviderName,
+		row.ServiceName,
+		row.ServiceCategory,
+		row.ResourceID,
+		row.ResourceName,
+		row.ResourceType,
+		row.SubAccountID,
+		row.SubAccountName,
+		tags,
+	}
+	for _, col := range w.custom {
+		record = append(record, row.Custom[col])
+	}
+
+	if err := w.csv.Write(record); err != nil {
+		return fmt.Errorf("failed to write FOCUS row: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the header if no rows were written, then any buffered data
+func (w *Writer) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// writeHeader writes the header unless it has been written
+func (w *Writer) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	if err := w.csv.Write(append(append([]string{}, Columns...), w.custom...)); err != nil {
+		return fmt.Errorf("failed to write FOCUS header: %w", err)
+	}
+	w.header = true
+	return nil
+}
+
+// ParseTime parses a FOCUS date/time. Datasets in the wild also use offsets,
+// fractional seconds and plain dates, which are accepted too.
+func ParseTime(value string) (time.Time, error) {
+	formats := []string{
+		TimeFormat,
+		time.RFC3339Nano,
+		"2006-01-02 15:04:05",
+		"2006-01-02T15:04:05",
+		"2006-01-02",
+	}
+	for _, format := range formats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse date/time: %s", value)
+}
+
+// ParseTags parses the Tags column, a JSON object whose values may be strings,
+// numbers or booleans. Non-string values are returned in their JSON form.
+func ParseTags(value string) (map[string]string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return nil, fmt.Errorf("invalid tags: %w", err)
+	}
+
+	tags := make(map[string]string, len(raw))
+	for key, v := range raw {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			tags[key] = s
+		} else {
+			tags[key] = string(v)
+		}
+	}
+	return tags, nil
+}
+
+// FormatTags formats tags as a JSON object, or an empty string when there are none
+func FormatTags(tags map[string]string) (string, error) {
+	if len(tags) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(tags)
+	if err != nil {
+		return "", fmt.Errorf("failed to format tags: %w", err)
+	}
+	return string(b), nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(TimeFormat)
+}
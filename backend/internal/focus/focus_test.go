@@ -0,0 +1,77 @@
+package focus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "x_DirectCost")
+	require.NoError(t, err)
+
+	date := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	periodStart, periodEnd := BillingPeriod(date)
+	require.NoError(t, w.Write(Row{
+		BillingPeriodStart: periodStart,
+		BillingPeriodEnd:   periodEnd,
+		ChargePeriodStart:  date,
+		ChargePeriodEnd:    date.AddDate(0, 0, 1),
+		BilledCost:         decimal.RequireFromString("12.5"),
+		EffectiveCost:      decimal.RequireFromString("12.5"),
+		BillingCurrency:    "USD",
+		ChargeCategory:     ChargeCategoryUsage,
+		ResourceName:       "checkout",
+		Tags:               map[string]string{"team": "payments", "env": "prod"},
+		Custom:             map[string]string{"x_DirectCost": "2.5"},
+	}))
+	require.NoError(t, w.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, strings.Join(append(append([]string{}, Columns...), "x_DirectCost"), ","), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "2024-01-01T00:00:00Z,2024-02-01T00:00:00Z,2024-01-15T00:00:00Z,2024-01-16T00:00:00Z,12.5,12.5,0,0,USD,Usage,"))
+	assert.Contains(t, lines[1], `"{""env"":""prod"",""team"":""payments""}"`)
+	assert.True(t, strings.HasSuffix(lines[1], ",2.5"))
+
+	_, err = NewWriter(&buf, "DirectCost")
+	assert.Error(t, err)
+}
+
+func TestWriterWritesHeaderWithoutRows(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	assert.Equal(t, strings.Join(Columns, ",")+"\n", buf.String())
+}
+
+func TestParseTags(t *testing.T) {
+	tags, err := ParseTags(`{"team": "payments", "replicas": 3, "critical": true}`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"team": "payments", "replicas": "3", "critical": "true"}, tags)
+
+	tags, err = ParseTags("")
+	require.NoError(t, err)
+	assert.Nil(t, tags)
+
+	_, err = ParseTags("team=payments")
+	assert.Error(t, err)
+}
+
+func TestParseTime(t *testing.T) {
+	for _, value := range []string{"2024-01-15T00:00:00Z", "2024-01-15T01:00:00+01:00", "2024-01-15"} {
+		parsed, err := ParseTime(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), parsed, value)
+	}
+
+	_, err := ParseTime("15/01/2024")
+	assert.Error(t, err)
+}
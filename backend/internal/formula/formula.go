@@ -0,0 +1,436 @@
+// Package formula implements the small expression language used by the formula
+// allocation strategy. Expressions are arithmetic over usage metrics, node labels
+// and constants, for example
+//
+//	requests * 0.7 + cpu_hours * 0.3
+//	if(label("tier") == "gold", 2, 1) * usage("builtin:service.requestCount.total")
+//
+// The language is sandboxed: it has no assignment, loops or access to anything
+// but the Env it is evaluated against, expressions are limited in size and
+// nesting, and every expression is type checked when it is parsed so that a bad
+// formula is rejected before any allocation runs.
+package formula
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// maxLength is the longest expression accepted, in bytes
+	maxLength = 2048
+	// maxDepth is the deepest nesting of operators and parentheses accepted
+	maxDepth = 32
+)
+
+// Env supplies the values an expression reads when it is evaluated
+type Env interface {
+	// Usage returns the node's usage of a metric, zero if it has none
+	Usage(metric string) decimal.Decimal
+	// Label returns the value of one of the node's labels
+	Label(key string) (string, bool)
+}
+
+// Expression is a parsed, type checked formula
+type Expression struct {
+	source  string
+	root    node
+	metrics []string
+	labels  bool
+}
+
+// Parse parses and type checks a formula. Bare identifiers name constants when
+// they appear in constants and usage metrics otherwise. The formula must evaluate
+// to a number.
+func Parse(source string, constants map[string]decimal.Decimal) (*Expression, error) {
+	if strings.TrimSpace(source) == "" {
+		return nil, fmt.Errorf("formula is empty")
+	}
+	if len(source) > maxLength {
+		return nil, fmt.Errorf("formula is longer than %d characters", maxLength)
+	}
+
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula: %w", err)
+	}
+
+	p := &parser{tokens: tokens, constants: constants, metrics: make(map[string]bool)}
+	root, err := p.parseExpression()
+	if err != nil {
+		return nil, fmt.Errorf("invalid formula: %w", err)
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("invalid formula: unexpected %s at offset %d", describe(t), t.pos)
+	}
+	if root.typ() != typeNumber {
+		return nil, fmt.Errorf("invalid formula: result must be a number, got a %s", root.typ())
+	}
+
+	metrics := make([]string, 0, len(p.metrics))
+	for metric := range p.metrics {
+		metrics = append(metrics, metric)
+	}
+	sort.Strings(metrics)
+
+	return &Expression{source: source, root: root, metrics: metrics, labels: p.labels}, nil
+}
+
+// String returns the formula as it was written
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Metrics returns the usage metrics the formula reads, in sorted order
+func (e *Expression) Metrics() []string {
+	return e.metrics
+}
+
+// UsesLabels reports whether the formula reads node labels
+func (e *Expression) UsesLabels() bool {
+	return e.labels
+}
+
+// Evaluate computes the formula against an environment
+func (e *Expression) Evaluate(env Env) (decimal.Decimal, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to evaluate formula: %w", err)
+	}
+	return v.number, nil
+}
+
+// valueType is the static type of an expression
+type valueType int
+
+const (
+	typeNumber valueType = iota
+	typeString
+	typeBool
+)
+
+func (t valueType) String() string {
+	switch t {
+	case typeString:
+		return "string"
+	case typeBool:
+		return "boolean"
+	default:
+		return "number"
+	}
+}
+
+// value is the result of evaluating a node; only the field matching its type is set
+type value struct {
+	number  decimal.Decimal
+	str     string
+	boolean bool
+}
+
+// node is a type checked node of the expression tree
+type node interface {
+	typ() valueType
+	eval(env Env) (value, error)
+}
+
+type numberNode struct{ value decimal.Decimal }
+
+func (n *numberNode) typ() valueType          { return typeNumber }
+func (n *numberNode) eval(Env) (value, error) { return value{number: n.value}, nil }
+
+type stringNode struct{ value string }
+
+func (n *stringNode) typ() valueType          { return typeString }
+func (n *stringNode) eval(Env) (value, error) { return value{str: n.value}, nil }
+
+type boolNode struct{ value bool }
+
+func (n *boolNode) typ() valueType          { return typeBool }
+func (n *boolNode) eval(Env) (value, error) { return value{boolean: n.value}, nil }
+
+// usageNode reads a usage metric
+type usageNode struct{ metric string }
+
+func (n *usageNode) typ() valueType { return typeNumber }
+func (n *usageNode) eval(env Env) (value, error) {
+	return value{number: env.Usage(n.metric)}, nil
+}
+
+// labelNode reads a label as a string, as a number with a default, or tests it exists
+type labelNode struct {
+	key      string
+	result   valueType
+	fallback node // label_number only
+}
+
+func (n *labelNode) typ() valueType { return n.result }
+func (n *labelNode) eval(env Env) (value, error) {
+	label, ok := env.Label(n.key)
+	switch n.result {
+	case typeBool:
+		return value{boolean: ok}, nil
+	case typeString:
+		return value{str: label}, nil
+	}
+
+	if ok {
+		if number, err := decimal.NewFromString(strings.TrimSpace(label)); err == nil {
+			return value{number: number}, nil
+		}
+	}
+	return n.fallback.eval(env)
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) typ() valueType {
+	if n.op == "!" {
+		return typeBool
+	}
+	return typeNumber
+}
+
+func (n *unaryNode) eval(env Env) (value, error) {
+	v, err := n.operand.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+	if n.op == "!" {
+		return value{boolean: !v.boolean}, nil
+	}
+	return value{number: v.number.Neg()}, nil
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+	result      valueType
+}
+
+// newBinary type checks a binary operator
+func newBinary(op token, left, right node) (node, error) {
+	mismatch := func(want string) error {
+		return fmt.Errorf("operator %s at offset %d needs %s, got a %s and a %s", op.text, op.pos, want, left.typ(), right.typ())
+	}
+
+	switch op.text {
+	case "+", "-", "*", "/":
+		if left.typ() != typeNumber || right.typ() != typeNumber {
+			return nil, mismatch("numbers")
+		}
+		return &binaryNode{op: op.text, left: left, right: right, result: typeNumber}, nil
+	case "<", "<=", ">", ">=":
+		if left.typ() != typeNumber || right.typ() != typeNumber {
+			return nil, mismatch("numbers")
+		}
+	case "==", "!=":
+		if left.typ() != right.typ() {
+			return nil, mismatch("operands of the same type")
+		}
+	case "&&", "||":
+		if left.typ() != typeBool || right.typ() != typeBool {
+			return nil, mismatch("booleans")
+		}
+	}
+	return &binaryNode{op: op.text, left: left, right: right, result: typeBool}, nil
+}
+
+func (n *binaryNode) typ() valueType { return n.result }
+
+func (n *binaryNode) eval(env Env) (value, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+
+	// Logical operators short-circuit
+	switch n.op {
+	case "&&":
+		if !left.boolean {
+			return value{boolean: false}, nil
+		}
+		return n.right.eval(env)
+	case "||":
+		if left.boolean {
+			return value{boolean: true}, nil
+		}
+		return n.right.eval(env)
+	}
+
+	right, err := n.right.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+
+	switch n.op {
+	case "+":
+		return value{number: left.number.Add(right.number)}, nil
+	case "-":
+		return value{number: left.number.Sub(right.number)}, nil
+	case "*":
+		return value{number: left.number.Mul(right.number)}, nil
+	case "/":
+		if right.number.IsZero() {
+			return value{}, fmt.Errorf("division by zero")
+		}
+		return value{number: left.number.Div(right.number)}, nil
+	case "<":
+		return value{boolean: left.number.LessThan(right.number)}, nil
+	case "<=":
+		return value{boolean: left.number.LessThanOrEqual(right.number)}, nil
+	case ">":
+		return value{boolean: left.number.GreaterThan(right.number)}, nil
+	case ">=":
+		return value{boolean: left.number.GreaterThanOrEqual(right.number)}, nil
+	case "==", "!=":
+		var equal bool
+		switch n.left.typ() {
+		case typeNumber:
+			equal = left.number.Equal(right.number)
+		case typeString:
+			equal = left.str == right.str
+		case typeBool:
+			equal = left.boolean == right.boolean
+		}
+		return value{boolean: equal == (n.op == "==")}, nil
+	}
+	return value{}, fmt.Errorf("unknown operator %s", n.op)
+}
+
+// ifNode evaluates only the branch its condition selects
+type ifNode struct {
+	cond, then, otherwise node
+}
+
+func (n *ifNode) typ() valueType { return n.then.typ() }
+
+func (n *ifNode) eval(env Env) (value, error) {
+	cond, err := n.cond.eval(env)
+	if err != nil {
+		return value{}, err
+	}
+	if cond.boolean {
+		return n.then.eval(env)
+	}
+	return n.otherwise.eval(env)
+}
+
+// numericCallNode applies min, max or abs
+type numericCallNode struct {
+	name string
+	args []node
+}
+
+func (n *numericCallNode) typ() valueType { return typeNumber }
+
+func (n *numericCallNode) eval(env Env) (value, error) {
+	values := make([]decimal.Decimal, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return value{}, err
+		}
+		values[i] = v.number
+	}
+
+	switch n.name {
+	case "abs":
+		return value{number: values[0].Abs()}, nil
+	case "min":
+		return value{number: decimal.Min(values[0], values[1:]...)}, nil
+	default:
+		return value{number: decimal.Max(values[0], values[1:]...)}, nil
+	}
+}
+
+// function type checks a call's arguments and builds its node
+type function struct {
+	check func(args []node) (node, error)
+}
+
+// functions lists every function a formula may call
+var functions = map[string]function{
+	"min": {check: numericCall("min", 1, -1)},
+	"max": {check: numericCall("max", 1, -1)},
+	"abs": {check: numericCall("abs", 1, 1)},
+	"if": {check: func(args []node) (node, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("takes 3 arguments, got %d", len(args))
+		}
+		if args[0].typ() != typeBool {
+			return nil, fmt.Errorf("condition must be a boolean, got a %s", args[0].typ())
+		}
+		if args[1].typ() != args[2].typ() {
+			return nil, fmt.Errorf("branches must have the same type, got a %s and a %s", args[1].typ(), args[2].typ())
+		}
+		return &ifNode{cond: args[0], then: args[1], otherwise: args[2]}, nil
+	}},
+	"usage": {check: func(args []node) (node, error) {
+		metric, err := literalArgument(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &usageNode{metric: metric}, nil
+	}},
+	"label": {check: func(args []node) (node, error) {
+		key, err := literalArgument(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &labelNode{key: key, result: typeString}, nil
+	}},
+	"has_label": {check: func(args []node) (node, error) {
+		key, err := literalArgument(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &labelNode{key: key, result: typeBool}, nil
+	}},
+	"label_number": {check: func(args []node) (node, error) {
+		key, err := literalArgument(args, 2)
+		if err != nil {
+			return nil, err
+		}
+		if args[1].typ() != typeNumber {
+			return nil, fmt.Errorf("default must be a number, got a %s", args[1].typ())
+		}
+		return &labelNode{key: key, result: typeNumber, fallback: args[1]}, nil
+	}},
+}
+
+// numericCall checks a call takes between min and max (-1 for any) numbers
+func numericCall(name string, min, max int) func(args []node) (node, error) {
+	return func(args []node) (node, error) {
+		if len(args) < min {
+			return nil, fmt.Errorf("takes at least %d argument(s), got %d", min, len(args))
+		}
+		if max >= 0 && len(args) > max {
+			return nil, fmt.Errorf("takes at most %d argument(s), got %d", max, len(args))
+		}
+		for i, arg := range args {
+			if arg.typ() != typeNumber {
+				return nil, fmt.Errorf("argument %d must be a number, got a %s", i+1, arg.typ())
+			}
+		}
+		return &numericCallNode{name: name, args: args}, nil
+	}
+}
+
+// literalArgument checks a call has count arguments, the first a string literal,
+// and returns it
+func literalArgument(args []node, count int) (string, error) {
+	if len(args) != count {
+		return "", fmt.Errorf("takes %d argument(s), got %d", count, len(args))
+	}
+	literal, ok := args[0].(*stringNode)
+	if !ok {
+		return "", fmt.Errorf("first argument must be a string literal")
+	}
+	return literal.value, nil
+}
@@ -0,0 +1,114 @@
+package formula
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapEnv is an Env backed by maps
+type mapEnv struct {
+	usage  map[string]float64
+	labels map[string]string
+}
+
+func (e mapEnv) Usage(metric string) decimal.Decimal {
+	return decimal.NewFromFloat(e.usage[metric])
+}
+
+func (e mapEnv) Label(key string) (string, bool) {
+	label, ok := e.labels[key]
+	return label, ok
+}
+
+func TestEvaluate(t *testing.T) {
+	env := mapEnv{
+		usage:  map[string]float64{"requests": 1000, "cpu_hours": 20, "builtin:service.requestCount.total": 50},
+		labels: map[string]string{"tier": "gold", "weight": "1.5", "team": "payments"},
+	}
+	constants := map[string]decimal.Decimal{"request_weight": decimal.RequireFromString("0.7")}
+
+	tests := []struct {
+		name    string
+		formula string
+		want    string
+	}{
+		{"weighted metrics", "requests * 0.7 + cpu_hours * 0.3", "706"},
+		{"named constants", "requests * request_weight", "700"},
+		{"precedence and parentheses", "(1 + 2) * 3 - 4 / 2", "7"},
+		{"unary minus", "-cpu_hours + 30", "10"},
+		{"quoted metric names", "usage(\"builtin:service.requestCount.total\") * 2", "100"},
+		{"string labels", "if(label(\"tier\") == \"gold\", 2, 1) * cpu_hours", "40"},
+		{"numeric labels", "label_number(\"weight\", 1) * cpu_hours", "30"},
+		{"missing numeric labels use the default", "label_number(\"missing\", 3)", "3"},
+		{"label presence", "if(has_label(\"team\") && !has_label(\"missing\"), 1, 0)", "1"},
+		{"comparisons", "if(requests >= 1000 || cpu_hours < 1, 5, 0)", "5"},
+		{"min and max", "max(min(requests, 10), abs(-4), 2)", "10"},
+		{"unknown metrics are zero", "unused_metric + 1", "1"},
+		{"short-circuit skips division by zero", "if(cpu_hours > 0, requests / cpu_hours, 1 / 0)", "50"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Parse(tt.formula, constants)
+			require.NoError(t, err)
+
+			got, err := expr.Evaluate(env)
+			require.NoError(t, err)
+			assert.True(t, got.Equal(decimal.RequireFromString(tt.want)), "got %s, want %s", got, tt.want)
+		})
+	}
+
+	t.Run("division by zero is an evaluation error", func(t *testing.T) {
+		expr, err := Parse("requests / unused_metric", nil)
+		require.NoError(t, err)
+		_, err = expr.Evaluate(env)
+		assert.ErrorContains(t, err, "division by zero")
+	})
+}
+
+func TestParse(t *testing.T) {
+	t.Run("records the metrics and labels read", func(t *testing.T) {
+		expr, err := Parse("requests * 0.7 + usage('cpu_hours') * w + label_number('weight', 1)", map[string]decimal.Decimal{"w": decimal.NewFromInt(1)})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"cpu_hours", "requests"}, expr.Metrics())
+		assert.True(t, expr.UsesLabels())
+
+		expr, err = Parse("requests", nil)
+		require.NoError(t, err)
+		assert.False(t, expr.UsesLabels())
+	})
+
+	invalid := []struct {
+		name    string
+		formula string
+		err     string
+	}{
+		{"empty", "  ", "formula is empty"},
+		{"unbalanced parentheses", "(requests + 1", "expected ')'"},
+		{"trailing tokens", "requests cpu_hours", "unexpected \"cpu_hours\""},
+		{"unknown characters", "requests ; 1", "unexpected character"},
+		{"unterminated strings", "label(\"tier) == 1", "unterminated string"},
+		{"unknown functions", "exec(\"rm\")", "unknown function exec"},
+		{"non-numeric results", "requests > 1", "result must be a number"},
+		{"string arithmetic", "label(\"tier\") + 1", "needs numbers"},
+		{"mixed comparisons", "if(label(\"tier\") == 1, 1, 0)", "same type"},
+		{"non-boolean conditions", "if(requests, 1, 0)", "condition must be a boolean"},
+		{"mismatched branches", "if(true, 1, \"a\")", "branches must have the same type"},
+		{"non-literal label keys", "label_number(label(\"k\") , 1)", "string literal"},
+		{"wrong arity", "abs(1, 2)", "at most 1"},
+		{"chained comparisons", "if(1 < 2 < 3, 1, 0)", "cannot be chained"},
+		{"deep nesting", strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40), "nested more than"},
+		{"long formulas", strings.Repeat("1+", maxLength) + "1", "longer than"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.formula, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+}
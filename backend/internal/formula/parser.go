@@ -0,0 +1,305 @@
+package formula
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/shopspring/decimal"
+)
+
+// tokenKind classifies a lexical token
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+// token is a lexical token and the offset it starts at
+type token struct {
+	kind  tokenKind
+	text  string
+	value decimal.Decimal // Set for numbers
+	pos   int
+}
+
+// operators lists every operator, longest first so "<=" is matched before "<"
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "<", ">", "!"}
+
+// lex splits an expression into tokens
+func lex(source string) ([]token, error) {
+	var tokens []token
+	for pos := 0; pos < len(source); {
+		c := rune(source[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: pos})
+			pos++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: pos})
+			pos++
+		case c == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: pos})
+			pos++
+		case c == '"' || c == '\'':
+			end := strings.IndexRune(source[pos+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at offset %d", pos)
+			}
+			text := source[pos+1 : pos+1+end]
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: pos})
+			pos += end + 2
+		case unicode.IsDigit(c) || (c == '.' && pos+1 < len(source) && unicode.IsDigit(rune(source[pos+1]))):
+			start := pos
+			for pos < len(source) && (unicode.IsDigit(rune(source[pos])) || source[pos] == '.') {
+				pos++
+			}
+			value, err := decimal.NewFromString(source[start:pos])
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", source[start:pos], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:pos], value: value, pos: start})
+		case c == '_' || unicode.IsLetter(c):
+			start := pos
+			for pos < len(source) && (source[pos] == '_' || unicode.IsLetter(rune(source[pos])) || unicode.IsDigit(rune(source[pos]))) {
+				pos++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:pos], pos: start})
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(source[pos:], op) {
+					tokens = append(tokens, token{kind: tokenOperator, text: op, pos: pos})
+					pos += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, pos)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(source)}), nil
+}
+
+// parser is a recursive descent parser that type checks as it builds the tree
+type parser struct {
+	tokens    []token
+	pos       int
+	depth     int
+	constants map[string]decimal.Decimal
+	metrics   map[string]bool
+	labels    bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	t := p.next()
+	if t.kind != kind {
+		return fmt.Errorf("expected %s at offset %d, got %s", text, t.pos, describe(t))
+	}
+	return nil
+}
+
+// enter guards against deeply nested expressions
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return fmt.Errorf("expression is nested more than %d levels deep", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func describe(t token) string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return fmt.Sprintf("string %q", t.text)
+	default:
+		return fmt.Sprintf("%q", t.text)
+	}
+}
+
+// binaryLevels lists the binary operators from lowest to highest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "/"},
+}
+
+func (p *parser) parseExpression() (node, error) {
+	return p.parseBinary(0)
+}
+
+func (p *parser) parseBinary(level int) (node, error) {
+	if level == len(binaryLevels) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		if t.kind != tokenOperator || !containsOperator(binaryLevels[level], t.text) {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left, err = newBinary(t, left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		// Comparisons do not chain
+		if level == 2 {
+			if next := p.peek(); next.kind == tokenOperator && containsOperator(binaryLevels[level], next.text) {
+				return nil, fmt.Errorf("comparisons cannot be chained at offset %d", next.pos)
+			}
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	t := p.peek()
+	if t.kind == tokenOperator && (t.text == "-" || t.text == "!") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		want := typeNumber
+		if t.text == "!" {
+			want = typeBool
+		}
+		if operand.typ() != want {
+			return nil, fmt.Errorf("operator %s at offset %d needs a %s, got a %s", t.text, t.pos, want, operand.typ())
+		}
+		return &unaryNode{op: t.text, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		return &numberNode{value: t.value}, nil
+	case tokenString:
+		return &stringNode{value: t.text}, nil
+	case tokenLParen:
+		inner, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return inner, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(t)
+		}
+		switch t.text {
+		case "true":
+			return &boolNode{value: true}, nil
+		case "false":
+			return &boolNode{value: false}, nil
+		}
+		if value, ok := p.constants[t.text]; ok {
+			return &numberNode{value: value}, nil
+		}
+		p.metrics[t.text] = true
+		return &usageNode{metric: t.text}, nil
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", describe(t), t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s at offset %d", name.text, name.pos)
+	}
+	p.next() // (
+
+	var args []node
+	if p.peek().kind != tokenRParen {
+		for {
+			arg, err := p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if err := p.expect(tokenRParen, "')'"); err != nil {
+		return nil, err
+	}
+
+	call, err := fn.check(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s at offset %d: %w", name.text, name.pos, err)
+	}
+
+	// Usage and label references are known when parsing, so the metrics an
+	// expression reads can be loaded up front
+	switch n := call.(type) {
+	case *usageNode:
+		p.metrics[n.metric] = true
+	case *labelNode:
+		p.labels = true
+	}
+	return call, nil
+}
+
+func containsOperator(ops []string, op string) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+// Package fx converts amounts between currencies using dated exchange rates.
+package fx
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+// pair identifies a currency pair
+type pair struct {
+	from string
+	to   string
+}
+
+// datedRate is a pair's rate from a date onwards
+type datedRate struct {
+	date time.Time
+	rate decimal.Decimal
+}
+
+// Rates converts amounts using a set of dated exchange rates. A conversion uses
+// the latest rate on or before the date, as fx_convert does in the database: the
+// pair's own rate, or the inverse of the opposite pair's when only that is known.
+type Rates struct {
+	rates map[pair][]datedRate
+}
+
+// NewRates indexes exchange rates for conversion
+func NewRates(rates []models.FXRate) *Rates {
+	r := &Rates{rates: make(map[pair][]datedRate)}
+	for _, rate := range rates {
+		key := pair{from: NormaliseCurrency(rate.FromCurrency), to: NormaliseCurrency(rate.ToCurrency)}
+		r.rates[key] = append(r.rates[key], datedRate{date: day(rate.RateDate), rate: rate.Rate})
+	}
+	for _, dated := range r.rates {
+		sort.Slice(dated, func(i, j int) bool { return dated[i].date.Before(dated[j].date) })
+	}
+	return r
+}
+
+// Rate returns the rate converting from one currency to another on a date
+func (r *Rates) Rate(from, to string, date time.Time) (decimal.Decimal, error) {
+	from, to = NormaliseCurrency(from), NormaliseCurrency(to)
+	if from == to {
+		return decimal.NewFromInt(1), nil
+	}
+
+	date = day(date)
+	if rate, ok := r.latest(pair{from: from, to: to}, date); ok {
+		return rate, nil
+	}
+	if rate, ok := r.latest(pair{from: to, to: from}, date); ok {
+		return decimal.NewFromInt(1).Div(rate), nil
+	}
+	return decimal.Zero, fmt.Errorf("no FX rate from %s to %s on or before %s", from, to, date.Format("2006-01-02"))
+}
+
+// Convert converts an amount from one currency to another at the rate on a date
+func (r *Rates) Convert(amount decimal.Decimal, from, to string, date time.Time) (decimal.Decimal, error) {
+	if NormaliseCurrency(from) == NormaliseCurrency(to) {
+		return amount, nil
+	}
+
+	rate, err := r.Rate(from, to, date)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return amount.Mul(rate), nil
+}
+
+// latest returns a pair's latest rate on or before a date
+func (r *Rates) latest(key pair, date time.Time) (decimal.Decimal, bool) {
+	dated := r.rates[key]
+	i := sort.Search(len(dated), func(i int) bool { return dated[i].date.After(date) })
+	if i == 0 {
+		return decimal.Zero, false
+	}
+	return dated[i-1].rate, true
+}
+
+// NormaliseCurrency returns a currency code in the upper case ISO 4217 form
+func NormaliseCurrency(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// ValidateCurrency checks a currency code is three letters
+func ValidateCurrency(code string) error {
+	code = NormaliseCurrency(code)
+	if len(code) != 3 {
+		return fmt.Errorf("invalid currency code %q", code)
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return fmt.Errorf("invalid currency code %q", code)
+		}
+	}
+	return nil
+}
+
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,61 @@
+package fx
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestRates(t *testing.T) {
+	rates := NewRates([]models.FXRate{
+		{RateDate: date("2024-01-02"), FromCurrency: "EUR", ToCurrency: "USD", Rate: decimal.RequireFromString("1.10")},
+		{RateDate: date("2024-01-05"), FromCurrency: "EUR", ToCurrency: "USD", Rate: decimal.RequireFromString("1.20")},
+		{RateDate: date("2024-01-03"), FromCurrency: "USD", ToCurrency: "GBP", Rate: decimal.RequireFromString("0.80")},
+	})
+
+	tests := []struct {
+		name string
+		from string
+		to   string
+		date string
+		want string
+	}{
+		{"the rate on the date", "EUR", "USD", "2024-01-02", "110"},
+		{"the latest rate before the date", "EUR", "USD", "2024-01-04", "110"},
+		{"a newer rate replaces it", "EUR", "USD", "2024-01-10", "120"},
+		{"the inverse of the opposite pair", "GBP", "USD", "2024-01-03", "125"},
+		{"the same currency", "usd", "USD", "2023-01-01", "100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rates.Convert(decimal.NewFromInt(100), tt.from, tt.to, date(tt.date))
+			require.NoError(t, err)
+			assert.True(t, got.Equal(decimal.RequireFromString(tt.want)), "got %s, want %s", got, tt.want)
+		})
+	}
+
+	t.Run("no rate before the date", func(t *testing.T) {
+		_, err := rates.Convert(decimal.NewFromInt(100), "EUR", "USD", date("2024-01-01"))
+		assert.ErrorContains(t, err, "no FX rate from EUR to USD on or before 2024-01-01")
+	})
+
+	t.Run("rates are not chained through other currencies", func(t *testing.T) {
+		_, err := rates.Convert(decimal.NewFromInt(100), "EUR", "GBP", date("2024-01-10"))
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateCurrency(t *testing.T) {
+	assert.NoError(t, ValidateCurrency("eur"))
+	assert.Error(t, ValidateCurrency("EURO"))
+	assert.Error(t, ValidateCurrency("U$D"))
+}
@@ -52,7 +52,20 @@ func (gb *GraphBuilder) BuildForDate(ctx context.Context, date time.Time) (*Grap
 		return nil, fmt.Errorf("failed to get edges: %w", err)
 	}
 
-	// Build the graph
+	g := NewGraph(date, nodes, edges)
+
+	log.Info().
+		Int("nodes", len(g.nodes)).
+		Int("edges", len(edges)).
+		Str("hash", g.hash).
+		Msg("Graph built successfully")
+
+	return g, nil
+}
+
+// NewGraph builds a graph from already-loaded nodes and edges. Edges that
+// reference nodes outside the supplied set are dropped with a warning.
+func NewGraph(date time.Time, nodes []models.CostNode, edges []models.DependencyEdge) *Graph {
 	g := &Graph{
 		nodes:    make(map[uuid.UUID]*models.CostNode),
 		edges:    make(map[uuid.UUID][]models.DependencyEdge),
@@ -90,13 +103,7 @@ func (gb *GraphBuilder) BuildForDate(ctx context.Context, date time.Time) (*Grap
 	// Calculate graph hash
 	g.hash = g.calculateHash()
 
-	log.Info().
-		Int("nodes", len(g.nodes)).
-		Int("edges", len(edges)).
-		Str("hash", g.hash).
-		Msg("Graph built successfully")
-
-	return g, nil
+	return g
 }
 
 // Nodes returns all nodes in the graph
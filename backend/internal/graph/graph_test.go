@@ -207,6 +207,70 @@ func TestGraph_Statistics(t *testing.T) {
 
 // Helper functions for tests
 
+func TestValidator_EdgeStrategies(t *testing.T) {
+	shared := &models.CostNode{ID: uuid.New(), Name: "shared", Type: string(models.NodeTypeShared)}
+	app := &models.CostNode{ID: uuid.New(), Name: "app", Type: string(models.NodeTypeProduct)}
+	web := &models.CostNode{ID: uuid.New(), Name: "web", Type: string(models.NodeTypeProduct)}
+	nodes := map[uuid.UUID]*models.CostNode{shared.ID: shared, app.ID: app, web.ID: web}
+
+	t.Run("registered strategies with valid parameters pass", func(t *testing.T) {
+		edges := []models.DependencyEdge{
+			{ID: uuid.New(), ParentID: shared.ID, ChildID: app.ID, DefaultStrategy: string(models.StrategyEqual)},
+			{ID: uuid.New(), ParentID: shared.ID, ChildID: web.ID, DefaultStrategy: string(models.StrategyProportionalOn), DefaultParameters: map[string]interface{}{"metric": "cpu"}},
+		}
+
+		result := &ValidationResult{Valid: true}
+		checkEdgeStrategies(createGraphFromEdges(nodes, edges), nil, result)
+		assert.True(t, result.Valid)
+		assert.Empty(t, result.Errors)
+		assert.Empty(t, result.Warnings)
+	})
+
+	t.Run("unknown strategies and bad parameters are errors", func(t *testing.T) {
+		cpu := "cpu"
+		edges := []models.DependencyEdge{
+			{ID: uuid.New(), ParentID: shared.ID, ChildID: app.ID, DefaultStrategy: "round_robin"},
+			{ID: uuid.New(), ParentID: shared.ID, ChildID: web.ID, DefaultStrategy: string(models.StrategyEqual)},
+		}
+		overrides := map[uuid.UUID][]models.EdgeStrategy{
+			edges[1].ID: {{EdgeID: edges[1].ID, Dimension: &cpu, Strategy: string(models.StrategyFixedPercent), Parameters: map[string]interface{}{"percent": 150.0, "precent": 15.0}}},
+		}
+
+		result := &ValidationResult{Valid: true}
+		checkEdgeStrategies(createGraphFromEdges(nodes, edges), overrides, result)
+		assert.False(t, result.Valid)
+
+		errorTypes := make(map[string]ValidationError)
+		for _, e := range result.Errors {
+			errorTypes[e.Type] = e
+		}
+		assert.Len(t, result.Errors, 2)
+		assert.Equal(t, "round_robin", errorTypes["unknown_strategy"].Details["strategy"])
+		assert.Equal(t, "cpu", errorTypes["invalid_strategy_parameters"].Details["dimension"])
+		assert.Contains(t, errorTypes["invalid_strategy_parameters"].Details["error"], "'percent' must be at most 100")
+
+		assert.Len(t, result.Warnings, 1)
+		assert.Equal(t, "unknown_strategy_parameters", result.Warnings[0].Type)
+		assert.Equal(t, []string{"precent"}, result.Warnings[0].Details["parameters"])
+		assert.NotContains(t, result.Warnings[0].Details, "error")
+	})
+
+	t.Run("formulas are parsed", func(t *testing.T) {
+		edges := []models.DependencyEdge{
+			{ID: uuid.New(), ParentID: shared.ID, ChildID: app.ID, DefaultStrategy: string(models.StrategyFormula), DefaultParameters: map[string]interface{}{"expression": "requests * 0.7 + cpu_hours * 0.3"}},
+			{ID: uuid.New(), ParentID: shared.ID, ChildID: web.ID, DefaultStrategy: string(models.StrategyFormula), DefaultParameters: map[string]interface{}{"expression": "requests * (0.7"}},
+		}
+
+		result := &ValidationResult{Valid: true}
+		checkEdgeStrategies(createGraphFromEdges(nodes, edges), nil, result)
+		assert.False(t, result.Valid)
+		if assert.Len(t, result.Errors, 1) {
+			assert.Equal(t, edges[1].ID, *result.Errors[0].EdgeID)
+			assert.Contains(t, result.Errors[0].Details["error"], "invalid formula")
+		}
+	})
+}
+
 func createGraphFromEdges(nodes map[uuid.UUID]*models.CostNode, edges []models.DependencyEdge) *Graph {
 	g := &Graph{
 		nodes:    nodes,
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
 	"github.com/pickeringtech/FinOpsAggregator/internal/store"
 	"github.com/rs/zerolog/log"
 )
@@ -80,6 +81,7 @@ func (v *Validator) ValidateForDate(ctx context.Context, date time.Time) (*Valid
 	v.validateNodeReferences(ctx, graph, result)
 	v.validateEdgeConsistency(ctx, graph, result)
 	v.validateEdgeDirection(graph, result)
+	v.validateEdgeStrategies(ctx, graph, result)
 	v.validateIsolatedNodes(graph, result)
 	v.validatePlatformNodes(graph, result)
 
@@ -194,6 +196,91 @@ func (v *Validator) validateEdgeConsistency(ctx context.Context, graph *Graph, r
 	}
 }
 
+// validateEdgeStrategies validates every edge's default strategy and its dimension
+// overrides against the strategy registry
+func (v *Validator) validateEdgeStrategies(ctx context.Context, graph *Graph, result *ValidationResult) {
+	var edgeIDs []uuid.UUID
+	for _, edges := range graph.edges {
+		for _, edge := range edges {
+			edgeIDs = append(edgeIDs, edge.ID)
+		}
+	}
+
+	overrides, err := v.store.Edges.GetStrategiesForEdges(ctx, edgeIDs)
+	if err != nil {
+		result.Errors = append(result.Errors, ValidationError{
+			Type:    "edge_strategy_query_error",
+			Message: fmt.Sprintf("Failed to query edge strategies: %v", err),
+		})
+		return
+	}
+
+	checkEdgeStrategies(graph, overrides, result)
+}
+
+// checkEdgeStrategies reports strategies that are not registered or whose
+// parameters do not match the strategy's parameter spec. Parameters the spec does
+// not describe are only a warning.
+func checkEdgeStrategies(graph *Graph, overrides map[uuid.UUID][]models.EdgeStrategy, result *ValidationResult) {
+	check := func(edge models.DependencyEdge, dimension *string, strategy string, params map[string]interface{}) {
+		details := func() map[string]interface{} {
+			d := map[string]interface{}{
+				"parent_id": edge.ParentID.String(),
+				"child_id":  edge.ChildID.String(),
+				"strategy":  strategy,
+			}
+			if dimension != nil {
+				d["dimension"] = *dimension
+			}
+			return d
+		}
+
+		spec, ok := models.LookupStrategySpec(strategy)
+		if !ok {
+			result.Valid = false
+			result.Errors = append(result.Errors, ValidationError{
+				Type:    "unknown_strategy",
+				Message: fmt.Sprintf("Edge uses unregistered allocation strategy %q", strategy),
+				EdgeID:  &edge.ID,
+				Details: details(),
+			})
+			return
+		}
+
+		if err := spec.ValidateParameters(params); err != nil {
+			d := details()
+			d["error"] = err.Error()
+			result.Valid = false
+			result.Errors = append(result.Errors, ValidationError{
+				Type:    "invalid_strategy_parameters",
+				Message: "Edge strategy parameters do not match the strategy's parameter spec",
+				EdgeID:  &edge.ID,
+				Details: d,
+			})
+		}
+
+		if unknown := spec.UnknownParameters(params); len(unknown) > 0 {
+			d := details()
+			d["parameters"] = unknown
+			result.Warnings = append(result.Warnings, ValidationWarning{
+				Type:    "unknown_strategy_parameters",
+				Message: "Edge strategy has parameters its strategy does not use",
+				EdgeID:  &edge.ID,
+				Details: d,
+			})
+		}
+	}
+
+	for _, edges := range graph.edges {
+		for _, edge := range edges {
+			check(edge, nil, edge.DefaultStrategy, edge.DefaultParameters)
+			for _, override := range overrides[edge.ID] {
+				check(edge, override.Dimension, override.Strategy, override.Parameters)
+			}
+		}
+	}
+}
+
 // validateIsolatedNodes identifies nodes with no connections
 func (v *Validator) validateIsolatedNodes(graph *Graph, result *ValidationResult) {
 	for nodeID, node := range graph.nodes {
@@ -2,27 +2,33 @@ package ingestion
 
 import (
 	"context"
-	"encoding/csv"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
 	"github.com/pickeringtech/FinOpsAggregator/internal/models"
 	"github.com/pickeringtech/FinOpsAggregator/internal/store"
 	"github.com/rs/zerolog/log"
 	"github.com/shopspring/decimal"
 )
 
-// AWSCURIngester handles ingestion of AWS Cost and Usage Report CSV files
+// AWSCURIngester handles ingestion of AWS Cost and Usage Reports, legacy CUR
+// and CUR 2.0 / Data Exports alike
 type AWSCURIngester struct {
 	store           *store.Store
 	columnMappings  map[string]string
 	progressChan    chan IngestionProgress
 	batchSize       int
 	createMissingNodes bool
+	costBasis       CostBasis
+	rules           *MappingRuleSet
 }
 
 // IngestionProgress reports progress during ingestion
@@ -43,8 +49,77 @@ type AWSCURConfig struct {
 	BatchSize          int
 	CreateMissingNodes bool
 	ProgressChan       chan IngestionProgress
+	// CostBasis selects which cost columns are recorded (default unblended)
+	CostBasis CostBasis
+	// MappingRules map line items to nodes and dimensions (default
+	// DefaultCURMappingRules)
+	MappingRules *MappingRuleSet
 }
 
+// CostBasis selects how commitment discounts (Savings Plans and Reserved
+// Instances) are reflected in the cost recorded for each line item
+type CostBasis string
+
+const (
+	// CostBasisUnblended records what was billed on the day: on-demand rates for
+	// covered usage, with upfront and recurring commitment fees as they are charged
+	CostBasisUnblended CostBasis = "unblended"
+	// CostBasisAmortised spreads commitment fees over the usage they cover, so
+	// covered usage carries its effective cost and unused commitment is recorded
+	// against the fee line items
+	CostBasisAmortised CostBasis = "amortised"
+	// CostBasisNetAmortised is the amortised cost after discounts such as EDP
+	CostBasisNetAmortised CostBasis = "net_amortised"
+)
+
+// ParseCostBasis parses a cost basis name, defaulting to unblended when empty
+func ParseCostBasis(name string) (CostBasis, error) {
+	switch basis := CostBasis(strings.ToLower(strings.TrimSpace(name))); basis {
+	case "":
+		return CostBasisUnblended, nil
+	case CostBasisUnblended, CostBasisAmortised, CostBasisNetAmortised:
+		return basis, nil
+	default:
+		return "", fmt.Errorf("unknown cost basis %q (expected unblended, amortised or net_amortised)", name)
+	}
+}
+
+// Dimension returns the dimension cost of a usage dimension is recorded under.
+// Unblended cost keeps the usage dimension; other bases are suffixed with the
+// basis, so the same report can be ingested under several bases side by side.
+func (b CostBasis) Dimension(dimension string) string {
+	if b == "" || b == CostBasisUnblended {
+		return dimension
+	}
+	return dimension + "_" + string(b)
+}
+
+// batchSource returns the source a basis's ingestion batches are recorded
+// under, so importing a period under one basis does not replace the costs
+// recorded under another. Unblended batches keep the plain source, as their
+// dimensions keep the usage dimension.
+func (b CostBasis) batchSource() string {
+	if b == "" || b == CostBasisUnblended {
+		return "aws_cur"
+	}
+	return "aws_cur:" + string(b)
+}
+
+// AWS CUR line item types
+const (
+	LineItemTypeUsage                   = "Usage"
+	LineItemTypeSavingsPlanCoveredUsage = "SavingsPlanCoveredUsage"
+	LineItemTypeSavingsPlanNegation     = "SavingsPlanNegation"
+	LineItemTypeSavingsPlanUpfrontFee   = "SavingsPlanUpfrontFee"
+	LineItemTypeSavingsPlanRecurringFee = "SavingsPlanRecurringFee"
+	LineItemTypeDiscountedUsage         = "DiscountedUsage"
+	LineItemTypeRIFee                   = "RIFee"
+	LineItemTypeFee                     = "Fee"
+	LineItemTypeCredit                  = "Credit"
+	LineItemTypeRefund                  = "Refund"
+	LineItemTypeTax                     = "Tax"
+)
+
 // Standard AWS CUR column names
 const (
 	ColLineItemUsageStartDate    = "lineItem/UsageStartDate"
@@ -56,14 +131,46 @@ const (
 	ColLineItemUnblendedCost     = "lineItem/UnblendedCost"
 	ColLineItemBlendedCost       = "lineItem/BlendedCost"
 	ColLineItemUsageAmount       = "lineItem/UsageAmount"
+	ColLineItemLineItemType      = "lineItem/LineItemType"
+	ColLineItemNetUnblendedCost  = "lineItem/NetUnblendedCost"
+	ColLineItemCurrencyCode      = "lineItem/CurrencyCode"
+	ColLineItemUsageAccountId    = "lineItem/UsageAccountId"
 	ColProductProductName        = "product/ProductName"
 	ColProductRegion             = "product/region"
 	ColResourceTagsUserName      = "resourceTags/user:Name"
 	ColResourceTagsUserProduct   = "resourceTags/user:Product"
 	ColResourceTagsUserService   = "resourceTags/user:Service"
 	ColResourceTagsUserCostCenter = "resourceTags/user:CostCenter"
+
+	ColSavingsPlanARN                           = "savingsPlan/SavingsPlanARN"
+	ColSavingsPlanEffectiveCost                 = "savingsPlan/SavingsPlanEffectiveCost"
+	ColSavingsPlanNetEffectiveCost              = "savingsPlan/NetSavingsPlanEffectiveCost"
+	ColSavingsPlanTotalCommitmentToDate         = "savingsPlan/TotalCommitmentToDate"
+	ColSavingsPlanUsedCommitment                = "savingsPlan/UsedCommitment"
+	ColSavingsPlanNetAmortizedUpfrontCommitment = "savingsPlan/NetAmortizedUpfrontCommitmentForBillingPeriod"
+	ColSavingsPlanNetRecurringCommitment        = "savingsPlan/NetRecurringCommitmentForBillingPeriod"
+
+	ColReservationARN                          = "reservation/ReservationARN"
+	ColReservationEffectiveCost                = "reservation/EffectiveCost"
+	ColReservationNetEffectiveCost             = "reservation/NetEffectiveCost"
+	ColReservationUnusedAmortizedUpfrontFee    = "reservation/UnusedAmortizedUpfrontFeeForBillingPeriod"
+	ColReservationNetUnusedAmortizedUpfrontFee = "reservation/NetUnusedAmortizedUpfrontFeeForBillingPeriod"
+	ColReservationUnusedRecurringFee           = "reservation/UnusedRecurringFee"
+	ColReservationNetUnusedRecurringFee        = "reservation/NetUnusedRecurringFee"
+
+	ColBillBillingPeriodStartDate = "bill/BillingPeriodStartDate"
+	ColBillBillingPeriodEndDate   = "bill/BillingPeriodEndDate"
 )
 
+// defaultCURRules are the compiled DefaultCURMappingRules
+var defaultCURRules = func() *MappingRuleSet {
+	rules, err := NewMappingRuleSet(DefaultCURMappingRules())
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}()
+
 // NewAWSCURIngester creates a new AWS CUR ingester
 func NewAWSCURIngester(store *store.Store, config *AWSCURConfig) *AWSCURIngester {
 	batchSize := 1000
@@ -81,11 +188,23 @@ func NewAWSCURIngester(store *store.Store, config *AWSCURConfig) *AWSCURIngester
 		progressChan = config.ProgressChan
 	}
 
+	costBasis := CostBasisUnblended
+	if config != nil && config.CostBasis != "" {
+		costBasis = config.CostBasis
+	}
+
+	rules := defaultCURRules
+	if config != nil && config.MappingRules != nil {
+		rules = config.MappingRules
+	}
+
 	return &AWSCURIngester{
 		store:              store,
 		batchSize:          batchSize,
 		createMissingNodes: createMissingNodes,
 		progressChan:       progressChan,
+		costBasis:          costBasis,
+		rules:              rules,
 		columnMappings: map[string]string{
 			// Map AWS CUR columns to internal field names
 			ColLineItemUsageStartDate:    "usage_start_date",
@@ -100,7 +219,7 @@ func NewAWSCURIngester(store *store.Store, config *AWSCURConfig) *AWSCURIngester
 	}
 }
 
-// IngestFile ingests an AWS CUR CSV file
+// IngestFile ingests an AWS CUR report file
 func (a *AWSCURIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -122,97 +241,166 @@ func (a *AWSCURIngester) IngestFile(ctx context.Context, filePath string) (*Inge
 	return a.IngestReader(ctx, file, filePath)
 }
 
-// IngestReader ingests AWS CUR data from a reader
+// IngestReader ingests AWS CUR data from a reader. CSV and Parquet reports are
+// accepted, either of them gzip compressed or in a zip archive.
+//
+// The report is taken to be the whole of its billing period, which is read from
+// its billing period columns, or is the calendar month of its line items when it
+// has none. Its costs are recorded as an ingestion batch that replaces the
+// period's previous batch, so line items removed by a restated report are
+// removed here too, and importing the same report again does nothing.
 func (a *AWSCURIngester) IngestReader(ctx context.Context, reader io.Reader, sourceName string) (*IngestionResult, error) {
 	result := &IngestionResult{
 		Source:    "aws_cur",
 		StartTime: time.Now(),
 	}
 
-	csvReader := csv.NewReader(reader)
-	csvReader.LazyQuotes = true
-	csvReader.TrimLeadingSpace = true
+	resolver := newNodeResolver(a.store, "aws_cur", a.createMissingNodes)
+
+	// Line items are summed per node, day and dimension: a report has many line
+	// items for each, and credits, refunds and Savings Plan negations are
+	// negative amounts that net against the cost they apply to
+	totals := newCostTotals()
+	periods := make(curPeriods)
+
+	hash := sha256.New()
+	content := io.TeeReader(reader, hash)
+	if err := a.readReport(ctx, content, "", resolver, totals, periods, result); err != nil {
+		return nil, err
+	}
+	// Readers may stop short of the end of a file, e.g. at the end of a gzip stream
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+
+	start, end, err := periods.period()
+	if err != nil {
+		return nil, err
+	}
 
-	// Read header row
-	headers, err := csvReader.Read()
+	batch := a.newBatch(start, end, []string{hex.EncodeToString(hash.Sum(nil))})
+	err = a.store.WithTx(ctx, func(tx *store.Store) error {
+		return a.storeBatch(ctx, tx, batch, totals, result)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+		return nil, fmt.Errorf("failed to store billing period: %w", err)
+	}
+
+	if result.AlreadyIngested {
+		log.Info().
+			Str("source", sourceName).
+			Str("checksum", batch.Checksum).
+			Msg("CUR report already ingested, nothing to do")
 	}
 
-	// Build column index map
-	colIndex := make(map[string]int)
-	for i, header := range headers {
-		colIndex[header] = i
+	a.complete(result, sourceName)
+	return result, nil
+}
+
+// curPeriods collects the billing periods of a report's line items, as the
+// start of each as written mapped to its end
+type curPeriods map[string]string
+
+// add records the billing period of a line item: its billing period columns, or
+// the calendar month it was used in when the report has none
+func (p curPeriods) add(fields *curLineItemFields, usageDate time.Time) {
+	if start := fields.Column(ColBillBillingPeriodStartDate); start != "" {
+		p[start] = fields.Column(ColBillBillingPeriodEndDate)
+		return
+	}
+	if usageDate.IsZero() {
+		return
 	}
+	month := time.Date(usageDate.Year(), usageDate.Month(), 1, 0, 0, 0, 0, time.UTC)
+	p[month.Format("2006-01-02")] = month.AddDate(0, 1, 0).Format("2006-01-02")
+}
 
-	// Validate required columns exist
-	requiredCols := []string{ColLineItemUsageStartDate, ColLineItemUnblendedCost}
-	for _, col := range requiredCols {
-		if _, ok := colIndex[col]; !ok {
-			return nil, fmt.Errorf("required column %s not found in CSV", col)
+// period returns the billing period of a report, which must have exactly one
+func (p curPeriods) period() (time.Time, time.Time, error) {
+	if len(p) != 1 {
+		if len(p) == 0 {
+			return time.Time{}, time.Time{}, errors.New("report has no line items to take a billing period from")
+		}
+		starts := make([]string, 0, len(p))
+		for start := range p {
+			starts = append(starts, start)
 		}
+		sort.Strings(starts)
+		return time.Time{}, time.Time{}, fmt.Errorf("report covers %d billing periods (%s); import each period's report separately", len(p), strings.Join(starts, ", "))
 	}
 
-	// Cache for node lookups
-	nodeCache := make(map[string]*models.CostNode)
+	var rawStart, rawEnd string
+	for rawStart, rawEnd = range p {
+	}
+	start, err := parseCURPeriodTime(rawStart)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid billing period start: %w", err)
+	}
+	end, err := parseCURPeriodTime(rawEnd)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid billing period end: %w", err)
+	}
+	return start, end, nil
+}
 
-	// Batch for bulk insert
-	var costBatch []models.NodeCostByDimension
-	recordNum := 0
+// readReport reads a report's line items into totals, and their billing periods
+// into periods when it is not nil. Errors for rows that are skipped are
+// prefixed with errorPrefix.
+func (a *AWSCURIngester) readReport(ctx context.Context, reader io.Reader, errorPrefix string, resolver *nodeResolver, totals *costTotals, periods curPeriods, result *IngestionResult) error {
+	records, err := openCURRecords(reader, a.rules.Columns()...)
+	if err != nil {
+		return err
+	}
 
+	recordNum := 0
 	for {
-		record, err := csvReader.Read()
+		record, columns, err := records.Read()
 		if err == io.EOF {
-			break
+			return nil
 		}
-		if err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum+1, err))
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			result.Errors = append(result.Errors, fmt.Sprintf("%srow %d: %v", errorPrefix, recordNum+1, err))
 			result.RecordsSkipped++
 			continue
 		}
+		if err != nil {
+			return fmt.Errorf("failed to read report: %w", err)
+		}
 
 		recordNum++
 		result.RecordsProcessed++
 
 		// Parse the record
-		cost, err := a.parseRecord(ctx, record, colIndex, nodeCache)
+		fields := columns.fields(record)
+		if periods != nil {
+			usageDate, _ := a.parseDate(fields.Column(ColLineItemUsageStartDate))
+			periods.add(fields, usageDate)
+		}
+		mapping, err := a.parseRecord(ctx, fields, resolver)
 		if err != nil {
-			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum, err))
+			result.Errors = append(result.Errors, fmt.Sprintf("%srow %d: %v", errorPrefix, recordNum, err))
 			result.RecordsSkipped++
 			continue
 		}
 
-		if cost != nil {
-			costBatch = append(costBatch, *cost)
+		if mapping.cost != nil {
+			totals.add(*mapping.cost)
 		}
 
-		// Batch insert when batch is full
-		if len(costBatch) >= a.batchSize {
-			if err := a.store.Costs.BulkUpsert(ctx, costBatch); err != nil {
-				return nil, fmt.Errorf("failed to bulk insert costs: %w", err)
-			}
-			result.RecordsInserted += len(costBatch)
-			
+		if recordNum%a.batchSize == 0 {
 			a.reportProgress(IngestionProgress{
 				RecordsProcessed: result.RecordsProcessed,
-				RecordsInserted:  result.RecordsInserted,
 				RecordsSkipped:   result.RecordsSkipped,
 				CurrentRecord:    recordNum,
-				Message:          fmt.Sprintf("Processed %d records, inserted %d", result.RecordsProcessed, result.RecordsInserted),
+				Message:          fmt.Sprintf("Processed %d records", result.RecordsProcessed),
 			})
-			
-			costBatch = costBatch[:0]
-		}
-	}
-
-	// Insert remaining records
-	if len(costBatch) > 0 {
-		if err := a.store.Costs.BulkUpsert(ctx, costBatch); err != nil {
-			return nil, fmt.Errorf("failed to bulk insert final costs: %w", err)
 		}
-		result.RecordsInserted += len(costBatch)
 	}
+}
 
+// complete records the end of an ingestion and reports it
+func (a *AWSCURIngester) complete(result *IngestionResult, sourceName string) {
 	result.EndTime = time.Now()
 	result.Duration = result.EndTime.Sub(result.StartTime)
 
@@ -224,17 +412,29 @@ func (a *AWSCURIngester) IngestReader(ctx context.Context, reader io.Reader, sou
 	})
 
 	log.Info().
+		Str("source", sourceName).
 		Int("processed", result.RecordsProcessed).
 		Int("inserted", result.RecordsInserted).
 		Int("skipped", result.RecordsSkipped).
+		Str("cost_basis", string(a.costBasis)).
 		Dur("duration", result.Duration).
 		Msg("AWS CUR ingestion completed")
+}
 
-	return result, nil
+// lineItemMapping is how a line item's cost is recorded
+type lineItemMapping struct {
+	// cost is nil when the line item is not recorded, for the reason skipped gives
+	cost    *models.NodeCostByDimension
+	skipped string
+	// rule is the mapping rule that applied, if any
+	rule string
+	node *models.CostNode
 }
 
-// parseRecord parses a single CSV record into a NodeCostByDimension
-func (a *AWSCURIngester) parseRecord(ctx context.Context, record []string, colIndex map[string]int, nodeCache map[string]*models.CostNode) (*models.NodeCostByDimension, error) {
+// parseRecord parses a single report record into a NodeCostByDimension
+func (a *AWSCURIngester) parseRecord(ctx context.Context, fields *curLineItemFields, resolver *nodeResolver) (*lineItemMapping, error) {
+	record, colIndex := fields.record, fields.colIndex
+
 	// Get usage start date
 	usageStartDateStr := a.getColumn(record, colIndex, ColLineItemUsageStartDate)
 	if usageStartDateStr == "" {
@@ -246,47 +446,143 @@ func (a *AWSCURIngester) parseRecord(ctx context.Context, record []string, colIn
 		return nil, fmt.Errorf("invalid usage start date: %w", err)
 	}
 
-	// Get cost amount
-	costStr := a.getColumn(record, colIndex, ColLineItemUnblendedCost)
-	if costStr == "" {
-		return nil, nil // Skip records with no cost
-	}
-
-	cost, err := decimal.NewFromString(costStr)
+	// Get cost amount under the cost basis
+	cost, err := a.lineItemCost(record, colIndex)
 	if err != nil {
-		return nil, fmt.Errorf("invalid cost value: %w", err)
+		return nil, err
 	}
 
 	// Skip zero-cost records
 	if cost.IsZero() {
-		return nil, nil
+		return &lineItemMapping{skipped: "zero cost"}, nil
 	}
 
 	// Determine the node to associate this cost with
-	node, err := a.resolveNode(ctx, record, colIndex, nodeCache)
+	mapped, err := a.rules.resolve(ctx, fields, resolver)
 	if err != nil {
 		return nil, fmt.Errorf("failed to resolve node: %w", err)
 	}
-	if node == nil {
-		return nil, nil // Skip if no node found and not creating missing nodes
+	if mapped == nil {
+		return &lineItemMapping{skipped: "no rule matched"}, nil // Skip if no rule maps the line item to a node
+	}
+
+	// Build dimension from the rule, or else usage type or product code
+	dimension := mapped.dimension
+	if dimension == "" {
+		dimension = a.buildDimension(record, colIndex)
 	}
+	dimension = a.costBasis.Dimension(dimension)
 
-	// Build dimension from usage type or product code
-	dimension := a.buildDimension(record, colIndex)
+	// Reports without a currency column are billed in USD
+	currency := fx.NormaliseCurrency(a.getColumn(record, colIndex, ColLineItemCurrencyCode))
+	if currency == "" {
+		currency = "USD"
+	}
 
 	// Build metadata
 	metadata := a.buildMetadata(record, colIndex)
 
-	return &models.NodeCostByDimension{
-		NodeID:    node.ID,
-		CostDate:  usageDate,
-		Dimension: dimension,
-		Amount:    cost,
-		Currency:  "USD",
-		Metadata:  metadata,
+	return &lineItemMapping{
+		cost: &models.NodeCostByDimension{
+			NodeID:    mapped.node.ID,
+			CostDate:  usageDate,
+			Dimension: dimension,
+			Amount:    cost,
+			Currency:  currency,
+			Metadata:  metadata,
+		},
+		rule: mapped.rule,
+		node: mapped.node,
 	}, nil
 }
 
+// lineItemCost returns a line item's cost under the ingester's cost basis.
+// Unblended cost is lineItem/UnblendedCost for every line item. Amortised cost
+// follows the line item type:
+//   - SavingsPlanCoveredUsage and DiscountedUsage carry their effective cost,
+//     which includes their share of the commitment's fees
+//   - SavingsPlanRecurringFee and RIFee carry only the commitment left unused
+//   - SavingsPlanNegation, SavingsPlanUpfrontFee and reservation upfront fees
+//     carry nothing, as they are already spread over the covered usage
+//   - Usage, Credit, Refund, Tax and other fees carry their unblended cost
+//
+// Net amortised cost reads the net variant of each column where the report has it.
+// The report has no net used commitment, so a SavingsPlanRecurringFee's unused
+// commitment is discounted by the ratio of its net commitment to its total.
+func (a *AWSCURIngester) lineItemCost(record []string, colIndex map[string]int) (decimal.Decimal, error) {
+	if a.costBasis == CostBasisUnblended {
+		return a.decimalColumn(record, colIndex, ColLineItemUnblendedCost)
+	}
+
+	column := func(gross, net string) string {
+		if _, ok := colIndex[net]; ok && a.costBasis == CostBasisNetAmortised {
+			return net
+		}
+		return gross
+	}
+
+	switch a.getColumn(record, colIndex, ColLineItemLineItemType) {
+	case LineItemTypeSavingsPlanCoveredUsage:
+		return a.decimalColumn(record, colIndex, column(ColSavingsPlanEffectiveCost, ColSavingsPlanNetEffectiveCost))
+	case LineItemTypeDiscountedUsage:
+		return a.decimalColumn(record, colIndex, column(ColReservationEffectiveCost, ColReservationNetEffectiveCost))
+	case LineItemTypeSavingsPlanRecurringFee:
+		total, err := a.decimalColumn(record, colIndex, ColSavingsPlanTotalCommitmentToDate)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		used, err := a.decimalColumn(record, colIndex, ColSavingsPlanUsedCommitment)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		unused := total.Sub(used)
+		if _, ok := colIndex[ColSavingsPlanNetRecurringCommitment]; !ok || a.costBasis != CostBasisNetAmortised || total.IsZero() {
+			return unused, nil
+		}
+		upfront, err := a.decimalColumn(record, colIndex, ColSavingsPlanNetAmortizedUpfrontCommitment)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		recurring, err := a.decimalColumn(record, colIndex, ColSavingsPlanNetRecurringCommitment)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return unused.Mul(upfront.Add(recurring)).Div(total), nil
+	case LineItemTypeRIFee:
+		upfront, err := a.decimalColumn(record, colIndex, column(ColReservationUnusedAmortizedUpfrontFee, ColReservationNetUnusedAmortizedUpfrontFee))
+		if err != nil {
+			return decimal.Zero, err
+		}
+		recurring, err := a.decimalColumn(record, colIndex, column(ColReservationUnusedRecurringFee, ColReservationNetUnusedRecurringFee))
+		if err != nil {
+			return decimal.Zero, err
+		}
+		return upfront.Add(recurring), nil
+	case LineItemTypeSavingsPlanNegation, LineItemTypeSavingsPlanUpfrontFee:
+		return decimal.Zero, nil
+	case LineItemTypeFee:
+		if a.getColumn(record, colIndex, ColReservationARN) != "" {
+			return decimal.Zero, nil
+		}
+	}
+
+	return a.decimalColumn(record, colIndex, column(ColLineItemUnblendedCost, ColLineItemNetUnblendedCost))
+}
+
+// decimalColumn parses a numeric column, treating an empty or missing value as zero
+func (a *AWSCURIngester) decimalColumn(record []string, colIndex map[string]int, colName string) (decimal.Decimal, error) {
+	value := a.getColumn(record, colIndex, colName)
+	if value == "" {
+		return decimal.Zero, nil
+	}
+
+	amount, err := decimal.NewFromString(value)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid %s value: %w", colName, err)
+	}
+	return amount, nil
+}
+
 // getColumn safely gets a column value from a record
 func (a *AWSCURIngester) getColumn(record []string, colIndex map[string]int, colName string) string {
 	if idx, ok := colIndex[colName]; ok && idx < len(record) {
@@ -299,6 +595,7 @@ func (a *AWSCURIngester) getColumn(record []string, colIndex map[string]int, col
 func (a *AWSCURIngester) parseDate(dateStr string) (time.Time, error) {
 	formats := []string{
 		"2006-01-02T15:04:05Z",
+		time.RFC3339Nano,
 		"2006-01-02T15:04:05",
 		"2006-01-02 15:04:05",
 		"2006-01-02",
@@ -314,87 +611,6 @@ func (a *AWSCURIngester) parseDate(dateStr string) (time.Time, error) {
 	return time.Time{}, fmt.Errorf("unable to parse date: %s", dateStr)
 }
 
-// resolveNode finds or creates the node to associate costs with
-func (a *AWSCURIngester) resolveNode(ctx context.Context, record []string, colIndex map[string]int, nodeCache map[string]*models.CostNode) (*models.CostNode, error) {
-	// Try to find node by product tag first
-	productTag := a.getColumn(record, colIndex, ColResourceTagsUserProduct)
-	if productTag != "" {
-		if node, ok := nodeCache[productTag]; ok {
-			return node, nil
-		}
-		node, err := a.store.Nodes.GetByName(ctx, productTag)
-		if err == nil {
-			nodeCache[productTag] = node
-			return node, nil
-		}
-	}
-
-	// Try service tag
-	serviceTag := a.getColumn(record, colIndex, ColResourceTagsUserService)
-	if serviceTag != "" {
-		if node, ok := nodeCache[serviceTag]; ok {
-			return node, nil
-		}
-		node, err := a.store.Nodes.GetByName(ctx, serviceTag)
-		if err == nil {
-			nodeCache[serviceTag] = node
-			return node, nil
-		}
-	}
-
-	// Try cost center tag
-	costCenterTag := a.getColumn(record, colIndex, ColResourceTagsUserCostCenter)
-	if costCenterTag != "" {
-		if node, ok := nodeCache[costCenterTag]; ok {
-			return node, nil
-		}
-		node, err := a.store.Nodes.GetByName(ctx, costCenterTag)
-		if err == nil {
-			nodeCache[costCenterTag] = node
-			return node, nil
-		}
-	}
-
-	// Fall back to product code (AWS service name)
-	productCode := a.getColumn(record, colIndex, ColLineItemProductCode)
-	if productCode != "" {
-		nodeName := "aws_" + strings.ToLower(productCode)
-		if node, ok := nodeCache[nodeName]; ok {
-			return node, nil
-		}
-		node, err := a.store.Nodes.GetByName(ctx, nodeName)
-		if err == nil {
-			nodeCache[nodeName] = node
-			return node, nil
-		}
-
-		// Create missing node if configured
-		if a.createMissingNodes {
-			newNode := &models.CostNode{
-				ID:         uuid.New(),
-				Name:       nodeName,
-				Type:       string(models.NodeTypeResource),
-				IsPlatform: false,
-				CostLabels: map[string]interface{}{
-					"aws_product_code": productCode,
-				},
-				Metadata: map[string]interface{}{
-					"source":     "aws_cur_import",
-					"created_by": "auto",
-				},
-			}
-			if err := a.store.Nodes.Create(ctx, newNode); err != nil {
-				return nil, fmt.Errorf("failed to create node: %w", err)
-			}
-			nodeCache[nodeName] = newNode
-			log.Info().Str("node_name", nodeName).Msg("Created new node from AWS CUR import")
-			return newNode, nil
-		}
-	}
-
-	return nil, nil
-}
-
 // buildDimension creates a dimension string from the record
 func (a *AWSCURIngester) buildDimension(record []string, colIndex map[string]int) string {
 	usageType := a.getColumn(record, colIndex, ColLineItemUsageType)
@@ -440,23 +656,24 @@ func (a *AWSCURIngester) buildMetadata(record []string, colIndex map[string]int)
 	if v := a.getColumn(record, colIndex, ColProductRegion); v != "" {
 		metadata["region"] = v
 	}
+	if v := a.getColumn(record, colIndex, ColLineItemUsageAccountId); v != "" {
+		metadata["account_id"] = v
+	}
 	if v := a.getColumn(record, colIndex, ColResourceTagsUserName); v != "" {
 		metadata["resource_name"] = v
 	}
+	if v := a.getColumn(record, colIndex, ColLineItemLineItemType); v != "" {
+		metadata["line_item_types"] = []string{v}
+	}
 
 	metadata["source"] = "aws_cur"
+	metadata["cost_basis"] = string(a.costBasis)
 
 	return metadata
 }
 
 // reportProgress sends progress updates if a channel is configured
 func (a *AWSCURIngester) reportProgress(progress IngestionProgress) {
-	if a.progressChan != nil {
-		select {
-		case a.progressChan <- progress:
-		default:
-			// Don't block if channel is full
-		}
-	}
+	sendProgress(a.progressChan, progress)
 }
 
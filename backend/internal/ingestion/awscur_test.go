@@ -0,0 +1,287 @@
+package ingestion
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLineItemCost checks each line item type's cost under every cost basis, using a
+// day of an EC2 Savings Plan, a Reserved Instance and their covered usage
+func TestLineItemCost(t *testing.T) {
+	headers := []string{
+		ColLineItemLineItemType,
+		ColLineItemUnblendedCost,
+		ColLineItemNetUnblendedCost,
+		ColSavingsPlanEffectiveCost,
+		ColSavingsPlanNetEffectiveCost,
+		ColSavingsPlanTotalCommitmentToDate,
+		ColSavingsPlanUsedCommitment,
+		ColSavingsPlanNetAmortizedUpfrontCommitment,
+		ColSavingsPlanNetRecurringCommitment,
+		ColReservationARN,
+		ColReservationEffectiveCost,
+		ColReservationNetEffectiveCost,
+		ColReservationUnusedAmortizedUpfrontFee,
+		ColReservationNetUnusedAmortizedUpfrontFee,
+		ColReservationUnusedRecurringFee,
+		ColReservationNetUnusedRecurringFee,
+	}
+	colIndex := make(map[string]int, len(headers))
+	for i, header := range headers {
+		colIndex[header] = i
+	}
+
+	row := func(values map[string]string) []string {
+		record := make([]string, len(headers))
+		for i, header := range headers {
+			record[i] = values[header]
+		}
+		return record
+	}
+
+	rows := []struct {
+		name   string
+		record []string
+		want   map[CostBasis]string
+	}{
+		{
+			name:   "on-demand usage",
+			record: row(map[string]string{ColLineItemLineItemType: LineItemTypeUsage, ColLineItemUnblendedCost: "10", ColLineItemNetUnblendedCost: "9"}),
+			want:   map[CostBasis]string{CostBasisUnblended: "10", CostBasisAmortised: "10", CostBasisNetAmortised: "9"},
+		},
+		{
+			name: "Savings Plan covered usage",
+			record: row(map[string]string{
+				ColLineItemLineItemType: LineItemTypeSavingsPlanCoveredUsage, ColLineItemUnblendedCost: "10",
+				ColSavingsPlanEffectiveCost: "6", ColSavingsPlanNetEffectiveCost: "5.4",
+			}),
+			want: map[CostBasis]string{CostBasisUnblended: "10", CostBasisAmortised: "6", CostBasisNetAmortised: "5.4"},
+		},
+		{
+			name:   "Savings Plan negation",
+			record: row(map[string]string{ColLineItemLineItemType: LineItemTypeSavingsPlanNegation, ColLineItemUnblendedCost: "-10"}),
+			want:   map[CostBasis]string{CostBasisUnblended: "-10", CostBasisAmortised: "0", CostBasisNetAmortised: "0"},
+		},
+		{
+			name: "Savings Plan recurring fee",
+			record: row(map[string]string{
+				ColLineItemLineItemType: LineItemTypeSavingsPlanRecurringFee, ColLineItemUnblendedCost: "8",
+				ColSavingsPlanTotalCommitmentToDate: "8", ColSavingsPlanUsedCommitment: "6",
+				ColSavingsPlanNetAmortizedUpfrontCommitment: "0", ColSavingsPlanNetRecurringCommitment: "8",
+			}),
+			want: map[CostBasis]string{CostBasisUnblended: "8", CostBasisAmortised: "2", CostBasisNetAmortised: "2"},
+		},
+		{
+			name: "discounted Savings Plan recurring fee",
+			record: row(map[string]string{
+				ColLineItemLineItemType: LineItemTypeSavingsPlanRecurringFee, ColLineItemUnblendedCost: "5",
+				ColSavingsPlanTotalCommitmentToDate: "10", ColSavingsPlanUsedCommitment: "4",
+				ColSavingsPlanNetAmortizedUpfrontCommitment: "4.5", ColSavingsPlanNetRecurringCommitment: "4.5",
+			}),
+			want: map[CostBasis]string{CostBasisUnblended: "5", CostBasisAmortised: "6", CostBasisNetAmortised: "5.4"},
+		},
+		{
+			name:   "Savings Plan upfront fee",
+			record: row(map[string]string{ColLineItemLineItemType: LineItemTypeSavingsPlanUpfrontFee, ColLineItemUnblendedCost: "2920"}),
+			want:   map[CostBasis]string{CostBasisUnblended: "2920", CostBasisAmortised: "0", CostBasisNetAmortised: "0"},
+		},
+		{
+			name: "Reserved Instance covered usage",
+			record: row(map[string]string{
+				ColLineItemLineItemType: LineItemTypeDiscountedUsage, ColLineItemUnblendedCost: "0",
+				ColReservationEffectiveCost: "4", ColReservationNetEffectiveCost: "3.6",
+			}),
+			want: map[CostBasis]string{CostBasisUnblended: "0", CostBasisAmortised: "4", CostBasisNetAmortised: "3.6"},
+		},
+		{
+			name: "Reserved Instance fee",
+			record: row(map[string]string{
+				ColLineItemLineItemType: LineItemTypeRIFee, ColLineItemUnblendedCost: "3",
+				ColReservationUnusedAmortizedUpfrontFee: "1", ColReservationNetUnusedAmortizedUpfrontFee: "0.9",
+				ColReservationUnusedRecurringFee: "0.5", ColReservationNetUnusedRecurringFee: "0.45",
+			}),
+			want: map[CostBasis]string{CostBasisUnblended: "3", CostBasisAmortised: "1.5", CostBasisNetAmortised: "1.35"},
+		},
+		{
+			name: "Reserved Instance upfront fee",
+			record: row(map[string]string{
+				ColLineItemLineItemType: LineItemTypeFee, ColLineItemUnblendedCost: "1460",
+				ColReservationARN: "arn:aws:ec2:us-east-1:123456789012:reserved-instances/ri-1",
+			}),
+			want: map[CostBasis]string{CostBasisUnblended: "1460", CostBasisAmortised: "0", CostBasisNetAmortised: "0"},
+		},
+		{
+			name:   "other fees",
+			record: row(map[string]string{ColLineItemLineItemType: LineItemTypeFee, ColLineItemUnblendedCost: "29", ColLineItemNetUnblendedCost: "29"}),
+			want:   map[CostBasis]string{CostBasisUnblended: "29", CostBasisAmortised: "29", CostBasisNetAmortised: "29"},
+		},
+		{
+			name:   "credits",
+			record: row(map[string]string{ColLineItemLineItemType: LineItemTypeCredit, ColLineItemUnblendedCost: "-5", ColLineItemNetUnblendedCost: "-5"}),
+			want:   map[CostBasis]string{CostBasisUnblended: "-5", CostBasisAmortised: "-5", CostBasisNetAmortised: "-5"},
+		},
+		{
+			name:   "tax",
+			record: row(map[string]string{ColLineItemLineItemType: LineItemTypeTax, ColLineItemUnblendedCost: "1.2", ColLineItemNetUnblendedCost: "1.1"}),
+			want:   map[CostBasis]string{CostBasisUnblended: "1.2", CostBasisAmortised: "1.2", CostBasisNetAmortised: "1.1"},
+		},
+	}
+
+	for _, tt := range rows {
+		for basis, want := range tt.want {
+			t.Run(tt.name+"/"+string(basis), func(t *testing.T) {
+				ingester := NewAWSCURIngester(nil, &AWSCURConfig{CostBasis: basis})
+				got, err := ingester.lineItemCost(tt.record, colIndex)
+				require.NoError(t, err)
+				assert.True(t, got.Equal(decimal.RequireFromString(want)), "got %s, want %s", got, want)
+			})
+		}
+	}
+
+	t.Run("net amortised falls back to gross columns", func(t *testing.T) {
+		ingester := NewAWSCURIngester(nil, &AWSCURConfig{CostBasis: CostBasisNetAmortised})
+		got, err := ingester.lineItemCost([]string{LineItemTypeUsage, "10"}, map[string]int{ColLineItemLineItemType: 0, ColLineItemUnblendedCost: 1})
+		require.NoError(t, err)
+		assert.Equal(t, "10", got.String())
+
+		got, err = ingester.lineItemCost(
+			[]string{LineItemTypeSavingsPlanRecurringFee, "8", "6"},
+			map[string]int{ColLineItemLineItemType: 0, ColSavingsPlanTotalCommitmentToDate: 1, ColSavingsPlanUsedCommitment: 2},
+		)
+		require.NoError(t, err)
+		assert.Equal(t, "2", got.String())
+	})
+}
+
+func TestParseCostBasis(t *testing.T) {
+	basis, err := ParseCostBasis("")
+	require.NoError(t, err)
+	assert.Equal(t, CostBasisUnblended, basis)
+
+	basis, err = ParseCostBasis("Amortised")
+	require.NoError(t, err)
+	assert.Equal(t, CostBasisAmortised, basis)
+	assert.Equal(t, "box_usage_amortised", basis.Dimension("box_usage"))
+	assert.Equal(t, "box_usage", CostBasisUnblended.Dimension("box_usage"))
+
+	_, err = ParseCostBasis("blended")
+	assert.ErrorContains(t, err, "unknown cost basis")
+}
+
+// TestCostTotals checks line items are summed per node, day and dimension, so
+// negations and credits net against the cost they apply to
+func TestCostTotals(t *testing.T) {
+	node := uuid.New()
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	lineItem := func(lineItemType, amount string) models.NodeCostByDimension {
+		return models.NodeCostByDimension{
+			NodeID:    node,
+			CostDate:  day,
+			Dimension: "box_usage",
+			Amount:    decimal.RequireFromString(amount),
+			Currency:  "USD",
+			Metadata:  map[string]interface{}{"line_item_types": []string{lineItemType}},
+		}
+	}
+
+	totals := newCostTotals()
+	totals.add(lineItem(LineItemTypeSavingsPlanCoveredUsage, "10"))
+	totals.add(lineItem(LineItemTypeSavingsPlanNegation, "-10"))
+	totals.add(lineItem(LineItemTypeUsage, "4"))
+	totals.add(lineItem(LineItemTypeUsage, "3"))
+
+	costs := totals.costs()
+	require.Len(t, costs, 1)
+	assert.Equal(t, "7", costs[0].Amount.String())
+	assert.Equal(t, []string{LineItemTypeSavingsPlanCoveredUsage, LineItemTypeSavingsPlanNegation, LineItemTypeUsage}, costs[0].Metadata["line_item_types"])
+}
+
+// TestCostTotalsNetCredit checks a total left negative by credits is recorded as a
+// net credit, with the dimension's cost set to zero
+func TestCostTotalsNetCredit(t *testing.T) {
+	node := uuid.New()
+	day := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	cost := func(dimension, amount string) models.NodeCostByDimension {
+		return models.NodeCostByDimension{
+			NodeID:    node,
+			CostDate:  day,
+			Dimension: dimension,
+			Amount:    decimal.RequireFromString(amount),
+			Currency:  "USD",
+			Metadata:  map[string]interface{}{"line_item_types": []string{LineItemTypeUsage}},
+		}
+	}
+
+	totals := newCostTotals()
+	totals.add(cost("box_usage", "4"))
+	totals.add(cost("box_usage", "-6.5"))
+	totals.add(cost("storage", "2"))
+
+	var written []models.NodeCostByDimension
+	write := func(_ context.Context, batch []models.NodeCostByDimension) error {
+		written = append(written, batch...)
+		return nil
+	}
+	result := &IngestionResult{}
+	require.NoError(t, writeCostTotals(context.Background(), write, totals, 2, result, func(IngestionProgress) {}))
+
+	amounts := make(map[string]string)
+	for _, c := range written {
+		amounts[c.Dimension] = c.Amount.String()
+	}
+	assert.Equal(t, map[string]string{"box_usage": "0", "box_usage_credit": "2.5", "storage": "2"}, amounts)
+	assert.Equal(t, 3, result.RecordsInserted)
+	assert.Empty(t, result.Errors)
+
+	require.Len(t, written, 3)
+	assert.Equal(t, "box_usage", written[1].Metadata["credit_for"])
+	assert.NotContains(t, written[0].Metadata, "credit_for", "the cost's metadata is not shared with its credit")
+}
+
+// TestCURReportPeriod checks a single report's billing period is read from its
+// billing period columns, or is the month of its line items without them
+func TestCURReportPeriod(t *testing.T) {
+	ingester := NewAWSCURIngester(nil, &AWSCURConfig{CreateMissingNodes: true})
+	period := func(report string) (string, error) {
+		periods := make(curPeriods)
+		resolver := noNodes(testResolver(true), "aws_amazonec2")
+		err := ingester.readReport(context.Background(), strings.NewReader(report), "", resolver, newCostTotals(), periods, &IngestionResult{})
+		require.NoError(t, err)
+		start, end, err := periods.period()
+		if err != nil {
+			return "", err
+		}
+		return start.Format("2006-01-02") + " " + end.Format("2006-01-02"), nil
+	}
+
+	got, err := period(`bill/BillingPeriodStartDate,bill/BillingPeriodEndDate,lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/ProductCode
+2024-01-01T00:00:00Z,2024-02-01T00:00:00Z,2024-01-15,1.5,AmazonEC2
+2024-01-01T00:00:00Z,2024-02-01T00:00:00Z,2024-01-31,1,AmazonEC2
+`)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01 2024-02-01", got)
+
+	got, err = period(`lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/ProductCode
+2024-03-02,1.5,AmazonEC2
+2024-03-31,1,AmazonEC2
+`)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-03-01 2024-04-01", got, "the period is the month of the line items")
+
+	_, err = period(`lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/ProductCode
+2024-03-31,1.5,AmazonEC2
+2024-04-01,1,AmazonEC2
+`)
+	assert.EqualError(t, err, "report covers 2 billing periods (2024-03-01, 2024-04-01); import each period's report separately")
+
+	_, err = period("lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/ProductCode\n")
+	assert.EqualError(t, err, "report has no line items to take a billing period from")
+}
@@ -0,0 +1,321 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// AzureCostExportIngester handles ingestion of Azure Cost Management exports
+// (actual or amortized cost, CSV)
+type AzureCostExportIngester struct {
+	store              *store.Store
+	progressChan       chan IngestionProgress
+	batchSize          int
+	createMissingNodes bool
+}
+
+// AzureCostExportConfig configures the Azure Cost Management export ingester
+type AzureCostExportConfig struct {
+	BatchSize          int
+	CreateMissingNodes bool
+	ProgressChan       chan IngestionProgress
+}
+
+// Azure Cost Management export columns. The export schema names some columns
+// differently between agreement types and versions, so each field lists every
+// name it is exported under; names match ignoring case.
+var (
+	AzureColDate             = []string{"Date", "UsageDate", "UsageDateTime"}
+	AzureColCost             = []string{"CostInBillingCurrency", "Cost", "PreTaxCost"}
+	AzureColCurrency         = []string{"BillingCurrencyCode", "BillingCurrency", "Currency"}
+	AzureColMeterCategory    = []string{"MeterCategory"}
+	AzureColMeterSubCategory = []string{"MeterSubCategory"}
+	AzureColMeterName        = []string{"MeterName"}
+	AzureColConsumedService  = []string{"ConsumedService"}
+	AzureColResourceID       = []string{"ResourceId", "InstanceId", "InstanceName"}
+	AzureColResourceGroup    = []string{"ResourceGroup", "ResourceGroupName"}
+	AzureColResourceLocation = []string{"ResourceLocation", "Location"}
+	AzureColSubscription     = []string{"SubscriptionName", "SubscriptionId"}
+	AzureColChargeType       = []string{"ChargeType"}
+	AzureColTags             = []string{"Tags"}
+)
+
+// NewAzureCostExportIngester creates a new Azure Cost Management export ingester
+func NewAzureCostExportIngester(store *store.Store, config *AzureCostExportConfig) *AzureCostExportIngester {
+	ingester := &AzureCostExportIngester{
+		store:     store,
+		batchSize: 1000,
+	}
+	if config != nil {
+		if config.BatchSize > 0 {
+			ingester.batchSize = config.BatchSize
+		}
+		ingester.createMissingNodes = config.CreateMissingNodes
+		ingester.progressChan = config.ProgressChan
+	}
+	return ingester
+}
+
+// IngestFile ingests an Azure Cost Management export file
+func (a *AzureCostExportIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	a.reportProgress(IngestionProgress{
+		CurrentFile: filePath,
+		Message:     fmt.Sprintf("Starting ingestion of %s", filePath),
+	})
+
+	return a.IngestReader(ctx, file, filePath)
+}
+
+// IngestReader ingests an Azure Cost Management export from a reader
+func (a *AzureCostExportIngester) IngestReader(ctx context.Context, reader io.Reader, sourceName string) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "azure_cost_export",
+		StartTime: time.Now(),
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.TrimLeadingSpace = true
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := newCSVColumns(headers)
+	for _, col := range [][]string{AzureColDate, AzureColCost} {
+		if !columns.has(col...) {
+			return nil, fmt.Errorf("required column %s not found in CSV", col[0])
+		}
+	}
+
+	resolver := newNodeResolver(a.store, "azure_cost_export", a.createMissingNodes)
+	totals := newCostTotals()
+	recordNum := 0
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum+1, err))
+			result.RecordsSkipped++
+			continue
+		}
+
+		recordNum++
+		result.RecordsProcessed++
+
+		item, err := azureLineItem(columns, record)
+		if err == nil && !item.amount.IsZero() {
+			err = addLineItem(ctx, resolver, totals, item)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum, err))
+			result.RecordsSkipped++
+			continue
+		}
+
+		if recordNum%a.batchSize == 0 {
+			a.reportProgress(IngestionProgress{
+				RecordsProcessed: result.RecordsProcessed,
+				RecordsSkipped:   result.RecordsSkipped,
+				CurrentRecord:    recordNum,
+				Message:          fmt.Sprintf("Processed %d records", result.RecordsProcessed),
+			})
+		}
+	}
+
+	if err := storeCostTotals(ctx, a.store, totals, a.batchSize, result, a.reportProgress); err != nil {
+		return nil, err
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	a.reportProgress(IngestionProgress{
+		RecordsProcessed: result.RecordsProcessed,
+		RecordsInserted:  result.RecordsInserted,
+		RecordsSkipped:   result.RecordsSkipped,
+		Message:          fmt.Sprintf("Ingestion complete: %d processed, %d inserted, %d skipped", result.RecordsProcessed, result.RecordsInserted, result.RecordsSkipped),
+	})
+
+	log.Info().
+		Str("source", sourceName).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("Azure cost export ingestion completed")
+
+	return result, nil
+}
+
+// reportProgress sends progress updates if a channel is configured
+func (a *AzureCostExportIngester) reportProgress(progress IngestionProgress) {
+	sendProgress(a.progressChan, progress)
+}
+
+// azureLineItem parses an export row. Rows are resolved to the node named by their
+// Product, Service or CostCenter tag, or else to an azure_<meter category> node.
+func azureLineItem(c csvColumns, record []string) (billingLineItem, error) {
+	date, err := parseAzureDate(c.get(record, AzureColDate...))
+	if err != nil {
+		return billingLineItem{}, fmt.Errorf("invalid date: %w", err)
+	}
+
+	amount := decimal.Zero
+	if value := c.get(record, AzureColCost...); value != "" {
+		amount, err = decimal.NewFromString(value)
+		if err != nil {
+			return billingLineItem{}, fmt.Errorf("invalid cost: %w", err)
+		}
+	}
+
+	currency := fx.NormaliseCurrency(c.get(record, AzureColCurrency...))
+	if currency == "" {
+		currency = "USD"
+	}
+
+	tags := parseAzureTags(c.get(record, AzureColTags...))
+
+	category := c.get(record, AzureColMeterCategory...)
+	var service serviceNode
+	if name := normaliseName(category); name != "" {
+		service = serviceNode{name: "azure_" + name, labels: map[string]interface{}{"azure_meter_category": category}}
+	} else if consumed := c.get(record, AzureColConsumedService...); consumed != "" {
+		service = serviceNode{name: "azure_" + normaliseName(consumed), labels: map[string]interface{}{"azure_consumed_service": consumed}}
+	}
+
+	dimension := normaliseName(c.get(record, AzureColMeterName...))
+	if dimension == "" && category != "" {
+		dimension = normaliseName(category) + "_cost"
+	}
+	if dimension == "" {
+		dimension = "unclassified"
+	}
+
+	metadata := map[string]interface{}{"source": "azure_cost_export"}
+	for key, names := range map[string][]string{
+		"meter_category":    AzureColMeterCategory,
+		"meter_subcategory": AzureColMeterSubCategory,
+		"meter_name":        AzureColMeterName,
+		"resource_id":       AzureColResourceID,
+		"resource_group":    AzureColResourceGroup,
+		"region":            AzureColResourceLocation,
+		"subscription":      AzureColSubscription,
+	} {
+		if v := c.get(record, names...); v != "" {
+			metadata[key] = v
+		}
+	}
+	if v := c.get(record, AzureColChargeType...); v != "" {
+		metadata["line_item_types"] = []string{v}
+	}
+
+	return billingLineItem{
+		date:      date,
+		amount:    amount,
+		currency:  currency,
+		dimension: dimension,
+		tags: []string{
+			tagValue(tags, "Product"),
+			tagValue(tags, "Service"),
+			tagValue(tags, "CostCenter", "CostCentre"),
+		},
+		service:  service,
+		metadata: metadata,
+	}, nil
+}
+
+// parseAzureDate parses the date formats Azure exports use: US-style dates in
+// older exports and ISO dates in newer ones
+func parseAzureDate(value string) (time.Time, error) {
+	formats := []string{
+		"01/02/2006",
+		"1/2/2006",
+		"2006-01-02",
+		"2006-01-02T15:04:05Z07:00",
+		"2006-01-02T15:04:05",
+	}
+	for _, format := range formats {
+		if t, err := time.Parse(format, value); err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse date: %s", value)
+}
+
+// parseAzureTags parses the Tags column, which newer exports write as a JSON
+// object and older ones as its members without the braces. Tags that cannot be
+// parsed are ignored, leaving the row to its meter category's node.
+func parseAzureTags(value string) map[string]string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if !strings.HasPrefix(value, "{") {
+		value = "{" + value + "}"
+	}
+
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(value), &tags); err != nil {
+		log.Debug().Err(err).Str("tags", value).Msg("Ignoring unparseable Azure tags")
+		return nil
+	}
+	return tags
+}
+
+// billingLineItem is a cost line item read from a cloud billing export, before
+// its node is resolved
+type billingLineItem struct {
+	date      time.Time
+	amount    decimal.Decimal
+	currency  string
+	dimension string
+	// tags are the product, service and cost centre tag values, in the order
+	// they are tried as node names
+	tags     []string
+	service  serviceNode
+	metadata map[string]interface{}
+}
+
+// addLineItem resolves a line item's node and adds its cost to the totals.
+// Line items with no node, when missing nodes are not created, are dropped.
+func addLineItem(ctx context.Context, resolver *nodeResolver, totals *costTotals, item billingLineItem) error {
+	node, err := resolver.resolve(ctx, item.tags, item.service)
+	if err != nil {
+		return fmt.Errorf("failed to resolve node: %w", err)
+	}
+	if node == nil {
+		return nil
+	}
+
+	totals.add(models.NodeCostByDimension{
+		NodeID:    node.ID,
+		CostDate:  item.date,
+		Dimension: item.dimension,
+		Amount:    item.amount,
+		Currency:  item.currency,
+		Metadata:  item.metadata,
+	})
+	return nil
+}
@@ -0,0 +1,61 @@
+package ingestion
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAzureLineItem(t *testing.T) {
+	input := "\ufeffDate,MeterCategory,MeterName,CostInBillingCurrency,BillingCurrencyCode,ResourceGroup,ChargeType,Tags\n" +
+		`01/15/2024,Virtual Machines,D2s v3,12.50,eur,rg-web,Usage,"""Product"": ""checkout"", ""cost-center"": ""retail"""` + "\n" +
+		`2024-01-16,Storage,,3.25,,rg-data,Usage,{"env": "prod"}` + "\n" +
+		`2024-01-17,Storage,,1.00,,,Usage,not json` + "\n" +
+		`13/45/2024,Storage,,1.00,,,Usage,` + "\n"
+
+	reader := csv.NewReader(strings.NewReader(input))
+	reader.LazyQuotes = true
+	headers, err := reader.Read()
+	require.NoError(t, err)
+	columns := newCSVColumns(headers)
+	assert.True(t, columns.has(AzureColDate...))
+	assert.True(t, columns.has(AzureColCost...))
+
+	records, err := reader.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 4)
+
+	item, err := azureLineItem(columns, records[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-15", item.date.Format("2006-01-02"))
+	assert.Equal(t, "12.5", item.amount.String())
+	assert.Equal(t, "EUR", item.currency)
+	assert.Equal(t, "d2s_v3", item.dimension)
+	assert.Equal(t, []string{"checkout", "", "retail"}, item.tags)
+	assert.Equal(t, "azure_virtual_machines", item.service.name)
+	assert.Equal(t, "rg-web", item.metadata["resource_group"])
+	assert.Equal(t, []string{"Usage"}, item.metadata["line_item_types"])
+
+	item, err = azureLineItem(columns, records[1])
+	require.NoError(t, err)
+	assert.Equal(t, "USD", item.currency)
+	assert.Equal(t, "storage_cost", item.dimension)
+	assert.Equal(t, []string{"", "", ""}, item.tags)
+
+	// Unparseable tags leave the row to its meter category's node
+	item, err = azureLineItem(columns, records[2])
+	require.NoError(t, err)
+	assert.Equal(t, "azure_storage", item.service.name)
+
+	_, err = azureLineItem(columns, records[3])
+	assert.ErrorContains(t, err, "invalid date")
+}
+
+func TestCSVColumns(t *testing.T) {
+	columns := newCSVColumns([]string{"UsageDate", "MeterCategory"})
+	assert.True(t, columns.has(AzureColDate...))
+	assert.False(t, columns.has(AzureColCost...))
+}
@@ -0,0 +1,334 @@
+package ingestion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// costKey identifies a cost record
+type costKey struct {
+	nodeID    uuid.UUID
+	date      time.Time
+	dimension string
+}
+
+// costTotals sums line items into cost records, in the order each was first seen
+type costTotals struct {
+	totals map[costKey]*models.NodeCostByDimension
+	order  []costKey
+}
+
+func newCostTotals() *costTotals {
+	return &costTotals{totals: make(map[costKey]*models.NodeCostByDimension)}
+}
+
+// add adds a line item's cost to its record, keeping the first line item's
+// metadata and the line item types of them all
+func (t *costTotals) add(cost models.NodeCostByDimension) {
+	key := costKey{nodeID: cost.NodeID, date: cost.CostDate, dimension: cost.Dimension}
+	total, ok := t.totals[key]
+	if !ok {
+		t.totals[key] = &cost
+		t.order = append(t.order, key)
+		return
+	}
+
+	total.Amount = total.Amount.Add(cost.Amount)
+	types, _ := total.Metadata["line_item_types"].([]string)
+	added, _ := cost.Metadata["line_item_types"].([]string)
+	for _, lineItemType := range added {
+		if !containsString(types, lineItemType) {
+			types = append(types, lineItemType)
+		}
+	}
+	if len(types) > 0 {
+		total.Metadata["line_item_types"] = types
+	}
+}
+
+// costs returns the summed cost records
+func (t *costTotals) costs() []models.NodeCostByDimension {
+	costs := make([]models.NodeCostByDimension, 0, len(t.order))
+	for _, key := range t.order {
+		costs = append(costs, *t.totals[key])
+	}
+	return costs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CreditDimensionSuffix ends the dimension a node's net credit is recorded under
+// when credits exceed its cost for a day, e.g. box_usage_credit
+const CreditDimensionSuffix = "_credit"
+
+// CreditDimension returns the dimension net credits against a dimension's cost
+// are recorded under
+func CreditDimension(dimension string) string {
+	return dimension + CreditDimensionSuffix
+}
+
+// netCosts splits totals left negative by credits, which costs cannot be, into a
+// zero cost for the dimension and the net credit as a positive amount under its
+// credit dimension. The zero replaces any cost recorded by an earlier import.
+func netCosts(totals *costTotals) []models.NodeCostByDimension {
+	costs := make([]models.NodeCostByDimension, 0, len(totals.order))
+	for _, cost := range totals.costs() {
+		if !cost.Amount.IsNegative() {
+			costs = append(costs, cost)
+			continue
+		}
+
+		credit := cost
+		credit.Dimension = CreditDimension(cost.Dimension)
+		credit.Amount = cost.Amount.Neg()
+		credit.Metadata = make(map[string]interface{}, len(cost.Metadata)+1)
+		for key, value := range cost.Metadata {
+			credit.Metadata[key] = value
+		}
+		credit.Metadata["credit_for"] = cost.Dimension
+
+		cost.Amount = decimal.Zero
+		costs = append(costs, cost, credit)
+	}
+	return costs
+}
+
+// storeCostTotals records summed costs in batches. Costs cannot be negative, so a
+// total left negative by credits is recorded as a net credit; see netCosts.
+func storeCostTotals(ctx context.Context, st *store.Store, totals *costTotals, batchSize int, result *IngestionResult, report func(IngestionProgress)) error {
+	return writeCostTotals(ctx, st.Costs.BulkUpsert, totals, batchSize, result, report)
+}
+
+// writeCostTotals records summed costs in batches with write, as storeCostTotals
+func writeCostTotals(ctx context.Context, write func(context.Context, []models.NodeCostByDimension) error, totals *costTotals, batchSize int, result *IngestionResult, report func(IngestionProgress)) error {
+	costs := netCosts(totals)
+	for start := 0; start < len(costs); start += batchSize {
+		end := start + batchSize
+		if end > len(costs) {
+			end = len(costs)
+		}
+		if err := write(ctx, costs[start:end]); err != nil {
+			return fmt.Errorf("failed to bulk insert costs: %w", err)
+		}
+		result.RecordsInserted += end - start
+
+		report(IngestionProgress{
+			RecordsProcessed: result.RecordsProcessed,
+			RecordsInserted:  result.RecordsInserted,
+			RecordsSkipped:   result.RecordsSkipped,
+			CurrentRecord:    result.RecordsProcessed,
+			Message:          fmt.Sprintf("Processed %d records, inserted %d", result.RecordsProcessed, result.RecordsInserted),
+		})
+	}
+
+	return nil
+}
+
+// rowError reports a row that cannot be parsed. The row is skipped and
+// reading continues with the next.
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string { return e.err.Error() }
+
+func (e *rowError) Unwrap() error { return e.err }
+
+// serviceNode describes the resource node holding the cost of a billed cloud
+// service, e.g. aws_amazonec2, when no tag names a node
+type serviceNode struct {
+	name   string
+	labels map[string]interface{}
+}
+
+// nodeResolver finds the node a billing line item's cost belongs to: the node
+// named by its product, service or cost centre tag, in that order, or else the
+// node for the cloud service it was billed for, which is created when the
+// ingester is configured to create missing nodes
+type nodeResolver struct {
+	store         *store.Store
+	source        string
+	createMissing bool
+	// dryRun returns nodes that would be created without creating them; they
+	// have no ID
+	dryRun bool
+	// cache holds nodes by name, and nil for names with no node
+	cache map[string]*models.CostNode
+}
+
+func newNodeResolver(st *store.Store, source string, createMissing bool) *nodeResolver {
+	return &nodeResolver{
+		store:         st,
+		source:        source,
+		createMissing: createMissing,
+		cache:         make(map[string]*models.CostNode),
+	}
+}
+
+// resolve returns the node for a line item, or nil when there is none and none
+// is created
+func (r *nodeResolver) resolve(ctx context.Context, tags []string, service serviceNode) (*models.CostNode, error) {
+	for _, tag := range tags {
+		if tag == "" {
+			continue
+		}
+		if node := r.lookup(ctx, tag); node != nil {
+			return node, nil
+		}
+	}
+
+	if service.name == "" {
+		return nil, nil
+	}
+	return r.find(ctx, service.name, string(models.NodeTypeResource), service.labels, true)
+}
+
+// find returns the node with a name. A node that does not exist is created
+// with the type and labels given when create is set and the resolver creates
+// missing nodes, and otherwise nil is returned.
+func (r *nodeResolver) find(ctx context.Context, name, nodeType string, labels map[string]interface{}, create bool) (*models.CostNode, error) {
+	if node := r.lookup(ctx, name); node != nil {
+		return node, nil
+	}
+	if !create || !r.createMissing {
+		return nil, nil
+	}
+
+	node := &models.CostNode{
+		Name:       name,
+		Type:       nodeType,
+		IsPlatform: false,
+		CostLabels: labels,
+		Metadata: map[string]interface{}{
+			"source":     r.source + "_import",
+			"created_by": "auto",
+		},
+	}
+	if r.dryRun {
+		r.cache[name] = node
+		return node, nil
+	}
+
+	node.ID = uuid.New()
+	if err := r.store.Nodes.Create(ctx, node); err != nil {
+		return nil, fmt.Errorf("failed to create node: %w", err)
+	}
+	r.cache[name] = node
+	log.Info().Str("node_name", name).Str("source", r.source).Msg("Created new node from cost import")
+	return node, nil
+}
+
+// lookup returns the node with a name, or nil if there is none
+func (r *nodeResolver) lookup(ctx context.Context, name string) *models.CostNode {
+	if node, ok := r.cache[name]; ok {
+		return node
+	}
+	node, err := r.store.Nodes.GetByName(ctx, name)
+	if err != nil {
+		node = nil
+	}
+	r.cache[name] = node
+	return node
+}
+
+// tagValue returns the value of the first of the keys a set of tags or labels
+// has. Keys match ignoring case and punctuation, so CostCenter, cost_center and
+// cost-center are the same key.
+func tagValue(tags map[string]string, keys ...string) string {
+	for _, key := range keys {
+		for tag, value := range tags {
+			if tagKey(tag) == tagKey(key) && strings.TrimSpace(value) != "" {
+				return strings.TrimSpace(value)
+			}
+		}
+	}
+	return ""
+}
+
+func tagKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, key)
+}
+
+// normaliseName turns a billing name into a node or dimension name, e.g.
+// "Virtual Machines" -> "virtual_machines"
+func normaliseName(name string) string {
+	var b strings.Builder
+	pending := false
+	for _, r := range strings.TrimSpace(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if pending && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			pending = false
+			b.WriteRune(unicode.ToLower(r))
+			continue
+		}
+		pending = true
+	}
+	return b.String()
+}
+
+// csvColumns indexes a CSV file's columns by lower case name
+type csvColumns map[string]int
+
+func newCSVColumns(headers []string) csvColumns {
+	columns := make(csvColumns, len(headers))
+	for i, header := range headers {
+		// Files written with a byte order mark carry it on the first header
+		header = strings.TrimPrefix(strings.TrimSpace(header), "\ufeff")
+		columns[strings.ToLower(header)] = i
+	}
+	return columns
+}
+
+// has reports whether the file has any of the named columns
+func (c csvColumns) has(names ...string) bool {
+	for _, name := range names {
+		if _, ok := c[strings.ToLower(name)]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// get returns the value of the first of the named columns the file has
+func (c csvColumns) get(record []string, names ...string) string {
+	for _, name := range names {
+		if idx, ok := c[strings.ToLower(name)]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+	}
+	return ""
+}
+
+// sendProgress sends a progress update if a channel is configured
+func sendProgress(progressChan chan IngestionProgress, progress IngestionProgress) {
+	if progressChan != nil {
+		select {
+		case progressChan <- progress:
+		default:
+			// Don't block if channel is full
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package ingestion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// MappingExplanation describes how a line item is mapped to a node's cost
+type MappingExplanation struct {
+	File string
+	Row  int
+	// Rule is the mapping rule that applied, empty when none did
+	Rule     string
+	Node     string
+	NodeType string
+	// NewNode is set when the node does not exist and would be created
+	NewNode   bool
+	Dimension string
+	Amount    decimal.Decimal
+	Currency  string
+	// Skipped says why the line item would not be recorded
+	Skipped string
+}
+
+// ExplainFile reads a report file, or every file a manifest lists, and
+// explains how each line item would be mapped by the ingester's rules. Nothing
+// is recorded and no nodes are created.
+func (a *AWSCURIngester) ExplainFile(ctx context.Context, path string, explain func(MappingExplanation)) error {
+	storage := localCURStorage{}
+	keys := []string{filepath.ToSlash(path)}
+
+	if IsCURManifest(path) {
+		reader, err := storage.ReadStream(ctx, keys[0])
+		if err != nil {
+			return fmt.Errorf("failed to read manifest: %w", err)
+		}
+		manifest, err := ParseCURManifest(reader)
+		reader.Close()
+		if err != nil {
+			return err
+		}
+		if keys, err = manifest.ResolveKeys(ctx, storage, keys[0]); err != nil {
+			return err
+		}
+	}
+
+	resolver := newNodeResolver(a.store, "aws_cur", a.createMissingNodes)
+	resolver.dryRun = true
+
+	for _, key := range keys {
+		reader, err := storage.ReadStream(ctx, key)
+		if err != nil {
+			return fmt.Errorf("failed to open report file %s: %w", key, err)
+		}
+		err = a.explainReport(ctx, reader, key, resolver, explain)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read report file %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// explainReport explains how each of a report's line items would be mapped
+func (a *AWSCURIngester) explainReport(ctx context.Context, reader io.Reader, file string, resolver *nodeResolver, explain func(MappingExplanation)) error {
+	records, err := openCURRecords(reader, a.rules.Columns()...)
+	if err != nil {
+		return err
+	}
+
+	for row := 1; ; row++ {
+		record, columns, err := records.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var rowErr *rowError
+		if errors.As(err, &rowErr) {
+			explain(MappingExplanation{File: file, Row: row, Skipped: err.Error()})
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read report: %w", err)
+		}
+
+		explanation := MappingExplanation{File: file, Row: row}
+		mapping, err := a.parseRecord(ctx, columns.fields(record), resolver)
+		switch {
+		case err != nil:
+			explanation.Skipped = err.Error()
+		case mapping.cost == nil:
+			explanation.Skipped = mapping.skipped
+		default:
+			explanation.Rule = mapping.rule
+			explanation.Node = mapping.node.Name
+			explanation.NodeType = mapping.node.Type
+			explanation.NewNode = mapping.node.ID == uuid.Nil
+			explanation.Dimension = mapping.cost.Dimension
+			explanation.Amount = mapping.cost.Amount
+			explanation.Currency = mapping.cost.Currency
+		}
+		explain(explanation)
+	}
+}
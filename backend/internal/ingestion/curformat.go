@@ -0,0 +1,438 @@
+package ingestion
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/focus"
+	"github.com/pickeringtech/FinOpsAggregator/internal/parquet"
+)
+
+var (
+	gzipMagic    = []byte{0x1f, 0x8b}
+	zipMagic     = []byte("PK\x03\x04")
+	parquetMagic = []byte("PAR1")
+)
+
+// curColumns are the CUR columns the ingester reads
+var curColumns = []string{
+	ColLineItemUsageStartDate,
+	ColLineItemUsageEndDate,
+	ColLineItemProductCode,
+	ColLineItemUsageType,
+	ColLineItemOperation,
+	ColLineItemResourceId,
+	ColLineItemUnblendedCost,
+	ColLineItemBlendedCost,
+	ColLineItemUsageAmount,
+	ColLineItemLineItemType,
+	ColLineItemNetUnblendedCost,
+	ColLineItemCurrencyCode,
+	ColLineItemUsageAccountId,
+	ColProductProductName,
+	ColProductRegion,
+	ColResourceTagsUserName,
+	ColResourceTagsUserProduct,
+	ColResourceTagsUserService,
+	ColResourceTagsUserCostCenter,
+	ColSavingsPlanARN,
+	ColSavingsPlanEffectiveCost,
+	ColSavingsPlanNetEffectiveCost,
+	ColSavingsPlanTotalCommitmentToDate,
+	ColSavingsPlanUsedCommitment,
+	ColSavingsPlanNetAmortizedUpfrontCommitment,
+	ColSavingsPlanNetRecurringCommitment,
+	ColReservationARN,
+	ColReservationEffectiveCost,
+	ColReservationNetEffectiveCost,
+	ColReservationUnusedAmortizedUpfrontFee,
+	ColReservationNetUnusedAmortizedUpfrontFee,
+	ColReservationUnusedRecurringFee,
+	ColReservationNetUnusedRecurringFee,
+	ColBillBillingPeriodStartDate,
+	ColBillBillingPeriodEndDate,
+}
+
+// curColumnsByKey maps the tagKey of each CUR column to its name, so
+// lineItem/UsageStartDate and CUR 2.0's line_item_usage_start_date are the
+// same column
+var curColumnsByKey = func() map[string]string {
+	columns := make(map[string]string, len(curColumns))
+	for _, col := range curColumns {
+		columns[tagKey(col)] = col
+	}
+	return columns
+}()
+
+// curMapColumns are the CUR 2.0 columns holding a map, keyed by tagKey. Each
+// entry is read as a column named after the map and the key, so the
+// resource_tags entry user_product is resourceTags/user:Product.
+var curMapColumns = map[string]bool{
+	"product":      true,
+	"resourcetags": true,
+}
+
+// curColumnIndex indexes a report's columns by their own names and by the CUR
+// column names they match. Mapping rules find tags and columns by comparing
+// keys, so the positions they find are cached until a column is added.
+type curColumnIndex struct {
+	index     map[string]int
+	names     map[string]int
+	count     int
+	positions map[string]int
+}
+
+func newCURColumnIndex() *curColumnIndex {
+	return &curColumnIndex{
+		index:     make(map[string]int),
+		names:     make(map[string]int),
+		positions: make(map[string]int),
+	}
+}
+
+// add adds a column, returning its position
+func (c *curColumnIndex) add(name string) int {
+	if i, ok := c.names[name]; ok {
+		return i
+	}
+	i := c.count
+	c.count++
+	c.names[name] = i
+	clear(c.positions)
+	if _, ok := c.index[name]; !ok {
+		c.index[name] = i
+	}
+	if col, ok := curColumnsByKey[tagKey(name)]; ok {
+		if _, exists := c.index[col]; !exists {
+			c.index[col] = i
+		}
+	}
+	return i
+}
+
+// setMap sets the columns of a map's entries in record, adding any that are new
+func (c *curColumnIndex) setMap(record []string, name string, entries map[string]string) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		record = c.set(record, name+"."+key, entries[key])
+	}
+	return record
+}
+
+// set sets a column in record, adding the column if it is new
+func (c *curColumnIndex) set(record []string, name, value string) []string {
+	i := c.add(name)
+	for len(record) <= i {
+		record = append(record, "")
+	}
+	record[i] = value
+	return record
+}
+
+// requireCURColumns checks a report has the columns every line item needs
+func requireCURColumns(index map[string]int) error {
+	for _, col := range []string{ColLineItemUsageStartDate, ColLineItemUnblendedCost} {
+		if _, ok := index[col]; !ok {
+			return fmt.Errorf("required column %s not found in report", col)
+		}
+	}
+	return nil
+}
+
+// curRecords reads the line items of a report
+type curRecords interface {
+	// Read returns the next line item and the index of its columns, which grows
+	// as map columns add entries. Rows that cannot be parsed return a rowError.
+	Read() ([]string, *curColumnIndex, error)
+}
+
+// openCURRecords opens a report file, detecting its format from its content:
+// gzip and zip archives are decompressed, Parquet files are read as Parquet
+// and anything else is read as CSV. Parquet reports are read for the CUR
+// columns, resource tags and any extra columns named.
+func openCURRecords(reader io.Reader, extra ...string) (curRecords, error) {
+	buffered := bufio.NewReader(reader)
+	// Short files are left to the CSV reader to reject
+	magic, _ := buffered.Peek(4)
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress report: %w", err)
+		}
+		return openCURRecords(gz, extra...)
+
+	case bytes.HasPrefix(magic, zipMagic):
+		// Zip and Parquet files are indexed at their end, so are read whole
+		data, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read report: %w", err)
+		}
+		archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		records := &curArchiveRecords{extra: extra}
+		for _, file := range archive.File {
+			if !file.FileInfo().IsDir() {
+				records.files = append(records.files, file)
+			}
+		}
+		if len(records.files) == 0 {
+			return nil, errors.New("zip archive holds no report files")
+		}
+		return records, nil
+
+	case bytes.Equal(magic, parquetMagic):
+		data, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read report: %w", err)
+		}
+		file, err := parquet.Open(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open Parquet report: %w", err)
+		}
+		return newCURParquetRecords(file, extra)
+
+	default:
+		return newCURCSVRecords(buffered)
+	}
+}
+
+// curCSVRecords reads the line items of a CSV report
+type curCSVRecords struct {
+	reader  *csv.Reader
+	columns *curColumnIndex
+	headers []string
+	// maps are the positions of map columns, whose values are JSON objects
+	maps []int
+}
+
+func newCURCSVRecords(reader io.Reader) (*curCSVRecords, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.TrimLeadingSpace = true
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	records := &curCSVRecords{reader: csvReader, columns: newCURColumnIndex(), headers: headers}
+	for i, header := range headers {
+		records.columns.add(header)
+		if curMapColumns[tagKey(header)] {
+			records.maps = append(records.maps, i)
+		}
+	}
+	if err := requireCURColumns(records.columns.index); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *curCSVRecords) Read() ([]string, *curColumnIndex, error) {
+	record, err := r.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, nil, &rowError{err}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, i := range r.maps {
+		if i >= len(record) {
+			continue
+		}
+		entries, err := focus.ParseTags(record[i])
+		if err != nil {
+			return nil, nil, &rowError{fmt.Errorf("invalid %s: %w", r.headers[i], err)}
+		}
+		record = r.columns.setMap(record, r.headers[i], entries)
+	}
+	return record, r.columns, nil
+}
+
+// curParquetRecords reads the line items of a Parquet report
+type curParquetRecords struct {
+	rows    *parquet.Rows
+	columns *curColumnIndex
+	maps    map[string]bool
+}
+
+func newCURParquetRecords(file *parquet.File, extra []string) (*curParquetRecords, error) {
+	extraKeys := make(map[string]bool, len(extra))
+	for _, col := range extra {
+		extraKeys[tagKey(col)] = true
+	}
+
+	records := &curParquetRecords{columns: newCURColumnIndex(), maps: make(map[string]bool)}
+	for _, field := range file.Fields() {
+		key := tagKey(field)
+		if curMapColumns[key] {
+			records.maps[field] = true
+		} else if _, ok := curColumnsByKey[key]; ok || extraKeys[key] || strings.HasPrefix(key, curTagPrefix) {
+			records.columns.add(field)
+		}
+	}
+	if err := requireCURColumns(records.columns.index); err != nil {
+		return nil, err
+	}
+
+	// Reports have over a hundred columns, so only those the ingester uses are read
+	records.rows = file.Rows(func(field string) bool {
+		_, ok := records.columns.names[field]
+		return ok || records.maps[field]
+	})
+	return records, nil
+}
+
+func (r *curParquetRecords) Read() ([]string, *curColumnIndex, error) {
+	row, err := r.rows.Next()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make([]string, 0, len(row))
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	record := make([]string, r.columns.count)
+	for _, name := range names {
+		record = r.columns.set(record, name, row[name])
+	}
+	return record, r.columns, nil
+}
+
+// curArchiveRecords reads the line items of each report in a zip archive in turn
+type curArchiveRecords struct {
+	files   []*zip.File
+	extra   []string
+	current curRecords
+	closer  io.Closer
+}
+
+func (r *curArchiveRecords) Read() ([]string, *curColumnIndex, error) {
+	for {
+		if r.current == nil {
+			if len(r.files) == 0 {
+				return nil, nil, io.EOF
+			}
+			file := r.files[0]
+			r.files = r.files[1:]
+
+			reader, err := file.Open()
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to open %s: %w", file.Name, err)
+			}
+			records, err := openCURRecords(reader, r.extra...)
+			if err != nil {
+				reader.Close()
+				return nil, nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+			}
+			r.current, r.closer = records, reader
+		}
+
+		record, columns, err := r.current.Read()
+		if err == io.EOF {
+			r.closer.Close()
+			r.current, r.closer = nil, nil
+			continue
+		}
+		return record, columns, err
+	}
+}
+
+// curTagPrefix begins the tagKey of every resource tag column, e.g.
+// resourceTags/user:team or CUR 2.0's resource_tags.user_team
+const curTagPrefix = "resourcetags"
+
+// fields returns the fields of a line item read with these columns
+func (c *curColumnIndex) fields(record []string) *curLineItemFields {
+	return &curLineItemFields{record: record, colIndex: c.index, columns: c}
+}
+
+// position returns the position of a tag or column, or -1 if there is none
+func (c *curColumnIndex) position(kind, name string, find func() int) int {
+	key := kind + ":" + name
+	if i, ok := c.positions[key]; ok {
+		return i
+	}
+	i := find()
+	c.positions[key] = i
+	return i
+}
+
+// curLineItemFields reads a line item's fields for mapping rules
+type curLineItemFields struct {
+	record   []string
+	colIndex map[string]int
+	columns  *curColumnIndex
+}
+
+// Tag returns the value of a resource tag. Keys ignore case, punctuation and
+// the user: prefix, so team is resourceTags/user:team or resource_tags.user_team.
+func (f *curLineItemFields) Tag(key string) string {
+	return f.value(f.columns.position("tag", key, func() int {
+		want := tagKey(key)
+		found := -1
+		for name, i := range f.colIndex {
+			rest, ok := strings.CutPrefix(tagKey(name), curTagPrefix)
+			if !ok {
+				continue
+			}
+			if rest == want {
+				return i
+			}
+			if rest == "user"+want {
+				found = i
+			}
+		}
+		return found
+	}))
+}
+
+// Column returns the value of a report column, named as in legacy CUR or CUR 2.0
+func (f *curLineItemFields) Column(name string) string {
+	if i, ok := f.colIndex[name]; ok {
+		return f.value(i)
+	}
+	return f.value(f.columns.position("column", name, func() int {
+		want := tagKey(name)
+		if col, ok := curColumnsByKey[want]; ok {
+			if i, ok := f.colIndex[col]; ok {
+				return i
+			}
+		}
+		for col, i := range f.colIndex {
+			if tagKey(col) == want {
+				return i
+			}
+		}
+		return -1
+	}))
+}
+
+func (f *curLineItemFields) value(i int) string {
+	if i < 0 || i >= len(f.record) {
+		return ""
+	}
+	return strings.TrimSpace(f.record[i])
+}
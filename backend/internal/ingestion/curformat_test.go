@@ -0,0 +1,281 @@
+package ingestion
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const legacyCURReport = `lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/ProductCode,resourceTags/user:Product
+2024-01-15T00:00:00Z,1.5,AmazonEC2,web
+2024-01-15T01:00:00Z,2
+2024-01-16T00:00:00Z,0.25,AmazonS3,
+`
+
+// CUR 2.0 and Data Exports name columns in snake case and hold tags in a map
+const cur2Report = `line_item_usage_start_date,line_item_unblended_cost,line_item_product_code,product,resource_tags
+2024-01-15T00:00:00.000Z,1.5,AmazonEC2,"{""region"":""eu-west-1""}","{""user_product"":""web"",""user_cost_center"":""retail""}"
+2024-01-16T00:00:00.000Z,0.25,AmazonS3,,"{""user_service"":""storage""}"
+2024-01-16T00:00:00.000Z,0.5,AmazonS3,,not json
+`
+
+// curLineItem is the part of a line item the format tests check
+type curLineItem struct {
+	date, cost, productCode, product, service, costCenter, region string
+}
+
+func readCURLineItems(t *testing.T, reader io.Reader) ([]curLineItem, []error) {
+	t.Helper()
+	records, err := openCURRecords(reader)
+	require.NoError(t, err)
+
+	a := &AWSCURIngester{}
+	var items []curLineItem
+	var rowErrs []error
+	for {
+		record, columns, err := records.Read()
+		if err == io.EOF {
+			return items, rowErrs
+		}
+		if err != nil {
+			var rowErr *rowError
+			require.True(t, errors.As(err, &rowErr), "unexpected error: %v", err)
+			rowErrs = append(rowErrs, err)
+			continue
+		}
+		items = append(items, curLineItem{
+			date:        a.getColumn(record, columns.index, ColLineItemUsageStartDate),
+			cost:        a.getColumn(record, columns.index, ColLineItemUnblendedCost),
+			productCode: a.getColumn(record, columns.index, ColLineItemProductCode),
+			product:     a.getColumn(record, columns.index, ColResourceTagsUserProduct),
+			service:     a.getColumn(record, columns.index, ColResourceTagsUserService),
+			costCenter:  a.getColumn(record, columns.index, ColResourceTagsUserCostCenter),
+			region:      a.getColumn(record, columns.index, ColProductRegion),
+		})
+	}
+}
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, files map[string][]byte, names ...string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write(files[name])
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestOpenCURRecordsFormats(t *testing.T) {
+	expected := []curLineItem{
+		{date: "2024-01-15T00:00:00Z", cost: "1.5", productCode: "AmazonEC2", product: "web"},
+		{date: "2024-01-16T00:00:00Z", cost: "0.25", productCode: "AmazonS3"},
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []curLineItem
+		skipped  int
+	}{
+		{name: "CSV", data: []byte(legacyCURReport), expected: expected, skipped: 1},
+		{name: "gzip CSV", data: gzipped(t, legacyCURReport), expected: expected, skipped: 1},
+		{
+			name: "zip of several reports",
+			data: zipped(t, map[string][]byte{
+				"report-1.csv":    []byte(legacyCURReport),
+				"report-2.csv.gz": gzipped(t, legacyCURReport),
+			}, "report-1.csv", "report-2.csv.gz"),
+			expected: append(append([]curLineItem{}, expected...), expected...),
+			skipped:  2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, rowErrs := readCURLineItems(t, bytes.NewReader(tt.data))
+			assert.Equal(t, tt.expected, items)
+			// The row missing columns is skipped in each report
+			assert.Len(t, rowErrs, tt.skipped)
+		})
+	}
+}
+
+func TestOpenCURRecordsCUR2(t *testing.T) {
+	items, rowErrs := readCURLineItems(t, strings.NewReader(cur2Report))
+
+	assert.Equal(t, []curLineItem{
+		{date: "2024-01-15T00:00:00.000Z", cost: "1.5", productCode: "AmazonEC2", product: "web", costCenter: "retail", region: "eu-west-1"},
+		{date: "2024-01-16T00:00:00.000Z", cost: "0.25", productCode: "AmazonS3", service: "storage"},
+	}, items)
+	require.Len(t, rowErrs, 1)
+	assert.ErrorContains(t, rowErrs[0], "invalid resource_tags")
+
+	date, err := (&AWSCURIngester{}).parseDate(items[0].date)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-15", date.Format("2006-01-02"))
+}
+
+func TestOpenCURRecordsRequiresColumns(t *testing.T) {
+	_, err := openCURRecords(strings.NewReader("line_item_usage_start_date,line_item_product_code\n"))
+	assert.ErrorContains(t, err, "required column lineItem/UnblendedCost not found")
+
+	_, err = openCURRecords(bytes.NewReader(gzipped(t, "")))
+	assert.ErrorContains(t, err, "failed to read CSV header")
+}
+
+// memoryCURStorage holds report files in memory
+type memoryCURStorage map[string]string
+
+func (s memoryCURStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, ok := s[key]
+	return ok, nil
+}
+
+func (s memoryCURStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	data, ok := s[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func TestParseCURManifest(t *testing.T) {
+	legacy := `{
+		"assemblyId": "a1b2",
+		"bucket": "billing",
+		"reportKeys": ["cur/daily/20240101-20240201/a1b2/daily-00001.csv.gz", "cur/daily/20240101-20240201/a1b2/daily-00002.csv.gz"],
+		"billingPeriod": {"start": "20240101T000000.000Z", "end": "20240201T000000.000Z"}
+	}`
+	manifest, err := ParseCURManifest(strings.NewReader(legacy))
+	require.NoError(t, err)
+	assert.Equal(t, "a1b2", manifest.ID())
+	assert.Equal(t, manifest.ReportKeys, manifest.Keys())
+	start, end, err := manifest.Period()
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01", start.Format("2006-01-02"))
+	assert.Equal(t, "2024-02-01", end.Format("2006-01-02"))
+
+	dataExport := `{
+		"executionId": "e-123",
+		"bucket": "billing",
+		"dataFiles": ["s3://billing/exports/cur2/data/BILLING_PERIOD=2024-01/cur2-00001.snappy.parquet"],
+		"billingPeriod": {"start": "2024-01-01T00:00:00.000Z", "end": "2024-02-01T00:00:00.000Z"}
+	}`
+	manifest, err = ParseCURManifest(strings.NewReader(dataExport))
+	require.NoError(t, err)
+	assert.Equal(t, "e-123", manifest.ID())
+	assert.Equal(t, []string{"exports/cur2/data/BILLING_PERIOD=2024-01/cur2-00001.snappy.parquet"}, manifest.Keys())
+	start, _, err = manifest.Period()
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01", start.Format("2006-01-02"))
+
+	_, err = ParseCURManifest(strings.NewReader(`{"reportKeys": []}`))
+	assert.ErrorContains(t, err, "no report files")
+}
+
+func TestResolveCURManifestKeys(t *testing.T) {
+	manifest := &CURManifest{ReportKeys: []string{
+		"cur/daily/20240101-20240201/a1b2/daily-00001.csv.gz",
+		"cur/daily/20240101-20240201/a1b2/daily-00002.csv.gz",
+	}}
+	storage := memoryCURStorage{
+		// As delivered
+		"cur/daily/20240101-20240201/a1b2/daily-00001.csv.gz": "",
+		// Copied beneath the import bucket's aws-cur/ prefix
+		"aws-cur/cur/daily/20240101-20240201/a1b2/daily-00002.csv.gz": "",
+	}
+
+	keys, err := manifest.ResolveKeys(context.Background(), storage, "aws-cur/cur/daily/20240101-20240201/daily-Manifest.json")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"cur/daily/20240101-20240201/a1b2/daily-00001.csv.gz",
+		"aws-cur/cur/daily/20240101-20240201/a1b2/daily-00002.csv.gz",
+	}, keys)
+
+	_, err = manifest.ResolveKeys(context.Background(), memoryCURStorage{}, "daily-Manifest.json")
+	assert.ErrorContains(t, err, "daily-00001.csv.gz not found")
+}
+
+func TestCURBatchChecksum(t *testing.T) {
+	a := sha256Hex("daily-00001.csv.gz")
+	b := sha256Hex("daily-00002.csv.gz")
+
+	checksum := curBatchChecksum([]string{a, b})
+	assert.Len(t, checksum, 64)
+	// Listing the same files in another order is the same batch
+	assert.Equal(t, checksum, curBatchChecksum([]string{b, a}))
+	// A restatement changing any file is a new batch
+	assert.NotEqual(t, checksum, curBatchChecksum([]string{a, sha256Hex("restated")}))
+	assert.NotEqual(t, checksum, curBatchChecksum([]string{a}))
+}
+
+func TestCURBatchPerCostBasis(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	digests := []string{sha256Hex("daily-00001.csv.gz")}
+
+	unblended := NewAWSCURIngester(nil, nil).newBatch(start, end, digests)
+	amortised := NewAWSCURIngester(nil, &AWSCURConfig{CostBasis: CostBasisAmortised}).newBatch(start, end, digests)
+
+	// The same period under two bases is two current batches, neither replacing
+	// the other nor taken for the other by its checksum
+	assert.Equal(t, "aws_cur", unblended.Source)
+	assert.Equal(t, "aws_cur:amortised", amortised.Source)
+	assert.NotEqual(t, unblended.Checksum, amortised.Checksum)
+	assert.Equal(t, 1, amortised.FileCount)
+	assert.Equal(t, end, amortised.BillingPeriodEnd)
+
+	// The same files and basis are the same batch
+	assert.Equal(t, unblended.Checksum, NewAWSCURIngester(nil, &AWSCURConfig{CostBasis: CostBasisUnblended}).newBatch(start, end, digests).Checksum)
+}
+
+func TestCURBatchAfterRulesChange(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	digests := []string{sha256Hex("daily-00001.csv.gz")}
+	batch := func(node string) *models.IngestionBatch {
+		rules, err := NewMappingRuleSet(MappingRules{Rules: []MappingRule{
+			{Name: "team", Match: MappingMatch{Tags: map[string]string{"team": ".+"}}, Node: MappingTarget{Name: node}},
+		}})
+		require.NoError(t, err)
+		return NewAWSCURIngester(nil, &AWSCURConfig{MappingRules: rules}).newBatch(start, start.AddDate(0, 1, 0), digests)
+	}
+
+	// Importing the same report with edited rules replaces the period's batch
+	// rather than being skipped as already ingested
+	before, after := batch("{tag.team}"), batch("team_{tag.team|lower}")
+	assert.Equal(t, before.Source, after.Source)
+	assert.NotEqual(t, before.Checksum, after.Checksum)
+	assert.Equal(t, before.Checksum, batch("{tag.team}").Checksum)
+	assert.NotEqual(t, before.Checksum, NewAWSCURIngester(nil, nil).newBatch(start, start.AddDate(0, 1, 0), digests).Checksum)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
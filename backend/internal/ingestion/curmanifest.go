@@ -0,0 +1,349 @@
+package ingestion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+)
+
+// CURManifestSuffix ends the name of every CUR and Data Exports manifest
+const CURManifestSuffix = "Manifest.json"
+
+// CURManifest is the manifest AWS delivers with each version of a billing
+// period's report, listing the files that make it up. Legacy CUR manifests
+// list report keys; CUR 2.0 and Data Exports manifests list data file URIs.
+type CURManifest struct {
+	AssemblyID    string           `json:"assemblyId"`
+	ExecutionID   string           `json:"executionId"`
+	Bucket        string           `json:"bucket"`
+	ReportKeys    []string         `json:"reportKeys"`
+	DataFiles     []string         `json:"dataFiles"`
+	BillingPeriod CURBillingPeriod `json:"billingPeriod"`
+}
+
+// CURBillingPeriod is the billing period a manifest covers
+type CURBillingPeriod struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// CURStorage reads the files named by a manifest
+type CURStorage interface {
+	Exists(ctx context.Context, key string) (bool, error)
+	ReadStream(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// IsCURManifest reports whether a file name or key names a CUR manifest
+func IsCURManifest(name string) bool {
+	return strings.HasSuffix(name, CURManifestSuffix)
+}
+
+// ParseCURManifest parses a CUR or Data Exports manifest
+func ParseCURManifest(reader io.Reader) (*CURManifest, error) {
+	var manifest CURManifest
+	if err := json.NewDecoder(reader).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if len(manifest.Keys()) == 0 {
+		return nil, errors.New("manifest lists no report files")
+	}
+	return &manifest, nil
+}
+
+// Keys returns the keys of the manifest's report files, relative to the root of
+// the bucket the report was delivered to
+func (m *CURManifest) Keys() []string {
+	if len(m.ReportKeys) > 0 {
+		return m.ReportKeys
+	}
+
+	keys := make([]string, 0, len(m.DataFiles))
+	for _, file := range m.DataFiles {
+		if rest, ok := strings.CutPrefix(file, "s3://"); ok {
+			_, file, _ = strings.Cut(rest, "/")
+		}
+		keys = append(keys, file)
+	}
+	return keys
+}
+
+// ID returns the identifier of the report version the manifest describes
+func (m *CURManifest) ID() string {
+	if m.AssemblyID != "" {
+		return m.AssemblyID
+	}
+	return m.ExecutionID
+}
+
+// Period returns the start and (exclusive) end of the manifest's billing period
+func (m *CURManifest) Period() (time.Time, time.Time, error) {
+	start, err := parseCURPeriodTime(m.BillingPeriod.Start)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid billing period start: %w", err)
+	}
+	end, err := parseCURPeriodTime(m.BillingPeriod.End)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid billing period end: %w", err)
+	}
+	return start, end, nil
+}
+
+// parseCURPeriodTime parses a billing period bound: legacy manifests use the
+// basic ISO 8601 format (20240101T000000.000Z), Data Exports the extended one
+func parseCURPeriodTime(value string) (time.Time, error) {
+	for _, format := range []string{"20060102T150405.000Z", "20060102T150405Z", time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(format, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse date: %s", value)
+}
+
+// ResolveKeys returns the storage keys of the manifest's report files. Reports
+// are often copied beneath a prefix of another bucket, so a key that does not
+// exist as listed is looked for beneath each directory above the manifest,
+// nearest first.
+func (m *CURManifest) ResolveKeys(ctx context.Context, storage CURStorage, manifestKey string) ([]string, error) {
+	keys := m.Keys()
+	resolved := make([]string, 0, len(keys))
+
+	for _, key := range keys {
+		candidates := []string{key}
+		for dir := path.Dir(manifestKey); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			candidates = append(candidates, path.Join(dir, key))
+		}
+
+		found := false
+		for _, candidate := range candidates {
+			exists, err := storage.Exists(ctx, candidate)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check for report file %s: %w", candidate, err)
+			}
+			if exists {
+				resolved = append(resolved, candidate)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("report file %s not found", key)
+		}
+	}
+
+	return resolved, nil
+}
+
+// IngestManifestFile ingests the report files listed by a manifest on the
+// local filesystem
+func (a *AWSCURIngester) IngestManifestFile(ctx context.Context, manifestPath string) (*IngestionResult, error) {
+	return a.IngestManifest(ctx, localCURStorage{}, filepath.ToSlash(manifestPath))
+}
+
+// IngestManifest ingests every report file listed by a manifest as one batch.
+// Line items are summed across the files, which may split a node's day
+// between them, and the costs are written in a single transaction, so the
+// billing period is imported in full or not at all.
+//
+// Each import of a billing period is recorded as an ingestion batch. AWS
+// restates the current month's report many times, so a batch replaces the
+// costs written by the period's previous batch, including those a restatement
+// no longer has. Importing files whose checksum has already been ingested does
+// nothing.
+func (a *AWSCURIngester) IngestManifest(ctx context.Context, storage CURStorage, manifestKey string) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "aws_cur",
+		StartTime: time.Now(),
+	}
+
+	reader, err := storage.ReadStream(ctx, manifestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	manifest, err := ParseCURManifest(reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, err := manifest.Period()
+	if err != nil {
+		return nil, fmt.Errorf("manifest has no valid billing period: %w", err)
+	}
+
+	keys, err := manifest.ResolveKeys(ctx, storage, manifestKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := newNodeResolver(a.store, "aws_cur", a.createMissingNodes)
+	totals := newCostTotals()
+	digests := make([]string, 0, len(keys))
+
+	for i, key := range keys {
+		a.reportProgress(IngestionProgress{
+			RecordsProcessed: result.RecordsProcessed,
+			RecordsSkipped:   result.RecordsSkipped,
+			CurrentFile:      key,
+			Message:          fmt.Sprintf("Reading %s (%d of %d)", key, i+1, len(keys)),
+		})
+
+		digest, err := a.readManifestFile(ctx, storage, key, resolver, totals, result)
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+
+	batch := a.newBatch(start, end, digests)
+	err = a.store.WithTx(ctx, func(tx *store.Store) error {
+		return a.storeBatch(ctx, tx, batch, totals, result)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store billing period: %w", err)
+	}
+
+	if result.AlreadyIngested {
+		log.Info().
+			Str("manifest", manifestKey).
+			Str("checksum", batch.Checksum).
+			Msg("CUR billing period already ingested, nothing to do")
+	} else {
+		log.Info().
+			Str("manifest", manifestKey).
+			Str("report_id", manifest.ID()).
+			Str("batch_id", batch.ID.String()).
+			Time("billing_period_start", start).
+			Time("billing_period_end", end).
+			Int("files", len(keys)).
+			Int("replaced", result.RecordsReplaced).
+			Msg("Ingested CUR billing period")
+	}
+
+	a.complete(result, manifestKey)
+	return result, nil
+}
+
+// storeBatch records a billing period's costs as an ingestion batch, replacing
+// the period's previous batch, unless a batch with the same checksum has
+// already been ingested. It must be called within a transaction.
+func (a *AWSCURIngester) storeBatch(ctx context.Context, tx *store.Store, batch *models.IngestionBatch, totals *costTotals, result *IngestionResult) error {
+	// Imports of the same period wait for one another, so each sees the batch
+	// the previous one recorded
+	if err := tx.Batches.LockPeriod(ctx, batch.Source, batch.BillingPeriodStart); err != nil {
+		return err
+	}
+
+	existing, err := tx.Batches.GetByChecksum(ctx, batch.Source, batch.Checksum)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		batch.ID = existing.ID
+		result.AlreadyIngested = true
+		return nil
+	}
+
+	if err := tx.Batches.Create(ctx, batch); err != nil {
+		return err
+	}
+	replaced, err := tx.Batches.ReplacePrevious(ctx, batch)
+	if err != nil {
+		return err
+	}
+	result.RecordsReplaced = replaced
+
+	write := func(ctx context.Context, costs []models.NodeCostByDimension) error {
+		return tx.Costs.BulkUpsertBatch(ctx, batch.ID, costs)
+	}
+	if err := writeCostTotals(ctx, write, totals, a.batchSize, result, a.reportProgress); err != nil {
+		return err
+	}
+
+	batch.RecordsProcessed = result.RecordsProcessed
+	batch.RecordsInserted = result.RecordsInserted
+	batch.RecordsSkipped = result.RecordsSkipped
+	return tx.Batches.Complete(ctx, batch)
+}
+
+// readManifestFile reads one of a manifest's report files into totals,
+// returning the SHA-256 digest of its content
+func (a *AWSCURIngester) readManifestFile(ctx context.Context, storage CURStorage, key string, resolver *nodeResolver, totals *costTotals, result *IngestionResult) (string, error) {
+	reader, err := storage.ReadStream(ctx, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to open report file %s: %w", key, err)
+	}
+	defer reader.Close()
+
+	hash := sha256.New()
+	content := io.TeeReader(reader, hash)
+	if err := a.readReport(ctx, content, key+": ", resolver, totals, nil, result); err != nil {
+		return "", fmt.Errorf("failed to read report file %s: %w", key, err)
+	}
+	// Readers may stop short of the end of a file, e.g. at the end of a gzip stream
+	if _, err := io.Copy(io.Discard, content); err != nil {
+		return "", fmt.Errorf("failed to read report file %s: %w", key, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// newBatch returns the batch of a billing period read from report files with
+// digests. Its source is the cost basis's, and its checksum also covers the
+// cost basis and mapping rules, so files imported again under another basis or
+// edited rules are recorded again rather than skipped.
+func (a *AWSCURIngester) newBatch(start, end time.Time, digests []string) *models.IngestionBatch {
+	hash := sha256.New()
+	io.WriteString(hash, "cost_basis:"+string(a.costBasis)+"\n")
+	io.WriteString(hash, "rules:"+a.rules.digest+"\n")
+	io.WriteString(hash, curBatchChecksum(digests)+"\n")
+
+	return &models.IngestionBatch{
+		Source:             a.costBasis.batchSource(),
+		BillingPeriodStart: start,
+		BillingPeriodEnd:   end,
+		Checksum:           hex.EncodeToString(hash.Sum(nil)),
+		FileCount:          len(digests),
+	}
+}
+
+// curBatchChecksum combines the digests of a batch's report files into its
+// checksum. Digests are sorted first, so the checksum does not depend on the
+// order a manifest lists its files in.
+func curBatchChecksum(digests []string) string {
+	sorted := append([]string(nil), digests...)
+	sort.Strings(sorted)
+
+	hash := sha256.New()
+	for _, digest := range sorted {
+		io.WriteString(hash, digest+"\n")
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// localCURStorage reads manifests and report files from the local filesystem
+type localCURStorage struct{}
+
+func (localCURStorage) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(filepath.FromSlash(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (localCURStorage) ReadStream(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(key))
+}
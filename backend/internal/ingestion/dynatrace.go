@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -20,14 +21,40 @@ import (
 type DynatraceIngester struct {
 	store          *store.Store
 	metricMappings map[string]MetricMapping
+	// hourly also records the datapoints aggregated per hour, for peak-based strategies
+	hourly bool
+	// rawSamples also keeps each datapoint at the resolution it was exported in
+	rawSamples bool
+	// entityMapping routes datapoints to nodes by entity; entities indexes the
+	// nodes for it, loaded on first use
+	entityMapping *DynatraceEntityMapping
+	entities      *dynatraceEntityIndex
 }
 
 // MetricMapping defines how a Dynatrace metric maps to internal metrics
 type MetricMapping struct {
-	InternalName string
-	Unit         string
+	InternalName string `mapstructure:"name"`
+	Unit         string `mapstructure:"unit"`
+	// Aggregation combines the metric's datapoints per day and label set, and
+	// a day's label sets when they are read together; empty means sum
+	Aggregation Aggregation `mapstructure:"aggregation"`
 }
 
+// Aggregation is how the datapoints of a metric within a period are combined
+// into a single usage value
+type Aggregation string
+
+const (
+	// AggregationSum totals the datapoints, for counts and time consumed
+	AggregationSum Aggregation = "sum"
+	// AggregationAvg averages the datapoints, for rates and response times
+	AggregationAvg Aggregation = "avg"
+	// AggregationMax takes the largest datapoint, for gauges sized to their peak
+	AggregationMax Aggregation = "max"
+	// AggregationP95 takes the 95th percentile datapoint, by nearest rank
+	AggregationP95 Aggregation = "p95"
+)
+
 // DynatraceExport represents the structure of a Dynatrace metric export file
 type DynatraceExport struct {
 	Timeframe DynatraceTimeframe `json:"timeframe"`
@@ -56,19 +83,12 @@ type DynatraceDataPoint struct {
 // NewDynatraceIngester creates a new Dynatrace ingester with default metric mappings
 func NewDynatraceIngester(store *store.Store) *DynatraceIngester {
 	return &DynatraceIngester{
-		store: store,
-		metricMappings: map[string]MetricMapping{
-			"builtin:service.requestCount.total":   {InternalName: "http_requests", Unit: "count"},
-			"builtin:service.response.time":        {InternalName: "http_duration_ms", Unit: "milliseconds"},
-			"builtin:service.cpu.time":             {InternalName: "cpu_time_ms", Unit: "milliseconds"},
-			"builtin:service.errors.total":         {InternalName: "error_count", Unit: "count"},
-			"builtin:service.dbconnections.success": {InternalName: "db_connections", Unit: "count"},
-			"builtin:service.keyRequest.count":     {InternalName: "key_requests", Unit: "count"},
-		},
+		store:          store,
+		metricMappings: GetDefaultMetricMappings(),
 	}
 }
 
-// AddMetricMapping adds a custom metric mapping
+// AddMetricMapping adds a custom metric mapping whose datapoints are summed
 func (d *DynatraceIngester) AddMetricMapping(dynatraceMetric string, internalName string, unit string) {
 	d.metricMappings[dynatraceMetric] = MetricMapping{
 		InternalName: internalName,
@@ -76,7 +96,56 @@ func (d *DynatraceIngester) AddMetricMapping(dynatraceMetric string, internalNam
 	}
 }
 
-// IngestFile ingests a Dynatrace export file
+// SetMetricMapping adds or replaces a metric mapping, including how its
+// datapoints are aggregated
+func (d *DynatraceIngester) SetMetricMapping(dynatraceMetric string, mapping MetricMapping) error {
+	if err := mapping.validate(dynatraceMetric); err != nil {
+		return err
+	}
+	d.metricMappings[dynatraceMetric] = mapping
+	return nil
+}
+
+// SetMetricMappings adds or replaces several metric mappings, such as those
+// loaded by LoadDynatraceMetricMappings
+func (d *DynatraceIngester) SetMetricMappings(mappings map[string]MetricMapping) error {
+	for dynatraceMetric, mapping := range mappings {
+		if err := d.SetMetricMapping(dynatraceMetric, mapping); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnableHourlyUsage records the datapoints per hour alongside the daily usage,
+// for strategies such as peak_coincident that need hourly granularity. Hourly
+// usage has no labels, so each hour's datapoints are aggregated across all
+// label sets.
+func (d *DynatraceIngester) EnableHourlyUsage() {
+	d.hourly = true
+}
+
+// EnableRawSamples keeps every datapoint at the resolution it was exported in,
+// alongside the aggregated usage, for analyses that need more than daily detail
+func (d *DynatraceIngester) EnableRawSamples() {
+	d.rawSamples = true
+}
+
+// SetEntityMapping routes each datapoint to the node for the entity in one of
+// its dimensions. Datapoints without the dimension are recorded against the
+// node given for the export, if any; those whose entity has no node are
+// skipped and the entity reported in the result.
+func (d *DynatraceIngester) SetEntityMapping(mapping DynatraceEntityMapping) error {
+	if err := mapping.validate(); err != nil {
+		return err
+	}
+	d.entityMapping = &mapping
+	d.entities = nil
+	return nil
+}
+
+// IngestFile ingests a Dynatrace export file. nodeID takes the datapoints not
+// routed by entity, and may be uuid.Nil when an entity mapping is set.
 func (d *DynatraceIngester) IngestFile(ctx context.Context, filePath string, nodeID uuid.UUID) (*IngestionResult, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -87,7 +156,7 @@ func (d *DynatraceIngester) IngestFile(ctx context.Context, filePath string, nod
 	return d.IngestReader(ctx, file, nodeID)
 }
 
-// IngestReader ingests Dynatrace metrics from a reader
+// IngestReader ingests Dynatrace metrics from a reader, as IngestFile does
 func (d *DynatraceIngester) IngestReader(ctx context.Context, reader io.Reader, nodeID uuid.UUID) (*IngestionResult, error) {
 	var export DynatraceExport
 	if err := json.NewDecoder(reader).Decode(&export); err != nil {
@@ -97,7 +166,9 @@ func (d *DynatraceIngester) IngestReader(ctx context.Context, reader io.Reader,
 	return d.processExport(ctx, &export, nodeID)
 }
 
-// IngestDirectory ingests all Dynatrace export files from a directory
+// IngestDirectory ingests all Dynatrace export files from a directory. Files are
+// matched to nodes by name; with an entity mapping, unmatched files are routed
+// by entity alone.
 func (d *DynatraceIngester) IngestDirectory(ctx context.Context, dirPath string, nodeMapping map[string]uuid.UUID) (*IngestionResult, error) {
 	result := &IngestionResult{
 		Source:    "dynatrace",
@@ -117,7 +188,7 @@ func (d *DynatraceIngester) IngestDirectory(ctx context.Context, dirPath string,
 			// Try without extension
 			nameWithoutExt := baseName[:len(baseName)-len(filepath.Ext(baseName))]
 			nodeID, ok = nodeMapping[nameWithoutExt]
-			if !ok {
+			if !ok && d.entityMapping == nil {
 				log.Warn().Str("file", file).Msg("No node mapping found for file, skipping")
 				result.Errors = append(result.Errors, fmt.Sprintf("no node mapping for %s", baseName))
 				continue
@@ -135,6 +206,15 @@ func (d *DynatraceIngester) IngestDirectory(ctx context.Context, dirPath string,
 		result.RecordsInserted += fileResult.RecordsInserted
 		result.RecordsUpdated += fileResult.RecordsUpdated
 		result.RecordsSkipped += fileResult.RecordsSkipped
+		result.UnmappedEntities = append(result.UnmappedEntities, fileResult.UnmappedEntities...)
+	}
+
+	if len(result.UnmappedEntities) > 0 {
+		unmapped := make(map[string]bool)
+		for _, entity := range result.UnmappedEntities {
+			unmapped[entity] = true
+		}
+		result.UnmappedEntities = sortedKeys(unmapped)
 	}
 
 	result.EndTime = time.Now()
@@ -150,7 +230,109 @@ func (d *DynatraceIngester) processExport(ctx context.Context, export *Dynatrace
 		StartTime: time.Now(),
 	}
 
-	var usages []models.NodeUsageByDimension
+	router, err := d.router(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := d.aggregateExport(export, router)
+	result.RecordsProcessed = usage.processed
+	result.RecordsSkipped = usage.skipped
+	result.UnmappedEntities = usage.unmapped
+	if len(usage.unmapped) > 0 {
+		log.Warn().
+			Strs("entities", usage.unmapped).
+			Int("skipped", usage.skipped).
+			Msg("No node found for Dynatrace entities, skipping their datapoints")
+	}
+
+	// Bulk insert the usage records
+	if len(usage.daily) > 0 {
+		if err := d.store.Usage.BulkUpsertWithLabels(ctx, usage.daily); err != nil {
+			return nil, fmt.Errorf("failed to store usage records: %w", err)
+		}
+		result.RecordsInserted = len(usage.daily)
+	}
+
+	if len(usage.hourly) > 0 {
+		if err := d.store.Usage.BulkUpsertHourly(ctx, usage.hourly); err != nil {
+			return nil, fmt.Errorf("failed to store hourly usage records: %w", err)
+		}
+		result.RecordsInserted += len(usage.hourly)
+	}
+
+	for start := 0; start < len(usage.samples); start += dynatraceSampleBatchSize {
+		end := min(start+dynatraceSampleBatchSize, len(usage.samples))
+		if err := d.store.Usage.BulkUpsertSamples(ctx, usage.samples[start:end]); err != nil {
+			return nil, fmt.Errorf("failed to store usage samples: %w", err)
+		}
+	}
+	result.RecordsInserted += len(usage.samples)
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	log.Info().
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Int("hourly", len(usage.hourly)).
+		Int("samples", len(usage.samples)).
+		Dur("duration", result.Duration).
+		Msg("Dynatrace ingestion completed")
+
+	return result, nil
+}
+
+// router returns the router for an export whose datapoints without an entity
+// go to nodeID, loading the nodes for the entity mapping on first use
+func (d *DynatraceIngester) router(ctx context.Context, nodeID uuid.UUID) (dynatraceRouter, error) {
+	if d.entityMapping == nil {
+		if nodeID == uuid.Nil {
+			return dynatraceRouter{}, fmt.Errorf("a node ID or entity mapping is required")
+		}
+		return dynatraceRouter{defaultNode: nodeID}, nil
+	}
+
+	if d.entities == nil {
+		nodes, err := d.store.Nodes.List(ctx, store.NodeFilters{})
+		if err != nil {
+			return dynatraceRouter{}, fmt.Errorf("failed to list nodes for entity mapping: %w", err)
+		}
+		entities, err := newDynatraceEntityIndex(*d.entityMapping, nodes)
+		if err != nil {
+			return dynatraceRouter{}, fmt.Errorf("invalid entity mapping: %w", err)
+		}
+		d.entities = entities
+	}
+	return dynatraceRouter{entities: d.entities, defaultNode: nodeID}, nil
+}
+
+// dynatraceSampleBatchSize is the number of raw samples written per statement
+const dynatraceSampleBatchSize = 1000
+
+// exportUsage is the usage recorded from a Dynatrace export or Prometheus
+// query result
+type exportUsage struct {
+	daily     []models.NodeUsageByDimension
+	hourly    []models.NodeUsageHourly
+	samples   []models.NodeUsageSample
+	processed int
+	// skipped counts datapoints without a node, and unmapped lists the
+	// entities they named
+	skipped  int
+	unmapped []string
+}
+
+// aggregateExport aggregates an export's datapoints per node, day, metric and
+// label set using each metric's aggregation, and per hour and at their
+// exported resolution when enabled
+func (d *DynatraceIngester) aggregateExport(export *DynatraceExport, router dynatraceRouter) *exportUsage {
+	usage := &exportUsage{}
+	daily := make(usageSeriesSet)
+	hourly := make(usageSeriesSet)
+	samples := make(map[usageSeriesKey]models.NodeUsageSample)
+	unmapped := make(map[string]bool)
 
 	for _, metric := range export.Metrics {
 		mapping, ok := d.metricMappings[metric.MetricID]
@@ -160,51 +342,181 @@ func (d *DynatraceIngester) processExport(ctx context.Context, export *Dynatrace
 		}
 
 		for _, dataPoint := range metric.Data {
+			labels := labelSetKey(dataPoint.Dimensions)
+			nodeID, entity, routed := router.route(dataPoint.Dimensions)
+			if entity != "" {
+				unmapped[entity] = true
+			}
+
 			for i, timestamp := range dataPoint.Timestamps {
 				if i >= len(dataPoint.Values) {
 					continue
 				}
 
-				result.RecordsProcessed++
+				usage.processed++
+				if !routed {
+					usage.skipped++
+					continue
+				}
 
 				// Convert timestamp (milliseconds) to time
-				usageDate := time.Unix(timestamp/1000, (timestamp%1000)*1000000).UTC()
+				sampledAt := time.UnixMilli(timestamp).UTC()
+				value := decimal.NewFromFloat(dataPoint.Values[i])
+
 				// Truncate to day for daily aggregation
-				usageDate = time.Date(usageDate.Year(), usageDate.Month(), usageDate.Day(), 0, 0, 0, 0, time.UTC)
-
-				usage := models.NodeUsageByDimension{
-					NodeID:    nodeID,
-					UsageDate: usageDate,
-					Metric:    mapping.InternalName,
-					Value:     decimal.NewFromFloat(dataPoint.Values[i]),
-					Unit:      mapping.Unit,
-					Labels:    dataPoint.Dimensions,
-					Source:    "dynatrace",
+				day := time.Date(sampledAt.Year(), sampledAt.Month(), sampledAt.Day(), 0, 0, 0, 0, time.UTC)
+				daily.add(usageSeriesKey{nodeID: nodeID, period: day, metric: mapping.InternalName, labels: labels}, mapping, dataPoint.Dimensions, value)
+
+				if d.hourly {
+					hourly.add(usageSeriesKey{nodeID: nodeID, period: sampledAt.Truncate(time.Hour), metric: mapping.InternalName}, mapping, nil, value)
 				}
 
-				usages = append(usages, usage)
+				if d.rawSamples {
+					// A repeated datapoint replaces the earlier one
+					samples[usageSeriesKey{nodeID: nodeID, period: sampledAt, metric: mapping.InternalName, labels: labels}] = models.NodeUsageSample{
+						NodeID:    nodeID,
+						SampledAt: sampledAt,
+						Metric:    mapping.InternalName,
+						Labels:    dataPoint.Dimensions,
+						Value:     value,
+						Unit:      mapping.Unit,
+						Source:    "dynatrace",
+					}
+				}
 			}
 		}
 	}
 
-	// Bulk insert the usage records
-	if len(usages) > 0 {
-		if err := d.store.Usage.BulkUpsertWithLabels(ctx, usages); err != nil {
-			return nil, fmt.Errorf("failed to store usage records: %w", err)
-		}
-		result.RecordsInserted = len(usages)
+	for _, series := range daily.sorted() {
+		usage.daily = append(usage.daily, models.NodeUsageByDimension{
+			NodeID:      series.key.nodeID,
+			UsageDate:   series.key.period,
+			Metric:      series.key.metric,
+			Value:       series.value(),
+			Unit:        series.mapping.Unit,
+			Labels:      series.labels,
+			Source:      "dynatrace",
+			Aggregation: string(series.mapping.Aggregation),
+		})
 	}
 
-	result.EndTime = time.Now()
-	result.Duration = result.EndTime.Sub(result.StartTime)
+	for _, series := range hourly.sorted() {
+		usage.hourly = append(usage.hourly, models.NodeUsageHourly{
+			NodeID:    series.key.nodeID,
+			UsageHour: series.key.period,
+			Metric:    series.key.metric,
+			Value:     series.value(),
+			Unit:      series.mapping.Unit,
+			Source:    "dynatrace",
+		})
+	}
 
-	log.Info().
-		Int("processed", result.RecordsProcessed).
-		Int("inserted", result.RecordsInserted).
-		Dur("duration", result.Duration).
-		Msg("Dynatrace ingestion completed")
+	keys := make([]usageSeriesKey, 0, len(samples))
+	for key := range samples {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	for _, key := range keys {
+		usage.samples = append(usage.samples, samples[key])
+	}
+	usage.unmapped = sortedKeys(unmapped)
 
-	return result, nil
+	return usage
+}
+
+// usageSeriesKey identifies the datapoints aggregated into one usage record
+type usageSeriesKey struct {
+	nodeID uuid.UUID
+	period time.Time
+	metric string
+	labels string
+}
+
+// less orders keys by node, period, metric and label set
+func (k usageSeriesKey) less(other usageSeriesKey) bool {
+	if k.nodeID != other.nodeID {
+		return k.nodeID.String() < other.nodeID.String()
+	}
+	if !k.period.Equal(other.period) {
+		return k.period.Before(other.period)
+	}
+	if k.metric != other.metric {
+		return k.metric < other.metric
+	}
+	return k.labels < other.labels
+}
+
+// usageSeries holds the datapoints aggregated into one usage record
+type usageSeries struct {
+	key     usageSeriesKey
+	mapping MetricMapping
+	labels  map[string]string
+	values  []decimal.Decimal
+}
+
+// value aggregates the series' datapoints
+func (s *usageSeries) value() decimal.Decimal {
+	return s.mapping.Aggregation.apply(s.values)
+}
+
+// usageSeriesSet groups datapoints by node, period, metric and label set
+type usageSeriesSet map[usageSeriesKey]*usageSeries
+
+// add adds a datapoint to its series
+func (s usageSeriesSet) add(key usageSeriesKey, mapping MetricMapping, labels map[string]string, value decimal.Decimal) {
+	series, ok := s[key]
+	if !ok {
+		series = &usageSeries{key: key, mapping: mapping, labels: labels}
+		s[key] = series
+	}
+	series.values = append(series.values, value)
+}
+
+// sorted returns the series ordered by key, so records are written in a
+// consistent order
+func (s usageSeriesSet) sorted() []*usageSeries {
+	series := make([]*usageSeries, 0, len(s))
+	for _, entry := range s {
+		series = append(series, entry)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].key.less(series[j].key) })
+	return series
+}
+
+// labelSetKey identifies a set of labels regardless of their order
+func labelSetKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	// Maps are encoded with their keys sorted
+	key, _ := json.Marshal(labels)
+	return string(key)
+}
+
+// valid reports whether the aggregation is known, empty meaning sum
+func (a Aggregation) valid() bool {
+	switch a {
+	case "", AggregationSum, AggregationAvg, AggregationMax, AggregationP95:
+		return true
+	}
+	return false
+}
+
+// apply combines a period's datapoints, of which there is at least one
+func (a Aggregation) apply(values []decimal.Decimal) decimal.Decimal {
+	switch a {
+	case AggregationAvg:
+		return decimal.Sum(values[0], values[1:]...).Div(decimal.NewFromInt(int64(len(values))))
+	case AggregationMax:
+		return decimal.Max(values[0], values[1:]...)
+	case AggregationP95:
+		sorted := append([]decimal.Decimal(nil), values...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].LessThan(sorted[j]) })
+		// The nearest rank is the smallest at or above 95% of the datapoints
+		return sorted[(95*len(sorted)+99)/100-1]
+	default:
+		return decimal.Sum(values[0], values[1:]...)
+	}
 }
 
 // IngestionResult represents the result of an ingestion operation
@@ -217,18 +529,65 @@ type IngestionResult struct {
 	RecordsInserted  int           `json:"records_inserted"`
 	RecordsUpdated   int           `json:"records_updated"`
 	RecordsSkipped   int           `json:"records_skipped"`
+	RecordsReplaced  int           `json:"records_replaced,omitempty"`
+	AlreadyIngested  bool          `json:"already_ingested,omitempty"`
+	UnmappedEntities []string      `json:"unmapped_entities,omitempty"`
+	RejectedFile     string        `json:"rejected_file,omitempty"`
 	Errors           []string      `json:"errors,omitempty"`
 }
 
+// validate checks a mapping names its internal metric and unit, and a known aggregation
+func (m MetricMapping) validate(dynatraceMetric string) error {
+	if m.InternalName == "" || m.Unit == "" {
+		return fmt.Errorf("metric mapping for %s needs an internal name and unit", dynatraceMetric)
+	}
+	if !m.Aggregation.valid() {
+		return fmt.Errorf("unknown aggregation %q for metric %s", m.Aggregation, dynatraceMetric)
+	}
+	return nil
+}
+
+// LoadDynatraceMetricMappings loads metric mappings from a YAML file, keyed by
+// Dynatrace metric ID, which add to or replace the defaults:
+//
+//	metrics:
+//	  custom:pool.active:
+//	    name: pool_connections
+//	    unit: count
+//	    aggregation: max
+func LoadDynatraceMetricMappings(path string) (map[string]MetricMapping, error) {
+	var file struct {
+		Metrics map[string]MetricMapping `mapstructure:"metrics"`
+	}
+	if err := readRulesFile(path, &file); err != nil {
+		return nil, err
+	}
+	if len(file.Metrics) == 0 {
+		return nil, fmt.Errorf("metric mappings file %s has no metrics", path)
+	}
+
+	ids := make([]string, 0, len(file.Metrics))
+	for id := range file.Metrics {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := file.Metrics[id].validate(id); err != nil {
+			return nil, err
+		}
+	}
+	return file.Metrics, nil
+}
+
 // GetDefaultMetricMappings returns the default Dynatrace metric mappings
 func GetDefaultMetricMappings() map[string]MetricMapping {
 	return map[string]MetricMapping{
-		"builtin:service.requestCount.total":   {InternalName: "http_requests", Unit: "count"},
-		"builtin:service.response.time":        {InternalName: "http_duration_ms", Unit: "milliseconds"},
-		"builtin:service.cpu.time":             {InternalName: "cpu_time_ms", Unit: "milliseconds"},
-		"builtin:service.errors.total":         {InternalName: "error_count", Unit: "count"},
-		"builtin:service.dbconnections.success": {InternalName: "db_connections", Unit: "count"},
-		"builtin:service.keyRequest.count":     {InternalName: "key_requests", Unit: "count"},
+		"builtin:service.requestCount.total":    {InternalName: "http_requests", Unit: "count", Aggregation: AggregationSum},
+		"builtin:service.response.time":         {InternalName: "http_duration_ms", Unit: "milliseconds", Aggregation: AggregationAvg},
+		"builtin:service.cpu.time":              {InternalName: "cpu_time_ms", Unit: "milliseconds", Aggregation: AggregationSum},
+		"builtin:service.errors.total":          {InternalName: "error_count", Unit: "count", Aggregation: AggregationSum},
+		"builtin:service.dbconnections.success": {InternalName: "db_connections", Unit: "count", Aggregation: AggregationSum},
+		"builtin:service.keyRequest.count":      {InternalName: "key_requests", Unit: "count", Aggregation: AggregationSum},
 	}
 }
 
@@ -0,0 +1,197 @@
+package ingestion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decimals(values ...float64) []decimal.Decimal {
+	result := make([]decimal.Decimal, len(values))
+	for i, value := range values {
+		result[i] = decimal.NewFromFloat(value)
+	}
+	return result
+}
+
+func TestAggregationApply(t *testing.T) {
+	values := decimals(4, 1, 3, 2)
+	assert.Equal(t, "10", AggregationSum.apply(values).String())
+	assert.Equal(t, "10", Aggregation("").apply(values).String())
+	assert.Equal(t, "2.5", AggregationAvg.apply(values).String())
+	assert.Equal(t, "4", AggregationMax.apply(values).String())
+	assert.Equal(t, "4", AggregationP95.apply(values).String())
+	assert.Equal(t, "4 1 3 2", decimalStrings(values), "values are not reordered")
+
+	// The 95th percentile of 1..100 is the 95th value, and of 1..20 the 19th
+	var hundred, twenty []float64
+	for i := 1; i <= 100; i++ {
+		hundred = append(hundred, float64(i))
+		if i <= 20 {
+			twenty = append(twenty, float64(i))
+		}
+	}
+	assert.Equal(t, "95", AggregationP95.apply(decimals(hundred...)).String())
+	assert.Equal(t, "19", AggregationP95.apply(decimals(twenty...)).String())
+	assert.Equal(t, "7", AggregationP95.apply(decimals(7)).String())
+}
+
+func decimalStrings(values []decimal.Decimal) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = value.String()
+	}
+	return strings.Join(parts, " ")
+}
+
+func TestDynatraceAggregateExport(t *testing.T) {
+	ingester := NewDynatraceIngester(nil)
+	require.NoError(t, ingester.SetMetricMapping("custom:pool.size", MetricMapping{InternalName: "pool_size", Unit: "count", Aggregation: AggregationMax}))
+	ingester.EnableHourlyUsage()
+	ingester.EnableRawSamples()
+
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	at := func(hour, minute int) int64 {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute).UnixMilli()
+	}
+	nodeID := uuid.New()
+
+	export := &DynatraceExport{Metrics: []DynatraceMetric{
+		{MetricID: "builtin:service.requestCount.total", Data: []DynatraceDataPoint{
+			{Dimensions: map[string]string{"customer_id": "c1", "region": "eu"}, Timestamps: []int64{at(9, 0), at(9, 30), at(10, 0)}, Values: []float64{10, 20, 30}},
+			{Dimensions: map[string]string{"region": "eu", "customer_id": "c1"}, Timestamps: []int64{at(11, 0)}, Values: []float64{40}},
+			{Dimensions: map[string]string{"customer_id": "c2"}, Timestamps: []int64{at(9, 0)}, Values: []float64{5}},
+		}},
+		{MetricID: "builtin:service.response.time", Data: []DynatraceDataPoint{
+			{Dimensions: map[string]string{"customer_id": "c1"}, Timestamps: []int64{at(9, 0), at(9, 30), at(23, 59)}, Values: []float64{100, 200, 600}},
+			{Dimensions: map[string]string{"customer_id": "c2"}, Timestamps: []int64{at(9, 0)}, Values: []float64{300}},
+		}},
+		{MetricID: "custom:pool.size", Data: []DynatraceDataPoint{
+			// A datapoint without a value is ignored; the next day is its own record
+			{Timestamps: []int64{at(1, 0), at(2, 0), at(25, 0), at(3, 0)}, Values: []float64{8, 12, 3}},
+		}},
+		{MetricID: "custom:unmapped", Data: []DynatraceDataPoint{
+			{Timestamps: []int64{at(1, 0)}, Values: []float64{1}},
+		}},
+	}}
+
+	usage := ingester.aggregateExport(export, dynatraceRouter{defaultNode: nodeID})
+	assert.Equal(t, 12, usage.processed)
+
+	type daily struct {
+		date   string
+		metric string
+		labels map[string]string
+		value  string
+	}
+	var got []daily
+	aggregations := make(map[string]string)
+	for _, record := range usage.daily {
+		assert.Equal(t, nodeID, record.NodeID)
+		assert.Equal(t, "dynatrace", record.Source)
+		got = append(got, daily{record.UsageDate.Format("2006-01-02"), record.Metric, record.Labels, record.Value.String()})
+		aggregations[record.Metric] = record.Aggregation
+	}
+	// One record per day, metric and label set, however the labels are ordered
+	assert.Equal(t, []daily{
+		{"2024-01-15", "http_duration_ms", map[string]string{"customer_id": "c1"}, "300"},
+		{"2024-01-15", "http_duration_ms", map[string]string{"customer_id": "c2"}, "300"},
+		{"2024-01-15", "http_requests", map[string]string{"customer_id": "c1", "region": "eu"}, "100"},
+		{"2024-01-15", "http_requests", map[string]string{"customer_id": "c2"}, "5"},
+		{"2024-01-15", "pool_size", nil, "12"},
+		{"2024-01-16", "pool_size", nil, "3"},
+	}, got)
+	// Records keep their metric's aggregation, so label sets are read back with it
+	assert.Equal(t, map[string]string{"http_duration_ms": "avg", "http_requests": "sum", "pool_size": "max"}, aggregations)
+
+	// Hourly usage aggregates each hour's datapoints across all label sets
+	hourly := make(map[string]string)
+	for _, record := range usage.hourly {
+		hourly[record.UsageHour.Format("02T15")+" "+record.Metric] = record.Value.String()
+	}
+	assert.Equal(t, "35", hourly["15T09 http_requests"])
+	assert.Equal(t, "30", hourly["15T10 http_requests"])
+	assert.Equal(t, "200", hourly["15T09 http_duration_ms"], "the average of c1's 100 and 200 and c2's 300")
+	assert.Equal(t, "600", hourly["15T23 http_duration_ms"])
+	assert.Equal(t, "3", hourly["16T01 pool_size"])
+	assert.Len(t, usage.hourly, 8)
+
+	// Raw samples keep every datapoint, in time order
+	require.Len(t, usage.samples, 12)
+	assert.Equal(t, "pool_size", usage.samples[0].Metric)
+	assert.Equal(t, day.Add(time.Hour), usage.samples[0].SampledAt)
+	assert.Equal(t, "8", usage.samples[0].Value.String())
+	assert.Equal(t, day.Add(25*time.Hour), usage.samples[11].SampledAt)
+}
+
+func TestDynatraceAggregateExportWithoutOptions(t *testing.T) {
+	ingester := NewDynatraceIngester(nil)
+	export := &DynatraceExport{Metrics: []DynatraceMetric{
+		{MetricID: "builtin:service.cpu.time", Data: []DynatraceDataPoint{
+			{Timestamps: []int64{1705309200000, 1705312800000}, Values: []float64{1.5, 2.5}},
+		}},
+	}}
+
+	usage := ingester.aggregateExport(export, dynatraceRouter{defaultNode: uuid.New()})
+	require.Len(t, usage.daily, 1)
+	assert.Equal(t, "4", usage.daily[0].Value.String())
+	assert.Empty(t, usage.hourly)
+	assert.Empty(t, usage.samples)
+}
+
+func TestSetMetricMapping(t *testing.T) {
+	ingester := NewDynatraceIngester(nil)
+	assert.NoError(t, ingester.SetMetricMapping("custom:latency", MetricMapping{InternalName: "latency_ms", Unit: "milliseconds", Aggregation: AggregationP95}))
+	assert.Equal(t, AggregationP95, ingester.metricMappings["custom:latency"].Aggregation)
+
+	assert.ErrorContains(t, ingester.SetMetricMapping("custom:latency", MetricMapping{InternalName: "latency_ms", Unit: "milliseconds", Aggregation: "median"}), `unknown aggregation "median"`)
+	assert.ErrorContains(t, ingester.SetMetricMapping("custom:latency", MetricMapping{Unit: "milliseconds"}), "needs an internal name and unit")
+
+	assert.Equal(t, AggregationAvg, GetDefaultMetricMappings()["builtin:service.response.time"].Aggregation)
+}
+
+func TestLoadDynatraceMetricMappings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+metrics:
+  custom:Pool.Active:
+    name: pool_connections
+    unit: count
+    aggregation: max
+  builtin:service.requestCount.total:
+    name: requests
+    unit: count
+`), 0o644))
+
+	mappings, err := LoadDynatraceMetricMappings(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]MetricMapping{
+		"custom:Pool.Active":                 {InternalName: "pool_connections", Unit: "count", Aggregation: AggregationMax},
+		"builtin:service.requestCount.total": {InternalName: "requests", Unit: "count"},
+	}, mappings)
+
+	ingester := NewDynatraceIngester(nil)
+	require.NoError(t, ingester.SetMetricMappings(mappings))
+	assert.Equal(t, "requests", ingester.metricMappings["builtin:service.requestCount.total"].InternalName)
+	assert.Equal(t, AggregationAvg, ingester.metricMappings["builtin:service.response.time"].Aggregation, "defaults are kept")
+
+	require.NoError(t, os.WriteFile(path, []byte("metrics:\n  custom:latency:\n    name: latency_ms\n    unit: ms\n    aggregation: median\n"), 0o644))
+	_, err = LoadDynatraceMetricMappings(path)
+	assert.ErrorContains(t, err, `unknown aggregation "median"`)
+
+	require.NoError(t, os.WriteFile(path, []byte("metrics: {}\n"), 0o644))
+	_, err = LoadDynatraceMetricMappings(path)
+	assert.ErrorContains(t, err, "has no metrics")
+
+	// The documented example is valid
+	mappings, err = LoadDynatraceMetricMappings(filepath.Join("..", "..", "docs", "dynatrace-metrics.yaml"))
+	require.NoError(t, err)
+	assert.Len(t, mappings, 3)
+}
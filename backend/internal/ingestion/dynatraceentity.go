@@ -0,0 +1,177 @@
+package ingestion
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+)
+
+// DynatraceEntityMetadataKey is the node metadata key holding the Dynatrace
+// entity ID, or list of IDs, whose usage is recorded against the node
+const DynatraceEntityMetadataKey = "dynatrace_entity_id"
+
+// DynatraceEntityMapping routes each datapoint to the node for the entity in
+// one of its dimensions, rather than to a single node for the whole export.
+// Entity IDs and names are matched ignoring case.
+type DynatraceEntityMapping struct {
+	// Dimension holds the entity ID, such as dt.entity.service
+	Dimension string
+	// Nodes maps entity IDs or names to node names or IDs, and is tried first
+	Nodes map[string]string
+	// MatchMetadata matches entity IDs to the dynatrace_entity_id in node metadata
+	MatchMetadata bool
+	// MatchNames matches entity names, from the <Dimension>.name dimension that
+	// Dynatrace adds when names are requested, to node names
+	MatchNames bool
+}
+
+// nameDimension is the dimension holding the entity's display name
+func (m DynatraceEntityMapping) nameDimension() string {
+	return m.Dimension + ".name"
+}
+
+// validate checks the mapping names a dimension and a way to find nodes
+func (m DynatraceEntityMapping) validate() error {
+	if m.Dimension == "" {
+		return fmt.Errorf("entity mapping needs a dimension")
+	}
+	if len(m.Nodes) == 0 && !m.MatchMetadata && !m.MatchNames {
+		return fmt.Errorf("entity mapping for %s needs nodes or a way to match them", m.Dimension)
+	}
+	return nil
+}
+
+// dynatraceEntityIndex finds the node for an entity among the nodes in the graph
+type dynatraceEntityIndex struct {
+	mapping DynatraceEntityMapping
+	// explicit, byEntityID and byName are keyed by lower case ID or name
+	explicit   map[string]uuid.UUID
+	byEntityID map[string]uuid.UUID
+	byName     map[string]uuid.UUID
+}
+
+// newDynatraceEntityIndex indexes nodes for a mapping. Every node the mapping
+// names explicitly must exist.
+func newDynatraceEntityIndex(mapping DynatraceEntityMapping, nodes []models.CostNode) (*dynatraceEntityIndex, error) {
+	index := &dynatraceEntityIndex{
+		mapping:    mapping,
+		explicit:   make(map[string]uuid.UUID),
+		byEntityID: make(map[string]uuid.UUID),
+		byName:     make(map[string]uuid.UUID),
+	}
+
+	nodesByName := make(map[string]uuid.UUID, len(nodes))
+	nodeIDs := make(map[uuid.UUID]bool, len(nodes))
+	for _, node := range nodes {
+		nodeIDs[node.ID] = true
+		// Nodes are listed by name, so the first of names differing only in case wins
+		if _, ok := nodesByName[strings.ToLower(node.Name)]; !ok {
+			nodesByName[strings.ToLower(node.Name)] = node.ID
+		}
+		if mapping.MatchMetadata {
+			for _, entityID := range metadataEntityIDs(node.Metadata[DynatraceEntityMetadataKey]) {
+				index.byEntityID[strings.ToLower(entityID)] = node.ID
+			}
+		}
+	}
+	if mapping.MatchNames {
+		index.byName = nodesByName
+	}
+
+	for entity, target := range mapping.Nodes {
+		nodeID, err := uuid.Parse(target)
+		if err != nil || !nodeIDs[nodeID] {
+			var ok bool
+			if nodeID, ok = nodesByName[strings.ToLower(target)]; !ok {
+				return nil, fmt.Errorf("entity %s is mapped to unknown node %s", entity, target)
+			}
+		}
+		index.explicit[strings.ToLower(entity)] = nodeID
+	}
+
+	return index, nil
+}
+
+// metadataEntityIDs reads the entity IDs from a node's metadata value, a string
+// or list of strings
+func metadataEntityIDs(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		if v != "" {
+			return []string{v}
+		}
+	case []interface{}:
+		var ids []string
+		for _, item := range v {
+			if id, ok := item.(string); ok && id != "" {
+				ids = append(ids, id)
+			}
+		}
+		return ids
+	case []string:
+		return v
+	}
+	return nil
+}
+
+// node finds the node for a datapoint's entity. It returns false with the
+// entity, described by its ID and name, when no node matches, and false with
+// an empty entity when the datapoint names none.
+func (x *dynatraceEntityIndex) node(dimensions map[string]string) (uuid.UUID, string, bool) {
+	entityID := dimensions[x.mapping.Dimension]
+	entityName := dimensions[x.mapping.nameDimension()]
+	if entityID == "" {
+		return uuid.Nil, "", false
+	}
+
+	if nodeID, ok := x.explicit[strings.ToLower(entityID)]; ok {
+		return nodeID, "", true
+	}
+	if nodeID, ok := x.explicit[strings.ToLower(entityName)]; ok && entityName != "" {
+		return nodeID, "", true
+	}
+	if nodeID, ok := x.byEntityID[strings.ToLower(entityID)]; ok {
+		return nodeID, "", true
+	}
+	if nodeID, ok := x.byName[strings.ToLower(entityName)]; ok && entityName != "" {
+		return nodeID, "", true
+	}
+
+	if entityName != "" {
+		return uuid.Nil, fmt.Sprintf("%s (%s)", entityID, entityName), false
+	}
+	return uuid.Nil, entityID, false
+}
+
+// dynatraceRouter picks the node each datapoint is recorded against
+type dynatraceRouter struct {
+	// entities finds nodes by entity, nil when the ingester has no entity mapping
+	entities *dynatraceEntityIndex
+	// defaultNode takes datapoints without an entity; uuid.Nil when there is none
+	defaultNode uuid.UUID
+}
+
+// route returns the datapoint's node. It returns false, with the entity when
+// the datapoint names one, when the datapoint has no node.
+func (r dynatraceRouter) route(dimensions map[string]string) (uuid.UUID, string, bool) {
+	if r.entities != nil {
+		nodeID, entity, ok := r.entities.node(dimensions)
+		if ok || entity != "" {
+			return nodeID, entity, ok
+		}
+	}
+	return r.defaultNode, "", r.defaultNode != uuid.Nil
+}
+
+// sortedKeys returns a set's members in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
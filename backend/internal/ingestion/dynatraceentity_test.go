@@ -0,0 +1,103 @@
+package ingestion
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDynatraceEntityIndex(t *testing.T) {
+	checkout := models.CostNode{ID: uuid.New(), Name: "checkout", Metadata: map[string]interface{}{DynatraceEntityMetadataKey: "SERVICE-0001"}}
+	search := models.CostNode{ID: uuid.New(), Name: "Search", Metadata: map[string]interface{}{DynatraceEntityMetadataKey: []interface{}{"SERVICE-0002", "SERVICE-0003"}}}
+	payments := models.CostNode{ID: uuid.New(), Name: "payments"}
+	nodes := []models.CostNode{checkout, search, payments}
+
+	index, err := newDynatraceEntityIndex(DynatraceEntityMapping{
+		Dimension:     "dt.entity.service",
+		Nodes:         map[string]string{"service-0009": "payments", "legacy-billing": payments.ID.String()},
+		MatchMetadata: true,
+		MatchNames:    true,
+	}, nodes)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		dimensions map[string]string
+		node       uuid.UUID
+		entity     string
+	}{
+		{name: "explicit ID", dimensions: map[string]string{"dt.entity.service": "SERVICE-0009"}, node: payments.ID},
+		{name: "explicit name", dimensions: map[string]string{"dt.entity.service": "SERVICE-0010", "dt.entity.service.name": "Legacy-Billing"}, node: payments.ID},
+		{name: "metadata", dimensions: map[string]string{"dt.entity.service": "SERVICE-0001", "dt.entity.service.name": "search"}, node: checkout.ID},
+		{name: "metadata list", dimensions: map[string]string{"dt.entity.service": "SERVICE-0003"}, node: search.ID},
+		{name: "node name", dimensions: map[string]string{"dt.entity.service": "SERVICE-0004", "dt.entity.service.name": "search"}, node: search.ID},
+		{name: "unmapped", dimensions: map[string]string{"dt.entity.service": "SERVICE-0005", "dt.entity.service.name": "inventory"}, entity: "SERVICE-0005 (inventory)"},
+		{name: "unmapped without name", dimensions: map[string]string{"dt.entity.service": "SERVICE-0006"}, entity: "SERVICE-0006"},
+		{name: "no entity", dimensions: map[string]string{"customer_id": "c1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, entity, ok := index.node(tt.dimensions)
+			assert.Equal(t, tt.node, node)
+			assert.Equal(t, tt.entity, entity)
+			assert.Equal(t, tt.node != uuid.Nil, ok)
+		})
+	}
+
+	// Matching by metadata and name is optional
+	index, err = newDynatraceEntityIndex(DynatraceEntityMapping{Dimension: "dt.entity.service", MatchMetadata: true}, nodes)
+	require.NoError(t, err)
+	_, entity, ok := index.node(map[string]string{"dt.entity.service": "SERVICE-0004", "dt.entity.service.name": "search"})
+	assert.False(t, ok)
+	assert.Equal(t, "SERVICE-0004 (search)", entity)
+
+	_, err = newDynatraceEntityIndex(DynatraceEntityMapping{Dimension: "dt.entity.service", Nodes: map[string]string{"SERVICE-0001": "orders"}}, nodes)
+	assert.ErrorContains(t, err, "entity SERVICE-0001 is mapped to unknown node orders")
+}
+
+func TestDynatraceEntityMappingValidate(t *testing.T) {
+	ingester := NewDynatraceIngester(nil)
+	assert.ErrorContains(t, ingester.SetEntityMapping(DynatraceEntityMapping{MatchNames: true}), "needs a dimension")
+	assert.ErrorContains(t, ingester.SetEntityMapping(DynatraceEntityMapping{Dimension: "dt.entity.service"}), "needs nodes or a way to match them")
+	assert.NoError(t, ingester.SetEntityMapping(DynatraceEntityMapping{Dimension: "dt.entity.service", MatchNames: true}))
+}
+
+func TestDynatraceAggregateExportByEntity(t *testing.T) {
+	checkout := models.CostNode{ID: uuid.New(), Name: "checkout"}
+	search := models.CostNode{ID: uuid.New(), Name: "search"}
+	fallback := uuid.New()
+	index, err := newDynatraceEntityIndex(DynatraceEntityMapping{Dimension: "dt.entity.service", MatchNames: true}, []models.CostNode{checkout, search})
+	require.NoError(t, err)
+
+	timestamp := time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC).UnixMilli()
+	export := &DynatraceExport{Metrics: []DynatraceMetric{
+		{MetricID: "builtin:service.requestCount.total", Data: []DynatraceDataPoint{
+			{Dimensions: map[string]string{"dt.entity.service": "SERVICE-1", "dt.entity.service.name": "checkout"}, Timestamps: []int64{timestamp, timestamp + 60000}, Values: []float64{10, 20}},
+			{Dimensions: map[string]string{"dt.entity.service": "SERVICE-2", "dt.entity.service.name": "search"}, Timestamps: []int64{timestamp}, Values: []float64{5}},
+			{Dimensions: map[string]string{"dt.entity.service": "SERVICE-3", "dt.entity.service.name": "inventory"}, Timestamps: []int64{timestamp, timestamp + 60000}, Values: []float64{1, 2}},
+			{Dimensions: map[string]string{"customer_id": "c1"}, Timestamps: []int64{timestamp}, Values: []float64{7}},
+		}},
+	}}
+
+	ingester := NewDynatraceIngester(nil)
+	usage := ingester.aggregateExport(export, dynatraceRouter{entities: index, defaultNode: fallback})
+	assert.Equal(t, 6, usage.processed)
+	assert.Equal(t, 2, usage.skipped)
+	assert.Equal(t, []string{"SERVICE-3 (inventory)"}, usage.unmapped)
+
+	values := make(map[uuid.UUID]string)
+	for _, record := range usage.daily {
+		values[record.NodeID] = record.Value.String()
+	}
+	assert.Equal(t, map[uuid.UUID]string{checkout.ID: "30", search.ID: "5", fallback: "7"}, values)
+
+	// Without a node for the export, datapoints without an entity are skipped too
+	usage = ingester.aggregateExport(export, dynatraceRouter{entities: index})
+	assert.Equal(t, 3, usage.skipped)
+	assert.Len(t, usage.daily, 2)
+}
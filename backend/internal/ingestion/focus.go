@@ -0,0 +1,255 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/focus"
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// FOCUSIngester handles ingestion of cost data in the FinOps Foundation's FOCUS
+// schema (CSV), as exported by AWS, Azure, GCP and other providers
+type FOCUSIngester struct {
+	store              *store.Store
+	progressChan       chan IngestionProgress
+	batchSize          int
+	createMissingNodes bool
+	costBasis          CostBasis
+}
+
+// FOCUSConfig configures the FOCUS ingester
+type FOCUSConfig struct {
+	BatchSize          int
+	CreateMissingNodes bool
+	ProgressChan       chan IngestionProgress
+	// CostBasis selects the cost column recorded: BilledCost for unblended (the
+	// default), EffectiveCost for amortised and net_amortised
+	CostBasis CostBasis
+}
+
+// NewFOCUSIngester creates a new FOCUS ingester
+func NewFOCUSIngester(store *store.Store, config *FOCUSConfig) *FOCUSIngester {
+	ingester := &FOCUSIngester{
+		store:     store,
+		batchSize: 1000,
+		costBasis: CostBasisUnblended,
+	}
+	if config != nil {
+		if config.BatchSize > 0 {
+			ingester.batchSize = config.BatchSize
+		}
+		if config.CostBasis != "" {
+			ingester.costBasis = config.CostBasis
+		}
+		ingester.createMissingNodes = config.CreateMissingNodes
+		ingester.progressChan = config.ProgressChan
+	}
+	return ingester
+}
+
+// IngestFile ingests a FOCUS CSV file
+func (f *FOCUSIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	f.reportProgress(IngestionProgress{
+		CurrentFile: filePath,
+		Message:     fmt.Sprintf("Starting ingestion of %s", filePath),
+	})
+
+	return f.IngestReader(ctx, file, filePath)
+}
+
+// IngestReader ingests FOCUS CSV data from a reader
+func (f *FOCUSIngester) IngestReader(ctx context.Context, reader io.Reader, sourceName string) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "focus",
+		StartTime: time.Now(),
+	}
+
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.TrimLeadingSpace = true
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	columns := newCSVColumns(headers)
+	for _, col := range []string{focus.ColChargePeriodStart, f.costColumn()} {
+		if !columns.has(col) {
+			return nil, fmt.Errorf("required column %s not found in CSV", col)
+		}
+	}
+
+	resolver := newNodeResolver(f.store, "focus", f.createMissingNodes)
+	totals := newCostTotals()
+	recordNum := 0
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum+1, err))
+			result.RecordsSkipped++
+			continue
+		}
+
+		recordNum++
+		result.RecordsProcessed++
+
+		item, err := f.lineItem(columns, record)
+		if err == nil && !item.amount.IsZero() {
+			err = addLineItem(ctx, resolver, totals, item)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum, err))
+			result.RecordsSkipped++
+			continue
+		}
+
+		if recordNum%f.batchSize == 0 {
+			f.reportProgress(IngestionProgress{
+				RecordsProcessed: result.RecordsProcessed,
+				RecordsSkipped:   result.RecordsSkipped,
+				CurrentRecord:    recordNum,
+				Message:          fmt.Sprintf("Processed %d records", result.RecordsProcessed),
+			})
+		}
+	}
+
+	if err := storeCostTotals(ctx, f.store, totals, f.batchSize, result, f.reportProgress); err != nil {
+		return nil, err
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	f.reportProgress(IngestionProgress{
+		RecordsProcessed: result.RecordsProcessed,
+		RecordsInserted:  result.RecordsInserted,
+		RecordsSkipped:   result.RecordsSkipped,
+		Message:          fmt.Sprintf("Ingestion complete: %d processed, %d inserted, %d skipped", result.RecordsProcessed, result.RecordsInserted, result.RecordsSkipped),
+	})
+
+	log.Info().
+		Str("source", sourceName).
+		Str("cost_basis", string(f.costBasis)).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("FOCUS ingestion completed")
+
+	return result, nil
+}
+
+// reportProgress sends progress updates if a channel is configured
+func (f *FOCUSIngester) reportProgress(progress IngestionProgress) {
+	sendProgress(f.progressChan, progress)
+}
+
+// costColumn returns the cost column recorded for the ingester's cost basis
+func (f *FOCUSIngester) costColumn() string {
+	if f.costBasis == CostBasisUnblended {
+		return focus.ColBilledCost
+	}
+	return focus.ColEffectiveCost
+}
+
+// lineItem parses a FOCUS row. Rows are resolved to the node named by their
+// product, service or cost center tag, or else to a node named after their
+// provider and service (e.g. aws_amazon_elastic_compute_cloud), and recorded
+// under a dimension named after their service.
+func (f *FOCUSIngester) lineItem(c csvColumns, record []string) (billingLineItem, error) {
+	start, err := focus.ParseTime(c.get(record, focus.ColChargePeriodStart))
+	if err != nil {
+		return billingLineItem{}, fmt.Errorf("invalid %s: %w", focus.ColChargePeriodStart, err)
+	}
+	date := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+
+	amount := decimal.Zero
+	if value := c.get(record, f.costColumn()); value != "" {
+		amount, err = decimal.NewFromString(value)
+		if err != nil {
+			return billingLineItem{}, fmt.Errorf("invalid %s: %w", f.costColumn(), err)
+		}
+	}
+
+	currency := fx.NormaliseCurrency(c.get(record, focus.ColBillingCurrency))
+	if currency == "" {
+		currency = "USD"
+	}
+
+	tags, err := focus.ParseTags(c.get(record, focus.ColTags))
+	if err != nil {
+		return billingLineItem{}, err
+	}
+
+	provider := c.get(record, focus.ColProviderName)
+	serviceName := c.get(record, focus.ColServiceName)
+	var service serviceNode
+	if name := normaliseName(serviceName); name != "" {
+		if prefix := normaliseName(provider); prefix != "" {
+			name = prefix + "_" + name
+		}
+		service = serviceNode{
+			name: name,
+			labels: map[string]interface{}{
+				"focus_provider_name": provider,
+				"focus_service_name":  serviceName,
+			},
+		}
+	}
+
+	dimension := normaliseName(serviceName)
+	if dimension == "" {
+		dimension = "unclassified"
+	}
+
+	metadata := map[string]interface{}{"source": "focus"}
+	for key, col := range map[string]string{
+		"provider":         focus.ColProviderName,
+		"service":          focus.ColServiceName,
+		"service_category": focus.ColServiceCategory,
+		"resource_id":      focus.ColResourceID,
+		"resource_name":    focus.ColResourceName,
+		"region":           focus.ColRegionID,
+		"sub_account_id":   focus.ColSubAccountID,
+		"charge":           focus.ColChargeDescription,
+	} {
+		if v := c.get(record, col); v != "" {
+			metadata[key] = v
+		}
+	}
+	if v := c.get(record, focus.ColChargeCategory); v != "" {
+		metadata["line_item_types"] = []string{v}
+	}
+
+	return billingLineItem{
+		date:      date,
+		amount:    amount,
+		currency:  currency,
+		dimension: f.costBasis.Dimension(dimension),
+		tags: []string{
+			tagValue(tags, "product"),
+			tagValue(tags, "service"),
+			tagValue(tags, "cost_center", "cost_centre"),
+		},
+		service:  service,
+		metadata: metadata,
+	}, nil
+}
@@ -0,0 +1,60 @@
+package ingestion
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFOCUSLineItem(t *testing.T) {
+	input := `ChargePeriodStart,BilledCost,EffectiveCost,BillingCurrency,ChargeCategory,ProviderName,ServiceName,ResourceId,Tags
+2024-01-15T00:00:00Z,10.00,7.50,usd,Usage,AWS,Amazon Elastic Compute Cloud,i-123,"{""Product"": ""checkout"", ""team"": 7}"
+2024-01-15T00:00:00Z,100.00,0,USD,Purchase,AWS,Savings Plans for AWS Compute usage,,
+2024-01-16,5,5,EUR,Usage,,Storage,,
+2024-01-16,5,5,USD,Usage,AWS,S3,,not json
+bad,5,5,USD,Usage,AWS,S3,,
+`
+	reader := csv.NewReader(strings.NewReader(input))
+	headers, err := reader.Read()
+	require.NoError(t, err)
+	columns := newCSVColumns(headers)
+	records, err := reader.ReadAll()
+	require.NoError(t, err)
+
+	billed := NewFOCUSIngester(nil, nil)
+	item, err := billed.lineItem(columns, records[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-15", item.date.Format("2006-01-02"))
+	assert.Equal(t, "10", item.amount.String())
+	assert.Equal(t, "USD", item.currency)
+	assert.Equal(t, "amazon_elastic_compute_cloud", item.dimension)
+	assert.Equal(t, []string{"checkout", "", ""}, item.tags)
+	assert.Equal(t, "aws_amazon_elastic_compute_cloud", item.service.name)
+	assert.Equal(t, "i-123", item.metadata["resource_id"])
+	assert.Equal(t, []string{"Usage"}, item.metadata["line_item_types"])
+
+	// Effective cost spreads commitment purchases over the usage they cover
+	effective := NewFOCUSIngester(nil, &FOCUSConfig{CostBasis: CostBasisAmortised})
+	item, err = effective.lineItem(columns, records[0])
+	require.NoError(t, err)
+	assert.Equal(t, "7.5", item.amount.String())
+	assert.Equal(t, "amazon_elastic_compute_cloud_amortised", item.dimension)
+	item, err = effective.lineItem(columns, records[1])
+	require.NoError(t, err)
+	assert.True(t, item.amount.IsZero())
+
+	// Without a provider the service node is named after the service alone
+	item, err = billed.lineItem(columns, records[2])
+	require.NoError(t, err)
+	assert.Equal(t, "storage", item.service.name)
+	assert.Equal(t, "EUR", item.currency)
+
+	_, err = billed.lineItem(columns, records[3])
+	assert.ErrorContains(t, err, "invalid tags")
+
+	_, err = billed.lineItem(columns, records[4])
+	assert.ErrorContains(t, err, "invalid ChargePeriodStart")
+}
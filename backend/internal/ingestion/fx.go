@@ -0,0 +1,179 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// FX rate CSV column names
+const (
+	ColFXDate   = "date"
+	ColFXFrom   = "from"
+	ColFXTo     = "to"
+	ColFXRate   = "rate"
+	ColFXSource = "source"
+)
+
+// fxBatchSize is the number of rates upserted at a time
+const fxBatchSize = 1000
+
+// FXRateIngester handles ingestion of FX rate CSV files with the columns
+// date, from, to, rate and an optional source. A rate converts one unit of
+// the from currency into the to currency and applies from its date until the
+// pair's next rate.
+type FXRateIngester struct {
+	store *store.Store
+}
+
+// NewFXRateIngester creates a new FX rate ingester
+func NewFXRateIngester(store *store.Store) *FXRateIngester {
+	return &FXRateIngester{store: store}
+}
+
+// IngestFile ingests FX rates from a CSV file
+func (f *FXRateIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return f.IngestReader(ctx, file)
+}
+
+// IngestReader ingests FX rates from a CSV reader
+func (f *FXRateIngester) IngestReader(ctx context.Context, reader io.Reader) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "fx_rates",
+		StartTime: time.Now(),
+	}
+
+	rates, err := parseFXRates(reader, result)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(rates); start += fxBatchSize {
+		end := start + fxBatchSize
+		if end > len(rates) {
+			end = len(rates)
+		}
+		if err := f.store.FX.BulkUpsert(ctx, rates[start:end]); err != nil {
+			return nil, fmt.Errorf("failed to bulk upsert FX rates: %w", err)
+		}
+		result.RecordsInserted += end - start
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	log.Info().
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("FX rate ingestion completed")
+
+	return result, nil
+}
+
+// parseFXRates reads FX rates from CSV, recording rows that cannot be parsed in
+// the result and skipping them
+func parseFXRates(reader io.Reader, result *IngestionResult) ([]models.FXRate, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.TrimLeadingSpace = true
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	colIndex := make(map[string]int)
+	for i, header := range headers {
+		colIndex[strings.ToLower(strings.TrimSpace(header))] = i
+	}
+	for _, col := range []string{ColFXDate, ColFXFrom, ColFXTo, ColFXRate} {
+		if _, ok := colIndex[col]; !ok {
+			return nil, fmt.Errorf("required column %s not found in CSV", col)
+		}
+	}
+
+	var rates []models.FXRate
+	for row := 1; ; row++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		result.RecordsProcessed++
+		if err == nil {
+			var rate models.FXRate
+			rate, err = parseFXRate(record, colIndex)
+			if err == nil {
+				rates = append(rates, rate)
+				continue
+			}
+		}
+		result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", row, err))
+		result.RecordsSkipped++
+	}
+
+	return rates, nil
+}
+
+// parseFXRate parses a single CSV record into an FX rate
+func parseFXRate(record []string, colIndex map[string]int) (models.FXRate, error) {
+	column := func(name string) string {
+		if idx, ok := colIndex[name]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	date, err := time.Parse("2006-01-02", column(ColFXDate))
+	if err != nil {
+		return models.FXRate{}, fmt.Errorf("invalid date: %w", err)
+	}
+
+	from, to := fx.NormaliseCurrency(column(ColFXFrom)), fx.NormaliseCurrency(column(ColFXTo))
+	if err := fx.ValidateCurrency(from); err != nil {
+		return models.FXRate{}, err
+	}
+	if err := fx.ValidateCurrency(to); err != nil {
+		return models.FXRate{}, err
+	}
+	if from == to {
+		return models.FXRate{}, fmt.Errorf("rate converts %s to itself", from)
+	}
+
+	rate, err := decimal.NewFromString(column(ColFXRate))
+	if err != nil {
+		return models.FXRate{}, fmt.Errorf("invalid rate: %w", err)
+	}
+	if !rate.IsPositive() {
+		return models.FXRate{}, fmt.Errorf("rate must be positive, got %s", rate)
+	}
+
+	source := column(ColFXSource)
+	if source == "" {
+		source = "csv"
+	}
+
+	return models.FXRate{
+		RateDate:     date,
+		FromCurrency: from,
+		ToCurrency:   to,
+		Rate:         rate,
+		Source:       source,
+	}, nil
+}
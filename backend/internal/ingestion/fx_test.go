@@ -0,0 +1,42 @@
+package ingestion
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFXRates(t *testing.T) {
+	input := `Date,From,To,Rate,Source
+2024-01-02,eur,usd,1.0956,ecb
+2024-01-02,GBP,USD,1.2710,
+2024-01-03,EUR,EUR,1,
+2024-01-03,EUR,USD,-1,
+2024-13-01,EUR,USD,1.09,
+2024-01-03,EURO,USD,1.09,
+`
+	result := &IngestionResult{}
+	rates, err := parseFXRates(strings.NewReader(input), result)
+	require.NoError(t, err)
+
+	require.Len(t, rates, 2)
+	assert.Equal(t, "EUR", rates[0].FromCurrency)
+	assert.Equal(t, "USD", rates[0].ToCurrency)
+	assert.Equal(t, "1.0956", rates[0].Rate.String())
+	assert.Equal(t, "ecb", rates[0].Source)
+	assert.Equal(t, "2024-01-02", rates[0].RateDate.Format("2006-01-02"))
+	assert.Equal(t, "csv", rates[1].Source)
+
+	assert.Equal(t, 6, result.RecordsProcessed)
+	assert.Equal(t, 4, result.RecordsSkipped)
+	require.Len(t, result.Errors, 4)
+	assert.Contains(t, result.Errors[0], "row 3: rate converts EUR to itself")
+	assert.Contains(t, result.Errors[1], "rate must be positive")
+	assert.Contains(t, result.Errors[2], "invalid date")
+	assert.Contains(t, result.Errors[3], "invalid currency code")
+
+	_, err = parseFXRates(strings.NewReader("date,from,rate\n"), &IngestionResult{})
+	assert.ErrorContains(t, err, "required column to not found")
+}
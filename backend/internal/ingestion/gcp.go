@@ -0,0 +1,463 @@
+package ingestion
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/fx"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// GCP billing export file formats
+const (
+	GCPFormatJSONL = "jsonl"
+	GCPFormatCSV   = "csv"
+)
+
+// GCPBillingIngester handles ingestion of the GCP Cloud Billing export to
+// BigQuery, saved from BigQuery as newline delimited JSON or as CSV
+type GCPBillingIngester struct {
+	store              *store.Store
+	progressChan       chan IngestionProgress
+	batchSize          int
+	createMissingNodes bool
+}
+
+// GCPBillingConfig configures the GCP billing export ingester
+type GCPBillingConfig struct {
+	BatchSize          int
+	CreateMissingNodes bool
+	ProgressChan       chan IngestionProgress
+}
+
+// gcpBillingRow is a row of the standard billing export table. JSON exports
+// keep its nested fields; CSV exports flatten them into columns named
+// service_description or service.description.
+type gcpBillingRow struct {
+	Service struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+	} `json:"service"`
+	SKU struct {
+		ID          string `json:"id"`
+		Description string `json:"description"`
+	} `json:"sku"`
+	UsageStartTime string `json:"usage_start_time"`
+	Project        struct {
+		ID     string     `json:"id"`
+		Name   string     `json:"name"`
+		Labels []gcpLabel `json:"labels"`
+	} `json:"project"`
+	Labels   []gcpLabel `json:"labels"`
+	Location struct {
+		Region string `json:"region"`
+	} `json:"location"`
+	Resource struct {
+		Name string `json:"name"`
+	} `json:"resource"`
+	Cost     decimal.Decimal `json:"cost"`
+	Currency string          `json:"currency"`
+	CostType string          `json:"cost_type"`
+	Credits  []gcpCredit     `json:"credits"`
+}
+
+type gcpLabel struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type gcpCredit struct {
+	Name   string          `json:"name"`
+	Amount decimal.Decimal `json:"amount"`
+	Type   string          `json:"type"`
+}
+
+// NewGCPBillingIngester creates a new GCP billing export ingester
+func NewGCPBillingIngester(store *store.Store, config *GCPBillingConfig) *GCPBillingIngester {
+	ingester := &GCPBillingIngester{
+		store:     store,
+		batchSize: 1000,
+	}
+	if config != nil {
+		if config.BatchSize > 0 {
+			ingester.batchSize = config.BatchSize
+		}
+		ingester.createMissingNodes = config.CreateMissingNodes
+		ingester.progressChan = config.ProgressChan
+	}
+	return ingester
+}
+
+// GCPFormatFromPath returns the export format of a file from its extension:
+// CSV for .csv files and JSONL otherwise
+func GCPFormatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return GCPFormatCSV
+	}
+	return GCPFormatJSONL
+}
+
+// IngestFile ingests a GCP billing export file
+func (g *GCPBillingIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	g.reportProgress(IngestionProgress{
+		CurrentFile: filePath,
+		Message:     fmt.Sprintf("Starting ingestion of %s", filePath),
+	})
+
+	return g.IngestReader(ctx, file, GCPFormatFromPath(filePath))
+}
+
+// IngestReader ingests a GCP billing export in the given format from a reader
+func (g *GCPBillingIngester) IngestReader(ctx context.Context, reader io.Reader, format string) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "gcp_billing_export",
+		StartTime: time.Now(),
+	}
+
+	var next func() (gcpBillingRow, error)
+	switch format {
+	case GCPFormatJSONL:
+		next = newGCPJSONReader(reader)
+	case GCPFormatCSV:
+		var err error
+		next, err = newGCPCSVReader(reader)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported GCP billing export format: %s", format)
+	}
+
+	resolver := newNodeResolver(g.store, "gcp_billing_export", g.createMissingNodes)
+	totals := newCostTotals()
+	recordNum := 0
+
+	for {
+		row, err := next()
+		if err == io.EOF {
+			break
+		}
+		var rowErr *rowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return nil, fmt.Errorf("failed to read GCP billing export: %w", err)
+		}
+
+		recordNum++
+		result.RecordsProcessed++
+
+		if err == nil {
+			var item billingLineItem
+			item, err = gcpLineItem(row)
+			if err == nil && !item.amount.IsZero() {
+				err = addLineItem(ctx, resolver, totals, item)
+			}
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", recordNum, err))
+			result.RecordsSkipped++
+			continue
+		}
+
+		if recordNum%g.batchSize == 0 {
+			g.reportProgress(IngestionProgress{
+				RecordsProcessed: result.RecordsProcessed,
+				RecordsSkipped:   result.RecordsSkipped,
+				CurrentRecord:    recordNum,
+				Message:          fmt.Sprintf("Processed %d records", result.RecordsProcessed),
+			})
+		}
+	}
+
+	if err := storeCostTotals(ctx, g.store, totals, g.batchSize, result, g.reportProgress); err != nil {
+		return nil, err
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	g.reportProgress(IngestionProgress{
+		RecordsProcessed: result.RecordsProcessed,
+		RecordsInserted:  result.RecordsInserted,
+		RecordsSkipped:   result.RecordsSkipped,
+		Message:          fmt.Sprintf("Ingestion complete: %d processed, %d inserted, %d skipped", result.RecordsProcessed, result.RecordsInserted, result.RecordsSkipped),
+	})
+
+	log.Info().
+		Str("format", format).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("GCP billing export ingestion completed")
+
+	return result, nil
+}
+
+// reportProgress sends progress updates if a channel is configured
+func (g *GCPBillingIngester) reportProgress(progress IngestionProgress) {
+	sendProgress(g.progressChan, progress)
+}
+
+// newGCPJSONReader returns a function reading rows from newline delimited JSON,
+// skipping blank lines
+func newGCPJSONReader(reader io.Reader) func() (gcpBillingRow, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	return func() (gcpBillingRow, error) {
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var row gcpBillingRow
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				return gcpBillingRow{}, &rowError{fmt.Errorf("invalid JSON: %w", err)}
+			}
+			return row, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return gcpBillingRow{}, fmt.Errorf("failed to read JSON lines: %w", err)
+		}
+		return gcpBillingRow{}, io.EOF
+	}
+}
+
+// newGCPCSVReader returns a function reading rows from a CSV export. Nested
+// fields are read from flattened columns; labels and credits, which BigQuery
+// cannot flatten, are read from columns holding them as JSON. A credits column
+// may instead hold the credit total.
+func newGCPCSVReader(reader io.Reader) (func() (gcpBillingRow, error), error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.TrimLeadingSpace = true
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	colIndex := make(map[string]int)
+	for i, header := range headers {
+		header = strings.TrimPrefix(strings.TrimSpace(header), "\ufeff")
+		colIndex[strings.ReplaceAll(strings.ToLower(header), ".", "_")] = i
+	}
+	for _, col := range []string{"usage_start_time", "cost"} {
+		if _, ok := colIndex[col]; !ok {
+			return nil, fmt.Errorf("required column %s not found in CSV", col)
+		}
+	}
+
+	return func() (gcpBillingRow, error) {
+		record, err := csvReader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return gcpBillingRow{}, &rowError{err}
+		}
+		if err != nil {
+			return gcpBillingRow{}, err
+		}
+		row, err := parseGCPCSVRecord(record, colIndex)
+		if err != nil {
+			return gcpBillingRow{}, &rowError{err}
+		}
+		return row, nil
+	}, nil
+}
+
+// parseGCPCSVRecord parses a single CSV record into a billing export row
+func parseGCPCSVRecord(record []string, colIndex map[string]int) (gcpBillingRow, error) {
+	column := func(name string) string {
+		if idx, ok := colIndex[name]; ok && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+
+	var row gcpBillingRow
+	row.Service.ID = column("service_id")
+	row.Service.Description = column("service_description")
+	row.SKU.ID = column("sku_id")
+	row.SKU.Description = column("sku_description")
+	row.UsageStartTime = column("usage_start_time")
+	row.Project.ID = column("project_id")
+	row.Project.Name = column("project_name")
+	row.Location.Region = column("location_region")
+	row.Resource.Name = column("resource_name")
+	row.Currency = column("currency")
+	row.CostType = column("cost_type")
+
+	if value := column("cost"); value != "" {
+		cost, err := decimal.NewFromString(value)
+		if err != nil {
+			return gcpBillingRow{}, fmt.Errorf("invalid cost: %w", err)
+		}
+		row.Cost = cost
+	}
+
+	var err error
+	if row.Labels, err = parseGCPLabels(column("labels")); err != nil {
+		return gcpBillingRow{}, fmt.Errorf("invalid labels: %w", err)
+	}
+	if row.Project.Labels, err = parseGCPLabels(column("project_labels")); err != nil {
+		return gcpBillingRow{}, fmt.Errorf("invalid project labels: %w", err)
+	}
+
+	if value := column("credits"); value != "" {
+		if total, err := decimal.NewFromString(value); err == nil {
+			row.Credits = []gcpCredit{{Amount: total}}
+		} else if err := json.Unmarshal([]byte(value), &row.Credits); err != nil {
+			return gcpBillingRow{}, fmt.Errorf("invalid credits: %w", err)
+		}
+	}
+
+	return row, nil
+}
+
+// parseGCPLabels parses labels written as JSON, either as BigQuery's array of
+// key/value records or as an object
+func parseGCPLabels(value string) ([]gcpLabel, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(value, "{") {
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(value), &labels); err != nil {
+			return nil, err
+		}
+		result := make([]gcpLabel, 0, len(labels))
+		for key, value := range labels {
+			result = append(result, gcpLabel{Key: key, Value: value})
+		}
+		return result, nil
+	}
+
+	var labels []gcpLabel
+	if err := json.Unmarshal([]byte(value), &labels); err != nil {
+		return nil, err
+	}
+	return labels, nil
+}
+
+// gcpLineItem converts a billing export row into a line item. Its cost is net of
+// credits. Rows are resolved to the node named by their resource's product,
+// service or cost_center label, then their project's, or else to a
+// gcp_<service> node.
+func gcpLineItem(row gcpBillingRow) (billingLineItem, error) {
+	date, err := parseGCPTime(row.UsageStartTime)
+	if err != nil {
+		return billingLineItem{}, fmt.Errorf("invalid usage_start_time: %w", err)
+	}
+
+	amount := row.Cost
+	for _, credit := range row.Credits {
+		amount = amount.Add(credit.Amount)
+	}
+
+	currency := fx.NormaliseCurrency(row.Currency)
+	if currency == "" {
+		currency = "USD"
+	}
+
+	labels := gcpLabelMap(row.Labels)
+	projectLabels := gcpLabelMap(row.Project.Labels)
+
+	var service serviceNode
+	if name := normaliseName(row.Service.Description); name != "" {
+		service = serviceNode{
+			name: "gcp_" + name,
+			labels: map[string]interface{}{
+				"gcp_service_id":          row.Service.ID,
+				"gcp_service_description": row.Service.Description,
+			},
+		}
+	}
+
+	dimension := normaliseName(row.SKU.Description)
+	if dimension == "" && service.name != "" {
+		dimension = strings.TrimPrefix(service.name, "gcp_") + "_cost"
+	}
+	if dimension == "" {
+		dimension = "unclassified"
+	}
+
+	metadata := map[string]interface{}{"source": "gcp_billing_export"}
+	for key, value := range map[string]string{
+		"service":   row.Service.Description,
+		"sku":       row.SKU.Description,
+		"sku_id":    row.SKU.ID,
+		"project":   row.Project.ID,
+		"region":    row.Location.Region,
+		"resource":  row.Resource.Name,
+		"cost_type": row.CostType,
+	} {
+		if value != "" {
+			metadata[key] = value
+		}
+	}
+	if row.CostType != "" {
+		metadata["line_item_types"] = []string{row.CostType}
+	}
+
+	return billingLineItem{
+		date:      date,
+		amount:    amount,
+		currency:  currency,
+		dimension: dimension,
+		tags: []string{
+			tagValue(labels, "product"),
+			tagValue(labels, "service"),
+			tagValue(labels, "cost_center", "cost_centre"),
+			tagValue(projectLabels, "product"),
+			tagValue(projectLabels, "service"),
+			tagValue(projectLabels, "cost_center", "cost_centre"),
+		},
+		service:  service,
+		metadata: metadata,
+	}, nil
+}
+
+// parseGCPTime parses a usage timestamp as BigQuery exports it, returning its
+// UTC day
+func parseGCPTime(value string) (time.Time, error) {
+	formats := []string{
+		"2006-01-02 15:04:05 MST",
+		"2006-01-02 15:04:05.999999 MST",
+		"2006-01-02 15:04:05-07:00",
+		"2006-01-02 15:04:05.999999-07:00",
+		time.RFC3339Nano,
+		"2006-01-02",
+	}
+	for _, format := range formats {
+		if t, err := time.Parse(format, value); err == nil {
+			t = t.UTC()
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse timestamp: %s", value)
+}
+
+func gcpLabelMap(labels []gcpLabel) map[string]string {
+	result := make(map[string]string, len(labels))
+	for _, label := range labels {
+		result[label.Key] = label.Value
+	}
+	return result
+}
@@ -0,0 +1,95 @@
+package ingestion
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readGCPRows(t *testing.T, next func() (gcpBillingRow, error)) ([]gcpBillingRow, []error) {
+	t.Helper()
+	var rows []gcpBillingRow
+	var rowErrs []error
+	for {
+		row, err := next()
+		if err == io.EOF {
+			return rows, rowErrs
+		}
+		if err != nil {
+			var rowErr *rowError
+			require.True(t, errors.As(err, &rowErr), "unexpected error: %v", err)
+			rowErrs = append(rowErrs, err)
+			continue
+		}
+		rows = append(rows, row)
+	}
+}
+
+func TestGCPLineItemFromJSON(t *testing.T) {
+	input := `{"service":{"id":"6F81-5844-456A","description":"Compute Engine"},"sku":{"id":"2E27","description":"N1 Predefined Instance Core"},"usage_start_time":"2024-01-15 23:00:00 UTC","project":{"id":"shop-prod","labels":[{"key":"cost_center","value":"retail"}]},"labels":[{"key":"product","value":"checkout"}],"cost":10.5,"currency":"GBP","cost_type":"regular","credits":[{"name":"Sustained use","amount":-2.5,"type":"SUSTAINED_USAGE_DISCOUNT"}]}
+
+{"service":{"description":"Cloud Storage"},"usage_start_time":"2024-01-16T01:00:00-08:00","cost":"3.25"}
+not json
+`
+	rows, rowErrs := readGCPRows(t, newGCPJSONReader(strings.NewReader(input)))
+	require.Len(t, rows, 2)
+	require.Len(t, rowErrs, 1)
+	assert.ErrorContains(t, rowErrs[0], "invalid JSON")
+
+	item, err := gcpLineItem(rows[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-15", item.date.Format("2006-01-02"))
+	assert.Equal(t, "8", item.amount.String())
+	assert.Equal(t, "GBP", item.currency)
+	assert.Equal(t, "n1_predefined_instance_core", item.dimension)
+	assert.Equal(t, []string{"checkout", "", "", "", "", "retail"}, item.tags)
+	assert.Equal(t, "gcp_compute_engine", item.service.name)
+	assert.Equal(t, "shop-prod", item.metadata["project"])
+	assert.Equal(t, []string{"regular"}, item.metadata["line_item_types"])
+
+	// Usage dates are UTC days
+	item, err = gcpLineItem(rows[1])
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-16", item.date.Format("2006-01-02"))
+	assert.Equal(t, "USD", item.currency)
+	assert.Equal(t, "cloud_storage_cost", item.dimension)
+}
+
+func TestGCPLineItemFromCSV(t *testing.T) {
+	input := `service.description,sku.description,usage_start_time,project.id,labels,project_labels,cost,currency,credits
+BigQuery,Analysis,2024-01-15 10:00:00 UTC,analytics,"{""service"": ""reporting""}",,4.00,USD,-1.5
+BigQuery,Analysis,2024-01-15 11:00:00 UTC,analytics,"[{""key"": ""Product"", ""value"": ""search""}]",,2.00,USD,"[{""amount"": -0.5}]"
+BigQuery,Analysis,2024-01-15 12:00:00 UTC,analytics,,,abc,USD,
+`
+	next, err := newGCPCSVReader(strings.NewReader(input))
+	require.NoError(t, err)
+	rows, rowErrs := readGCPRows(t, next)
+	require.Len(t, rows, 2)
+	require.Len(t, rowErrs, 1)
+	assert.ErrorContains(t, rowErrs[0], "invalid cost")
+
+	item, err := gcpLineItem(rows[0])
+	require.NoError(t, err)
+	assert.Equal(t, "2.5", item.amount.String())
+	assert.Equal(t, "reporting", item.tags[1])
+	assert.Equal(t, "gcp_bigquery", item.service.name)
+	assert.Equal(t, "analysis", item.dimension)
+
+	item, err = gcpLineItem(rows[1])
+	require.NoError(t, err)
+	assert.Equal(t, "1.5", item.amount.String())
+	assert.Equal(t, "search", item.tags[0])
+
+	_, err = newGCPCSVReader(strings.NewReader("usage_start_time,service\n"))
+	assert.ErrorContains(t, err, "required column cost not found")
+}
+
+func TestGCPFormatFromPath(t *testing.T) {
+	assert.Equal(t, GCPFormatCSV, GCPFormatFromPath("billing/2024-01.CSV"))
+	assert.Equal(t, GCPFormatJSONL, GCPFormatFromPath("billing/2024-01.jsonl"))
+	assert.Equal(t, GCPFormatJSONL, GCPFormatFromPath("billing/2024-01.json"))
+}
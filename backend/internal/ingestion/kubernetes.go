@@ -0,0 +1,740 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Kubernetes export formats
+const (
+	KubernetesFormatOpenCost = "opencost"
+	KubernetesFormatCSV      = "csv"
+)
+
+// Usage metrics recorded for Kubernetes namespaces. Requests and usage are the
+// average over each window multiplied by its length; memory is in GiB.
+const (
+	KubernetesMetricCPURequest    = "cpu_request_core_hours"
+	KubernetesMetricCPUUsage      = "cpu_usage_core_hours"
+	KubernetesMetricMemoryRequest = "memory_request_gb_hours"
+	KubernetesMetricMemoryUsage   = "memory_usage_gb_hours"
+)
+
+// KubernetesIdleNamespace is the namespace OpenCost reports unrequested cluster
+// capacity under. Its usage is recorded against the cluster's idle node.
+const KubernetesIdleNamespace = "__idle__"
+
+// bytesPerGiB converts Kubernetes memory, in bytes, to GiB
+var bytesPerGiB = decimal.NewFromInt(1 << 30)
+
+// KubernetesIngester handles ingestion of Kubernetes resource requests and
+// usage per cluster, namespace and window, from OpenCost allocation exports or
+// kube-state-metrics style CSV
+type KubernetesIngester struct {
+	store              *store.Store
+	createMissingNodes bool
+	createEdges        bool
+	edgeMetric         string
+	cluster            string
+	labels             []string
+	clusterNode        mappingTemplate
+	namespaceNode      mappingTemplate
+	idleNode           mappingTemplate
+}
+
+// KubernetesConfig configures the Kubernetes ingester. Node names may hold
+// the placeholders {cluster}, {namespace} and {label.<name>}, each optionally
+// followed by |lower or |normalise.
+type KubernetesConfig struct {
+	// ClusterNode names the node for a cluster; "{cluster|normalise}" by default
+	ClusterNode string
+	// NamespaceNode names the node for a namespace;
+	// "{cluster|normalise}_{namespace|normalise}" by default
+	NamespaceNode string
+	// IdleNode names the node for a cluster's unrequested capacity;
+	// "{cluster|normalise}_idle" by default
+	IdleNode string
+	// Cluster names the cluster of rows that have none
+	Cluster string
+	// Labels are the Kubernetes labels kept on usage records
+	Labels []string
+	// CreateMissingNodes creates cluster, namespace and idle nodes that do not exist
+	CreateMissingNodes bool
+	// CreateEdges creates a proportional_on edge from each cluster node to its
+	// namespace and idle nodes, when there is none
+	CreateEdges bool
+	// EdgeMetric is the metric created edges split cost on;
+	// cpu_request_core_hours by default
+	EdgeMetric string
+}
+
+// kubernetesAllocation is a namespace's, or a cluster's, resources over a
+// window. Requests, usage and capacity are averages over the window, in cores
+// and bytes.
+type kubernetesAllocation struct {
+	cluster   string
+	namespace string
+	labels    map[string]string
+	start     time.Time
+	hours     decimal.Decimal
+
+	cpuRequest    decimal.Decimal
+	cpuUsage      decimal.Decimal
+	memoryRequest decimal.Decimal
+	memoryUsage   decimal.Decimal
+	// cpuCapacity and memoryCapacity are set on cluster rows, which have no
+	// namespace, to derive idle capacity when the export does not report it
+	cpuCapacity    decimal.Decimal
+	memoryCapacity decimal.Decimal
+}
+
+// idle reports whether the allocation is the cluster's unrequested capacity
+func (a *kubernetesAllocation) idle() bool {
+	return a.namespace == KubernetesIdleNamespace
+}
+
+// Tag returns the value of a node name placeholder: the cluster, namespace or
+// a label, named label.<name>
+func (a *kubernetesAllocation) Tag(key string) string {
+	switch key {
+	case "cluster":
+		return a.cluster
+	case "namespace":
+		return a.namespace
+	}
+	if name, ok := strings.CutPrefix(key, "label."); ok {
+		return seriesLabels(a.labels).Tag(name)
+	}
+	return ""
+}
+
+// Column returns the value of a placeholder, as Tag does
+func (a *kubernetesAllocation) Column(name string) string {
+	return a.Tag(name)
+}
+
+// NewKubernetesIngester creates a new Kubernetes ingester
+func NewKubernetesIngester(store *store.Store, config *KubernetesConfig) (*KubernetesIngester, error) {
+	if config == nil {
+		config = &KubernetesConfig{}
+	}
+
+	ingester := &KubernetesIngester{
+		store:              store,
+		createMissingNodes: config.CreateMissingNodes,
+		createEdges:        config.CreateEdges,
+		edgeMetric:         config.EdgeMetric,
+		cluster:            config.Cluster,
+		labels:             config.Labels,
+	}
+	if ingester.edgeMetric == "" {
+		ingester.edgeMetric = KubernetesMetricCPURequest
+	}
+
+	templates := []struct {
+		name     string
+		value    string
+		fallback string
+		template *mappingTemplate
+	}{
+		{"cluster", config.ClusterNode, "{cluster|normalise}", &ingester.clusterNode},
+		{"namespace", config.NamespaceNode, "{cluster|normalise}_{namespace|normalise}", &ingester.namespaceNode},
+		{"idle", config.IdleNode, "{cluster|normalise}_idle", &ingester.idleNode},
+	}
+	for _, t := range templates {
+		value := t.value
+		if value == "" {
+			value = t.fallback
+		}
+		template, err := parseLabelTemplate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s node name: %w", t.name, err)
+		}
+		*t.template = template
+	}
+
+	return ingester, nil
+}
+
+// KubernetesFormatFromPath returns the export format of a file from its
+// extension: CSV for .csv files and OpenCost JSON otherwise
+func KubernetesFormatFromPath(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return KubernetesFormatCSV
+	}
+	return KubernetesFormatOpenCost
+}
+
+// IngestFile ingests a Kubernetes allocation export file
+func (k *KubernetesIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return k.IngestReader(ctx, file, KubernetesFormatFromPath(filePath))
+}
+
+// IngestReader ingests a Kubernetes allocation export in the given format from
+// a reader
+func (k *KubernetesIngester) IngestReader(ctx context.Context, reader io.Reader, format string) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "kubernetes",
+		StartTime: time.Now(),
+	}
+
+	var allocations []kubernetesAllocation
+	var err error
+	switch format {
+	case KubernetesFormatOpenCost:
+		allocations, err = readOpenCostAllocations(reader)
+	case KubernetesFormatCSV:
+		allocations, err = readKubernetesCSV(reader, result)
+	default:
+		return nil, fmt.Errorf("unsupported Kubernetes export format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resolver := newNodeResolver(k.store, "kubernetes", k.createMissingNodes)
+	usage, err := k.aggregate(ctx, allocations, resolver)
+	if err != nil {
+		return nil, err
+	}
+	result.RecordsProcessed += usage.processed
+	result.RecordsSkipped += usage.skipped
+	result.UnmappedEntities = usage.unmapped
+	if len(usage.unmapped) > 0 {
+		log.Warn().
+			Strs("namespaces", usage.unmapped).
+			Msg("No node exists for Kubernetes namespaces, skipping them")
+	}
+
+	if len(usage.daily) > 0 {
+		if err := k.store.Usage.BulkUpsertWithLabels(ctx, usage.daily); err != nil {
+			return nil, fmt.Errorf("failed to store usage records: %w", err)
+		}
+		result.RecordsInserted = len(usage.daily)
+	}
+
+	if k.createEdges {
+		if err := k.ensureEdges(ctx, usage.children, usage.firstDate); err != nil {
+			return nil, err
+		}
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	log.Info().
+		Str("format", format).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("Kubernetes ingestion completed")
+
+	return result, nil
+}
+
+// kubernetesUsage is the daily usage aggregated from an export, with the
+// namespace and idle nodes of each cluster node
+type kubernetesUsage struct {
+	exportUsage
+	children  map[uuid.UUID]map[uuid.UUID]bool
+	firstDate time.Time
+}
+
+// kubernetesWindow identifies a cluster's window, to derive its idle capacity
+type kubernetesWindow struct {
+	cluster string
+	start   time.Time
+}
+
+// kubernetesCapacity is a cluster's capacity and requests over a window
+type kubernetesCapacity struct {
+	// hasCapacity is set when the window has capacity rows, whose capacity is
+	// summed; hours is the window's length
+	hasCapacity bool
+	hours       decimal.Decimal
+	cpuCapacity decimal.Decimal
+	memCapacity decimal.Decimal
+	cpuRequest  decimal.Decimal
+	memRequest  decimal.Decimal
+	// reported is set when the export reports the window's idle capacity
+	reported bool
+}
+
+// aggregate records each namespace's requests and usage, and each cluster's
+// idle capacity, per day. Idle capacity the export does not report is the
+// cluster's capacity, summed over the window's capacity rows, less its
+// namespaces' requests.
+func (k *KubernetesIngester) aggregate(ctx context.Context, allocations []kubernetesAllocation, resolver *nodeResolver) (*kubernetesUsage, error) {
+	usage := &kubernetesUsage{children: make(map[uuid.UUID]map[uuid.UUID]bool)}
+	daily := make(usageSeriesSet)
+	unmapped := make(map[string]bool)
+	windows := make(map[kubernetesWindow]*kubernetesCapacity)
+	var windowOrder []kubernetesWindow
+
+	window := func(a *kubernetesAllocation) *kubernetesCapacity {
+		key := kubernetesWindow{cluster: a.cluster, start: a.start}
+		if _, ok := windows[key]; !ok {
+			windows[key] = &kubernetesCapacity{}
+			windowOrder = append(windowOrder, key)
+		}
+		return windows[key]
+	}
+
+	for i := range allocations {
+		a := &allocations[i]
+		usage.processed++
+		if a.cluster == "" {
+			a.cluster = k.cluster
+		}
+		if a.cluster == "" {
+			usage.skipped++
+			continue
+		}
+
+		switch {
+		case a.namespace == "":
+			w := window(a)
+			w.hasCapacity = true
+			w.hours = a.hours
+			w.cpuCapacity = w.cpuCapacity.Add(a.cpuCapacity)
+			w.memCapacity = w.memCapacity.Add(a.memoryCapacity)
+		case a.idle():
+			window(a).reported = true
+			if err := k.record(ctx, a, daily, usage, unmapped, resolver); err != nil {
+				return nil, err
+			}
+		default:
+			w := window(a)
+			w.cpuRequest = w.cpuRequest.Add(a.cpuRequest)
+			w.memRequest = w.memRequest.Add(a.memoryRequest)
+			if err := k.record(ctx, a, daily, usage, unmapped, resolver); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for _, key := range windowOrder {
+		w := windows[key]
+		if w.reported || !w.hasCapacity {
+			continue
+		}
+		idle := kubernetesAllocation{
+			cluster:       key.cluster,
+			namespace:     KubernetesIdleNamespace,
+			start:         key.start,
+			hours:         w.hours,
+			cpuRequest:    decimal.Max(w.cpuCapacity.Sub(w.cpuRequest), decimal.Zero),
+			memoryRequest: decimal.Max(w.memCapacity.Sub(w.memRequest), decimal.Zero),
+		}
+		if err := k.record(ctx, &idle, daily, usage, unmapped, resolver); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, series := range daily.sorted() {
+		usage.daily = append(usage.daily, models.NodeUsageByDimension{
+			NodeID:      series.key.nodeID,
+			UsageDate:   series.key.period,
+			Metric:      series.key.metric,
+			Value:       series.value(),
+			Unit:        series.mapping.Unit,
+			Labels:      series.labels,
+			Source:      "kubernetes",
+			Aggregation: string(series.mapping.Aggregation),
+		})
+	}
+	usage.unmapped = sortedKeys(unmapped)
+
+	return usage, nil
+}
+
+// record adds a namespace or idle allocation's usage to its node's daily series
+func (k *KubernetesIngester) record(ctx context.Context, a *kubernetesAllocation, daily usageSeriesSet, usage *kubernetesUsage, unmapped map[string]bool, resolver *nodeResolver) error {
+	template, nodeType := k.namespaceNode, models.NodeTypeService
+	if a.idle() {
+		template, nodeType = k.idleNode, models.NodeTypeShared
+	}
+
+	labels := map[string]interface{}{"kubernetes_cluster": a.cluster}
+	if !a.idle() {
+		labels["kubernetes_namespace"] = a.namespace
+	}
+	var node *models.CostNode
+	if name, ok := template.expand(a); ok {
+		var err error
+		if node, err = resolver.find(ctx, name, string(nodeType), labels, true); err != nil {
+			return err
+		}
+	}
+	if node == nil {
+		unmapped[a.cluster+"/"+a.namespace] = true
+		usage.skipped++
+		return nil
+	}
+
+	if clusterName, ok := k.clusterNode.expand(a); ok {
+		cluster, err := resolver.find(ctx, clusterName, string(models.NodeTypePlatform), map[string]interface{}{"kubernetes_cluster": a.cluster}, true)
+		if err != nil {
+			return err
+		}
+		if cluster != nil {
+			if usage.children[cluster.ID] == nil {
+				usage.children[cluster.ID] = make(map[uuid.UUID]bool)
+			}
+			usage.children[cluster.ID][node.ID] = true
+		}
+	}
+
+	var kept map[string]string
+	if !a.idle() {
+		for _, name := range k.labels {
+			if value := seriesLabels(a.labels).Tag(name); value != "" {
+				if kept == nil {
+					kept = make(map[string]string)
+				}
+				kept[name] = value
+			}
+		}
+	}
+
+	day := time.Date(a.start.Year(), a.start.Month(), a.start.Day(), 0, 0, 0, 0, time.UTC)
+	if usage.firstDate.IsZero() || day.Before(usage.firstDate) {
+		usage.firstDate = day
+	}
+	values := []struct {
+		metric string
+		unit   string
+		value  decimal.Decimal
+	}{
+		{KubernetesMetricCPURequest, "core_hours", a.cpuRequest},
+		{KubernetesMetricCPUUsage, "core_hours", a.cpuUsage},
+		{KubernetesMetricMemoryRequest, "gb_hours", a.memoryRequest.Div(bytesPerGiB)},
+		{KubernetesMetricMemoryUsage, "gb_hours", a.memoryUsage.Div(bytesPerGiB)},
+	}
+	for _, v := range values {
+		// Idle capacity is never used, so only its requests are recorded
+		if a.idle() && (v.metric == KubernetesMetricCPUUsage || v.metric == KubernetesMetricMemoryUsage) {
+			continue
+		}
+		key := usageSeriesKey{nodeID: node.ID, period: day, metric: v.metric, labels: labelSetKey(kept)}
+		mapping := MetricMapping{InternalName: v.metric, Unit: v.unit, Aggregation: AggregationSum}
+		daily.add(key, mapping, kept, v.value.Mul(a.hours))
+	}
+	return nil
+}
+
+// ensureEdges creates a proportional_on edge from each cluster node to each of
+// its namespace and idle nodes that has none, active from the first day of usage
+func (k *KubernetesIngester) ensureEdges(ctx context.Context, children map[uuid.UUID]map[uuid.UUID]bool, activeFrom time.Time) error {
+	created := 0
+	for clusterID, childIDs := range children {
+		edges, err := k.store.Edges.GetByParentID(ctx, clusterID, nil)
+		if err != nil {
+			return fmt.Errorf("failed to get cluster edges: %w", err)
+		}
+		existing := make(map[uuid.UUID]bool, len(edges))
+		for _, edge := range edges {
+			existing[edge.ChildID] = true
+		}
+
+		for childID := range childIDs {
+			if existing[childID] {
+				continue
+			}
+			edge := &models.DependencyEdge{
+				ParentID:          clusterID,
+				ChildID:           childID,
+				DefaultStrategy:   string(models.StrategyProportionalOn),
+				DefaultParameters: map[string]interface{}{"metric": k.edgeMetric},
+				ActiveFrom:        activeFrom,
+			}
+			if err := k.store.Edges.Create(ctx, edge); err != nil {
+				return fmt.Errorf("failed to create cluster edge: %w", err)
+			}
+			created++
+		}
+	}
+
+	if created > 0 {
+		log.Info().
+			Int("edges", created).
+			Str("metric", k.edgeMetric).
+			Msg("Created edges from Kubernetes clusters to namespaces")
+	}
+	return nil
+}
+
+// openCostResponse is a response of OpenCost's /allocation API queried with
+// accumulate=false, holding a set of allocations per step. Query with
+// aggregate=namespace, or finer, and includeIdle=true to record idle capacity.
+type openCostResponse struct {
+	Code    int                             `json:"code"`
+	Message string                          `json:"message"`
+	Data    []map[string]openCostAllocation `json:"data"`
+}
+
+// openCostAllocation is a single allocation of an OpenCost response
+type openCostAllocation struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Cluster   string            `json:"cluster"`
+		Namespace string            `json:"namespace"`
+		Labels    map[string]string `json:"labels"`
+	} `json:"properties"`
+	Start                 time.Time `json:"start"`
+	End                   time.Time `json:"end"`
+	Minutes               float64   `json:"minutes"`
+	CPUCores              float64   `json:"cpuCores"`
+	CPUCoreRequestAverage float64   `json:"cpuCoreRequestAverage"`
+	CPUCoreUsageAverage   float64   `json:"cpuCoreUsageAverage"`
+	RAMBytes              float64   `json:"ramBytes"`
+	RAMByteRequestAverage float64   `json:"ramByteRequestAverage"`
+	RAMByteUsageAverage   float64   `json:"ramByteUsageAverage"`
+}
+
+// readOpenCostAllocations reads the allocations of an OpenCost response
+func readOpenCostAllocations(reader io.Reader) ([]kubernetesAllocation, error) {
+	var response openCostResponse
+	if err := json.NewDecoder(reader).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode OpenCost allocations: %w", err)
+	}
+	if response.Code != 0 && response.Code != 200 {
+		return nil, fmt.Errorf("opencost query failed with code %d: %s", response.Code, response.Message)
+	}
+
+	var allocations []kubernetesAllocation
+	for _, set := range response.Data {
+		names := make([]string, 0, len(set))
+		for name := range set {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			oc := set[name]
+			hours := decimal.NewFromFloat(oc.End.Sub(oc.Start).Hours())
+			if !hours.IsPositive() {
+				hours = decimal.NewFromFloat(oc.Minutes / 60)
+			}
+
+			a := kubernetesAllocation{
+				cluster:       oc.Properties.Cluster,
+				namespace:     oc.Properties.Namespace,
+				labels:        oc.Properties.Labels,
+				start:         oc.Start.UTC(),
+				hours:         hours,
+				cpuRequest:    decimal.NewFromFloat(oc.CPUCoreRequestAverage),
+				cpuUsage:      decimal.NewFromFloat(oc.CPUCoreUsageAverage),
+				memoryRequest: decimal.NewFromFloat(oc.RAMByteRequestAverage),
+				memoryUsage:   decimal.NewFromFloat(oc.RAMByteUsageAverage),
+			}
+			// Idle allocations are named __idle__, or <cluster>/__idle__ when
+			// aggregated by cluster, and hold the idle capacity as allocated cores
+			if oc.Name == KubernetesIdleNamespace || strings.HasSuffix(oc.Name, "/"+KubernetesIdleNamespace) {
+				a.namespace = KubernetesIdleNamespace
+				a.cpuRequest = decimal.NewFromFloat(oc.CPUCores)
+				a.memoryRequest = decimal.NewFromFloat(oc.RAMBytes)
+			}
+			if a.namespace == "" {
+				// Allocations without a namespace, such as __unallocated__, have
+				// no node to record them against
+				continue
+			}
+			allocations = append(allocations, a)
+		}
+	}
+	return allocations, nil
+}
+
+// kubernetesCSVColumns are the columns of a CSV export, by their key as
+// tagKey returns it, with the alternative names each may have
+var kubernetesCSVColumns = map[string][]string{
+	"start":           {"windowstart", "start", "timestamp", "time"},
+	"end":             {"windowend", "end"},
+	"minutes":         {"minutes"},
+	"cluster":         {"cluster", "clusterid", "clustername"},
+	"namespace":       {"namespace"},
+	"cpu_request":     {"cpurequestcores", "cpucorerequestaverage", "cpurequest"},
+	"cpu_usage":       {"cpuusagecores", "cpucoreusageaverage", "cpuusage"},
+	"memory_request":  {"memoryrequestbytes", "rambyterequestaverage", "memoryrequest"},
+	"memory_usage":    {"memoryusagebytes", "rambyteusageaverage", "memoryusage"},
+	"cpu_capacity":    {"cpucapacitycores", "cpucapacity"},
+	"memory_capacity": {"memorycapacitybytes", "memorycapacity"},
+}
+
+// readKubernetesCSV reads a CSV export with a row per namespace, or pod, and
+// window. Rows without a namespace hold the cluster's capacity, or part of it
+// such as one machine's, and a window's are summed. Columns named
+// label_<name> hold Kubernetes labels. Windows are an hour long unless the
+// export has window end or minutes columns. Rows that cannot be parsed are
+// reported in the result and skipped.
+func readKubernetesCSV(reader io.Reader, result *IngestionResult) ([]kubernetesAllocation, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.TrimLeadingSpace = true
+
+	headers, err := csvReader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	colIndex := make(map[string]int)
+	labelIndex := make(map[string]int)
+	for i, header := range headers {
+		header = strings.TrimPrefix(strings.TrimSpace(header), "\ufeff")
+		if name, ok := strings.CutPrefix(header, "label_"); ok && name != "" {
+			labelIndex[name] = i
+			continue
+		}
+		for column, names := range kubernetesCSVColumns {
+			if _, seen := colIndex[column]; !seen && containsString(names, tagKey(header)) {
+				colIndex[column] = i
+			}
+		}
+	}
+	for _, col := range []string{"start", "namespace"} {
+		if _, ok := colIndex[col]; !ok {
+			return nil, fmt.Errorf("required column %s not found in CSV", col)
+		}
+	}
+
+	var allocations []kubernetesAllocation
+	for rowNum := 1; ; rowNum++ {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		var a kubernetesAllocation
+		if err == nil {
+			a, err = parseKubernetesCSVRecord(record, colIndex, labelIndex)
+		}
+		if err != nil {
+			result.RecordsProcessed++
+			result.RecordsSkipped++
+			result.Errors = append(result.Errors, fmt.Sprintf("row %d: %v", rowNum, err))
+			continue
+		}
+		allocations = append(allocations, a)
+	}
+	return allocations, nil
+}
+
+// parseKubernetesCSVRecord parses a single CSV record into an allocation
+func parseKubernetesCSVRecord(record []string, colIndex, labelIndex map[string]int) (kubernetesAllocation, error) {
+	field := func(idx int) string {
+		if idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+	column := func(name string) string {
+		if idx, ok := colIndex[name]; ok {
+			return field(idx)
+		}
+		return ""
+	}
+
+	a := kubernetesAllocation{
+		cluster:   column("cluster"),
+		namespace: column("namespace"),
+		hours:     decimal.NewFromInt(1),
+	}
+
+	var err error
+	if a.start, err = parseKubernetesTime(column("start")); err != nil {
+		return a, fmt.Errorf("invalid window start: %w", err)
+	}
+	if value := column("end"); value != "" {
+		end, err := parseKubernetesTime(value)
+		if err != nil {
+			return a, fmt.Errorf("invalid window end: %w", err)
+		}
+		a.hours = decimal.NewFromFloat(end.Sub(a.start).Hours())
+	} else if value := column("minutes"); value != "" {
+		minutes, err := decimal.NewFromString(value)
+		if err != nil {
+			return a, fmt.Errorf("invalid minutes: %w", err)
+		}
+		a.hours = minutes.Div(decimal.NewFromInt(60))
+	}
+	if !a.hours.IsPositive() {
+		return a, fmt.Errorf("window must have a positive length")
+	}
+
+	quantities := []struct {
+		column string
+		value  *decimal.Decimal
+	}{
+		{"cpu_request", &a.cpuRequest},
+		{"cpu_usage", &a.cpuUsage},
+		{"memory_request", &a.memoryRequest},
+		{"memory_usage", &a.memoryUsage},
+		{"cpu_capacity", &a.cpuCapacity},
+		{"memory_capacity", &a.memoryCapacity},
+	}
+	for _, q := range quantities {
+		value := column(q.column)
+		if value == "" {
+			continue
+		}
+		if *q.value, err = decimal.NewFromString(value); err != nil {
+			return a, fmt.Errorf("invalid %s: %w", strings.ReplaceAll(q.column, "_", " "), err)
+		}
+		if q.value.IsNegative() {
+			return a, fmt.Errorf("%s cannot be negative", strings.ReplaceAll(q.column, "_", " "))
+		}
+	}
+
+	for name, idx := range labelIndex {
+		if value := field(idx); value != "" {
+			if a.labels == nil {
+				a.labels = make(map[string]string)
+			}
+			a.labels[name] = value
+		}
+	}
+	return a, nil
+}
+
+// parseKubernetesTime parses an RFC 3339 time, a "2006-01-02 15:04:05" time in
+// UTC or Unix seconds
+func parseKubernetesTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+	if t, err := time.Parse("2006-01-02 15:04:05", value); err == nil {
+		return t, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("unrecognised time %q", value)
+}
@@ -0,0 +1,216 @@
+package ingestion
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const kubernetesCSV = `window_start,cluster,namespace,pod,cpu_request_cores,cpu_usage_cores,memory_request_bytes,memory_usage_bytes,cpu_capacity_cores,memory_capacity_bytes,label_team
+2024-01-15T09:00:00Z,prod-eu,checkout,checkout-1,0.5,0.25,1073741824,536870912,,,payments
+2024-01-15T09:00:00Z,prod-eu,checkout,checkout-2,0.5,0.75,1073741824,536870912,,,payments
+2024-01-15T10:00:00Z,prod-eu,checkout,checkout-1,1,0.5,2147483648,1073741824,,,payments
+2024-01-15T09:00:00Z,prod-eu,search,search-1,2,1,4294967296,2147483648,,,search
+2024-01-15T09:00:00Z,prod-eu,legacy,legacy-1,0.5,0.5,0,0,,,
+2024-01-15T09:00:00Z,prod-eu,,,,,,,4,8589934592,
+2024-01-15T10:00:00Z,prod-eu,,,,,,,4,8589934592,
+yesterday,prod-eu,checkout,checkout-1,1,1,1,1,,,
+2024-01-15T11:00:00Z,prod-eu,checkout,checkout-1,-1,1,1,1,,,
+`
+
+// kubernetesDaily returns the daily usage recorded against each node, keyed by
+// node name and metric
+func kubernetesDaily(t *testing.T, usage *kubernetesUsage, resolver *nodeResolver) map[string]string {
+	t.Helper()
+	names := make(map[uuid.UUID]string)
+	for name, node := range resolver.cache {
+		if node != nil {
+			names[node.ID] = name
+		}
+	}
+
+	got := make(map[string]string)
+	for _, record := range usage.daily {
+		assert.Equal(t, "kubernetes", record.Source)
+		assert.Equal(t, "2024-01-15", record.UsageDate.Format("2006-01-02"))
+		got[names[record.NodeID]+" "+record.Metric] = record.Value.String()
+	}
+	return got
+}
+
+func TestKubernetesAggregateCSV(t *testing.T) {
+	result := &IngestionResult{}
+	allocations, err := readKubernetesCSV(strings.NewReader(kubernetesCSV), result)
+	require.NoError(t, err)
+	assert.Len(t, allocations, 7)
+	assert.Equal(t, 2, result.RecordsSkipped)
+	require.Len(t, result.Errors, 2)
+	assert.Contains(t, result.Errors[0], "row 8: invalid window start")
+	assert.Contains(t, result.Errors[1], "row 9: cpu request cannot be negative")
+
+	ingester, err := NewKubernetesIngester(nil, &KubernetesConfig{Labels: []string{"team"}})
+	require.NoError(t, err)
+	resolver := noNodes(testResolver(false, "prod_eu", "prod_eu_checkout", "prod_eu_search", "prod_eu_idle"), "prod_eu_legacy")
+	usage, err := ingester.aggregate(context.Background(), allocations, resolver)
+	require.NoError(t, err)
+
+	assert.Equal(t, 7, usage.processed)
+	assert.Equal(t, 1, usage.skipped)
+	assert.Equal(t, []string{"prod-eu/legacy"}, usage.unmapped)
+
+	assert.Equal(t, map[string]string{
+		"prod_eu_checkout cpu_request_core_hours":  "2",
+		"prod_eu_checkout cpu_usage_core_hours":    "1.5",
+		"prod_eu_checkout memory_request_gb_hours": "4",
+		"prod_eu_checkout memory_usage_gb_hours":   "2",
+		"prod_eu_search cpu_request_core_hours":    "2",
+		"prod_eu_search cpu_usage_core_hours":      "1",
+		"prod_eu_search memory_request_gb_hours":   "4",
+		"prod_eu_search memory_usage_gb_hours":     "2",
+		// Capacity less every namespace's requests, mapped or not: 0.5 + 3 cores
+		// and 2 + 6 GiB
+		"prod_eu_idle cpu_request_core_hours":  "3.5",
+		"prod_eu_idle memory_request_gb_hours": "8",
+	}, kubernetesDaily(t, usage, resolver))
+
+	for _, record := range usage.daily {
+		if record.NodeID == resolver.cache["prod_eu_checkout"].ID {
+			assert.Equal(t, map[string]string{"team": "payments"}, record.Labels)
+		}
+		if record.NodeID == resolver.cache["prod_eu_idle"].ID {
+			assert.Nil(t, record.Labels)
+		}
+	}
+
+	// The cluster node is the parent of its namespace and idle nodes
+	assert.Equal(t, map[uuid.UUID]map[uuid.UUID]bool{
+		resolver.cache["prod_eu"].ID: {
+			resolver.cache["prod_eu_checkout"].ID: true,
+			resolver.cache["prod_eu_search"].ID:   true,
+			resolver.cache["prod_eu_idle"].ID:     true,
+		},
+	}, usage.children)
+	assert.Equal(t, time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), usage.firstDate)
+}
+
+const openCostAllocations = `{
+  "code": 200,
+  "data": [
+    {
+      "checkout": {
+        "name": "checkout",
+        "properties": {"cluster": "prod-eu", "namespace": "checkout", "labels": {"team": "payments"}},
+        "start": "2024-01-15T09:00:00Z", "end": "2024-01-15T10:00:00Z", "minutes": 60,
+        "cpuCoreRequestAverage": 1, "cpuCoreUsageAverage": 0.5,
+        "ramByteRequestAverage": 1073741824, "ramByteUsageAverage": 536870912
+      },
+      "__idle__": {
+        "name": "__idle__",
+        "properties": {"cluster": "prod-eu"},
+        "start": "2024-01-15T09:00:00Z", "end": "2024-01-15T10:00:00Z", "minutes": 60,
+        "cpuCores": 3, "ramBytes": 2147483648
+      },
+      "__unallocated__": {
+        "name": "__unallocated__",
+        "properties": {"cluster": "prod-eu"},
+        "start": "2024-01-15T09:00:00Z", "end": "2024-01-15T10:00:00Z", "minutes": 60,
+        "cpuCoreRequestAverage": 0.1
+      }
+    },
+    {
+      "checkout": {
+        "name": "checkout",
+        "properties": {"cluster": "prod-eu", "namespace": "checkout"},
+        "start": "2024-01-15T10:00:00Z", "end": "2024-01-15T10:30:00Z", "minutes": 30,
+        "cpuCoreRequestAverage": 2, "cpuCoreUsageAverage": 1,
+        "ramByteRequestAverage": 1073741824, "ramByteUsageAverage": 1073741824
+      }
+    }
+  ]
+}`
+
+func TestKubernetesAggregateOpenCost(t *testing.T) {
+	allocations, err := readOpenCostAllocations(strings.NewReader(openCostAllocations))
+	require.NoError(t, err)
+	require.Len(t, allocations, 3, "allocations without a namespace are ignored")
+	assert.True(t, allocations[0].idle())
+	assert.Equal(t, "3", allocations[0].cpuRequest.String())
+	assert.Equal(t, "0.5", allocations[2].hours.String())
+
+	ingester, err := NewKubernetesIngester(nil, &KubernetesConfig{NamespaceNode: "{namespace}", IdleNode: "{cluster}_spare"})
+	require.NoError(t, err)
+	resolver := testResolver(false, "prod_eu", "checkout", "prod-eu_spare")
+	usage, err := ingester.aggregate(context.Background(), allocations, resolver)
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"checkout cpu_request_core_hours":  "2",
+		"checkout cpu_usage_core_hours":    "1",
+		"checkout memory_request_gb_hours": "1.5",
+		"checkout memory_usage_gb_hours":   "1",
+		// Idle capacity the export reports is not derived again
+		"prod-eu_spare cpu_request_core_hours":  "3",
+		"prod-eu_spare memory_request_gb_hours": "2",
+	}, kubernetesDaily(t, usage, resolver))
+	assert.Empty(t, usage.unmapped)
+
+	_, err = readOpenCostAllocations(strings.NewReader(`{"code": 400, "message": "invalid window"}`))
+	assert.ErrorContains(t, err, "opencost query failed with code 400: invalid window")
+}
+
+func TestKubernetesAggregateDefaultCluster(t *testing.T) {
+	allocations, err := readKubernetesCSV(strings.NewReader("timestamp,namespace,minutes,cpu_request\n1705309200,checkout,30,2\n"), &IngestionResult{})
+	require.NoError(t, err)
+
+	// Rows without a cluster are skipped unless the ingester names one
+	ingester, err := NewKubernetesIngester(nil, nil)
+	require.NoError(t, err)
+	usage, err := ingester.aggregate(context.Background(), allocations, testResolver(false))
+	require.NoError(t, err)
+	assert.Equal(t, 1, usage.skipped)
+	assert.Empty(t, usage.daily)
+
+	ingester, err = NewKubernetesIngester(nil, &KubernetesConfig{Cluster: "Prod EU"})
+	require.NoError(t, err)
+	resolver := noNodes(testResolver(false, "prod_eu_checkout"), "prod_eu")
+	usage, err = ingester.aggregate(context.Background(), allocations, resolver)
+	require.NoError(t, err)
+	assert.Equal(t, "1", kubernetesDaily(t, usage, resolver)["prod_eu_checkout cpu_request_core_hours"])
+	assert.Empty(t, usage.children, "no edges without a cluster node")
+}
+
+func TestKubernetesAggregateCapacityRows(t *testing.T) {
+	// Each window's capacity is reported per machine
+	allocations, err := readKubernetesCSV(strings.NewReader(`window_start,cluster,namespace,cpu_request_cores,memory_request_bytes,cpu_capacity_cores,memory_capacity_bytes
+2024-01-15T09:00:00Z,prod-eu,checkout,1,1073741824,,
+2024-01-15T09:00:00Z,prod-eu,,,,4,8589934592
+2024-01-15T09:00:00Z,prod-eu,,,,2,4294967296
+2024-01-15T10:00:00Z,prod-eu,,,,4,8589934592
+2024-01-15T10:00:00Z,prod-eu,,,,4,8589934592
+`), &IngestionResult{})
+	require.NoError(t, err)
+
+	ingester, err := NewKubernetesIngester(nil, nil)
+	require.NoError(t, err)
+	resolver := testResolver(false, "prod_eu", "prod_eu_checkout", "prod_eu_idle")
+	usage, err := ingester.aggregate(context.Background(), allocations, resolver)
+	require.NoError(t, err)
+
+	got := kubernetesDaily(t, usage, resolver)
+	// 6 - 1 cores and 12 - 1 GiB at 09:00, and 8 cores and 16 GiB at 10:00
+	assert.Equal(t, "13", got["prod_eu_idle cpu_request_core_hours"])
+	assert.Equal(t, "27", got["prod_eu_idle memory_request_gb_hours"])
+}
+
+func TestKubernetesCSVRequiresColumns(t *testing.T) {
+	_, err := readKubernetesCSV(strings.NewReader("cluster,namespace\nprod,checkout\n"), &IngestionResult{})
+	assert.ErrorContains(t, err, "required column start not found")
+
+	_, err = NewKubernetesIngester(nil, &KubernetesConfig{NamespaceNode: "{namespace|upper}"})
+	assert.ErrorContains(t, err, `invalid namespace node name: unknown filter "upper"`)
+}
@@ -0,0 +1,478 @@
+package ingestion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// MappingRules are ordered rules mapping billing line items to the node, and
+// dimension, their cost is recorded against. They are loaded from a YAML rules
+// file:
+//
+//	rules:
+//	  - name: team
+//	    match:
+//	      tags:
+//	        team: ".+"
+//	        env: "^prod"
+//	    node:
+//	      name: "{tag.team}"
+//	      type: product
+//	  - name: shared_accounts
+//	    match:
+//	      account_ids: ["123456789012"]
+//	      columns:
+//	        line_item_usage_type: "DataTransfer"
+//	    node:
+//	      name: shared_networking
+//	      type: shared
+//	      create: true
+//	    dimension: egress_gb
+//	  - name: aws_service
+//	    fallback: true
+//	    node:
+//	      name: "aws_{product_code|lower}"
+//	      create: true
+type MappingRules struct {
+	Rules []MappingRule `mapstructure:"rules"`
+}
+
+// MappingRule maps the line items it matches to a node. Rules are tried in
+// order and the first whose node is found, or created, applies. Fallback rules
+// are tried only after every other rule.
+type MappingRule struct {
+	Name  string        `mapstructure:"name"`
+	Match MappingMatch  `mapstructure:"match"`
+	Node  MappingTarget `mapstructure:"node"`
+	// Dimension names the dimension costs are recorded under, and may hold
+	// placeholders. When empty it is derived from the line item's usage type.
+	Dimension string `mapstructure:"dimension"`
+	Fallback  bool   `mapstructure:"fallback"`
+}
+
+// MappingMatch selects the line items a rule applies to. Every condition given
+// must hold, so a rule without conditions matches every line item.
+type MappingMatch struct {
+	// Tags maps tag keys to regular expressions their values must match. Keys
+	// ignore case, punctuation and the user: prefix, so team is
+	// resourceTags/user:team or the user_team entry of CUR 2.0's resource_tags.
+	// A missing tag has an empty value.
+	Tags map[string]string `mapstructure:"tags"`
+	// Columns maps report columns, named as in legacy CUR or CUR 2.0, to
+	// regular expressions their values must match
+	Columns map[string]string `mapstructure:"columns"`
+	// AccountIDs are the usage account IDs the rule applies to
+	AccountIDs []string `mapstructure:"account_ids"`
+	// ProductCodes are the product codes, e.g. AmazonEC2, the rule applies to
+	ProductCodes []string `mapstructure:"product_codes"`
+}
+
+// MappingTarget names the node a rule maps line items to. Its name and label
+// values may hold placeholders: {tag.<key>}, {column.<name>}, {account_id},
+// {product_code}, {usage_type}, {operation}, {region} and {resource_id}, each
+// optionally followed by |lower or |normalise. A rule does not apply to a line
+// item that leaves any placeholder of the node name empty.
+type MappingTarget struct {
+	Name string `mapstructure:"name"`
+	// Type is the type of node created; it defaults to resource
+	Type string `mapstructure:"type"`
+	// Create creates the node when it does not exist and the ingester creates
+	// missing nodes. Otherwise a rule whose node does not exist does not apply.
+	Create bool              `mapstructure:"create"`
+	Labels map[string]string `mapstructure:"labels"`
+}
+
+// mappingFieldColumns are the CUR columns named placeholders read
+var mappingFieldColumns = map[string]string{
+	"account_id":   ColLineItemUsageAccountId,
+	"product_code": ColLineItemProductCode,
+	"usage_type":   ColLineItemUsageType,
+	"operation":    ColLineItemOperation,
+	"region":       ColProductRegion,
+	"resource_id":  ColLineItemResourceId,
+}
+
+// DefaultCURMappingRules returns the rules used when no rules file is given:
+// the node named by a line item's Product, Service or CostCenter tag, in that
+// order, or else the node for the AWS service it was billed for
+func DefaultCURMappingRules() MappingRules {
+	return MappingRules{Rules: []MappingRule{
+		{Name: "product_tag", Match: MappingMatch{Tags: map[string]string{"product": "."}}, Node: MappingTarget{Name: "{tag.product}"}},
+		{Name: "service_tag", Match: MappingMatch{Tags: map[string]string{"service": "."}}, Node: MappingTarget{Name: "{tag.service}"}},
+		{Name: "cost_center_tag", Match: MappingMatch{Tags: map[string]string{"costcenter": "."}}, Node: MappingTarget{Name: "{tag.costcenter}"}},
+		{
+			Name:     "aws_service",
+			Fallback: true,
+			Node: MappingTarget{
+				Name:   "aws_{product_code|lower}",
+				Type:   string(models.NodeTypeResource),
+				Create: true,
+				Labels: map[string]string{"aws_product_code": "{product_code}"},
+			},
+		},
+	}}
+}
+
+// LoadMappingRules loads mapping rules from a YAML file
+func LoadMappingRules(path string) (*MappingRuleSet, error) {
+	var rules MappingRules
+	if err := readRulesFile(path, &rules); err != nil {
+		return nil, err
+	}
+	if len(rules.Rules) == 0 {
+		return nil, fmt.Errorf("mapping rules file %s has no rules", path)
+	}
+	return NewMappingRuleSet(rules)
+}
+
+// readRulesFile reads a YAML rules file into rules. The file is decoded as it
+// is written rather than through viper, which lower-cases keys and splits them
+// on dots, so tag and label names such as user:CostCentre or
+// app.kubernetes.io/name keep their case and dots.
+func readRulesFile(path string, rules interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read mapping rules: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("failed to read mapping rules: %w", err)
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.ComposeDecodeHookFunc(
+			mapstructure.StringToTimeDurationHookFunc(),
+			mapstructure.StringToSliceHookFunc(","),
+		),
+		WeaklyTypedInput: true,
+		Result:           rules,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal mapping rules: %w", err)
+	}
+	if err := decoder.Decode(raw); err != nil {
+		return fmt.Errorf("failed to unmarshal mapping rules: %w", err)
+	}
+	return nil
+}
+
+// MappingRuleSet is a validated set of mapping rules, ready to apply
+type MappingRuleSet struct {
+	rules []*mappingRule
+	// digest identifies the rules, so batches mapped with other rules are told
+	// apart
+	digest string
+}
+
+// mappingRule is a rule with its expressions compiled
+type mappingRule struct {
+	MappingRule
+	tags      map[string]*regexp.Regexp
+	columns   map[string]*regexp.Regexp
+	accounts  map[string]bool
+	products  map[string]bool
+	node      mappingTemplate
+	dimension mappingTemplate
+	labels    map[string]mappingTemplate
+}
+
+// NewMappingRuleSet validates and compiles mapping rules, ordering fallback
+// rules after the others
+func NewMappingRuleSet(rules MappingRules) (*MappingRuleSet, error) {
+	set := &MappingRuleSet{}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule_%d", i+1)
+		}
+		compiled, err := compileMappingRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping rule %s: %w", rule.Name, err)
+		}
+		set.rules = append(set.rules, compiled)
+	}
+
+	sort.SliceStable(set.rules, func(i, j int) bool {
+		return !set.rules[i].Fallback && set.rules[j].Fallback
+	})
+
+	// Maps are encoded with their keys sorted, so equal rules have equal digests
+	ordered := make([]MappingRule, len(set.rules))
+	for i, rule := range set.rules {
+		ordered[i] = rule.MappingRule
+	}
+	encoded, err := json.Marshal(ordered)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode mapping rules: %w", err)
+	}
+	digest := sha256.Sum256(encoded)
+	set.digest = hex.EncodeToString(digest[:])
+	return set, nil
+}
+
+func compileMappingRule(rule MappingRule) (*mappingRule, error) {
+	if rule.Node.Name == "" {
+		return nil, errors.New("node name is required")
+	}
+	if rule.Node.Type == "" {
+		rule.Node.Type = string(models.NodeTypeResource)
+	}
+	switch models.NodeType(rule.Node.Type) {
+	case models.NodeTypeProduct, models.NodeTypeService, models.NodeTypeResource,
+		models.NodeTypePlatform, models.NodeTypeInfra, models.NodeTypeShared:
+	default:
+		return nil, fmt.Errorf("unknown node type %q", rule.Node.Type)
+	}
+
+	compiled := &mappingRule{
+		MappingRule: rule,
+		tags:        make(map[string]*regexp.Regexp, len(rule.Match.Tags)),
+		columns:     make(map[string]*regexp.Regexp, len(rule.Match.Columns)),
+		accounts:    make(map[string]bool, len(rule.Match.AccountIDs)),
+		products:    make(map[string]bool, len(rule.Match.ProductCodes)),
+		labels:      make(map[string]mappingTemplate, len(rule.Node.Labels)),
+	}
+
+	for key, expr := range rule.Match.Tags {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for tag %s: %w", key, err)
+		}
+		compiled.tags[key] = re
+	}
+	for column, expr := range rule.Match.Columns {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for column %s: %w", column, err)
+		}
+		compiled.columns[column] = re
+	}
+	for _, id := range rule.Match.AccountIDs {
+		compiled.accounts[strings.TrimSpace(id)] = true
+	}
+	for _, code := range rule.Match.ProductCodes {
+		compiled.products[strings.ToLower(strings.TrimSpace(code))] = true
+	}
+
+	var err error
+	if compiled.node, err = parseMappingTemplate(rule.Node.Name); err != nil {
+		return nil, fmt.Errorf("invalid node name: %w", err)
+	}
+	if compiled.dimension, err = parseMappingTemplate(rule.Dimension); err != nil {
+		return nil, fmt.Errorf("invalid dimension: %w", err)
+	}
+	for key, value := range rule.Node.Labels {
+		if compiled.labels[key], err = parseMappingTemplate(value); err != nil {
+			return nil, fmt.Errorf("invalid label %s: %w", key, err)
+		}
+	}
+
+	return compiled, nil
+}
+
+// Columns returns the report columns the rules read beyond those the
+// ingester always reads
+func (s *MappingRuleSet) Columns() []string {
+	seen := make(map[string]bool)
+	var columns []string
+	add := func(column string) {
+		if _, ok := curColumnsByKey[tagKey(column)]; ok {
+			return
+		}
+		if !seen[column] {
+			seen[column] = true
+			columns = append(columns, column)
+		}
+	}
+
+	for _, rule := range s.rules {
+		for column := range rule.columns {
+			add(column)
+		}
+		templates := append([]mappingTemplate{rule.node, rule.dimension}, mapValues(rule.labels)...)
+		for _, template := range templates {
+			for _, part := range template {
+				if part.source == "column" {
+					add(part.key)
+				}
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func mapValues(templates map[string]mappingTemplate) []mappingTemplate {
+	values := make([]mappingTemplate, 0, len(templates))
+	for _, template := range templates {
+		values = append(values, template)
+	}
+	return values
+}
+
+// mappingFields reads the fields of a line item that rules match on
+type mappingFields interface {
+	// Tag returns the value of a resource tag, or "" if the line item has none
+	Tag(key string) string
+	// Column returns the value of a report column, or "" if there is none
+	Column(name string) string
+}
+
+// ruleMapping is the node, and dimension, a rule maps a line item to
+type ruleMapping struct {
+	rule string
+	node *models.CostNode
+	// dimension is empty when it is derived from the usage type
+	dimension string
+}
+
+// resolve applies the first rule that maps a line item to a node, returning
+// nil when no rule does
+func (s *MappingRuleSet) resolve(ctx context.Context, fields mappingFields, resolver *nodeResolver) (*ruleMapping, error) {
+	for _, rule := range s.rules {
+		if !rule.matches(fields) {
+			continue
+		}
+		name, ok := rule.node.expand(fields)
+		if !ok {
+			continue
+		}
+
+		labels := make(map[string]interface{}, len(rule.labels))
+		for key, template := range rule.labels {
+			if value, ok := template.expand(fields); ok && value != "" {
+				labels[key] = value
+			}
+		}
+
+		node, err := resolver.find(ctx, name, rule.Node.Type, labels, rule.Node.Create)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			continue
+		}
+
+		mapping := &ruleMapping{rule: rule.Name, node: node}
+		if dimension, ok := rule.dimension.expand(fields); ok {
+			mapping.dimension = normaliseName(dimension)
+		}
+		return mapping, nil
+	}
+	return nil, nil
+}
+
+// matches reports whether a line item meets all of a rule's conditions
+func (r *mappingRule) matches(fields mappingFields) bool {
+	if len(r.accounts) > 0 && !r.accounts[fields.Column(ColLineItemUsageAccountId)] {
+		return false
+	}
+	if len(r.products) > 0 && !r.products[strings.ToLower(fields.Column(ColLineItemProductCode))] {
+		return false
+	}
+	for key, re := range r.tags {
+		if !re.MatchString(fields.Tag(key)) {
+			return false
+		}
+	}
+	for column, re := range r.columns {
+		if !re.MatchString(fields.Column(column)) {
+			return false
+		}
+	}
+	return true
+}
+
+// mappingTemplate is a node name, dimension or label value, made of literal
+// text and placeholders
+type mappingTemplate []mappingTemplatePart
+
+// mappingTemplatePart is literal text, when source is empty, or a placeholder
+type mappingTemplatePart struct {
+	text   string
+	source string
+	key    string
+	filter string
+}
+
+var mappingPlaceholder = regexp.MustCompile(`\{([^{}|]+)(?:\|([^{}]+))?\}`)
+
+func parseMappingTemplate(value string) (mappingTemplate, error) {
+	var template mappingTemplate
+	last := 0
+	for _, m := range mappingPlaceholder.FindAllStringSubmatchIndex(value, -1) {
+		if m[0] > last {
+			template = append(template, mappingTemplatePart{text: value[last:m[0]]})
+		}
+		last = m[1]
+
+		part := mappingTemplatePart{source: strings.TrimSpace(value[m[2]:m[3]])}
+		if m[4] >= 0 {
+			part.filter = strings.TrimSpace(value[m[4]:m[5]])
+		}
+		switch part.filter {
+		case "", "lower", "normalise":
+		default:
+			return nil, fmt.Errorf("unknown filter %q", part.filter)
+		}
+
+		if source, key, ok := strings.Cut(part.source, "."); ok && (source == "tag" || source == "column") {
+			part.source, part.key = source, key
+		} else if column, ok := mappingFieldColumns[part.source]; ok {
+			part.source, part.key = "column", column
+		} else {
+			return nil, fmt.Errorf("unknown placeholder {%s}", part.source)
+		}
+		template = append(template, part)
+	}
+	if last < len(value) {
+		template = append(template, mappingTemplatePart{text: value[last:]})
+	}
+	return template, nil
+}
+
+// expand fills a template's placeholders from a line item. It reports false
+// when the template is empty or leaves a placeholder empty.
+func (t mappingTemplate) expand(fields mappingFields) (string, bool) {
+	if len(t) == 0 {
+		return "", false
+	}
+
+	var b strings.Builder
+	for _, part := range t {
+		if part.source == "" {
+			b.WriteString(part.text)
+			continue
+		}
+
+		var value string
+		if part.source == "tag" {
+			value = fields.Tag(part.key)
+		} else {
+			value = fields.Column(part.key)
+		}
+		switch part.filter {
+		case "lower":
+			value = strings.ToLower(value)
+		case "normalise":
+			value = normaliseName(value)
+		}
+		if value == "" {
+			return "", false
+		}
+		b.WriteString(value)
+	}
+	return b.String(), true
+}
@@ -0,0 +1,316 @@
+package ingestion
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mapFields holds a line item's tags and columns for rule tests
+type mapFields struct {
+	tags    map[string]string
+	columns map[string]string
+}
+
+func (f mapFields) Tag(key string) string     { return f.tags[key] }
+func (f mapFields) Column(name string) string { return f.columns[name] }
+
+// testResolver resolves the named nodes without a store, creating others in a
+// dry run
+func testResolver(createMissing bool, existing ...string) *nodeResolver {
+	resolver := newNodeResolver(nil, "aws_cur", createMissing)
+	resolver.dryRun = true
+	for _, name := range existing {
+		resolver.cache[name] = &models.CostNode{ID: uuid.New(), Name: name, Type: string(models.NodeTypeProduct)}
+	}
+	return resolver
+}
+
+// noNodes marks names as having no node, so the resolver does not look them up
+func noNodes(resolver *nodeResolver, names ...string) *nodeResolver {
+	for _, name := range names {
+		resolver.cache[name] = nil
+	}
+	return resolver
+}
+
+func TestMappingRuleSetResolve(t *testing.T) {
+	rules, err := NewMappingRuleSet(MappingRules{Rules: []MappingRule{
+		{
+			Name:     "service",
+			Fallback: true,
+			Node:     MappingTarget{Name: "aws_{product_code|lower}", Create: true, Labels: map[string]string{"aws_product_code": "{product_code}"}},
+		},
+		{
+			Name:      "prod_app",
+			Match:     MappingMatch{Tags: map[string]string{"app": ".+", "env": "^prod"}},
+			Node:      MappingTarget{Name: "{tag.app}", Type: "product"},
+			Dimension: "{usage_type|normalise}",
+		},
+		{
+			Name:  "network",
+			Match: MappingMatch{AccountIDs: []string{"111122223333"}, ProductCodes: []string{"amazonvpc"}},
+			Node:  MappingTarget{Name: "shared_networking", Type: "shared", Create: true},
+		},
+	}})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// The first rule whose node exists applies
+	resolver := testResolver(true, "checkout")
+	mapping, err := rules.resolve(ctx, mapFields{
+		tags:    map[string]string{"app": "checkout", "env": "production"},
+		columns: map[string]string{ColLineItemProductCode: "AmazonEC2", ColLineItemUsageType: "EUW2-BoxUsage:t3.medium"},
+	}, resolver)
+	require.NoError(t, err)
+	assert.Equal(t, "prod_app", mapping.rule)
+	assert.Equal(t, "checkout", mapping.node.Name)
+	assert.Equal(t, "euw2_boxusage_t3_medium", mapping.dimension)
+
+	// A rule whose node does not exist, and may not be created, falls through
+	// to the fallback rule, tried after the others though listed first
+	mapping, err = rules.resolve(ctx, mapFields{
+		tags:    map[string]string{"app": "search", "env": "prod"},
+		columns: map[string]string{ColLineItemProductCode: "AmazonEC2"},
+	}, noNodes(testResolver(true), "search", "aws_amazonec2"))
+	require.NoError(t, err)
+	assert.Equal(t, "service", mapping.rule)
+	assert.Equal(t, "aws_amazonec2", mapping.node.Name)
+	assert.Equal(t, string(models.NodeTypeResource), mapping.node.Type)
+	assert.Equal(t, uuid.Nil, mapping.node.ID, "a dry run does not create nodes")
+	assert.Equal(t, map[string]interface{}{"aws_product_code": "AmazonEC2"}, mapping.node.CostLabels)
+	assert.Empty(t, mapping.dimension)
+
+	// Account IDs and product codes must both match; product codes ignore case
+	mapping, err = rules.resolve(ctx, mapFields{
+		columns: map[string]string{ColLineItemUsageAccountId: "111122223333", ColLineItemProductCode: "AmazonVPC"},
+	}, noNodes(testResolver(true), "shared_networking"))
+	require.NoError(t, err)
+	assert.Equal(t, "network", mapping.rule)
+	assert.Equal(t, "shared", mapping.node.Type)
+
+	// Without creating missing nodes, nothing applies
+	mapping, err = rules.resolve(ctx, mapFields{
+		columns: map[string]string{ColLineItemUsageAccountId: "444455556666", ColLineItemProductCode: "AmazonVPC"},
+	}, noNodes(testResolver(false), "aws_amazonvpc"))
+	require.NoError(t, err)
+	assert.Nil(t, mapping)
+
+	// A rule whose node name has an empty placeholder does not apply
+	mapping, err = rules.resolve(ctx, mapFields{}, testResolver(true))
+	require.NoError(t, err)
+	assert.Nil(t, mapping)
+}
+
+func TestNewMappingRuleSetValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		rule MappingRule
+		err  string
+	}{
+		{name: "no node", rule: MappingRule{}, err: "invalid mapping rule rule_1: node name is required"},
+		{name: "bad type", rule: MappingRule{Node: MappingTarget{Name: "x", Type: "team"}}, err: `unknown node type "team"`},
+		{name: "bad regex", rule: MappingRule{Match: MappingMatch{Tags: map[string]string{"team": "("}}, Node: MappingTarget{Name: "x"}}, err: "invalid expression for tag team"},
+		{name: "bad placeholder", rule: MappingRule{Node: MappingTarget{Name: "{team}"}}, err: "unknown placeholder {team}"},
+		{name: "bad filter", rule: MappingRule{Node: MappingTarget{Name: "{tag.team|upper}"}}, err: `unknown filter "upper"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewMappingRuleSet(MappingRules{Rules: []MappingRule{tt.rule}})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadMappingRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: team
+    match:
+      tags:
+        Team: ".+"
+      columns:
+        line_item_usage_type: "BoxUsage"
+        bill_payer_account_id: "^1"
+    node:
+      name: "team_{tag.team|normalise}"
+      type: service
+      create: true
+  - name: aws_service
+    fallback: true
+    node:
+      name: "aws_{product_code|lower}"
+`), 0o644))
+
+	rules, err := LoadMappingRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules.rules, 2)
+	assert.Equal(t, "team", rules.rules[0].Name)
+	assert.True(t, rules.rules[0].Node.Create)
+	assert.True(t, rules.rules[1].Fallback)
+	assert.Equal(t, string(models.NodeTypeResource), rules.rules[1].Node.Type)
+	// Columns the ingester reads anyway are not listed
+	assert.Equal(t, []string{"bill_payer_account_id"}, rules.Columns())
+
+	require.NoError(t, os.WriteFile(path, []byte("rules: []\n"), 0o644))
+	_, err = LoadMappingRules(path)
+	assert.ErrorContains(t, err, "has no rules")
+
+	// The documented example is valid
+	rules, err = LoadMappingRules(filepath.Join("..", "..", "docs", "cur-mapping-rules.yaml"))
+	require.NoError(t, err)
+	assert.Len(t, rules.rules, 6)
+}
+
+// TestLoadMappingRulesTagKeys checks tag keys keep their case and dots, which a
+// config loader splitting keys on dots would turn into nested maps
+func TestLoadMappingRulesTagKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: stack
+    match:
+      tags:
+        user:CostCentre: "^CC-"
+        app.kubernetes.io/name: "^api$"
+    node:
+      name: "{tag.aws:cloudformation:stack-name}"
+`), 0o644))
+
+	rules, err := LoadMappingRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules.rules, 1)
+	assert.Equal(t, map[string]string{"user:CostCentre": "^CC-", "app.kubernetes.io/name": "^api$"}, rules.rules[0].Match.Tags)
+
+	report := "lineItem/UsageStartDate,lineItem/UnblendedCost,resourceTags/user:CostCentre,resourceTags/user:app.kubernetes.io/name,resourceTags/aws:cloudformation:stack-name\n" +
+		"2024-01-15,1,CC-42,api,payments-stack\n"
+	records, err := openCURRecords(strings.NewReader(report))
+	require.NoError(t, err)
+	record, columns, err := records.Read()
+	require.NoError(t, err)
+
+	fields := columns.fields(record)
+	assert.True(t, rules.rules[0].matches(fields))
+	name, ok := rules.rules[0].node.expand(fields)
+	assert.True(t, ok)
+	assert.Equal(t, "payments-stack", name)
+}
+
+func TestCURLineItemFields(t *testing.T) {
+	reports := map[string]string{
+		"legacy": "lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/UsageAccountId,lineItem/UsageType,resourceTags/user:Team,resourceTags/aws:createdBy\n" +
+			"2024-01-15,1,111122223333,BoxUsage,Payments ,alice\n",
+		"CUR 2.0": "line_item_usage_start_date,line_item_unblended_cost,line_item_usage_account_id,line_item_usage_type,resource_tags\n" +
+			`2024-01-15,1,111122223333,BoxUsage,"{""user_team"":""Payments"",""aws_created_by"":""alice""}"` + "\n",
+	}
+
+	for name, report := range reports {
+		t.Run(name, func(t *testing.T) {
+			records, err := openCURRecords(strings.NewReader(report))
+			require.NoError(t, err)
+			record, columns, err := records.Read()
+			require.NoError(t, err)
+
+			fields := columns.fields(record)
+			assert.Equal(t, "Payments", fields.Tag("team"))
+			assert.Equal(t, "Payments", fields.Tag("user:Team"))
+			assert.Equal(t, "alice", fields.Tag("aws:createdBy"))
+			assert.Empty(t, fields.Tag("createdBy"), "aws: tags are named in full")
+			assert.Empty(t, fields.Tag("env"))
+			assert.Equal(t, "111122223333", fields.Column(ColLineItemUsageAccountId))
+			assert.Equal(t, "BoxUsage", fields.Column("line_item_usage_type"))
+			assert.Equal(t, "BoxUsage", fields.Column("lineItem/UsageType"))
+			assert.Empty(t, fields.Column("bill/PayerAccountId"))
+		})
+	}
+}
+
+// TestCURLineItemFieldsNewColumn checks a tag missing from earlier line items
+// is found once a later line item adds its column
+func TestCURLineItemFieldsNewColumn(t *testing.T) {
+	report := "line_item_usage_start_date,line_item_unblended_cost,resource_tags\n" +
+		`2024-01-15,1,"{""user_team"":""Payments""}"` + "\n" +
+		`2024-01-15,1,"{""user_team"":""Search"",""user_env"":""prod""}"` + "\n"
+
+	records, err := openCURRecords(strings.NewReader(report))
+	require.NoError(t, err)
+
+	record, columns, err := records.Read()
+	require.NoError(t, err)
+	first := columns.fields(record)
+	assert.Equal(t, "Payments", first.Tag("team"))
+	assert.Empty(t, first.Tag("env"))
+
+	record, columns, err = records.Read()
+	require.NoError(t, err)
+	second := columns.fields(record)
+	assert.Equal(t, "Search", second.Tag("team"))
+	assert.Equal(t, "prod", second.Tag("env"))
+}
+
+func TestExplainReport(t *testing.T) {
+	rules, err := NewMappingRuleSet(MappingRules{Rules: []MappingRule{
+		{Name: "team", Match: MappingMatch{Tags: map[string]string{"team": ".+"}}, Node: MappingTarget{Name: "{tag.team}"}},
+		{Name: "aws_service", Fallback: true, Node: MappingTarget{Name: "aws_{product_code|lower}", Create: true}, Dimension: "{product_code}"},
+	}})
+	require.NoError(t, err)
+	ingester := NewAWSCURIngester(nil, &AWSCURConfig{CreateMissingNodes: true, MappingRules: rules})
+
+	report := `lineItem/UsageStartDate,lineItem/UnblendedCost,lineItem/ProductCode,lineItem/UsageType,resourceTags/user:team
+2024-01-15,1.5,AmazonEC2,EUW2-BoxUsage:t3.medium,payments
+2024-01-15,0.25,AmazonS3,EUW2-TimedStorage-ByteHrs,
+2024-01-15,0,AmazonS3,EUW2-Requests-Tier1,
+2024-01-15
+`
+	var explanations []MappingExplanation
+	err = ingester.explainReport(context.Background(), strings.NewReader(report), "report.csv", noNodes(testResolver(true, "payments"), "aws_amazons3"), func(e MappingExplanation) {
+		explanations = append(explanations, e)
+	})
+	require.NoError(t, err)
+	require.Len(t, explanations, 4)
+
+	assert.Equal(t, "team", explanations[0].Rule)
+	assert.Equal(t, "payments", explanations[0].Node)
+	assert.False(t, explanations[0].NewNode)
+	assert.Equal(t, "boxusage", explanations[0].Dimension)
+	assert.Equal(t, "1.5", explanations[0].Amount.String())
+	assert.Equal(t, "USD", explanations[0].Currency)
+
+	assert.Equal(t, "aws_service", explanations[1].Rule)
+	assert.Equal(t, "aws_amazons3", explanations[1].Node)
+	assert.True(t, explanations[1].NewNode)
+	assert.Equal(t, "amazons3", explanations[1].Dimension)
+
+	assert.Equal(t, "zero cost", explanations[2].Skipped)
+	assert.Equal(t, 4, explanations[3].Row)
+	assert.NotEmpty(t, explanations[3].Skipped)
+}
+
+func TestDefaultCURMappingRules(t *testing.T) {
+	ctx := context.Background()
+	tagged := mapFields{
+		tags:    map[string]string{"service": "search", "costcenter": "retail"},
+		columns: map[string]string{ColLineItemProductCode: "AmazonEC2"},
+	}
+
+	// Tags name existing nodes, in order of product, service and cost centre
+	mapping, err := defaultCURRules.resolve(ctx, tagged, noNodes(testResolver(false, "retail"), "search"))
+	require.NoError(t, err)
+	assert.Equal(t, "cost_center_tag", mapping.rule)
+	assert.Equal(t, "retail", mapping.node.Name)
+
+	// Tags never create nodes; the AWS service's node is created instead
+	mapping, err = defaultCURRules.resolve(ctx, tagged, noNodes(testResolver(true), "search", "retail", "aws_amazonec2"))
+	require.NoError(t, err)
+	assert.Equal(t, "aws_service", mapping.rule)
+	assert.Equal(t, "aws_amazonec2", mapping.node.Name)
+}
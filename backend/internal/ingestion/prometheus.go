@@ -0,0 +1,558 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// PrometheusRules are ordered rules mapping the series of a Prometheus query
+// result to the node, and usage metric, they are recorded against. They are
+// loaded from a YAML rules file:
+//
+//	rules:
+//	  - name: prod_services
+//	    match:
+//	      namespace: "^prod"
+//	      service: ".+"
+//	    node: "{service}"
+//	    metric: http_requests
+//	    unit: count
+//	    aggregation: sum
+//	    drop_labels: [pod, instance]
+type PrometheusRules struct {
+	Rules []PrometheusRule `mapstructure:"rules"`
+}
+
+// PrometheusRule maps the series it matches to a node. Rules are tried in order
+// and the first whose node exists applies; nodes are never created.
+type PrometheusRule struct {
+	Name string `mapstructure:"name"`
+	// Match maps label names to regular expressions their values must match. A
+	// missing label has an empty value, and __name__ holds the metric name.
+	Match map[string]string `mapstructure:"match"`
+	// Node names the node, and may hold {<label>} placeholders, each optionally
+	// followed by |lower or |normalise. A rule does not apply to a series that
+	// leaves any placeholder empty.
+	Node string `mapstructure:"node"`
+	// Metric names the usage metric, and may hold placeholders. It defaults to
+	// {__name__|normalise}, which queries applying functions do not have.
+	Metric string `mapstructure:"metric"`
+	// Unit defaults to count
+	Unit string `mapstructure:"unit"`
+	// Aggregation combines a series' samples per day; empty means sum
+	Aggregation Aggregation `mapstructure:"aggregation"`
+	// DropLabels are left off the usage's labels, with __name__. Series that
+	// differ only in dropped labels are aggregated together.
+	DropLabels []string `mapstructure:"drop_labels"`
+}
+
+// DefaultPrometheusRules returns the rules used when no rules file is given:
+// the node named by a series' service, app or job label, in that order,
+// recording its metric as a count
+func DefaultPrometheusRules() PrometheusRules {
+	return PrometheusRules{Rules: []PrometheusRule{
+		{Name: "service_label", Match: map[string]string{"service": "."}, Node: "{service}"},
+		{Name: "app_label", Match: map[string]string{"app": "."}, Node: "{app}"},
+		{Name: "job_label", Match: map[string]string{"job": "."}, Node: "{job}"},
+	}}
+}
+
+// LoadPrometheusRules loads Prometheus mapping rules from a YAML file
+func LoadPrometheusRules(path string) (*PrometheusRuleSet, error) {
+	var rules PrometheusRules
+	if err := readRulesFile(path, &rules); err != nil {
+		return nil, err
+	}
+	if len(rules.Rules) == 0 {
+		return nil, fmt.Errorf("mapping rules file %s has no rules", path)
+	}
+	return NewPrometheusRuleSet(rules)
+}
+
+// PrometheusRuleSet is a validated, compiled set of Prometheus mapping rules
+type PrometheusRuleSet struct {
+	rules []*prometheusRule
+}
+
+type prometheusRule struct {
+	PrometheusRule
+	match  map[string]*regexp.Regexp
+	node   mappingTemplate
+	metric mappingTemplate
+	drop   map[string]bool
+}
+
+// NewPrometheusRuleSet validates and compiles Prometheus mapping rules
+func NewPrometheusRuleSet(rules PrometheusRules) (*PrometheusRuleSet, error) {
+	set := &PrometheusRuleSet{}
+	for i, rule := range rules.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule_%d", i+1)
+		}
+		compiled, err := compilePrometheusRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mapping rule %s: %w", rule.Name, err)
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	return set, nil
+}
+
+func compilePrometheusRule(rule PrometheusRule) (*prometheusRule, error) {
+	if rule.Node == "" {
+		return nil, fmt.Errorf("node is required")
+	}
+	if rule.Metric == "" {
+		rule.Metric = "{__name__|normalise}"
+	}
+	if rule.Unit == "" {
+		rule.Unit = "count"
+	}
+	if !rule.Aggregation.valid() {
+		return nil, fmt.Errorf("unknown aggregation %q", rule.Aggregation)
+	}
+
+	compiled := &prometheusRule{
+		PrometheusRule: rule,
+		match:          make(map[string]*regexp.Regexp, len(rule.Match)),
+		drop:           map[string]bool{"__name__": true},
+	}
+	for label, expr := range rule.Match {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression for label %s: %w", label, err)
+		}
+		compiled.match[label] = re
+	}
+	for _, label := range rule.DropLabels {
+		compiled.drop[label] = true
+	}
+
+	var err error
+	if compiled.node, err = parseLabelTemplate(rule.Node); err != nil {
+		return nil, fmt.Errorf("invalid node name: %w", err)
+	}
+	if compiled.metric, err = parseLabelTemplate(rule.Metric); err != nil {
+		return nil, fmt.Errorf("invalid metric: %w", err)
+	}
+	return compiled, nil
+}
+
+// parseLabelTemplate parses a template whose placeholders name series labels
+func parseLabelTemplate(value string) (mappingTemplate, error) {
+	var template mappingTemplate
+	last := 0
+	for _, m := range mappingPlaceholder.FindAllStringSubmatchIndex(value, -1) {
+		if m[0] > last {
+			template = append(template, mappingTemplatePart{text: value[last:m[0]]})
+		}
+		last = m[1]
+
+		part := mappingTemplatePart{source: "tag", key: strings.TrimSpace(value[m[2]:m[3]])}
+		if m[4] >= 0 {
+			part.filter = strings.TrimSpace(value[m[4]:m[5]])
+		}
+		switch part.filter {
+		case "", "lower", "normalise":
+		default:
+			return nil, fmt.Errorf("unknown filter %q", part.filter)
+		}
+		template = append(template, part)
+	}
+	if last < len(value) {
+		template = append(template, mappingTemplatePart{text: value[last:]})
+	}
+	return template, nil
+}
+
+// seriesLabels are the labels of a series, read by rules as tags and columns
+type seriesLabels map[string]string
+
+// Tag returns a label's value. Rules files lower case label names, so a label
+// missing by its exact name is matched ignoring case.
+func (l seriesLabels) Tag(key string) string {
+	if value, ok := l[key]; ok {
+		return value
+	}
+	for name, value := range l {
+		if strings.EqualFold(name, key) {
+			return value
+		}
+	}
+	return ""
+}
+
+// Column returns a label's value, as Tag does
+func (l seriesLabels) Column(name string) string {
+	return l.Tag(name)
+}
+
+// String describes the series in PromQL notation
+func (l seriesLabels) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		if name != "__name__" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s=%q", name, l[name])
+	}
+	return l["__name__"] + "{" + strings.Join(parts, ",") + "}"
+}
+
+// matches reports whether a series meets all of a rule's conditions
+func (r *prometheusRule) matches(labels seriesLabels) bool {
+	for label, re := range r.match {
+		if !re.MatchString(labels.Tag(label)) {
+			return false
+		}
+	}
+	return true
+}
+
+// PrometheusQueryResponse is the JSON Prometheus returns from its query_range
+// and query APIs
+type PrometheusQueryResponse struct {
+	Status    string              `json:"status"`
+	Data      PrometheusQueryData `json:"data"`
+	ErrorType string              `json:"errorType,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+// PrometheusQueryData holds a query's result: a matrix from a range query, or
+// a vector from an instant query
+type PrometheusQueryData struct {
+	ResultType string             `json:"resultType"`
+	Result     []PrometheusSeries `json:"result"`
+}
+
+// PrometheusSeries is a series of a query result
+type PrometheusSeries struct {
+	Metric map[string]string `json:"metric"`
+	// Values holds a matrix series' samples, and Value a vector series' sample
+	Values []PrometheusSample `json:"values,omitempty"`
+	Value  *PrometheusSample  `json:"value,omitempty"`
+}
+
+// PrometheusSample is a sample, encoded as [<unix seconds>, "<value>"]
+type PrometheusSample struct {
+	Time  time.Time
+	Value string
+}
+
+// UnmarshalJSON decodes a sample from its [<unix seconds>, "<value>"] pair
+func (s *PrometheusSample) UnmarshalJSON(data []byte) error {
+	var pair []json.RawMessage
+	if err := json.Unmarshal(data, &pair); err != nil {
+		return err
+	}
+	if len(pair) != 2 {
+		return fmt.Errorf("sample has %d elements, expected 2", len(pair))
+	}
+
+	var seconds float64
+	if err := json.Unmarshal(pair[0], &seconds); err != nil {
+		return fmt.Errorf("invalid sample timestamp: %w", err)
+	}
+	if err := json.Unmarshal(pair[1], &s.Value); err != nil {
+		return fmt.Errorf("invalid sample value: %w", err)
+	}
+	s.Time = time.UnixMilli(int64(math.Round(seconds * 1000))).UTC()
+	return nil
+}
+
+// PrometheusIngester handles ingestion of Prometheus query results as usage
+type PrometheusIngester struct {
+	store       *store.Store
+	url         string
+	bearerToken string
+	client      *http.Client
+	rules       *PrometheusRuleSet
+}
+
+// PrometheusConfig holds configuration for Prometheus ingestion
+type PrometheusConfig struct {
+	// URL is the Prometheus server IngestQuery queries, e.g. http://prometheus:9090
+	URL string
+	// BearerToken, when set, authorises queries
+	BearerToken string
+	// Rules map series to nodes; the default rules are used when nil
+	Rules *PrometheusRuleSet
+	// HTTPClient sends queries; a client with a one minute timeout by default
+	HTTPClient *http.Client
+}
+
+// PrometheusQuery is a range query to run on the Prometheus server
+type PrometheusQuery struct {
+	Query string
+	Start time.Time
+	End   time.Time
+	// Step is the resolution of the result, e.g. one hour for increase(x[1h])
+	Step time.Duration
+}
+
+// defaultPrometheusRules are the rules of DefaultPrometheusRules, compiled
+var defaultPrometheusRules = func() *PrometheusRuleSet {
+	rules, err := NewPrometheusRuleSet(DefaultPrometheusRules())
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}()
+
+// NewPrometheusIngester creates a new Prometheus ingester
+func NewPrometheusIngester(store *store.Store, config *PrometheusConfig) *PrometheusIngester {
+	if config == nil {
+		config = &PrometheusConfig{}
+	}
+
+	ingester := &PrometheusIngester{
+		store:       store,
+		url:         strings.TrimRight(config.URL, "/"),
+		bearerToken: config.BearerToken,
+		client:      config.HTTPClient,
+		rules:       config.Rules,
+	}
+	if ingester.client == nil {
+		ingester.client = &http.Client{Timeout: time.Minute}
+	}
+	if ingester.rules == nil {
+		ingester.rules = defaultPrometheusRules
+	}
+	return ingester
+}
+
+// IngestFile ingests a query result saved from the query_range API
+func (p *PrometheusIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return p.IngestReader(ctx, file)
+}
+
+// IngestReader ingests a query result from a reader
+func (p *PrometheusIngester) IngestReader(ctx context.Context, reader io.Reader) (*IngestionResult, error) {
+	var response PrometheusQueryResponse
+	if err := json.NewDecoder(reader).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode Prometheus query result: %w", err)
+	}
+
+	return p.processResponse(ctx, &response)
+}
+
+// IngestQuery runs a range query on the Prometheus server and ingests its result
+func (p *PrometheusIngester) IngestQuery(ctx context.Context, query PrometheusQuery) (*IngestionResult, error) {
+	response, err := p.query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.processResponse(ctx, response)
+}
+
+// query runs a range query on the Prometheus server
+func (p *PrometheusIngester) query(ctx context.Context, query PrometheusQuery) (*PrometheusQueryResponse, error) {
+	if p.url == "" {
+		return nil, fmt.Errorf("no Prometheus URL is configured")
+	}
+	if query.Step <= 0 {
+		return nil, fmt.Errorf("query step must be positive")
+	}
+
+	params := url.Values{}
+	params.Set("query", query.Query)
+	params.Set("start", query.Start.UTC().Format(time.RFC3339))
+	params.Set("end", query.End.UTC().Format(time.RFC3339))
+	params.Set("step", strconv.FormatFloat(query.Step.Seconds(), 'f', -1, 64))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+"/api/v1/query_range?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Prometheus query: %w", err)
+	}
+	request.Header.Set("Accept", "application/json")
+	if p.bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	}
+
+	response, err := p.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Prometheus: %w", err)
+	}
+	defer response.Body.Close()
+
+	// Failed queries return an error response with a 4xx or 5xx status
+	var result PrometheusQueryResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("prometheus query failed with status %s", response.Status)
+		}
+		return nil, fmt.Errorf("failed to decode Prometheus query result: %w", err)
+	}
+	if result.Status == "error" {
+		return nil, fmt.Errorf("prometheus query failed: %s: %s", result.ErrorType, result.Error)
+	}
+
+	return &result, nil
+}
+
+// processResponse stores a query result's series as daily usage
+func (p *PrometheusIngester) processResponse(ctx context.Context, response *PrometheusQueryResponse) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    "prometheus",
+		StartTime: time.Now(),
+	}
+
+	if response.Status == "error" {
+		return nil, fmt.Errorf("prometheus query failed: %s: %s", response.ErrorType, response.Error)
+	}
+	if rt := response.Data.ResultType; rt != "matrix" && rt != "vector" {
+		return nil, fmt.Errorf("unsupported Prometheus result type %q, expected matrix or vector", rt)
+	}
+
+	resolver := newNodeResolver(p.store, "prometheus", false)
+	usage, err := p.aggregate(ctx, response.Data.Result, resolver)
+	if err != nil {
+		return nil, err
+	}
+	result.RecordsProcessed = usage.processed
+	result.RecordsSkipped = usage.skipped
+	result.UnmappedEntities = usage.unmapped
+	if len(usage.unmapped) > 0 {
+		log.Warn().
+			Strs("series", usage.unmapped).
+			Msg("No rule maps Prometheus series to a node, skipping them")
+	}
+
+	if len(usage.daily) > 0 {
+		if err := p.store.Usage.BulkUpsertWithLabels(ctx, usage.daily); err != nil {
+			return nil, fmt.Errorf("failed to store usage records: %w", err)
+		}
+		result.RecordsInserted = len(usage.daily)
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	log.Info().
+		Int("series", len(response.Data.Result)).
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("skipped", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("Prometheus ingestion completed")
+
+	return result, nil
+}
+
+// aggregate maps each series to a node by the rules and aggregates its samples
+// per day with its labels, less those the rule drops
+func (p *PrometheusIngester) aggregate(ctx context.Context, series []PrometheusSeries, resolver *nodeResolver) (*exportUsage, error) {
+	usage := &exportUsage{}
+	daily := make(usageSeriesSet)
+	unmapped := make(map[string]bool)
+
+	for _, s := range series {
+		samples := s.Values
+		if s.Value != nil {
+			samples = append(samples, *s.Value)
+		}
+		usage.processed += len(samples)
+
+		labels := seriesLabels(s.Metric)
+		rule, node, metric, err := p.rules.resolve(ctx, labels, resolver)
+		if err != nil {
+			return nil, err
+		}
+		if node == nil {
+			unmapped[labels.String()] = true
+			usage.skipped += len(samples)
+			continue
+		}
+
+		kept := make(map[string]string, len(labels))
+		for name, value := range labels {
+			if !rule.drop[name] {
+				kept[name] = value
+			}
+		}
+		mapping := MetricMapping{InternalName: metric, Unit: rule.Unit, Aggregation: rule.Aggregation}
+		key := usageSeriesKey{nodeID: node.ID, metric: metric, labels: labelSetKey(kept)}
+
+		for _, sample := range samples {
+			// Usage cannot be negative, and NaN or infinite values cannot be stored
+			value, err := decimal.NewFromString(sample.Value)
+			if err != nil || value.IsNegative() {
+				usage.skipped++
+				continue
+			}
+			key.period = time.Date(sample.Time.Year(), sample.Time.Month(), sample.Time.Day(), 0, 0, 0, 0, time.UTC)
+			daily.add(key, mapping, kept, value)
+		}
+	}
+
+	for _, series := range daily.sorted() {
+		usage.daily = append(usage.daily, models.NodeUsageByDimension{
+			NodeID:      series.key.nodeID,
+			UsageDate:   series.key.period,
+			Metric:      series.key.metric,
+			Value:       series.value(),
+			Unit:        series.mapping.Unit,
+			Labels:      series.labels,
+			Source:      "prometheus",
+			Aggregation: string(series.mapping.Aggregation),
+		})
+	}
+	usage.unmapped = sortedKeys(unmapped)
+
+	return usage, nil
+}
+
+// resolve applies the first rule that maps a series to an existing node,
+// returning a nil node when no rule does
+func (s *PrometheusRuleSet) resolve(ctx context.Context, labels seriesLabels, resolver *nodeResolver) (*prometheusRule, *models.CostNode, string, error) {
+	for _, rule := range s.rules {
+		if !rule.matches(labels) {
+			continue
+		}
+		name, ok := rule.node.expand(labels)
+		if !ok {
+			continue
+		}
+		metric, ok := rule.metric.expand(labels)
+		if !ok {
+			continue
+		}
+
+		node, err := resolver.find(ctx, name, "", nil, false)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		if node != nil {
+			return rule, node, metric, nil
+		}
+	}
+	return nil, nil, "", nil
+}
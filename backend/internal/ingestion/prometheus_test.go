@@ -0,0 +1,223 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const prometheusMatrix = `{
+  "status": "success",
+  "data": {
+    "resultType": "matrix",
+    "result": [
+      {
+        "metric": {"__name__": "http_requests_total", "service": "checkout", "pod": "checkout-1", "customer_id": "c1"},
+        "values": [[1705309200, "10"], [1705312800, "20"], [1705392000.5, "5"]]
+      },
+      {
+        "metric": {"__name__": "http_requests_total", "service": "checkout", "pod": "checkout-2", "customer_id": "c1"},
+        "values": [[1705309200, "30"], [1705312800, "NaN"]]
+      },
+      {
+        "metric": {"__name__": "http_requests_total", "service": "search", "pod": "search-1"},
+        "values": [[1705309200, "7"]]
+      },
+      {
+        "metric": {"__name__": "http_requests_total", "service": "inventory"},
+        "values": [[1705309200, "1"], [1705312800, "2"]]
+      },
+      {
+        "metric": {"__name__": "process_resident_memory_bytes", "job": "checkout"},
+        "values": [[1705309200, "100"], [1705312800, "300"]]
+      }
+    ]
+  }
+}`
+
+func TestPrometheusSampleUnmarshal(t *testing.T) {
+	var response PrometheusQueryResponse
+	require.NoError(t, json.Unmarshal([]byte(prometheusMatrix), &response))
+	require.Len(t, response.Data.Result, 5)
+
+	sample := response.Data.Result[0].Values[2]
+	assert.Equal(t, time.Date(2024, 1, 16, 8, 0, 0, 500000000, time.UTC), sample.Time)
+	assert.Equal(t, "5", sample.Value)
+
+	var bad PrometheusSample
+	assert.ErrorContains(t, json.Unmarshal([]byte(`[1705309200]`), &bad), "expected 2")
+	assert.Error(t, json.Unmarshal([]byte(`["now", "1"]`), &bad))
+}
+
+func TestPrometheusAggregate(t *testing.T) {
+	rules, err := NewPrometheusRuleSet(PrometheusRules{Rules: []PrometheusRule{
+		{
+			Name:       "requests",
+			Match:      map[string]string{"__name__": "^http_requests_total$"},
+			Node:       "{service}",
+			Metric:     "http_requests",
+			DropLabels: []string{"pod"},
+		},
+		{Name: "memory", Match: map[string]string{"__name__": "memory"}, Node: "{job}", Unit: "bytes", Aggregation: AggregationMax},
+	}})
+	require.NoError(t, err)
+
+	var response PrometheusQueryResponse
+	require.NoError(t, json.Unmarshal([]byte(prometheusMatrix), &response))
+
+	ingester := NewPrometheusIngester(nil, &PrometheusConfig{Rules: rules})
+	resolver := noNodes(testResolver(false, "checkout", "search"), "inventory")
+	usage, err := ingester.aggregate(context.Background(), response.Data.Result, resolver)
+	require.NoError(t, err)
+
+	assert.Equal(t, 10, usage.processed)
+	assert.Equal(t, 3, usage.skipped, "two unmapped samples and a NaN")
+	assert.Equal(t, []string{`http_requests_total{service="inventory"}`}, usage.unmapped)
+
+	checkout := resolver.cache["checkout"].ID
+	search := resolver.cache["search"].ID
+	type daily struct {
+		node   string
+		date   string
+		metric string
+		labels map[string]string
+		value  string
+		unit   string
+	}
+	names := map[string]string{checkout.String(): "checkout", search.String(): "search"}
+	got := make(map[string]daily)
+	for _, record := range usage.daily {
+		assert.Equal(t, "prometheus", record.Source)
+		d := daily{names[record.NodeID.String()], record.UsageDate.Format("2006-01-02"), record.Metric, record.Labels, record.Value.String(), record.Unit}
+		got[d.node+" "+d.date+" "+d.metric] = d
+	}
+	assert.Len(t, got, 4)
+
+	// Pods are dropped, so their series are summed per day
+	assert.Equal(t, daily{"checkout", "2024-01-15", "http_requests", map[string]string{"service": "checkout", "customer_id": "c1"}, "60", "count"}, got["checkout 2024-01-15 http_requests"])
+	assert.Equal(t, "5", got["checkout 2024-01-16 http_requests"].value)
+	assert.Equal(t, "7", got["search 2024-01-15 http_requests"].value)
+	// Without a metric, the series' name is used
+	assert.Equal(t, daily{"checkout", "2024-01-15", "process_resident_memory_bytes", map[string]string{"job": "checkout"}, "300", "bytes"}, got["checkout 2024-01-15 process_resident_memory_bytes"])
+}
+
+func TestPrometheusDefaultRules(t *testing.T) {
+	ctx := context.Background()
+	resolver := noNodes(testResolver(false, "payments", "billing"), "checkout")
+
+	// A label naming no node falls through to the next rule
+	rule, node, metric, err := defaultPrometheusRules.resolve(ctx, seriesLabels{"__name__": "up", "service": "checkout", "app": "payments", "job": "billing"}, resolver)
+	require.NoError(t, err)
+	assert.Equal(t, "app_label", rule.Name)
+	assert.Equal(t, "payments", node.Name)
+	assert.Equal(t, "up", metric)
+
+	// Series from functions have no name, so the default metric does not apply
+	_, node, _, err = defaultPrometheusRules.resolve(ctx, seriesLabels{"job": "billing"}, resolver)
+	require.NoError(t, err)
+	assert.Nil(t, node)
+}
+
+func TestNewPrometheusRuleSetValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		rule PrometheusRule
+		err  string
+	}{
+		{name: "no node", rule: PrometheusRule{}, err: "invalid mapping rule rule_1: node is required"},
+		{name: "bad regex", rule: PrometheusRule{Node: "x", Match: map[string]string{"job": "("}}, err: "invalid expression for label job"},
+		{name: "bad filter", rule: PrometheusRule{Node: "{job|upper}"}, err: `unknown filter "upper"`},
+		{name: "bad aggregation", rule: PrometheusRule{Node: "x", Aggregation: "median"}, err: `unknown aggregation "median"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPrometheusRuleSet(PrometheusRules{Rules: []PrometheusRule{tt.rule}})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+func TestLoadPrometheusRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+rules:
+  - name: requests
+    match:
+      serviceName: ".+"
+    node: "{serviceName|normalise}"
+    metric: http_requests
+    aggregation: sum
+    drop_labels: [pod]
+`), 0o644))
+
+	rules, err := LoadPrometheusRules(path)
+	require.NoError(t, err)
+	require.Len(t, rules.rules, 1)
+	assert.Equal(t, "count", rules.rules[0].Unit)
+	assert.True(t, rules.rules[0].drop["pod"])
+
+	// Label names in matches are lower cased by the YAML loader, and still match
+	rule, node, _, err := rules.resolve(context.Background(), seriesLabels{"serviceName": "Check Out"}, testResolver(false, "check_out"))
+	require.NoError(t, err)
+	assert.Equal(t, "requests", rule.Name)
+	assert.Equal(t, "check_out", node.Name)
+
+	// The documented example is valid
+	_, err = LoadPrometheusRules(filepath.Join("..", "..", "docs", "prometheus-rules.yaml"))
+	require.NoError(t, err)
+}
+
+func TestPrometheusQuery(t *testing.T) {
+	var request *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request = r
+		if r.URL.Query().Get("query") == "bad(" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+			return
+		}
+		w.Write([]byte(prometheusMatrix))
+	}))
+	defer server.Close()
+
+	ingester := NewPrometheusIngester(nil, &PrometheusConfig{URL: server.URL + "/", BearerToken: "secret"})
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	query := PrometheusQuery{Query: "sum by (service) (increase(http_requests_total[1h]))", Start: start, End: start.Add(24 * time.Hour), Step: time.Hour}
+
+	response, err := ingester.query(context.Background(), query)
+	require.NoError(t, err)
+	assert.Len(t, response.Data.Result, 5)
+
+	assert.Equal(t, "/api/v1/query_range", request.URL.Path)
+	assert.Equal(t, query.Query, request.URL.Query().Get("query"))
+	assert.Equal(t, "2024-01-15T00:00:00Z", request.URL.Query().Get("start"))
+	assert.Equal(t, "2024-01-16T00:00:00Z", request.URL.Query().Get("end"))
+	assert.Equal(t, "3600", request.URL.Query().Get("step"))
+	assert.Equal(t, "Bearer secret", request.Header.Get("Authorization"))
+
+	query.Query = "bad("
+	_, err = ingester.query(context.Background(), query)
+	assert.ErrorContains(t, err, "prometheus query failed: bad_data: parse error")
+
+	_, err = NewPrometheusIngester(nil, nil).query(context.Background(), query)
+	assert.ErrorContains(t, err, "no Prometheus URL")
+}
+
+func TestPrometheusRejectsUnsupportedResults(t *testing.T) {
+	ingester := NewPrometheusIngester(nil, nil)
+	_, err := ingester.IngestReader(context.Background(), strings.NewReader(`{"status":"success","data":{"resultType":"scalar","result":[]}}`))
+	assert.ErrorContains(t, err, `unsupported Prometheus result type "scalar"`)
+
+	_, err = ingester.IngestReader(context.Background(), strings.NewReader(`{"status":"error","errorType":"timeout","error":"query timed out"}`))
+	assert.ErrorContains(t, err, "query timed out")
+}
@@ -0,0 +1,646 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/pickeringtech/FinOpsAggregator/internal/store"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// UsageCSVMapping describes how the columns of a usage CSV file map to usage
+// records. It is loaded from a YAML mapping file:
+//
+//	date:
+//	  column: day
+//	  format: "02/01/2006"
+//	node:
+//	  name_column: service
+//	metric:
+//	  value: api_requests
+//	value: request_count
+//	unit:
+//	  column: unit
+//	  value: count
+//	labels:
+//	  customer_id: customer
+//	units:
+//	  api_requests: count
+type UsageCSVMapping struct {
+	Date UsageCSVDate `mapstructure:"date"`
+	Node UsageCSVNode `mapstructure:"node"`
+	// Metric names the metric of each row
+	Metric UsageCSVField `mapstructure:"metric"`
+	// Value is the column holding each row's usage
+	Value string `mapstructure:"value"`
+	// Unit names the unit of each row's usage
+	Unit UsageCSVField `mapstructure:"unit"`
+	// Labels maps label names to the columns holding their values. Rows with
+	// an empty column do not have the label. Mapping files lower case names.
+	Labels map[string]string `mapstructure:"labels"`
+	// Units maps metrics, ignoring case, to the unit their rows must be in.
+	// Rows of other metrics must be in the unit most of the metric's rows are
+	// in, ties going to the unit that appears first.
+	Units map[string]string `mapstructure:"units"`
+	// Aggregation combines rows for the same node, date, metric and labels;
+	// sum by default
+	Aggregation Aggregation `mapstructure:"aggregation"`
+	// Source is recorded as the source of the usage; usage_csv by default
+	Source string `mapstructure:"source"`
+}
+
+// UsageCSVDate names the column holding each row's date and its format
+type UsageCSVDate struct {
+	Column string `mapstructure:"column"`
+	// Format is a Go time layout, rfc3339 or unix (seconds); 2006-01-02 by
+	// default. Times are recorded on their UTC date.
+	Format string `mapstructure:"format"`
+}
+
+// UsageCSVNode names the column holding each row's node, by name or by ID
+type UsageCSVNode struct {
+	NameColumn string `mapstructure:"name_column"`
+	IDColumn   string `mapstructure:"id_column"`
+	// Type is the type of nodes created by name; resource by default
+	Type string `mapstructure:"type"`
+}
+
+// UsageCSVField is a value read from a column, or the fixed value given when
+// there is no column or a row's column is empty
+type UsageCSVField struct {
+	Column string `mapstructure:"column"`
+	Value  string `mapstructure:"value"`
+}
+
+// DefaultUsageCSVMapping returns the mapping used when no mapping file is
+// given: the columns date, node, metric, value and unit, with dates formatted
+// 2006-01-02 and nodes named
+func DefaultUsageCSVMapping() UsageCSVMapping {
+	return UsageCSVMapping{
+		Date:   UsageCSVDate{Column: "date"},
+		Node:   UsageCSVNode{NameColumn: "node"},
+		Metric: UsageCSVField{Column: "metric"},
+		Value:  "value",
+		Unit:   UsageCSVField{Column: "unit"},
+	}
+}
+
+// LoadUsageCSVMapping loads a usage CSV mapping from a YAML file
+func LoadUsageCSVMapping(path string) (*UsageCSVMapping, error) {
+	var mapping UsageCSVMapping
+	if err := readRulesFile(path, &mapping); err != nil {
+		return nil, err
+	}
+	if err := mapping.validate(); err != nil {
+		return nil, fmt.Errorf("invalid usage mapping %s: %w", path, err)
+	}
+	return &mapping, nil
+}
+
+// validate checks the mapping names every column a usage record needs, and
+// fills in defaults
+func (m *UsageCSVMapping) validate() error {
+	if m.Date.Column == "" {
+		return errors.New("date column is required")
+	}
+	if m.Date.Format == "" {
+		m.Date.Format = "2006-01-02"
+	}
+	if (m.Node.NameColumn == "") == (m.Node.IDColumn == "") {
+		return errors.New("exactly one of the node name and ID columns is required")
+	}
+	if m.Node.Type == "" {
+		m.Node.Type = string(models.NodeTypeResource)
+	}
+	if m.Metric.Column == "" && m.Metric.Value == "" {
+		return errors.New("metric column or value is required")
+	}
+	if m.Value == "" {
+		return errors.New("value column is required")
+	}
+	if m.Unit.Column == "" && m.Unit.Value == "" && len(m.Units) == 0 {
+		return errors.New("unit column, value or units are required")
+	}
+	if !m.Aggregation.valid() {
+		return fmt.Errorf("unknown aggregation %q", m.Aggregation)
+	}
+	if m.Source == "" {
+		m.Source = "usage_csv"
+	}
+
+	units := make(map[string]string, len(m.Units))
+	for metric, unit := range m.Units {
+		units[strings.ToLower(metric)] = unit
+	}
+	m.Units = units
+	return nil
+}
+
+// columns returns the columns the mapping reads
+func (m *UsageCSVMapping) columns() []string {
+	var columns []string
+	for _, column := range []string{m.Date.Column, m.Node.NameColumn, m.Node.IDColumn, m.Metric.Column, m.Value, m.Unit.Column} {
+		if column != "" {
+			columns = append(columns, column)
+		}
+	}
+	for _, column := range m.Labels {
+		columns = append(columns, column)
+	}
+	return columns
+}
+
+// UsageCSVIngester handles ingestion of usage from CSV files whose columns
+// are described by a mapping
+type UsageCSVIngester struct {
+	store              *store.Store
+	mapping            UsageCSVMapping
+	batchSize          int
+	createMissingNodes bool
+	rejectedPath       string
+	progressChan       chan IngestionProgress
+}
+
+// UsageCSVConfig configures the usage CSV ingester
+type UsageCSVConfig struct {
+	// Mapping describes the file's columns; DefaultUsageCSVMapping when nil
+	Mapping            *UsageCSVMapping
+	BatchSize          int
+	CreateMissingNodes bool
+	// RejectedPath is the CSV file rejected rows are written to, with their
+	// line and error. No file is written when it is empty or no row is rejected.
+	RejectedPath string
+	ProgressChan chan IngestionProgress
+}
+
+// NewUsageCSVIngester creates a new usage CSV ingester
+func NewUsageCSVIngester(store *store.Store, config *UsageCSVConfig) (*UsageCSVIngester, error) {
+	if config == nil {
+		config = &UsageCSVConfig{}
+	}
+
+	ingester := &UsageCSVIngester{
+		store:              store,
+		mapping:            DefaultUsageCSVMapping(),
+		batchSize:          1000,
+		createMissingNodes: config.CreateMissingNodes,
+		rejectedPath:       config.RejectedPath,
+		progressChan:       config.ProgressChan,
+	}
+	if config.Mapping != nil {
+		ingester.mapping = *config.Mapping
+	}
+	if config.BatchSize > 0 {
+		ingester.batchSize = config.BatchSize
+	}
+	if err := ingester.mapping.validate(); err != nil {
+		return nil, fmt.Errorf("invalid usage mapping: %w", err)
+	}
+	return ingester, nil
+}
+
+// IngestFile ingests a usage CSV file
+func (u *UsageCSVIngester) IngestFile(ctx context.Context, filePath string) (*IngestionResult, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	u.reportProgress(IngestionProgress{
+		CurrentFile: filePath,
+		Message:     fmt.Sprintf("Starting ingestion of %s", filePath),
+	})
+
+	return u.IngestReader(ctx, file)
+}
+
+// IngestReader ingests usage CSV from a reader. Rows that cannot be parsed,
+// name no node or are in the wrong unit are rejected and the rest imported.
+func (u *UsageCSVIngester) IngestReader(ctx context.Context, reader io.Reader) (*IngestionResult, error) {
+	result := &IngestionResult{
+		Source:    u.mapping.Source,
+		StartTime: time.Now(),
+	}
+
+	imp := u.newImport(newNodeResolver(u.store, u.mapping.Source, u.createMissingNodes))
+	if err := imp.read(ctx, reader); err != nil {
+		return nil, err
+	}
+
+	result.RecordsProcessed = imp.processed
+	result.RecordsSkipped = len(imp.rejected)
+	for _, rejection := range imp.rejected {
+		result.Errors = append(result.Errors, fmt.Sprintf("line %d: %s", rejection.line, rejection.err))
+	}
+
+	if len(imp.rejected) > 0 && u.rejectedPath != "" {
+		if err := writeRejectedRows(u.rejectedPath, imp.header, imp.rejected); err != nil {
+			return nil, err
+		}
+		result.RejectedFile = u.rejectedPath
+	}
+
+	usage := imp.usage()
+	for start := 0; start < len(usage); start += u.batchSize {
+		end := start + u.batchSize
+		if end > len(usage) {
+			end = len(usage)
+		}
+		if err := u.store.Usage.BulkUpsertWithLabels(ctx, usage[start:end]); err != nil {
+			return nil, fmt.Errorf("failed to store usage records: %w", err)
+		}
+		result.RecordsInserted += end - start
+
+		u.reportProgress(IngestionProgress{
+			RecordsProcessed: result.RecordsProcessed,
+			RecordsInserted:  result.RecordsInserted,
+			RecordsSkipped:   result.RecordsSkipped,
+			Message:          fmt.Sprintf("Processed %d records, inserted %d", result.RecordsProcessed, result.RecordsInserted),
+		})
+	}
+
+	result.EndTime = time.Now()
+	result.Duration = result.EndTime.Sub(result.StartTime)
+
+	log.Info().
+		Int("processed", result.RecordsProcessed).
+		Int("inserted", result.RecordsInserted).
+		Int("rejected", result.RecordsSkipped).
+		Dur("duration", result.Duration).
+		Msg("Usage CSV ingestion completed")
+
+	return result, nil
+}
+
+// reportProgress sends progress updates if a channel is configured
+func (u *UsageCSVIngester) reportProgress(progress IngestionProgress) {
+	sendProgress(u.progressChan, progress)
+}
+
+// usageCSVRejection is a row rejected by the import, with its line number
+type usageCSVRejection struct {
+	line   int
+	record []string
+	err    error
+}
+
+// usageCSVImport is the state of a single usage CSV import
+type usageCSVImport struct {
+	ingester *UsageCSVIngester
+	resolver *nodeResolver
+	// nodeIDs records whether nodes named by ID exist
+	nodeIDs map[uuid.UUID]bool
+	// rows holds the parsed rows until every metric's unit is known
+	rows []usageCSVRow
+	// units holds the unit of each metric, by lower case metric, once known
+	units     map[string]string
+	daily     usageSeriesSet
+	header    []string
+	colIndex  map[string]int
+	processed int
+	rejected  []usageCSVRejection
+}
+
+// newImport starts an import finding nodes by name with resolver
+func (u *UsageCSVIngester) newImport(resolver *nodeResolver) *usageCSVImport {
+	return &usageCSVImport{
+		ingester: u,
+		resolver: resolver,
+		nodeIDs:  make(map[uuid.UUID]bool),
+		units:    make(map[string]string),
+		daily:    make(usageSeriesSet),
+	}
+}
+
+// read reads every row of the file, adding accepted rows to their series and
+// recording rejected rows. Rows are checked against their metric's unit once
+// the whole file has been read, as it depends on every other row of the metric.
+func (imp *usageCSVImport) read(ctx context.Context, reader io.Reader) error {
+	csvReader := csv.NewReader(reader)
+	csvReader.LazyQuotes = true
+	csvReader.TrimLeadingSpace = true
+	csvReader.FieldsPerRecord = -1
+
+	header, err := csvReader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	imp.header = header
+	imp.colIndex = make(map[string]int, len(header))
+	for i, name := range header {
+		imp.colIndex[strings.TrimPrefix(strings.TrimSpace(name), "\ufeff")] = i
+	}
+	for _, column := range imp.ingester.mapping.columns() {
+		if _, ok := imp.column(column); !ok {
+			return fmt.Errorf("required column %s not found in CSV", column)
+		}
+	}
+
+	for {
+		record, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			imp.processed++
+			imp.rejected = append(imp.rejected, usageCSVRejection{line: parseErr.Line, record: record, err: err})
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV: %w", err)
+		}
+
+		imp.processed++
+		line, _ := csvReader.FieldPos(0)
+		row, err := imp.parse(ctx, record)
+		if err != nil {
+			var rowErr *rowError
+			if !errors.As(err, &rowErr) {
+				return err
+			}
+			imp.rejected = append(imp.rejected, usageCSVRejection{line: line, record: record, err: err})
+			continue
+		}
+		row.line = line
+		imp.rows = append(imp.rows, row)
+	}
+
+	imp.majorityUnits()
+	for _, row := range imp.rows {
+		if err := imp.add(row); err != nil {
+			imp.rejected = append(imp.rejected, usageCSVRejection{line: row.line, record: row.record, err: err})
+		}
+	}
+	imp.rows = nil
+	sort.SliceStable(imp.rejected, func(i, j int) bool { return imp.rejected[i].line < imp.rejected[j].line })
+	return nil
+}
+
+// usageCSVRow is a parsed row, not yet checked against its metric's unit
+type usageCSVRow struct {
+	line   int
+	record []string
+	nodeID uuid.UUID
+	date   time.Time
+	metric string
+	unit   string
+	value  decimal.Decimal
+	labels map[string]string
+}
+
+// column returns the index of a column, matching its name exactly or else
+// ignoring case
+func (imp *usageCSVImport) column(name string) (int, bool) {
+	if idx, ok := imp.colIndex[name]; ok {
+		return idx, true
+	}
+	for header, idx := range imp.colIndex {
+		if strings.EqualFold(header, name) {
+			return idx, true
+		}
+	}
+	return 0, false
+}
+
+// parse parses a row and finds its node. Rows that cannot be imported return
+// a rowError.
+func (imp *usageCSVImport) parse(ctx context.Context, record []string) (usageCSVRow, error) {
+	mapping := &imp.ingester.mapping
+	value := func(column string) string {
+		if idx, ok := imp.column(column); ok && column != "" && idx < len(record) {
+			return strings.TrimSpace(record[idx])
+		}
+		return ""
+	}
+	field := func(f UsageCSVField) string {
+		if v := value(f.Column); v != "" {
+			return v
+		}
+		return f.Value
+	}
+
+	date, err := parseUsageDate(value(mapping.Date.Column), mapping.Date.Format)
+	if err != nil {
+		return usageCSVRow{}, &rowError{fmt.Errorf("invalid date: %w", err)}
+	}
+
+	metric := field(mapping.Metric)
+	if metric == "" {
+		return usageCSVRow{}, &rowError{errors.New("no metric")}
+	}
+
+	amount, err := decimal.NewFromString(value(mapping.Value))
+	if err != nil {
+		return usageCSVRow{}, &rowError{fmt.Errorf("invalid value %q", value(mapping.Value))}
+	}
+	if amount.IsNegative() {
+		return usageCSVRow{}, &rowError{errors.New("value cannot be negative")}
+	}
+
+	nodeID, err := imp.node(ctx, value(mapping.Node.NameColumn), value(mapping.Node.IDColumn))
+	if err != nil {
+		return usageCSVRow{}, err
+	}
+
+	var labels map[string]string
+	for name, column := range mapping.Labels {
+		if v := value(column); v != "" {
+			if labels == nil {
+				labels = make(map[string]string, len(mapping.Labels))
+			}
+			labels[name] = v
+		}
+	}
+
+	return usageCSVRow{
+		record: record,
+		nodeID: nodeID,
+		date:   date,
+		metric: metric,
+		unit:   field(mapping.Unit),
+		value:  amount,
+		labels: labels,
+	}, nil
+}
+
+// majorityUnits sets the unit of each metric the mapping gives none for to
+// the one most of its rows are in, ignoring case; ties go to the unit that
+// appears first. A unit is taken as written in its first row.
+func (imp *usageCSVImport) majorityUnits() {
+	type unitCount struct {
+		unit  string
+		count int
+	}
+	counts := make(map[string][]*unitCount)
+	for _, row := range imp.rows {
+		key := strings.ToLower(row.metric)
+		if _, ok := imp.ingester.mapping.Units[key]; ok || row.unit == "" {
+			continue
+		}
+		var found *unitCount
+		for _, c := range counts[key] {
+			if strings.EqualFold(c.unit, row.unit) {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			found = &unitCount{unit: row.unit}
+			counts[key] = append(counts[key], found)
+		}
+		found.count++
+	}
+
+	for key, units := range counts {
+		majority := units[0]
+		for _, c := range units[1:] {
+			if c.count > majority.count {
+				majority = c
+			}
+		}
+		imp.units[key] = majority.unit
+	}
+}
+
+// add checks a parsed row's unit against its metric's and adds it to its series
+func (imp *usageCSVImport) add(row usageCSVRow) error {
+	unit, err := imp.unit(row.metric, row.unit)
+	if err != nil {
+		return err
+	}
+
+	key := usageSeriesKey{nodeID: row.nodeID, period: row.date, metric: row.metric, labels: labelSetKey(row.labels)}
+	imp.daily.add(key, MetricMapping{InternalName: row.metric, Unit: unit, Aggregation: imp.ingester.mapping.Aggregation}, row.labels, row.value)
+	return nil
+}
+
+// unit checks a row's unit against the metric's, returning the metric's unit.
+// A metric's unit is the one the mapping gives, or else the one most of its
+// rows are in; rows without a unit take it.
+func (imp *usageCSVImport) unit(metric, unit string) (string, error) {
+	key := strings.ToLower(metric)
+	expected, ok := imp.ingester.mapping.Units[key]
+	if !ok {
+		expected, ok = imp.units[key]
+	}
+	if !ok {
+		return "", fmt.Errorf("no unit for metric %s", metric)
+	}
+	if unit != "" && !strings.EqualFold(unit, expected) {
+		return "", fmt.Errorf("unit %s does not match %s, the unit of metric %s", unit, expected, metric)
+	}
+	return expected, nil
+}
+
+// node returns the ID of a row's node, named by name or by ID. A node that
+// does not exist, and is not created, rejects the row.
+func (imp *usageCSVImport) node(ctx context.Context, name, id string) (uuid.UUID, error) {
+	if imp.ingester.mapping.Node.IDColumn != "" {
+		nodeID, err := uuid.Parse(id)
+		if err != nil {
+			return uuid.Nil, &rowError{fmt.Errorf("invalid node ID %q", id)}
+		}
+		exists, ok := imp.nodeIDs[nodeID]
+		if !ok {
+			_, err := imp.ingester.store.Nodes.GetByID(ctx, nodeID)
+			exists = err == nil
+			imp.nodeIDs[nodeID] = exists
+		}
+		if !exists {
+			return uuid.Nil, &rowError{fmt.Errorf("node %s not found", nodeID)}
+		}
+		return nodeID, nil
+	}
+
+	if name == "" {
+		return uuid.Nil, &rowError{errors.New("no node")}
+	}
+	node, err := imp.resolver.find(ctx, name, imp.ingester.mapping.Node.Type, nil, true)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if node == nil {
+		return uuid.Nil, &rowError{fmt.Errorf("node %s not found", name)}
+	}
+	return node.ID, nil
+}
+
+// usage returns the aggregated usage records
+func (imp *usageCSVImport) usage() []models.NodeUsageByDimension {
+	var usage []models.NodeUsageByDimension
+	for _, series := range imp.daily.sorted() {
+		usage = append(usage, models.NodeUsageByDimension{
+			NodeID:      series.key.nodeID,
+			UsageDate:   series.key.period,
+			Metric:      series.key.metric,
+			Value:       series.value(),
+			Unit:        series.mapping.Unit,
+			Labels:      series.labels,
+			Source:      imp.ingester.mapping.Source,
+			Aggregation: string(series.mapping.Aggregation),
+		})
+	}
+	return usage
+}
+
+// parseUsageDate parses a date in a Go time layout, rfc3339 or unix, returning
+// its UTC date
+func parseUsageDate(value, format string) (time.Time, error) {
+	var t time.Time
+	var err error
+	switch strings.ToLower(format) {
+	case "unix":
+		var seconds int64
+		if seconds, err = strconv.ParseInt(value, 10, 64); err == nil {
+			t = time.Unix(seconds, 0)
+		}
+	case "rfc3339":
+		t, err = time.Parse(time.RFC3339, value)
+	default:
+		t, err = time.Parse(format, value)
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+}
+
+// writeRejectedRows writes rejected rows to a CSV file, with the line and error
+// of each before the row's columns
+func writeRejectedRows(path string, header []string, rejected []usageCSVRejection) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create rejected rows file: %w", err)
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	if err := writer.Write(append([]string{"line", "error"}, header...)); err != nil {
+		return fmt.Errorf("failed to write rejected rows: %w", err)
+	}
+	for _, rejection := range rejected {
+		row := append([]string{strconv.Itoa(rejection.line), rejection.err.Error()}, rejection.record...)
+		if err := writer.Write(row); err != nil {
+			return fmt.Errorf("failed to write rejected rows: %w", err)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return fmt.Errorf("failed to write rejected rows: %w", err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,247 @@
+package ingestion
+
+import (
+	"context"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// usageCSVRecords returns the usage an import aggregated, keyed by node name
+// or ID, date and metric
+func usageCSVRecords(imp *usageCSVImport, names map[uuid.UUID]string) map[string]string {
+	got := make(map[string]string)
+	for _, record := range imp.usage() {
+		name, ok := names[record.NodeID]
+		if !ok {
+			name = record.NodeID.String()
+		}
+		got[name+" "+record.UsageDate.Format("2006-01-02")+" "+record.Metric] = record.Value.String() + " " + record.Unit
+	}
+	return got
+}
+
+func TestUsageCSVDefaultMapping(t *testing.T) {
+	ingester, err := NewUsageCSVIngester(nil, nil)
+	require.NoError(t, err)
+	resolver := noNodes(testResolver(false, "checkout", "search"), "inventory")
+	imp := ingester.newImport(resolver)
+
+	err = imp.read(context.Background(), strings.NewReader(`date,node,metric,value,unit
+2024-01-15,checkout,requests,10,count
+2024-01-15,checkout,requests,5,Count
+2024-01-16,search,requests,3,
+2024-01-15,checkout,storage_gb,1.5,
+2024-01-15,checkout,requests,1,bytes
+15/01/2024,checkout,requests,1,count
+2024-01-15,inventory,storage_gb,1,gb
+2024-01-15,inventory,requests,1,count
+2024-01-15,checkout,requests,-1,count
+2024-01-15,checkout,requests,abc,count
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 10, imp.processed)
+
+	names := map[uuid.UUID]string{resolver.cache["checkout"].ID: "checkout", resolver.cache["search"].ID: "search"}
+	assert.Equal(t, map[string]string{
+		"checkout 2024-01-15 requests": "15 count",
+		// A row without a unit takes its metric's
+		"search 2024-01-16 requests": "3 count",
+	}, usageCSVRecords(imp, names))
+	for _, record := range imp.usage() {
+		assert.Equal(t, "usage_csv", record.Source)
+		assert.Nil(t, record.Labels)
+	}
+
+	var rejected []string
+	for _, rejection := range imp.rejected {
+		rejected = append(rejected, rejection.err.Error())
+		assert.Len(t, rejection.record, 5)
+	}
+	assert.Equal(t, []string{
+		"no unit for metric storage_gb",
+		"unit bytes does not match count, the unit of metric requests",
+		`invalid date: parsing time "15/01/2024" as "2006-01-02": cannot parse "15/01/2024" as "2006"`,
+		"node inventory not found",
+		"node inventory not found",
+		"value cannot be negative",
+		`invalid value "abc"`,
+	}, rejected)
+	assert.Equal(t, 5, imp.rejected[0].line)
+	assert.Equal(t, 11, imp.rejected[6].line)
+	assert.Empty(t, imp.units["storage_gb"], "rejected rows do not set their metric's unit")
+}
+
+func TestUsageCSVMajorityUnit(t *testing.T) {
+	ingester, err := NewUsageCSVIngester(nil, nil)
+	require.NoError(t, err)
+	resolver := testResolver(false, "checkout")
+	imp := ingester.newImport(resolver)
+
+	// The first row's unit is outnumbered, and a tie goes to the first unit
+	err = imp.read(context.Background(), strings.NewReader(`date,node,metric,value,unit
+2024-01-15,checkout,storage,1,bytes
+2024-01-15,checkout,storage,2,GB
+2024-01-15,checkout,storage,3,gb
+2024-01-15,checkout,storage,4,
+2024-01-15,checkout,requests,1,count
+2024-01-15,checkout,requests,2,calls
+`))
+	require.NoError(t, err)
+
+	names := map[uuid.UUID]string{resolver.cache["checkout"].ID: "checkout"}
+	assert.Equal(t, map[string]string{
+		"checkout 2024-01-15 storage":  "9 GB",
+		"checkout 2024-01-15 requests": "1 count",
+	}, usageCSVRecords(imp, names))
+
+	require.Len(t, imp.rejected, 2)
+	assert.Equal(t, 2, imp.rejected[0].line)
+	assert.Equal(t, "unit bytes does not match GB, the unit of metric storage", imp.rejected[0].err.Error())
+	assert.Equal(t, 7, imp.rejected[1].line)
+	assert.Equal(t, "unit calls does not match count, the unit of metric requests", imp.rejected[1].err.Error())
+
+	// Units the mapping gives are kept however many rows disagree
+	mapping := DefaultUsageCSVMapping()
+	mapping.Units = map[string]string{"storage": "bytes"}
+	ingester, err = NewUsageCSVIngester(nil, &UsageCSVConfig{Mapping: &mapping})
+	require.NoError(t, err)
+	imp = ingester.newImport(resolver)
+	err = imp.read(context.Background(), strings.NewReader(`date,node,metric,value,unit
+2024-01-15,checkout,storage,1,bytes
+2024-01-15,checkout,storage,2,GB
+2024-01-15,checkout,storage,3,GB
+`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"checkout 2024-01-15 storage": "1 bytes"}, usageCSVRecords(imp, names))
+	assert.Len(t, imp.rejected, 2)
+}
+
+func TestUsageCSVMappingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`
+date:
+  column: Day
+  format: "02/01/2006"
+node:
+  id_column: NodeID
+metric:
+  value: API_Requests
+value: Requests
+labels:
+  customer_id: Customer
+units:
+  API_Requests: count
+aggregation: max
+source: billing_system
+`), 0o644))
+
+	mapping, err := LoadUsageCSVMapping(path)
+	require.NoError(t, err)
+	ingester, err := NewUsageCSVIngester(nil, &UsageCSVConfig{Mapping: mapping})
+	require.NoError(t, err)
+
+	nodeID, missing := uuid.New(), uuid.New()
+	imp := ingester.newImport(testResolver(false))
+	imp.nodeIDs[nodeID] = true
+	imp.nodeIDs[missing] = false
+
+	err = imp.read(context.Background(), strings.NewReader(strings.Join([]string{
+		"Day,NodeID,Requests,Customer",
+		"15/01/2024," + nodeID.String() + ",10,c1",
+		"15/01/2024," + nodeID.String() + ",30,c1",
+		"15/01/2024," + nodeID.String() + ",5,",
+		"15/01/2024," + missing.String() + ",1,c1",
+		"15/01/2024,checkout,1,c1",
+	}, "\n")))
+	require.NoError(t, err)
+
+	usage := imp.usage()
+	require.Len(t, usage, 2)
+	values := make(map[string]string)
+	for _, record := range usage {
+		assert.Equal(t, nodeID, record.NodeID)
+		assert.Equal(t, "API_Requests", record.Metric)
+		assert.Equal(t, "count", record.Unit)
+		assert.Equal(t, "billing_system", record.Source)
+		values[record.Labels["customer_id"]] = record.Value.String()
+	}
+	// Rows with the same labels are aggregated by their maximum
+	assert.Equal(t, map[string]string{"c1": "30", "": "5"}, values)
+
+	require.Len(t, imp.rejected, 2)
+	assert.Equal(t, "node "+missing.String()+" not found", imp.rejected[0].err.Error())
+	assert.Equal(t, `invalid node ID "checkout"`, imp.rejected[1].err.Error())
+
+	err = ingester.newImport(testResolver(false)).read(context.Background(), strings.NewReader("Day,Node,Requests\n"))
+	assert.ErrorContains(t, err, "required column NodeID not found")
+
+	// The documented example is valid
+	_, err = LoadUsageCSVMapping(filepath.Join("..", "..", "docs", "usage-csv-mapping.yaml"))
+	require.NoError(t, err)
+}
+
+func TestUsageCSVMappingValidates(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(m *UsageCSVMapping)
+		err    string
+	}{
+		{name: "no date", modify: func(m *UsageCSVMapping) { m.Date.Column = "" }, err: "date column is required"},
+		{name: "two node columns", modify: func(m *UsageCSVMapping) { m.Node.IDColumn = "node_id" }, err: "exactly one of the node name and ID columns"},
+		{name: "no metric", modify: func(m *UsageCSVMapping) { m.Metric = UsageCSVField{} }, err: "metric column or value is required"},
+		{name: "no value", modify: func(m *UsageCSVMapping) { m.Value = "" }, err: "value column is required"},
+		{name: "no unit", modify: func(m *UsageCSVMapping) { m.Unit = UsageCSVField{} }, err: "unit column, value or units are required"},
+		{name: "bad aggregation", modify: func(m *UsageCSVMapping) { m.Aggregation = "median" }, err: `unknown aggregation "median"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := DefaultUsageCSVMapping()
+			tt.modify(&mapping)
+			_, err := NewUsageCSVIngester(nil, &UsageCSVConfig{Mapping: &mapping})
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+
+	// Units alone are enough, and give rows without a unit theirs
+	mapping := DefaultUsageCSVMapping()
+	mapping.Unit = UsageCSVField{}
+	mapping.Units = map[string]string{"requests": "count"}
+	_, err := NewUsageCSVIngester(nil, &UsageCSVConfig{Mapping: &mapping})
+	assert.NoError(t, err)
+}
+
+func TestUsageCSVRejectedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejected.csv")
+	ingester, err := NewUsageCSVIngester(nil, &UsageCSVConfig{RejectedPath: path})
+	require.NoError(t, err)
+
+	result, err := ingester.IngestReader(context.Background(), strings.NewReader(`date,node,metric,value,unit
+yesterday,checkout,requests,1,count
+2024-01-15,checkout,,1,count
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.RecordsProcessed)
+	assert.Equal(t, 2, result.RecordsSkipped)
+	assert.Equal(t, path, result.RejectedFile)
+	require.Len(t, result.Errors, 2)
+	assert.Equal(t, "line 3: no metric", result.Errors[1])
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"line", "error", "date", "node", "metric", "value", "unit"},
+		{"2", `invalid date: parsing time "yesterday" as "2006-01-02": cannot parse "yesterday" as "2006"`, "yesterday", "checkout", "requests", "1", "count"},
+		{"3", "no metric", "2024-01-15", "checkout", "", "1", "count"},
+	}, rows)
+}
@@ -0,0 +1,265 @@
+package models
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/formula"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
+	"github.com/shopspring/decimal"
+)
+
+// ParamType is the JSON type an allocation strategy parameter must have
+type ParamType = strategy.ParamType
+
+const (
+	ParamTypeString  = strategy.ParamTypeString
+	ParamTypeNumber  = strategy.ParamTypeNumber
+	ParamTypeInteger = strategy.ParamTypeInteger
+	ParamTypeBoolean = strategy.ParamTypeBoolean
+	ParamTypeObject  = strategy.ParamTypeObject
+	ParamTypeArray   = strategy.ParamTypeArray
+)
+
+// ParamSpec describes one parameter of an allocation strategy
+type ParamSpec = strategy.ParamSpec
+
+// StrategySpec describes an allocation strategy and the parameters it accepts
+type StrategySpec = strategy.Spec
+
+// RegisterStrategySpec adds a strategy to the set IsValidStrategy accepts. Names
+// must be unique; built-in strategies are registered when the package loads.
+func RegisterStrategySpec(spec StrategySpec) error {
+	return strategy.RegisterSpec(spec)
+}
+
+// LookupStrategySpec returns the spec of a registered strategy
+func LookupStrategySpec(name string) (StrategySpec, bool) {
+	return strategy.LookupSpec(name)
+}
+
+// StrategySpecs returns every registered strategy, ordered by name
+func StrategySpecs() []StrategySpec {
+	return strategy.Specs()
+}
+
+func init() {
+	for _, spec := range builtinStrategySpecs() {
+		if err := RegisterStrategySpec(spec); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// builtinStrategySpecs describes the strategies the allocation engine ships with
+func builtinStrategySpecs() []StrategySpec {
+	zero, one, hundred, hoursPerDay := 0.0, 1.0, 100.0, 24.0
+	percent := func(description string, required bool) ParamSpec {
+		return ParamSpec{Type: ParamTypeNumber, Description: description, Required: required, Minimum: &zero, Maximum: &hundred}
+	}
+	metric := func(required bool) ParamSpec {
+		return ParamSpec{Type: ParamTypeString, Description: "Usage metric the split is proportional to", Required: required}
+	}
+
+	return []StrategySpec{
+		{
+			Name:        StrategyEqual,
+			Description: "Split equally among the parent's children",
+			Parameters:  map[string]ParamSpec{},
+		},
+		{
+			Name:        StrategyProportionalOn,
+			Description: "Split by each child's usage of a metric on the day",
+			Parameters:  map[string]ParamSpec{"metric": metric(true)},
+		},
+		{
+			Name:        StrategyFixedPercent,
+			Description: "Give each child a fixed share of the parent's cost",
+			Parameters: map[string]ParamSpec{
+				"percent": percent("Share of the parent's cost, as a percentage or a fraction of 1", true),
+			},
+		},
+		{
+			Name:        StrategyCappedProp,
+			Description: "Split by usage, capping each child's share and redistributing the excess",
+			Parameters: map[string]ParamSpec{
+				"metric": metric(true),
+				"cap":    percent("Largest share any child may receive", false),
+			},
+		},
+		{
+			Name:        StrategyResidualToMax,
+			Description: "Split by usage, with the parent using the most absorbing what the child's other parents leave",
+			Parameters:  map[string]ParamSpec{"metric": metric(true)},
+		},
+		{
+			Name:        StrategyWeightedAverage,
+			Description: "Split by each child's average usage over a look-back window",
+			Parameters: map[string]ParamSpec{
+				"metric":      metric(true),
+				"window_days": {Type: ParamTypeInteger, Description: "Days of usage averaged, ending on the allocation date (default 7)", Minimum: &one},
+			},
+		},
+		{
+			Name:        StrategyHybridFixedProp,
+			Description: "Split a fixed portion equally and the rest by usage",
+			Parameters: map[string]ParamSpec{
+				"fixed_percent": percent("Portion of the parent's cost split equally", true),
+				"metric":        metric(false),
+			},
+		},
+		{
+			Name:        StrategyMinFloorProportional,
+			Description: "Guarantee every child a minimum share and split the rest by usage",
+			Parameters: map[string]ParamSpec{
+				"min_floor_percent": percent("Minimum share of every child", true),
+				"metric":            metric(false),
+			},
+		},
+		{
+			Name:        StrategySegmentFilteredProp,
+			Description: "Split by usage of a metric, counting only usage whose labels match a filter",
+			Parameters: map[string]ParamSpec{
+				"metric": metric(true),
+				"segment_filter": {
+					Type:        ParamTypeObject,
+					Description: "Label filter applied to the usage records",
+					Properties: map[string]ParamSpec{
+						"label":    {Type: ParamTypeString, Description: "Label key to filter on", Required: true},
+						"operator": {Type: ParamTypeString, Description: "Filter operator (default in)", Enum: []string{"eq", "neq", "in", "not_in", "exists", "not_exists"}},
+						"values":   {Type: ParamTypeArray, Description: "Label values to match"},
+						"value":    {Type: ParamTypeString, Description: "Single label value to match"},
+					},
+				},
+			},
+		},
+		{
+			Name:        StrategyFormula,
+			Description: "Split by a per-child weight computed from usage, labels and constants, with an optional equal portion and minimum share",
+			Parameters: map[string]ParamSpec{
+				"expression":    {Type: ParamTypeString, Description: "Formula giving each child's weight, e.g. requests * 0.7 + cpu_hours * 0.3", Required: true},
+				"constants":     {Type: ParamTypeObject, Description: "Named numbers the expression may refer to"},
+				"fixed_percent": percent("Portion of the parent's cost split equally before the formula applies", false),
+				"min_percent":   percent("Minimum share of every child", false),
+			},
+			Validate: func(params map[string]interface{}) error {
+				_, err := ParseFormulaParameters(params)
+				return err
+			},
+		},
+		{
+			Name:        StrategyTieredRate,
+			Description: "Charge each child for its usage of a metric against a tier table, sending any under- or over-recovery of the parent's cost to a sink",
+			Parameters: map[string]ParamSpec{
+				"metric": {Type: ParamTypeString, Description: "Usage metric the tiers price", Required: true},
+				"tiers": {
+					Type:        ParamTypeArray,
+					Description: "Tiers in ascending order, each {\"up_to\": usage, \"rate\": price per unit}; the last has no up_to",
+					Required:    true,
+				},
+				"sink_node": {Type: ParamTypeString, Description: "Child that absorbs under-recovery and is booked over-recovery; the unallocated node when omitted"},
+			},
+			Validate: func(params map[string]interface{}) error {
+				if _, err := ParseRateTiers(params); err != nil {
+					return err
+				}
+				if sink, ok := params["sink_node"].(string); ok {
+					if _, err := uuid.Parse(sink); err != nil {
+						return fmt.Errorf("'sink_node' must be a node ID, got %q", sink)
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Name:        StrategyPeakCoincident,
+			Description: "Split by each child's hourly usage of a metric during the parent's busiest hours of the day",
+			Parameters: map[string]ParamSpec{
+				"metric": {Type: ParamTypeString, Description: "Hourly usage metric the peaks are found and the split is made on", Required: true},
+				"peak_hours": {
+					Type:        ParamTypeInteger,
+					Description: "How many of the parent's busiest hours are compared (default 1)",
+					Minimum:     &one,
+					Maximum:     &hoursPerDay,
+				},
+			},
+		},
+	}
+}
+
+// RateTier is one band of a tiered rate table. Usage up to UpTo, and above the
+// previous tier's bound, is charged at Rate per unit; a nil UpTo is unbounded.
+type RateTier struct {
+	UpTo *decimal.Decimal
+	Rate decimal.Decimal
+}
+
+// ParseRateTiers parses the tier table of a tiered_rate strategy. Bounds must
+// increase, rates must not be negative, and only the last tier is unbounded.
+func ParseRateTiers(params map[string]interface{}) ([]RateTier, error) {
+	raw, ok := params["tiers"].([]interface{})
+	if !ok || len(raw) == 0 {
+		return nil, fmt.Errorf("'tiers' must be a non-empty array")
+	}
+
+	tiers := make([]RateTier, 0, len(raw))
+	for i, entry := range raw {
+		tier, ok := entry.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'tiers[%d]' must be an object, got %T", i, entry)
+		}
+
+		rate, ok := strategy.Number(tier["rate"])
+		if !ok || rate < 0 {
+			return nil, fmt.Errorf("'tiers[%d].rate' must be a number of at least 0, got %v", i, tier["rate"])
+		}
+		parsed := RateTier{Rate: decimal.NewFromFloat(rate)}
+
+		if upTo, present := tier["up_to"]; present && upTo != nil {
+			bound, ok := strategy.Number(upTo)
+			if !ok {
+				return nil, fmt.Errorf("'tiers[%d].up_to' must be a number, got %v", i, upTo)
+			}
+			b := decimal.NewFromFloat(bound)
+			if i > 0 && !b.GreaterThan(*tiers[i-1].UpTo) {
+				return nil, fmt.Errorf("'tiers[%d].up_to' must be greater than the previous tier's", i)
+			}
+			if !b.IsPositive() {
+				return nil, fmt.Errorf("'tiers[%d].up_to' must be positive", i)
+			}
+			parsed.UpTo = &b
+		} else if i != len(raw)-1 {
+			return nil, fmt.Errorf("only the last tier may omit 'up_to'")
+		}
+
+		tiers = append(tiers, parsed)
+	}
+
+	if tiers[len(tiers)-1].UpTo != nil {
+		return nil, fmt.Errorf("the last tier must omit 'up_to' so every unit of usage is priced")
+	}
+	return tiers, nil
+}
+
+// ParseFormulaParameters parses the expression of a formula strategy together
+// with its named constants
+func ParseFormulaParameters(params map[string]interface{}) (*formula.Expression, error) {
+	source, ok := params["expression"].(string)
+	if !ok {
+		return nil, fmt.Errorf("'expression' is required")
+	}
+
+	var constants map[string]decimal.Decimal
+	if raw, ok := params["constants"].(map[string]interface{}); ok {
+		constants = make(map[string]decimal.Decimal, len(raw))
+		for name, value := range raw {
+			number, ok := strategy.Number(value)
+			if !ok {
+				return nil, fmt.Errorf("'constants.%s' must be a number, got %v", name, value)
+			}
+			constants[name] = decimal.NewFromFloat(number)
+		}
+	}
+
+	return formula.Parse(source, constants)
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrategySpec_ValidateParameters(t *testing.T) {
+	fixedPercent, ok := LookupStrategySpec(string(StrategyFixedPercent))
+	require.True(t, ok)
+
+	t.Run("accepts numbers and numeric strings", func(t *testing.T) {
+		assert.NoError(t, fixedPercent.ValidateParameters(map[string]interface{}{"percent": 25.0}))
+		assert.NoError(t, fixedPercent.ValidateParameters(map[string]interface{}{"percent": "0.25"}))
+	})
+
+	t.Run("required parameters must be present", func(t *testing.T) {
+		err := fixedPercent.ValidateParameters(nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'percent' is required")
+	})
+
+	t.Run("types and bounds are enforced", func(t *testing.T) {
+		assert.Error(t, fixedPercent.ValidateParameters(map[string]interface{}{"percent": true}))
+		assert.Error(t, fixedPercent.ValidateParameters(map[string]interface{}{"percent": 150.0}))
+		assert.Error(t, fixedPercent.ValidateParameters(map[string]interface{}{"percent": -1.0}))
+	})
+
+	t.Run("integers reject fractions", func(t *testing.T) {
+		weighted, _ := LookupStrategySpec(string(StrategyWeightedAverage))
+		assert.NoError(t, weighted.ValidateParameters(map[string]interface{}{"metric": "cpu", "window_days": 14.0}))
+		assert.Error(t, weighted.ValidateParameters(map[string]interface{}{"metric": "cpu", "window_days": 1.5}))
+		assert.Error(t, weighted.ValidateParameters(map[string]interface{}{"metric": "cpu", "window_days": 0.0}))
+	})
+
+	t.Run("nested objects are validated", func(t *testing.T) {
+		segment, _ := LookupStrategySpec(string(StrategySegmentFilteredProp))
+		assert.NoError(t, segment.ValidateParameters(map[string]interface{}{
+			"metric":         "requests",
+			"segment_filter": map[string]interface{}{"label": "customer_id", "operator": "in", "values": []interface{}{"a"}},
+		}))
+
+		err := segment.ValidateParameters(map[string]interface{}{
+			"metric":         "requests",
+			"segment_filter": map[string]interface{}{"label": "customer_id", "operator": "like"},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "segment_filter.operator")
+
+		err = segment.ValidateParameters(map[string]interface{}{
+			"metric":         "requests",
+			"segment_filter": map[string]interface{}{},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'segment_filter.label' is required")
+	})
+
+	t.Run("unknown parameters are reported separately", func(t *testing.T) {
+		params := map[string]interface{}{"percent": 10.0, "percnt": 10.0, "note": "x"}
+		assert.NoError(t, fixedPercent.ValidateParameters(params))
+		assert.Equal(t, []string{"note", "percnt"}, fixedPercent.UnknownParameters(params))
+	})
+}
+
+func TestRegisterStrategySpec(t *testing.T) {
+	t.Run("built-in strategies are registered", func(t *testing.T) {
+		specs := StrategySpecs()
+		var names []AllocationStrategy
+		for _, spec := range specs {
+			names = append(names, spec.Name)
+		}
+		assert.Contains(t, names, StrategyEqual)
+		assert.Contains(t, names, StrategySegmentFilteredProp)
+		assert.IsIncreasing(t, names)
+	})
+
+	t.Run("names must be unique", func(t *testing.T) {
+		assert.Error(t, RegisterStrategySpec(StrategySpec{Name: StrategyEqual}))
+		assert.Error(t, RegisterStrategySpec(StrategySpec{Name: " "}))
+	})
+
+	t.Run("registered strategies become valid", func(t *testing.T) {
+		assert.False(t, IsValidStrategy("test_registered_strategy"))
+		require.NoError(t, RegisterStrategySpec(StrategySpec{Name: "test_registered_strategy"}))
+		assert.True(t, IsValidStrategy("test_registered_strategy"))
+	})
+}
+
+func TestParseRateTiers(t *testing.T) {
+	tier := func(upTo interface{}, rate interface{}) map[string]interface{} {
+		entry := map[string]interface{}{"rate": rate}
+		if upTo != nil {
+			entry["up_to"] = upTo
+		}
+		return entry
+	}
+
+	tiers, err := ParseRateTiers(map[string]interface{}{"tiers": []interface{}{tier(1.0, 0.0), tier("11", "50"), tier(nil, 20.0)}})
+	require.NoError(t, err)
+	require.Len(t, tiers, 3)
+	assert.Equal(t, "11", tiers[1].UpTo.String())
+	assert.Equal(t, "50", tiers[1].Rate.String())
+	assert.Nil(t, tiers[2].UpTo)
+
+	invalid := []struct {
+		name  string
+		tiers interface{}
+		err   string
+	}{
+		{"missing", nil, "non-empty array"},
+		{"empty", []interface{}{}, "non-empty array"},
+		{"not objects", []interface{}{1.0}, "must be an object"},
+		{"negative rates", []interface{}{tier(nil, -1.0)}, "tiers[0].rate"},
+		{"bounds out of order", []interface{}{tier(10.0, 1.0), tier(5.0, 1.0), tier(nil, 1.0)}, "greater than the previous"},
+		{"unbounded tier before the last", []interface{}{tier(nil, 1.0), tier(nil, 2.0)}, "only the last tier"},
+		{"bounded last tier", []interface{}{tier(10.0, 1.0)}, "last tier must omit"},
+	}
+	for _, tt := range invalid {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseRateTiers(map[string]interface{}{"tiers": tt.tiers})
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+		})
+	}
+
+	t.Run("the spec validates the sink node", func(t *testing.T) {
+		spec, ok := LookupStrategySpec(string(StrategyTieredRate))
+		require.True(t, ok)
+		params := map[string]interface{}{"metric": "tb", "tiers": []interface{}{tier(nil, 1.0)}, "sink_node": "platform"}
+		assert.ErrorContains(t, spec.ValidateParameters(params), "must be a node ID")
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/pkg/strategy"
 	"github.com/shopspring/decimal"
 )
 
@@ -57,17 +58,74 @@ type NodeCostByDimension struct {
 	UpdatedAt time.Time              `json:"updated_at" db:"updated_at"`
 }
 
+// FXRate is the rate converting one currency into another on a date: an amount
+// in FromCurrency multiplied by Rate is the amount in ToCurrency
+type FXRate struct {
+	RateDate     time.Time       `json:"rate_date" db:"rate_date"`
+	FromCurrency string          `json:"from_currency" db:"from_currency"`
+	ToCurrency   string          `json:"to_currency" db:"to_currency"`
+	Rate         decimal.Decimal `json:"rate" db:"rate"`
+	Source       string          `json:"source,omitempty" db:"source"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// IngestionBatch records an import of a billing period's report. Costs it wrote
+// carry its ID, so a later batch for the same source and period replaces them.
+type IngestionBatch struct {
+	ID                 uuid.UUID  `json:"id" db:"id"`
+	Source             string     `json:"source" db:"source"`
+	BillingPeriodStart time.Time  `json:"billing_period_start" db:"billing_period_start"`
+	BillingPeriodEnd   time.Time  `json:"billing_period_end" db:"billing_period_end"`
+	Checksum           string     `json:"checksum" db:"checksum"`
+	FileCount          int        `json:"file_count" db:"file_count"`
+	RecordsProcessed   int        `json:"records_processed" db:"records_processed"`
+	RecordsInserted    int        `json:"records_inserted" db:"records_inserted"`
+	RecordsSkipped     int        `json:"records_skipped" db:"records_skipped"`
+	RecordsReplaced    int        `json:"records_replaced" db:"records_replaced"`
+	SupersededBy       *uuid.UUID `json:"superseded_by,omitempty" db:"superseded_by"`
+	CreatedAt          time.Time  `json:"created_at" db:"created_at"`
+}
+
 // NodeUsageByDimension represents usage metrics for a node on a specific date
 type NodeUsageByDimension struct {
-	NodeID    uuid.UUID              `json:"node_id" db:"node_id"`
-	UsageDate time.Time              `json:"usage_date" db:"usage_date"`
-	Metric    string                 `json:"metric" db:"metric"`
-	Value     decimal.Decimal        `json:"value" db:"value"`
-	Unit      string                 `json:"unit" db:"unit"`
-	Labels    map[string]string      `json:"labels,omitempty" db:"labels"`     // Optional labels for filtering (e.g. customer_id, environment)
-	Source    string                 `json:"source,omitempty" db:"source"`     // Source of the metric (e.g. "dynatrace", "prometheus", "manual")
-	CreatedAt time.Time              `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time              `json:"updated_at" db:"updated_at"`
+	NodeID      uuid.UUID              `json:"node_id" db:"node_id"`
+	UsageDate   time.Time              `json:"usage_date" db:"usage_date"`
+	Metric      string                 `json:"metric" db:"metric"`
+	Value       decimal.Decimal        `json:"value" db:"value"`
+	Unit        string                 `json:"unit" db:"unit"`
+	Labels      map[string]string      `json:"labels,omitempty" db:"labels"`     // Optional labels for filtering (e.g. customer_id, environment)
+	Source      string                 `json:"source,omitempty" db:"source"`     // Source of the metric (e.g. "dynatrace", "prometheus", "manual")
+	Aggregation string                 `json:"aggregation,omitempty" db:"aggregation"` // How values and label sets are combined: sum, avg, max or p95; empty means sum
+	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at" db:"updated_at"`
+}
+
+// NodeUsageHourly represents usage metrics for a node within one hour (UTC),
+// for strategies that allocate by load at particular times of day
+type NodeUsageHourly struct {
+	NodeID    uuid.UUID       `json:"node_id" db:"node_id"`
+	UsageHour time.Time       `json:"usage_hour" db:"usage_hour"` // Start of the hour
+	Metric    string          `json:"metric" db:"metric"`
+	Value     decimal.Decimal `json:"value" db:"value"`
+	Unit      string          `json:"unit" db:"unit"`
+	Source    string          `json:"source,omitempty" db:"source"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// NodeUsageSample represents a single usage datapoint at the resolution its
+// source reported it, before it is aggregated per day
+type NodeUsageSample struct {
+	NodeID    uuid.UUID         `json:"node_id" db:"node_id"`
+	SampledAt time.Time         `json:"sampled_at" db:"sampled_at"`
+	Metric    string            `json:"metric" db:"metric"`
+	Labels    map[string]string `json:"labels,omitempty" db:"labels"`
+	Value     decimal.Decimal   `json:"value" db:"value"`
+	Unit      string            `json:"unit" db:"unit"`
+	Source    string            `json:"source,omitempty" db:"source"`
+	CreatedAt time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at" db:"updated_at"`
 }
 
 // UsageLabelFilter represents a filter for querying usage metrics by labels
@@ -90,14 +148,18 @@ type UsageQueryOptions struct {
 
 // ComputationRun represents a single allocation computation run
 type ComputationRun struct {
-	ID          uuid.UUID  `json:"id" db:"id"`
-	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
-	WindowStart time.Time  `json:"window_start" db:"window_start"`
-	WindowEnd   time.Time  `json:"window_end" db:"window_end"`
-	GraphHash   string     `json:"graph_hash" db:"graph_hash"`
-	Status      string     `json:"status" db:"status"`
-	Notes       *string    `json:"notes,omitempty" db:"notes"`
+	ID                uuid.UUID  `json:"id" db:"id"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+	WindowStart       time.Time  `json:"window_start" db:"window_start"`
+	WindowEnd         time.Time  `json:"window_end" db:"window_end"`
+	GraphHash         string     `json:"graph_hash" db:"graph_hash"`
+	Status            string     `json:"status" db:"status"`
+	Notes             *string    `json:"notes,omitempty" db:"notes"`
+	UnallocatedNodeID *uuid.UUID `json:"unallocated_node_id,omitempty" db:"unallocated_node_id"` // Synthetic sink for cost the engine could not place
+	Dimensions        []string   `json:"dimensions" db:"dimensions"`                             // Dimensions allocated, so the run can be resumed
+	HeartbeatAt       *time.Time `json:"heartbeat_at,omitempty" db:"heartbeat_at"`               // Last sign of life from the process running it
+	Currency          string     `json:"currency" db:"currency"`                                 // Base currency every cost was converted to before allocating
 }
 
 // AllocationResultByDimension represents the allocation result for a node on a specific date and dimension
@@ -115,15 +177,104 @@ type AllocationResultByDimension struct {
 
 // ContributionResultByDimension represents how much a child contributed to a parent
 type ContributionResultByDimension struct {
-	RunID             uuid.UUID       `json:"run_id" db:"run_id"`
-	ParentID          uuid.UUID       `json:"parent_id" db:"parent_id"`
-	ChildID           uuid.UUID       `json:"child_id" db:"child_id"`
-	ContributionDate  time.Time       `json:"contribution_date" db:"contribution_date"`
-	Dimension         string          `json:"dimension" db:"dimension"`
-	ContributedAmount decimal.Decimal `json:"contributed_amount" db:"contributed_amount"`
-	Path              []uuid.UUID     `json:"path" db:"path"`
-	CreatedAt         time.Time       `json:"created_at" db:"created_at"`
-	UpdatedAt         time.Time       `json:"updated_at" db:"updated_at"`
+	RunID              uuid.UUID       `json:"run_id" db:"run_id"`
+	ParentID           uuid.UUID       `json:"parent_id" db:"parent_id"`
+	ChildID            uuid.UUID       `json:"child_id" db:"child_id"`
+	ContributionDate   time.Time       `json:"contribution_date" db:"contribution_date"`
+	Dimension          string          `json:"dimension" db:"dimension"`
+	ContributedAmount  decimal.Decimal `json:"contributed_amount" db:"contributed_amount"`
+	RoundingAdjustment decimal.Decimal `json:"rounding_adjustment" db:"rounding_adjustment"` // Rounded minus exact amount
+	Path               []uuid.UUID     `json:"path" db:"path"`
+	CreatedAt          time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt          time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// LineageResultByDimension traces part of a final cost centre's holistic cost back to
+// the node it was originally incurred on, along the full path it was allocated through
+type LineageResultByDimension struct {
+	RunID        uuid.UUID       `json:"run_id" db:"run_id"`
+	OriginID     uuid.UUID       `json:"origin_id" db:"origin_id"`
+	CostCentreID uuid.UUID       `json:"cost_centre_id" db:"cost_centre_id"`
+	LineageDate  time.Time       `json:"lineage_date" db:"lineage_date"`
+	Dimension    string          `json:"dimension" db:"dimension"`
+	Amount       decimal.Decimal `json:"amount" db:"amount"`
+	Path         []uuid.UUID     `json:"path" db:"path"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+}
+
+// AllocationInvariantReport accounts for where a day's direct cost ended up for one
+// dimension, so any cost created or lost by the allocation is visible exactly
+type AllocationInvariantReport struct {
+	RunID      uuid.UUID `json:"run_id" db:"run_id"`
+	ReportDate time.Time `json:"report_date" db:"report_date"`
+	Dimension  string    `json:"dimension" db:"dimension"`
+	// DirectCost is the total direct cost recorded for the day
+	DirectCost decimal.Decimal `json:"direct_cost" db:"direct_cost"`
+	// FinalCostCentreCost is the cost retained by final cost centres
+	FinalCostCentreCost decimal.Decimal `json:"final_cost_centre_cost" db:"final_cost_centre_cost"`
+	// UnallocatedCost is the cost held back on parents whose child shares summed to less than 1
+	UnallocatedCost decimal.Decimal `json:"unallocated_cost" db:"unallocated_cost"`
+	// RoundingResidue is the cost below one minor unit left on parents by rounding their
+	// contributions, summed over parents; credits leave a negative residue
+	RoundingResidue decimal.Decimal `json:"rounding_residue" db:"rounding_residue"`
+	// StrandedCost is the cost retained by childless nodes that are not final cost centres
+	StrandedCost decimal.Decimal `json:"stranded_cost" db:"stranded_cost"`
+	// CreatedCost is the cost allocated out of parents beyond their holistic cost
+	CreatedCost decimal.Decimal `json:"created_cost" db:"created_cost"`
+	// LostCost is the direct cost not accounted for by any of the above
+	LostCost  decimal.Decimal `json:"lost_cost" db:"lost_cost"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// UnallocatedResultByDimension records cost a node could not pass on to its children,
+// which the engine routes to the run's unallocated node instead
+type UnallocatedResultByDimension struct {
+	RunID          uuid.UUID         `json:"run_id" db:"run_id"`
+	NodeID         uuid.UUID         `json:"node_id" db:"node_id"`
+	AllocationDate time.Time         `json:"allocation_date" db:"allocation_date"`
+	Dimension      string            `json:"dimension" db:"dimension"`
+	Reason         UnallocatedReason `json:"reason" db:"reason"`
+	Amount         decimal.Decimal   `json:"amount" db:"amount"`
+	CreatedAt      time.Time         `json:"created_at" db:"created_at"`
+}
+
+// RecoveryResultByDimension compares what a rate-based strategy charged a parent's
+// children with the parent's cost for a day. Under-recovery, cost the charges did
+// not cover, is allocated to the sink. Over-recovery, what the charges exceeded
+// the cost by, is booked against the sink while the children's charges are scaled
+// back to the cost, so allocation never creates cost.
+type RecoveryResultByDimension struct {
+	RunID          uuid.UUID       `json:"run_id" db:"run_id"`
+	ParentID       uuid.UUID       `json:"parent_id" db:"parent_id"`
+	AllocationDate time.Time       `json:"allocation_date" db:"allocation_date"`
+	Dimension      string          `json:"dimension" db:"dimension"`
+	Strategy       string          `json:"strategy" db:"strategy"`
+	SinkNodeID     *uuid.UUID      `json:"sink_node_id,omitempty" db:"sink_node_id"`
+	CostAmount     decimal.Decimal `json:"cost_amount" db:"cost_amount"`
+	ChargedAmount  decimal.Decimal `json:"charged_amount" db:"charged_amount"`
+	UnderRecovery  decimal.Decimal `json:"under_recovery" db:"under_recovery"`
+	OverRecovery   decimal.Decimal `json:"over_recovery" db:"over_recovery"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+}
+
+// AllocationDayFingerprint records the inputs a run's day was computed from
+type AllocationDayFingerprint struct {
+	RunID            uuid.UUID  `json:"run_id" db:"run_id"`
+	AllocationDate   time.Time  `json:"allocation_date" db:"allocation_date"`
+	Fingerprint      string     `json:"fingerprint" db:"fingerprint"`
+	GraphHash        string     `json:"graph_hash" db:"graph_hash"`
+	CostChecksum     string     `json:"cost_checksum" db:"cost_checksum"`
+	UsageChecksum    string     `json:"usage_checksum" db:"usage_checksum"`
+	StrategyChecksum string     `json:"strategy_checksum" db:"strategy_checksum"`
+	CopiedFromRunID  *uuid.UUID `json:"copied_from_run_id,omitempty" db:"copied_from_run_id"`
+	CreatedAt        time.Time  `json:"created_at" db:"created_at"`
+}
+
+// ComputationRunCheckpoint records that every result of a run's day has been saved
+type ComputationRunCheckpoint struct {
+	RunID          uuid.UUID `json:"run_id" db:"run_id"`
+	CheckpointDate time.Time `json:"checkpoint_date" db:"checkpoint_date"`
+	CompletedAt    time.Time `json:"completed_at" db:"completed_at"`
 }
 
 // ComputationStatus represents the status of a computation run
@@ -134,6 +285,7 @@ const (
 	ComputationStatusRunning   ComputationStatus = "running"
 	ComputationStatusCompleted ComputationStatus = "completed"
 	ComputationStatusFailed    ComputationStatus = "failed"
+	ComputationStatusCancelled ComputationStatus = "cancelled"
 )
 
 // NodeType represents different types of cost nodes
@@ -146,10 +298,31 @@ const (
 	NodeTypePlatform    NodeType = "platform"
 	NodeTypeInfra       NodeType = "infrastructure"
 	NodeTypeShared      NodeType = "shared"
+	NodeTypeUnallocated NodeType = "unallocated" // Synthetic per-run sink for cost that could not be placed
+)
+
+// UnallocatedReason explains why cost was routed to the unallocated node
+type UnallocatedReason string
+
+const (
+	// UnallocatedReasonNoChildren is cost on a node with no children that is not a final cost centre
+	UnallocatedReasonNoChildren UnallocatedReason = "no_children"
+	// UnallocatedReasonStrategyError is cost left behind because a child's strategy failed
+	UnallocatedReasonStrategyError UnallocatedReason = "strategy_error"
+	// UnallocatedReasonZeroUsageFallbackDisabled is cost a usage-based strategy could not
+	// split because no child had usage and the equal fallback is disabled
+	UnallocatedReasonZeroUsageFallbackDisabled UnallocatedReason = "zero_usage_fallback_disabled"
+	// UnallocatedReasonShareRemainder is cost left over when the children's shares sum to less than 1
+	UnallocatedReasonShareRemainder UnallocatedReason = "share_remainder"
+	// UnallocatedReasonRoundingResidue is cost left over by rounding the contributions
+	UnallocatedReasonRoundingResidue UnallocatedReason = "rounding_residue"
+	// UnallocatedReasonUnderRecovery is cost a rate-based strategy's charges did not
+	// recover when it has no sink node of its own
+	UnallocatedReasonUnderRecovery UnallocatedReason = "under_recovery"
 )
 
 // AllocationStrategy represents different cost allocation strategies
-type AllocationStrategy string
+type AllocationStrategy = strategy.Name
 
 const (
 	StrategyProportionalOn         AllocationStrategy = "proportional_on"
@@ -161,18 +334,15 @@ const (
 	StrategyHybridFixedProp        AllocationStrategy = "hybrid_fixed_proportional"
 	StrategyMinFloorProportional   AllocationStrategy = "min_floor_proportional"
 	StrategySegmentFilteredProp    AllocationStrategy = "segment_filtered_proportional"
+	StrategyFormula                AllocationStrategy = "formula"
+	StrategyTieredRate             AllocationStrategy = "tiered_rate"
+	StrategyPeakCoincident         AllocationStrategy = "peak_coincident"
 )
 
-// IsValidStrategy checks if a strategy string is a valid allocation strategy
+// IsValidStrategy checks if a strategy string names a registered allocation strategy
 func IsValidStrategy(s string) bool {
-	switch AllocationStrategy(s) {
-	case StrategyProportionalOn, StrategyEqual, StrategyFixedPercent,
-		StrategyCappedProp, StrategyResidualToMax, StrategyWeightedAverage,
-		StrategyHybridFixedProp, StrategyMinFloorProportional, StrategySegmentFilteredProp:
-		return true
-	default:
-		return false
-	}
+	_, ok := LookupStrategySpec(s)
+	return ok
 }
 
 // Dimension represents common cost dimensions
@@ -214,22 +384,29 @@ type AllocationInput struct {
 }
 
 // AllocationOutput represents the result of allocation computation
+// Results are persisted per day as they are computed and are not returned;
+// read them back through the run repository.
 type AllocationOutput struct {
-	RunID         uuid.UUID                        `json:"run_id"`
-	Allocations   []AllocationResultByDimension    `json:"allocations"`
-	Contributions []ContributionResultByDimension  `json:"contributions"`
-	Summary       AllocationSummary                `json:"summary"`
+	RunID   uuid.UUID         `json:"run_id"`
+	Summary AllocationSummary `json:"summary"`
 }
 
 // AllocationSummary provides high-level statistics about an allocation run
 type AllocationSummary struct {
-	TotalNodes        int                        `json:"total_nodes"`
-	TotalEdges        int                        `json:"total_edges"`
-	ProcessedDays     int                        `json:"processed_days"`
-	TotalDirectCost   map[string]decimal.Decimal `json:"total_direct_cost"`
-	TotalIndirectCost map[string]decimal.Decimal `json:"total_indirect_cost"`
-	TotalCost         map[string]decimal.Decimal `json:"total_cost"`
-	ProcessingTime    time.Duration              `json:"processing_time"`
+	TotalNodes          int                        `json:"total_nodes"`
+	TotalEdges          int                        `json:"total_edges"`
+	ProcessedDays       int                        `json:"processed_days"`
+	CopiedDays          int                        `json:"copied_days"`
+	AllocationCount     int                        `json:"allocation_count"`
+	ContributionCount   int                        `json:"contribution_count"`
+	LineageCount        int                        `json:"lineage_count"`
+	InvariantViolations int                        `json:"invariant_violations"` // Day and dimension reports that created or lost cost
+	TotalDirectCost     map[string]decimal.Decimal `json:"total_direct_cost"`
+	TotalIndirectCost   map[string]decimal.Decimal `json:"total_indirect_cost"`
+	TotalCost           map[string]decimal.Decimal `json:"total_cost"`
+	TotalUnallocated    map[string]decimal.Decimal `json:"total_unallocated"`      // Cost routed to the run's unallocated node
+	ResumedFrom         *time.Time                 `json:"resumed_from,omitempty"` // First day allocated when a run was resumed; earlier days are not counted
+	ProcessingTime      time.Duration              `json:"processing_time"`
 }
 
 // Custom JSON marshaling for JSONB fields
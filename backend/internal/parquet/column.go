@@ -0,0 +1,481 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// julianUnixEpoch is the Julian day of 1970-01-01, which INT96 timestamps count from
+const julianUnixEpoch = 2440588
+
+// column describes a leaf column of the schema
+type column struct {
+	path       []string
+	physical   int32
+	typeLength int32
+	converted  int32
+	scale      int32
+	logical    logicalType
+	maxDef     int32
+	maxRep     int32
+	// index is the column's position among the leaves, and so among the column
+	// chunks of each row group
+	index int
+}
+
+// columnData holds the decoded values and levels of a column chunk. Levels are
+// nil when the column's maximum level is 0. Only non-null values are held.
+type columnData struct {
+	values []string
+	defs   []int32
+	reps   []int32
+}
+
+// readColumn reads and decodes a column chunk
+func (f *File) readColumn(chunk columnChunk, col *column) (*columnData, error) {
+	meta := chunk.meta
+	if meta == nil {
+		return nil, errors.New("column chunk has no metadata")
+	}
+
+	start := meta.dataPageOffset
+	if meta.dictionaryPageOffset > 0 && meta.dictionaryPageOffset < start {
+		start = meta.dictionaryPageOffset
+	}
+	if start < 0 || meta.totalCompressedSize < 0 || start+meta.totalCompressedSize > f.size {
+		return nil, errors.New("column chunk is outside the file")
+	}
+
+	buf := make([]byte, meta.totalCompressedSize)
+	if _, err := f.r.ReadAt(buf, start); err != nil {
+		return nil, fmt.Errorf("failed to read column chunk: %w", err)
+	}
+
+	data := &columnData{}
+	var dictionary []string
+	r := &thriftReader{buf: buf}
+	for read := int64(0); read < meta.numValues; {
+		header, err := readPageHeader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read page header: %w", err)
+		}
+		size := int(header.compressedSize)
+		if size < 0 || size > len(buf)-r.pos {
+			return nil, errors.New("page is outside its column chunk")
+		}
+		page := buf[r.pos : r.pos+size]
+		r.pos += size
+
+		switch {
+		case header.typ == pageDictionary && header.dictionary != nil:
+			raw, err := decompress(meta.codec, page)
+			if err != nil {
+				return nil, err
+			}
+			if dictionary, _, err = decodePlain(raw, col, int(header.dictionary.numValues)); err != nil {
+				return nil, fmt.Errorf("failed to decode dictionary page: %w", err)
+			}
+		case header.typ == pageData && header.data != nil:
+			raw, err := decompress(meta.codec, page)
+			if err != nil {
+				return nil, err
+			}
+			if err := data.readPage(raw, col, header.data, dictionary); err != nil {
+				return nil, err
+			}
+			read += int64(header.data.numValues)
+		case header.typ == pageDataV2 && header.dataV2 != nil:
+			if err := data.readPageV2(page, meta.codec, col, header.dataV2, dictionary); err != nil {
+				return nil, err
+			}
+			read += int64(header.dataV2.numValues)
+		}
+		// Index pages, and any other page types, hold nothing to read
+
+		if r.pos == len(buf) && read < meta.numValues {
+			return nil, errors.New("column chunk ended before all its values were read")
+		}
+	}
+
+	return data, nil
+}
+
+// readPage reads a decompressed version 1 data page, whose levels are each
+// preceded by their length
+func (d *columnData) readPage(raw []byte, col *column, header *dataPageHeader, dictionary []string) error {
+	n := int(header.numValues)
+	pos := 0
+	if col.maxRep > 0 {
+		levels, size, err := readPrefixedLevels(raw[pos:], col.maxRep, n)
+		if err != nil {
+			return fmt.Errorf("failed to read repetition levels: %w", err)
+		}
+		d.reps = append(d.reps, levels...)
+		pos += size
+	}
+	var defs []int32
+	if col.maxDef > 0 {
+		levels, size, err := readPrefixedLevels(raw[pos:], col.maxDef, n)
+		if err != nil {
+			return fmt.Errorf("failed to read definition levels: %w", err)
+		}
+		defs = levels
+		pos += size
+	}
+	return d.readValues(raw[pos:], col, header.encoding, n, defs, dictionary)
+}
+
+// readPageV2 reads a version 2 data page, whose levels are never compressed and
+// whose lengths are in its header
+func (d *columnData) readPageV2(page []byte, codec int32, col *column, header *dataPageHeaderV2, dictionary []string) error {
+	n := int(header.numValues)
+	repLength, defLength := int(header.repLength), int(header.defLength)
+	if repLength < 0 || defLength < 0 || repLength+defLength > len(page) {
+		return errors.New("page levels are longer than the page")
+	}
+
+	if col.maxRep > 0 {
+		levels, err := decodeHybrid(page[:repLength], bitWidth(col.maxRep), n)
+		if err != nil {
+			return fmt.Errorf("failed to read repetition levels: %w", err)
+		}
+		d.reps = append(d.reps, levels...)
+	}
+	var defs []int32
+	if col.maxDef > 0 {
+		levels, err := decodeHybrid(page[repLength:repLength+defLength], bitWidth(col.maxDef), n)
+		if err != nil {
+			return fmt.Errorf("failed to read definition levels: %w", err)
+		}
+		defs = levels
+	}
+
+	raw := page[repLength+defLength:]
+	if header.isCompressed {
+		var err error
+		if raw, err = decompress(codec, raw); err != nil {
+			return err
+		}
+	}
+	return d.readValues(raw, col, header.encoding, n, defs, dictionary)
+}
+
+// readValues decodes a page's values, of which there is one for each
+// definition level at the column's maximum
+func (d *columnData) readValues(raw []byte, col *column, encoding int32, n int, defs []int32, dictionary []string) error {
+	present := n
+	if col.maxDef > 0 {
+		d.defs = append(d.defs, defs...)
+		present = 0
+		for _, def := range defs {
+			if def == col.maxDef {
+				present++
+			}
+		}
+	}
+
+	var values []string
+	var err error
+	switch encoding {
+	case encodingPlain:
+		values, _, err = decodePlain(raw, col, present)
+	case encodingPlainDictionary, encodingRLEDictionary:
+		values, err = decodeDictionary(raw, dictionary, present)
+	case encodingRLE:
+		if col.physical != typeBoolean {
+			return fmt.Errorf("RLE encoding is not supported for type %d", col.physical)
+		}
+		var levels []int32
+		if levels, _, err = readPrefixedLevels(raw, 1, present); err == nil {
+			values = make([]string, len(levels))
+			for i, v := range levels {
+				values[i] = strconv.FormatBool(v == 1)
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode values: %w", err)
+	}
+
+	d.values = append(d.values, values...)
+	return nil
+}
+
+// decompress decompresses a page
+func decompress(codec int32, data []byte) ([]byte, error) {
+	switch codec {
+	case codecUncompressed:
+		return data, nil
+	case codecSnappy:
+		out, err := snappyDecode(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress page: %w", err)
+		}
+		return out, nil
+	case codecGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress page: %w", err)
+		}
+		out, err := io.ReadAll(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress page: %w", err)
+		}
+		return out, nil
+	default:
+		if name, ok := codecNames[codec]; ok {
+			return nil, fmt.Errorf("unsupported compression codec %s", name)
+		}
+		return nil, fmt.Errorf("unsupported compression codec %d", codec)
+	}
+}
+
+// bitWidth returns the number of bits needed to store levels up to max
+func bitWidth(max int32) int {
+	width := 0
+	for ; max > 0; max >>= 1 {
+		width++
+	}
+	return width
+}
+
+// readPrefixedLevels reads n levels encoded with the RLE/bit-packed hybrid
+// encoding and preceded by their length, returning the bytes consumed
+func readPrefixedLevels(buf []byte, max int32, n int) ([]int32, int, error) {
+	if len(buf) < 4 {
+		return nil, 0, errors.New("levels are truncated")
+	}
+	length := int(binary.LittleEndian.Uint32(buf))
+	if length < 0 || length > len(buf)-4 {
+		return nil, 0, errors.New("levels are truncated")
+	}
+	levels, err := decodeHybrid(buf[4:4+length], bitWidth(max), n)
+	return levels, 4 + length, err
+}
+
+// decodeDictionary decodes n dictionary indices, preceded by their bit width
+func decodeDictionary(buf []byte, dictionary []string, n int) ([]string, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	if len(buf) == 0 {
+		return nil, errors.New("dictionary indices are truncated")
+	}
+	indices, err := decodeHybrid(buf[1:], int(buf[0]), n)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, n)
+	for i, index := range indices {
+		if index < 0 || int(index) >= len(dictionary) {
+			return nil, fmt.Errorf("dictionary index %d out of range", index)
+		}
+		values[i] = dictionary[index]
+	}
+	return values, nil
+}
+
+// decodeHybrid decodes n values encoded with the RLE/bit-packed hybrid encoding
+func decodeHybrid(buf []byte, width int, n int) ([]int32, error) {
+	if width < 0 || width > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", width)
+	}
+	values := make([]int32, 0, n)
+	byteWidth := (width + 7) / 8
+	pos := 0
+
+	for len(values) < n {
+		header, size := binary.Uvarint(buf[pos:])
+		if size <= 0 {
+			return nil, errors.New("encoded values are truncated")
+		}
+		pos += size
+
+		if header&1 == 0 {
+			// A run of one repeated value
+			count := int(header >> 1)
+			if pos+byteWidth > len(buf) {
+				return nil, errors.New("encoded values are truncated")
+			}
+			var v int32
+			for i := 0; i < byteWidth; i++ {
+				v |= int32(buf[pos+i]) << (8 * i)
+			}
+			pos += byteWidth
+			for i := 0; i < count && len(values) < n; i++ {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// Groups of eight bit-packed values, least significant bit first
+		count := int(header>>1) * 8
+		if pos+count*width/8 > len(buf) {
+			return nil, errors.New("encoded values are truncated")
+		}
+		var acc uint64
+		var bits int
+		mask := uint64(1)<<width - 1
+		for i := 0; i < count; i++ {
+			for bits < width {
+				acc |= uint64(buf[pos]) << bits
+				pos++
+				bits += 8
+			}
+			if len(values) < n {
+				values = append(values, int32(acc&mask))
+			}
+			acc >>= width
+			bits -= width
+		}
+	}
+
+	return values, nil
+}
+
+// decodePlain decodes n PLAIN encoded values, returning the bytes consumed
+func decodePlain(buf []byte, col *column, n int) ([]string, int, error) {
+	values := make([]string, 0, n)
+	pos := 0
+	need := func(size int) error {
+		if size < 0 || pos+size > len(buf) {
+			return errors.New("values are truncated")
+		}
+		return nil
+	}
+
+	if col.physical == typeBoolean {
+		// Booleans are bit-packed, least significant bit first
+		if err := need((n + 7) / 8); err != nil {
+			return nil, 0, err
+		}
+		for i := 0; i < n; i++ {
+			values = append(values, strconv.FormatBool(buf[i/8]>>(i%8)&1 == 1))
+		}
+		return values, (n + 7) / 8, nil
+	}
+
+	for i := 0; i < n; i++ {
+		switch col.physical {
+		case typeInt32:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, col.formatInt(int64(int32(binary.LittleEndian.Uint32(buf[pos:])))))
+			pos += 4
+		case typeInt64:
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, col.formatInt(int64(binary.LittleEndian.Uint64(buf[pos:]))))
+			pos += 8
+		case typeInt96:
+			if err := need(12); err != nil {
+				return nil, 0, err
+			}
+			nanos := int64(binary.LittleEndian.Uint64(buf[pos:]))
+			days := int64(binary.LittleEndian.Uint32(buf[pos+8:])) - julianUnixEpoch
+			values = append(values, formatTime(time.Unix(days*86400, nanos)))
+			pos += 12
+		case typeFloat:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			v := math.Float32frombits(binary.LittleEndian.Uint32(buf[pos:]))
+			values = append(values, strconv.FormatFloat(float64(v), 'f', -1, 32))
+			pos += 4
+		case typeDouble:
+			if err := need(8); err != nil {
+				return nil, 0, err
+			}
+			v := math.Float64frombits(binary.LittleEndian.Uint64(buf[pos:]))
+			values = append(values, strconv.FormatFloat(v, 'f', -1, 64))
+			pos += 8
+		case typeByteArray:
+			if err := need(4); err != nil {
+				return nil, 0, err
+			}
+			size := int(binary.LittleEndian.Uint32(buf[pos:]))
+			pos += 4
+			if err := need(size); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, col.formatBytes(buf[pos:pos+size]))
+			pos += size
+		case typeFixedLenByteArray:
+			size := int(col.typeLength)
+			if err := need(size); err != nil {
+				return nil, 0, err
+			}
+			values = append(values, col.formatBytes(buf[pos:pos+size]))
+			pos += size
+		default:
+			return nil, 0, fmt.Errorf("unsupported type %d", col.physical)
+		}
+	}
+
+	return values, pos, nil
+}
+
+// decimalScale returns the scale of a decimal column
+func (c *column) decimalScale() (int32, bool) {
+	if c.logical.kind == logicalDecimal {
+		return c.logical.scale, true
+	}
+	if c.converted == convertedDecimal {
+		return c.scale, true
+	}
+	return 0, false
+}
+
+// formatInt formats an INT32 or INT64 value as its decimal, date or timestamp
+func (c *column) formatInt(v int64) string {
+	if scale, ok := c.decimalScale(); ok {
+		return decimal.New(v, -scale).String()
+	}
+
+	switch {
+	case c.logical.kind == logicalDate || c.converted == convertedDate:
+		return time.Unix(v*86400, 0).UTC().Format("2006-01-02")
+	case c.logical.kind == logicalTimestamp && c.logical.unit == unitNanos:
+		return formatTime(time.Unix(0, v))
+	case c.logical.kind == logicalTimestamp && c.logical.unit == unitMicros,
+		c.converted == convertedTimestampMicros:
+		return formatTime(time.UnixMicro(v))
+	case c.logical.kind == logicalTimestamp && c.logical.unit == unitMillis,
+		c.converted == convertedTimestampMillis:
+		return formatTime(time.UnixMilli(v))
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+// formatBytes formats a byte array as its decimal, or else as a string
+func (c *column) formatBytes(b []byte) string {
+	scale, ok := c.decimalScale()
+	if !ok {
+		return string(b)
+	}
+
+	// Decimals are big-endian two's complement
+	v := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(8*len(b))))
+	}
+	return decimal.NewFromBigInt(v, -scale).String()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
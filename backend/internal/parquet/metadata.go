@@ -0,0 +1,363 @@
+package parquet
+
+// Physical types
+const (
+	typeBoolean           = 0
+	typeInt32             = 1
+	typeInt64             = 2
+	typeInt96             = 3
+	typeFloat             = 4
+	typeDouble            = 5
+	typeByteArray         = 6
+	typeFixedLenByteArray = 7
+)
+
+// Field repetition types
+const (
+	repetitionRequired = 0
+	repetitionOptional = 1
+	repetitionRepeated = 2
+)
+
+// Converted types, the annotations older writers use instead of logical types
+const (
+	convertedNone            = -1
+	convertedMap             = 1
+	convertedMapKeyValue     = 2
+	convertedDecimal         = 5
+	convertedDate            = 6
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+)
+
+// Logical types, identified by their field in the LogicalType union
+const (
+	logicalNone      = 0
+	logicalMap       = 2
+	logicalDecimal   = 5
+	logicalDate      = 6
+	logicalTimestamp = 8
+)
+
+// Timestamp units, identified by their field in the TimeUnit union
+const (
+	unitMillis = 1
+	unitMicros = 2
+	unitNanos  = 3
+)
+
+// Encodings
+const (
+	encodingPlain           = 0
+	encodingPlainDictionary = 2
+	encodingRLE             = 3
+	encodingRLEDictionary   = 8
+)
+
+// Compression codecs
+const (
+	codecUncompressed = 0
+	codecSnappy       = 1
+	codecGzip         = 2
+)
+
+var codecNames = map[int32]string{
+	3: "LZO",
+	4: "BROTLI",
+	5: "LZ4",
+	6: "ZSTD",
+	7: "LZ4_RAW",
+}
+
+// Page types
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+type fileMetaData struct {
+	schema    []schemaElement
+	numRows   int64
+	rowGroups []rowGroup
+}
+
+type schemaElement struct {
+	typ         int32
+	typeLength  int32
+	repetition  int32
+	name        string
+	numChildren int32
+	converted   int32
+	scale       int32
+	logical     logicalType
+}
+
+type logicalType struct {
+	kind  int16
+	scale int32
+	unit  int16
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	numRows int64
+}
+
+type columnChunk struct {
+	meta *columnMetaData
+}
+
+type columnMetaData struct {
+	typ                  int32
+	path                 []string
+	codec                int32
+	numValues            int64
+	totalCompressedSize  int64
+	dataPageOffset       int64
+	dictionaryPageOffset int64
+}
+
+type pageHeader struct {
+	typ              int32
+	uncompressedSize int32
+	compressedSize   int32
+	data             *dataPageHeader
+	dictionary       *dictionaryPageHeader
+	dataV2           *dataPageHeaderV2
+}
+
+type dataPageHeader struct {
+	numValues int32
+	encoding  int32
+	defEnc    int32
+	repEnc    int32
+}
+
+type dictionaryPageHeader struct {
+	numValues int32
+}
+
+type dataPageHeaderV2 struct {
+	numValues    int32
+	encoding     int32
+	defLength    int32
+	repLength    int32
+	isCompressed bool
+}
+
+func readFileMetaData(buf []byte) (fileMetaData, error) {
+	r := &thriftReader{buf: buf}
+	var meta fileMetaData
+	err := r.readStruct(func(id int16, typ byte) error {
+		switch id {
+		case 2:
+			return r.readList(func(byte) error {
+				element, err := readSchemaElement(r)
+				meta.schema = append(meta.schema, element)
+				return err
+			})
+		case 3:
+			v, err := r.readVarint()
+			meta.numRows = v
+			return err
+		case 4:
+			return r.readList(func(byte) error {
+				group, err := readRowGroup(r)
+				meta.rowGroups = append(meta.rowGroups, group)
+				return err
+			})
+		default:
+			return r.skip(typ)
+		}
+	})
+	return meta, err
+}
+
+func readSchemaElement(r *thriftReader) (schemaElement, error) {
+	element := schemaElement{typ: -1, converted: convertedNone}
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch id {
+		case 1:
+			element.typ, err = r.readI32()
+		case 2:
+			element.typeLength, err = r.readI32()
+		case 3:
+			element.repetition, err = r.readI32()
+		case 4:
+			element.name, err = r.readString()
+		case 5:
+			element.numChildren, err = r.readI32()
+		case 6:
+			element.converted, err = r.readI32()
+		case 7:
+			element.scale, err = r.readI32()
+		case 10:
+			element.logical, err = readLogicalType(r)
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	return element, err
+}
+
+func readLogicalType(r *thriftReader) (logicalType, error) {
+	var logical logicalType
+	err := r.readStruct(func(id int16, typ byte) error {
+		logical.kind = id
+		switch id {
+		case logicalDecimal:
+			return r.readStruct(func(id int16, typ byte) error {
+				if id == 1 {
+					var err error
+					logical.scale, err = r.readI32()
+					return err
+				}
+				return r.skip(typ)
+			})
+		case logicalTimestamp:
+			return r.readStruct(func(id int16, typ byte) error {
+				if id == 2 {
+					return r.readStruct(func(id int16, typ byte) error {
+						logical.unit = id
+						return r.skip(typ)
+					})
+				}
+				return r.skip(typ)
+			})
+		default:
+			return r.skip(typ)
+		}
+	})
+	return logical, err
+}
+
+func readRowGroup(r *thriftReader) (rowGroup, error) {
+	var group rowGroup
+	err := r.readStruct(func(id int16, typ byte) error {
+		switch id {
+		case 1:
+			return r.readList(func(byte) error {
+				chunk, err := readColumnChunk(r)
+				group.columns = append(group.columns, chunk)
+				return err
+			})
+		case 3:
+			v, err := r.readVarint()
+			group.numRows = v
+			return err
+		default:
+			return r.skip(typ)
+		}
+	})
+	return group, err
+}
+
+func readColumnChunk(r *thriftReader) (columnChunk, error) {
+	var chunk columnChunk
+	err := r.readStruct(func(id int16, typ byte) error {
+		if id == 3 {
+			meta, err := readColumnMetaData(r)
+			chunk.meta = &meta
+			return err
+		}
+		return r.skip(typ)
+	})
+	return chunk, err
+}
+
+func readColumnMetaData(r *thriftReader) (columnMetaData, error) {
+	var meta columnMetaData
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch id {
+		case 1:
+			meta.typ, err = r.readI32()
+		case 3:
+			meta.path, err = r.readStringList()
+		case 4:
+			meta.codec, err = r.readI32()
+		case 5:
+			meta.numValues, err = r.readVarint()
+		case 7:
+			meta.totalCompressedSize, err = r.readVarint()
+		case 9:
+			meta.dataPageOffset, err = r.readVarint()
+		case 11:
+			meta.dictionaryPageOffset, err = r.readVarint()
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	return meta, err
+}
+
+func readPageHeader(r *thriftReader) (pageHeader, error) {
+	var header pageHeader
+	err := r.readStruct(func(id int16, typ byte) error {
+		var err error
+		switch id {
+		case 1:
+			header.typ, err = r.readI32()
+		case 2:
+			header.uncompressedSize, err = r.readI32()
+		case 3:
+			header.compressedSize, err = r.readI32()
+		case 5:
+			header.data = &dataPageHeader{}
+			err = r.readStruct(func(id int16, typ byte) error {
+				var err error
+				switch id {
+				case 1:
+					header.data.numValues, err = r.readI32()
+				case 2:
+					header.data.encoding, err = r.readI32()
+				case 3:
+					header.data.defEnc, err = r.readI32()
+				case 4:
+					header.data.repEnc, err = r.readI32()
+				default:
+					err = r.skip(typ)
+				}
+				return err
+			})
+		case 7:
+			header.dictionary = &dictionaryPageHeader{}
+			err = r.readStruct(func(id int16, typ byte) error {
+				if id == 1 {
+					var err error
+					header.dictionary.numValues, err = r.readI32()
+					return err
+				}
+				return r.skip(typ)
+			})
+		case 8:
+			header.dataV2 = &dataPageHeaderV2{isCompressed: true}
+			err = r.readStruct(func(id int16, typ byte) error {
+				var err error
+				switch id {
+				case 1:
+					header.dataV2.numValues, err = r.readI32()
+				case 4:
+					header.dataV2.encoding, err = r.readI32()
+				case 5:
+					header.dataV2.defLength, err = r.readI32()
+				case 6:
+					header.dataV2.repLength, err = r.readI32()
+				case 7:
+					header.dataV2.isCompressed = typ == compactTrue
+				default:
+					err = r.skip(typ)
+				}
+				return err
+			})
+		default:
+			err = r.skip(typ)
+		}
+		return err
+	})
+	return header, err
+}
@@ -0,0 +1,342 @@
+// Package parquet reads Apache Parquet files, as AWS CUR 2.0 and Data Exports
+// deliver. It reads flat columns, columns nested in structs and maps of
+// primitive keys and values; other repeated fields, such as lists, are not
+// read. PLAIN and dictionary encoded pages are supported, uncompressed or
+// compressed with snappy or gzip.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var magic = []byte("PAR1")
+
+// File is a Parquet file opened for reading
+type File struct {
+	r        io.ReaderAt
+	size     int64
+	metadata fileMetaData
+	columns  []*column
+	fields   []field
+}
+
+// field is a top-level field that can be read: a primitive column, named by
+// its path when nested in structs, or a map
+type field struct {
+	name  string
+	value *column
+	// key is the key column of a map
+	key *column
+}
+
+// schemaNode is an element of the schema tree
+type schemaNode struct {
+	element  schemaElement
+	children []*schemaNode
+	column   *column
+}
+
+// Row is a row of a file, keyed by field name. Map entries are keyed by the
+// map's name and the entry's key, joined by a dot. Null values are omitted.
+type Row map[string]string
+
+// Open opens a Parquet file of the given size
+func Open(r io.ReaderAt, size int64) (*File, error) {
+	if size < 12 {
+		return nil, errors.New("file is too small to be Parquet")
+	}
+
+	tail := make([]byte, 8)
+	if _, err := r.ReadAt(tail, size-8); err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+	if !bytes.Equal(tail[4:], magic) {
+		return nil, errors.New("not a Parquet file")
+	}
+
+	footerLength := int64(binary.LittleEndian.Uint32(tail))
+	if footerLength > size-12 {
+		return nil, errors.New("footer is longer than the file")
+	}
+	footer := make([]byte, footerLength)
+	if _, err := r.ReadAt(footer, size-8-footerLength); err != nil {
+		return nil, fmt.Errorf("failed to read footer: %w", err)
+	}
+
+	metadata, err := readFileMetaData(footer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file metadata: %w", err)
+	}
+
+	f := &File{r: r, size: size, metadata: metadata}
+	if err := f.readSchema(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// NumRows returns the number of rows in the file
+func (f *File) NumRows() int64 {
+	return f.metadata.numRows
+}
+
+// Fields returns the names of the fields that can be read, in schema order
+func (f *File) Fields() []string {
+	names := make([]string, len(f.fields))
+	for i, fld := range f.fields {
+		names[i] = fld.name
+	}
+	return names
+}
+
+// readSchema builds the schema tree from its flattened, depth-first form and
+// finds the fields that can be read
+func (f *File) readSchema() error {
+	elements := f.metadata.schema
+	if len(elements) == 0 {
+		return errors.New("file has no schema")
+	}
+
+	pos := 1
+	var build func(element schemaElement, path []string, maxDef, maxRep int32) (*schemaNode, error)
+	build = func(element schemaElement, path []string, maxDef, maxRep int32) (*schemaNode, error) {
+		node := &schemaNode{element: element}
+		if element.numChildren == 0 {
+			node.column = &column{
+				path:       path,
+				physical:   element.typ,
+				typeLength: element.typeLength,
+				converted:  element.converted,
+				scale:      element.scale,
+				logical:    element.logical,
+				maxDef:     maxDef,
+				maxRep:     maxRep,
+				index:      len(f.columns),
+			}
+			f.columns = append(f.columns, node.column)
+			return node, nil
+		}
+
+		for i := int32(0); i < element.numChildren; i++ {
+			if pos >= len(elements) {
+				return nil, errors.New("schema is truncated")
+			}
+			child := elements[pos]
+			pos++
+
+			def, rep := maxDef, maxRep
+			switch child.repetition {
+			case repetitionOptional:
+				def++
+			case repetitionRepeated:
+				def++
+				rep++
+			}
+
+			childPath := append(append([]string{}, path...), child.name)
+			childNode, err := build(child, childPath, def, rep)
+			if err != nil {
+				return nil, err
+			}
+			node.children = append(node.children, childNode)
+		}
+		return node, nil
+	}
+
+	root, err := build(elements[0], nil, 0, 0)
+	if err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+
+	for _, node := range root.children {
+		f.addFields(node)
+	}
+
+	for _, group := range f.metadata.rowGroups {
+		if len(group.columns) != len(f.columns) {
+			return fmt.Errorf("row group has %d columns, schema has %d", len(group.columns), len(f.columns))
+		}
+	}
+	return nil
+}
+
+// addFields adds the fields that can be read from a schema node
+func (f *File) addFields(node *schemaNode) {
+	if node.column != nil {
+		if node.column.maxRep == 0 {
+			f.fields = append(f.fields, field{name: strings.Join(node.column.path, "."), value: node.column})
+		}
+		return
+	}
+
+	if node.element.repetition == repetitionRepeated {
+		return
+	}
+
+	if key, value, ok := mapColumns(node); ok {
+		f.fields = append(f.fields, field{name: strings.Join(key.path[:len(key.path)-2], "."), key: key, value: value})
+		return
+	}
+
+	// Lists are annotated groups too, and like other repeated fields are not read
+	for _, child := range node.children {
+		f.addFields(child)
+	}
+}
+
+// mapColumns returns the key and value columns of a map of primitive keys and
+// values: a group holding a repeated group of a key and a value
+func mapColumns(node *schemaNode) (*column, *column, bool) {
+	isMap := node.element.converted == convertedMap ||
+		node.element.converted == convertedMapKeyValue ||
+		node.element.logical.kind == logicalMap
+	if !isMap || len(node.children) != 1 {
+		return nil, nil, false
+	}
+
+	entries := node.children[0]
+	if entries.element.repetition != repetitionRepeated || len(entries.children) != 2 {
+		return nil, nil, false
+	}
+	key, value := entries.children[0].column, entries.children[1].column
+	if key == nil || value == nil || value.maxRep != key.maxRep || key.maxRep != 1 {
+		return nil, nil, false
+	}
+	return key, value, true
+}
+
+// Rows returns an iterator over the file's rows. Only the fields for which
+// include returns true are read; a nil include reads them all.
+func (f *File) Rows(include func(field string) bool) *Rows {
+	rows := &Rows{file: f}
+	for _, fld := range f.fields {
+		if include == nil || include(fld.name) {
+			rows.fields = append(rows.fields, fld)
+		}
+	}
+	return rows
+}
+
+// Rows iterates over the rows of a file, one row group at a time
+type Rows struct {
+	file      *File
+	fields    []field
+	group     int
+	remaining int64
+	cursors   []*fieldCursor
+}
+
+// fieldCursor tracks the position of a field in the current row group
+type fieldCursor struct {
+	field field
+	value *columnData
+	key   *columnData
+	// level is the index of the next level, and valueIndex and keyIndex the
+	// index of the next non-null value
+	level      int
+	valueIndex int
+	keyIndex   int
+}
+
+// Next returns the next row, or io.EOF when there are no more
+func (r *Rows) Next() (Row, error) {
+	for r.remaining == 0 {
+		if r.group >= len(r.file.metadata.rowGroups) {
+			return nil, io.EOF
+		}
+		if err := r.readGroup(); err != nil {
+			return nil, err
+		}
+	}
+
+	row := make(Row, len(r.cursors))
+	for _, cursor := range r.cursors {
+		if err := cursor.read(row); err != nil {
+			return nil, err
+		}
+	}
+	r.remaining--
+	return row, nil
+}
+
+// readGroup reads the included columns of the next row group
+func (r *Rows) readGroup() error {
+	group := r.file.metadata.rowGroups[r.group]
+	r.group++
+
+	r.cursors = r.cursors[:0]
+	for _, fld := range r.fields {
+		cursor := &fieldCursor{field: fld}
+		var err error
+		if cursor.value, err = r.file.readColumn(group.columns[fld.value.index], fld.value); err != nil {
+			return fmt.Errorf("failed to read column %s: %w", strings.Join(fld.value.path, "."), err)
+		}
+		if fld.key != nil {
+			if cursor.key, err = r.file.readColumn(group.columns[fld.key.index], fld.key); err != nil {
+				return fmt.Errorf("failed to read column %s: %w", strings.Join(fld.key.path, "."), err)
+			}
+		}
+		r.cursors = append(r.cursors, cursor)
+	}
+
+	r.remaining = group.numRows
+	return nil
+}
+
+// read adds the field's values in the next row to row
+func (c *fieldCursor) read(row Row) error {
+	if c.field.key == nil {
+		value, ok, err := c.next(c.value, c.field.value, &c.valueIndex)
+		if err != nil {
+			return err
+		}
+		if ok {
+			row[c.field.name] = value
+		}
+		c.level++
+		return nil
+	}
+
+	// A map's entries continue until the next level with a repetition level of 0
+	for first := true; c.level < len(c.key.reps); first = false {
+		if !first && c.key.reps[c.level] == 0 {
+			break
+		}
+		key, hasKey, err := c.next(c.key, c.field.key, &c.keyIndex)
+		if err != nil {
+			return err
+		}
+		value, hasValue, err := c.next(c.value, c.field.value, &c.valueIndex)
+		if err != nil {
+			return err
+		}
+		if hasKey && hasValue {
+			row[c.field.name+"."+key] = value
+		}
+		c.level++
+	}
+	return nil
+}
+
+// next returns the value at the cursor's level, if it is not null
+func (c *fieldCursor) next(data *columnData, col *column, index *int) (string, bool, error) {
+	if col.maxDef > 0 {
+		if c.level >= len(data.defs) {
+			return "", false, errors.New("column has fewer values than rows")
+		}
+		if data.defs[c.level] != col.maxDef {
+			return "", false, nil
+		}
+	}
+	if *index >= len(data.values) {
+		return "", false, errors.New("column has fewer values than rows")
+	}
+	value := data.values[*index]
+	*index++
+	return value, true, nil
+}
@@ -0,0 +1,629 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// thriftWriter encodes the Thrift compact protocol, to build test files
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+}
+
+func (w *thriftWriter) uvarint(v uint64) {
+	w.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (w *thriftWriter) varint(v int64) {
+	w.uvarint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := w.last[len(w.last)-1]
+	if delta := id - last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	w.last[len(w.last)-1] = id
+}
+
+func (w *thriftWriter) begin() { w.last = append(w.last, 0) }
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(compactStop)
+	w.last = w.last[:len(w.last)-1]
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, compactI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, compactI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) str(id int16, v string) {
+	w.field(id, compactBinary)
+	w.uvarint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *thriftWriter) boolean(id int16, v bool) {
+	if v {
+		w.field(id, compactTrue)
+	} else {
+		w.field(id, compactFalse)
+	}
+}
+
+func (w *thriftWriter) structField(id int16, fn func()) {
+	w.field(id, compactStruct)
+	w.begin()
+	fn()
+	w.end()
+}
+
+func (w *thriftWriter) listHeader(n int, typ byte) {
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	w.buf.WriteByte(0xf0 | typ)
+	w.uvarint(uint64(n))
+}
+
+func (w *thriftWriter) structList(id int16, n int, fn func(i int)) {
+	w.field(id, compactList)
+	w.listHeader(n, compactStruct)
+	for i := 0; i < n; i++ {
+		w.begin()
+		fn(i)
+		w.end()
+	}
+}
+
+func (w *thriftWriter) stringList(id int16, values []string) {
+	w.field(id, compactList)
+	w.listHeader(len(values), compactBinary)
+	for _, v := range values {
+		w.uvarint(uint64(len(v)))
+		w.buf.WriteString(v)
+	}
+}
+
+// testElement is a schema element of a test file
+type testElement struct {
+	name        string
+	typ         int32
+	repetition  int32
+	numChildren int32
+	converted   int32
+	scale       int32
+	precision   int32
+	// logical is the LogicalType union field to set, with unit for timestamps
+	logical int16
+	unit    int16
+}
+
+// testPage is a page of a test column chunk
+type testPage struct {
+	typ        int32
+	numValues  int32
+	encoding   int32
+	reps, defs []int32
+	maxRep     int32
+	maxDef     int32
+	values     []byte
+}
+
+// testColumn is a column chunk of a test file
+type testColumn struct {
+	path  []string
+	typ   int32
+	codec int32
+	pages []testPage
+}
+
+// buildFile writes a Parquet file with a row group per slice of columns
+func buildFile(t *testing.T, schema []testElement, groups [][]testColumn, numRows []int64) []byte {
+	t.Helper()
+
+	var file bytes.Buffer
+	file.Write(magic)
+
+	type chunkMeta struct {
+		column     testColumn
+		numValues  int64
+		offset     int64
+		dictOffset int64
+		size       int64
+	}
+	var metas [][]chunkMeta
+
+	for _, group := range groups {
+		var groupMetas []chunkMeta
+		for _, col := range group {
+			meta := chunkMeta{column: col}
+			start := int64(file.Len())
+			for _, page := range col.pages {
+				if page.typ == pageDictionary {
+					meta.dictOffset = int64(file.Len())
+				} else if meta.offset == 0 {
+					meta.offset = int64(file.Len())
+				}
+				file.Write(encodePage(t, page, col.codec))
+				if page.typ != pageDictionary {
+					meta.numValues += int64(page.numValues)
+				}
+			}
+			meta.size = int64(file.Len()) - start
+			groupMetas = append(groupMetas, meta)
+		}
+		metas = append(metas, groupMetas)
+	}
+
+	var total int64
+	for _, n := range numRows {
+		total += n
+	}
+
+	w := &thriftWriter{}
+	w.begin()
+	w.i32(1, 1)
+	w.structList(2, len(schema), func(i int) {
+		e := schema[i]
+		if e.numChildren == 0 {
+			w.i32(1, e.typ)
+		}
+		if i > 0 {
+			w.i32(3, e.repetition)
+		}
+		w.str(4, e.name)
+		if e.numChildren > 0 {
+			w.i32(5, e.numChildren)
+		}
+		if e.converted != 0 {
+			w.i32(6, e.converted)
+		}
+		if e.scale != 0 {
+			w.i32(7, e.scale)
+			w.i32(8, e.precision)
+		}
+		if e.logical != 0 {
+			w.structField(10, func() {
+				w.structField(e.logical, func() {
+					switch e.logical {
+					case logicalDecimal:
+						w.i32(1, e.scale)
+						w.i32(2, e.precision)
+					case logicalTimestamp:
+						w.boolean(1, true)
+						w.structField(2, func() {
+							w.structField(e.unit, func() {})
+						})
+					}
+				})
+			})
+		}
+	})
+	w.i64(3, total)
+	w.structList(4, len(metas), func(i int) {
+		w.structList(1, len(metas[i]), func(j int) {
+			meta := metas[i][j]
+			w.i64(2, meta.offset)
+			w.structField(3, func() {
+				w.i32(1, meta.column.typ)
+				w.field(2, compactList)
+				w.listHeader(1, compactI32)
+				w.varint(encodingPlain)
+				w.stringList(3, meta.column.path)
+				w.i32(4, meta.column.codec)
+				w.i64(5, meta.numValues)
+				w.i64(6, meta.size)
+				w.i64(7, meta.size)
+				w.i64(9, meta.offset)
+				if meta.dictOffset > 0 {
+					w.i64(11, meta.dictOffset)
+				}
+			})
+		})
+		w.i64(2, 0)
+		w.i64(3, numRows[i])
+	})
+	w.end()
+
+	file.Write(w.buf.Bytes())
+	file.Write(binary.LittleEndian.AppendUint32(nil, uint32(w.buf.Len())))
+	file.Write(magic)
+	return file.Bytes()
+}
+
+// encodePage encodes a page and its header
+func encodePage(t *testing.T, page testPage, codec int32) []byte {
+	t.Helper()
+
+	var body []byte
+	var repLength, defLength int
+	switch page.typ {
+	case pageDictionary:
+		body = compressTest(t, codec, page.values)
+	case pageData:
+		var raw []byte
+		if page.maxRep > 0 {
+			raw = append(raw, prefixed(rleRuns(page.reps, bitWidth(page.maxRep)))...)
+		}
+		if page.maxDef > 0 {
+			raw = append(raw, prefixed(bitPacked(page.defs, bitWidth(page.maxDef)))...)
+		}
+		body = compressTest(t, codec, append(raw, page.values...))
+	case pageDataV2:
+		var levels []byte
+		if page.maxRep > 0 {
+			reps := rleRuns(page.reps, bitWidth(page.maxRep))
+			repLength = len(reps)
+			levels = append(levels, reps...)
+		}
+		if page.maxDef > 0 {
+			defs := rleRuns(page.defs, bitWidth(page.maxDef))
+			defLength = len(defs)
+			levels = append(levels, defs...)
+		}
+		body = append(levels, compressTest(t, codec, page.values)...)
+	}
+
+	w := &thriftWriter{}
+	w.begin()
+	w.i32(1, page.typ)
+	w.i32(2, int32(len(body)))
+	w.i32(3, int32(len(body)))
+	switch page.typ {
+	case pageDictionary:
+		w.structField(7, func() {
+			w.i32(1, page.numValues)
+			w.i32(2, encodingPlain)
+		})
+	case pageData:
+		w.structField(5, func() {
+			w.i32(1, page.numValues)
+			w.i32(2, page.encoding)
+			w.i32(3, encodingRLE)
+			w.i32(4, encodingRLE)
+		})
+	case pageDataV2:
+		w.structField(8, func() {
+			w.i32(1, page.numValues)
+			w.i32(2, 0)
+			w.i32(3, page.numValues)
+			w.i32(4, page.encoding)
+			w.i32(5, int32(defLength))
+			w.i32(6, int32(repLength))
+			w.boolean(7, codec != codecUncompressed)
+		})
+	}
+	w.end()
+
+	return append(w.buf.Bytes(), body...)
+}
+
+func compressTest(t *testing.T, codec int32, data []byte) []byte {
+	t.Helper()
+	switch codec {
+	case codecSnappy:
+		return snappyLiteral(data)
+	case codecGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	return data
+}
+
+// snappyLiteral encodes data as a snappy block of a single literal
+func snappyLiteral(data []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(data)))
+	if len(data) == 0 {
+		return out
+	}
+	n := len(data) - 1
+	if n < 60 {
+		out = append(out, byte(n)<<2)
+	} else {
+		out = append(out, 61<<2, byte(n), byte(n>>8))
+	}
+	return append(out, data...)
+}
+
+func prefixed(b []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(b))), b...)
+}
+
+// rleRuns encodes values as RLE runs of one value each
+func rleRuns(values []int32, width int) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.AppendUvarint(out, 1<<1)
+		for i := 0; i < (width+7)/8; i++ {
+			out = append(out, byte(v>>(8*i)))
+		}
+	}
+	return out
+}
+
+// bitPacked encodes values as a single bit-packed run
+func bitPacked(values []int32, width int) []byte {
+	groups := (len(values) + 7) / 8
+	out := binary.AppendUvarint(nil, uint64(groups<<1|1))
+	packed := make([]byte, groups*width)
+	for i, v := range values {
+		for b := 0; b < width; b++ {
+			if v>>b&1 == 1 {
+				bit := i*width + b
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+		}
+	}
+	return append(out, packed...)
+}
+
+func plainStrings(values ...string) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(v)))
+		out = append(out, v...)
+	}
+	return out
+}
+
+func plainDoubles(values ...float64) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.LittleEndian.AppendUint64(out, math.Float64bits(v))
+	}
+	return out
+}
+
+func plainInt64s(values ...int64) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.LittleEndian.AppendUint64(out, uint64(v))
+	}
+	return out
+}
+
+func plainInt32s(values ...int32) []byte {
+	var out []byte
+	for _, v := range values {
+		out = binary.LittleEndian.AppendUint32(out, uint32(v))
+	}
+	return out
+}
+
+func readAll(t *testing.T, data []byte, include func(string) bool) []Row {
+	t.Helper()
+	f, err := Open(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+
+	var rows []Row
+	iter := f.Rows(include)
+	for {
+		row, err := iter.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// curSchema resembles a CUR 2.0 export: flat columns and a map of tags
+var curSchema = []testElement{
+	{name: "schema", numChildren: 5},
+	{name: "line_item_usage_start_date", typ: typeInt64, repetition: repetitionOptional, logical: logicalTimestamp, unit: unitMillis},
+	{name: "line_item_unblended_cost", typ: typeDouble, repetition: repetitionOptional},
+	{name: "product_code", typ: typeByteArray, repetition: repetitionOptional, converted: 0, logical: 1},
+	{name: "pricing_unit", typ: typeInt32, repetition: repetitionRequired, converted: convertedDecimal, scale: 2, precision: 9},
+	{name: "resource_tags", repetition: repetitionOptional, numChildren: 1, converted: convertedMap},
+	{name: "key_value", repetition: repetitionRepeated, numChildren: 2},
+	{name: "key", typ: typeByteArray, repetition: repetitionRequired},
+	{name: "value", typ: typeByteArray, repetition: repetitionOptional},
+}
+
+func curColumns(codec int32, pageType int32) []testColumn {
+	return []testColumn{
+		{path: []string{"line_item_usage_start_date"}, typ: typeInt64, codec: codec, pages: []testPage{
+			{typ: pageType, numValues: 3, encoding: encodingPlain, maxDef: 1, defs: []int32{1, 1, 1},
+				values: plainInt64s(1705276800000, 1705276800000, 1705363200500)},
+		}},
+		{path: []string{"line_item_unblended_cost"}, typ: typeDouble, codec: codec, pages: []testPage{
+			{typ: pageType, numValues: 3, encoding: encodingPlain, maxDef: 1, defs: []int32{1, 0, 1},
+				values: plainDoubles(12.5, 0.25)},
+		}},
+		{path: []string{"product_code"}, typ: typeByteArray, codec: codec, pages: []testPage{
+			{typ: pageDictionary, numValues: 2, values: plainStrings("AmazonEC2", "AmazonS3")},
+			{typ: pageType, numValues: 3, encoding: encodingRLEDictionary, maxDef: 1, defs: []int32{1, 1, 1},
+				values: append([]byte{1}, rleRuns([]int32{0, 1, 0}, 1)...)},
+		}},
+		{path: []string{"pricing_unit"}, typ: typeInt32, codec: codec, pages: []testPage{
+			{typ: pageType, numValues: 3, encoding: encodingPlain, values: plainInt32s(1234, -5, 0)},
+		}},
+		{path: []string{"resource_tags", "key_value", "key"}, typ: typeByteArray, codec: codec, pages: []testPage{
+			{typ: pageType, numValues: 4, encoding: encodingPlain, maxRep: 1, maxDef: 2,
+				reps: []int32{0, 1, 0, 0}, defs: []int32{2, 2, 1, 2},
+				values: plainStrings("user_product", "user_team", "user_product")},
+		}},
+		{path: []string{"resource_tags", "key_value", "value"}, typ: typeByteArray, codec: codec, pages: []testPage{
+			{typ: pageType, numValues: 4, encoding: encodingPlain, maxRep: 1, maxDef: 3,
+				reps: []int32{0, 1, 0, 0}, defs: []int32{3, 2, 1, 3},
+				values: plainStrings("web", "api")},
+		}},
+	}
+}
+
+func TestRows(t *testing.T) {
+	expected := []Row{
+		{
+			"line_item_usage_start_date": "2024-01-15T00:00:00Z",
+			"line_item_unblended_cost":   "12.5",
+			"product_code":               "AmazonEC2",
+			"pricing_unit":               "12.34",
+			"resource_tags.user_product": "web",
+		},
+		{
+			"line_item_usage_start_date": "2024-01-15T00:00:00Z",
+			"product_code":               "AmazonS3",
+			"pricing_unit":               "-0.05",
+		},
+		{
+			"line_item_usage_start_date": "2024-01-16T00:00:00.5Z",
+			"line_item_unblended_cost":   "0.25",
+			"product_code":               "AmazonEC2",
+			"pricing_unit":               "0",
+			"resource_tags.user_product": "api",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		codec    int32
+		pageType int32
+	}{
+		{name: "uncompressed v1 pages", codec: codecUncompressed, pageType: pageData},
+		{name: "snappy v1 pages", codec: codecSnappy, pageType: pageData},
+		{name: "gzip v1 pages", codec: codecGzip, pageType: pageData},
+		{name: "snappy v2 pages", codec: codecSnappy, pageType: pageDataV2},
+		{name: "uncompressed v2 pages", codec: codecUncompressed, pageType: pageDataV2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := buildFile(t, curSchema, [][]testColumn{curColumns(tt.codec, tt.pageType)}, []int64{3})
+
+			f, err := Open(bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			assert.Equal(t, int64(3), f.NumRows())
+			assert.Equal(t, []string{
+				"line_item_usage_start_date",
+				"line_item_unblended_cost",
+				"product_code",
+				"pricing_unit",
+				"resource_tags",
+			}, f.Fields())
+
+			assert.Equal(t, expected, readAll(t, data, nil))
+		})
+	}
+}
+
+func TestRowsIncludesOnlySelectedFields(t *testing.T) {
+	data := buildFile(t, curSchema, [][]testColumn{curColumns(codecUncompressed, pageData)}, []int64{3})
+
+	rows := readAll(t, data, func(field string) bool {
+		return field == "product_code" || field == "resource_tags"
+	})
+
+	assert.Equal(t, []Row{
+		{"product_code": "AmazonEC2", "resource_tags.user_product": "web"},
+		{"product_code": "AmazonS3"},
+		{"product_code": "AmazonEC2", "resource_tags.user_product": "api"},
+	}, rows)
+}
+
+func TestRowsAcrossRowGroups(t *testing.T) {
+	schema := []testElement{
+		{name: "schema", numChildren: 1},
+		{name: "bill", repetition: repetitionOptional, numChildren: 1},
+		{name: "payer_account_id", typ: typeByteArray, repetition: repetitionRequired},
+	}
+	group := func(values ...string) []testColumn {
+		return []testColumn{{path: []string{"bill", "payer_account_id"}, typ: typeByteArray, pages: []testPage{
+			{typ: pageData, numValues: int32(len(values)), encoding: encodingPlain, maxDef: 1,
+				defs: []int32{1, 1}[:len(values)], values: plainStrings(values...)},
+		}}}
+	}
+
+	data := buildFile(t, schema, [][]testColumn{group("111", "222"), group("333")}, []int64{2, 1})
+
+	assert.Equal(t, []Row{
+		{"bill.payer_account_id": "111"},
+		{"bill.payer_account_id": "222"},
+		{"bill.payer_account_id": "333"},
+	}, readAll(t, data, nil))
+}
+
+func TestOpenRejectsOtherFiles(t *testing.T) {
+	data := []byte("identity/LineItemId,lineItem/UsageStartDate\n")
+	_, err := Open(bytes.NewReader(data), int64(len(data)))
+	assert.Error(t, err)
+}
+
+func TestUnsupportedCodec(t *testing.T) {
+	columns := curColumns(codecUncompressed, pageData)
+	for i := range columns {
+		columns[i].codec = 6
+	}
+	data := buildFile(t, curSchema, [][]testColumn{columns}, []int64{3})
+
+	f, err := Open(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	_, err = f.Rows(nil).Next()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "ZSTD")
+}
+
+func TestDecodeHybrid(t *testing.T) {
+	values := []int32{0, 1, 2, 3, 4, 5, 6, 7, 3, 2}
+
+	decoded, err := decodeHybrid(bitPacked(values, 3), 3, len(values))
+	require.NoError(t, err)
+	assert.Equal(t, values, decoded)
+
+	decoded, err = decodeHybrid(rleRuns(values, 3), 3, len(values))
+	require.NoError(t, err)
+	assert.Equal(t, values, decoded)
+
+	_, err = decodeHybrid([]byte{0x03}, 3, 8)
+	assert.Error(t, err)
+}
+
+func TestSnappyDecode(t *testing.T) {
+	// "abcd" as a literal, then a copy of 8 bytes from 4 back, which overlaps
+	// its own output
+	block := []byte{12, 3 << 2, 'a', 'b', 'c', 'd', 0x01 | (8-4)<<2, 4}
+	decoded, err := snappyDecode(block)
+	require.NoError(t, err)
+	assert.Equal(t, "abcdabcdabcd", string(decoded))
+
+	decoded, err = snappyDecode(snappyLiteral(bytes.Repeat([]byte("x"), 100)))
+	require.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("x"), 100), decoded)
+
+	_, err = snappyDecode([]byte{12, 3 << 2, 'a', 'b', 'c', 'd', 0x01 | (8-4)<<2, 9})
+	assert.Error(t, err)
+}
+
+func TestFormatInt96(t *testing.T) {
+	col := &column{physical: typeInt96}
+	value := binary.LittleEndian.AppendUint64(nil, uint64(3600*1e9))
+	value = binary.LittleEndian.AppendUint32(value, julianUnixEpoch+19737)
+
+	values, size, err := decodePlain(value, col, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 12, size)
+	assert.Equal(t, []string{"2024-01-15T01:00:00Z"}, values)
+}
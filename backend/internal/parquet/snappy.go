@@ -0,0 +1,84 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errCorruptSnappy = errors.New("corrupt snappy data")
+
+// maxSnappyLength bounds the decoded length a snappy block may claim
+const maxSnappyLength = 1 << 31
+
+// snappyDecode decodes a snappy block, the raw (unframed) format Parquet pages
+// are compressed with
+func snappyDecode(src []byte) ([]byte, error) {
+	length, n := binary.Uvarint(src)
+	if n <= 0 || length > maxSnappyLength {
+		return nil, errCorruptSnappy
+	}
+
+	dst := make([]byte, 0, length)
+	for s := n; s < len(src); {
+		tag := src[s]
+		var size, offset int
+
+		switch tag & 0x03 {
+		case 0x00: // literal
+			size = int(tag >> 2)
+			s++
+			if size >= 60 {
+				extra := size - 59
+				if s+extra > len(src) {
+					return nil, errCorruptSnappy
+				}
+				size = 0
+				for i := 0; i < extra; i++ {
+					size |= int(src[s+i]) << (8 * i)
+				}
+				s += extra
+			}
+			size++
+			if size <= 0 || s+size > len(src) {
+				return nil, errCorruptSnappy
+			}
+			dst = append(dst, src[s:s+size]...)
+			s += size
+			continue
+		case 0x01: // copy with a 1 byte offset
+			if s+2 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			size = 4 + int(tag>>2&0x07)
+			offset = int(tag&0xe0)<<3 | int(src[s+1])
+			s += 2
+		case 0x02: // copy with a 2 byte offset
+			if s+3 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[s+1:]))
+			s += 3
+		case 0x03: // copy with a 4 byte offset
+			if s+5 > len(src) {
+				return nil, errCorruptSnappy
+			}
+			size = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[s+1:]))
+			s += 5
+		}
+
+		if offset <= 0 || offset > len(dst) || uint64(len(dst)+size) > length {
+			return nil, errCorruptSnappy
+		}
+		// Copies may overlap their own output, so are made a byte at a time
+		for i := 0; i < size; i++ {
+			dst = append(dst, dst[len(dst)-offset])
+		}
+	}
+
+	if uint64(len(dst)) != length {
+		return nil, errCorruptSnappy
+	}
+	return dst, nil
+}
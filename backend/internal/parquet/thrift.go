@@ -0,0 +1,219 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Thrift compact protocol types
+const (
+	compactStop   = 0
+	compactTrue   = 1
+	compactFalse  = 2
+	compactByte   = 3
+	compactI16    = 4
+	compactI32    = 5
+	compactI64    = 6
+	compactDouble = 7
+	compactBinary = 8
+	compactList   = 9
+	compactSet    = 10
+	compactMap    = 11
+	compactStruct = 12
+)
+
+var errTruncated = errors.New("truncated thrift data")
+
+// thriftReader decodes Thrift compact protocol data, which Parquet uses for its
+// file metadata and page headers
+type thriftReader struct {
+	buf []byte
+	pos int
+}
+
+func (r *thriftReader) readByte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errTruncated
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *thriftReader) readUvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf[r.pos:])
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	r.pos += n
+	return v, nil
+}
+
+// readVarint reads a zigzag encoded integer, as i16, i32 and i64 values are
+func (r *thriftReader) readVarint() (int64, error) {
+	u, err := r.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	return int64(u>>1) ^ -int64(u&1), nil
+}
+
+func (r *thriftReader) readI32() (int32, error) {
+	v, err := r.readVarint()
+	return int32(v), err
+}
+
+func (r *thriftReader) readBinary() ([]byte, error) {
+	n, err := r.readUvarint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.buf)-r.pos) {
+		return nil, errTruncated
+	}
+	b := r.buf[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+func (r *thriftReader) readString() (string, error) {
+	b, err := r.readBinary()
+	return string(b), err
+}
+
+// readStruct reads a struct, calling fn to read each field's value. Boolean
+// fields carry their value in their type, compactTrue or compactFalse.
+func (r *thriftReader) readStruct(fn func(id int16, typ byte) error) error {
+	var last int16
+	for {
+		b, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		if b == compactStop {
+			return nil
+		}
+
+		typ := b & 0x0f
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			v, err := r.readVarint()
+			if err != nil {
+				return err
+			}
+			id = int16(v)
+		}
+		last = id
+
+		if err := fn(id, typ); err != nil {
+			return err
+		}
+	}
+}
+
+// readList reads a list or set, calling fn to read each element
+func (r *thriftReader) readList(fn func(typ byte) error) error {
+	b, err := r.readByte()
+	if err != nil {
+		return err
+	}
+
+	size := uint64(b >> 4)
+	typ := b & 0x0f
+	if size == 15 {
+		if size, err = r.readUvarint(); err != nil {
+			return err
+		}
+	}
+	// Every element takes at least a byte
+	if size > uint64(len(r.buf)-r.pos) {
+		return errTruncated
+	}
+
+	for i := uint64(0); i < size; i++ {
+		if err := fn(typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *thriftReader) readI32List() ([]int32, error) {
+	var values []int32
+	err := r.readList(func(typ byte) error {
+		v, err := r.readI32()
+		values = append(values, v)
+		return err
+	})
+	return values, err
+}
+
+func (r *thriftReader) readStringList() ([]string, error) {
+	var values []string
+	err := r.readList(func(typ byte) error {
+		v, err := r.readString()
+		values = append(values, v)
+		return err
+	})
+	return values, err
+}
+
+// skip skips a field's value
+func (r *thriftReader) skip(typ byte) error {
+	switch typ {
+	case compactTrue, compactFalse:
+		return nil
+	case compactByte:
+		_, err := r.readByte()
+		return err
+	case compactI16, compactI32, compactI64:
+		_, err := r.readUvarint()
+		return err
+	case compactDouble:
+		if len(r.buf)-r.pos < 8 {
+			return errTruncated
+		}
+		r.pos += 8
+		return nil
+	case compactBinary:
+		_, err := r.readBinary()
+		return err
+	case compactList, compactSet:
+		return r.readList(r.skipElement)
+	case compactMap:
+		size, err := r.readUvarint()
+		if err != nil || size == 0 {
+			return err
+		}
+		types, err := r.readByte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < size; i++ {
+			if err := r.skipElement(types >> 4); err != nil {
+				return err
+			}
+			if err := r.skipElement(types & 0x0f); err != nil {
+				return err
+			}
+		}
+		return nil
+	case compactStruct:
+		return r.readStruct(func(_ int16, typ byte) error {
+			return r.skip(typ)
+		})
+	default:
+		return fmt.Errorf("unknown thrift type %d", typ)
+	}
+}
+
+// skipElement skips a list, set or map element. Unlike boolean fields, boolean
+// elements are stored in a byte.
+func (r *thriftReader) skipElement(typ byte) error {
+	if typ == compactTrue || typ == compactFalse {
+		_, err := r.readByte()
+		return err
+	}
+	return r.skip(typ)
+}
@@ -0,0 +1,186 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+)
+
+// IngestionBatchRepository handles ingestion batch operations
+type IngestionBatchRepository struct {
+	*BaseRepository
+}
+
+// NewIngestionBatchRepository creates a new ingestion batch repository
+func NewIngestionBatchRepository(db *DB) *IngestionBatchRepository {
+	return &IngestionBatchRepository{
+		BaseRepository: NewBaseRepository(db.pool, db.sb),
+	}
+}
+
+// NewIngestionBatchRepositoryWithTx creates a new ingestion batch repository with a transaction
+func NewIngestionBatchRepositoryWithTx(tx pgx.Tx, sb squirrel.StatementBuilderType) *IngestionBatchRepository {
+	return &IngestionBatchRepository{
+		BaseRepository: NewBaseRepository(tx, sb),
+	}
+}
+
+var batchColumns = []string{
+	"id", "source", "billing_period_start", "billing_period_end", "checksum", "file_count",
+	"records_processed", "records_inserted", "records_skipped", "records_replaced",
+	"superseded_by", "created_at",
+}
+
+// LockPeriod takes a lock on a source's billing period until the transaction
+// ends, so concurrent imports of the same period replace one another in turn.
+// It must be called within a transaction.
+func (r *IngestionBatchRepository) LockPeriod(ctx context.Context, source string, periodStart time.Time) error {
+	key := fmt.Sprintf("ingestion_batch:%s:%s", source, periodStart.Format("2006-01-02"))
+	if _, err := r.DB().Exec(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", key); err != nil {
+		return fmt.Errorf("failed to lock billing period: %w", err)
+	}
+	return nil
+}
+
+// Create records a new batch
+func (r *IngestionBatchRepository) Create(ctx context.Context, batch *models.IngestionBatch) error {
+	if batch.ID == uuid.Nil {
+		batch.ID = uuid.New()
+	}
+
+	query := r.QueryBuilder().
+		Insert("ingestion_batches").
+		Columns("id", "source", "billing_period_start", "billing_period_end", "checksum", "file_count").
+		Values(batch.ID, batch.Source, batch.BillingPeriodStart, batch.BillingPeriodEnd, batch.Checksum, batch.FileCount).
+		Suffix("RETURNING created_at")
+
+	if err := r.QueryRow(ctx, query).Scan(&batch.CreatedAt); err != nil {
+		return fmt.Errorf("failed to create ingestion batch: %w", err)
+	}
+	return nil
+}
+
+// GetByChecksum retrieves the current batch of a source with a checksum, or nil
+// when there is none. Superseded batches are ignored, so a report re-imported
+// after another replaced it is recorded again.
+func (r *IngestionBatchRepository) GetByChecksum(ctx context.Context, source, checksum string) (*models.IngestionBatch, error) {
+	query := r.QueryBuilder().
+		Select(batchColumns...).
+		From("ingestion_batches").
+		Where(squirrel.Eq{"source": source, "checksum": checksum, "superseded_by": nil}).
+		OrderBy("created_at DESC").
+		Limit(1)
+
+	batch, err := scanBatch(r.QueryRow(ctx, query))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ingestion batch by checksum: %w", err)
+	}
+	return batch, nil
+}
+
+// List retrieves the batches of a source, newest first. An empty source lists
+// the batches of every source.
+func (r *IngestionBatchRepository) List(ctx context.Context, source string, limit int) ([]models.IngestionBatch, error) {
+	query := r.QueryBuilder().
+		Select(batchColumns...).
+		From("ingestion_batches").
+		OrderBy("created_at DESC")
+	if source != "" {
+		query = query.Where(squirrel.Eq{"source": source})
+	}
+	if limit > 0 {
+		query = query.Limit(uint64(limit))
+	}
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ingestion batches: %w", err)
+	}
+	defer rows.Close()
+
+	var batches []models.IngestionBatch
+	for rows.Next() {
+		batch, err := scanBatch(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan ingestion batch: %w", err)
+		}
+		batches = append(batches, *batch)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating ingestion batches: %w", err)
+	}
+	return batches, nil
+}
+
+// ReplacePrevious supersedes the earlier batches of a batch's source and
+// billing period and deletes the costs they wrote, returning how many were
+// deleted. Costs since overwritten by other imports no longer belong to the
+// batches and are kept.
+func (r *IngestionBatchRepository) ReplacePrevious(ctx context.Context, batch *models.IngestionBatch) (int, error) {
+	tag, err := r.DB().Exec(ctx, `
+		WITH previous AS (
+			UPDATE ingestion_batches
+			SET superseded_by = $1
+			WHERE source = $2
+				AND billing_period_start = $3
+				AND id <> $1
+				AND superseded_by IS NULL
+			RETURNING id
+		)
+		DELETE FROM node_costs_by_dimension
+		WHERE batch_id IN (SELECT id FROM previous)`,
+		batch.ID, batch.Source, batch.BillingPeriodStart)
+	if err != nil {
+		return 0, fmt.Errorf("failed to replace previous ingestion batches: %w", err)
+	}
+
+	batch.RecordsReplaced = int(tag.RowsAffected())
+	return batch.RecordsReplaced, nil
+}
+
+// Complete records a batch's record counts
+func (r *IngestionBatchRepository) Complete(ctx context.Context, batch *models.IngestionBatch) error {
+	query := r.QueryBuilder().
+		Update("ingestion_batches").
+		Set("records_processed", batch.RecordsProcessed).
+		Set("records_inserted", batch.RecordsInserted).
+		Set("records_skipped", batch.RecordsSkipped).
+		Set("records_replaced", batch.RecordsReplaced).
+		Where(squirrel.Eq{"id": batch.ID})
+
+	if _, err := r.ExecQuery(ctx, query); err != nil {
+		return fmt.Errorf("failed to complete ingestion batch: %w", err)
+	}
+	return nil
+}
+
+func scanBatch(row pgx.Row) (*models.IngestionBatch, error) {
+	var batch models.IngestionBatch
+	err := row.Scan(
+		&batch.ID,
+		&batch.Source,
+		&batch.BillingPeriodStart,
+		&batch.BillingPeriodEnd,
+		&batch.Checksum,
+		&batch.FileCount,
+		&batch.RecordsProcessed,
+		&batch.RecordsInserted,
+		&batch.RecordsSkipped,
+		&batch.RecordsReplaced,
+		&batch.SupersededBy,
+		&batch.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
@@ -476,7 +476,7 @@ func (r *CostRepository) ListNodesWithCosts(ctx context.Context, startDate, endD
 	// Build query with optional type filter
 	queryBuilder := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $2 AND window_end >= $1
 			  AND status = 'completed'
@@ -488,7 +488,7 @@ func (r *CostRepository) ListNodesWithCosts(ctx context.Context, startDate, endD
 				n.id,
 				n.name,
 				n.type,
-				SUM(a.total_amount) as total_cost,
+				SUM(fx_convert(a.total_amount, lr.currency, $3, a.allocation_date)) as total_cost,
 				$3 as currency
 			FROM cost_nodes n
 			JOIN allocation_results_by_dimension a ON n.id = a.node_id
@@ -557,7 +557,7 @@ func (r *CostRepository) GetCostsByType(ctx context.Context, startDate, endDate
 	// Query to aggregate costs by node type from the most recent computation run
 	query := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $2 AND window_end >= $1
 			  AND status = 'completed'
@@ -567,9 +567,9 @@ func (r *CostRepository) GetCostsByType(ctx context.Context, startDate, endDate
 		type_costs AS (
 			SELECT
 				n.type,
-				SUM(a.direct_amount) as direct_cost,
-				SUM(a.indirect_amount) as indirect_cost,
-				SUM(a.total_amount) as total_cost,
+				SUM(fx_convert(a.direct_amount, lr.currency, $3, a.allocation_date)) as direct_cost,
+				SUM(fx_convert(a.indirect_amount, lr.currency, $3, a.allocation_date)) as indirect_cost,
+				SUM(fx_convert(a.total_amount, lr.currency, $3, a.allocation_date)) as total_cost,
 				COUNT(DISTINCT n.id) as node_count,
 				$3 as currency
 			FROM cost_nodes n
@@ -630,7 +630,7 @@ func (r *CostRepository) GetCostsByDimension(ctx context.Context, startDate, end
 	// This aggregates by metadata/labels - for now, we'll aggregate by cost_labels
 	query := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $2 AND window_end >= $1
 			  AND status = 'completed'
@@ -641,7 +641,7 @@ func (r *CostRepository) GetCostsByDimension(ctx context.Context, startDate, end
 			SELECT
 				$4 as dimension_key,
 				COALESCE(n.cost_labels->>$4, 'untagged') as dimension_value,
-				SUM(a.total_amount) as total_cost,
+				SUM(fx_convert(a.total_amount, lr.currency, $3, a.allocation_date)) as total_cost,
 				COUNT(DISTINCT n.id) as node_count,
 				$3 as currency
 			FROM cost_nodes n
@@ -830,8 +830,20 @@ func (r *CostRepository) Delete(ctx context.Context, nodeID uuid.UUID, startDate
 	return nil
 }
 
-// BulkUpsert efficiently inserts or updates multiple cost records
+// BulkUpsert efficiently inserts or updates multiple cost records. Costs it
+// overwrites no longer belong to the ingestion batch that wrote them.
 func (r *CostRepository) BulkUpsert(ctx context.Context, costs []models.NodeCostByDimension) error {
+	return r.bulkUpsert(ctx, costs, nil)
+}
+
+// BulkUpsertBatch inserts or updates multiple cost records as part of an
+// ingestion batch, so a later import of the batch's billing period can
+// replace them
+func (r *CostRepository) BulkUpsertBatch(ctx context.Context, batchID uuid.UUID, costs []models.NodeCostByDimension) error {
+	return r.bulkUpsert(ctx, costs, &batchID)
+}
+
+func (r *CostRepository) bulkUpsert(ctx context.Context, costs []models.NodeCostByDimension, batchID *uuid.UUID) error {
 	if len(costs) == 0 {
 		return nil
 	}
@@ -856,7 +868,7 @@ func (r *CostRepository) BulkUpsert(ctx context.Context, costs []models.NodeCost
 
 	query := r.QueryBuilder().
 		Insert("node_costs_by_dimension").
-		Columns("node_id", "cost_date", "dimension", "amount", "currency", "metadata")
+		Columns("node_id", "cost_date", "dimension", "amount", "currency", "metadata", "batch_id")
 
 	for _, cost := range costs {
 		metadataJSON, err := json.Marshal(cost.Metadata)
@@ -864,7 +876,7 @@ func (r *CostRepository) BulkUpsert(ctx context.Context, costs []models.NodeCost
 			return fmt.Errorf("failed to marshal metadata: %w", err)
 		}
 
-		query = query.Values(cost.NodeID, cost.CostDate, cost.Dimension, cost.Amount, cost.Currency, metadataJSON)
+		query = query.Values(cost.NodeID, cost.CostDate, cost.Dimension, cost.Amount, cost.Currency, metadataJSON, batchID)
 	}
 
 	query = query.Suffix(`ON CONFLICT (node_id, cost_date, dimension)
@@ -872,6 +884,7 @@ func (r *CostRepository) BulkUpsert(ctx context.Context, costs []models.NodeCost
 			amount = EXCLUDED.amount,
 			currency = EXCLUDED.currency,
 			metadata = EXCLUDED.metadata,
+			batch_id = EXCLUDED.batch_id,
 			updated_at = now()`)
 
 	_, err := r.ExecQuery(ctx, query)
@@ -972,21 +985,21 @@ type OptimizationInsight struct {
 func (r *CostRepository) GetTotalCostByDateRange(ctx context.Context, startDate, endDate time.Time, currency string) (decimal.Decimal, error) {
 	query := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $2 AND window_end >= $1
 			  AND status = 'completed'
 			ORDER BY created_at DESC
 			LIMIT 1
 		)
-		SELECT COALESCE(SUM(total_amount), 0) as total
+		SELECT COALESCE(SUM(fx_convert(a.total_amount, lr.currency, $3, a.allocation_date)), 0) as total
 		FROM allocation_results_by_dimension a
 		JOIN latest_run lr ON a.run_id = lr.id
 		WHERE a.allocation_date >= $1
 		  AND a.allocation_date <= $2
 	`
 
-	row := r.db.QueryRow(ctx, query, startDate, endDate)
+	row := r.db.QueryRow(ctx, query, startDate, endDate, currency)
 
 	var total decimal.Decimal
 	if err := row.Scan(&total); err != nil {
@@ -1000,11 +1013,10 @@ func (r *CostRepository) GetTotalCostByDateRange(ctx context.Context, startDate,
 // Uses node_costs_by_dimension which contains the ingested pre-allocation spend
 func (r *CostRepository) GetRawTotalCostByDateRange(ctx context.Context, startDate, endDate time.Time, currency string) (decimal.Decimal, error) {
 	query := `
-			SELECT COALESCE(SUM(amount), 0) as total
+			SELECT COALESCE(SUM(fx_convert(amount, currency, $3, cost_date)), 0) as total
 			FROM node_costs_by_dimension
 			WHERE cost_date >= $1
 			  AND cost_date <= $2
-			  AND currency = $3
 	`
 
 	row := r.db.QueryRow(ctx, query, startDate, endDate, currency)
@@ -1021,12 +1033,11 @@ func (r *CostRepository) GetRawTotalCostByDateRange(ctx context.Context, startDa
 // This represents the "raw infrastructure cost" available for allocation to products
 func (r *CostRepository) GetPlatformAndSharedTotalCostByDateRange(ctx context.Context, startDate, endDate time.Time, currency string) (decimal.Decimal, error) {
 	query := `
-			SELECT COALESCE(SUM(c.amount), 0) as total
+			SELECT COALESCE(SUM(fx_convert(c.amount, c.currency, $3, c.cost_date)), 0) as total
 			FROM node_costs_by_dimension c
 			JOIN cost_nodes n ON c.node_id = n.id
 			WHERE c.cost_date >= $1
 			  AND c.cost_date <= $2
-			  AND c.currency = $3
 			  AND (n.is_platform = true OR n.type = 'shared')
 			  AND n.archived_at IS NULL
 	`
@@ -1047,12 +1058,11 @@ func (r *CostRepository) GetPlatformAndSharedTotalCostByDateRange(ctx context.Co
 // the services that are allocated into products.
 func (r *CostRepository) GetTotalInfrastructureCostByDateRange(ctx context.Context, startDate, endDate time.Time, currency string) (decimal.Decimal, error) {
 	query := `
-			SELECT COALESCE(SUM(c.amount), 0) as total
+			SELECT COALESCE(SUM(fx_convert(c.amount, c.currency, $3, c.cost_date)), 0) as total
 			FROM node_costs_by_dimension c
 			JOIN cost_nodes n ON c.node_id = n.id
 			WHERE c.cost_date >= $1
 			  AND c.cost_date <= $2
-			  AND c.currency = $3
 			  AND (n.type IN ('resource', 'shared', 'platform', 'infrastructure') OR n.is_platform = true)
 			  AND n.archived_at IS NULL
 	`
@@ -1071,17 +1081,22 @@ func (r *CostRepository) GetTotalInfrastructureCostByDateRange(ctx context.Conte
 // GetAllocatedCostsByNodeAndDateRange retrieves allocated costs for a node from allocation results
 // This uses the computed allocation results rather than raw input costs
 // Returns direct_amount (node's own costs) in TotalAmount field
-func (r *CostRepository) GetAllocatedCostsByNodeAndDateRange(ctx context.Context, nodeID uuid.UUID, startDate, endDate time.Time) ([]models.AllocationResultByDimension, error) {
+// Amounts are converted to the currency at the rate on each allocation date
+func (r *CostRepository) GetAllocatedCostsByNodeAndDateRange(ctx context.Context, nodeID uuid.UUID, startDate, endDate time.Time, currency string) ([]models.AllocationResultByDimension, error) {
 	query := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $3 AND window_end >= $2
 			  AND status = 'completed'
 			ORDER BY created_at DESC
 			LIMIT 1
 		)
-		SELECT a.run_id, a.node_id, a.allocation_date, a.dimension, a.direct_amount, a.indirect_amount, a.total_amount, a.created_at, a.updated_at
+		SELECT a.run_id, a.node_id, a.allocation_date, a.dimension,
+			fx_convert(a.direct_amount, lr.currency, $4, a.allocation_date),
+			fx_convert(a.indirect_amount, lr.currency, $4, a.allocation_date),
+			fx_convert(a.total_amount, lr.currency, $4, a.allocation_date),
+			a.created_at, a.updated_at
 		FROM allocation_results_by_dimension a
 		JOIN latest_run lr ON a.run_id = lr.id
 		WHERE a.node_id = $1
@@ -1090,7 +1105,7 @@ func (r *CostRepository) GetAllocatedCostsByNodeAndDateRange(ctx context.Context
 		ORDER BY a.allocation_date, a.dimension
 	`
 
-	rows, err := r.db.Query(ctx, query, nodeID, startDate, endDate)
+	rows, err := r.db.Query(ctx, query, nodeID, startDate, endDate, currency)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get allocated costs: %w", err)
 	}
@@ -1159,11 +1174,12 @@ type RawCostRecord struct {
 }
 
 // GetAllocationsFromNode retrieves all allocations FROM a specific node TO its children
-// This shows how much cost has been allocated from this infrastructure node to products
-func (r *CostRepository) GetAllocationsFromNode(ctx context.Context, nodeID uuid.UUID, startDate, endDate time.Time) ([]AllocationFromNode, error) {
+// This shows how much cost has been allocated from this infrastructure node to products,
+// converted to the currency at the rate on each contribution date
+func (r *CostRepository) GetAllocationsFromNode(ctx context.Context, nodeID uuid.UUID, startDate, endDate time.Time, currency string) ([]AllocationFromNode, error) {
 	query := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $2 AND window_end >= $3
 			  AND status = 'completed'
@@ -1173,7 +1189,7 @@ func (r *CostRepository) GetAllocationsFromNode(ctx context.Context, nodeID uuid
 		SELECT
 			c.parent_id,
 			c.child_id,
-			c.contributed_amount,
+			fx_convert(c.contributed_amount, lr.currency, $4, c.contribution_date),
 			c.dimension,
 			COALESCE(e.default_strategy, 'unknown') as strategy,
 			c.contribution_date
@@ -1186,7 +1202,7 @@ func (r *CostRepository) GetAllocationsFromNode(ctx context.Context, nodeID uuid
 		ORDER BY c.contribution_date, c.child_id, c.dimension
 	`
 
-	rows, err := r.db.Query(ctx, query, nodeID, startDate, endDate)
+	rows, err := r.db.Query(ctx, query, nodeID, startDate, endDate, currency)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get allocations from node: %w", err)
 	}
@@ -1221,7 +1237,7 @@ func (r *CostRepository) GetDetailedCostRecords(ctx context.Context, startDate,
 	// Query to get detailed allocation results with node information
 	queryBuilder := `
 		WITH latest_run AS (
-			SELECT id
+			SELECT id, currency
 			FROM computation_runs
 			WHERE window_start <= $2 AND window_end >= $1
 			  AND status = 'completed'
@@ -1234,9 +1250,9 @@ func (r *CostRepository) GetDetailedCostRecords(ctx context.Context, startDate,
 			n.type,
 			a.allocation_date,
 			a.dimension,
-			a.direct_amount,
-			a.indirect_amount,
-			a.total_amount,
+			fx_convert(a.direct_amount, lr.currency, $3, a.allocation_date),
+			fx_convert(a.indirect_amount, lr.currency, $3, a.allocation_date),
+			fx_convert(a.total_amount, lr.currency, $3, a.allocation_date),
 			$3 as currency
 		FROM cost_nodes n
 		JOIN allocation_results_by_dimension a ON n.id = a.node_id
@@ -1299,14 +1315,13 @@ func (r *CostRepository) GetRawCostRecords(ctx context.Context, startDate, endDa
 			n.type,
 			c.cost_date,
 			c.dimension,
-			c.amount,
-			c.currency,
+			fx_convert(c.amount, c.currency, $3, c.cost_date),
+			$3 as currency,
 			c.metadata
 		FROM cost_nodes n
 		JOIN node_costs_by_dimension c ON n.id = c.node_id
 		WHERE c.cost_date >= $1
-		  AND c.cost_date <= $2
-		  AND c.currency = $3`
+		  AND c.cost_date <= $2`
 
 	args := []interface{}{startDate, endDate, currency}
 	argIndex := 4
@@ -1394,19 +1409,20 @@ func (r *CostRepository) GetProductHierarchyRecords(ctx context.Context, startDa
 			p.type as product_type,
 			a.allocation_date,
 			a.dimension,
-			a.direct_amount,
-			a.indirect_amount,
-			a.total_amount,
-			COALESCE(ssc.shared_service_amount, 0) as shared_service_cost,
+			fx_convert(a.direct_amount, run.currency, $3, a.allocation_date),
+			fx_convert(a.indirect_amount, run.currency, $3, a.allocation_date),
+			fx_convert(a.total_amount, run.currency, $3, a.allocation_date),
+			fx_convert(COALESCE(ssc.shared_service_amount, 0), run.currency, $3, a.allocation_date) as shared_service_cost,
 			$3 as currency,
 			c.child_id as downstream_node_id,
 			cn.name as downstream_node_name,
 			cn.type as downstream_node_type,
-			c.contributed_amount,
+			fx_convert(c.contributed_amount, run.currency, $3, a.allocation_date),
 			COALESCE(e.default_strategy, 'unknown') as allocation_strategy,
 			p.metadata as product_metadata
 		FROM cost_nodes p
 		JOIN allocation_results_by_dimension a ON p.id = a.node_id
+		JOIN computation_runs run ON a.run_id = run.id
 		LEFT JOIN contribution_results_by_dimension c ON (
 			p.id = c.parent_id
 			AND a.allocation_date = c.contribution_date
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/Masterminds/squirrel"
@@ -12,6 +13,10 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
+// ErrNotFound is wrapped by the errors repositories return for a node or
+// computation run that does not exist
+var ErrNotFound = errors.New("not found")
+
 // DB wraps the database connection and provides query building
 type DB struct {
 	pool *pgxpool.Pool
@@ -88,23 +93,27 @@ func (db *DB) WithTx(ctx context.Context, fn func(pgx.Tx) error) error {
 
 // Store provides access to all repositories
 type Store struct {
-	db    *DB
-	Nodes *NodeRepository
-	Edges *EdgeRepository
-	Costs *CostRepository
-	Usage *UsageRepository
-	Runs  *RunRepository
+	db      *DB
+	Nodes   *NodeRepository
+	Edges   *EdgeRepository
+	Costs   *CostRepository
+	Usage   *UsageRepository
+	Runs    *RunRepository
+	FX      *FXRateRepository
+	Batches *IngestionBatchRepository
 }
 
 // NewStore creates a new store with all repositories
 func NewStore(db *DB) *Store {
 	return &Store{
-		db:    db,
-		Nodes: NewNodeRepository(db),
-		Edges: NewEdgeRepository(db),
-		Costs: NewCostRepository(db),
-		Usage: NewUsageRepository(db),
-		Runs:  NewRunRepository(db),
+		db:      db,
+		Nodes:   NewNodeRepository(db),
+		Edges:   NewEdgeRepository(db),
+		Costs:   NewCostRepository(db),
+		Usage:   NewUsageRepository(db),
+		Runs:    NewRunRepository(db),
+		FX:      NewFXRateRepository(db),
+		Batches: NewIngestionBatchRepository(db),
 	}
 }
 
@@ -117,12 +126,14 @@ func (s *Store) DB() *DB {
 func (s *Store) WithTx(ctx context.Context, fn func(*Store) error) error {
 	return s.db.WithTx(ctx, func(tx pgx.Tx) error {
 		txStore := &Store{
-			db:    &DB{pool: nil, sb: s.db.sb}, // We'll use tx directly
-			Nodes: NewNodeRepositoryWithTx(tx, s.db.sb),
-			Edges: NewEdgeRepositoryWithTx(tx, s.db.sb),
-			Costs: NewCostRepositoryWithTx(tx, s.db.sb),
-			Usage: NewUsageRepositoryWithTx(tx, s.db.sb),
-			Runs:  NewRunRepositoryWithTx(tx, s.db.sb),
+			db:      &DB{pool: nil, sb: s.db.sb}, // We'll use tx directly
+			Nodes:   NewNodeRepositoryWithTx(tx, s.db.sb),
+			Edges:   NewEdgeRepositoryWithTx(tx, s.db.sb),
+			Costs:   NewCostRepositoryWithTx(tx, s.db.sb),
+			Usage:   NewUsageRepositoryWithTx(tx, s.db.sb),
+			Runs:    NewRunRepositoryWithTx(tx, s.db.sb),
+			FX:      NewFXRateRepositoryWithTx(tx, s.db.sb),
+			Batches: NewIngestionBatchRepositoryWithTx(tx, s.db.sb),
 		}
 		return fn(txStore)
 	})
@@ -133,6 +144,7 @@ type Queryable interface {
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
 }
 
 // BaseRepository provides common functionality for all repositories
@@ -205,3 +217,14 @@ func (r *BaseRepository) QueryRow(ctx context.Context, query squirrel.Sqlizer) p
 
 	return r.db.QueryRow(ctx, sql, args...)
 }
+
+// CopyRows bulk loads rows into a table using the COPY protocol
+func (r *BaseRepository) CopyRows(ctx context.Context, table string, columns []string, rows [][]interface{}) (int64, error) {
+	log.Debug().
+		Str("table", table).
+		Strs("columns", columns).
+		Int("rows", len(rows)).
+		Msg("Copying rows")
+
+	return r.db.CopyFrom(ctx, pgx.Identifier{table}, columns, pgx.CopyFromRows(rows))
+}
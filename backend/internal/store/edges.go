@@ -354,6 +354,57 @@ func (r *EdgeRepository) GetStrategiesForEdge(ctx context.Context, edgeID uuid.U
 	return strategies, nil
 }
 
+// GetStrategiesForEdges retrieves the dimension-specific strategies for a set of edges,
+// keyed by edge ID
+func (r *EdgeRepository) GetStrategiesForEdges(ctx context.Context, edgeIDs []uuid.UUID) (map[uuid.UUID][]models.EdgeStrategy, error) {
+	result := make(map[uuid.UUID][]models.EdgeStrategy)
+	if len(edgeIDs) == 0 {
+		return result, nil
+	}
+
+	query := r.QueryBuilder().
+		Select("id", "edge_id", "dimension", "strategy", "parameters", "created_at", "updated_at").
+		From("edge_strategies").
+		Where(squirrel.Eq{"edge_id": edgeIDs}).
+		OrderBy("edge_id, dimension")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get edge strategies: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var strategy models.EdgeStrategy
+		var parametersJSON []byte
+
+		err := rows.Scan(
+			&strategy.ID,
+			&strategy.EdgeID,
+			&strategy.Dimension,
+			&strategy.Strategy,
+			&parametersJSON,
+			&strategy.CreatedAt,
+			&strategy.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan edge strategy: %w", err)
+		}
+
+		if err := json.Unmarshal(parametersJSON, &strategy.Parameters); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal strategy parameters: %w", err)
+		}
+
+		result[strategy.EdgeID] = append(result[strategy.EdgeID], strategy)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating edge strategies: %w", err)
+	}
+
+	return result, nil
+}
+
 // CreateStrategy creates a new edge strategy
 func (r *EdgeRepository) CreateStrategy(ctx context.Context, strategy *models.EdgeStrategy) error {
 	if strategy.ID == uuid.Nil {
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+)
+
+// FXRateRepository handles foreign exchange rate operations
+type FXRateRepository struct {
+	*BaseRepository
+}
+
+// NewFXRateRepository creates a new FX rate repository
+func NewFXRateRepository(db *DB) *FXRateRepository {
+	return &FXRateRepository{
+		BaseRepository: NewBaseRepository(db.pool, db.sb),
+	}
+}
+
+// NewFXRateRepositoryWithTx creates a new FX rate repository with a transaction
+func NewFXRateRepositoryWithTx(tx pgx.Tx, sb squirrel.StatementBuilderType) *FXRateRepository {
+	return &FXRateRepository{
+		BaseRepository: NewBaseRepository(tx, sb),
+	}
+}
+
+// BulkUpsert inserts or updates multiple FX rates
+func (r *FXRateRepository) BulkUpsert(ctx context.Context, rates []models.FXRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	// Sort by primary key (from_currency, to_currency, rate_date) to prevent deadlocks
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].FromCurrency != rates[j].FromCurrency {
+			return rates[i].FromCurrency < rates[j].FromCurrency
+		}
+		if rates[i].ToCurrency != rates[j].ToCurrency {
+			return rates[i].ToCurrency < rates[j].ToCurrency
+		}
+		return rates[i].RateDate.Before(rates[j].RateDate)
+	})
+
+	query := r.QueryBuilder().
+		Insert("fx_rates").
+		Columns("rate_date", "from_currency", "to_currency", "rate", "source")
+
+	for _, rate := range rates {
+		query = query.Values(rate.RateDate, rate.FromCurrency, rate.ToCurrency, rate.Rate, rate.Source)
+	}
+
+	query = query.Suffix(`ON CONFLICT (from_currency, to_currency, rate_date)
+		DO UPDATE SET
+			rate = EXCLUDED.rate,
+			source = EXCLUDED.source,
+			updated_at = now()`)
+
+	_, err := r.ExecQuery(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to bulk upsert FX rates: %w", err)
+	}
+
+	return nil
+}
+
+// GetForDateRange retrieves the FX rates needed to convert amounts dated within a
+// range: every rate in the range and each pair's latest rate before it
+func (r *FXRateRepository) GetForDateRange(ctx context.Context, startDate, endDate time.Time) ([]models.FXRate, error) {
+	query := `
+		SELECT rate_date, from_currency, to_currency, rate, source, created_at, updated_at
+		FROM fx_rates
+		WHERE rate_date >= $1 AND rate_date <= $2
+		UNION ALL
+		SELECT * FROM (
+			SELECT DISTINCT ON (from_currency, to_currency)
+				rate_date, from_currency, to_currency, rate, source, created_at, updated_at
+			FROM fx_rates
+			WHERE rate_date < $1
+			ORDER BY from_currency, to_currency, rate_date DESC
+		) earlier
+		ORDER BY from_currency, to_currency, rate_date`
+
+	rows, err := r.db.Query(ctx, query, startDate, endDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get FX rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []models.FXRate
+	for rows.Next() {
+		var rate models.FXRate
+
+		err := rows.Scan(
+			&rate.RateDate,
+			&rate.FromCurrency,
+			&rate.ToCurrency,
+			&rate.Rate,
+			&rate.Source,
+			&rate.CreatedAt,
+			&rate.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan FX rate: %w", err)
+		}
+
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating FX rates: %w", err)
+	}
+
+	return rates, nil
+}
@@ -85,7 +85,7 @@ func (r *NodeRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Cos
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("node not found: %s", id)
+			return nil, fmt.Errorf("node %s: %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get node: %w", err)
 	}
@@ -127,7 +127,7 @@ func (r *NodeRepository) GetByName(ctx context.Context, name string) (*models.Co
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("node not found: %s", name)
+			return nil, fmt.Errorf("node %s: %w", name, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get node: %w", err)
 	}
@@ -152,10 +152,15 @@ func (r *NodeRepository) List(ctx context.Context, filters NodeFilters) ([]model
 	// Apply filters
 	if filters.Type != "" {
 		query = query.Where(squirrel.Eq{"type": filters.Type})
+	} else if !filters.IncludeUnallocated {
+		query = query.Where(squirrel.NotEq{"type": string(models.NodeTypeUnallocated)})
 	}
 	if filters.IsPlatform != nil {
 		query = query.Where(squirrel.Eq{"is_platform": *filters.IsPlatform})
 	}
+	if len(filters.IDs) > 0 {
+		query = query.Where(squirrel.Eq{"id": filters.IDs})
+	}
 	if !filters.IncludeArchived {
 		query = query.Where(squirrel.Eq{"archived_at": nil})
 	}
@@ -240,7 +245,7 @@ func (r *NodeRepository) Update(ctx context.Context, node *models.CostNode) erro
 	row := r.QueryRow(ctx, query)
 	if err := row.Scan(&node.UpdatedAt); err != nil {
 		if err == pgx.ErrNoRows {
-			return fmt.Errorf("node not found: %s", node.ID)
+			return fmt.Errorf("node %s: %w", node.ID, ErrNotFound)
 		}
 		return fmt.Errorf("failed to update node: %w", err)
 	}
@@ -273,6 +278,11 @@ type NodeFilters struct {
 	Type            string
 	IsPlatform      *bool
 	IncludeArchived bool
-	Limit           int
-	Offset          int
+	// IDs limits the nodes to those with these IDs
+	IDs []uuid.UUID
+	// IncludeUnallocated lists the synthetic per-run unallocated nodes, which are
+	// otherwise left out unless Type asks for them
+	IncludeUnallocated bool
+	Limit              int
+	Offset             int
 }
@@ -2,13 +2,16 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/shopspring/decimal"
 )
 
 // RunRepository handles computation run operations
@@ -38,12 +41,12 @@ func (r *RunRepository) Create(ctx context.Context, run *models.ComputationRun)
 
 	query := r.QueryBuilder().
 		Insert("computation_runs").
-		Columns("id", "window_start", "window_end", "graph_hash", "status", "notes").
-		Values(run.ID, run.WindowStart, run.WindowEnd, run.GraphHash, run.Status, run.Notes).
-		Suffix("RETURNING created_at, updated_at")
+		Columns("id", "window_start", "window_end", "graph_hash", "status", "notes", "unallocated_node_id", "dimensions", "heartbeat_at", "currency").
+		Values(run.ID, run.WindowStart, run.WindowEnd, run.GraphHash, run.Status, run.Notes, run.UnallocatedNodeID, run.Dimensions, squirrel.Expr("now()"), run.Currency).
+		Suffix("RETURNING created_at, updated_at, heartbeat_at")
 
 	row := r.QueryRow(ctx, query)
-	if err := row.Scan(&run.CreatedAt, &run.UpdatedAt); err != nil {
+	if err := row.Scan(&run.CreatedAt, &run.UpdatedAt, &run.HeartbeatAt); err != nil {
 		return fmt.Errorf("failed to create computation run: %w", err)
 	}
 
@@ -53,7 +56,7 @@ func (r *RunRepository) Create(ctx context.Context, run *models.ComputationRun)
 // GetByID retrieves a computation run by ID
 func (r *RunRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.ComputationRun, error) {
 	query := r.QueryBuilder().
-		Select("id", "created_at", "updated_at", "window_start", "window_end", "graph_hash", "status", "notes").
+		Select("id", "created_at", "updated_at", "window_start", "window_end", "graph_hash", "status", "notes", "unallocated_node_id", "dimensions", "heartbeat_at", "currency").
 		From("computation_runs").
 		Where(squirrel.Eq{"id": id})
 
@@ -69,10 +72,14 @@ func (r *RunRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Comp
 		&run.GraphHash,
 		&run.Status,
 		&run.Notes,
+		&run.UnallocatedNodeID,
+		&run.Dimensions,
+		&run.HeartbeatAt,
+		&run.Currency,
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
-			return nil, fmt.Errorf("computation run not found: %s", id)
+			return nil, fmt.Errorf("computation run %s: %w", id, ErrNotFound)
 		}
 		return nil, fmt.Errorf("failed to get computation run: %w", err)
 	}
@@ -83,7 +90,7 @@ func (r *RunRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Comp
 // List retrieves computation runs with optional filtering
 func (r *RunRepository) List(ctx context.Context, filters RunFilters) ([]models.ComputationRun, error) {
 	query := r.QueryBuilder().
-		Select("id", "created_at", "updated_at", "window_start", "window_end", "graph_hash", "status", "notes").
+		Select("id", "created_at", "updated_at", "window_start", "window_end", "graph_hash", "status", "notes", "unallocated_node_id", "dimensions", "heartbeat_at", "currency").
 		From("computation_runs")
 
 	// Apply filters
@@ -130,6 +137,10 @@ func (r *RunRepository) List(ctx context.Context, filters RunFilters) ([]models.
 			&run.GraphHash,
 			&run.Status,
 			&run.Notes,
+			&run.UnallocatedNodeID,
+			&run.Dimensions,
+			&run.HeartbeatAt,
+			&run.Currency,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan computation run: %w", err)
@@ -161,7 +172,7 @@ func (r *RunRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status s
 	var updatedAt time.Time
 	if err := row.Scan(&updatedAt); err != nil {
 		if err == pgx.ErrNoRows {
-			return fmt.Errorf("computation run not found: %s", id)
+			return fmt.Errorf("computation run %s: %w", id, ErrNotFound)
 		}
 		return fmt.Errorf("failed to update computation run status: %w", err)
 	}
@@ -169,47 +180,226 @@ func (r *RunRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status s
 	return nil
 }
 
-// Delete deletes a computation run and all associated results
+// Heartbeat records that the process running a computation is still alive and
+// returns the run's current status, so the process can notice it was cancelled
+func (r *RunRepository) Heartbeat(ctx context.Context, id uuid.UUID) (models.ComputationStatus, error) {
+	query := r.QueryBuilder().
+		Update("computation_runs").
+		Set("heartbeat_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING status")
+
+	var status string
+	if err := r.QueryRow(ctx, query).Scan(&status); err != nil {
+		if err == pgx.ErrNoRows {
+			return "", fmt.Errorf("computation run %s: %w", id, ErrNotFound)
+		}
+		return "", fmt.Errorf("failed to record computation run heartbeat: %w", err)
+	}
+
+	return models.ComputationStatus(status), nil
+}
+
+// Cancel marks a pending or running computation run as cancelled. The process
+// running it stops at its next heartbeat.
+func (r *RunRepository) Cancel(ctx context.Context, id uuid.UUID, notes *string) error {
+	query := r.QueryBuilder().
+		Update("computation_runs").
+		Set("status", string(models.ComputationStatusCancelled)).
+		Where(squirrel.Eq{
+			"id":     id,
+			"status": []string{string(models.ComputationStatusPending), string(models.ComputationStatusRunning)},
+		}).
+		Suffix("RETURNING updated_at")
+
+	if notes != nil {
+		query = query.Set("notes", *notes)
+	}
+
+	var updatedAt time.Time
+	if err := r.QueryRow(ctx, query).Scan(&updatedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("no pending or running computation run: %s", id)
+		}
+		return fmt.Errorf("failed to cancel computation run: %w", err)
+	}
+
+	return nil
+}
+
+// MarkResumed moves a failed or cancelled computation run back to running so it
+// can be resumed. Only one caller can claim a run this way.
+func (r *RunRepository) MarkResumed(ctx context.Context, id uuid.UUID) error {
+	query := r.QueryBuilder().
+		Update("computation_runs").
+		Set("status", string(models.ComputationStatusRunning)).
+		Set("heartbeat_at", squirrel.Expr("now()")).
+		Where(squirrel.Eq{
+			"id":     id,
+			"status": []string{string(models.ComputationStatusFailed), string(models.ComputationStatusCancelled)},
+		}).
+		Suffix("RETURNING updated_at")
+
+	var updatedAt time.Time
+	if err := r.QueryRow(ctx, query).Scan(&updatedAt); err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("no failed or cancelled computation run to resume: %s", id)
+		}
+		return fmt.Errorf("failed to resume computation run: %w", err)
+	}
+
+	return nil
+}
+
+// FailStaleRuns marks pending and running computation runs whose last heartbeat is
+// older than staleBefore as failed and returns their IDs. Runs created before
+// heartbeats were recorded fall back to their last update.
+func (r *RunRepository) FailStaleRuns(ctx context.Context, staleBefore time.Time) ([]uuid.UUID, error) {
+	notes := fmt.Sprintf("no heartbeat since %s; the process running it is presumed dead", staleBefore.UTC().Format(time.RFC3339))
+	query := r.QueryBuilder().
+		Update("computation_runs").
+		Set("status", string(models.ComputationStatusFailed)).
+		Set("notes", notes).
+		Where(squirrel.Eq{"status": []string{string(models.ComputationStatusPending), string(models.ComputationStatusRunning)}}).
+		Where(squirrel.Lt{"COALESCE(heartbeat_at, updated_at)": staleBefore}).
+		Suffix("RETURNING id")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fail stale computation runs: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan stale computation run: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating stale computation runs: %w", err)
+	}
+
+	return ids, nil
+}
+
+// SaveCheckpoint records that every result of a run's day has been saved
+func (r *RunRepository) SaveCheckpoint(ctx context.Context, runID uuid.UUID, date time.Time) error {
+	query := r.QueryBuilder().
+		Insert("computation_run_checkpoints").
+		Columns("run_id", "checkpoint_date").
+		Values(runID, date)
+
+	if _, err := r.ExecQuery(ctx, query); err != nil {
+		return fmt.Errorf("failed to save computation run checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// GetLastCheckpoint retrieves the latest checkpointed day of a run, or nil when no
+// day has been checkpointed
+func (r *RunRepository) GetLastCheckpoint(ctx context.Context, runID uuid.UUID) (*models.ComputationRunCheckpoint, error) {
+	query := r.QueryBuilder().
+		Select("run_id", "checkpoint_date", "completed_at").
+		From("computation_run_checkpoints").
+		Where(squirrel.Eq{"run_id": runID}).
+		OrderBy("checkpoint_date DESC").
+		Limit(1)
+
+	var checkpoint models.ComputationRunCheckpoint
+	err := r.QueryRow(ctx, query).Scan(&checkpoint.RunID, &checkpoint.CheckpointDate, &checkpoint.CompletedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get computation run checkpoint: %w", err)
+	}
+
+	return &checkpoint, nil
+}
+
+// runDayTables lists every table holding per-day results of a run, with its date column
+var runDayTables = []struct{ table, dateColumn string }{
+	{"allocation_results_by_dimension", "allocation_date"},
+	{"contribution_results_by_dimension", "contribution_date"},
+	{"contribution_lineage_by_dimension", "lineage_date"},
+	{"unallocated_results_by_dimension", "allocation_date"},
+	{"recovery_results_by_dimension", "allocation_date"},
+	{"allocation_invariant_reports", "report_date"},
+	{"allocation_day_fingerprints", "allocation_date"},
+	{"computation_run_checkpoints", "checkpoint_date"},
+}
+
+// DeleteResultsFrom deletes a run's results for fromDate and every later day. A
+// resumed run uses it to discard whatever an interrupted day saved before its
+// checkpoint was written.
+func (r *RunRepository) DeleteResultsFrom(ctx context.Context, runID uuid.UUID, fromDate time.Time) error {
+	for _, t := range runDayTables {
+		query := r.QueryBuilder().
+			Delete(t.table).
+			Where(squirrel.Eq{"run_id": runID}).
+			Where(squirrel.GtOrEq{t.dateColumn: fromDate})
+		if _, err := r.ExecQuery(ctx, query); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", t.table, err)
+		}
+	}
+
+	return nil
+}
+
+// Delete deletes a computation run, all associated results and its unallocated node
 func (r *RunRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	query := r.QueryBuilder().
 		Delete("computation_runs").
-		Where(squirrel.Eq{"id": id})
+		Where(squirrel.Eq{"id": id}).
+		Suffix("RETURNING unallocated_node_id")
 
-	tag, err := r.ExecQuery(ctx, query)
-	if err != nil {
+	var unallocatedNodeID *uuid.UUID
+	if err := r.QueryRow(ctx, query).Scan(&unallocatedNodeID); err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("computation run %s: %w", id, ErrNotFound)
+		}
 		return fmt.Errorf("failed to delete computation run: %w", err)
 	}
 
-	if tag.RowsAffected() == 0 {
-		return fmt.Errorf("computation run not found: %s", id)
+	if unallocatedNodeID != nil {
+		nodeQuery := r.QueryBuilder().
+			Delete("cost_nodes").
+			Where(squirrel.Eq{"id": *unallocatedNodeID, "type": string(models.NodeTypeUnallocated)})
+		if _, err := r.ExecQuery(ctx, nodeQuery); err != nil {
+			return fmt.Errorf("failed to delete unallocated node: %w", err)
+		}
 	}
 
 	return nil
 }
 
-// SaveAllocationResults saves allocation results for a computation run
+// SaveAllocationResults saves allocation results for a computation run using COPY
 func (r *RunRepository) SaveAllocationResults(ctx context.Context, results []models.AllocationResultByDimension) error {
 	if len(results) == 0 {
 		return nil
 	}
 
-	query := r.QueryBuilder().
-		Insert("allocation_results_by_dimension").
-		Columns("run_id", "node_id", "allocation_date", "dimension", "direct_amount", "indirect_amount", "total_amount")
-
+	rows := make([][]interface{}, 0, len(results))
 	for _, result := range results {
-		query = query.Values(
+		rows = append(rows, []interface{}{
 			result.RunID,
 			result.NodeID,
 			result.AllocationDate,
 			result.Dimension,
-			result.DirectAmount,
-			result.IndirectAmount,
-			result.TotalAmount,
-		)
+			numericValue(result.DirectAmount),
+			numericValue(result.IndirectAmount),
+			numericValue(result.TotalAmount),
+		})
 	}
 
-	_, err := r.ExecQuery(ctx, query)
+	_, err := r.CopyRows(ctx, "allocation_results_by_dimension",
+		[]string{"run_id", "node_id", "allocation_date", "dimension", "direct_amount", "indirect_amount", "total_amount"},
+		rows)
 	if err != nil {
 		return fmt.Errorf("failed to save allocation results: %w", err)
 	}
@@ -217,43 +407,38 @@ func (r *RunRepository) SaveAllocationResults(ctx context.Context, results []mod
 	return nil
 }
 
-// SaveContributionResults saves contribution results for a computation run
+// SaveContributionResults saves contribution results for a computation run using COPY
 func (r *RunRepository) SaveContributionResults(ctx context.Context, results []models.ContributionResultByDimension) error {
 	if len(results) == 0 {
 		return nil
 	}
 
-	query := r.QueryBuilder().
-		Insert("contribution_results_by_dimension").
-		Columns("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "path")
-
+	rows := make([][]interface{}, 0, len(results))
 	for _, result := range results {
-		pathJSON := "[]"
-		if len(result.Path) > 0 {
-			// Convert UUID slice to JSON array
-			pathStr := "["
-			for i, id := range result.Path {
-				if i > 0 {
-					pathStr += ","
-				}
-				pathStr += fmt.Sprintf(`"%s"`, id.String())
-			}
-			pathStr += "]"
-			pathJSON = pathStr
+		path := result.Path
+		if path == nil {
+			path = []uuid.UUID{}
+		}
+		pathJSON, err := json.Marshal(path)
+		if err != nil {
+			return fmt.Errorf("failed to marshal contribution path: %w", err)
 		}
 
-		query = query.Values(
+		rows = append(rows, []interface{}{
 			result.RunID,
 			result.ParentID,
 			result.ChildID,
 			result.ContributionDate,
 			result.Dimension,
-			result.ContributedAmount,
+			numericValue(result.ContributedAmount),
+			numericValue(result.RoundingAdjustment),
 			pathJSON,
-		)
+		})
 	}
 
-	_, err := r.ExecQuery(ctx, query)
+	_, err := r.CopyRows(ctx, "contribution_results_by_dimension",
+		[]string{"run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "rounding_adjustment", "path"},
+		rows)
 	if err != nil {
 		return fmt.Errorf("failed to save contribution results: %w", err)
 	}
@@ -261,6 +446,495 @@ func (r *RunRepository) SaveContributionResults(ctx context.Context, results []m
 	return nil
 }
 
+// SaveLineageResults saves end-to-end lineage results for a computation run using COPY
+func (r *RunRepository) SaveLineageResults(ctx context.Context, results []models.LineageResultByDimension) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(results))
+	for _, result := range results {
+		pathJSON, err := json.Marshal(result.Path)
+		if err != nil {
+			return fmt.Errorf("failed to marshal lineage path: %w", err)
+		}
+
+		rows = append(rows, []interface{}{
+			result.RunID,
+			result.OriginID,
+			result.CostCentreID,
+			result.LineageDate,
+			result.Dimension,
+			numericValue(result.Amount),
+			pathJSON,
+		})
+	}
+
+	_, err := r.CopyRows(ctx, "contribution_lineage_by_dimension",
+		[]string{"run_id", "origin_id", "cost_centre_id", "lineage_date", "dimension", "amount", "path"},
+		rows)
+	if err != nil {
+		return fmt.Errorf("failed to save lineage results: %w", err)
+	}
+
+	return nil
+}
+
+// invariantReportColumns are the columns written for an invariant report, run_id first
+var invariantReportColumns = []string{
+	"run_id", "report_date", "dimension", "direct_cost", "final_cost_centre_cost",
+	"unallocated_cost", "stranded_cost", "created_cost", "lost_cost", "rounding_residue",
+}
+
+// SaveInvariantReports saves a day's invariant reports for a computation run
+func (r *RunRepository) SaveInvariantReports(ctx context.Context, reports []models.AllocationInvariantReport) error {
+	if len(reports) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(reports))
+	for _, report := range reports {
+		rows = append(rows, []interface{}{
+			report.RunID,
+			report.ReportDate,
+			report.Dimension,
+			numericValue(report.DirectCost),
+			numericValue(report.FinalCostCentreCost),
+			numericValue(report.UnallocatedCost),
+			numericValue(report.StrandedCost),
+			numericValue(report.CreatedCost),
+			numericValue(report.LostCost),
+			numericValue(report.RoundingResidue),
+		})
+	}
+
+	if _, err := r.CopyRows(ctx, "allocation_invariant_reports", invariantReportColumns, rows); err != nil {
+		return fmt.Errorf("failed to save invariant reports: %w", err)
+	}
+
+	return nil
+}
+
+// GetInvariantReports retrieves a run's invariant reports within an inclusive date range
+func (r *RunRepository) GetInvariantReports(ctx context.Context, runID uuid.UUID, startDate, endDate time.Time) ([]models.AllocationInvariantReport, error) {
+	query := r.QueryBuilder().
+		Select(append(append([]string{}, invariantReportColumns...), "created_at")...).
+		From("allocation_invariant_reports").
+		Where(squirrel.Eq{"run_id": runID}).
+		Where(squirrel.GtOrEq{"report_date": startDate}).
+		Where(squirrel.LtOrEq{"report_date": endDate}).
+		OrderBy("report_date, dimension")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invariant reports: %w", err)
+	}
+	defer rows.Close()
+
+	var reports []models.AllocationInvariantReport
+	for rows.Next() {
+		var report models.AllocationInvariantReport
+
+		err := rows.Scan(
+			&report.RunID,
+			&report.ReportDate,
+			&report.Dimension,
+			&report.DirectCost,
+			&report.FinalCostCentreCost,
+			&report.UnallocatedCost,
+			&report.StrandedCost,
+			&report.CreatedCost,
+			&report.LostCost,
+			&report.RoundingResidue,
+			&report.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invariant report: %w", err)
+		}
+
+		reports = append(reports, report)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating invariant reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// unallocatedResultColumns are the columns written for an unallocated result, run_id first
+var unallocatedResultColumns = []string{
+	"run_id", "node_id", "allocation_date", "dimension", "reason", "amount",
+}
+
+// SaveUnallocatedResults saves the cost routed to a run's unallocated node using COPY
+func (r *RunRepository) SaveUnallocatedResults(ctx context.Context, results []models.UnallocatedResultByDimension) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, []interface{}{
+			result.RunID,
+			result.NodeID,
+			result.AllocationDate,
+			result.Dimension,
+			string(result.Reason),
+			numericValue(result.Amount),
+		})
+	}
+
+	if _, err := r.CopyRows(ctx, "unallocated_results_by_dimension", unallocatedResultColumns, rows); err != nil {
+		return fmt.Errorf("failed to save unallocated results: %w", err)
+	}
+
+	return nil
+}
+
+// UnallocatedResultFilters represents filters for unallocated result queries
+type UnallocatedResultFilters struct {
+	NodeID     uuid.UUID
+	StartDate  time.Time
+	EndDate    time.Time
+	Dimensions []string
+	Reason     models.UnallocatedReason
+}
+
+// GetUnallocatedResults retrieves the cost a run routed to its unallocated node,
+// broken down by the node it came from and the reason it could not be placed
+func (r *RunRepository) GetUnallocatedResults(ctx context.Context, runID uuid.UUID, filters UnallocatedResultFilters) ([]models.UnallocatedResultByDimension, error) {
+	query := r.QueryBuilder().
+		Select(append(append([]string{}, unallocatedResultColumns...), "created_at")...).
+		From("unallocated_results_by_dimension").
+		Where(squirrel.Eq{"run_id": runID})
+
+	if filters.NodeID != uuid.Nil {
+		query = query.Where(squirrel.Eq{"node_id": filters.NodeID})
+	}
+	if !filters.StartDate.IsZero() {
+		query = query.Where(squirrel.GtOrEq{"allocation_date": filters.StartDate})
+	}
+	if !filters.EndDate.IsZero() {
+		query = query.Where(squirrel.LtOrEq{"allocation_date": filters.EndDate})
+	}
+	if len(filters.Dimensions) > 0 {
+		query = query.Where(squirrel.Eq{"dimension": filters.Dimensions})
+	}
+	if filters.Reason != "" {
+		query = query.Where(squirrel.Eq{"reason": string(filters.Reason)})
+	}
+
+	query = query.OrderBy("node_id, allocation_date, dimension, reason")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get unallocated results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.UnallocatedResultByDimension
+	for rows.Next() {
+		var result models.UnallocatedResultByDimension
+		var reason string
+
+		err := rows.Scan(
+			&result.RunID,
+			&result.NodeID,
+			&result.AllocationDate,
+			&result.Dimension,
+			&reason,
+			&result.Amount,
+			&result.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan unallocated result: %w", err)
+		}
+		result.Reason = models.UnallocatedReason(reason)
+
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating unallocated results: %w", err)
+	}
+
+	return results, nil
+}
+
+// recoveryResultColumns are the columns written for a recovery result, run_id first
+var recoveryResultColumns = []string{
+	"run_id", "parent_id", "allocation_date", "dimension", "strategy", "sink_node_id",
+	"cost_amount", "charged_amount", "under_recovery", "over_recovery",
+}
+
+// SaveRecoveryResults saves how far rate-based strategies' charges recovered their
+// parents' cost using COPY
+func (r *RunRepository) SaveRecoveryResults(ctx context.Context, results []models.RecoveryResultByDimension) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	rows := make([][]interface{}, 0, len(results))
+	for _, result := range results {
+		rows = append(rows, []interface{}{
+			result.RunID,
+			result.ParentID,
+			result.AllocationDate,
+			result.Dimension,
+			result.Strategy,
+			result.SinkNodeID,
+			numericValue(result.CostAmount),
+			numericValue(result.ChargedAmount),
+			numericValue(result.UnderRecovery),
+			numericValue(result.OverRecovery),
+		})
+	}
+
+	if _, err := r.CopyRows(ctx, "recovery_results_by_dimension", recoveryResultColumns, rows); err != nil {
+		return fmt.Errorf("failed to save recovery results: %w", err)
+	}
+
+	return nil
+}
+
+// GetRecoveryResults retrieves a run's recovery results between two dates inclusive
+func (r *RunRepository) GetRecoveryResults(ctx context.Context, runID uuid.UUID, startDate, endDate time.Time) ([]models.RecoveryResultByDimension, error) {
+	query := r.QueryBuilder().
+		Select(append(append([]string{}, recoveryResultColumns...), "created_at")...).
+		From("recovery_results_by_dimension").
+		Where(squirrel.Eq{"run_id": runID}).
+		Where(squirrel.GtOrEq{"allocation_date": startDate}).
+		Where(squirrel.LtOrEq{"allocation_date": endDate}).
+		OrderBy("allocation_date, parent_id, dimension")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recovery results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.RecoveryResultByDimension
+	for rows.Next() {
+		var result models.RecoveryResultByDimension
+		err := rows.Scan(
+			&result.RunID,
+			&result.ParentID,
+			&result.AllocationDate,
+			&result.Dimension,
+			&result.Strategy,
+			&result.SinkNodeID,
+			&result.CostAmount,
+			&result.ChargedAmount,
+			&result.UnderRecovery,
+			&result.OverRecovery,
+			&result.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan recovery result: %w", err)
+		}
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating recovery results: %w", err)
+	}
+
+	return results, nil
+}
+
+// numericValue converts a decimal to a pgtype.Numeric. COPY uses the binary
+// protocol, which has no encoding for decimal.Decimal's string driver value.
+func numericValue(d decimal.Decimal) pgtype.Numeric {
+	return pgtype.Numeric{Int: d.Coefficient(), Exp: d.Exponent(), Valid: true}
+}
+
+// SaveDayFingerprint records the input fingerprint of a run's day
+func (r *RunRepository) SaveDayFingerprint(ctx context.Context, fp *models.AllocationDayFingerprint) error {
+	query := r.QueryBuilder().
+		Insert("allocation_day_fingerprints").
+		Columns("run_id", "allocation_date", "fingerprint", "graph_hash", "cost_checksum", "usage_checksum", "strategy_checksum", "copied_from_run_id").
+		Values(fp.RunID, fp.AllocationDate, fp.Fingerprint, fp.GraphHash, fp.CostChecksum, fp.UsageChecksum, fp.StrategyChecksum, fp.CopiedFromRunID).
+		Suffix("RETURNING created_at")
+
+	row := r.QueryRow(ctx, query)
+	if err := row.Scan(&fp.CreatedAt); err != nil {
+		return fmt.Errorf("failed to save day fingerprint: %w", err)
+	}
+
+	return nil
+}
+
+// GetLatestDayFingerprints retrieves, for each date in the range, the fingerprint
+// recorded by the most recent completed run that covered that date
+func (r *RunRepository) GetLatestDayFingerprints(ctx context.Context, startDate, endDate time.Time) ([]models.AllocationDayFingerprint, error) {
+	query := r.QueryBuilder().
+		Select("DISTINCT ON (f.allocation_date) f.run_id", "f.allocation_date", "f.fingerprint", "f.graph_hash",
+			"f.cost_checksum", "f.usage_checksum", "f.strategy_checksum", "f.copied_from_run_id", "f.created_at").
+		From("allocation_day_fingerprints f").
+		Join("computation_runs r ON r.id = f.run_id").
+		Where(squirrel.Eq{"r.status": string(models.ComputationStatusCompleted)}).
+		Where(squirrel.GtOrEq{"f.allocation_date": startDate}).
+		Where(squirrel.LtOrEq{"f.allocation_date": endDate}).
+		OrderBy("f.allocation_date", "r.created_at DESC")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get day fingerprints: %w", err)
+	}
+	defer rows.Close()
+
+	var fingerprints []models.AllocationDayFingerprint
+	for rows.Next() {
+		var fp models.AllocationDayFingerprint
+
+		err := rows.Scan(
+			&fp.RunID,
+			&fp.AllocationDate,
+			&fp.Fingerprint,
+			&fp.GraphHash,
+			&fp.CostChecksum,
+			&fp.UsageChecksum,
+			&fp.StrategyChecksum,
+			&fp.CopiedFromRunID,
+			&fp.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan day fingerprint: %w", err)
+		}
+
+		fingerprints = append(fingerprints, fp)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating day fingerprints: %w", err)
+	}
+
+	return fingerprints, nil
+}
+
+// DayCopyCounts reports how many rows CopyDayResults copied from each results table
+type DayCopyCounts struct {
+	Allocations   int64
+	Contributions int64
+	Lineage       int64
+	Reports       int64
+	Unallocated   int64
+	Recoveries    int64
+}
+
+// CopyDayResults copies a day's allocation, contribution and lineage results and its
+// invariant reports from one run to another
+func (r *RunRepository) CopyDayResults(ctx context.Context, fromRunID, toRunID uuid.UUID, date time.Time) (*DayCopyCounts, error) {
+	allocations := r.QueryBuilder().
+		Insert("allocation_results_by_dimension").
+		Columns("run_id", "node_id", "allocation_date", "dimension", "direct_amount", "indirect_amount", "total_amount").
+		Select(r.QueryBuilder().
+			Select().
+			Column("?::uuid", toRunID).
+			// Each run has its own unallocated node, so the earlier run's sink rows move to this run's sink
+			Column(`CASE WHEN node_id = (SELECT unallocated_node_id FROM computation_runs WHERE id = ?)
+				THEN (SELECT unallocated_node_id FROM computation_runs WHERE id = ?)
+				ELSE node_id END`, fromRunID, toRunID).
+			Columns("allocation_date", "dimension", "direct_amount", "indirect_amount", "total_amount").
+			From("allocation_results_by_dimension").
+			Where(squirrel.Eq{"run_id": fromRunID, "allocation_date": date}))
+
+	allocTag, err := r.ExecQuery(ctx, allocations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy allocation results: %w", err)
+	}
+
+	contributions := r.QueryBuilder().
+		Insert("contribution_results_by_dimension").
+		Columns("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "rounding_adjustment", "path").
+		Select(r.QueryBuilder().
+			Select().
+			Column("?::uuid", toRunID).
+			Columns("parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "rounding_adjustment", "path").
+			From("contribution_results_by_dimension").
+			Where(squirrel.Eq{"run_id": fromRunID, "contribution_date": date}))
+
+	contribTag, err := r.ExecQuery(ctx, contributions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy contribution results: %w", err)
+	}
+
+	lineage := r.QueryBuilder().
+		Insert("contribution_lineage_by_dimension").
+		Columns("run_id", "origin_id", "cost_centre_id", "lineage_date", "dimension", "amount", "path").
+		Select(r.QueryBuilder().
+			Select().
+			Column("?::uuid", toRunID).
+			Columns("origin_id", "cost_centre_id", "lineage_date", "dimension", "amount", "path").
+			From("contribution_lineage_by_dimension").
+			Where(squirrel.Eq{"run_id": fromRunID, "lineage_date": date}))
+
+	lineageTag, err := r.ExecQuery(ctx, lineage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy lineage results: %w", err)
+	}
+
+	reports := r.QueryBuilder().
+		Insert("allocation_invariant_reports").
+		Columns(invariantReportColumns...).
+		Select(r.QueryBuilder().
+			Select().
+			Column("?::uuid", toRunID).
+			Columns(invariantReportColumns[1:]...).
+			From("allocation_invariant_reports").
+			Where(squirrel.Eq{"run_id": fromRunID, "report_date": date}))
+
+	reportTag, err := r.ExecQuery(ctx, reports)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy invariant reports: %w", err)
+	}
+
+	unallocated := r.QueryBuilder().
+		Insert("unallocated_results_by_dimension").
+		Columns(unallocatedResultColumns...).
+		Select(r.QueryBuilder().
+			Select().
+			Column("?::uuid", toRunID).
+			Columns(unallocatedResultColumns[1:]...).
+			From("unallocated_results_by_dimension").
+			Where(squirrel.Eq{"run_id": fromRunID, "allocation_date": date}))
+
+	unallocatedTag, err := r.ExecQuery(ctx, unallocated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy unallocated results: %w", err)
+	}
+
+	recoveries := r.QueryBuilder().
+		Insert("recovery_results_by_dimension").
+		Columns(recoveryResultColumns...).
+		Select(r.QueryBuilder().
+			Select().
+			Column("?::uuid", toRunID).
+			Columns("parent_id", "allocation_date", "dimension", "strategy").
+			// Recoveries sunk to the earlier run's unallocated node move to this run's
+			Column(`CASE WHEN sink_node_id = (SELECT unallocated_node_id FROM computation_runs WHERE id = ?)
+				THEN (SELECT unallocated_node_id FROM computation_runs WHERE id = ?)
+				ELSE sink_node_id END`, fromRunID, toRunID).
+			Columns("cost_amount", "charged_amount", "under_recovery", "over_recovery").
+			From("recovery_results_by_dimension").
+			Where(squirrel.Eq{"run_id": fromRunID, "allocation_date": date}))
+
+	recoveryTag, err := r.ExecQuery(ctx, recoveries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy recovery results: %w", err)
+	}
+
+	return &DayCopyCounts{
+		Allocations:   allocTag.RowsAffected(),
+		Contributions: contribTag.RowsAffected(),
+		Lineage:       lineageTag.RowsAffected(),
+		Reports:       reportTag.RowsAffected(),
+		Unallocated:   unallocatedTag.RowsAffected(),
+		Recoveries:    recoveryTag.RowsAffected(),
+	}, nil
+}
+
 // GetAllocationResults retrieves allocation results for a computation run
 func (r *RunRepository) GetAllocationResults(ctx context.Context, runID uuid.UUID, filters AllocationResultFilters) ([]models.AllocationResultByDimension, error) {
 	query := r.QueryBuilder().
@@ -322,7 +996,7 @@ func (r *RunRepository) GetAllocationResults(ctx context.Context, runID uuid.UUI
 // GetContributionResults retrieves contribution results for a computation run
 func (r *RunRepository) GetContributionResults(ctx context.Context, runID uuid.UUID, filters ContributionResultFilters) ([]models.ContributionResultByDimension, error) {
 	query := r.QueryBuilder().
-		Select("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "path", "created_at", "updated_at").
+		Select("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "rounding_adjustment", "path", "created_at", "updated_at").
 		From("contribution_results_by_dimension").
 		Where(squirrel.Eq{"run_id": runID})
 
@@ -363,6 +1037,7 @@ func (r *RunRepository) GetContributionResults(ctx context.Context, runID uuid.U
 			&result.ContributionDate,
 			&result.Dimension,
 			&result.ContributedAmount,
+			&result.RoundingAdjustment,
 			&pathJSON,
 			&result.CreatedAt,
 			&result.UpdatedAt,
@@ -371,9 +1046,10 @@ func (r *RunRepository) GetContributionResults(ctx context.Context, runID uuid.U
 			return nil, fmt.Errorf("failed to scan contribution result: %w", err)
 		}
 
-		// TODO: Parse pathJSON back to []uuid.UUID
-		// For now, leaving it empty
-		result.Path = []uuid.UUID{}
+		result.Path, err = parsePath(pathJSON)
+		if err != nil {
+			return nil, err
+		}
 
 		results = append(results, result)
 	}
@@ -473,7 +1149,7 @@ func (r *RunRepository) GetAllocationsByChildAndDateRange(ctx context.Context, c
 // GetContributionsByParentAndDateRange retrieves contributions where the given node is the parent (receiving contributions)
 func (r *RunRepository) GetContributionsByParentAndDateRange(ctx context.Context, parentID uuid.UUID, startDate, endDate time.Time, dimensions []string) ([]models.ContributionResultByDimension, error) {
 	query := r.QueryBuilder().
-		Select("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "path", "created_at", "updated_at").
+		Select("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "rounding_adjustment", "path", "created_at", "updated_at").
 		From("contribution_results_by_dimension").
 		Where(squirrel.Eq{"parent_id": parentID}).
 		Where(squirrel.GtOrEq{"contribution_date": startDate}).
@@ -503,6 +1179,7 @@ func (r *RunRepository) GetContributionsByParentAndDateRange(ctx context.Context
 			&result.ContributionDate,
 			&result.Dimension,
 			&result.ContributedAmount,
+			&result.RoundingAdjustment,
 			&pathJSON,
 			&result.CreatedAt,
 			&result.UpdatedAt,
@@ -511,8 +1188,10 @@ func (r *RunRepository) GetContributionsByParentAndDateRange(ctx context.Context
 			return nil, fmt.Errorf("failed to scan contribution result: %w", err)
 		}
 
-		// TODO: Parse pathJSON back to []uuid.UUID
-		result.Path = []uuid.UUID{}
+		result.Path, err = parsePath(pathJSON)
+		if err != nil {
+			return nil, err
+		}
 
 		results = append(results, result)
 	}
@@ -527,7 +1206,7 @@ func (r *RunRepository) GetContributionsByParentAndDateRange(ctx context.Context
 // GetContributionsByChildAndDateRange retrieves contributions where the given node is the child (contributing to others)
 func (r *RunRepository) GetContributionsByChildAndDateRange(ctx context.Context, childID uuid.UUID, startDate, endDate time.Time, dimensions []string) ([]models.ContributionResultByDimension, error) {
 	query := r.QueryBuilder().
-		Select("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "path", "created_at", "updated_at").
+		Select("run_id", "parent_id", "child_id", "contribution_date", "dimension", "contributed_amount", "rounding_adjustment", "path", "created_at", "updated_at").
 		From("contribution_results_by_dimension").
 		Where(squirrel.Eq{"child_id": childID}).
 		Where(squirrel.GtOrEq{"contribution_date": startDate}).
@@ -557,6 +1236,7 @@ func (r *RunRepository) GetContributionsByChildAndDateRange(ctx context.Context,
 			&result.ContributionDate,
 			&result.Dimension,
 			&result.ContributedAmount,
+			&result.RoundingAdjustment,
 			&pathJSON,
 			&result.CreatedAt,
 			&result.UpdatedAt,
@@ -565,8 +1245,10 @@ func (r *RunRepository) GetContributionsByChildAndDateRange(ctx context.Context,
 			return nil, fmt.Errorf("failed to scan contribution result: %w", err)
 		}
 
-		// TODO: Parse pathJSON back to []uuid.UUID
-		result.Path = []uuid.UUID{}
+		result.Path, err = parsePath(pathJSON)
+		if err != nil {
+			return nil, err
+		}
 
 		results = append(results, result)
 	}
@@ -627,3 +1309,89 @@ func (r *RunRepository) GetAllocationsByNodeAndDateRange(ctx context.Context, no
 
 	return results, nil
 }
+
+// LineageFilters represents filtering options for lineage results
+type LineageFilters struct {
+	StartDate  time.Time
+	EndDate    time.Time
+	Dimensions []string
+}
+
+// GetLineage retrieves the end-to-end lineage of every cost flow in a run that
+// passes through a node: flows originating on it, flows ending on it as a final
+// cost centre, and flows routed through it on the way
+func (r *RunRepository) GetLineage(ctx context.Context, runID, nodeID uuid.UUID, filters LineageFilters) ([]models.LineageResultByDimension, error) {
+	nodeJSON, err := json.Marshal([]uuid.UUID{nodeID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal node filter: %w", err)
+	}
+
+	query := r.QueryBuilder().
+		Select("run_id", "origin_id", "cost_centre_id", "lineage_date", "dimension", "amount", "path", "created_at").
+		From("contribution_lineage_by_dimension").
+		Where(squirrel.Eq{"run_id": runID}).
+		Where("path @> ?::jsonb", string(nodeJSON))
+
+	if !filters.StartDate.IsZero() {
+		query = query.Where(squirrel.GtOrEq{"lineage_date": filters.StartDate})
+	}
+	if !filters.EndDate.IsZero() {
+		query = query.Where(squirrel.LtOrEq{"lineage_date": filters.EndDate})
+	}
+	if len(filters.Dimensions) > 0 {
+		query = query.Where(squirrel.Eq{"dimension": filters.Dimensions})
+	}
+
+	query = query.OrderBy("lineage_date, dimension, origin_id, cost_centre_id, amount DESC")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lineage: %w", err)
+	}
+	defer rows.Close()
+
+	var results []models.LineageResultByDimension
+	for rows.Next() {
+		var result models.LineageResultByDimension
+		var pathJSON string
+
+		err := rows.Scan(
+			&result.RunID,
+			&result.OriginID,
+			&result.CostCentreID,
+			&result.LineageDate,
+			&result.Dimension,
+			&result.Amount,
+			&pathJSON,
+			&result.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan lineage result: %w", err)
+		}
+
+		result.Path, err = parsePath(pathJSON)
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating lineage results: %w", err)
+	}
+
+	return results, nil
+}
+
+// parsePath decodes a JSONB node path
+func parsePath(pathJSON string) ([]uuid.UUID, error) {
+	path := []uuid.UUID{}
+	if pathJSON == "" {
+		return path, nil
+	}
+	if err := json.Unmarshal([]byte(pathJSON), &path); err != nil {
+		return nil, fmt.Errorf("failed to parse path: %w", err)
+	}
+	return path, nil
+}
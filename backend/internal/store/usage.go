@@ -35,12 +35,13 @@ func NewUsageRepositoryWithTx(tx pgx.Tx, sb squirrel.StatementBuilderType) *Usag
 func (r *UsageRepository) Upsert(ctx context.Context, usage *models.NodeUsageByDimension) error {
 	query := r.QueryBuilder().
 		Insert("node_usage_by_dimension").
-		Columns("node_id", "usage_date", "metric", "value", "unit").
-		Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit).
-		Suffix(`ON CONFLICT (node_id, usage_date, metric) 
-			DO UPDATE SET 
+		Columns("node_id", "usage_date", "metric", "value", "unit", "aggregation").
+		Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit, usageAggregation(usage.Aggregation)).
+		Suffix(`ON CONFLICT (node_id, usage_date, metric, labels)
+			DO UPDATE SET
 				value = EXCLUDED.value,
 				unit = EXCLUDED.unit,
+				aggregation = EXCLUDED.aggregation,
 				updated_at = now()
 			RETURNING created_at, updated_at`)
 
@@ -52,14 +53,16 @@ func (r *UsageRepository) Upsert(ctx context.Context, usage *models.NodeUsageByD
 	return nil
 }
 
-// GetByNodeAndDateRange retrieves usage for a node within a date range
+// GetByNodeAndDateRange retrieves usage for a node within a date range,
+// combining each day's label sets with their metric's aggregation
 func (r *UsageRepository) GetByNodeAndDateRange(ctx context.Context, nodeID uuid.UUID, startDate, endDate time.Time, metrics []string) ([]models.NodeUsageByDimension, error) {
 	query := r.QueryBuilder().
-		Select("node_id", "usage_date", "metric", "value", "unit", "created_at", "updated_at").
+		Select(usageTotalColumns...).
 		From("node_usage_by_dimension").
 		Where(squirrel.Eq{"node_id": nodeID}).
 		Where(squirrel.GtOrEq{"usage_date": startDate}).
-		Where(squirrel.LtOrEq{"usage_date": endDate})
+		Where(squirrel.LtOrEq{"usage_date": endDate}).
+		GroupBy(usageTotalGroupBy...)
 
 	if len(metrics) > 0 {
 		query = query.Where(squirrel.Eq{"metric": metrics})
@@ -100,13 +103,15 @@ func (r *UsageRepository) GetByNodeAndDateRange(ctx context.Context, nodeID uuid
 	return usages, nil
 }
 
-// GetByDateRange retrieves all usage within a date range
+// GetByDateRange retrieves all usage within a date range, combining each day's
+// label sets with their metric's aggregation
 func (r *UsageRepository) GetByDateRange(ctx context.Context, startDate, endDate time.Time, metrics []string) ([]models.NodeUsageByDimension, error) {
 	query := r.QueryBuilder().
-		Select("node_id", "usage_date", "metric", "value", "unit", "created_at", "updated_at").
+		Select(usageTotalColumns...).
 		From("node_usage_by_dimension").
 		Where(squirrel.GtOrEq{"usage_date": startDate}).
-		Where(squirrel.LtOrEq{"usage_date": endDate})
+		Where(squirrel.LtOrEq{"usage_date": endDate}).
+		GroupBy(usageTotalGroupBy...)
 
 	if len(metrics) > 0 {
 		query = query.Where(squirrel.Eq{"metric": metrics})
@@ -147,12 +152,14 @@ func (r *UsageRepository) GetByDateRange(ctx context.Context, startDate, endDate
 	return usages, nil
 }
 
-// GetByDate retrieves all usage for a specific date
+// GetByDate retrieves all usage for a specific date, combining its label sets
+// with their metric's aggregation
 func (r *UsageRepository) GetByDate(ctx context.Context, date time.Time, metrics []string) ([]models.NodeUsageByDimension, error) {
 	query := r.QueryBuilder().
-		Select("node_id", "usage_date", "metric", "value", "unit", "created_at", "updated_at").
+		Select(usageTotalColumns...).
 		From("node_usage_by_dimension").
-		Where(squirrel.Eq{"usage_date": date})
+		Where(squirrel.Eq{"usage_date": date}).
+		GroupBy(usageTotalGroupBy...)
 
 	if len(metrics) > 0 {
 		query = query.Where(squirrel.Eq{"metric": metrics})
@@ -196,7 +203,7 @@ func (r *UsageRepository) GetByDate(ctx context.Context, date time.Time, metrics
 // GetSummaryByNodeAndDateRange retrieves usage summaries aggregated by node and metric
 func (r *UsageRepository) GetSummaryByNodeAndDateRange(ctx context.Context, startDate, endDate time.Time, metrics []string) ([]UsageSummary, error) {
 	query := r.QueryBuilder().
-		Select("node_id", "metric", "unit", "SUM(value) as total_value", "AVG(value) as avg_value", "COUNT(*) as day_count").
+		Select("node_id", "metric", "unit", "SUM(value) as total_value", "SUM(value) / COUNT(DISTINCT usage_date) as avg_value", "COUNT(DISTINCT usage_date) as day_count").
 		From("node_usage_by_dimension").
 		Where(squirrel.GtOrEq{"usage_date": startDate}).
 		Where(squirrel.LtOrEq{"usage_date": endDate}).
@@ -287,18 +294,23 @@ func (r *UsageRepository) BulkUpsert(ctx context.Context, usages []models.NodeUs
 		return usages[i].Metric < usages[j].Metric
 	})
 
+	if err := checkUsageAggregations(usages); err != nil {
+		return fmt.Errorf("failed to bulk upsert usage: %w", err)
+	}
+
 	query := r.QueryBuilder().
 		Insert("node_usage_by_dimension").
-		Columns("node_id", "usage_date", "metric", "value", "unit")
+		Columns("node_id", "usage_date", "metric", "value", "unit", "aggregation")
 
 	for _, usage := range usages {
-		query = query.Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit)
+		query = query.Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit, usageAggregation(usage.Aggregation))
 	}
 
-	query = query.Suffix(`ON CONFLICT (node_id, usage_date, metric)
+	query = query.Suffix(`ON CONFLICT (node_id, usage_date, metric, labels)
 		DO UPDATE SET
 			value = EXCLUDED.value,
 			unit = EXCLUDED.unit,
+			aggregation = EXCLUDED.aggregation,
 			updated_at = now()`)
 
 	_, err := r.ExecQuery(ctx, query)
@@ -309,6 +321,98 @@ func (r *UsageRepository) BulkUpsert(ctx context.Context, usages []models.NodeUs
 	return nil
 }
 
+// BulkUpsertHourly efficiently inserts or updates multiple hourly usage records.
+// Each record's hour must already be truncated to the start of the hour.
+func (r *UsageRepository) BulkUpsertHourly(ctx context.Context, usages []models.NodeUsageHourly) error {
+	if len(usages) == 0 {
+		return nil
+	}
+
+	// Sort by primary key (node_id, usage_hour, metric) to prevent deadlocks, as in BulkUpsert
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].NodeID != usages[j].NodeID {
+			return usages[i].NodeID.String() < usages[j].NodeID.String()
+		}
+		if !usages[i].UsageHour.Equal(usages[j].UsageHour) {
+			return usages[i].UsageHour.Before(usages[j].UsageHour)
+		}
+		return usages[i].Metric < usages[j].Metric
+	})
+
+	query := r.QueryBuilder().
+		Insert("node_usage_hourly").
+		Columns("node_id", "usage_hour", "metric", "value", "unit", "source")
+
+	for _, usage := range usages {
+		query = query.Values(usage.NodeID, usage.UsageHour.UTC(), usage.Metric, usage.Value, usage.Unit, usage.Source)
+	}
+
+	query = query.Suffix(`ON CONFLICT (node_id, usage_hour, metric)
+		DO UPDATE SET
+			value = EXCLUDED.value,
+			unit = EXCLUDED.unit,
+			source = EXCLUDED.source,
+			updated_at = now()`)
+
+	_, err := r.ExecQuery(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to bulk upsert hourly usage: %w", err)
+	}
+
+	return nil
+}
+
+// GetHourlyByDate retrieves all hourly usage within a UTC day
+func (r *UsageRepository) GetHourlyByDate(ctx context.Context, date time.Time, metrics []string) ([]models.NodeUsageHourly, error) {
+	start := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+
+	query := r.QueryBuilder().
+		Select("node_id", "usage_hour", "metric", "value", "unit", "source", "created_at", "updated_at").
+		From("node_usage_hourly").
+		Where(squirrel.GtOrEq{"usage_hour": start}).
+		Where(squirrel.Lt{"usage_hour": start.AddDate(0, 0, 1)})
+
+	if len(metrics) > 0 {
+		query = query.Where(squirrel.Eq{"metric": metrics})
+	}
+
+	query = query.OrderBy("node_id, usage_hour, metric")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hourly usage by date: %w", err)
+	}
+	defer rows.Close()
+
+	var usages []models.NodeUsageHourly
+	for rows.Next() {
+		var usage models.NodeUsageHourly
+
+		err := rows.Scan(
+			&usage.NodeID,
+			&usage.UsageHour,
+			&usage.Metric,
+			&usage.Value,
+			&usage.Unit,
+			&usage.Source,
+			&usage.CreatedAt,
+			&usage.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hourly usage: %w", err)
+		}
+
+		usage.UsageHour = usage.UsageHour.UTC()
+		usages = append(usages, usage)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating hourly usage: %w", err)
+	}
+
+	return usages, nil
+}
+
 // UsageSummary represents aggregated usage data
 type UsageSummary struct {
 	NodeID     uuid.UUID `db:"node_id"`
@@ -319,17 +423,18 @@ type UsageSummary struct {
 	DayCount   int       `db:"day_count"`
 }
 
-// UpsertWithLabels creates or updates a node usage record with labels
+// UpsertWithLabels creates or updates a node usage record with labels. Each
+// label set has its own record.
 func (r *UsageRepository) UpsertWithLabels(ctx context.Context, usage *models.NodeUsageByDimension) error {
 	query := r.QueryBuilder().
 		Insert("node_usage_by_dimension").
-		Columns("node_id", "usage_date", "metric", "value", "unit", "labels", "source").
-		Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit, usage.Labels, usage.Source).
-		Suffix(`ON CONFLICT (node_id, usage_date, metric)
+		Columns("node_id", "usage_date", "metric", "value", "unit", "labels", "source", "aggregation").
+		Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit, usageLabels(usage.Labels), usage.Source, usageAggregation(usage.Aggregation)).
+		Suffix(`ON CONFLICT (node_id, usage_date, metric, labels)
 			DO UPDATE SET
 				value = EXCLUDED.value,
 				unit = EXCLUDED.unit,
-				labels = EXCLUDED.labels,
+				aggregation = EXCLUDED.aggregation,
 				source = EXCLUDED.source,
 				updated_at = now()
 			RETURNING created_at, updated_at`)
@@ -456,8 +561,8 @@ func (r *UsageRepository) GetSummaryByLabelValue(ctx context.Context, opts model
 			unit,
 			labels->>'%s' as label_value,
 			SUM(value) as total_value,
-			AVG(value) as avg_value,
-			COUNT(*) as day_count
+			SUM(value) / COUNT(DISTINCT usage_date) as avg_value,
+			COUNT(DISTINCT usage_date) as day_count
 		FROM node_usage_by_dimension
 		WHERE usage_date >= $1 AND usage_date <= $2
 		  AND labels->>'%s' IS NOT NULL
@@ -524,25 +629,30 @@ func (r *UsageRepository) GetSummaryByLabelValue(ctx context.Context, opts model
 	return result, nil
 }
 
-// BulkUpsertWithLabels efficiently inserts or updates multiple usage records with labels
+// BulkUpsertWithLabels efficiently inserts or updates multiple usage records with
+// labels. No two records may share a node, date, metric and label set.
 func (r *UsageRepository) BulkUpsertWithLabels(ctx context.Context, usages []models.NodeUsageByDimension) error {
 	if len(usages) == 0 {
 		return nil
 	}
 
+	if err := checkUsageAggregations(usages); err != nil {
+		return fmt.Errorf("failed to bulk upsert usage with labels: %w", err)
+	}
+
 	query := r.QueryBuilder().
 		Insert("node_usage_by_dimension").
-		Columns("node_id", "usage_date", "metric", "value", "unit", "labels", "source")
+		Columns("node_id", "usage_date", "metric", "value", "unit", "labels", "source", "aggregation")
 
 	for _, usage := range usages {
-		query = query.Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit, usage.Labels, usage.Source)
+		query = query.Values(usage.NodeID, usage.UsageDate, usage.Metric, usage.Value, usage.Unit, usageLabels(usage.Labels), usage.Source, usageAggregation(usage.Aggregation))
 	}
 
-	query = query.Suffix(`ON CONFLICT (node_id, usage_date, metric)
+	query = query.Suffix(`ON CONFLICT (node_id, usage_date, metric, labels)
 		DO UPDATE SET
 			value = EXCLUDED.value,
 			unit = EXCLUDED.unit,
-			labels = EXCLUDED.labels,
+			aggregation = EXCLUDED.aggregation,
 			source = EXCLUDED.source,
 			updated_at = now()`)
 
@@ -554,6 +664,144 @@ func (r *UsageRepository) BulkUpsertWithLabels(ctx context.Context, usages []mod
 	return nil
 }
 
+// BulkUpsertSamples inserts or updates usage datapoints at their reported
+// resolution. No two samples may share a node, metric, label set and time.
+func (r *UsageRepository) BulkUpsertSamples(ctx context.Context, samples []models.NodeUsageSample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	query := r.QueryBuilder().
+		Insert("node_usage_samples").
+		Columns("node_id", "sampled_at", "metric", "labels", "value", "unit", "source")
+
+	for _, sample := range samples {
+		query = query.Values(sample.NodeID, sample.SampledAt.UTC(), sample.Metric, usageLabels(sample.Labels), sample.Value, sample.Unit, sample.Source)
+	}
+
+	query = query.Suffix(`ON CONFLICT (node_id, metric, labels, sampled_at)
+		DO UPDATE SET
+			value = EXCLUDED.value,
+			unit = EXCLUDED.unit,
+			source = EXCLUDED.source,
+			updated_at = now()`)
+
+	_, err := r.ExecQuery(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to bulk upsert usage samples: %w", err)
+	}
+
+	return nil
+}
+
+// GetSamples retrieves a node's usage datapoints sampled within [start, end)
+func (r *UsageRepository) GetSamples(ctx context.Context, nodeID uuid.UUID, start, end time.Time, metrics []string) ([]models.NodeUsageSample, error) {
+	query := r.QueryBuilder().
+		Select("node_id", "sampled_at", "metric", "labels", "value", "unit", "source", "created_at", "updated_at").
+		From("node_usage_samples").
+		Where(squirrel.Eq{"node_id": nodeID}).
+		Where(squirrel.GtOrEq{"sampled_at": start}).
+		Where(squirrel.Lt{"sampled_at": end})
+
+	if len(metrics) > 0 {
+		query = query.Where(squirrel.Eq{"metric": metrics})
+	}
+
+	query = query.OrderBy("metric, sampled_at")
+
+	rows, err := r.QueryRows(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []models.NodeUsageSample
+	for rows.Next() {
+		var sample models.NodeUsageSample
+
+		err := rows.Scan(
+			&sample.NodeID,
+			&sample.SampledAt,
+			&sample.Metric,
+			&sample.Labels,
+			&sample.Value,
+			&sample.Unit,
+			&sample.Source,
+			&sample.CreatedAt,
+			&sample.UpdatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan usage sample: %w", err)
+		}
+
+		sample.SampledAt = sample.SampledAt.UTC()
+		samples = append(samples, sample)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating usage samples: %w", err)
+	}
+
+	return samples, nil
+}
+
+// usageTotalColumns select a day's usage over all its label sets, grouped by
+// usageTotalGroupBy
+var usageTotalColumns = []string{"node_id", "usage_date", "metric", usageTotalValue, "unit", "MIN(created_at)", "MAX(updated_at)"}
+
+// usageTotalValue combines a day's label sets with their metric's aggregation,
+// as each label set's datapoints were: summing averages or peaks would count
+// them once per label set. The label sets share one aggregation, which the
+// check_node_usage_aggregation trigger enforces, so MIN picks it.
+const usageTotalValue = `CASE MIN(aggregation)
+		WHEN 'avg' THEN AVG(value)
+		WHEN 'max' THEN MAX(value)
+		WHEN 'p95' THEN percentile_disc(0.95) WITHIN GROUP (ORDER BY value)
+		ELSE SUM(value)
+	END`
+
+var usageTotalGroupBy = []string{"node_id", "usage_date", "metric", "unit"}
+
+// usageAggregation returns the aggregation to store, sum when there is none
+func usageAggregation(aggregation string) string {
+	if aggregation == "" {
+		return "sum"
+	}
+	return aggregation
+}
+
+// checkUsageAggregations rejects a batch that gives one node's metric on one
+// day more than one aggregation across its label sets, which readers could not
+// combine
+func checkUsageAggregations(usages []models.NodeUsageByDimension) error {
+	type usageDay struct {
+		nodeID uuid.UUID
+		date   string
+		metric string
+	}
+
+	aggregations := make(map[usageDay]string, len(usages))
+	for _, usage := range usages {
+		key := usageDay{nodeID: usage.NodeID, date: usage.UsageDate.Format("2006-01-02"), metric: usage.Metric}
+		aggregation := usageAggregation(usage.Aggregation)
+		if existing, ok := aggregations[key]; ok && existing != aggregation {
+			return fmt.Errorf("usage metric %s for node %s on %s has label sets with different aggregations: %s, %s",
+				usage.Metric, usage.NodeID, key.date, existing, aggregation)
+		}
+		aggregations[key] = aggregation
+	}
+
+	return nil
+}
+
+// usageLabels returns the labels to store, an empty set when there are none
+func usageLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
+}
+
 // Helper function to join strings
 func joinStrings(strs []string, sep string) string {
 	if len(strs) == 0 {
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pickeringtech/FinOpsAggregator/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckUsageAggregations(t *testing.T) {
+	nodeID := uuid.New()
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+
+	usage := func(metric, aggregation string, labels map[string]string, date time.Time) models.NodeUsageByDimension {
+		return models.NodeUsageByDimension{
+			NodeID:      nodeID,
+			UsageDate:   date,
+			Metric:      metric,
+			Aggregation: aggregation,
+			Labels:      labels,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		usages  []models.NodeUsageByDimension
+		wantErr string
+	}{
+		{
+			name: "label sets share an aggregation",
+			usages: []models.NodeUsageByDimension{
+				usage("cpu_percent", "avg", map[string]string{"host": "a"}, day),
+				usage("cpu_percent", "avg", map[string]string{"host": "b"}, day),
+			},
+		},
+		{
+			name: "empty aggregation is sum",
+			usages: []models.NodeUsageByDimension{
+				usage("requests", "", map[string]string{"host": "a"}, day),
+				usage("requests", "sum", map[string]string{"host": "b"}, day),
+			},
+		},
+		{
+			name: "different metrics and days may differ",
+			usages: []models.NodeUsageByDimension{
+				usage("cpu_percent", "avg", nil, day),
+				usage("requests", "sum", nil, day),
+				usage("cpu_percent", "max", nil, day.AddDate(0, 0, 1)),
+			},
+		},
+		{
+			name: "label sets disagree",
+			usages: []models.NodeUsageByDimension{
+				usage("cpu_percent", "avg", map[string]string{"host": "a"}, day),
+				usage("cpu_percent", "max", map[string]string{"host": "b"}, day),
+			},
+			wantErr: "usage metric cpu_percent for node " + nodeID.String() + " on 2024-01-15 has label sets with different aggregations: avg, max",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUsageAggregations(tt.usages)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.wantErr, err.Error())
+		})
+	}
+}
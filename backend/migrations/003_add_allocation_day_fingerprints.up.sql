@@ -0,0 +1,22 @@
+-- Per-day input fingerprints for incremental allocation
+--
+-- Each completed day of a computation run records a fingerprint of the inputs it
+-- was computed from. An incremental run recomputes only the days whose fingerprint
+-- differs from the latest completed run and copies the rest forward.
+
+CREATE TABLE allocation_day_fingerprints (
+    run_id UUID NOT NULL REFERENCES computation_runs(id) ON DELETE CASCADE,
+    allocation_date DATE NOT NULL,
+    fingerprint TEXT NOT NULL,
+    graph_hash TEXT NOT NULL,
+    cost_checksum TEXT NOT NULL,
+    usage_checksum TEXT NOT NULL,
+    strategy_checksum TEXT NOT NULL,
+    copied_from_run_id UUID REFERENCES computation_runs(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT allocation_day_fingerprints_not_empty CHECK (length(trim(fingerprint)) > 0),
+    PRIMARY KEY (run_id, allocation_date)
+);
+
+CREATE INDEX idx_allocation_day_fingerprints_date ON allocation_day_fingerprints(allocation_date);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS contribution_lineage_by_dimension;
@@ -0,0 +1,27 @@
+-- End-to-end contribution lineage
+--
+-- contribution_results_by_dimension records single-hop parent to child
+-- contributions. Lineage rows trace each final cost centre's holistic cost back
+-- to the node it was originally incurred on, with the full allocation path, so
+-- the amounts for a cost centre on a day sum to its total.
+
+CREATE TABLE contribution_lineage_by_dimension (
+    run_id UUID NOT NULL REFERENCES computation_runs(id) ON DELETE CASCADE,
+    origin_id UUID NOT NULL REFERENCES cost_nodes(id) ON DELETE CASCADE,
+    cost_centre_id UUID NOT NULL REFERENCES cost_nodes(id) ON DELETE CASCADE,
+    lineage_date DATE NOT NULL,
+    dimension TEXT NOT NULL,
+    amount NUMERIC(38, 9) NOT NULL,
+    path JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT contribution_lineage_dimension_not_empty CHECK (length(trim(dimension)) > 0),
+    CONSTRAINT contribution_lineage_amount_non_negative CHECK (amount >= 0),
+    CONSTRAINT contribution_lineage_path_not_empty CHECK (jsonb_array_length(path) > 0),
+    PRIMARY KEY (run_id, origin_id, cost_centre_id, lineage_date, dimension, path)
+);
+
+CREATE INDEX idx_contribution_lineage_run_origin ON contribution_lineage_by_dimension(run_id, origin_id);
+CREATE INDEX idx_contribution_lineage_run_cost_centre ON contribution_lineage_by_dimension(run_id, cost_centre_id);
+CREATE INDEX idx_contribution_lineage_lineage_date ON contribution_lineage_by_dimension(lineage_date);
+CREATE INDEX idx_contribution_lineage_path ON contribution_lineage_by_dimension USING GIN (path jsonb_path_ops);
@@ -0,0 +1,24 @@
+-- Per-day allocation invariant reports
+--
+-- Each day and dimension of a computation run records where its direct cost ended
+-- up, so any cost created or lost by the allocation is visible exactly:
+--   direct_cost = final_cost_centre_cost + unallocated_cost + stranded_cost
+--                 - created_cost + lost_cost
+
+CREATE TABLE allocation_invariant_reports (
+    run_id UUID NOT NULL REFERENCES computation_runs(id) ON DELETE CASCADE,
+    report_date DATE NOT NULL,
+    dimension TEXT NOT NULL,
+    direct_cost NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    final_cost_centre_cost NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    unallocated_cost NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    stranded_cost NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    created_cost NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    lost_cost NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT allocation_invariant_reports_dimension_not_empty CHECK (length(trim(dimension)) > 0),
+    PRIMARY KEY (run_id, report_date, dimension)
+);
+
+CREATE INDEX idx_allocation_invariant_reports_report_date ON allocation_invariant_reports(report_date);
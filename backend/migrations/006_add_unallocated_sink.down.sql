@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS unallocated_results_by_dimension;
+
+DELETE FROM cost_nodes WHERE type = 'unallocated';
+
+ALTER TABLE computation_runs DROP COLUMN IF EXISTS unallocated_node_id;
@@ -0,0 +1,29 @@
+-- Explicit unallocated sink
+--
+-- Each computation run gets a synthetic cost node of type 'unallocated'. Cost the
+-- engine cannot place on a child is routed to it, so the sink has ordinary
+-- allocation_results_by_dimension rows holding the run's unallocated total, and
+-- unallocated_results_by_dimension records where that cost came from and why.
+
+ALTER TABLE computation_runs
+    ADD COLUMN unallocated_node_id UUID REFERENCES cost_nodes(id) ON DELETE SET NULL;
+
+CREATE TABLE unallocated_results_by_dimension (
+    run_id UUID NOT NULL REFERENCES computation_runs(id) ON DELETE CASCADE,
+    node_id UUID NOT NULL REFERENCES cost_nodes(id) ON DELETE CASCADE,
+    allocation_date DATE NOT NULL,
+    dimension TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    amount NUMERIC(38, 9) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT unallocated_results_dimension_not_empty CHECK (length(trim(dimension)) > 0),
+    CONSTRAINT unallocated_results_reason_valid CHECK (reason IN (
+        'no_children', 'strategy_error', 'zero_usage_fallback_disabled', 'share_remainder', 'rounding_residue'
+    )),
+    CONSTRAINT unallocated_results_amount_non_negative CHECK (amount >= 0),
+    PRIMARY KEY (run_id, node_id, allocation_date, dimension, reason)
+);
+
+CREATE INDEX idx_unallocated_results_allocation_date ON unallocated_results_by_dimension(allocation_date);
+CREATE INDEX idx_unallocated_results_run_reason ON unallocated_results_by_dimension(run_id, reason);
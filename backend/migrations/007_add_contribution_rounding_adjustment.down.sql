@@ -0,0 +1 @@
+ALTER TABLE contribution_results_by_dimension DROP COLUMN IF EXISTS rounding_adjustment;
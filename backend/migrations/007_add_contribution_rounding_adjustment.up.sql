@@ -0,0 +1,8 @@
+-- Rounding adjustments on contributions
+--
+-- When contributions are rounded to the currency's minor unit, each row records
+-- how far its rounded amount moved from the exact share, so that the rounded
+-- amounts can always be reconciled back to the unrounded allocation.
+
+ALTER TABLE contribution_results_by_dimension
+    ADD COLUMN rounding_adjustment NUMERIC(38, 9) NOT NULL DEFAULT 0;
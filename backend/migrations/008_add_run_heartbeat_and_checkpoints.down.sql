@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS computation_run_checkpoints;
+
+DROP INDEX IF EXISTS idx_computation_runs_heartbeat_at;
+
+ALTER TABLE computation_runs
+    DROP COLUMN IF EXISTS dimensions,
+    DROP COLUMN IF EXISTS heartbeat_at;
+
+UPDATE computation_runs SET status = 'failed' WHERE status = 'cancelled';
+
+ALTER TABLE computation_runs DROP CONSTRAINT computation_runs_status_valid;
+ALTER TABLE computation_runs ADD CONSTRAINT computation_runs_status_valid
+    CHECK (status IN ('pending', 'running', 'completed', 'failed'));
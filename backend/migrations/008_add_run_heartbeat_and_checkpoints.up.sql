@@ -0,0 +1,27 @@
+-- Run cancellation, heartbeats and resumable checkpoints
+--
+-- A run that is cancelled is recorded as such rather than failed. The process
+-- running a computation touches heartbeat_at periodically, so a run whose
+-- process died can be told apart from one still in progress and reaped. Each
+-- day whose results have been saved in full gets a checkpoint, and a failed or
+-- cancelled run resumes from the day after its last checkpoint using the
+-- dimensions it was started with.
+
+ALTER TABLE computation_runs DROP CONSTRAINT computation_runs_status_valid;
+ALTER TABLE computation_runs ADD CONSTRAINT computation_runs_status_valid
+    CHECK (status IN ('pending', 'running', 'completed', 'failed', 'cancelled'));
+
+ALTER TABLE computation_runs
+    ADD COLUMN heartbeat_at TIMESTAMPTZ,
+    ADD COLUMN dimensions TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX idx_computation_runs_heartbeat_at ON computation_runs(heartbeat_at)
+    WHERE status IN ('pending', 'running');
+
+CREATE TABLE computation_run_checkpoints (
+    run_id UUID NOT NULL REFERENCES computation_runs(id) ON DELETE CASCADE,
+    checkpoint_date DATE NOT NULL,
+    completed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    PRIMARY KEY (run_id, checkpoint_date)
+);
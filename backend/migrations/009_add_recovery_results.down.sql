@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS recovery_results_by_dimension;
+
+DELETE FROM unallocated_results_by_dimension WHERE reason = 'under_recovery';
+
+ALTER TABLE unallocated_results_by_dimension DROP CONSTRAINT unallocated_results_reason_valid;
+ALTER TABLE unallocated_results_by_dimension ADD CONSTRAINT unallocated_results_reason_valid CHECK (reason IN (
+    'no_children', 'strategy_error', 'zero_usage_fallback_disabled', 'share_remainder', 'rounding_residue'
+));
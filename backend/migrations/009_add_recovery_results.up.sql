@@ -0,0 +1,38 @@
+-- Under- and over-recovery of rate-based strategies
+--
+-- A tiered_rate strategy charges each child for its usage at a rate card, which
+-- rarely matches the parent's cost. The difference goes to a sink: a child named
+-- by the strategy, or the run's unallocated node. Under-recovered cost is
+-- allocated to the sink (recorded as unallocated cost with reason
+-- 'under_recovery' when the sink is the unallocated node). Over-recovery cannot
+-- be allocated without creating cost, so the children's charges are scaled back
+-- to the cost and the surplus is booked against the sink here.
+
+ALTER TABLE unallocated_results_by_dimension DROP CONSTRAINT unallocated_results_reason_valid;
+ALTER TABLE unallocated_results_by_dimension ADD CONSTRAINT unallocated_results_reason_valid CHECK (reason IN (
+    'no_children', 'strategy_error', 'zero_usage_fallback_disabled', 'share_remainder', 'rounding_residue',
+    'under_recovery'
+));
+
+CREATE TABLE recovery_results_by_dimension (
+    run_id UUID NOT NULL REFERENCES computation_runs(id) ON DELETE CASCADE,
+    parent_id UUID NOT NULL REFERENCES cost_nodes(id) ON DELETE CASCADE,
+    allocation_date DATE NOT NULL,
+    dimension TEXT NOT NULL,
+    strategy TEXT NOT NULL,
+    sink_node_id UUID REFERENCES cost_nodes(id) ON DELETE SET NULL,
+    cost_amount NUMERIC(38, 9) NOT NULL,
+    charged_amount NUMERIC(38, 9) NOT NULL,
+    under_recovery NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    over_recovery NUMERIC(38, 9) NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT recovery_results_dimension_not_empty CHECK (length(trim(dimension)) > 0),
+    CONSTRAINT recovery_results_amounts_non_negative CHECK (
+        cost_amount >= 0 AND charged_amount >= 0 AND under_recovery >= 0 AND over_recovery >= 0
+    ),
+    PRIMARY KEY (run_id, parent_id, allocation_date, dimension)
+);
+
+CREATE INDEX idx_recovery_results_allocation_date ON recovery_results_by_dimension(allocation_date);
+CREATE INDEX idx_recovery_results_sink_node ON recovery_results_by_dimension(sink_node_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS node_usage_hourly;
@@ -0,0 +1,31 @@
+-- Hourly usage
+--
+-- Capacity-driven costs are shared by each consumer's contribution to peak load,
+-- which daily usage cannot show. Sources that report timestamped datapoints can
+-- also record usage per hour here; the peak_coincident strategy reads it to
+-- allocate by each child's usage during its parent's busiest hours of the day.
+-- Hours are stored in UTC, truncated to the start of the hour.
+
+CREATE TABLE node_usage_hourly (
+    node_id UUID NOT NULL REFERENCES cost_nodes(id) ON DELETE CASCADE,
+    usage_hour TIMESTAMPTZ NOT NULL,
+    metric TEXT NOT NULL,
+    value NUMERIC(38, 9) NOT NULL,
+    unit TEXT NOT NULL,
+    source TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT node_usage_hourly_metric_not_empty CHECK (length(trim(metric)) > 0),
+    CONSTRAINT node_usage_hourly_unit_not_empty CHECK (length(trim(unit)) > 0),
+    CONSTRAINT node_usage_hourly_value_non_negative CHECK (value >= 0),
+    CONSTRAINT node_usage_hourly_hour_aligned CHECK (
+        usage_hour AT TIME ZONE 'UTC' = date_trunc('hour', usage_hour AT TIME ZONE 'UTC')
+    ),
+    PRIMARY KEY (node_id, usage_hour, metric)
+);
+
+CREATE INDEX idx_node_usage_hourly_usage_hour ON node_usage_hourly(usage_hour);
+CREATE INDEX idx_node_usage_hourly_metric ON node_usage_hourly(metric);
+
+CREATE TRIGGER update_node_usage_hourly_updated_at BEFORE UPDATE ON node_usage_hourly FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
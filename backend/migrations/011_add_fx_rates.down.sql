@@ -0,0 +1,5 @@
+DROP FUNCTION IF EXISTS fx_convert(NUMERIC, TEXT, TEXT, DATE);
+
+ALTER TABLE computation_runs DROP COLUMN IF EXISTS currency;
+
+DROP TABLE IF EXISTS fx_rates;
@@ -0,0 +1,62 @@
+-- Foreign exchange rates and base-currency allocation
+--
+-- Costs arrive in the currency they were billed in. fx_rates holds the rate to
+-- convert one currency into another on each date; the allocation engine converts
+-- every cost into the run's base currency, recorded on the run, before allocating.
+-- fx_convert converts an amount using the latest rate on or before a date, the
+-- inverse of the opposite pair when only that is known, and raises an error when
+-- neither is, so reports never silently drop cost they cannot convert.
+
+CREATE TABLE fx_rates (
+    rate_date DATE NOT NULL,
+    from_currency TEXT NOT NULL,
+    to_currency TEXT NOT NULL,
+    rate NUMERIC(38, 18) NOT NULL,
+    source TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT fx_rates_currency_codes_valid CHECK (
+        from_currency ~ '^[A-Z]{3}$' AND to_currency ~ '^[A-Z]{3}$'
+    ),
+    CONSTRAINT fx_rates_currencies_differ CHECK (from_currency <> to_currency),
+    CONSTRAINT fx_rates_rate_positive CHECK (rate > 0),
+    PRIMARY KEY (from_currency, to_currency, rate_date)
+);
+
+CREATE INDEX idx_fx_rates_rate_date ON fx_rates(rate_date);
+
+CREATE TRIGGER update_fx_rates_updated_at BEFORE UPDATE ON fx_rates FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+ALTER TABLE computation_runs ADD COLUMN currency TEXT NOT NULL DEFAULT 'USD';
+
+CREATE OR REPLACE FUNCTION fx_convert(amount NUMERIC, from_ccy TEXT, to_ccy TEXT, on_date DATE)
+RETURNS NUMERIC AS $$
+DECLARE
+    fx NUMERIC;
+BEGIN
+    IF amount IS NULL OR from_ccy = to_ccy THEN
+        RETURN amount;
+    END IF;
+
+    SELECT rate INTO fx
+    FROM fx_rates
+    WHERE from_currency = from_ccy AND to_currency = to_ccy AND rate_date <= on_date
+    ORDER BY rate_date DESC
+    LIMIT 1;
+    IF fx IS NOT NULL THEN
+        RETURN amount * fx;
+    END IF;
+
+    SELECT rate INTO fx
+    FROM fx_rates
+    WHERE from_currency = to_ccy AND to_currency = from_ccy AND rate_date <= on_date
+    ORDER BY rate_date DESC
+    LIMIT 1;
+    IF fx IS NOT NULL THEN
+        RETURN amount / fx;
+    END IF;
+
+    RAISE EXCEPTION 'no FX rate from % to % on or before %', from_ccy, to_ccy, on_date;
+END;
+$$ LANGUAGE plpgsql STABLE;
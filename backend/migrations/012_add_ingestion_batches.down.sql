@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_node_costs_batch_id;
+
+ALTER TABLE node_costs_by_dimension DROP COLUMN IF EXISTS batch_id;
+
+DROP TABLE IF EXISTS ingestion_batches;
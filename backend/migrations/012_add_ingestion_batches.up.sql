@@ -0,0 +1,35 @@
+-- Ingestion batches
+--
+-- AWS restates the current month's CUR many times. Each import of a billing
+-- period is recorded as a batch, and every cost row records the batch that wrote
+-- it, so importing a restatement deletes the rows of the period's previous batch,
+-- including rows the restatement no longer has, before writing its own. Both
+-- happen in one transaction. A batch's checksum covers the files it was read
+-- from, so importing files already seen does nothing.
+
+CREATE TABLE ingestion_batches (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    source TEXT NOT NULL,
+    billing_period_start DATE NOT NULL,
+    billing_period_end DATE NOT NULL,
+    checksum TEXT NOT NULL,
+    file_count INTEGER NOT NULL DEFAULT 0,
+    records_processed INTEGER NOT NULL DEFAULT 0,
+    records_inserted INTEGER NOT NULL DEFAULT 0,
+    records_skipped INTEGER NOT NULL DEFAULT 0,
+    records_replaced INTEGER NOT NULL DEFAULT 0,
+    superseded_by UUID REFERENCES ingestion_batches(id) ON DELETE SET NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT ingestion_batches_source_not_empty CHECK (length(trim(source)) > 0),
+    CONSTRAINT ingestion_batches_checksum_not_empty CHECK (length(trim(checksum)) > 0),
+    CONSTRAINT ingestion_batches_period_valid CHECK (billing_period_end > billing_period_start)
+);
+
+CREATE INDEX idx_ingestion_batches_period ON ingestion_batches(source, billing_period_start);
+CREATE INDEX idx_ingestion_batches_checksum ON ingestion_batches(source, checksum);
+
+ALTER TABLE node_costs_by_dimension
+    ADD COLUMN batch_id UUID REFERENCES ingestion_batches(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_node_costs_batch_id ON node_costs_by_dimension(batch_id) WHERE batch_id IS NOT NULL;
@@ -0,0 +1,23 @@
+DROP TABLE IF EXISTS node_usage_samples;
+
+DROP INDEX IF EXISTS idx_node_usage_labels;
+
+-- Without labels in the key, each day's label sets are summed into one row
+CREATE TEMPORARY TABLE node_usage_totals AS
+SELECT node_id, usage_date, metric, SUM(value) AS value, MIN(unit) AS unit,
+       MIN(created_at) AS created_at, MAX(updated_at) AS updated_at
+FROM node_usage_by_dimension
+GROUP BY node_id, usage_date, metric;
+
+DELETE FROM node_usage_by_dimension;
+
+ALTER TABLE node_usage_by_dimension DROP CONSTRAINT node_usage_by_dimension_pkey;
+ALTER TABLE node_usage_by_dimension DROP COLUMN IF EXISTS labels;
+ALTER TABLE node_usage_by_dimension DROP COLUMN IF EXISTS source;
+
+INSERT INTO node_usage_by_dimension (node_id, usage_date, metric, value, unit, created_at, updated_at)
+SELECT node_id, usage_date, metric, value, unit, created_at, updated_at FROM node_usage_totals;
+
+ALTER TABLE node_usage_by_dimension ADD PRIMARY KEY (node_id, usage_date, metric);
+
+DROP TABLE node_usage_totals;
@@ -0,0 +1,38 @@
+-- Labelled usage and raw samples
+--
+-- Sources such as Dynatrace report usage per label set (customer, region and so
+-- on), and segment-filtered strategies allocate by those labels. Each label set
+-- is kept as its own row, so labels join the usage key; readers that ignore
+-- labels sum a day's label sets. Usage recorded without labels has an empty
+-- label set.
+--
+-- Sources can also keep their datapoints at the resolution they were reported
+-- in, before they are aggregated per day, for analyses that need more detail.
+
+ALTER TABLE node_usage_by_dimension ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE node_usage_by_dimension ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE node_usage_by_dimension DROP CONSTRAINT node_usage_by_dimension_pkey;
+ALTER TABLE node_usage_by_dimension ADD PRIMARY KEY (node_id, usage_date, metric, labels);
+
+CREATE INDEX idx_node_usage_labels ON node_usage_by_dimension USING GIN (labels);
+
+CREATE TABLE node_usage_samples (
+    node_id UUID NOT NULL REFERENCES cost_nodes(id) ON DELETE CASCADE,
+    sampled_at TIMESTAMPTZ NOT NULL,
+    metric TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}',
+    value NUMERIC(38, 9) NOT NULL,
+    unit TEXT NOT NULL,
+    source TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT node_usage_samples_metric_not_empty CHECK (length(trim(metric)) > 0),
+    CONSTRAINT node_usage_samples_unit_not_empty CHECK (length(trim(unit)) > 0),
+    PRIMARY KEY (node_id, metric, labels, sampled_at)
+);
+
+CREATE INDEX idx_node_usage_samples_sampled_at ON node_usage_samples(sampled_at);
+
+CREATE TRIGGER update_node_usage_samples_updated_at BEFORE UPDATE ON node_usage_samples FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
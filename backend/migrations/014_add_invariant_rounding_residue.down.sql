@@ -0,0 +1,4 @@
+-- Residue is folded back into the unallocated cost it was split from
+UPDATE allocation_invariant_reports SET unallocated_cost = unallocated_cost + rounding_residue;
+
+ALTER TABLE allocation_invariant_reports DROP COLUMN IF EXISTS rounding_residue;
@@ -0,0 +1,9 @@
+-- Rounding residue in allocation invariant reports
+--
+-- When contributions are rounded to the currency's minor unit, the part of each
+-- parent's cost below one minor unit stays on the parent. It is reported apart
+-- from unallocated cost, and may be negative for credits:
+--   direct_cost = final_cost_centre_cost + unallocated_cost + stranded_cost
+--                 + rounding_residue - created_cost + lost_cost
+
+ALTER TABLE allocation_invariant_reports ADD COLUMN IF NOT EXISTS rounding_residue NUMERIC(38, 9) NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE node_usage_by_dimension DROP CONSTRAINT IF EXISTS node_usage_by_dimension_aggregation_valid;
+ALTER TABLE node_usage_by_dimension DROP COLUMN IF EXISTS aggregation;
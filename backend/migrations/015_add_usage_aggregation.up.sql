@@ -0,0 +1,11 @@
+-- Usage aggregation
+--
+-- Each label set's usage is aggregated with its metric's aggregation, and readers
+-- that ignore labels combine a day's label sets the same way: summing them is
+-- only right for metrics that are themselves summed. Usage recorded before the
+-- aggregation was kept was summed.
+
+ALTER TABLE node_usage_by_dimension ADD COLUMN IF NOT EXISTS aggregation TEXT NOT NULL DEFAULT 'sum';
+
+ALTER TABLE node_usage_by_dimension ADD CONSTRAINT node_usage_by_dimension_aggregation_valid
+    CHECK (aggregation IN ('sum', 'avg', 'max', 'p95'));
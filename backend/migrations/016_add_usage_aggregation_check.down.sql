@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS check_node_usage_aggregation ON node_usage_by_dimension;
+DROP FUNCTION IF EXISTS check_node_usage_aggregation();
@@ -0,0 +1,49 @@
+-- Usage aggregation check
+--
+-- Readers that ignore labels combine a day's label sets with their metric's
+-- aggregation, so every label set of a node's metric on one day must share it.
+-- The check is deferred to the end of the transaction so that re-ingesting all
+-- of a metric's label sets can change its aggregation.
+
+DO $$
+DECLARE
+    mixed RECORD;
+BEGIN
+    SELECT node_id, usage_date, metric, string_agg(DISTINCT aggregation, ', ') AS aggregations
+    INTO mixed
+    FROM node_usage_by_dimension
+    GROUP BY node_id, usage_date, metric
+    HAVING COUNT(DISTINCT aggregation) > 1
+    LIMIT 1;
+
+    IF FOUND THEN
+        RAISE EXCEPTION 'usage metric % for node % on % has label sets with different aggregations: %',
+            mixed.metric, mixed.node_id, mixed.usage_date, mixed.aggregations;
+    END IF;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION check_node_usage_aggregation()
+RETURNS TRIGGER AS $$
+DECLARE
+    aggregations TEXT;
+BEGIN
+    SELECT string_agg(DISTINCT aggregation, ', ')
+    INTO aggregations
+    FROM node_usage_by_dimension
+    WHERE node_id = NEW.node_id AND usage_date = NEW.usage_date AND metric = NEW.metric
+    HAVING COUNT(DISTINCT aggregation) > 1;
+
+    IF FOUND THEN
+        RAISE EXCEPTION 'usage metric % for node % on % has label sets with different aggregations: %',
+            NEW.metric, NEW.node_id, NEW.usage_date, aggregations
+            USING ERRCODE = 'check_violation';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER check_node_usage_aggregation
+    AFTER INSERT OR UPDATE ON node_usage_by_dimension
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_node_usage_aggregation();
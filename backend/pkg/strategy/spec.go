@@ -0,0 +1,216 @@
+package strategy
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Name identifies an allocation strategy, such as "proportional_on"
+type Name string
+
+// ParamType is the JSON type an allocation strategy parameter must have
+type ParamType string
+
+const (
+	ParamTypeString  ParamType = "string"
+	ParamTypeNumber  ParamType = "number" // A JSON number or a numeric string
+	ParamTypeInteger ParamType = "integer"
+	ParamTypeBoolean ParamType = "boolean"
+	ParamTypeObject  ParamType = "object"
+	ParamTypeArray   ParamType = "array"
+)
+
+// ParamSpec describes one parameter of an allocation strategy, in the manner of a
+// JSON schema property
+type ParamSpec struct {
+	Type        ParamType            `json:"type"`
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Minimum     *float64             `json:"minimum,omitempty"`
+	Maximum     *float64             `json:"maximum,omitempty"`
+	Enum        []string             `json:"enum,omitempty"`
+	Properties  map[string]ParamSpec `json:"properties,omitempty"` // Nested parameters of an object
+}
+
+// Spec describes an allocation strategy and the parameters it accepts
+type Spec struct {
+	Name        Name                 `json:"name"`
+	Description string               `json:"description"`
+	Parameters  map[string]ParamSpec `json:"parameters"`
+	// Validate checks what the parameter specs cannot express, such as parsing a
+	// formula; it runs after the parameters pass their specs
+	Validate func(params map[string]interface{}) error `json:"-"`
+}
+
+// ValidateParameters checks a strategy's parameters against its spec: required
+// parameters must be present and every known parameter must have the right type
+// and lie within its bounds. Unknown parameters are not an error; see
+// UnknownParameters.
+func (s Spec) ValidateParameters(params map[string]interface{}) error {
+	err := validateParams(params, s.Parameters, "")
+	if err == nil && s.Validate != nil {
+		err = s.Validate(params)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid parameters for %s strategy: %w", s.Name, err)
+	}
+	return nil
+}
+
+// UnknownParameters returns the top-level parameters the spec does not describe,
+// which are usually misspelt, in sorted order
+func (s Spec) UnknownParameters(params map[string]interface{}) []string {
+	var unknown []string
+	for name := range params {
+		if _, ok := s.Parameters[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func validateParams(params map[string]interface{}, specs map[string]ParamSpec, prefix string) error {
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		spec := specs[name]
+		value, ok := params[name]
+		if !ok || value == nil {
+			if spec.Required {
+				return fmt.Errorf("'%s%s' is required", prefix, name)
+			}
+			continue
+		}
+		if err := spec.validate(prefix+name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p ParamSpec) validate(name string, value interface{}) error {
+	switch p.Type {
+	case ParamTypeString:
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("'%s' must be a string, got %T", name, value)
+		}
+		if len(p.Enum) > 0 && !containsValue(p.Enum, str) {
+			return fmt.Errorf("'%s' must be one of %s, got %q", name, strings.Join(p.Enum, ", "), str)
+		}
+	case ParamTypeNumber, ParamTypeInteger:
+		number, ok := Number(value)
+		if !ok {
+			return fmt.Errorf("'%s' must be a %s, got %v", name, p.Type, value)
+		}
+		if p.Type == ParamTypeInteger && number != math.Trunc(number) {
+			return fmt.Errorf("'%s' must be an integer, got %v", name, value)
+		}
+		if p.Minimum != nil && number < *p.Minimum {
+			return fmt.Errorf("'%s' must be at least %v, got %v", name, *p.Minimum, value)
+		}
+		if p.Maximum != nil && number > *p.Maximum {
+			return fmt.Errorf("'%s' must be at most %v, got %v", name, *p.Maximum, value)
+		}
+	case ParamTypeBoolean:
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("'%s' must be a boolean, got %T", name, value)
+		}
+	case ParamTypeObject:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("'%s' must be an object, got %T", name, value)
+		}
+		return validateParams(object, p.Properties, name+".")
+	case ParamTypeArray:
+		if _, ok := value.([]interface{}); !ok {
+			return fmt.Errorf("'%s' must be an array, got %T", name, value)
+		}
+	}
+	return nil
+}
+
+// Number reads a parameter number the way the strategies do: a JSON number, a Go
+// integer, or a numeric string
+func Number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		d, err := decimal.NewFromString(v)
+		if err != nil {
+			return 0, false
+		}
+		f, _ := d.Float64()
+		return f, true
+	}
+	return 0, false
+}
+
+func containsValue(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// specs holds every registered allocation strategy, keyed by name
+var specs = struct {
+	sync.RWMutex
+	specs map[Name]Spec
+}{specs: make(map[Name]Spec)}
+
+// RegisterSpec adds a strategy to the set of strategies edges may use. Names
+// must be unique; the built-in strategies are registered by the models package.
+func RegisterSpec(spec Spec) error {
+	if strings.TrimSpace(string(spec.Name)) == "" {
+		return fmt.Errorf("strategy name is required")
+	}
+
+	specs.Lock()
+	defer specs.Unlock()
+
+	if _, exists := specs.specs[spec.Name]; exists {
+		return fmt.Errorf("strategy %s is already registered", spec.Name)
+	}
+	specs.specs[spec.Name] = spec
+	return nil
+}
+
+// LookupSpec returns the spec of a registered strategy
+func LookupSpec(name string) (Spec, bool) {
+	specs.RLock()
+	defer specs.RUnlock()
+
+	spec, ok := specs.specs[Name(name)]
+	return spec, ok
+}
+
+// Specs returns every registered strategy, ordered by name
+func Specs() []Spec {
+	specs.RLock()
+	defer specs.RUnlock()
+
+	all := make([]Spec, 0, len(specs.specs))
+	for _, spec := range specs.specs {
+		all = append(all, spec)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
@@ -0,0 +1,134 @@
+// Package strategy defines how allocation strategies are described, registered
+// and given the day's data, so strategies can be written outside the allocation
+// engine. A strategy is a Func that splits a parent's cost between its children
+// and a Spec of the parameters it accepts, added with Register from an init
+// function before any allocation runs.
+package strategy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// Edge is a dependency edge active on the snapshot date
+type Edge struct {
+	ID       uuid.UUID
+	ParentID uuid.UUID
+	ChildID  uuid.UUID
+}
+
+// Usage is a node's usage of a metric on one day, for one label set
+type Usage struct {
+	NodeID uuid.UUID
+	Date   time.Time
+	Metric string
+	Value  decimal.Decimal
+	Unit   string
+	Labels map[string]string
+}
+
+// Snapshot is the day of data a strategy reads, loaded before the allocation runs
+type Snapshot interface {
+	// Date returns the date the snapshot was taken for
+	Date() time.Time
+	// ChildEdges returns the edges from a parent to its children, ordered by child ID
+	ChildEdges(parentID uuid.UUID) []Edge
+	// ParentEdges returns the edges into a child from its parents, ordered by parent ID
+	ParentEdges(childID uuid.UUID) []Edge
+	// UsageOn returns a node's usage of a metric on the snapshot date
+	UsageOn(nodeID uuid.UUID, metric string) decimal.Decimal
+	// UsageBetween returns a node's usage of a metric within an inclusive date
+	// range; only the look-back window the strategy declares is loaded
+	UsageBetween(nodeID uuid.UUID, metric string, startDate, endDate time.Time) []Usage
+	// LabelledUsageOn returns a node's usage of a metric on the snapshot date per
+	// label set; only loaded for strategies that declare LabelledUsage
+	LabelledUsageOn(nodeID uuid.UUID, metric string) []Usage
+	// HourlyUsageOn returns a node's usage of a metric in each hour (UTC) of the
+	// snapshot date, and whether it has any hourly usage of the metric at all;
+	// only loaded for strategies that declare HourlyUsage
+	HourlyUsageOn(nodeID uuid.UUID, metric string) ([24]decimal.Decimal, bool)
+	// NodeLabel returns the value of one of a node's cost labels as a string
+	NodeLabel(nodeID uuid.UUID, key string) (string, bool)
+	// ParentCost returns a parent's holistic cost for a dimension
+	ParentCost(parentID uuid.UUID, dimension string) decimal.Decimal
+}
+
+// Func calculates the share of every child of a parent under a strategy, given
+// the strategy's parameters and the day snapshot. The returned map is keyed by
+// child ID; children absent from it receive nothing.
+type Func func(params map[string]interface{}, snap Snapshot, parentID uuid.UUID, dimension string) (map[uuid.UUID]decimal.Decimal, error)
+
+// Definition is everything the engine needs to run a strategy
+type Definition struct {
+	// Spec names the strategy and describes its parameters; they are validated
+	// against it before Func is called
+	Spec Spec
+	Func Func
+	// LookbackDays returns how many days of usage, ending on the allocation date,
+	// the strategy reads; nil means only the allocation date
+	LookbackDays func(params map[string]interface{}) int
+	// LabelledUsage is set when the strategy reads usage labels
+	LabelledUsage bool
+	// HourlyUsage is set when the strategy reads hourly usage of its "metric"
+	// parameter on the allocation date
+	HourlyUsage bool
+	// Metrics returns the usage metrics the strategy reads other than its
+	// "metric" parameter; nil means it reads no others
+	Metrics func(params map[string]interface{}) []string
+	// NodeLabels is set when the strategy reads the children's cost labels
+	NodeLabels bool
+}
+
+// registry holds the definition of every strategy the engine can run, keyed by name
+var registry = struct {
+	sync.RWMutex
+	definitions map[Name]Definition
+}{definitions: make(map[Name]Definition)}
+
+// Register adds a strategy to the engine and to the set of strategies the graph
+// validator accepts. Call it from an init function before any allocation runs.
+func Register(def Definition) error {
+	if def.Func == nil {
+		return fmt.Errorf("strategy %s has no function", def.Spec.Name)
+	}
+	if err := RegisterSpec(def.Spec); err != nil {
+		return fmt.Errorf("failed to register strategy: %w", err)
+	}
+
+	registry.Lock()
+	defer registry.Unlock()
+	registry.definitions[def.Spec.Name] = def
+	return nil
+}
+
+// Implement attaches a function to a strategy whose spec is already registered,
+// as the engine does for the built-in strategies. The registered spec replaces
+// def.Spec.
+func Implement(def Definition) error {
+	if def.Func == nil {
+		return fmt.Errorf("strategy %s has no function", def.Spec.Name)
+	}
+	spec, ok := LookupSpec(string(def.Spec.Name))
+	if !ok {
+		return fmt.Errorf("strategy %s has no spec", def.Spec.Name)
+	}
+	def.Spec = spec
+
+	registry.Lock()
+	defer registry.Unlock()
+	registry.definitions[spec.Name] = def
+	return nil
+}
+
+// Lookup returns the definition of a registered strategy
+func Lookup(name Name) (Definition, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	def, ok := registry.definitions[name]
+	return def, ok
+}